@@ -71,6 +71,39 @@ extern void __wasm_import_wadu436_usb_device_method_usb_device_read_isochronous(
 __attribute__((__import_module__("wadu436:usb/device@0.0.1"), __import_name__("[method]usb-device.write-isochronous")))
 extern int64_t __wasm_import_wadu436_usb_device_method_usb_device_write_isochronous(int32_t, int32_t, uint8_t *, size_t);
 
+__attribute__((__import_module__("wadu436:usb/device@0.0.1"), __import_name__("[method]usb-device.bus-number")))
+extern int32_t __wasm_import_wadu436_usb_device_method_usb_device_bus_number(int32_t);
+
+__attribute__((__import_module__("wadu436:usb/device@0.0.1"), __import_name__("[method]usb-device.port-numbers")))
+extern void __wasm_import_wadu436_usb_device_method_usb_device_port_numbers(int32_t, uint8_t *);
+
+__attribute__((__import_module__("wadu436:usb/device@0.0.1"), __import_name__("[method]usb-device.suspend")))
+extern void __wasm_import_wadu436_usb_device_method_usb_device_suspend(int32_t);
+
+__attribute__((__import_module__("wadu436:usb/device@0.0.1"), __import_name__("[method]usb-device.resume")))
+extern void __wasm_import_wadu436_usb_device_method_usb_device_resume(int32_t);
+
+__attribute__((__import_module__("wadu436:usb/device@0.0.1"), __import_name__("[method]usb-device.suspended")))
+extern int32_t __wasm_import_wadu436_usb_device_method_usb_device_suspended(int32_t);
+
+__attribute__((__import_module__("wadu436:usb/device@0.0.1"), __import_name__("[method]usb-device.link-power-state-supported")))
+extern int32_t __wasm_import_wadu436_usb_device_method_usb_device_link_power_state_supported(int32_t, int32_t);
+
+__attribute__((__import_module__("wadu436:usb/device@0.0.1"), __import_name__("[method]usb-device.link-power-state-enabled")))
+extern int32_t __wasm_import_wadu436_usb_device_method_usb_device_link_power_state_enabled(int32_t, int32_t);
+
+__attribute__((__import_module__("wadu436:usb/device@0.0.1"), __import_name__("[method]usb-device.set-link-power-state-enabled")))
+extern void __wasm_import_wadu436_usb_device_method_usb_device_set_link_power_state_enabled(int32_t, int32_t, int32_t);
+
+__attribute__((__import_module__("wadu436:usb/device@0.0.1"), __import_name__("[method]usb-device.alloc-streams")))
+extern int32_t __wasm_import_wadu436_usb_device_method_usb_device_alloc_streams(int32_t, int32_t, uint8_t *, size_t);
+
+__attribute__((__import_module__("wadu436:usb/device@0.0.1"), __import_name__("[method]usb-device.free-streams")))
+extern void __wasm_import_wadu436_usb_device_method_usb_device_free_streams(int32_t, uint8_t *, size_t);
+
+__attribute__((__import_module__("wadu436:usb/device@0.0.1"), __import_name__("[method]usb-device.transfer-bulk-streams")))
+extern void __wasm_import_wadu436_usb_device_method_usb_device_transfer_bulk_streams(int32_t, uint8_t *, size_t, uint8_t *);
+
 __attribute__((__import_module__("wadu436:usb/device@0.0.1"), __import_name__("[method]usb-configuration.descriptor")))
 extern void __wasm_import_wadu436_usb_device_method_usb_configuration_descriptor(int32_t, uint8_t *);
 
@@ -124,6 +157,10 @@ void wadu436_usb_types_filter_free(wadu436_usb_types_filter_t *ptr) {
   bindings_option_string_free(&ptr->serial_number);
 }
 
+void wadu436_usb_types_stream_transfer_result_free(wadu436_usb_types_stream_transfer_result_t *ptr) {
+  bindings_list_u8_free(&ptr->data);
+}
+
 void wadu436_usb_descriptors_device_descriptor_free(wadu436_usb_descriptors_device_descriptor_t *ptr) {
   bindings_option_string_free(&ptr->product_name);
   bindings_option_string_free(&ptr->manufacturer_name);
@@ -154,6 +191,14 @@ void wadu436_usb_device_filter_free(wadu436_usb_device_filter_t *ptr) {
   wadu436_usb_types_filter_free(ptr);
 }
 
+void wadu436_usb_device_stream_transfer_result_free(wadu436_usb_device_stream_transfer_result_t *ptr) {
+  wadu436_usb_types_stream_transfer_result_free(ptr);
+}
+
+void wadu436_usb_device_stream_transfer_free(wadu436_usb_device_stream_transfer_t *ptr) {
+  bindings_list_u8_free(&ptr->data);
+}
+
 __attribute__((__import_module__("wadu436:usb/device@0.0.1"), __import_name__("[resource-drop]usb-device")))
 extern void __wasm_import_wadu436_usb_device_usb_device_drop(int32_t handle);
 
@@ -253,6 +298,38 @@ void wadu436_usb_device_list_own_usb_endpoint_free(wadu436_usb_device_list_own_u
   }
 }
 
+void wadu436_usb_device_list_borrow_usb_endpoint_free(wadu436_usb_device_list_borrow_usb_endpoint_t *ptr) {
+  size_t list_len = ptr->len;
+  if (list_len > 0) {
+    wadu436_usb_device_borrow_usb_endpoint_t *list_ptr = ptr->ptr;
+    for (size_t i = 0; i < list_len; i++) {
+    }
+    free(list_ptr);
+  }
+}
+
+void wadu436_usb_device_list_stream_transfer_free(wadu436_usb_device_list_stream_transfer_t *ptr) {
+  size_t list_len = ptr->len;
+  if (list_len > 0) {
+    wadu436_usb_device_stream_transfer_t *list_ptr = ptr->ptr;
+    for (size_t i = 0; i < list_len; i++) {
+      wadu436_usb_device_stream_transfer_free(&list_ptr[i]);
+    }
+    free(list_ptr);
+  }
+}
+
+void wadu436_usb_device_list_stream_transfer_result_free(wadu436_usb_device_list_stream_transfer_result_t *ptr) {
+  size_t list_len = ptr->len;
+  if (list_len > 0) {
+    wadu436_usb_device_stream_transfer_result_t *list_ptr = ptr->ptr;
+    for (size_t i = 0; i < list_len; i++) {
+      wadu436_usb_device_stream_transfer_result_free(&list_ptr[i]);
+    }
+    free(list_ptr);
+  }
+}
+
 void bindings_string_set(bindings_string_t *ret, const char*s) {
   ret->ptr = (uint8_t*) s;
   ret->len = strlen(s);
@@ -532,6 +609,63 @@ uint64_t wadu436_usb_device_method_usb_device_write_isochronous(wadu436_usb_devi
   return (uint64_t) (ret);
 }
 
+uint8_t wadu436_usb_device_method_usb_device_bus_number(wadu436_usb_device_borrow_usb_device_t self) {
+  int32_t ret = __wasm_import_wadu436_usb_device_method_usb_device_bus_number((self).__handle);
+  return (uint8_t) (ret);
+}
+
+void wadu436_usb_device_method_usb_device_port_numbers(wadu436_usb_device_borrow_usb_device_t self, bindings_list_u8_t *ret) {
+  __attribute__((__aligned__(4)))
+  uint8_t ret_area[8];
+  uint8_t *ptr = (uint8_t *) &ret_area;
+  __wasm_import_wadu436_usb_device_method_usb_device_port_numbers((self).__handle, ptr);
+  *ret = (bindings_list_u8_t) { (uint8_t*)(*((uint8_t **) (ptr + 0))), (*((size_t*) (ptr + 4))) };
+}
+
+void wadu436_usb_device_method_usb_device_suspend(wadu436_usb_device_borrow_usb_device_t self) {
+  __wasm_import_wadu436_usb_device_method_usb_device_suspend((self).__handle);
+}
+
+void wadu436_usb_device_method_usb_device_resume(wadu436_usb_device_borrow_usb_device_t self) {
+  __wasm_import_wadu436_usb_device_method_usb_device_resume((self).__handle);
+}
+
+bool wadu436_usb_device_method_usb_device_suspended(wadu436_usb_device_borrow_usb_device_t self) {
+  int32_t ret = __wasm_import_wadu436_usb_device_method_usb_device_suspended((self).__handle);
+  return ret;
+}
+
+bool wadu436_usb_device_method_usb_device_link_power_state_supported(wadu436_usb_device_borrow_usb_device_t self, wadu436_usb_device_link_power_state_t state) {
+  int32_t ret = __wasm_import_wadu436_usb_device_method_usb_device_link_power_state_supported((self).__handle, (int32_t) state);
+  return ret;
+}
+
+bool wadu436_usb_device_method_usb_device_link_power_state_enabled(wadu436_usb_device_borrow_usb_device_t self, wadu436_usb_device_link_power_state_t state) {
+  int32_t ret = __wasm_import_wadu436_usb_device_method_usb_device_link_power_state_enabled((self).__handle, (int32_t) state);
+  return ret;
+}
+
+void wadu436_usb_device_method_usb_device_set_link_power_state_enabled(wadu436_usb_device_borrow_usb_device_t self, wadu436_usb_device_link_power_state_t state, bool enabled) {
+  __wasm_import_wadu436_usb_device_method_usb_device_set_link_power_state_enabled((self).__handle, (int32_t) state, enabled);
+}
+
+uint32_t wadu436_usb_device_method_usb_device_alloc_streams(wadu436_usb_device_borrow_usb_device_t self, uint32_t num_streams, wadu436_usb_device_list_borrow_usb_endpoint_t *endpoints) {
+  int32_t ret = __wasm_import_wadu436_usb_device_method_usb_device_alloc_streams((self).__handle, (int32_t) (num_streams), (uint8_t *) (*endpoints).ptr, (*endpoints).len);
+  return (uint32_t) (ret);
+}
+
+void wadu436_usb_device_method_usb_device_free_streams(wadu436_usb_device_borrow_usb_device_t self, wadu436_usb_device_list_borrow_usb_endpoint_t *endpoints) {
+  __wasm_import_wadu436_usb_device_method_usb_device_free_streams((self).__handle, (uint8_t *) (*endpoints).ptr, (*endpoints).len);
+}
+
+void wadu436_usb_device_method_usb_device_transfer_bulk_streams(wadu436_usb_device_borrow_usb_device_t self, wadu436_usb_device_list_stream_transfer_t *transfers, wadu436_usb_device_list_stream_transfer_result_t *ret) {
+  __attribute__((__aligned__(4)))
+  uint8_t ret_area[8];
+  uint8_t *ptr = (uint8_t *) &ret_area;
+  __wasm_import_wadu436_usb_device_method_usb_device_transfer_bulk_streams((self).__handle, (uint8_t *) (*transfers).ptr, (*transfers).len, ptr);
+  *ret = (wadu436_usb_device_list_stream_transfer_result_t) { (wadu436_usb_device_stream_transfer_result_t*)(*((uint8_t **) (ptr + 0))), (*((size_t*) (ptr + 4))) };
+}
+
 void wadu436_usb_device_method_usb_configuration_descriptor(wadu436_usb_device_borrow_usb_configuration_t self, wadu436_usb_device_configuration_descriptor_t *ret) {
   __attribute__((__aligned__(4)))
   uint8_t ret_area[20];
@@ -120,6 +120,34 @@ func Wadu436Usb0_0_1_TypesSpeedSuperplus() Wadu436Usb0_0_1_TypesSpeed{
   return Wadu436Usb0_0_1_TypesSpeed{kind: Wadu436Usb0_0_1_TypesSpeedKindSuperplus}
 }
 
+type Wadu436Usb0_0_1_TypesLinkPowerStateKind int
+
+const (
+Wadu436Usb0_0_1_TypesLinkPowerStateKindUsb2Lpm Wadu436Usb0_0_1_TypesLinkPowerStateKind = iota
+Wadu436Usb0_0_1_TypesLinkPowerStateKindU1
+Wadu436Usb0_0_1_TypesLinkPowerStateKindU2
+)
+
+type Wadu436Usb0_0_1_TypesLinkPowerState struct {
+  kind Wadu436Usb0_0_1_TypesLinkPowerStateKind
+}
+
+func (n Wadu436Usb0_0_1_TypesLinkPowerState) Kind() Wadu436Usb0_0_1_TypesLinkPowerStateKind {
+  return n.kind
+}
+
+func Wadu436Usb0_0_1_TypesLinkPowerStateUsb2Lpm() Wadu436Usb0_0_1_TypesLinkPowerState{
+  return Wadu436Usb0_0_1_TypesLinkPowerState{kind: Wadu436Usb0_0_1_TypesLinkPowerStateKindUsb2Lpm}
+}
+
+func Wadu436Usb0_0_1_TypesLinkPowerStateU1() Wadu436Usb0_0_1_TypesLinkPowerState{
+  return Wadu436Usb0_0_1_TypesLinkPowerState{kind: Wadu436Usb0_0_1_TypesLinkPowerStateKindU1}
+}
+
+func Wadu436Usb0_0_1_TypesLinkPowerStateU2() Wadu436Usb0_0_1_TypesLinkPowerState{
+  return Wadu436Usb0_0_1_TypesLinkPowerState{kind: Wadu436Usb0_0_1_TypesLinkPowerStateKindU2}
+}
+
 type Wadu436Usb0_0_1_TypesControlSetupTypeKind int
 
 const (
@@ -184,6 +212,40 @@ type Wadu436Usb0_0_1_TypesControlSetup struct {
   Index uint16
 }
 
+type Wadu436Usb0_0_1_TypesStreamTransferStatusKind int
+
+const (
+Wadu436Usb0_0_1_TypesStreamTransferStatusKindCompleted Wadu436Usb0_0_1_TypesStreamTransferStatusKind = iota
+Wadu436Usb0_0_1_TypesStreamTransferStatusKindCancelled
+Wadu436Usb0_0_1_TypesStreamTransferStatusKindStalled
+)
+
+type Wadu436Usb0_0_1_TypesStreamTransferStatus struct {
+  kind Wadu436Usb0_0_1_TypesStreamTransferStatusKind
+}
+
+func (n Wadu436Usb0_0_1_TypesStreamTransferStatus) Kind() Wadu436Usb0_0_1_TypesStreamTransferStatusKind {
+  return n.kind
+}
+
+func Wadu436Usb0_0_1_TypesStreamTransferStatusCompleted() Wadu436Usb0_0_1_TypesStreamTransferStatus{
+  return Wadu436Usb0_0_1_TypesStreamTransferStatus{kind: Wadu436Usb0_0_1_TypesStreamTransferStatusKindCompleted}
+}
+
+func Wadu436Usb0_0_1_TypesStreamTransferStatusCancelled() Wadu436Usb0_0_1_TypesStreamTransferStatus{
+  return Wadu436Usb0_0_1_TypesStreamTransferStatus{kind: Wadu436Usb0_0_1_TypesStreamTransferStatusKindCancelled}
+}
+
+func Wadu436Usb0_0_1_TypesStreamTransferStatusStalled() Wadu436Usb0_0_1_TypesStreamTransferStatus{
+  return Wadu436Usb0_0_1_TypesStreamTransferStatus{kind: Wadu436Usb0_0_1_TypesStreamTransferStatusKindStalled}
+}
+
+type Wadu436Usb0_0_1_TypesStreamTransferResult struct {
+  Status Wadu436Usb0_0_1_TypesStreamTransferStatus
+  Data []uint8
+  Actual uint64
+}
+
 // Import functions from wadu436:usb/descriptors@0.0.1
 type Wadu436Usb0_0_1_DescriptorsVersion = Wadu436Usb0_0_1_TypesVersion
 type Wadu436Usb0_0_1_DescriptorsDirection = Wadu436Usb0_0_1_TypesDirection
@@ -237,6 +299,9 @@ type Wadu436Usb0_0_1_DeviceFilter = Wadu436Usb0_0_1_TypesFilter
 type Wadu436Usb0_0_1_DeviceControlSetupType = Wadu436Usb0_0_1_TypesControlSetupType
 type Wadu436Usb0_0_1_DeviceControlSetupRecipient = Wadu436Usb0_0_1_TypesControlSetupRecipient
 type Wadu436Usb0_0_1_DeviceControlSetup = Wadu436Usb0_0_1_TypesControlSetup
+type Wadu436Usb0_0_1_DeviceLinkPowerState = Wadu436Usb0_0_1_TypesLinkPowerState
+type Wadu436Usb0_0_1_DeviceStreamTransferStatus = Wadu436Usb0_0_1_TypesStreamTransferStatus
+type Wadu436Usb0_0_1_DeviceStreamTransferResult = Wadu436Usb0_0_1_TypesStreamTransferResult
 // Wadu436Usb0_0_1_DeviceUsbDevice is a handle to imported resource usb-device
 type Wadu436Usb0_0_1_DeviceUsbDevice int32
 
@@ -277,6 +342,13 @@ func (self Wadu436Usb0_0_1_DeviceUsbEndpoint) Drop() {
   _Wadu436Usb0_0_1_DeviceUsbEndpoint_drop(self)
 }
 
+type Wadu436Usb0_0_1_DeviceStreamTransfer struct {
+  Endpoint Wadu436Usb0_0_1_DeviceUsbEndpoint
+  StreamId uint32
+  Data []uint8
+  Length uint64
+}
+
 func StaticUsbDeviceEnumerate() []Wadu436Usb0_0_1_DeviceUsbDevice {
   var ret C.wadu436_usb_device_list_own_usb_device_t
   C.wadu436_usb_device_static_usb_device_enumerate(&ret )
@@ -812,6 +884,242 @@ func (self Wadu436Usb0_0_1_DeviceUsbDevice) WriteIsochronous(endpoint Wadu436Usb
   return lift_ret
 }
 
+func (self Wadu436Usb0_0_1_DeviceUsbDevice) BusNumber() uint8 {
+  var lower_self C.wadu436_usb_device_borrow_usb_device_t
+  lower_self.__handle = C.int32_t(self)
+  ret := C.wadu436_usb_device_method_usb_device_bus_number(lower_self )
+  var lift_ret uint8
+  lift_ret = uint8(ret)
+  return lift_ret
+}
+
+func (self Wadu436Usb0_0_1_DeviceUsbDevice) PortNumbers() []uint8 {
+  var lower_self C.wadu436_usb_device_borrow_usb_device_t
+  lower_self.__handle = C.int32_t(self)
+  var ret C.bindings_list_u8_t
+  C.wadu436_usb_device_method_usb_device_port_numbers(lower_self , &ret )
+  var lift_ret []uint8
+  lift_ret = make([]uint8, ret.len)
+  if ret.len > 0 {
+    for lift_ret_i := 0; lift_ret_i < int(ret.len); lift_ret_i++ {
+      var empty_lift_ret C.uint8_t
+      lift_ret_ptr := *(*C.uint8_t)(unsafe.Pointer(uintptr(unsafe.Pointer(ret.ptr)) +
+      uintptr(lift_ret_i)*unsafe.Sizeof(empty_lift_ret)))
+      var list_lift_ret uint8
+      list_lift_ret = uint8(lift_ret_ptr)
+      lift_ret[lift_ret_i] = list_lift_ret
+    }
+  }
+  return lift_ret
+}
+
+func (self Wadu436Usb0_0_1_DeviceUsbDevice) Suspend() {
+  var lower_self C.wadu436_usb_device_borrow_usb_device_t
+  lower_self.__handle = C.int32_t(self)
+  C.wadu436_usb_device_method_usb_device_suspend(lower_self )
+}
+
+func (self Wadu436Usb0_0_1_DeviceUsbDevice) Resume() {
+  var lower_self C.wadu436_usb_device_borrow_usb_device_t
+  lower_self.__handle = C.int32_t(self)
+  C.wadu436_usb_device_method_usb_device_resume(lower_self )
+}
+
+func (self Wadu436Usb0_0_1_DeviceUsbDevice) Suspended() bool {
+  var lower_self C.wadu436_usb_device_borrow_usb_device_t
+  lower_self.__handle = C.int32_t(self)
+  ret := C.wadu436_usb_device_method_usb_device_suspended(lower_self )
+  lift_ret := ret
+  return lift_ret
+}
+
+func (self Wadu436Usb0_0_1_DeviceUsbDevice) LinkPowerStateSupported(state Wadu436Usb0_0_1_DeviceLinkPowerState) bool {
+  var lower_self C.wadu436_usb_device_borrow_usb_device_t
+  lower_self.__handle = C.int32_t(self)
+  var lower_state C.wadu436_usb_types_link_power_state_t
+  if state.Kind() == Wadu436Usb0_0_1_TypesLinkPowerStateKindUsb2Lpm {
+    lower_state = 0
+  }
+  if state.Kind() == Wadu436Usb0_0_1_TypesLinkPowerStateKindU1 {
+    lower_state = 1
+  }
+  if state.Kind() == Wadu436Usb0_0_1_TypesLinkPowerStateKindU2 {
+    lower_state = 2
+  }
+  ret := C.wadu436_usb_device_method_usb_device_link_power_state_supported(lower_self , lower_state )
+  lift_ret := ret
+  return lift_ret
+}
+
+func (self Wadu436Usb0_0_1_DeviceUsbDevice) LinkPowerStateEnabled(state Wadu436Usb0_0_1_DeviceLinkPowerState) bool {
+  var lower_self C.wadu436_usb_device_borrow_usb_device_t
+  lower_self.__handle = C.int32_t(self)
+  var lower_state C.wadu436_usb_types_link_power_state_t
+  if state.Kind() == Wadu436Usb0_0_1_TypesLinkPowerStateKindUsb2Lpm {
+    lower_state = 0
+  }
+  if state.Kind() == Wadu436Usb0_0_1_TypesLinkPowerStateKindU1 {
+    lower_state = 1
+  }
+  if state.Kind() == Wadu436Usb0_0_1_TypesLinkPowerStateKindU2 {
+    lower_state = 2
+  }
+  ret := C.wadu436_usb_device_method_usb_device_link_power_state_enabled(lower_self , lower_state )
+  lift_ret := ret
+  return lift_ret
+}
+
+func (self Wadu436Usb0_0_1_DeviceUsbDevice) SetLinkPowerStateEnabled(state Wadu436Usb0_0_1_DeviceLinkPowerState, enabled bool) {
+  var lower_self C.wadu436_usb_device_borrow_usb_device_t
+  lower_self.__handle = C.int32_t(self)
+  var lower_state C.wadu436_usb_types_link_power_state_t
+  if state.Kind() == Wadu436Usb0_0_1_TypesLinkPowerStateKindUsb2Lpm {
+    lower_state = 0
+  }
+  if state.Kind() == Wadu436Usb0_0_1_TypesLinkPowerStateKindU1 {
+    lower_state = 1
+  }
+  if state.Kind() == Wadu436Usb0_0_1_TypesLinkPowerStateKindU2 {
+    lower_state = 2
+  }
+  lower_enabled := enabled
+  C.wadu436_usb_device_method_usb_device_set_link_power_state_enabled(lower_self , lower_state , lower_enabled )
+}
+
+func (self Wadu436Usb0_0_1_DeviceUsbDevice) AllocStreams(num_streams uint32, endpoints []Wadu436Usb0_0_1_DeviceUsbEndpoint) uint32 {
+  var lower_self C.wadu436_usb_device_borrow_usb_device_t
+  lower_self.__handle = C.int32_t(self)
+  lower_num_streams := C.uint32_t(num_streams)
+  var lower_endpoints C.wadu436_usb_device_list_borrow_usb_endpoint_t
+  if len(endpoints) == 0 {
+    lower_endpoints.ptr = nil
+    lower_endpoints.len = 0
+  } else {
+    var empty_lower_endpoints C.wadu436_usb_device_borrow_usb_endpoint_t
+    lower_endpoints.ptr = (*C.wadu436_usb_device_borrow_usb_endpoint_t)(C.malloc(C.size_t(len(endpoints)) * C.size_t(unsafe.Sizeof(empty_lower_endpoints))))
+    lower_endpoints.len = C.size_t(len(endpoints))
+    for lower_endpoints_i := range endpoints {
+      lower_endpoints_ptr := (*C.wadu436_usb_device_borrow_usb_endpoint_t)(unsafe.Pointer(uintptr(unsafe.Pointer(lower_endpoints.ptr)) +
+      uintptr(lower_endpoints_i)*unsafe.Sizeof(empty_lower_endpoints)))
+      var lower_endpoints_ptr_value C.wadu436_usb_device_borrow_usb_endpoint_t
+      lower_endpoints_ptr_value.__handle = C.int32_t(endpoints[lower_endpoints_i])
+      *lower_endpoints_ptr = lower_endpoints_ptr_value
+    }
+  }
+  ret := C.wadu436_usb_device_method_usb_device_alloc_streams(lower_self , lower_num_streams , &lower_endpoints )
+  var lift_ret uint32
+  lift_ret = uint32(ret)
+  return lift_ret
+}
+
+func (self Wadu436Usb0_0_1_DeviceUsbDevice) FreeStreams(endpoints []Wadu436Usb0_0_1_DeviceUsbEndpoint) {
+  var lower_self C.wadu436_usb_device_borrow_usb_device_t
+  lower_self.__handle = C.int32_t(self)
+  var lower_endpoints C.wadu436_usb_device_list_borrow_usb_endpoint_t
+  if len(endpoints) == 0 {
+    lower_endpoints.ptr = nil
+    lower_endpoints.len = 0
+  } else {
+    var empty_lower_endpoints C.wadu436_usb_device_borrow_usb_endpoint_t
+    lower_endpoints.ptr = (*C.wadu436_usb_device_borrow_usb_endpoint_t)(C.malloc(C.size_t(len(endpoints)) * C.size_t(unsafe.Sizeof(empty_lower_endpoints))))
+    lower_endpoints.len = C.size_t(len(endpoints))
+    for lower_endpoints_i := range endpoints {
+      lower_endpoints_ptr := (*C.wadu436_usb_device_borrow_usb_endpoint_t)(unsafe.Pointer(uintptr(unsafe.Pointer(lower_endpoints.ptr)) +
+      uintptr(lower_endpoints_i)*unsafe.Sizeof(empty_lower_endpoints)))
+      var lower_endpoints_ptr_value C.wadu436_usb_device_borrow_usb_endpoint_t
+      lower_endpoints_ptr_value.__handle = C.int32_t(endpoints[lower_endpoints_i])
+      *lower_endpoints_ptr = lower_endpoints_ptr_value
+    }
+  }
+  C.wadu436_usb_device_method_usb_device_free_streams(lower_self , &lower_endpoints )
+}
+
+func (self Wadu436Usb0_0_1_DeviceUsbDevice) TransferBulkStreams(transfers []Wadu436Usb0_0_1_DeviceStreamTransfer) []Wadu436Usb0_0_1_DeviceStreamTransferResult {
+  var lower_self C.wadu436_usb_device_borrow_usb_device_t
+  lower_self.__handle = C.int32_t(self)
+  var lower_transfers C.wadu436_usb_device_list_stream_transfer_t
+  if len(transfers) == 0 {
+    lower_transfers.ptr = nil
+    lower_transfers.len = 0
+  } else {
+    var empty_lower_transfers C.wadu436_usb_device_stream_transfer_t
+    lower_transfers.ptr = (*C.wadu436_usb_device_stream_transfer_t)(C.malloc(C.size_t(len(transfers)) * C.size_t(unsafe.Sizeof(empty_lower_transfers))))
+    lower_transfers.len = C.size_t(len(transfers))
+    for lower_transfers_i := range transfers {
+      lower_transfers_ptr := (*C.wadu436_usb_device_stream_transfer_t)(unsafe.Pointer(uintptr(unsafe.Pointer(lower_transfers.ptr)) +
+      uintptr(lower_transfers_i)*unsafe.Sizeof(empty_lower_transfers)))
+      var lower_transfers_ptr_value C.wadu436_usb_device_stream_transfer_t
+      var lower_transfers_ptr_value_endpoint C.wadu436_usb_device_borrow_usb_endpoint_t
+      lower_transfers_ptr_value_endpoint.__handle = C.int32_t(transfers[lower_transfers_i].Endpoint)
+      lower_transfers_ptr_value.endpoint = lower_transfers_ptr_value_endpoint
+      lower_transfers_ptr_value_stream_id := C.uint32_t(transfers[lower_transfers_i].StreamId)
+      lower_transfers_ptr_value.stream_id = lower_transfers_ptr_value_stream_id
+      var lower_transfers_ptr_value_data C.bindings_list_u8_t
+      if len(transfers[lower_transfers_i].Data) == 0 {
+        lower_transfers_ptr_value_data.ptr = nil
+        lower_transfers_ptr_value_data.len = 0
+      } else {
+        var empty_lower_transfers_ptr_value_data C.uint8_t
+        lower_transfers_ptr_value_data.ptr = (*C.uint8_t)(C.malloc(C.size_t(len(transfers[lower_transfers_i].Data)) * C.size_t(unsafe.Sizeof(empty_lower_transfers_ptr_value_data))))
+        lower_transfers_ptr_value_data.len = C.size_t(len(transfers[lower_transfers_i].Data))
+        for lower_transfers_ptr_value_data_i := range transfers[lower_transfers_i].Data {
+          lower_transfers_ptr_value_data_ptr := (*C.uint8_t)(unsafe.Pointer(uintptr(unsafe.Pointer(lower_transfers_ptr_value_data.ptr)) +
+          uintptr(lower_transfers_ptr_value_data_i)*unsafe.Sizeof(empty_lower_transfers_ptr_value_data)))
+          lower_transfers_ptr_value_data_ptr_value := C.uint8_t(transfers[lower_transfers_i].Data[lower_transfers_ptr_value_data_i])
+          *lower_transfers_ptr_value_data_ptr = lower_transfers_ptr_value_data_ptr_value
+        }
+      }
+      lower_transfers_ptr_value.data = lower_transfers_ptr_value_data
+      lower_transfers_ptr_value_length := C.uint64_t(transfers[lower_transfers_i].Length)
+      lower_transfers_ptr_value.length = lower_transfers_ptr_value_length
+      *lower_transfers_ptr = lower_transfers_ptr_value
+    }
+  }
+  var ret C.wadu436_usb_device_list_stream_transfer_result_t
+  C.wadu436_usb_device_method_usb_device_transfer_bulk_streams(lower_self , &lower_transfers , &ret )
+  var lift_ret []Wadu436Usb0_0_1_DeviceStreamTransferResult
+  lift_ret = make([]Wadu436Usb0_0_1_DeviceStreamTransferResult, ret.len)
+  if ret.len > 0 {
+    for lift_ret_i := 0; lift_ret_i < int(ret.len); lift_ret_i++ {
+      var empty_lift_ret C.wadu436_usb_device_stream_transfer_result_t
+      lift_ret_ptr := *(*C.wadu436_usb_device_stream_transfer_result_t)(unsafe.Pointer(uintptr(unsafe.Pointer(ret.ptr)) +
+      uintptr(lift_ret_i)*unsafe.Sizeof(empty_lift_ret)))
+      var list_lift_ret Wadu436Usb0_0_1_DeviceStreamTransferResult
+      var list_lift_ret_val Wadu436Usb0_0_1_TypesStreamTransferResult
+      var list_lift_ret_val_Status Wadu436Usb0_0_1_TypesStreamTransferStatus
+      if lift_ret_ptr.status == 0 {
+        list_lift_ret_val_Status = Wadu436Usb0_0_1_TypesStreamTransferStatusCompleted()
+      }
+      if lift_ret_ptr.status == 1 {
+        list_lift_ret_val_Status = Wadu436Usb0_0_1_TypesStreamTransferStatusCancelled()
+      }
+      if lift_ret_ptr.status == 2 {
+        list_lift_ret_val_Status = Wadu436Usb0_0_1_TypesStreamTransferStatusStalled()
+      }
+      list_lift_ret_val.Status = list_lift_ret_val_Status
+      var list_lift_ret_val_Data []uint8
+      list_lift_ret_val_Data = make([]uint8, lift_ret_ptr.data.len)
+      if lift_ret_ptr.data.len > 0 {
+        for list_lift_ret_val_Data_i := 0; list_lift_ret_val_Data_i < int(lift_ret_ptr.data.len); list_lift_ret_val_Data_i++ {
+          var empty_list_lift_ret_val_Data C.uint8_t
+          list_lift_ret_val_Data_ptr := *(*C.uint8_t)(unsafe.Pointer(uintptr(unsafe.Pointer(lift_ret_ptr.data.ptr)) +
+          uintptr(list_lift_ret_val_Data_i)*unsafe.Sizeof(empty_list_lift_ret_val_Data)))
+          var list_list_lift_ret_val_Data uint8
+          list_list_lift_ret_val_Data = uint8(list_lift_ret_val_Data_ptr)
+          list_lift_ret_val_Data[list_lift_ret_val_Data_i] = list_list_lift_ret_val_Data
+        }
+      }
+      list_lift_ret_val.Data = list_lift_ret_val_Data
+      var list_lift_ret_val_Actual uint64
+      list_lift_ret_val_Actual = uint64(lift_ret_ptr.actual)
+      list_lift_ret_val.Actual = list_lift_ret_val_Actual
+      list_lift_ret = list_lift_ret_val
+      lift_ret[lift_ret_i] = list_lift_ret
+    }
+  }
+  return lift_ret
+}
+
 func (self Wadu436Usb0_0_1_DeviceUsbConfiguration) Descriptor() Wadu436Usb0_0_1_DeviceConfigurationDescriptor {
   var lower_self C.wadu436_usb_device_borrow_usb_configuration_t
   lower_self.__handle = C.int32_t(self)
@@ -73,6 +73,14 @@ typedef uint8_t wadu436_usb_types_speed_t;
 // 5 Gbit/s
 #define WADU436_USB_TYPES_SPEED_SUPERPLUS 5
 
+// Link power management states. usb2-lpm is the L1 sleep state of USB 2.0 LPM,
+// u1 and u2 are the low power link states of USB 3.x.
+typedef uint8_t wadu436_usb_types_link_power_state_t;
+
+#define WADU436_USB_TYPES_LINK_POWER_STATE_USB2_LPM 0
+#define WADU436_USB_TYPES_LINK_POWER_STATE_U1 1
+#define WADU436_USB_TYPES_LINK_POWER_STATE_U2 2
+
 // Setup type for control transfers
 typedef uint8_t wadu436_usb_types_control_setup_type_t;
 
@@ -99,6 +107,28 @@ typedef struct wadu436_usb_types_control_setup_t {
   uint16_t   index;
 } wadu436_usb_types_control_setup_t;
 
+// Outcome of a transfer on a bulk stream
+typedef uint8_t wadu436_usb_types_stream_transfer_status_t;
+
+#define WADU436_USB_TYPES_STREAM_TRANSFER_STATUS_COMPLETED 0
+#define WADU436_USB_TYPES_STREAM_TRANSFER_STATUS_CANCELLED 1
+// still pending when the last transfer of the batch completed
+#define WADU436_USB_TYPES_STREAM_TRANSFER_STATUS_STALLED 2
+
+typedef struct bindings_list_u8_t {
+  uint8_t *ptr;
+  size_t len;
+} bindings_list_u8_t;
+
+// Result of a transfer on a bulk stream
+typedef struct wadu436_usb_types_stream_transfer_result_t {
+  wadu436_usb_types_stream_transfer_status_t   status;
+  bindings_list_u8_t   data;
+  // the data read from an IN endpoint
+  uint64_t   actual;
+  // the number of bytes transferred
+} wadu436_usb_types_stream_transfer_result_t;
+
 typedef wadu436_usb_types_version_t wadu436_usb_descriptors_version_t;
 
 typedef wadu436_usb_types_direction_t wadu436_usb_descriptors_direction_t;
@@ -169,6 +199,12 @@ typedef wadu436_usb_types_control_setup_recipient_t wadu436_usb_device_control_s
 
 typedef wadu436_usb_types_control_setup_t wadu436_usb_device_control_setup_t;
 
+typedef wadu436_usb_types_link_power_state_t wadu436_usb_device_link_power_state_t;
+
+typedef wadu436_usb_types_stream_transfer_status_t wadu436_usb_device_stream_transfer_status_t;
+
+typedef wadu436_usb_types_stream_transfer_result_t wadu436_usb_device_stream_transfer_result_t;
+
 typedef struct wadu436_usb_device_own_usb_device_t {
   int32_t __handle;
 } wadu436_usb_device_own_usb_device_t;
@@ -201,6 +237,16 @@ typedef struct wadu436_usb_device_borrow_usb_endpoint_t {
   int32_t __handle;
 } wadu436_usb_device_borrow_usb_endpoint_t;
 
+// A bulk transfer on a stream of an endpoint, or on the endpoint itself if stream-id is 0
+typedef struct wadu436_usb_device_stream_transfer_t {
+  wadu436_usb_device_borrow_usb_endpoint_t   endpoint;
+  uint32_t   stream_id;
+  bindings_list_u8_t   data;
+  // the data to write to an OUT endpoint
+  uint64_t   length;
+  // the number of bytes to read from an IN endpoint
+} wadu436_usb_device_stream_transfer_t;
+
 typedef struct wadu436_usb_device_list_own_usb_device_t {
   wadu436_usb_device_own_usb_device_t *ptr;
   size_t len;
@@ -216,11 +262,6 @@ typedef struct wadu436_usb_device_list_own_usb_configuration_t {
   size_t len;
 } wadu436_usb_device_list_own_usb_configuration_t;
 
-typedef struct bindings_list_u8_t {
-  uint8_t *ptr;
-  size_t len;
-} bindings_list_u8_t;
-
 typedef struct wadu436_usb_device_list_own_usb_interface_t {
   wadu436_usb_device_own_usb_interface_t *ptr;
   size_t len;
@@ -231,6 +272,21 @@ typedef struct wadu436_usb_device_list_own_usb_endpoint_t {
   size_t len;
 } wadu436_usb_device_list_own_usb_endpoint_t;
 
+typedef struct wadu436_usb_device_list_borrow_usb_endpoint_t {
+  wadu436_usb_device_borrow_usb_endpoint_t *ptr;
+  size_t len;
+} wadu436_usb_device_list_borrow_usb_endpoint_t;
+
+typedef struct wadu436_usb_device_list_stream_transfer_t {
+  wadu436_usb_device_stream_transfer_t *ptr;
+  size_t len;
+} wadu436_usb_device_list_stream_transfer_t;
+
+typedef struct wadu436_usb_device_list_stream_transfer_result_t {
+  wadu436_usb_device_stream_transfer_result_t *ptr;
+  size_t len;
+} wadu436_usb_device_list_stream_transfer_result_t;
+
 // Imported Functions from `wadu436:usb/device@0.0.1`
 // Main entry point for the API.
 // Returns all the USB devices currently connected to the system (or if access control is implemented by the runtime, only the ones the component has access to)
@@ -249,7 +305,7 @@ extern wadu436_usb_device_own_usb_configuration_t wadu436_usb_device_method_usb_
 extern void wadu436_usb_device_method_usb_device_open(wadu436_usb_device_borrow_usb_device_t self);
 // Returns whether the device is currently open.
 extern bool wadu436_usb_device_method_usb_device_opened(wadu436_usb_device_borrow_usb_device_t self);
-// Resets the device.
+// Resets the device. If the device re-enumerates during the reset, this resource no longer refers to it: the device is closed, so opened returns false, and it has to be found again with enumerate.
 extern void wadu436_usb_device_method_usb_device_reset(wadu436_usb_device_borrow_usb_device_t self);
 // Closes the device.
 extern void wadu436_usb_device_method_usb_device_close(wadu436_usb_device_borrow_usb_device_t self);
@@ -278,6 +334,28 @@ extern uint64_t wadu436_usb_device_method_usb_device_write_bulk(wadu436_usb_devi
 extern void wadu436_usb_device_method_usb_device_read_isochronous(wadu436_usb_device_borrow_usb_device_t self, wadu436_usb_device_borrow_usb_endpoint_t endpoint, bindings_list_u8_t *ret);
 // Write data to an isochronous endpoint. The endpoint must be an isochronous endpoint. The return value is the number of bytes written.
 extern uint64_t wadu436_usb_device_method_usb_device_write_isochronous(wadu436_usb_device_borrow_usb_device_t self, wadu436_usb_device_borrow_usb_endpoint_t endpoint, bindings_list_u8_t *data);
+// Returns the number of the bus the device is connected to.
+extern uint8_t wadu436_usb_device_method_usb_device_bus_number(wadu436_usb_device_borrow_usb_device_t self);
+// Returns the port numbers on the path from the root hub to the device. Devices whose port numbers only differ in the last element are connected to the same hub.
+extern void wadu436_usb_device_method_usb_device_port_numbers(wadu436_usb_device_borrow_usb_device_t self, bindings_list_u8_t *ret);
+// Suspends the device (selective suspend). The device must be closed, as an open handle keeps it awake. Opening the device wakes it up, and it suspends again when closed, until resume is called or it signals a remote wakeup if that is enabled.
+extern void wadu436_usb_device_method_usb_device_suspend(wadu436_usb_device_borrow_usb_device_t self);
+// Resumes a suspended device and restores its autosuspend settings. The device must be closed.
+extern void wadu436_usb_device_method_usb_device_resume(wadu436_usb_device_borrow_usb_device_t self);
+// Returns whether the device is currently suspended.
+extern bool wadu436_usb_device_method_usb_device_suspended(wadu436_usb_device_borrow_usb_device_t self);
+// Returns whether both the host and the device support a link power state.
+extern bool wadu436_usb_device_method_usb_device_link_power_state_supported(wadu436_usb_device_borrow_usb_device_t self, wadu436_usb_device_link_power_state_t state);
+// Returns whether a link power state is enabled.
+extern bool wadu436_usb_device_method_usb_device_link_power_state_enabled(wadu436_usb_device_borrow_usb_device_t self, wadu436_usb_device_link_power_state_t state);
+// Enables or disables a link power state. The state must be supported.
+extern void wadu436_usb_device_method_usb_device_set_link_power_state_enabled(wadu436_usb_device_borrow_usb_device_t self, wadu436_usb_device_link_power_state_t state, bool enabled);
+// Allocates bulk streams on SuperSpeed bulk endpoints, which must be in claimed interfaces. Every endpoint gets the same number of streams, with stream IDs 1 to the returned number, which may be lower than requested.
+extern uint32_t wadu436_usb_device_method_usb_device_alloc_streams(wadu436_usb_device_borrow_usb_device_t self, uint32_t num_streams, wadu436_usb_device_list_borrow_usb_endpoint_t *endpoints);
+// Frees the streams allocated on the endpoints.
+extern void wadu436_usb_device_method_usb_device_free_streams(wadu436_usb_device_borrow_usb_device_t self, wadu436_usb_device_list_borrow_usb_endpoint_t *endpoints);
+// Submits bulk transfers together and waits for the last one of the list to complete. The transfers still pending then are cancelled. The results are in the order of the transfers.
+extern void wadu436_usb_device_method_usb_device_transfer_bulk_streams(wadu436_usb_device_borrow_usb_device_t self, wadu436_usb_device_list_stream_transfer_t *transfers, wadu436_usb_device_list_stream_transfer_result_t *ret);
 extern void wadu436_usb_device_method_usb_configuration_descriptor(wadu436_usb_device_borrow_usb_configuration_t self, wadu436_usb_device_configuration_descriptor_t *ret);
 extern void wadu436_usb_device_method_usb_configuration_interfaces(wadu436_usb_device_borrow_usb_configuration_t self, wadu436_usb_device_list_own_usb_interface_t *ret);
 extern void wadu436_usb_device_method_usb_interface_descriptor(wadu436_usb_device_borrow_usb_interface_t self, wadu436_usb_device_interface_descriptor_t *ret);
@@ -294,6 +372,10 @@ void bindings_option_string_free(bindings_option_string_t *ptr);
 
 void wadu436_usb_types_filter_free(wadu436_usb_types_filter_t *ptr);
 
+void bindings_list_u8_free(bindings_list_u8_t *ptr);
+
+void wadu436_usb_types_stream_transfer_result_free(wadu436_usb_types_stream_transfer_result_t *ptr);
+
 void wadu436_usb_descriptors_device_descriptor_free(wadu436_usb_descriptors_device_descriptor_t *ptr);
 
 void wadu436_usb_descriptors_configuration_descriptor_free(wadu436_usb_descriptors_configuration_descriptor_t *ptr);
@@ -308,6 +390,10 @@ void wadu436_usb_device_interface_descriptor_free(wadu436_usb_device_interface_d
 
 void wadu436_usb_device_filter_free(wadu436_usb_device_filter_t *ptr);
 
+void wadu436_usb_device_stream_transfer_result_free(wadu436_usb_device_stream_transfer_result_t *ptr);
+
+void wadu436_usb_device_stream_transfer_free(wadu436_usb_device_stream_transfer_t *ptr);
+
 extern void wadu436_usb_device_usb_device_drop_own(wadu436_usb_device_own_usb_device_t handle);
 
 extern wadu436_usb_device_borrow_usb_device_t wadu436_usb_device_borrow_usb_device(wadu436_usb_device_own_usb_device_t handle);
@@ -330,12 +416,16 @@ void wadu436_usb_device_option_own_usb_device_free(wadu436_usb_device_option_own
 
 void wadu436_usb_device_list_own_usb_configuration_free(wadu436_usb_device_list_own_usb_configuration_t *ptr);
 
-void bindings_list_u8_free(bindings_list_u8_t *ptr);
-
 void wadu436_usb_device_list_own_usb_interface_free(wadu436_usb_device_list_own_usb_interface_t *ptr);
 
 void wadu436_usb_device_list_own_usb_endpoint_free(wadu436_usb_device_list_own_usb_endpoint_t *ptr);
 
+void wadu436_usb_device_list_borrow_usb_endpoint_free(wadu436_usb_device_list_borrow_usb_endpoint_t *ptr);
+
+void wadu436_usb_device_list_stream_transfer_free(wadu436_usb_device_list_stream_transfer_t *ptr);
+
+void wadu436_usb_device_list_stream_transfer_result_free(wadu436_usb_device_list_stream_transfer_result_t *ptr);
+
 // Transfers ownership of `s` into the string `ret`
 void bindings_string_set(bindings_string_t *ret, const char*s);
 
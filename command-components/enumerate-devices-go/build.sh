@@ -3,4 +3,10 @@ go generate
 tinygo build -target=wasi -o ./out/main.wasm main.go
 wasm-tools component embed --world bindings ../../wit ./out/main.wasm -o ./out/main.embed.wasm # create a component
 wasm-tools component new ./out/main.embed.wasm --adapt ../wasi_snapshot_preview1.command.wasm -o ./out/main.component.wasm
-wasm-tools validate ./out/main.component.wasm --features component-model
\ No newline at end of file
+wasm-tools validate ./out/main.component.wasm --features component-model
+for cmd in diskimage mscbench; do
+    tinygo build -target=wasi -o ./out/$cmd.wasm ./cmd/$cmd
+    wasm-tools component embed --world bindings ../../wit ./out/$cmd.wasm -o ./out/$cmd.embed.wasm
+    wasm-tools component new ./out/$cmd.embed.wasm --adapt ../wasi_snapshot_preview1.command.wasm -o ./out/$cmd.component.wasm
+    wasm-tools validate ./out/$cmd.component.wasm --features component-model
+done
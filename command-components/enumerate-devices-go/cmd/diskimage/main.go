@@ -0,0 +1,156 @@
+// Command diskimage copies a whole USB mass storage device to an image file,
+// or an image file back to a device.
+//
+//	diskimage [flags] read IMAGE
+//	diskimage [flags] write IMAGE
+//
+// The copy is checkpointed to IMAGE.resume; after an interruption, run the
+// same command with -resume to continue where it stopped.
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"example.com/cmd/internal/medium"
+	"example.com/usb/msc/diskimage"
+	"example.com/usb/msc/scsi"
+)
+
+var (
+	deviceFlag   = flag.Int("device", 0, "the mass storage `index` among the devices found, from 0")
+	lunFlag      = flag.Uint("lun", 0, "the logical unit")
+	transferFlag = flag.Int("transfer", 1024, "the transfer size in `KiB`")
+	sparseFlag   = flag.Bool("sparse", false, "leave holes in the image for zero blocks (read)")
+	verifyFlag   = flag.Bool("verify", false, "read the copy back and compare its SHA-256")
+	resumeFlag   = flag.Bool("resume", false, "continue an interrupted copy from IMAGE.resume")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: diskimage [flags] read|write IMAGE")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 || flag.Arg(0) != "read" && flag.Arg(0) != "write" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Arg(0), flag.Arg(1)); err != nil {
+		fmt.Fprintln(os.Stderr, "diskimage:", err)
+		os.Exit(1)
+	}
+}
+
+func run(op, image string) error {
+	resumeFile := image + ".resume"
+	opts := &diskimage.Options{
+		TransferSize: *transferFlag << 10,
+		Sparse:       *sparseFlag,
+		Verify:       *verifyFlag,
+		Progress:     progress(),
+		Checkpoint:   func(cp *diskimage.Checkpoint) error { return saveCheckpoint(resumeFile, cp) },
+	}
+	cp, err := loadCheckpoint(resumeFile)
+	switch {
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return err
+	case err == nil && !*resumeFlag:
+		return fmt.Errorf("%s exists: use -resume to continue the interrupted copy, or remove it", resumeFile)
+	case err != nil && *resumeFlag:
+		return fmt.Errorf("nothing to resume: %w", err)
+	}
+	opts.Resume = cp
+
+	m, _, err := medium.Open(*deviceFlag, uint8(*lunFlag))
+	if err != nil {
+		return err
+	}
+	transfer := readImage
+	if op == "write" {
+		transfer = writeImage
+	}
+	r, err := transfer(m, image, opts)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(resumeFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	fmt.Printf("%s  %s\n", hex.EncodeToString(r.SHA256[:]), image)
+	if r.Verified {
+		fmt.Fprintln(os.Stderr, "verified")
+	}
+	return nil
+}
+
+func readImage(m *scsi.Device, image string, opts *diskimage.Options) (*diskimage.Result, error) {
+	f, err := os.OpenFile(image, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return diskimage.Read(m, f, opts)
+}
+
+func writeImage(m *scsi.Device, image string, opts *diskimage.Options) (*diskimage.Result, error) {
+	if protected, err := m.WriteProtected(); err == nil && protected {
+		return nil, errors.New("the medium is write-protected")
+	}
+	f, err := os.Open(image)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return diskimage.Write(m, f, info.Size(), opts)
+}
+
+// progress returns a Progress function that prints at most once a second.
+func progress() func(diskimage.Progress) {
+	var last time.Time
+	return func(p diskimage.Progress) {
+		if time.Since(last) < time.Second && p.Done < p.Total {
+			return
+		}
+		last = time.Now()
+		const MiB = 1 << 20
+		fmt.Fprintf(os.Stderr, "\r%-9s %9.1f / %.1f MiB  %6.1f MiB/s", p.Phase, float64(p.Done)/MiB, float64(p.Total)/MiB, p.Rate()/MiB)
+	}
+}
+
+func loadCheckpoint(name string) (*diskimage.Checkpoint, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	cp := new(diskimage.Checkpoint)
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return cp, nil
+}
+
+// saveCheckpoint replaces the checkpoint file, so that it is never left
+// half written.
+func saveCheckpoint(name string, cp *diskimage.Checkpoint) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
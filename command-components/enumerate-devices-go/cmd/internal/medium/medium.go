@@ -0,0 +1,94 @@
+// Package medium finds the mass storage device the commands work on.
+package medium
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"example.com/usb"
+	"example.com/usb/component"
+	"example.com/usb/msc"
+	"example.com/usb/msc/bot"
+	"example.com/usb/msc/scsi"
+	"example.com/usb/msc/uas"
+)
+
+// Open returns logical unit lun of the index-th mass storage device, over
+// UAS when it supports it and Bulk-Only otherwise, and a name for it. It
+// lists the units of the device on stderr. The other devices are dropped.
+func Open(index int, lun uint8) (*scsi.Device, string, error) {
+	hosts := component.Enumerate()
+	var used *component.Host
+	defer func() {
+		for _, h := range hosts {
+			if h != used {
+				h.Drop()
+			}
+		}
+	}()
+	found := 0
+	var errs []error
+	for _, h := range hosts {
+		d := usb.NewDevice(h)
+		if err := d.Open(); err != nil {
+			continue
+		}
+		if _, ok := d.ActiveConfiguration(); !ok && len(d.Configurations()) > 0 {
+			if err := d.SelectConfiguration(d.Configurations()[0].Descriptor.Number); err != nil {
+				desc := d.Descriptor()
+				errs = append(errs, fmt.Errorf("%04x:%04x: %w", desc.VendorID, desc.ProductID, err))
+				d.Close()
+				continue
+			}
+		}
+		_, ok := bot.Find(d)
+		if !ok {
+			_, ok = uas.Find(d)
+		}
+		if !ok || found < index {
+			if ok {
+				found++
+			}
+			d.Close()
+			continue
+		}
+		t, maxLUN, err := uas.Open(d)
+		if err != nil {
+			return nil, "", err
+		}
+		u, err := selectUnit(t, maxLUN, lun)
+		if err != nil {
+			return nil, "", err
+		}
+		desc := d.Descriptor()
+		name := fmt.Sprintf("%04x:%04x %s %s LUN %d", desc.VendorID, desc.ProductID, u.Inquiry.Vendor, u.Inquiry.Product, lun)
+		fmt.Fprintln(os.Stderr, "using", name)
+		used = h
+		return u.Device, name, nil
+	}
+	return nil, "", errors.Join(append([]error{fmt.Errorf("no mass storage device %d", index)}, errs...)...)
+}
+
+// selectUnit probes the logical units of t, lists them, and returns unit lun
+// if it holds a medium.
+func selectUnit(t scsi.Transport, maxLUN, lun uint8) (*msc.Unit, error) {
+	units, err := msc.Probe(t, maxLUN)
+	if err != nil {
+		return nil, err
+	}
+	var found *msc.Unit
+	for _, u := range units {
+		fmt.Fprintln(os.Stderr, u)
+		if u.Device.LUN() == lun {
+			found = u
+		}
+	}
+	switch {
+	case found == nil:
+		return nil, fmt.Errorf("device has no LUN %d", lun)
+	case found.State != msc.MediaReady:
+		return nil, fmt.Errorf("LUN %d: %s", lun, found.State)
+	}
+	return found, nil
+}
@@ -0,0 +1,145 @@
+// Command mscbench measures the raw speed of a USB mass storage device and
+// prints a JSON report, to compare host and guest builds.
+//
+//	mscbench [flags] [REPORT]
+//
+// Without -write only reads are tested. With it, the span tested is
+// overwritten.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/cmd/internal/medium"
+	"example.com/usb/msc/bench"
+)
+
+var (
+	deviceFlag   = flag.Int("device", 0, "the mass storage `index` among the devices found, from 0")
+	lunFlag      = flag.Uint("lun", 0, "the logical unit")
+	startFlag    = flag.Int64("start", 1, "the offset of the span tested in `MiB`")
+	spanFlag     = flag.Int64("span", 256, "the size of the span tested in `MiB`")
+	amountFlag   = flag.Int64("amount", 64, "the `MiB` moved by each test")
+	durationFlag = flag.Duration("duration", 0, "end each test after this long")
+	seqFlag      = flag.String("seq", "1024,64", "the sequential transfer `sizes` in KiB")
+	randFlag     = flag.String("rand", "4", "the random transfer `sizes` in KiB")
+	qdFlag       = flag.String("qd", "1", "the queue `depths` of the random tests; above 1 they need a UAS device with bulk streams")
+	writeFlag    = flag.Bool("write", false, "test writes too, destroying the data in the span")
+	directFlag   = flag.Bool("direct", false, "bypass the device's cache with force unit access")
+	seedFlag     = flag.Uint64("seed", 1, "the seed of the random offsets and data")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: mscbench [flags] [REPORT]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, "mscbench:", err)
+		os.Exit(1)
+	}
+}
+
+func run(report string) error {
+	tests, err := parseTests()
+	if err != nil {
+		return err
+	}
+	m, name, err := medium.Open(*deviceFlag, uint8(*lunFlag))
+	if err != nil {
+		return err
+	}
+	r, err := bench.Run(m, &bench.Options{
+		Start:       *startFlag << 20,
+		Span:        *spanFlag << 20,
+		Amount:      *amountFlag << 20,
+		Duration:    *durationFlag,
+		Tests:       tests,
+		Write:       *writeFlag,
+		BypassCache: *directFlag,
+		Seed:        *seedFlag,
+		Progress:    progress,
+	})
+	if err != nil {
+		return err
+	}
+	r.Device = name
+	if report == "" {
+		return r.WriteJSON(os.Stdout)
+	}
+	f, err := os.Create(report)
+	if err != nil {
+		return err
+	}
+	if err := r.WriteJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// parseTests returns the tests of the flags, reads before writes.
+func parseTests() ([]bench.Test, error) {
+	seq, err := parseList(*seqFlag, 1<<10)
+	if err != nil {
+		return nil, fmt.Errorf("-seq: %w", err)
+	}
+	random, err := parseList(*randFlag, 1<<10)
+	if err != nil {
+		return nil, fmt.Errorf("-rand: %w", err)
+	}
+	depths, err := parseList(*qdFlag, 1)
+	if err != nil {
+		return nil, fmt.Errorf("-qd: %w", err)
+	}
+	var tests []bench.Test
+	for _, write := range []bool{false, true} {
+		if write && !*writeFlag {
+			break
+		}
+		for _, size := range seq {
+			tests = append(tests, bench.Test{Write: write, Size: size})
+		}
+		for _, size := range random {
+			for _, qd := range depths {
+				tests = append(tests, bench.Test{Random: true, Write: write, Size: size, QueueDepth: qd})
+			}
+		}
+	}
+	return tests, nil
+}
+
+// parseList parses a comma-separated list of positive numbers, in units.
+func parseList(s string, unit int) ([]int, error) {
+	var list []int
+	for _, f := range strings.Split(s, ",") {
+		if f == "" {
+			continue
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("bad number %q", f)
+		}
+		list = append(list, n*unit)
+	}
+	return list, nil
+}
+
+func progress(r *bench.Result) {
+	fmt.Fprintf(os.Stderr, "%-20s %9.2f MB/s %9.0f IOPS  p50 %s  p99 %s\n", r.Name, r.MBps, r.IOPS,
+		us(r.Latency.P50), us(r.Latency.P99))
+}
+
+func us(x float64) time.Duration {
+	return time.Duration(x * float64(time.Microsecond)).Round(time.Microsecond)
+}
@@ -0,0 +1,357 @@
+// Package component implements usb.Host on top of the wadu436:usb imports
+// generated in example.com/api. It only builds for the component target.
+package component
+
+import (
+	api "example.com/api"
+	"example.com/usb"
+)
+
+type (
+	apiDevice        = api.Wadu436Usb0_0_1_DeviceUsbDevice
+	apiConfiguration = api.Wadu436Usb0_0_1_DeviceUsbConfiguration
+	apiInterface     = api.Wadu436Usb0_0_1_DeviceUsbInterface
+	apiEndpoint      = api.Wadu436Usb0_0_1_DeviceUsbEndpoint
+)
+
+type interfaceKey struct {
+	number, alt uint8
+}
+
+// Host is a usb-device resource together with the child resources needed to
+// address configurations, interfaces and endpoints by number.
+type Host struct {
+	device     apiDevice
+	descriptor usb.DeviceDescriptor
+	configs    []usb.Configuration
+
+	configHandles    map[uint8]apiConfiguration
+	interfaceHandles map[uint8]map[interfaceKey]apiInterface
+	endpointHandles  map[uint8]apiEndpoint
+}
+
+var (
+	_ usb.Host       = (*Host)(nil)
+	_ usb.Locator    = (*Host)(nil)
+	_ usb.PowerHost  = (*Host)(nil)
+	_ usb.StreamHost = (*Host)(nil)
+)
+
+// Enumerate returns all devices the component has access to.
+func Enumerate() []*Host {
+	devices := api.StaticUsbDeviceEnumerate()
+	hosts := make([]*Host, len(devices))
+	for i, device := range devices {
+		hosts[i] = New(device)
+	}
+	return hosts
+}
+
+// Hosts is Enumerate as a usb.Enumerator, for Device.ResetAndReacquire.
+func Hosts() ([]usb.Host, error) {
+	var hosts []usb.Host
+	for _, h := range Enumerate() {
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// New wraps an owned usb-device handle. The configuration, interface and
+// endpoint resources are fetched once and kept until Drop.
+func New(device apiDevice) *Host {
+	h := &Host{
+		device:           device,
+		descriptor:       deviceDescriptor(device.Descriptor()),
+		configHandles:    make(map[uint8]apiConfiguration),
+		interfaceHandles: make(map[uint8]map[interfaceKey]apiInterface),
+		endpointHandles:  make(map[uint8]apiEndpoint),
+	}
+	for _, config := range device.Configurations() {
+		desc := config.Descriptor()
+		c := usb.Configuration{Descriptor: usb.ConfigurationDescriptor{
+			Number:       desc.Number,
+			Description:  optionString(desc.Description),
+			SelfPowered:  desc.SelfPowered,
+			RemoteWakeup: desc.RemoteWakeup,
+			MaxPower:     desc.MaxPower,
+		}}
+		h.configHandles[desc.Number] = config
+		h.interfaceHandles[desc.Number] = make(map[interfaceKey]apiInterface)
+		for _, intf := range config.Interfaces() {
+			idesc := intf.Descriptor()
+			i := usb.Interface{Descriptor: usb.InterfaceDescriptor{
+				InterfaceNumber:   idesc.InterfaceNumber,
+				AlternateSetting:  idesc.AlternateSetting,
+				InterfaceClass:    idesc.InterfaceClass,
+				InterfaceSubclass: idesc.InterfaceSubclass,
+				InterfaceProtocol: idesc.InterfaceProtocol,
+				InterfaceName:     optionString(idesc.InterfaceName),
+			}}
+			h.interfaceHandles[desc.Number][interfaceKey{idesc.InterfaceNumber, idesc.AlternateSetting}] = intf
+			for _, endpoint := range intf.Endpoints() {
+				ep := endpointDescriptor(endpoint.Descriptor())
+				i.Endpoints = append(i.Endpoints, ep)
+				// The host only uses the endpoint resource for its address,
+				// so any handle with the right address will do.
+				if _, ok := h.endpointHandles[ep.Address()]; !ok {
+					h.endpointHandles[ep.Address()] = endpoint
+				}
+			}
+			c.Interfaces = append(c.Interfaces, i)
+		}
+		h.configs = append(h.configs, c)
+	}
+	return h
+}
+
+// Drop releases the usb-device resource and all of its children.
+func (h *Host) Drop() {
+	for _, ep := range h.endpointHandles {
+		ep.Drop()
+	}
+	for _, intfs := range h.interfaceHandles {
+		for _, intf := range intfs {
+			intf.Drop()
+		}
+	}
+	for _, config := range h.configHandles {
+		config.Drop()
+	}
+	h.device.Drop()
+}
+
+func (h *Host) Descriptor() usb.DeviceDescriptor {
+	return h.descriptor
+}
+
+func (h *Host) Speed() usb.Speed {
+	return usb.Speed(h.device.Speed().Kind())
+}
+
+func (h *Host) Configurations() []usb.Configuration {
+	return h.configs
+}
+
+func (h *Host) ActiveConfiguration() uint8 {
+	config := h.device.ActiveConfiguration()
+	defer config.Drop()
+	return config.Descriptor().Number
+}
+
+func (h *Host) Open() error {
+	h.device.Open()
+	return nil
+}
+
+func (h *Host) Opened() bool {
+	return h.device.Opened()
+}
+
+// Reset resets the device. The host closes a handle whose device
+// re-enumerated during the reset, which Reset reports as ErrNoDevice.
+func (h *Host) Reset() error {
+	h.device.Reset()
+	if !h.device.Opened() {
+		return usb.ErrNoDevice
+	}
+	return nil
+}
+
+func (h *Host) Close() error {
+	h.device.Close()
+	return nil
+}
+
+func (h *Host) SelectConfiguration(number uint8) error {
+	h.device.SelectConfiguration(h.configHandles[number])
+	return nil
+}
+
+func (h *Host) interfaceHandle(number, alt uint8) apiInterface {
+	return h.interfaceHandles[h.ActiveConfiguration()][interfaceKey{number, alt}]
+}
+
+func (h *Host) ClaimInterface(number, alt uint8) error {
+	h.device.ClaimInterface(h.interfaceHandle(number, alt))
+	return nil
+}
+
+func (h *Host) ReleaseInterface(number, alt uint8) error {
+	h.device.ReleaseInterface(h.interfaceHandle(number, alt))
+	return nil
+}
+
+func (h *Host) ClearHalt(endpoint uint8) error {
+	h.device.ClearHalt(h.endpointHandles[endpoint])
+	return nil
+}
+
+func (h *Host) ReadControl(setup usb.ControlSetup, length uint16) ([]byte, error) {
+	return h.device.ReadControl(controlSetup(setup), length), nil
+}
+
+func (h *Host) WriteControl(setup usb.ControlSetup, data []byte) (int, error) {
+	return int(h.device.WriteControl(controlSetup(setup), data)), nil
+}
+
+func (h *Host) ReadInterrupt(endpoint uint8, length int) ([]byte, error) {
+	return h.device.ReadInterrupt(h.endpointHandles[endpoint], uint64(length)), nil
+}
+
+func (h *Host) WriteInterrupt(endpoint uint8, data []byte) (int, error) {
+	return int(h.device.WriteInterrupt(h.endpointHandles[endpoint], data)), nil
+}
+
+func (h *Host) ReadBulk(endpoint uint8, length int) ([]byte, error) {
+	return h.device.ReadBulk(h.endpointHandles[endpoint], uint64(length)), nil
+}
+
+func (h *Host) WriteBulk(endpoint uint8, data []byte) (int, error) {
+	return int(h.device.WriteBulk(h.endpointHandles[endpoint], data)), nil
+}
+
+func (h *Host) ReadIsochronous(endpoint uint8) ([]byte, error) {
+	return h.device.ReadIsochronous(h.endpointHandles[endpoint]), nil
+}
+
+func (h *Host) WriteIsochronous(endpoint uint8, data []byte) (int, error) {
+	return int(h.device.WriteIsochronous(h.endpointHandles[endpoint], data)), nil
+}
+
+func (h *Host) Location() usb.Location {
+	return usb.Location{Bus: h.device.BusNumber(), Ports: h.device.PortNumbers()}
+}
+
+func (h *Host) Suspend() error {
+	h.device.Suspend()
+	return nil
+}
+
+func (h *Host) Resume() error {
+	h.device.Resume()
+	return nil
+}
+
+func (h *Host) Suspended() (bool, error) {
+	return h.device.Suspended(), nil
+}
+
+func (h *Host) LinkPowerStateSupported(state usb.LinkPowerState) (bool, error) {
+	return h.device.LinkPowerStateSupported(linkPowerState(state)), nil
+}
+
+func (h *Host) LinkPowerStateEnabled(state usb.LinkPowerState) (bool, error) {
+	return h.device.LinkPowerStateEnabled(linkPowerState(state)), nil
+}
+
+func (h *Host) SetLinkPowerStateEnabled(state usb.LinkPowerState, enabled bool) error {
+	h.device.SetLinkPowerStateEnabled(linkPowerState(state), enabled)
+	return nil
+}
+
+func (h *Host) endpoints(addresses []uint8) []apiEndpoint {
+	endpoints := make([]apiEndpoint, len(addresses))
+	for i, address := range addresses {
+		endpoints[i] = h.endpointHandles[address]
+	}
+	return endpoints
+}
+
+func (h *Host) AllocStreams(count uint32, endpoints []uint8) (uint32, error) {
+	return h.device.AllocStreams(count, h.endpoints(endpoints)), nil
+}
+
+func (h *Host) FreeStreams(endpoints []uint8) error {
+	h.device.FreeStreams(h.endpoints(endpoints))
+	return nil
+}
+
+func (h *Host) TransferStreams(transfers []usb.StreamTransfer) ([]usb.StreamResult, error) {
+	apiTransfers := make([]api.Wadu436Usb0_0_1_DeviceStreamTransfer, len(transfers))
+	for i, t := range transfers {
+		apiTransfers[i] = api.Wadu436Usb0_0_1_DeviceStreamTransfer{
+			Endpoint: h.endpointHandles[t.Endpoint],
+			StreamId: t.StreamID,
+			Data:     t.Data,
+			Length:   uint64(t.Length),
+		}
+	}
+	apiResults := h.device.TransferBulkStreams(apiTransfers)
+	results := make([]usb.StreamResult, len(apiResults))
+	for i, r := range apiResults {
+		results[i] = usb.StreamResult{
+			Status: usb.StreamStatus(r.Status.Kind()),
+			Data:   r.Data,
+			Actual: int(r.Actual),
+		}
+	}
+	return results, nil
+}
+
+func optionString(o api.Option[string]) string {
+	if o.IsNone() {
+		return ""
+	}
+	return o.Unwrap()
+}
+
+func deviceDescriptor(d api.Wadu436Usb0_0_1_DeviceDeviceDescriptor) usb.DeviceDescriptor {
+	return usb.DeviceDescriptor{
+		ProductName:      optionString(d.ProductName),
+		ManufacturerName: optionString(d.ManufacturerName),
+		SerialNumber:     optionString(d.SerialNumber),
+		USBVersion:       usb.Version{Major: d.UsbVersion.F0, Minor: d.UsbVersion.F1, SubMinor: d.UsbVersion.F2},
+		VendorID:         d.VendorId,
+		ProductID:        d.ProductId,
+		DeviceVersion:    usb.Version{Major: d.DeviceVersion.F0, Minor: d.DeviceVersion.F1, SubMinor: d.DeviceVersion.F2},
+		DeviceClass:      d.DeviceClass,
+		DeviceSubclass:   d.DeviceSubclass,
+		DeviceProtocol:   d.DeviceProtocol,
+		MaxPacketSize:    d.MaxPacketSize,
+	}
+}
+
+func endpointDescriptor(d api.Wadu436Usb0_0_1_DeviceEndpointDescriptor) usb.EndpointDescriptor {
+	return usb.EndpointDescriptor{
+		EndpointNumber: d.EndpointNumber,
+		Direction:      usb.Direction(d.Direction.Kind()),
+		TransferType:   usb.TransferType(d.TransferType.Kind()),
+		MaxPacketSize:  d.MaxPacketSize,
+		Interval:       d.Interval,
+	}
+}
+
+func controlSetup(s usb.ControlSetup) api.Wadu436Usb0_0_1_DeviceControlSetup {
+	setup := api.Wadu436Usb0_0_1_DeviceControlSetup{
+		Request: s.Request,
+		Value:   s.Value,
+		Index:   s.Index,
+	}
+	switch s.Type {
+	case usb.ControlStandard:
+		setup.RequestType = api.Wadu436Usb0_0_1_TypesControlSetupTypeStandard()
+	case usb.ControlClass:
+		setup.RequestType = api.Wadu436Usb0_0_1_TypesControlSetupTypeClass()
+	case usb.ControlVendor:
+		setup.RequestType = api.Wadu436Usb0_0_1_TypesControlSetupTypeVendor()
+	}
+	switch s.Recipient {
+	case usb.RecipientDevice:
+		setup.RequestRecipient = api.Wadu436Usb0_0_1_TypesControlSetupRecipientDevice()
+	case usb.RecipientInterface:
+		setup.RequestRecipient = api.Wadu436Usb0_0_1_TypesControlSetupRecipientInterface()
+	case usb.RecipientEndpoint:
+		setup.RequestRecipient = api.Wadu436Usb0_0_1_TypesControlSetupRecipientEndpoint()
+	}
+	return setup
+}
+
+func linkPowerState(s usb.LinkPowerState) api.Wadu436Usb0_0_1_DeviceLinkPowerState {
+	switch s {
+	case usb.LinkPowerU1:
+		return api.Wadu436Usb0_0_1_TypesLinkPowerStateU1()
+	case usb.LinkPowerU2:
+		return api.Wadu436Usb0_0_1_TypesLinkPowerStateU2()
+	}
+	return api.Wadu436Usb0_0_1_TypesLinkPowerStateUsb2Lpm()
+}
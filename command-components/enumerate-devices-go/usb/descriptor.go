@@ -0,0 +1,288 @@
+// Package usb is a Go layer on top of the wadu436:usb WIT interface.
+//
+// The generated bindings in example.com/api expose the usb-device resource as
+// is: every call goes straight to the host, and any misuse traps. This package
+// wraps a device in a Device that tracks the open, configured and claimed
+// state and rejects illegal call sequences with descriptive errors before
+// they cross the component boundary. Cross-cutting behaviour such as retries
+// is added by Interceptors that wrap the device's Transport.
+package usb
+
+import "fmt"
+
+// Direction of an endpoint, as seen from the host.
+type Direction uint8
+
+const (
+	DirectionOut Direction = iota
+	DirectionIn
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionOut:
+		return "out"
+	case DirectionIn:
+		return "in"
+	}
+	return fmt.Sprintf("Direction(%d)", uint8(d))
+}
+
+// TransferType of an endpoint. The values follow the WIT transfer-type enum,
+// which differs from the bmAttributes encoding.
+type TransferType uint8
+
+const (
+	TransferControl TransferType = iota
+	TransferIsochronous
+	TransferBulk
+	TransferInterrupt
+)
+
+func (t TransferType) String() string {
+	switch t {
+	case TransferControl:
+		return "control"
+	case TransferIsochronous:
+		return "isochronous"
+	case TransferBulk:
+		return "bulk"
+	case TransferInterrupt:
+		return "interrupt"
+	}
+	return fmt.Sprintf("TransferType(%d)", uint8(t))
+}
+
+// Speed of the device.
+type Speed uint8
+
+const (
+	SpeedUnknown Speed = iota
+	SpeedLow
+	SpeedFull
+	SpeedHigh
+	SpeedSuper
+	SpeedSuperPlus
+)
+
+func (s Speed) String() string {
+	switch s {
+	case SpeedUnknown:
+		return "unknown"
+	case SpeedLow:
+		return "low"
+	case SpeedFull:
+		return "full"
+	case SpeedHigh:
+		return "high"
+	case SpeedSuper:
+		return "super"
+	case SpeedSuperPlus:
+		return "superplus"
+	}
+	return fmt.Sprintf("Speed(%d)", uint8(s))
+}
+
+// Version is a binary-coded decimal version triple, e.g. 2.0.0 for USB 2.0.
+type Version struct {
+	Major, Minor, SubMinor uint8
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.SubMinor)
+}
+
+// DeviceDescriptor mirrors the WIT device-descriptor record.
+type DeviceDescriptor struct {
+	ProductName      string
+	ManufacturerName string
+	SerialNumber     string
+
+	USBVersion Version
+
+	VendorID      uint16
+	ProductID     uint16
+	DeviceVersion Version
+
+	DeviceClass    uint8
+	DeviceSubclass uint8
+	DeviceProtocol uint8
+
+	MaxPacketSize uint8
+}
+
+// ConfigurationDescriptor mirrors the WIT configuration-descriptor record.
+type ConfigurationDescriptor struct {
+	Number       uint8
+	Description  string
+	SelfPowered  bool
+	RemoteWakeup bool
+	MaxPower     uint16 // in milliamps, not in the 2 mA units of the USB spec
+}
+
+// InterfaceDescriptor mirrors the WIT interface-descriptor record.
+type InterfaceDescriptor struct {
+	InterfaceNumber   uint8
+	AlternateSetting  uint8
+	InterfaceClass    uint8
+	InterfaceSubclass uint8
+	InterfaceProtocol uint8
+	InterfaceName     string
+}
+
+// EndpointDescriptor mirrors the WIT endpoint-descriptor record.
+type EndpointDescriptor struct {
+	EndpointNumber uint8 // 0-15, lower 4 bits of bEndpointAddress
+	Direction      Direction
+	TransferType   TransferType
+	MaxPacketSize  uint16
+	Interval       uint8
+}
+
+// Address returns the bEndpointAddress of the endpoint.
+func (e EndpointDescriptor) Address() uint8 {
+	return EndpointAddress(e.EndpointNumber, e.Direction)
+}
+
+// EndpointAddress builds a bEndpointAddress from an endpoint number and direction.
+func EndpointAddress(number uint8, dir Direction) uint8 {
+	addr := number & 0x0f
+	if dir == DirectionIn {
+		addr |= 0x80
+	}
+	return addr
+}
+
+// EndpointDirection returns the direction encoded in bit 7 of an endpoint address.
+func EndpointDirection(address uint8) Direction {
+	if address&0x80 != 0 {
+		return DirectionIn
+	}
+	return DirectionOut
+}
+
+// Configuration is a configuration descriptor together with its interfaces.
+type Configuration struct {
+	Descriptor ConfigurationDescriptor
+	Interfaces []Interface
+}
+
+// Interface is an alternate setting of an interface together with its endpoints.
+// Like the WIT usb-interface resource, every alternate setting is a separate Interface.
+type Interface struct {
+	Descriptor InterfaceDescriptor
+	Endpoints  []EndpointDescriptor
+}
+
+// Interface returns the alternate setting alt of interface number, if present.
+func (c *Configuration) Interface(number, alt uint8) (*Interface, bool) {
+	for i := range c.Interfaces {
+		d := c.Interfaces[i].Descriptor
+		if d.InterfaceNumber == number && d.AlternateSetting == alt {
+			return &c.Interfaces[i], true
+		}
+	}
+	return nil, false
+}
+
+// Endpoint returns the endpoint with the given address, if the interface has one.
+func (i *Interface) Endpoint(address uint8) (EndpointDescriptor, bool) {
+	for _, ep := range i.Endpoints {
+		if ep.Address() == address {
+			return ep, true
+		}
+	}
+	return EndpointDescriptor{}, false
+}
+
+// ControlType is the type field of bmRequestType.
+type ControlType uint8
+
+const (
+	ControlStandard ControlType = iota
+	ControlClass
+	ControlVendor
+)
+
+func (t ControlType) String() string {
+	switch t {
+	case ControlStandard:
+		return "standard"
+	case ControlClass:
+		return "class"
+	case ControlVendor:
+		return "vendor"
+	}
+	return fmt.Sprintf("ControlType(%d)", uint8(t))
+}
+
+// ControlRecipient is the recipient field of bmRequestType.
+type ControlRecipient uint8
+
+const (
+	RecipientDevice ControlRecipient = iota
+	RecipientInterface
+	RecipientEndpoint
+)
+
+func (r ControlRecipient) String() string {
+	switch r {
+	case RecipientDevice:
+		return "device"
+	case RecipientInterface:
+		return "interface"
+	case RecipientEndpoint:
+		return "endpoint"
+	}
+	return fmt.Sprintf("ControlRecipient(%d)", uint8(r))
+}
+
+// ControlSetup mirrors the WIT control-setup record. The direction bit of
+// bmRequestType is implied by whether ReadControl or WriteControl is used.
+type ControlSetup struct {
+	Type      ControlType
+	Recipient ControlRecipient
+	Request   uint8  // bRequest
+	Value     uint16 // wValue
+	Index     uint16 // wIndex
+}
+
+// RequestType returns the bmRequestType byte for a transfer in direction dir.
+func (s ControlSetup) RequestType(dir Direction) uint8 {
+	rt := uint8(s.Type)<<5 | uint8(s.Recipient)
+	if dir == DirectionIn {
+		rt |= 0x80
+	}
+	return rt
+}
+
+// Standard request codes (bRequest) from chapter 9 of the USB specification.
+const (
+	RequestGetStatus        uint8 = 0x00
+	RequestClearFeature     uint8 = 0x01
+	RequestSetFeature       uint8 = 0x03
+	RequestSetAddress       uint8 = 0x05
+	RequestGetDescriptor    uint8 = 0x06
+	RequestSetDescriptor    uint8 = 0x07
+	RequestGetConfiguration uint8 = 0x08
+	RequestSetConfiguration uint8 = 0x09
+	RequestGetInterface     uint8 = 0x0a
+	RequestSetInterface     uint8 = 0x0b
+	RequestSynchFrame       uint8 = 0x0c
+)
+
+// Standard feature selectors for SET_FEATURE and CLEAR_FEATURE.
+const (
+	FeatureEndpointHalt       uint16 = 0
+	FeatureDeviceRemoteWakeup uint16 = 1
+	FeatureTestMode           uint16 = 2
+	FeatureU1Enable           uint16 = 48
+	FeatureU2Enable           uint16 = 49
+)
+
+// Descriptor types for GET_DESCRIPTOR, sent in the high byte of wValue.
+const (
+	DescriptorTypeDevice        uint8 = 0x01
+	DescriptorTypeConfiguration uint8 = 0x02
+	DescriptorTypeString        uint8 = 0x03
+)
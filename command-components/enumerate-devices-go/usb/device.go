@@ -0,0 +1,235 @@
+package usb
+
+import "time"
+
+// Device wraps a Host and tracks whether it is open, which configuration is
+// active and which interfaces are claimed. Every call is checked against that
+// state and against the descriptors before it is forwarded, so that the
+// preconditions documented in the WIT interface ("the device must first be
+// opened", "the endpoint must be a bulk endpoint", ...) surface as a
+// *StateError instead of a trap. Transfers that fail on the host are
+// recovered according to the endpoint's RetryPolicy.
+//
+// Calls go through a chain of interceptors:
+//
+//	state validation -> retry -> interceptors passed to NewDevice or Use -> safety -> quirks -> host
+//
+// so the configured interceptors only see calls that are valid in the
+// current state, and see every attempt and recovery action of a retry. The
+// safety layer set with SetSafety sits below them, where no class driver or
+// interceptor can get around it; only the workarounds for the device's
+// quirks are closer to the host.
+//
+// A Device is not safe for concurrent use.
+type Device struct {
+	host         Host
+	interceptors []Interceptor
+	// builders[i], if set, builds interceptors[i] for a device, so that
+	// ResetAndReacquire can build it again for the new one.
+	builders   []func(*Device) Interceptor
+	safety     Interceptor
+	safetyOpts SafetyOptions
+	quirks     QuirkEntry
+	quirksSet  bool // by SetQuirks rather than looked up
+	transport  Transport
+
+	descriptor DeviceDescriptor
+	configs    []Configuration
+	active     uint8
+	opened     bool
+	claimed    map[uint8]uint8  // interface number -> alternate setting
+	streams    map[uint8]uint32 // endpoint address -> number of streams
+
+	policies      map[uint8]RetryPolicy
+	defaultPolicy RetryPolicy
+	classReset    func() error
+	inClassReset  bool
+	hooks         []RecoveryHook
+	sleep         func(time.Duration)
+	now           func() time.Time
+}
+
+var _ Transport = (*Device)(nil)
+
+// NewDevice reads the descriptors and current state of host and returns a
+// Device guarding it. Calls are passed through interceptors, outermost first,
+// before they reach the host.
+func NewDevice(host Host, interceptors ...Interceptor) *Device {
+	d := &Device{
+		host:       host,
+		descriptor: host.Descriptor(),
+		configs:    host.Configurations(),
+		active:     host.ActiveConfiguration(),
+		opened:     host.Opened(),
+		claimed:    make(map[uint8]uint8),
+		streams:    make(map[uint8]uint32),
+		policies:   make(map[uint8]RetryPolicy),
+		sleep:      time.Sleep,
+		now:        time.Now,
+	}
+	d.quirks = DefaultQuirks.Lookup(d.descriptor.VendorID, d.descriptor.ProductID)
+	d.correctDescriptors()
+	d.Use(interceptors...)
+	return d
+}
+
+// Use adds interceptors inside the ones already configured, closest to the host.
+func (d *Device) Use(interceptors ...Interceptor) {
+	d.interceptors = append(d.interceptors, interceptors...)
+	d.builders = append(d.builders, make([]func(*Device) Interceptor, len(interceptors))...)
+	d.rebuild()
+}
+
+// useBuilder adds the interceptor build returns for the device, like Use.
+func (d *Device) useBuilder(build func(*Device) Interceptor) {
+	d.interceptors = append(d.interceptors, build(d))
+	d.builders = append(d.builders, build)
+	d.rebuild()
+}
+
+func (d *Device) rebuild() {
+	chain := append([]Interceptor{d.validate, d.retry}, d.interceptors...)
+	if d.safety != nil {
+		chain = append(chain, d.safety)
+	}
+	chain = append(chain, d.quirk)
+	d.transport = Chain(d.host, chain...)
+}
+
+// Host returns the underlying host device. Calls made on it directly bypass
+// the Device, including its safety layer.
+func (d *Device) Host() Host {
+	return d.host
+}
+
+// Descriptor returns the device descriptor.
+func (d *Device) Descriptor() DeviceDescriptor {
+	return d.descriptor
+}
+
+// Speed returns the speed the device is operating at.
+func (d *Device) Speed() Speed {
+	return d.host.Speed()
+}
+
+// Location returns where the device is plugged in, or false if the host
+// does not know.
+func (d *Device) Location() (Location, bool) {
+	if l, ok := d.host.(Locator); ok {
+		return l.Location(), true
+	}
+	return Location{}, false
+}
+
+// Configurations returns all configurations the device supports.
+func (d *Device) Configurations() []Configuration {
+	return d.configs
+}
+
+// ActiveConfiguration returns the active configuration, or false if the
+// device is unconfigured.
+func (d *Device) ActiveConfiguration() (*Configuration, bool) {
+	return d.configuration(d.active)
+}
+
+// Opened reports whether the device is open.
+func (d *Device) Opened() bool {
+	return d.opened
+}
+
+// Claimed returns the alternate setting interface number was claimed with,
+// or false if it is not claimed.
+func (d *Device) Claimed(number uint8) (uint8, bool) {
+	alt, ok := d.claimed[number]
+	return alt, ok
+}
+
+func (d *Device) configuration(number uint8) (*Configuration, bool) {
+	if number == 0 {
+		return nil, false
+	}
+	for i := range d.configs {
+		if d.configs[i].Descriptor.Number == number {
+			return &d.configs[i], true
+		}
+	}
+	return nil, false
+}
+
+// Open opens the device. Opening an open device is a no-op, like on the host.
+func (d *Device) Open() error {
+	return d.transport.Open()
+}
+
+// Close closes the device. Claimed interfaces are released implicitly.
+func (d *Device) Close() error {
+	return d.transport.Close()
+}
+
+// Reset resets the device. The host restores the active configuration and
+// claimed interfaces afterwards, so the tracked state is kept. Devices that
+// re-enumerate after a reset should use ResetAndReacquire instead.
+func (d *Device) Reset() error {
+	return d.transport.Reset()
+}
+
+// SelectConfiguration makes configuration number the active configuration.
+// All interfaces must be released first.
+func (d *Device) SelectConfiguration(number uint8) error {
+	return d.transport.SelectConfiguration(number)
+}
+
+// ClaimInterface claims interface number and selects alternate setting alt.
+func (d *Device) ClaimInterface(number, alt uint8) error {
+	return d.transport.ClaimInterface(number, alt)
+}
+
+// ReleaseInterface releases an interface claimed with alternate setting alt.
+func (d *Device) ReleaseInterface(number, alt uint8) error {
+	return d.transport.ReleaseInterface(number, alt)
+}
+
+// ClearHalt clears a halt condition on an endpoint of a claimed interface.
+func (d *Device) ClearHalt(endpoint uint8) error {
+	return d.transport.ClearHalt(endpoint)
+}
+
+// ReadControl performs an IN control transfer on the default endpoint.
+func (d *Device) ReadControl(setup ControlSetup, length uint16) ([]byte, error) {
+	return d.transport.ReadControl(setup, length)
+}
+
+// WriteControl performs an OUT control transfer on the default endpoint.
+func (d *Device) WriteControl(setup ControlSetup, data []byte) (int, error) {
+	return d.transport.WriteControl(setup, data)
+}
+
+// ReadInterrupt reads up to length bytes from an interrupt IN endpoint.
+func (d *Device) ReadInterrupt(endpoint uint8, length int) ([]byte, error) {
+	return d.transport.ReadInterrupt(endpoint, length)
+}
+
+// WriteInterrupt writes data to an interrupt OUT endpoint.
+func (d *Device) WriteInterrupt(endpoint uint8, data []byte) (int, error) {
+	return d.transport.WriteInterrupt(endpoint, data)
+}
+
+// ReadBulk reads up to length bytes from a bulk IN endpoint.
+func (d *Device) ReadBulk(endpoint uint8, length int) ([]byte, error) {
+	return d.transport.ReadBulk(endpoint, length)
+}
+
+// WriteBulk writes data to a bulk OUT endpoint.
+func (d *Device) WriteBulk(endpoint uint8, data []byte) (int, error) {
+	return d.transport.WriteBulk(endpoint, data)
+}
+
+// ReadIsochronous reads a single packet from an isochronous IN endpoint.
+func (d *Device) ReadIsochronous(endpoint uint8) ([]byte, error) {
+	return d.transport.ReadIsochronous(endpoint)
+}
+
+// WriteIsochronous writes a single packet to an isochronous OUT endpoint.
+func (d *Device) WriteIsochronous(endpoint uint8, data []byte) (int, error) {
+	return d.transport.WriteIsochronous(endpoint, data)
+}
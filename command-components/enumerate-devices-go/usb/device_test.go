@@ -0,0 +1,230 @@
+package usb
+
+import (
+	"errors"
+	"testing"
+)
+
+type step func(d *Device) error
+
+func open(d *Device) error { return d.Open() }
+
+func closeDevice(d *Device) error { return d.Close() }
+
+func selectConfig(n uint8) step {
+	return func(d *Device) error { return d.SelectConfiguration(n) }
+}
+
+func claim(number, alt uint8) step {
+	return func(d *Device) error { return d.ClaimInterface(number, alt) }
+}
+
+func release(number, alt uint8) step {
+	return func(d *Device) error { return d.ReleaseInterface(number, alt) }
+}
+
+func readBulk(ep uint8) step {
+	return func(d *Device) error { _, err := d.ReadBulk(ep, 64); return err }
+}
+
+func writeBulk(ep uint8) step {
+	return func(d *Device) error { _, err := d.WriteBulk(ep, []byte{1, 2, 3}); return err }
+}
+
+func readInterrupt(ep uint8) step {
+	return func(d *Device) error { _, err := d.ReadInterrupt(ep, 8); return err }
+}
+
+func writeInterrupt(ep uint8) step {
+	return func(d *Device) error { _, err := d.WriteInterrupt(ep, []byte{1}); return err }
+}
+
+func readIso(ep uint8) step {
+	return func(d *Device) error { _, err := d.ReadIsochronous(ep); return err }
+}
+
+func writeIso(ep uint8) step {
+	return func(d *Device) error { _, err := d.WriteIsochronous(ep, []byte{1}); return err }
+}
+
+func clearHalt(ep uint8) step {
+	return func(d *Device) error { return d.ClearHalt(ep) }
+}
+
+func readControl(d *Device) error {
+	_, err := d.ReadControl(ControlSetup{Request: 0x06, Value: 0x0100}, 18)
+	return err
+}
+
+func writeControl(d *Device) error {
+	_, err := d.WriteControl(ControlSetup{Request: 0x09, Value: 1}, nil)
+	return err
+}
+
+func reset(d *Device) error { return d.Reset() }
+
+func TestDeviceStateMachine(t *testing.T) {
+	opened := []step{open}
+	claimed := []step{open, claim(0, 0)}
+	claimedAlt := []step{open, claim(0, 1)}
+	claimedBoth := []step{open, claim(0, 0), claim(1, 0)}
+
+	tests := []struct {
+		name  string
+		setup []step
+		call  step
+		want  error  // nil if the call must succeed
+		host  string // the call that must reach the host, if it succeeds
+	}{
+		// open/close/reset
+		{"open", nil, open, nil, "open"},
+		{"open twice", opened, open, nil, ""},
+		{"close before open", nil, closeDevice, ErrNotOpen, ""},
+		{"close", opened, closeDevice, nil, "close"},
+		{"close twice", []step{open, closeDevice}, closeDevice, ErrNotOpen, ""},
+		{"reset before open", nil, reset, ErrNotOpen, ""},
+		{"reset", opened, reset, nil, "reset"},
+		{"reset after close", []step{open, closeDevice}, reset, ErrNotOpen, ""},
+
+		// configurations
+		{"select before open", nil, selectConfig(2), ErrNotOpen, ""},
+		{"select unknown", opened, selectConfig(3), ErrUnknownConfiguration, ""},
+		{"select zero", opened, selectConfig(0), ErrUnknownConfiguration, ""},
+		{"select", opened, selectConfig(2), nil, "select-configuration(2)"},
+		{"select with claimed interface", claimed, selectConfig(2), ErrInterfacesClaimed, ""},
+		{"select after release", []step{open, claim(0, 0), release(0, 0)}, selectConfig(2), nil, "select-configuration(2)"},
+
+		// interfaces
+		{"claim before open", nil, claim(0, 0), ErrNotOpen, ""},
+		{"claim", opened, claim(0, 0), nil, "claim-interface(0,0)"},
+		{"claim alternate", opened, claim(0, 1), nil, "claim-interface(0,1)"},
+		{"claim unknown interface", opened, claim(2, 0), ErrUnknownInterface, ""},
+		{"claim unknown alternate", opened, claim(1, 1), ErrUnknownInterface, ""},
+		{"claim interface of other configuration", []step{open, selectConfig(2)}, claim(1, 0), ErrUnknownInterface, ""},
+		{"claim twice", claimed, claim(0, 0), ErrInterfaceClaimed, ""},
+		{"claim other alternate of claimed", claimed, claim(0, 1), ErrInterfaceClaimed, ""},
+		{"claim after close", []step{open, claim(0, 0), closeDevice, open}, claim(0, 0), nil, "claim-interface(0,0)"},
+		{"release before open", nil, release(0, 0), ErrNotOpen, ""},
+		{"release unclaimed", opened, release(0, 0), ErrInterfaceNotClaimed, ""},
+		{"release", claimedAlt, release(0, 1), nil, "release-interface(0,1)"},
+		{"release wrong alternate", claimedAlt, release(0, 0), ErrInterfaceNotClaimed, ""},
+		{"release twice", []step{open, claim(0, 0), release(0, 0)}, release(0, 0), ErrInterfaceNotClaimed, ""},
+
+		// control transfers only need an open device
+		{"read control before open", nil, readControl, ErrNotOpen, ""},
+		{"read control", opened, readControl, nil, "read-control(0x06)"},
+		{"write control before open", nil, writeControl, ErrNotOpen, ""},
+		{"write control", opened, writeControl, nil, "write-control(0x09)"},
+
+		// bulk
+		{"read bulk before open", nil, readBulk(0x81), ErrNotOpen, ""},
+		{"read bulk before claim", opened, readBulk(0x81), ErrUnknownEndpoint, ""},
+		{"read bulk", claimed, readBulk(0x81), nil, "read-bulk(0x81)"},
+		{"read bulk on out endpoint", claimed, readBulk(0x02), ErrWrongDirection, ""},
+		{"read bulk on interrupt endpoint", claimed, readBulk(0x83), ErrWrongTransferType, ""},
+		{"read bulk on unknown endpoint", claimed, readBulk(0x8f), ErrUnknownEndpoint, ""},
+		{"read bulk on other alternate", claimedAlt, readBulk(0x81), ErrUnknownEndpoint, ""},
+		{"read bulk after release", []step{open, claim(0, 0), release(0, 0)}, readBulk(0x81), ErrUnknownEndpoint, ""},
+		{"read bulk after close", []step{open, claim(0, 0), closeDevice}, readBulk(0x81), ErrNotOpen, ""},
+		{"read bulk unconfigured", []step{func(d *Device) error { d.active = 0; return nil }, open}, readBulk(0x81), ErrNotConfigured, ""},
+		{"write bulk before claim", opened, writeBulk(0x02), ErrUnknownEndpoint, ""},
+		{"write bulk", claimed, writeBulk(0x02), nil, "write-bulk(0x02)"},
+		{"write bulk on in endpoint", claimed, writeBulk(0x81), ErrWrongDirection, ""},
+		{"write bulk on other configuration", []step{open, selectConfig(2), claim(0, 0)}, writeBulk(0x02), ErrUnknownEndpoint, ""},
+		{"write bulk after select", []step{open, selectConfig(2), claim(0, 0)}, writeBulk(0x01), nil, "write-bulk(0x01)"},
+
+		// interrupt
+		{"read interrupt", claimed, readInterrupt(0x83), nil, "read-interrupt(0x83)"},
+		{"read interrupt on bulk endpoint", claimed, readInterrupt(0x81), ErrWrongTransferType, ""},
+		{"read interrupt on second interface", claimedBoth, readInterrupt(0x86), nil, "read-interrupt(0x86)"},
+		{"read interrupt on unclaimed interface", claimed, readInterrupt(0x86), ErrUnknownEndpoint, ""},
+		{"write interrupt", claimedBoth, writeInterrupt(0x07), nil, "write-interrupt(0x07)"},
+		{"write interrupt on in endpoint", claimedBoth, writeInterrupt(0x86), ErrWrongDirection, ""},
+		{"write interrupt on bulk endpoint", claimed, writeInterrupt(0x02), ErrWrongTransferType, ""},
+
+		// isochronous
+		{"read isochronous", claimedAlt, readIso(0x84), nil, "read-isochronous(0x84)"},
+		{"read isochronous on default alternate", claimed, readIso(0x84), ErrUnknownEndpoint, ""},
+		{"read isochronous on bulk endpoint", claimed, readIso(0x81), ErrWrongTransferType, ""},
+		{"write isochronous", claimedAlt, writeIso(0x05), nil, "write-isochronous(0x05)"},
+		{"write isochronous on in endpoint", claimedAlt, writeIso(0x84), ErrWrongDirection, ""},
+
+		// clear halt
+		{"clear halt before open", nil, clearHalt(0x81), ErrNotOpen, ""},
+		{"clear halt on default endpoint", claimed, clearHalt(0x00), ErrDefaultEndpoint, ""},
+		{"clear halt on default in endpoint", claimed, clearHalt(0x80), ErrDefaultEndpoint, ""},
+		{"clear halt before claim", opened, clearHalt(0x81), ErrUnknownEndpoint, ""},
+		{"clear halt", claimed, clearHalt(0x81), nil, "clear-halt(0x81)"},
+		{"clear halt on out endpoint", claimed, clearHalt(0x02), nil, "clear-halt(0x02)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := newFakeHost()
+			d := NewDevice(host)
+			for i, s := range tt.setup {
+				if err := s(d); err != nil {
+					t.Fatalf("setup step %d: %v", i, err)
+				}
+			}
+			before := len(host.calls)
+
+			err := tt.call(d)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+			calls := host.calls[before:]
+			if tt.want != nil {
+				var se *StateError
+				if !errors.As(err, &se) {
+					t.Fatalf("error %v is not a *StateError", err)
+				}
+				if len(calls) != 0 {
+					t.Fatalf("rejected call reached the host: %v", calls)
+				}
+				return
+			}
+			switch {
+			case tt.host == "" && len(calls) != 0:
+				t.Fatalf("expected no host call, got %v", calls)
+			case tt.host != "" && (len(calls) != 1 || calls[0] != tt.host):
+				t.Fatalf("host calls %v, want [%s]", calls, tt.host)
+			}
+		})
+	}
+}
+
+func TestStateErrorMessage(t *testing.T) {
+	d := NewDevice(newFakeHost())
+	d.Open()
+	d.ClaimInterface(0, 0)
+
+	_, err := d.ReadBulk(0x83, 8)
+	want := "usb: read-bulk: wrong transfer type: endpoint 0x83 is an interrupt endpoint"
+	if err == nil || err.Error() != want {
+		t.Fatalf("got %q, want %q", err, want)
+	}
+
+	err = d.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.Reset()
+	if err == nil || err.Error() != "usb: reset: device not open" {
+		t.Fatalf("got %q", err)
+	}
+}
+
+func TestNewDeviceAdoptsHostState(t *testing.T) {
+	host := newFakeHost()
+	host.opened = true
+	host.active = 2
+	d := NewDevice(host)
+	if !d.Opened() {
+		t.Fatal("device should be open")
+	}
+	cfg, ok := d.ActiveConfiguration()
+	if !ok || cfg.Descriptor.Number != 2 {
+		t.Fatalf("active configuration = %v, %v", cfg, ok)
+	}
+}
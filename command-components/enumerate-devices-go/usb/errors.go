@@ -0,0 +1,59 @@
+package usb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by Device when a call is made in the wrong state.
+// They are always wrapped in a *StateError carrying the operation and details.
+var (
+	ErrNotOpen              = errors.New("device not open")
+	ErrOpen                 = errors.New("device is open")
+	ErrNotConfigured        = errors.New("device not configured")
+	ErrUnknownConfiguration = errors.New("no such configuration")
+	ErrUnknownInterface     = errors.New("no such interface")
+	ErrInterfaceClaimed     = errors.New("interface already claimed")
+	ErrInterfaceNotClaimed  = errors.New("interface not claimed")
+	ErrInterfacesClaimed    = errors.New("interfaces still claimed")
+	ErrUnknownEndpoint      = errors.New("endpoint not in a claimed interface")
+	ErrWrongTransferType    = errors.New("wrong transfer type")
+	ErrWrongDirection       = errors.New("wrong endpoint direction")
+	ErrDefaultEndpoint      = errors.New("operation not valid on the default control endpoint")
+	ErrReadOnly             = errors.New("device is read-only")
+	ErrNotSupported         = errors.New("operation not supported")
+	ErrNoStreams            = errors.New("no streams allocated")
+)
+
+// Errors a Host reports for failed transfers. The current WIT interface has
+// no error type and traps instead, so these only come from Host
+// implementations that can observe the failure.
+var (
+	ErrStall    = errors.New("endpoint stalled")
+	ErrTimeout  = errors.New("transfer timed out")
+	ErrOverflow = errors.New("transfer overflow (babble)")
+	ErrNoDevice = errors.New("no such device")
+	ErrIO       = errors.New("input/output error")
+)
+
+// StateError reports a call that was rejected before reaching the host.
+type StateError struct {
+	Op     string // WIT function name, e.g. "read-bulk"
+	Err    error  // ErrNotOpen, ErrWrongDirection, ...
+	Detail string // optional, e.g. "endpoint 0x81 is an interrupt endpoint"
+}
+
+func (e *StateError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("usb: %s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("usb: %s: %v: %s", e.Op, e.Err, e.Detail)
+}
+
+func (e *StateError) Unwrap() error {
+	return e.Err
+}
+
+func stateError(op string, err error, format string, args ...any) error {
+	return &StateError{Op: op, Err: err, Detail: fmt.Sprintf(format, args...)}
+}
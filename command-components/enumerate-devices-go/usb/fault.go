@@ -0,0 +1,273 @@
+package usb
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+)
+
+// FaultKind is a kind of fault injected by a FaultInjector.
+type FaultKind uint8
+
+const (
+	// FaultStall fails the transfer with ErrStall without reaching the device.
+	FaultStall FaultKind = iota
+	// FaultTimeout fails the transfer with ErrTimeout without reaching the device.
+	FaultTimeout
+	// FaultShortRead performs a read and returns only part of the data.
+	FaultShortRead
+	// FaultOverflow performs a read and fails it with ErrOverflow, as if the
+	// device sent more data than requested.
+	FaultOverflow
+	// FaultDisconnect transfers part of the data and then fails with
+	// ErrNoDevice. Every later call fails with ErrNoDevice as well, until
+	// FaultInjector.Reconnect is called.
+	FaultDisconnect
+	// FaultCorrupt flips bits in the data read or written.
+	FaultCorrupt
+)
+
+func (k FaultKind) String() string {
+	switch k {
+	case FaultStall:
+		return "stall"
+	case FaultTimeout:
+		return "timeout"
+	case FaultShortRead:
+		return "short-read"
+	case FaultOverflow:
+		return "overflow"
+	case FaultDisconnect:
+		return "disconnect"
+	case FaultCorrupt:
+		return "corrupt"
+	}
+	return fmt.Sprintf("FaultKind(%d)", uint8(k))
+}
+
+// FaultRule describes when to inject a fault. A rule fires on a call if Match
+// accepts it, it is the Nth matching call (if Nth is set), the rule has fired
+// fewer than Times times (if Times is set), and a random draw succeeds with
+// Probability (if Probability is set). The first rule that fires wins.
+type FaultRule struct {
+	Kind FaultKind
+	// Match selects the calls the rule applies to. Nil matches every transfer.
+	Match func(c *Call) bool
+	// Nth restricts the rule to the Nth matching call, counting from 1.
+	Nth int
+	// Times limits how often the rule fires. Zero means no limit.
+	Times int
+	// Probability is the chance that the rule fires on a matching call.
+	// Zero means always.
+	Probability float64
+	// Length is the number of bytes transferred by short reads and before a
+	// disconnect. Zero means half of the data.
+	Length int
+	// Bytes is the number of bytes changed by FaultCorrupt. Zero means one.
+	Bytes int
+}
+
+// OnEndpoint matches transfers on the given endpoint address. Control
+// transfers use 0x00 and 0x80.
+func OnEndpoint(address uint8) func(c *Call) bool {
+	return func(c *Call) bool { return c.IsTransfer() && c.Endpoint == address }
+}
+
+// OnOp matches calls with one of the given operations, e.g. OpReadBulk.
+func OnOp(ops ...string) func(c *Call) bool {
+	return func(c *Call) bool { return slices.Contains(ops, c.Op) }
+}
+
+// OnRequest matches control transfers with the given type and bRequest.
+func OnRequest(typ ControlType, request uint8) func(c *Call) bool {
+	return func(c *Call) bool {
+		tt, _ := c.TransferType()
+		return c.IsTransfer() && tt == TransferControl && c.Setup.Type == typ && c.Setup.Request == request
+	}
+}
+
+// InjectedFault records a fault injected by a FaultInjector.
+type InjectedFault struct {
+	Kind FaultKind
+	Call Call
+}
+
+// FaultInjector injects faults into calls according to its rules. Random
+// draws come from a generator seeded at construction, so a run can be
+// reproduced by reusing the seed.
+type FaultInjector struct {
+	mu           sync.Mutex
+	rules        []*faultRule
+	rng          *rand.Rand
+	disconnected bool
+	injected     []InjectedFault
+}
+
+type faultRule struct {
+	FaultRule
+	matched int
+	fired   int
+}
+
+// NewFaultInjector returns an injector with the given seed and rules.
+func NewFaultInjector(seed uint64, rules ...FaultRule) *FaultInjector {
+	f := &FaultInjector{rng: rand.New(rand.NewPCG(seed, seed))}
+	for _, r := range rules {
+		f.Add(r)
+	}
+	return f
+}
+
+// InjectFaults adds a fault injector to the device and returns it. Faults
+// are injected below the retry policies, so they exercise the same recovery
+// paths as real device errors.
+func (d *Device) InjectFaults(seed uint64, rules ...FaultRule) *FaultInjector {
+	f := NewFaultInjector(seed, rules...)
+	d.Use(f.Interceptor())
+	return f
+}
+
+// Add appends a rule.
+func (f *FaultInjector) Add(rule FaultRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, &faultRule{FaultRule: rule})
+}
+
+// Reconnect ends a simulated disconnection.
+func (f *FaultInjector) Reconnect() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.disconnected = false
+}
+
+// Injected returns the faults injected so far.
+func (f *FaultInjector) Injected() []InjectedFault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.injected)
+}
+
+// Interceptor returns the interceptor that injects the faults.
+func (f *FaultInjector) Interceptor() Interceptor {
+	return Around(func(c *Call, invoke func() error) error {
+		rule, disconnected := f.pick(c)
+		if disconnected {
+			return injected(ErrNoDevice)
+		}
+		if rule == nil {
+			return invoke()
+		}
+		return f.inject(rule, c, invoke)
+	})
+}
+
+// pick returns the rule that fires on c, if any.
+func (f *FaultInjector) pick(c *Call) (*faultRule, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.disconnected {
+		return nil, c.Op != OpClose
+	}
+	// Every rule that matches counts the call, even past the one that
+	// fires, so that Nth counts all matching calls.
+	var winner *faultRule
+	for _, r := range f.rules {
+		if r.Match == nil && !c.IsTransfer() || r.Match != nil && !r.Match(c) {
+			continue
+		}
+		r.matched++
+		if winner != nil {
+			continue
+		}
+		if r.Nth != 0 && r.matched != r.Nth || r.Times != 0 && r.fired >= r.Times {
+			continue
+		}
+		if r.Probability != 0 && f.rng.Float64() >= r.Probability {
+			continue
+		}
+		winner = r
+	}
+	if winner == nil {
+		return nil, false
+	}
+	winner.fired++
+	if winner.Kind == FaultDisconnect {
+		f.disconnected = true
+	}
+	f.injected = append(f.injected, InjectedFault{Kind: winner.Kind, Call: *c})
+	return winner, false
+}
+
+func (f *FaultInjector) inject(r *faultRule, c *Call, invoke func() error) error {
+	read := c.Direction() == DirectionIn
+	switch r.Kind {
+	case FaultStall:
+		return injected(ErrStall)
+	case FaultTimeout:
+		return injected(ErrTimeout)
+	case FaultShortRead:
+		if !read {
+			return invoke()
+		}
+		if err := invoke(); err != nil {
+			return err
+		}
+		c.Data = c.Data[:r.length(len(c.Data))]
+		c.Actual = len(c.Data)
+		return nil
+	case FaultOverflow:
+		if read {
+			invoke()
+		}
+		return injected(ErrOverflow)
+	case FaultDisconnect:
+		if read {
+			invoke()
+			c.Data = c.Data[:r.length(len(c.Data))]
+			c.Actual = len(c.Data)
+		} else if len(c.Data) > 0 {
+			c.Data = c.Data[:r.length(len(c.Data))]
+			invoke()
+		}
+		return injected(ErrNoDevice)
+	case FaultCorrupt:
+		if read {
+			err := invoke()
+			c.Data = f.corrupt(c.Data, r.Bytes)
+			return err
+		}
+		c.Data = f.corrupt(c.Data, r.Bytes)
+		return invoke()
+	}
+	return invoke()
+}
+
+func (r *faultRule) length(n int) int {
+	if r.Length == 0 {
+		return n / 2
+	}
+	return min(r.Length, n)
+}
+
+// corrupt returns a copy of data with n bytes changed.
+func (f *FaultInjector) corrupt(data []byte, n int) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	if n == 0 {
+		n = 1
+	}
+	data = slices.Clone(data)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for range n {
+		data[f.rng.IntN(len(data))] ^= byte(1 + f.rng.IntN(255))
+	}
+	return data
+}
+
+func injected(err error) error {
+	return fmt.Errorf("%w (injected)", err)
+}
@@ -0,0 +1,172 @@
+package usb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func newFaultDevice(t *testing.T, rules ...FaultRule) (*Device, *fakeHost, *FaultInjector) {
+	t.Helper()
+	host := newFakeHost()
+	host.read = func(endpoint uint8, length int) []byte {
+		data := make([]byte, length)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		return data
+	}
+	d := NewDevice(host)
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ClaimInterface(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	f := d.InjectFaults(1, rules...)
+	host.calls = nil
+	return d, host, f
+}
+
+func TestFaultNthCall(t *testing.T) {
+	d, host, f := newFaultDevice(t, FaultRule{Kind: FaultStall, Match: OnEndpoint(0x81), Nth: 2})
+	for i, want := range []error{nil, ErrStall, nil} {
+		if _, err := d.ReadBulk(0x81, 8); !errors.Is(err, want) && (want != nil || err != nil) {
+			t.Fatalf("call %d: got %v, want %v", i+1, err, want)
+		}
+	}
+	// Other endpoints are not affected.
+	if _, err := d.WriteBulk(0x02, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := host.Calls(); got != "read-bulk(0x81) read-bulk(0x81) write-bulk(0x02)" {
+		t.Fatalf("host calls %q", got)
+	}
+	if inj := f.Injected(); len(inj) != 1 || inj[0].Kind != FaultStall || inj[0].Call.Op != OpReadBulk {
+		t.Fatalf("injected %+v", inj)
+	}
+}
+
+// A rule counts the calls it matches even when an earlier rule fires.
+func TestFaultOverlappingRules(t *testing.T) {
+	d, _, f := newFaultDevice(t,
+		FaultRule{Kind: FaultTimeout, Match: OnEndpoint(0x81), Times: 1},
+		FaultRule{Kind: FaultStall, Match: OnEndpoint(0x81), Nth: 2},
+	)
+	for i, want := range []error{ErrTimeout, ErrStall, nil} {
+		if _, err := d.ReadBulk(0x81, 8); !errors.Is(err, want) && (want != nil || err != nil) {
+			t.Fatalf("call %d: got %v, want %v", i+1, err, want)
+		}
+	}
+	if inj := f.Injected(); len(inj) != 2 || inj[0].Kind != FaultTimeout || inj[1].Kind != FaultStall {
+		t.Fatalf("injected %+v", inj)
+	}
+}
+
+func TestFaultKinds(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     FaultRule
+		read     bool
+		wantErr  error
+		wantData []byte
+	}{
+		{"timeout", FaultRule{Kind: FaultTimeout}, true, ErrTimeout, nil},
+		{"short read", FaultRule{Kind: FaultShortRead, Length: 3}, true, nil, []byte{0, 1, 2}},
+		{"short read default", FaultRule{Kind: FaultShortRead}, true, nil, []byte{0, 1, 2, 3}},
+		{"overflow", FaultRule{Kind: FaultOverflow}, true, ErrOverflow, nil},
+		{"disconnect read", FaultRule{Kind: FaultDisconnect, Length: 2}, true, ErrNoDevice, []byte{0, 1}},
+		{"disconnect write", FaultRule{Kind: FaultDisconnect}, false, ErrNoDevice, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, _, _ := newFaultDevice(t, tt.rule)
+			var err error
+			var data []byte
+			if tt.read {
+				data, err = d.ReadBulk(0x81, 8)
+			} else {
+				_, err = d.WriteBulk(0x02, make([]byte, 8))
+			}
+			if !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantData != nil && !bytes.Equal(data, tt.wantData) {
+				t.Fatalf("got data %v, want %v", data, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestFaultDisconnectIsSticky(t *testing.T) {
+	d, host, f := newFaultDevice(t, FaultRule{Kind: FaultDisconnect, Match: OnOp(OpWriteBulk), Length: 2})
+	n, err := d.WriteBulk(0x02, []byte{1, 2, 3, 4})
+	if n != 2 || !errors.Is(err, ErrNoDevice) {
+		t.Fatalf("write = %d, %v", n, err)
+	}
+	if _, err := d.ReadBulk(0x81, 8); !errors.Is(err, ErrNoDevice) {
+		t.Fatalf("read after disconnect: %v", err)
+	}
+	if err := d.Reset(); !errors.Is(err, ErrNoDevice) {
+		t.Fatalf("reset after disconnect: %v", err)
+	}
+	f.Reconnect()
+	if _, err := d.ReadBulk(0x81, 8); err != nil {
+		t.Fatal(err)
+	}
+	if got := host.Calls(); got != "write-bulk(0x02) read-bulk(0x81)" {
+		t.Fatalf("host calls %q", got)
+	}
+}
+
+func TestFaultCorruptAndRequest(t *testing.T) {
+	d, _, _ := newFaultDevice(t,
+		FaultRule{Kind: FaultStall, Match: OnRequest(ControlClass, 0xfe), Times: 1},
+		FaultRule{Kind: FaultCorrupt, Match: OnOp(OpReadBulk), Bytes: 2},
+	)
+	setup := ControlSetup{Type: ControlClass, Recipient: RecipientInterface, Request: 0xfe}
+	if _, err := d.ReadControl(setup, 1); !errors.Is(err, ErrStall) {
+		t.Fatalf("first GET MAX LUN: %v", err)
+	}
+	if _, err := d.ReadControl(setup, 1); err != nil {
+		t.Fatalf("second GET MAX LUN: %v", err)
+	}
+	data, err := d.ReadBulk(0x81, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := 0
+	for i, b := range data {
+		if b != byte(i) {
+			diff++
+		}
+	}
+	if diff == 0 || diff > 2 {
+		t.Fatalf("%d bytes corrupted: %v", diff, data)
+	}
+}
+
+func TestFaultProbabilityIsSeeded(t *testing.T) {
+	run := func() []error {
+		d, _, _ := newFaultDevice(t, FaultRule{Kind: FaultTimeout, Probability: 0.5})
+		var errs []error
+		for range 32 {
+			_, err := d.ReadBulk(0x81, 1)
+			errs = append(errs, err)
+		}
+		return errs
+	}
+	a, b := run(), run()
+	failed := 0
+	for i := range a {
+		if (a[i] == nil) != (b[i] == nil) {
+			t.Fatalf("runs with the same seed differ at call %d", i)
+		}
+		if a[i] != nil {
+			failed++
+		}
+	}
+	if failed == 0 || failed == len(a) {
+		t.Fatalf("%d of %d calls failed", failed, len(a))
+	}
+}
@@ -0,0 +1,65 @@
+package usb
+
+import (
+	"slices"
+	"strconv"
+)
+
+// Host is the raw usb-device resource as exposed by the runtime.
+//
+// Endpoints are addressed by their bEndpointAddress and interfaces by their
+// number and alternate setting; implementations map these back onto the
+// borrowed usb-endpoint and usb-interface handles the WIT functions expect.
+// Host performs no validation of its own: calls made in the wrong state are
+// passed through and typically trap. Use a Device to guard against that.
+type Host interface {
+	Transport
+
+	Descriptor() DeviceDescriptor
+	Speed() Speed
+	Configurations() []Configuration
+	// ActiveConfiguration returns the number of the active configuration,
+	// or 0 if the device is unconfigured.
+	ActiveConfiguration() uint8
+	Opened() bool
+}
+
+// Location is the position of a device in the USB topology: its bus and the
+// port numbers on the path from the root hub down.
+type Location struct {
+	Bus   uint8
+	Ports []uint8
+}
+
+// Locator is implemented by hosts that know where the device is plugged in.
+type Locator interface {
+	Location() Location
+}
+
+// String formats the location like Linux does, e.g. "1-2.4".
+func (l Location) String() string {
+	s := strconv.Itoa(int(l.Bus))
+	for i, p := range l.Ports {
+		if i == 0 {
+			s += "-"
+		} else {
+			s += "."
+		}
+		s += strconv.Itoa(int(p))
+	}
+	return s
+}
+
+// Hub returns the location of the hub the device is connected to. The root
+// hub of a bus has no ports.
+func (l Location) Hub() Location {
+	if len(l.Ports) == 0 {
+		return l
+	}
+	return Location{Bus: l.Bus, Ports: l.Ports[:len(l.Ports)-1]}
+}
+
+// Equal reports whether l and o are the same location.
+func (l Location) Equal(o Location) bool {
+	return l.Bus == o.Bus && slices.Equal(l.Ports, o.Ports)
+}
@@ -0,0 +1,186 @@
+package usb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// testConfigurations describes a device with two configurations:
+//
+//	config 1: interface 0 alt 0: bulk 0x81, bulk 0x02, interrupt 0x83
+//	          interface 0 alt 1: isochronous 0x84, isochronous 0x05
+//	          interface 1 alt 0: interrupt 0x86, interrupt 0x07
+//	config 2: interface 0 alt 0: bulk 0x81, bulk 0x01
+func testConfigurations() []Configuration {
+	ep := func(number uint8, dir Direction, tt TransferType) EndpointDescriptor {
+		return EndpointDescriptor{EndpointNumber: number, Direction: dir, TransferType: tt, MaxPacketSize: 512}
+	}
+	intf := func(number, alt uint8, eps ...EndpointDescriptor) Interface {
+		return Interface{
+			Descriptor: InterfaceDescriptor{InterfaceNumber: number, AlternateSetting: alt},
+			Endpoints:  eps,
+		}
+	}
+	return []Configuration{
+		{
+			Descriptor: ConfigurationDescriptor{Number: 1, MaxPower: 100},
+			Interfaces: []Interface{
+				intf(0, 0, ep(1, DirectionIn, TransferBulk), ep(2, DirectionOut, TransferBulk), ep(3, DirectionIn, TransferInterrupt)),
+				intf(0, 1, ep(4, DirectionIn, TransferIsochronous), ep(5, DirectionOut, TransferIsochronous)),
+				intf(1, 0, ep(6, DirectionIn, TransferInterrupt), ep(7, DirectionOut, TransferInterrupt)),
+			},
+		},
+		{
+			Descriptor: ConfigurationDescriptor{Number: 2, MaxPower: 500},
+			Interfaces: []Interface{
+				intf(0, 0, ep(1, DirectionIn, TransferBulk), ep(1, DirectionOut, TransferBulk)),
+			},
+		},
+	}
+}
+
+// fakeHost is an in-memory Host that records every call that reaches it.
+type fakeHost struct {
+	descriptor DeviceDescriptor
+	configs    []Configuration
+	active     uint8
+	opened     bool
+	calls      []string
+
+	// read, if set, produces the data returned by the Read* calls. Control
+	// reads use endpoint 0x80.
+	read func(endpoint uint8, length int) []byte
+	// fault, if set, is consulted for every recorded call; a non-nil error
+	// fails the call after it has been recorded.
+	fault func(call string) error
+}
+
+func newFakeHost() *fakeHost {
+	return &fakeHost{
+		descriptor: DeviceDescriptor{VendorID: 0x1234, ProductID: 0x5678, SerialNumber: "0001"},
+		configs:    testConfigurations(),
+		active:     1,
+	}
+}
+
+func (h *fakeHost) record(format string, args ...any) error {
+	call := fmt.Sprintf(format, args...)
+	h.calls = append(h.calls, call)
+	if h.fault != nil {
+		return h.fault(call)
+	}
+	return nil
+}
+
+func (h *fakeHost) Calls() string {
+	return strings.Join(h.calls, " ")
+}
+
+func (h *fakeHost) data(endpoint uint8, length int) []byte {
+	if h.read != nil {
+		return h.read(endpoint, length)
+	}
+	return make([]byte, length)
+}
+
+func (h *fakeHost) Descriptor() DeviceDescriptor    { return h.descriptor }
+func (h *fakeHost) Speed() Speed                    { return SpeedHigh }
+func (h *fakeHost) Configurations() []Configuration { return h.configs }
+func (h *fakeHost) ActiveConfiguration() uint8      { return h.active }
+func (h *fakeHost) Opened() bool                    { return h.opened }
+
+func (h *fakeHost) Open() error {
+	if err := h.record("open"); err != nil {
+		return err
+	}
+	h.opened = true
+	return nil
+}
+
+func (h *fakeHost) Close() error {
+	if err := h.record("close"); err != nil {
+		return err
+	}
+	h.opened = false
+	return nil
+}
+
+func (h *fakeHost) Reset() error {
+	return h.record("reset")
+}
+
+func (h *fakeHost) ClearHalt(endpoint uint8) error {
+	return h.record("clear-halt(0x%02x)", endpoint)
+}
+
+func (h *fakeHost) SelectConfiguration(n uint8) error {
+	if err := h.record("select-configuration(%d)", n); err != nil {
+		return err
+	}
+	h.active = n
+	return nil
+}
+
+func (h *fakeHost) ClaimInterface(number, alt uint8) error {
+	return h.record("claim-interface(%d,%d)", number, alt)
+}
+
+func (h *fakeHost) ReleaseInterface(number, alt uint8) error {
+	return h.record("release-interface(%d,%d)", number, alt)
+}
+
+func (h *fakeHost) ReadControl(setup ControlSetup, length uint16) ([]byte, error) {
+	if err := h.record("read-control(0x%02x)", setup.Request); err != nil {
+		return nil, err
+	}
+	return h.data(0x80, int(length)), nil
+}
+
+func (h *fakeHost) WriteControl(setup ControlSetup, data []byte) (int, error) {
+	if err := h.record("write-control(0x%02x)", setup.Request); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (h *fakeHost) ReadInterrupt(endpoint uint8, length int) ([]byte, error) {
+	if err := h.record("read-interrupt(0x%02x)", endpoint); err != nil {
+		return nil, err
+	}
+	return h.data(endpoint, length), nil
+}
+
+func (h *fakeHost) WriteInterrupt(endpoint uint8, data []byte) (int, error) {
+	if err := h.record("write-interrupt(0x%02x)", endpoint); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (h *fakeHost) ReadBulk(endpoint uint8, length int) ([]byte, error) {
+	if err := h.record("read-bulk(0x%02x)", endpoint); err != nil {
+		return nil, err
+	}
+	return h.data(endpoint, length), nil
+}
+
+func (h *fakeHost) WriteBulk(endpoint uint8, data []byte) (int, error) {
+	if err := h.record("write-bulk(0x%02x)", endpoint); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (h *fakeHost) ReadIsochronous(endpoint uint8) ([]byte, error) {
+	if err := h.record("read-isochronous(0x%02x)", endpoint); err != nil {
+		return nil, err
+	}
+	return h.data(endpoint, 512), nil
+}
+
+func (h *fakeHost) WriteIsochronous(endpoint uint8, data []byte) (int, error) {
+	if err := h.record("write-isochronous(0x%02x)", endpoint); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
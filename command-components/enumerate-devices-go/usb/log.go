@@ -0,0 +1,163 @@
+package usb
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// LogOptions configures the Logging interceptor. The zero value logs every
+// call at debug level to slog.Default() without payloads.
+type LogOptions struct {
+	// Logger receives the records. Defaults to slog.Default().
+	Logger *slog.Logger
+	// Level is the level of successful calls. Defaults to slog.LevelDebug.
+	// A *slog.LevelVar allows changing it while the device is in use.
+	Level slog.Leveler
+	// ErrorLevel is the level of failed calls. Defaults to slog.LevelError.
+	ErrorLevel slog.Leveler
+	// EndpointLevels overrides Level for transfers and clear-halts on
+	// specific endpoints. Control transfers use endpoint 0x00.
+	EndpointLevels map[uint8]slog.Level
+	// Filter, if set, is called before every call; calls for which it
+	// returns false are not logged.
+	Filter func(c *Call) bool
+	// PayloadPreview is the maximum number of payload bytes included as hex
+	// when debug logging is enabled. Zero disables payload previews.
+	PayloadPreview int
+}
+
+// Logging returns an interceptor that logs every call with the identity of
+// the device it was made on.
+func Logging(device DeviceDescriptor, opts LogOptions) Interceptor {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if opts.Level == nil {
+		opts.Level = slog.LevelDebug
+	}
+	if opts.ErrorLevel == nil {
+		opts.ErrorLevel = slog.LevelError
+	}
+	logger = logger.With(deviceAttr(device))
+
+	return Around(func(c *Call, invoke func() error) error {
+		if opts.Filter != nil && !opts.Filter(c) {
+			return invoke()
+		}
+		// Capture the request before invoke replaces Data with the result.
+		requested := c.Length
+		written := c.Data
+
+		start := time.Now()
+		err := invoke()
+		elapsed := time.Since(start)
+
+		level := opts.Level.Level()
+		if l, ok := opts.EndpointLevels[endpointKey(c)]; ok && (c.IsTransfer() || c.Op == OpClearHalt) {
+			level = l
+		}
+		if err != nil {
+			level = opts.ErrorLevel.Level()
+		}
+		ctx := context.Background()
+		if !logger.Enabled(ctx, level) {
+			return err
+		}
+
+		attrs := make([]slog.Attr, 0, 8)
+		attrs = append(attrs, slog.String("op", c.Op))
+		switch c.Op {
+		case OpSelectConfiguration:
+			attrs = append(attrs, slog.Int("configuration", int(c.Number)))
+		case OpClaimInterface, OpReleaseInterface:
+			attrs = append(attrs, slog.Int("interface", int(c.Number)), slog.Int("alternate", int(c.Alternate)))
+		case OpClearHalt:
+			attrs = append(attrs, slog.String("endpoint", fmt.Sprintf("0x%02x", c.Endpoint)))
+		case OpAllocStreams:
+			attrs = append(attrs, slog.String("endpoints", fmt.Sprintf("%x", c.Endpoints)), slog.Int("streams", int(c.Streams)))
+		case OpFreeStreams:
+			attrs = append(attrs, slog.String("endpoints", fmt.Sprintf("%x", c.Endpoints)))
+		case OpTransferStreams:
+			attrs = append(attrs, streamsAttr(c.Transfers), slog.Int("actual", c.Actual))
+		case OpLinkPowerStateEnabled, OpSetLinkPowerStateEnabled:
+			attrs = append(attrs, slog.String("state", c.State.String()), slog.Bool("enabled", c.Enabled))
+		}
+		if c.IsTransfer() {
+			attrs = append(attrs, slog.String("endpoint", fmt.Sprintf("0x%02x", c.Endpoint)))
+			if tt, _ := c.TransferType(); tt == TransferControl {
+				attrs = append(attrs, setupAttr(c.Setup, c.Direction()))
+			}
+			attrs = append(attrs, slog.Int("requested", requested), slog.Int("actual", c.Actual))
+		}
+		attrs = append(attrs, slog.Duration("duration", elapsed))
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		if opts.PayloadPreview > 0 && c.IsTransfer() && logger.Enabled(ctx, slog.LevelDebug) {
+			payload := c.Data
+			if c.Direction() == DirectionOut {
+				payload = written
+			}
+			attrs = append(attrs, slog.String("payload", hexPreview(payload, opts.PayloadPreview)))
+		}
+		logger.LogAttrs(ctx, level, "usb "+c.Op, attrs...)
+		return err
+	})
+}
+
+// EnableLogging adds a Logging interceptor for this device. Unlike one
+// added with Use, it is rebuilt for the new descriptor by ResetAndReacquire.
+func (d *Device) EnableLogging(opts LogOptions) {
+	d.useBuilder(func(d *Device) Interceptor { return Logging(d.descriptor, opts) })
+}
+
+func endpointKey(c *Call) uint8 {
+	if c.Endpoint&0x0f == 0 {
+		return 0
+	}
+	return c.Endpoint
+}
+
+func deviceAttr(d DeviceDescriptor) slog.Attr {
+	attrs := []any{
+		slog.String("id", fmt.Sprintf("%04x:%04x", d.VendorID, d.ProductID)),
+	}
+	if d.SerialNumber != "" {
+		attrs = append(attrs, slog.String("serial", d.SerialNumber))
+	}
+	return slog.Group("device", attrs...)
+}
+
+// streamsAttr describes a batch of stream transfers: the endpoint and stream
+// of each, with the length read or written.
+func streamsAttr(transfers []StreamTransfer) slog.Attr {
+	attrs := make([]any, len(transfers))
+	for i, t := range transfers {
+		length := t.Length
+		if EndpointDirection(t.Endpoint) == DirectionOut {
+			length = len(t.Data)
+		}
+		attrs[i] = slog.String(fmt.Sprint(i), fmt.Sprintf("0x%02x/%d:%d", t.Endpoint, t.StreamID, length))
+	}
+	return slog.Group("transfers", attrs...)
+}
+
+func setupAttr(s ControlSetup, dir Direction) slog.Attr {
+	return slog.Group("setup",
+		slog.String("bmRequestType", fmt.Sprintf("0x%02x", s.RequestType(dir))),
+		slog.String("bRequest", fmt.Sprintf("0x%02x", s.Request)),
+		slog.String("wValue", fmt.Sprintf("0x%04x", s.Value)),
+		slog.String("wIndex", fmt.Sprintf("0x%04x", s.Index)),
+	)
+}
+
+func hexPreview(data []byte, max int) string {
+	if len(data) <= max {
+		return hex.EncodeToString(data)
+	}
+	return hex.EncodeToString(data[:max]) + "..."
+}
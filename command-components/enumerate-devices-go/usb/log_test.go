@@ -0,0 +1,109 @@
+package usb
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// testLogger writes text records without the time and duration, which vary.
+func testLogger(buf *bytes.Buffer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey || a.Key == "duration" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
+
+func lines(buf *bytes.Buffer) []string {
+	return strings.Split(strings.TrimSpace(buf.String()), "\n")
+}
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	host := newFakeHost()
+	host.read = func(endpoint uint8, length int) []byte {
+		return []byte{0x55, 0x53, 0x42, 0x53, 0x01, 0x02}[:length]
+	}
+	d := NewDevice(host)
+	d.EnableLogging(LogOptions{Logger: testLogger(&buf, slog.LevelDebug), PayloadPreview: 4})
+
+	d.Open()
+	d.ClaimInterface(0, 0)
+	d.ReadControl(ControlSetup{Type: ControlClass, Recipient: RecipientInterface, Request: 0xfe}, 1)
+	d.ReadBulk(0x81, 6)
+	d.WriteBulk(0x02, []byte{0xde, 0xad})
+
+	want := []string{
+		`level=DEBUG msg="usb open" device.id=1234:5678 device.serial=0001 op=open`,
+		`level=DEBUG msg="usb claim-interface" device.id=1234:5678 device.serial=0001 op=claim-interface interface=0 alternate=0`,
+		`level=DEBUG msg="usb read-control" device.id=1234:5678 device.serial=0001 op=read-control endpoint=0x80 setup.bmRequestType=0xa1 setup.bRequest=0xfe setup.wValue=0x0000 setup.wIndex=0x0000 requested=1 actual=1 payload=55`,
+		`level=DEBUG msg="usb read-bulk" device.id=1234:5678 device.serial=0001 op=read-bulk endpoint=0x81 requested=6 actual=6 payload=55534253...`,
+		`level=DEBUG msg="usb write-bulk" device.id=1234:5678 device.serial=0001 op=write-bulk endpoint=0x02 requested=2 actual=2 payload=dead`,
+	}
+	got := lines(&buf)
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines:\n%s", len(got), buf.String())
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d:\ngot  %s\nwant %s", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLoggingLevelsAndFilters(t *testing.T) {
+	var buf bytes.Buffer
+	host := newFakeHost()
+	host.fault = always(ErrStall, "read-interrupt(0x83)")
+	d := NewDevice(host)
+	level := new(slog.LevelVar)
+	level.Set(slog.LevelInfo)
+	d.EnableLogging(LogOptions{
+		Logger:         testLogger(&buf, slog.LevelInfo),
+		Level:          level,
+		ErrorLevel:     slog.LevelWarn,
+		EndpointLevels: map[uint8]slog.Level{0x81: slog.LevelDebug},
+		Filter:         func(c *Call) bool { return c.Op != OpClaimInterface },
+		PayloadPreview: 16,
+	})
+
+	d.Open()
+	d.ClaimInterface(0, 0)
+	d.ReadBulk(0x81, 4)          // below the handler level
+	d.WriteBulk(0x02, []byte{1}) // logged, but payloads are debug only
+	d.ReadInterrupt(0x83, 8)     // fails
+
+	got := lines(&buf)
+	want := []string{
+		`level=INFO msg="usb open"`,
+		`level=INFO msg="usb write-bulk"`,
+		`level=WARN msg="usb read-interrupt"`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines:\n%s", len(got), buf.String())
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("line %d: got %s, want prefix %s", i, got[i], want[i])
+		}
+		if strings.Contains(got[i], "payload=") {
+			t.Errorf("line %d has a payload above debug level: %s", i, got[i])
+		}
+	}
+	if !strings.Contains(got[2], `error="endpoint stalled"`) {
+		t.Errorf("error not logged: %s", got[2])
+	}
+
+	buf.Reset()
+	level.Set(slog.LevelDebug)
+	d.WriteBulk(0x02, []byte{1})
+	if buf.Len() != 0 {
+		t.Fatalf("logged below the handler level: %s", buf.String())
+	}
+}
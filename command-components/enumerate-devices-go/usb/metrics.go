@@ -0,0 +1,369 @@
+package usb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds of the latency histogram buckets.
+var DefaultLatencyBuckets = []time.Duration{
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// Error kinds used in EndpointStats.Errors and the kind label.
+const (
+	ErrorKindStall    = "stall"
+	ErrorKindTimeout  = "timeout"
+	ErrorKindOverflow = "overflow"
+	ErrorKindNoDevice = "no_device"
+	ErrorKindIO       = "io"
+	ErrorKindOther    = "other"
+)
+
+// ErrorKind classifies a transfer error into one of the ErrorKind* constants.
+func ErrorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrStall):
+		return ErrorKindStall
+	case errors.Is(err, ErrTimeout):
+		return ErrorKindTimeout
+	case errors.Is(err, ErrOverflow):
+		return ErrorKindOverflow
+	case errors.Is(err, ErrNoDevice):
+		return ErrorKindNoDevice
+	case errors.Is(err, ErrIO):
+		return ErrorKindIO
+	}
+	return ErrorKindOther
+}
+
+// Histogram is a latency histogram. Counts[i] is the number of observations
+// no larger than Bounds[i] and larger than Bounds[i-1]; the last element of
+// Counts holds the observations above the largest bound.
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+func newHistogram(bounds []time.Duration) Histogram {
+	return Histogram{Bounds: bounds, Counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	i, _ := slices.BinarySearch(h.Bounds, d)
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+}
+
+func (h *Histogram) merge(o Histogram) {
+	for i := range o.Counts {
+		h.Counts[i] += o.Counts[i]
+	}
+	h.Count += o.Count
+	h.Sum += o.Sum
+}
+
+func (h Histogram) clone() Histogram {
+	h.Counts = slices.Clone(h.Counts)
+	return h
+}
+
+// EndpointStats are the counters of one endpoint, or of the whole device.
+type EndpointStats struct {
+	Endpoint  uint8
+	Transfers uint64
+	Bytes     uint64
+	Errors    map[string]uint64 // by ErrorKind
+	Stalls    uint64
+	Timeouts  uint64
+	Latency   Histogram
+}
+
+func newEndpointStats(endpoint uint8, bounds []time.Duration) *EndpointStats {
+	return &EndpointStats{Endpoint: endpoint, Errors: make(map[string]uint64), Latency: newHistogram(bounds)}
+}
+
+func (s *EndpointStats) clone() EndpointStats {
+	c := *s
+	c.Errors = make(map[string]uint64, len(s.Errors))
+	for k, v := range s.Errors {
+		c.Errors[k] = v
+	}
+	c.Latency = s.Latency.clone()
+	return c
+}
+
+// Snapshot is a consistent copy of the metrics of a device.
+type Snapshot struct {
+	Device DeviceDescriptor
+	// Location is where the device is plugged in, such as "1-2.4", or
+	// empty if the host does not know.
+	Location  string
+	Total     EndpointStats
+	Endpoints []EndpointStats // sorted by endpoint address
+}
+
+// Metrics collects per-endpoint transfer statistics of a device. It is safe
+// for concurrent use, so a snapshot can be taken while transfers are running.
+type Metrics struct {
+	mu        sync.Mutex
+	device    DeviceDescriptor
+	location  string
+	bounds    []time.Duration
+	endpoints map[uint8]*EndpointStats
+	now       func() time.Time
+}
+
+// NewMetrics returns empty metrics for device. If no latency buckets are
+// given, DefaultLatencyBuckets are used.
+func NewMetrics(device DeviceDescriptor, buckets ...time.Duration) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	bounds := slices.Clone(buckets)
+	slices.Sort(bounds)
+	return &Metrics{
+		device:    device,
+		bounds:    bounds,
+		endpoints: make(map[uint8]*EndpointStats),
+		now:       time.Now,
+	}
+}
+
+// EnableMetrics adds a metrics interceptor to the device and returns the
+// metrics it collects, with the location of the device if its host is a
+// Locator. The device returned by ResetAndReacquire goes on collecting
+// them, under its new descriptor and location.
+func (d *Device) EnableMetrics(buckets ...time.Duration) *Metrics {
+	m := NewMetrics(d.descriptor, buckets...)
+	d.useBuilder(func(d *Device) Interceptor {
+		m.mu.Lock()
+		m.device, m.location = d.descriptor, ""
+		if l, ok := d.Location(); ok {
+			m.location = l.String()
+		}
+		m.mu.Unlock()
+		return m.Interceptor()
+	})
+	return m
+}
+
+// Interceptor returns the interceptor that feeds m. Only transfers are
+// counted; every attempt of a retried transfer counts separately, and so
+// does every transfer of a batch on bulk streams, with the latency of the
+// batch.
+func (m *Metrics) Interceptor() Interceptor {
+	return Around(func(c *Call, invoke func() error) error {
+		if !c.IsTransfer() && c.Op != OpTransferStreams {
+			return invoke()
+		}
+		start := m.now()
+		err := invoke()
+		latency := m.now().Sub(start)
+		if c.Op != OpTransferStreams {
+			m.record(c.Endpoint, c.Actual, latency, err)
+			return err
+		}
+		for i, t := range c.Transfers {
+			n, terr := 0, err
+			if i < len(c.Results) {
+				n = c.Results[i].Actual
+				if terr == nil && c.Results[i].Status == StreamStalled {
+					terr = ErrStall
+				}
+			}
+			m.record(t.Endpoint, n, latency, terr)
+		}
+		return err
+	})
+}
+
+func (m *Metrics) record(endpoint uint8, n int, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.endpoints[endpoint]
+	if !ok {
+		s = newEndpointStats(endpoint, m.bounds)
+		m.endpoints[endpoint] = s
+	}
+	s.Transfers++
+	if n > 0 {
+		s.Bytes += uint64(n)
+	}
+	s.Latency.observe(latency)
+	if err != nil {
+		kind := ErrorKind(err)
+		s.Errors[kind]++
+		switch kind {
+		case ErrorKindStall:
+			s.Stalls++
+		case ErrorKindTimeout:
+			s.Timeouts++
+		}
+	}
+}
+
+// Snapshot returns a copy of the current metrics.
+func (m *Metrics) Snapshot() Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snap := Snapshot{Device: m.device, Location: m.location, Total: *newEndpointStats(0, m.bounds)}
+	for _, s := range m.endpoints {
+		snap.Endpoints = append(snap.Endpoints, s.clone())
+		snap.Total.Transfers += s.Transfers
+		snap.Total.Bytes += s.Bytes
+		snap.Total.Stalls += s.Stalls
+		snap.Total.Timeouts += s.Timeouts
+		for k, v := range s.Errors {
+			snap.Total.Errors[k] += v
+		}
+		snap.Total.Latency.merge(s.Latency)
+	}
+	slices.SortFunc(snap.Endpoints, func(a, b EndpointStats) int {
+		return int(a.Endpoint) - int(b.Endpoint)
+	})
+	return snap
+}
+
+// Reset clears all counters.
+func (m *Metrics) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.endpoints)
+}
+
+// WritePrometheus writes the metrics of m in the Prometheus text exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	return WritePrometheus(w, m.Snapshot())
+}
+
+// WriteFile writes the metrics to path in the Prometheus text exposition
+// format. The file is replaced atomically, as expected by the node exporter
+// textfile collector. A path of "-" writes to standard output.
+func (m *Metrics) WriteFile(path string) error {
+	if path == "-" {
+		return m.WritePrometheus(os.Stdout)
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := m.WritePrometheus(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// WritePrometheus writes the snapshots of one or more devices in the
+// Prometheus text exposition format. The usb_* families have a series per
+// endpoint; the usb_device_* families hold the totals of each device, so
+// that they need no aggregation. A device is labelled with its vendor and
+// product IDs, its serial number and its location, which tells apart
+// devices without a serial number.
+func WritePrometheus(w io.Writer, snaps ...Snapshot) error {
+	var endpoints, devices []promSeries
+	for i := range snaps {
+		snap := &snaps[i]
+		labels := promLabels(snap)
+		devices = append(devices, promSeries{labels, &snap.Total})
+		for j := range snap.Endpoints {
+			s := &snap.Endpoints[j]
+			endpoints = append(endpoints, promSeries{fmt.Sprintf(`%s,endpoint="0x%02x"`, labels, s.Endpoint), s})
+		}
+	}
+	bw := bufio.NewWriter(w)
+	writeFamilies(bw, "usb_", "", endpoints)
+	writeFamilies(bw, "usb_device_", " All endpoints of the device.", devices)
+	return bw.Flush()
+}
+
+// promSeries is the stats of one endpoint or device, with their labels.
+type promSeries struct {
+	labels string
+	stats  *EndpointStats
+}
+
+// writeFamilies writes the metric families of series, named with prefix
+// and with note appended to their help.
+func writeFamilies(bw *bufio.Writer, prefix, note string, series []promSeries) {
+	family := func(name, typ, help string, each func(labels string, s *EndpointStats)) {
+		name = prefix + name
+		fmt.Fprintf(bw, "# HELP %s %s%s\n# TYPE %s %s\n", name, help, note, name, typ)
+		for _, ps := range series {
+			each(ps.labels, ps.stats)
+		}
+	}
+	counter := func(name, help string, value func(s *EndpointStats) uint64) {
+		family(name, "counter", help, func(labels string, s *EndpointStats) {
+			fmt.Fprintf(bw, "%s%s{%s} %d\n", prefix, name, labels, value(s))
+		})
+	}
+
+	counter("transfers_total", "Number of transfers, including failed ones.",
+		func(s *EndpointStats) uint64 { return s.Transfers })
+	counter("transfer_bytes_total", "Number of bytes transferred.",
+		func(s *EndpointStats) uint64 { return s.Bytes })
+	counter("stalls_total", "Number of transfers that failed with a stall.",
+		func(s *EndpointStats) uint64 { return s.Stalls })
+	counter("timeouts_total", "Number of transfers that timed out.",
+		func(s *EndpointStats) uint64 { return s.Timeouts })
+	family("transfer_errors_total", "counter", "Number of failed transfers by kind of error.",
+		func(labels string, s *EndpointStats) {
+			kinds := make([]string, 0, len(s.Errors))
+			for k := range s.Errors {
+				kinds = append(kinds, k)
+			}
+			slices.Sort(kinds)
+			for _, k := range kinds {
+				fmt.Fprintf(bw, "%stransfer_errors_total{%s,kind=%q} %d\n", prefix, labels, k, s.Errors[k])
+			}
+		})
+	family("transfer_duration_seconds", "histogram", "Transfer latency.",
+		func(labels string, s *EndpointStats) {
+			name := prefix + "transfer_duration_seconds"
+			h := s.Latency
+			var cumulative uint64
+			for i, bound := range h.Bounds {
+				cumulative += h.Counts[i]
+				fmt.Fprintf(bw, "%s_bucket{%s,le=%q} %d\n", name, labels, promFloat(bound.Seconds()), cumulative)
+			}
+			fmt.Fprintf(bw, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.Count)
+			fmt.Fprintf(bw, "%s_sum{%s} %s\n", name, labels, promFloat(h.Sum.Seconds()))
+			fmt.Fprintf(bw, "%s_count{%s} %d\n", name, labels, h.Count)
+		})
+}
+
+func promLabels(snap *Snapshot) string {
+	d := snap.Device
+	return fmt.Sprintf(`device="%04x:%04x",serial="%s",location="%s"`, d.VendorID, d.ProductID, promEscape(d.SerialNumber), promEscape(snap.Location))
+}
+
+func promEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func promFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,140 @@
+package usb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock advances by step on every reading.
+func fakeClock(step time.Duration) func() time.Time {
+	now := time.Unix(0, 0)
+	return func() time.Time {
+		now = now.Add(step)
+		return now
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	host := newFakeHost()
+	host.fault = script(map[string][]error{"read-bulk(0x81)": {ErrStall}})
+	d := NewDevice(host)
+	d.SetDefaultRetryPolicy(DefaultRetryPolicy)
+	m := d.EnableMetrics(time.Millisecond, 10*time.Millisecond)
+	m.now = fakeClock(2 * time.Millisecond)
+
+	d.Open()
+	d.ClaimInterface(0, 0)
+	d.ReadBulk(0x81, 13)
+	d.WriteBulk(0x02, []byte{1, 2, 3})
+	host.fault = always(ErrTimeout, "read-interrupt(0x83)")
+	d.SetDefaultRetryPolicy(nil)
+	d.ReadInterrupt(0x83, 8)
+
+	snap := m.Snapshot()
+	if len(snap.Endpoints) != 3 {
+		t.Fatalf("got %d endpoints", len(snap.Endpoints))
+	}
+	out, in, intr := snap.Endpoints[0], snap.Endpoints[1], snap.Endpoints[2]
+	if out.Endpoint != 0x02 || in.Endpoint != 0x81 || intr.Endpoint != 0x83 {
+		t.Fatalf("endpoints not sorted: %x %x %x", out.Endpoint, in.Endpoint, intr.Endpoint)
+	}
+	// The stalled attempt and the successful retry both count.
+	if in.Transfers != 2 || in.Stalls != 1 || in.Errors[ErrorKindStall] != 1 || in.Bytes != 13 {
+		t.Errorf("0x81: %+v", in)
+	}
+	if out.Transfers != 1 || out.Bytes != 3 || len(out.Errors) != 0 {
+		t.Errorf("0x02: %+v", out)
+	}
+	if intr.Transfers != 1 || intr.Timeouts != 1 || intr.Errors[ErrorKindTimeout] != 1 {
+		t.Errorf("0x83: %+v", intr)
+	}
+	if snap.Total.Transfers != 4 || snap.Total.Bytes != 16 || snap.Total.Stalls != 1 || snap.Total.Timeouts != 1 {
+		t.Errorf("total: %+v", snap.Total)
+	}
+	if h := snap.Total.Latency; h.Count != 4 || h.Sum != 8*time.Millisecond || h.Counts[1] != 4 {
+		t.Errorf("latency: %+v", h)
+	}
+
+	m.Reset()
+	if snap := m.Snapshot(); len(snap.Endpoints) != 0 || snap.Total.Transfers != 0 {
+		t.Fatalf("reset left %+v", snap)
+	}
+}
+
+func TestMetricsPrometheus(t *testing.T) {
+	host := newPowerHost(1, 2, 4)
+	host.descriptor.SerialNumber = `a"b`
+	host.fault = always(ErrStall, "read-bulk(0x81)")
+	d := NewDevice(host)
+	m := d.EnableMetrics(time.Millisecond)
+	m.now = fakeClock(500 * time.Microsecond)
+
+	d.Open()
+	d.ClaimInterface(0, 0)
+	d.ReadBulk(0x81, 13)
+	d.ReadControl(ControlSetup{Request: RequestGetStatus}, 2)
+
+	var buf bytes.Buffer
+	if err := m.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	device := `device="1234:5678",serial="a\"b",location="1-2.4"`
+	labels := device + `,endpoint="0x81"`
+	for _, line := range []string{
+		"# TYPE usb_transfers_total counter",
+		"usb_transfers_total{" + labels + "} 1",
+		"usb_transfer_bytes_total{" + labels + "} 0",
+		"usb_stalls_total{" + labels + "} 1",
+		"usb_timeouts_total{" + labels + "} 0",
+		"usb_transfer_errors_total{" + labels + `,kind="stall"} 1`,
+		"# TYPE usb_transfer_duration_seconds histogram",
+		"usb_transfer_duration_seconds_bucket{" + labels + `,le="0.001"} 1`,
+		"usb_transfer_duration_seconds_bucket{" + labels + `,le="+Inf"} 1`,
+		"usb_transfer_duration_seconds_sum{" + labels + "} 0.0005",
+		"usb_transfer_duration_seconds_count{" + labels + "} 1",
+		"usb_transfers_total{" + device + `,endpoint="0x80"} 1`,
+		"# TYPE usb_device_transfers_total counter",
+		"usb_device_transfers_total{" + device + "} 2",
+		"usb_device_transfer_bytes_total{" + device + "} 2",
+		"usb_device_stalls_total{" + device + "} 1",
+		"usb_device_transfer_errors_total{" + device + `,kind="stall"} 1`,
+		"# TYPE usb_device_transfer_duration_seconds histogram",
+		"usb_device_transfer_duration_seconds_bucket{" + device + `,le="+Inf"} 2`,
+		"usb_device_transfer_duration_seconds_count{" + device + "} 2",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %s in\n%s", line, buf.String())
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "usb.prom")
+	if err := m.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, buf.Bytes()) {
+		t.Fatalf("file differs from exposition:\n%s", data)
+	}
+}
+
+// A host that does not know where the device is gives an empty location.
+func TestMetricsWithoutLocation(t *testing.T) {
+	d := NewDevice(newFakeHost())
+	m := d.EnableMetrics()
+	d.Open()
+	d.ReadControl(ControlSetup{Request: RequestGetStatus}, 2)
+	var buf bytes.Buffer
+	if err := m.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if line := `usb_device_transfers_total{device="1234:5678",serial="0001",location=""} 1` + "\n"; !strings.Contains(buf.String(), line) {
+		t.Errorf("missing %s in\n%s", line, buf.String())
+	}
+}
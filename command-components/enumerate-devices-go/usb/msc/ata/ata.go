@@ -0,0 +1,203 @@
+// Package ata sends ATA commands to SATA drives behind USB bridges, and
+// decodes IDENTIFY DEVICE and SMART data.
+//
+// A bridge presents the drive as a SCSI device. Most bridges translate the
+// ATA PASS-THROUGH commands of the SCSI/ATA Translation (SAT) standard;
+// older JMicron and Cypress bridges have vendor commands instead. Detect
+// finds a Passthrough that works, and ReadSMART reads the attributes of
+// the drive and judges its health.
+package ata
+
+import (
+	"errors"
+	"fmt"
+
+	"example.com/usb/msc/scsi"
+)
+
+// ATA commands.
+const (
+	CmdIdentifyDevice = 0xec
+	CmdSMART          = 0xb0
+)
+
+// Features of CmdSMART.
+const (
+	SMARTReadData       = 0xd0
+	SMARTReadThresholds = 0xd1
+	SMARTEnable         = 0xd8
+	SMARTReturnStatus   = 0xda
+)
+
+// smartLBA is the signature CmdSMART expects in the LBA mid and high
+// registers. SMART RETURN STATUS swaps it when a threshold is exceeded.
+const (
+	smartLBA       = 0xc24f00
+	smartLBAFailed = 0x2cf400
+)
+
+// Status register bits.
+const (
+	StatusErr  = 0x01
+	StatusDRQ  = 0x08
+	StatusDRDY = 0x40
+	StatusBSY  = 0x80
+)
+
+var (
+	// ErrUnsupported is returned by Detect if no passthrough works.
+	ErrUnsupported = errors.New("ata: no ATA passthrough supported")
+	// ErrNoRegisters is returned for commands that need the output
+	// registers, if the bridge cannot return them.
+	ErrNoRegisters = errors.New("ata: bridge does not return the ATA registers")
+)
+
+// Protocol is how a command moves its data. The values are those of SAT.
+type Protocol uint8
+
+const (
+	NonData Protocol = 3
+	PIOIn   Protocol = 4
+	PIOOut  Protocol = 5
+)
+
+// Command is an ATA command and its input registers. Commands with 28-bit
+// addresses put bits 24 to 27 of LBA in the device register.
+type Command struct {
+	Command  uint8
+	Features uint16
+	Count    uint16
+	LBA      uint64 // 48 bits
+	Device   uint8
+	Protocol Protocol
+}
+
+// ext reports whether the command needs the 48-bit registers.
+func (c *Command) ext() bool {
+	return c.Features > 0xff || c.Count > 0xff || c.LBA >= 1<<28
+}
+
+// device returns the device register of a 28-bit command.
+func (c *Command) device() uint8 {
+	return c.Device | uint8(c.LBA>>24)&0x0f
+}
+
+// Registers are the output registers of a command.
+type Registers struct {
+	Status uint8
+	Error  uint8
+	Count  uint16
+	LBA    uint64
+	Device uint8
+}
+
+// Error is a command the drive aborted.
+type Error struct {
+	Command   uint8
+	Registers Registers
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ata: command %#02x failed: status %#02x, error %#02x", e.Command, e.Registers.Status, e.Registers.Error)
+}
+
+// Passthrough sends ATA commands through a bridge.
+type Passthrough interface {
+	// Exec runs cmd, reading into data for PIOIn and writing data for
+	// PIOOut, in blocks of 512 bytes. It returns the output registers, or
+	// nil if the bridge does not return them.
+	Exec(cmd *Command, data []byte) (*Registers, error)
+	// Name names the passthrough, such as "SAT(16)".
+	Name() string
+}
+
+// IdentifyDevice sends IDENTIFY DEVICE.
+func IdentifyDevice(p Passthrough) (*Identify, error) {
+	buf := make([]byte, 512)
+	if _, err := p.Exec(&Command{Command: CmdIdentifyDevice, Count: 1, Protocol: PIOIn}, buf); err != nil {
+		return nil, err
+	}
+	return ParseIdentify(buf)
+}
+
+func smart(p Passthrough, feature uint8, protocol Protocol, data []byte) (*Registers, error) {
+	cmd := &Command{Command: CmdSMART, Features: uint16(feature), LBA: smartLBA, Protocol: protocol}
+	if len(data) > 0 {
+		cmd.Count = uint16(len(data) / 512)
+	}
+	return p.Exec(cmd, data)
+}
+
+// EnableSMART sends SMART ENABLE OPERATIONS.
+func EnableSMART(p Passthrough) error {
+	_, err := smart(p, SMARTEnable, NonData, nil)
+	return err
+}
+
+// ReadSMARTData sends SMART READ DATA and returns the 512-byte data
+// structure.
+func ReadSMARTData(p Passthrough) ([]byte, error) {
+	buf := make([]byte, 512)
+	if _, err := smart(p, SMARTReadData, PIOIn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// ReadSMARTThresholds sends SMART READ ATTRIBUTE THRESHOLDS and returns the
+// 512-byte data structure.
+func ReadSMARTThresholds(p Passthrough) ([]byte, error) {
+	buf := make([]byte, 512)
+	if _, err := smart(p, SMARTReadThresholds, PIOIn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// ReturnStatus sends SMART RETURN STATUS and reports whether the drive
+// found a threshold exceeded.
+func ReturnStatus(p Passthrough) (failing bool, err error) {
+	regs, err := smart(p, SMARTReturnStatus, NonData, nil)
+	if err != nil {
+		return false, err
+	}
+	if regs == nil {
+		return false, ErrNoRegisters
+	}
+	switch regs.LBA & 0xffff00 {
+	case smartLBA:
+		return false, nil
+	case smartLBAFailed:
+		return true, nil
+	}
+	return false, fmt.Errorf("ata: SMART RETURN STATUS returned LBA %#x", regs.LBA)
+}
+
+// Bridge vendor IDs.
+const (
+	VendorJMicron = 0x152d
+	VendorCypress = 0x04b4
+)
+
+// Detect finds the passthrough of the bridge d is behind, trying the vendor
+// command of the bridge's vendor first, and then SAT ATA PASS-THROUGH(16)
+// and (12). It returns the IDENTIFY DEVICE data with which it tried.
+func Detect(d *scsi.Device, vendorID uint16) (Passthrough, *Identify, error) {
+	var candidates []Passthrough
+	switch vendorID {
+	case VendorJMicron:
+		candidates = append(candidates, NewJMicron(d, 0))
+	case VendorCypress:
+		candidates = append(candidates, NewCypress(d))
+	}
+	candidates = append(candidates, NewSAT16(d), NewSAT12(d))
+	var errs []error
+	for _, p := range candidates {
+		id, err := IdentifyDevice(p)
+		if err == nil {
+			return p, id, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+	}
+	return nil, nil, fmt.Errorf("%w: %w", ErrUnsupported, errors.Join(errs...))
+}
@@ -0,0 +1,336 @@
+package ata
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"example.com/usb"
+	"example.com/usb/msc/scsi"
+)
+
+// drive emulates a SATA drive behind a bridge with one kind of passthrough:
+// "sat16", which also has ATA PASS-THROUGH(12), "sat12", "jmicron" or
+// "cypress". Other commands fail with ILLEGAL REQUEST.
+type drive struct {
+	bridge     string
+	identify   []byte
+	data       []byte // SMART READ DATA
+	thresholds []byte
+	failing    bool
+
+	jmicronRegs []byte
+	commands    [][]byte
+}
+
+func newDrive(bridge string) *drive {
+	return &drive{
+		bridge:     bridge,
+		identify:   identifyData("WDC WD40EFRX-68N32N0", "WD-WCC7K0000001", 7814037168),
+		data:       smartData(testAttributes...),
+		thresholds: smartThresholds(testAttributes...),
+	}
+}
+
+func (d *drive) Command(lun uint8, cb []byte, dir usb.Direction, data []byte) (scsi.Completion, error) {
+	d.commands = append(d.commands, append([]byte(nil), cb...))
+	switch {
+	case cb[0] == scsi.OpATAPassThrough16 && d.bridge == "sat16":
+		cmd := &Command{
+			Protocol: Protocol(cb[1] >> 1),
+			Features: binary.BigEndian.Uint16(cb[3:]),
+			Count:    binary.BigEndian.Uint16(cb[5:]),
+			LBA:      uint64(cb[8]) | uint64(cb[10])<<8 | uint64(cb[12])<<16,
+			Device:   cb[13],
+			Command:  cb[14],
+		}
+		if cb[1]&0x01 != 0 {
+			cmd.LBA |= uint64(cb[7])<<24 | uint64(cb[9])<<32 | uint64(cb[11])<<40
+		}
+		return d.sat(cmd, cb[2], data), nil
+	case cb[0] == scsi.OpATAPassThrough12 && (d.bridge == "sat16" || d.bridge == "sat12"):
+		cmd := &Command{
+			Protocol: Protocol(cb[1] >> 1),
+			Features: uint16(cb[3]),
+			Count:    uint16(cb[4]),
+			LBA:      uint64(cb[5]) | uint64(cb[6])<<8 | uint64(cb[7])<<16,
+			Device:   cb[8],
+			Command:  cb[9],
+		}
+		return d.sat(cmd, cb[2], data), nil
+	case cb[0] == opJMicron && d.bridge == "jmicron":
+		if cb[11] == jmicronReadMemory {
+			if binary.BigEndian.Uint16(cb[6:]) != jmicronRegisters0 {
+				return illegalRequest(), nil
+			}
+			copy(data, d.jmicronRegs)
+			return scsi.Completion{}, nil
+		}
+		cmd := &Command{
+			Features: uint16(cb[5]),
+			Count:    uint16(cb[6]),
+			LBA:      uint64(cb[7]) | uint64(cb[8])<<8 | uint64(cb[9])<<16,
+			Device:   cb[10],
+			Command:  cb[11],
+		}
+		regs := d.exec(cmd, data)
+		d.jmicronRegs = make([]byte, 16)
+		d.jmicronRegs[0], d.jmicronRegs[6], d.jmicronRegs[4], d.jmicronRegs[10] = uint8(regs.Count), uint8(regs.LBA), uint8(regs.LBA>>8), uint8(regs.LBA>>16)
+		d.jmicronRegs[9], d.jmicronRegs[13], d.jmicronRegs[14] = regs.Device, regs.Error, regs.Status
+		return scsi.Completion{}, nil
+	case cb[0] == opCypress && cb[1] == cypressATACB && d.bridge == "cypress":
+		cmd := &Command{
+			Features: uint16(cb[6]),
+			Count:    uint16(cb[7]),
+			LBA:      uint64(cb[8]) | uint64(cb[9])<<8 | uint64(cb[10])<<16,
+			Device:   cb[11],
+			Command:  cb[12],
+		}
+		if (cmd.Command == CmdIdentifyDevice) != (cb[2]&cypressIdentify != 0) {
+			return illegalRequest(), nil
+		}
+		if regs := d.exec(cmd, data); regs.Status&StatusErr != 0 {
+			return scsi.Completion{Status: scsi.StatusCheckCondition, Sense: fixedSense(scsi.SenseAbortedCommand, 0, 0, 0, 0)}, nil
+		}
+		return scsi.Completion{}, nil
+	}
+	return illegalRequest(), nil
+}
+
+// sat completes an ATA PASS-THROUGH command, returning the registers in
+// descriptor-format sense data for sat16 and fixed-format for sat12.
+func (d *drive) sat(cmd *Command, flags uint8, data []byte) scsi.Completion {
+	regs := d.exec(cmd, data)
+	key := scsi.SenseRecoveredError
+	if regs.Status&StatusErr != 0 {
+		key = scsi.SenseAbortedCommand
+	} else if flags&satCheckCondition == 0 {
+		return scsi.Completion{}
+	}
+	if d.bridge == "sat12" {
+		info := uint32(regs.Error)<<24 | uint32(regs.Status)<<16 | uint32(regs.Device)<<8 | uint32(uint8(regs.Count))
+		cs := uint32(uint8(regs.LBA))<<16 | uint32(uint8(regs.LBA>>8))<<8 | uint32(uint8(regs.LBA>>16))
+		ascq := uint8(0x1d)
+		if key == scsi.SenseAbortedCommand {
+			ascq = 0
+		}
+		return scsi.Completion{Status: scsi.StatusCheckCondition, Sense: fixedSense(key, 0, ascq, info, cs)}
+	}
+	sense := make([]byte, 8+14)
+	sense[0], sense[1], sense[3], sense[7] = 0x72, uint8(key), 0x1d, 14
+	desc := sense[8:]
+	desc[0], desc[1] = 0x09, 12
+	desc[2+0] = 0x01 // EXTEND
+	desc[2+1], desc[2+2], desc[2+3] = regs.Error, uint8(regs.Count>>8), uint8(regs.Count)
+	desc[2+4], desc[2+5] = uint8(regs.LBA>>24), uint8(regs.LBA)
+	desc[2+6], desc[2+7] = uint8(regs.LBA>>32), uint8(regs.LBA>>8)
+	desc[2+8], desc[2+9] = uint8(regs.LBA>>40), uint8(regs.LBA>>16)
+	desc[2+10], desc[2+11] = regs.Device, regs.Status
+	return scsi.Completion{Status: scsi.StatusCheckCondition, Sense: sense}
+}
+
+// exec runs an ATA command on the drive.
+func (d *drive) exec(cmd *Command, data []byte) Registers {
+	ok := Registers{Status: StatusDRDY, LBA: cmd.LBA, Device: cmd.Device}
+	aborted := Registers{Status: StatusDRDY | StatusErr, Error: 0x04, Device: cmd.Device}
+	switch cmd.Command {
+	case CmdIdentifyDevice:
+		if len(data) != 512 {
+			return aborted
+		}
+		copy(data, d.identify)
+		return ok
+	case CmdSMART:
+		if cmd.LBA&0xffff00 != smartLBA {
+			return aborted
+		}
+		switch cmd.Features {
+		case SMARTReadData:
+			copy(data, d.data)
+		case SMARTReadThresholds:
+			copy(data, d.thresholds)
+		case SMARTEnable:
+		case SMARTReturnStatus:
+			if d.failing {
+				ok.LBA = smartLBAFailed
+			}
+		default:
+			return aborted
+		}
+		return ok
+	}
+	return aborted
+}
+
+func fixedSense(key scsi.SenseKey, asc, ascq uint8, info, cs uint32) []byte {
+	b := make([]byte, 18)
+	b[0], b[2], b[7], b[12], b[13] = 0x70, uint8(key), 10, asc, ascq
+	binary.BigEndian.PutUint32(b[3:], info)
+	binary.BigEndian.PutUint32(b[8:], cs)
+	return b
+}
+
+func illegalRequest() scsi.Completion {
+	return scsi.Completion{Status: scsi.StatusCheckCondition, Sense: fixedSense(scsi.SenseIllegalRequest, 0x20, 0, 0, 0)}
+}
+
+func putString(w []byte, s string) {
+	for i := range w {
+		w[i] = ' '
+	}
+	copy(w, s)
+	for i := 0; i < len(w); i += 2 {
+		w[i], w[i+1] = w[i+1], w[i]
+	}
+}
+
+// identifyData returns the IDENTIFY DEVICE data of a 48-bit drive with 4096
+// byte physical sectors and SMART enabled.
+func identifyData(model, serial string, sectors uint64) []byte {
+	b := make([]byte, 512)
+	word := func(i int, v uint16) { binary.LittleEndian.PutUint16(b[2*i:], v) }
+	putString(b[20:40], serial)
+	putString(b[46:54], "82.00A82")
+	putString(b[54:94], model)
+	word(60, 0xffff)
+	word(61, 0x0fff)
+	word(80, 0x07f0) // ATA/ATAPI-4 to ACS-3
+	word(82, 0x746b)
+	word(83, 0x7361|1<<10)
+	word(84, 0x6163)
+	word(85, 0x7469)
+	for i := range 4 {
+		word(100+i, uint16(sectors>>(16*i)))
+	}
+	word(106, 0x6003) // 8 logical sectors per physical sector
+	word(108, 0x5001)
+	word(109, 0x4a2b)
+	word(110, 0x1234)
+	word(111, 0x5678)
+	word(217, 5400)
+	word(255, 0x00a5)
+	b[511] = -sum(b)
+	return b
+}
+
+func TestParseIdentify(t *testing.T) {
+	id, err := ParseIdentify(identifyData("WDC WD40EFRX-68N32N0", "WD-WCC7K0000001", 7814037168))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Model != "WDC WD40EFRX-68N32N0" || id.Serial != "WD-WCC7K0000001" || id.Firmware != "82.00A82" {
+		t.Errorf("strings %q %q %q", id.Model, id.Serial, id.Firmware)
+	}
+	if !id.LBA48 || id.Sectors != 7814037168 || id.Bytes() != 7814037168*512 {
+		t.Errorf("%d sectors", id.Sectors)
+	}
+	if id.LogicalSectorSize != 512 || id.PhysicalSectorSize != 4096 {
+		t.Errorf("sector sizes %d/%d", id.LogicalSectorSize, id.PhysicalSectorSize)
+	}
+	if !id.SMARTSupported || !id.SMARTEnabled || id.MajorVersion != 10 || id.RotationRate != 5400 {
+		t.Errorf("%+v", id)
+	}
+	if id.WWN != 0x50014a2b12345678 {
+		t.Errorf("WWN %#x", id.WWN)
+	}
+
+	// A 28-bit drive.
+	b := identifyData("OLD", "1", 0)
+	binary.LittleEndian.PutUint16(b[166:], 0x7361)
+	binary.LittleEndian.PutUint16(b[120:], 0x1000)
+	b[511] = 0
+	b[511] = -sum(b)
+	if id, err := ParseIdentify(b); err != nil || id.LBA48 || id.Sectors != 0x0fff1000 {
+		t.Errorf("28-bit drive: %v, %+v", err, id)
+	}
+
+	b[2]++
+	if _, err := ParseIdentify(b); err == nil {
+		t.Error("bad checksum accepted")
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for _, test := range []struct {
+		bridge string
+		vendor uint16
+		name   string
+	}{
+		{"sat16", 0x174c, "SAT(16)"},
+		{"sat16", VendorJMicron, "SAT(16)"},
+		{"sat12", 0x0bc2, "SAT(12)"},
+		{"jmicron", VendorJMicron, "JMicron port 0"},
+		{"cypress", VendorCypress, "Cypress ATACB"},
+	} {
+		d := newDrive(test.bridge)
+		p, id, err := Detect(scsi.New(d, 0), test.vendor)
+		if err != nil {
+			t.Errorf("%s: %v", test.bridge, err)
+			continue
+		}
+		if p.Name() != test.name || id.Model != "WDC WD40EFRX-68N32N0" {
+			t.Errorf("%s: %s, %q", test.bridge, p.Name(), id.Model)
+		}
+		if err := EnableSMART(p); err != nil {
+			t.Errorf("%s: enable: %v", test.bridge, err)
+		}
+		s, err := ReadSMART(p)
+		if err != nil {
+			t.Errorf("%s: %v", test.bridge, err)
+			continue
+		}
+		if s.StatusKnown != (test.bridge != "cypress") || s.StatusFailing || len(s.Attributes) != len(testAttributes) {
+			t.Errorf("%s: %+v", test.bridge, s)
+		}
+
+		d.failing = true
+		failing, err := ReturnStatus(p)
+		if test.bridge == "cypress" {
+			if !errors.Is(err, ErrNoRegisters) {
+				t.Errorf("%s: status: %v", test.bridge, err)
+			}
+		} else if err != nil || !failing {
+			t.Errorf("%s: failing status: %v, %v", test.bridge, failing, err)
+		}
+	}
+
+	if _, _, err := Detect(scsi.New(newDrive("none"), 0), VendorJMicron); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("no passthrough: %v", err)
+	}
+}
+
+func TestAborted(t *testing.T) {
+	for _, p := range []Passthrough{
+		NewSAT16(scsi.New(newDrive("sat16"), 0)),
+		NewSAT12(scsi.New(newDrive("sat12"), 0)),
+		NewJMicron(scsi.New(newDrive("jmicron"), 0), 0),
+	} {
+		regs, err := p.Exec(&Command{Command: 0xe7, Protocol: NonData}, nil) // FLUSH CACHE
+		var e *Error
+		if !errors.As(err, &e) || e.Command != 0xe7 || e.Registers.Error != 0x04 || regs == nil || regs.Status&StatusErr == 0 {
+			t.Errorf("%s: %v, %+v", p.Name(), err, regs)
+		}
+	}
+}
+
+func TestExtend(t *testing.T) {
+	d := newDrive("sat16")
+	cmd := &Command{Command: 0x25, Count: 1, LBA: 0x123456789a, Device: 0x40, Protocol: PIOIn} // READ DMA EXT
+	NewSAT16(scsi.New(d, 0)).Exec(cmd, make([]byte, 512))
+	cb := d.commands[0]
+	if cb[1]&0x01 == 0 || cb[7] != 0x34 || cb[9] != 0x12 || cb[11] != 0 || cb[13] != 0x40 {
+		t.Errorf("ATA PASS-THROUGH(16) %x", cb)
+	}
+	if cb[2] != satDirIn|satBlocks|satLengthInCount {
+		t.Errorf("flags %#02x", cb[2])
+	}
+
+	for _, p := range []Passthrough{NewSAT12(scsi.New(d, 0)), NewJMicron(scsi.New(d, 0), 0), NewCypress(scsi.New(d, 0))} {
+		if _, err := p.Exec(cmd, make([]byte, 512)); err == nil {
+			t.Errorf("%s sent a 48-bit command", p.Name())
+		}
+	}
+	if _, err := NewSAT16(scsi.New(d, 0)).Exec(&Command{Command: CmdIdentifyDevice, Protocol: PIOIn}, make([]byte, 100)); err == nil {
+		t.Error("partial block accepted")
+	}
+}
@@ -0,0 +1,260 @@
+package ata
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"example.com/usb"
+	"example.com/usb/msc/scsi"
+)
+
+// direction returns the direction of the data stage of a protocol.
+func direction(p Protocol) usb.Direction {
+	if p == PIOIn {
+		return usb.DirectionIn
+	}
+	return usb.DirectionOut
+}
+
+func checkData(cmd *Command, data []byte) error {
+	if len(data)%512 != 0 || (len(data) == 0) != (cmd.Protocol == NonData) {
+		return fmt.Errorf("ata: %d bytes of data for protocol %d", len(data), cmd.Protocol)
+	}
+	return nil
+}
+
+// sat sends SAT ATA PASS-THROUGH commands.
+type sat struct {
+	d     *scsi.Device
+	cdb12 bool
+}
+
+// NewSAT16 returns the passthrough of ATA PASS-THROUGH(16), which SAT
+// bridges implement.
+func NewSAT16(d *scsi.Device) Passthrough {
+	return &sat{d: d}
+}
+
+// NewSAT12 returns the passthrough of ATA PASS-THROUGH(12), for bridges
+// without the 16-byte command. It cannot send 48-bit commands.
+func NewSAT12(d *scsi.Device) Passthrough {
+	return &sat{d: d, cdb12: true}
+}
+
+func (s *sat) Name() string {
+	if s.cdb12 {
+		return "SAT(12)"
+	}
+	return "SAT(16)"
+}
+
+// Flags in byte 2 of ATA PASS-THROUGH.
+const (
+	satCheckCondition = 0x20 // CK_COND: return the registers in sense data
+	satDirIn          = 0x08 // T_DIR
+	satBlocks         = 0x04 // BYTE_BLOCK: the length counts blocks
+	satLengthInCount  = 0x02 // T_LENGTH: the length is in the count field
+)
+
+func (s *sat) Exec(cmd *Command, data []byte) (*Registers, error) {
+	if err := checkData(cmd, data); err != nil {
+		return nil, err
+	}
+	// The registers of commands without data are needed, e.g. for SMART
+	// RETURN STATUS. Asking for them with data stages upsets some bridges.
+	flags := uint8(satCheckCondition)
+	if len(data) > 0 {
+		flags = satBlocks | satLengthInCount
+		if cmd.Protocol == PIOIn {
+			flags |= satDirIn
+		}
+	}
+	var cb []byte
+	if s.cdb12 {
+		if cmd.ext() {
+			return nil, errors.New("ata: SAT(12) cannot send 48-bit commands")
+		}
+		cb = []byte{
+			scsi.OpATAPassThrough12, uint8(cmd.Protocol) << 1, flags, uint8(cmd.Features), uint8(cmd.Count),
+			uint8(cmd.LBA), uint8(cmd.LBA >> 8), uint8(cmd.LBA >> 16), cmd.device(), cmd.Command, 0, 0,
+		}
+	} else {
+		cb = make([]byte, 16)
+		cb[0], cb[1], cb[2] = scsi.OpATAPassThrough16, uint8(cmd.Protocol)<<1, flags
+		binary.BigEndian.PutUint16(cb[3:], cmd.Features)
+		binary.BigEndian.PutUint16(cb[5:], cmd.Count)
+		cb[8], cb[10], cb[12] = uint8(cmd.LBA), uint8(cmd.LBA>>8), uint8(cmd.LBA>>16)
+		cb[13] = cmd.device()
+		if cmd.ext() {
+			cb[1] |= 0x01 // EXTEND
+			cb[7], cb[9], cb[11] = uint8(cmd.LBA>>24), uint8(cmd.LBA>>32), uint8(cmd.LBA>>40)
+			cb[13] = cmd.Device
+		}
+		cb[14] = cmd.Command
+	}
+	_, sense, err := s.d.CommandSense(cb, direction(cmd.Protocol), data)
+	var se *scsi.SenseError
+	if errors.As(err, &se) && se.Sense.Key == scsi.SenseAbortedCommand {
+		// The drive aborted the command, and the bridge returns its
+		// registers.
+		if regs := satRegisters(&se.Sense); regs != nil {
+			return regs, &Error{Command: cmd.Command, Registers: *regs}
+		}
+	}
+	if err != nil || sense == nil {
+		return nil, err
+	}
+	return satRegisters(sense), nil
+}
+
+// satRegisters decodes the registers in the ATA Status Return descriptor of
+// descriptor-format sense data, or in the fields of fixed-format sense data.
+func satRegisters(s *scsi.Sense) *Registers {
+	for _, d := range s.Descriptors {
+		if d.Type != 0x09 || len(d.Data) < 12 {
+			continue
+		}
+		b := d.Data
+		r := &Registers{Error: b[1], Device: b[10], Status: b[11]}
+		r.Count = uint16(b[3])
+		r.LBA = uint64(b[5]) | uint64(b[7])<<8 | uint64(b[9])<<16
+		if b[0]&0x01 != 0 { // EXTEND
+			r.Count |= uint16(b[2]) << 8
+			r.LBA |= uint64(b[4])<<24 | uint64(b[6])<<32 | uint64(b[8])<<40
+		}
+		return r
+	}
+	// Fixed-format sense data holds registers for ATA PASS-THROUGH
+	// INFORMATION AVAILABLE, and for commands the drive aborted.
+	if s.Descriptor || s.ASC != 0 || (s.ASCQ != 0x1d && s.Key != scsi.SenseAbortedCommand) {
+		return nil
+	}
+	// The information field holds the error, status, device and count
+	// registers, the command-specific field the low 24 bits of the LBA.
+	info, cs := uint32(s.Information), uint32(s.CommandSpecific)
+	return &Registers{
+		Error:  uint8(info >> 24),
+		Status: uint8(info >> 16),
+		Device: uint8(info >> 8),
+		Count:  uint16(uint8(info)),
+		LBA:    uint64(uint8(cs>>16)) | uint64(uint8(cs>>8))<<8 | uint64(uint8(cs))<<16,
+	}
+}
+
+// JMicron vendor command, and the registers its subcommand 0xfd reads.
+const (
+	opJMicron         = 0xdf
+	jmicronReadMemory = 0xfd
+	jmicronRegisters0 = 0x8000 // the output registers of port 0
+	jmicronRegisters1 = 0x9000
+)
+
+// jmicron sends the vendor ATA command of JMicron JM20329, JM20336 and
+// similar bridges, as smartmontools does.
+type jmicron struct {
+	d    *scsi.Device
+	port uint8
+}
+
+// NewJMicron returns the passthrough of JMicron bridges, for the drive on
+// port 0 or 1. It cannot send 48-bit commands.
+func NewJMicron(d *scsi.Device, port uint8) Passthrough {
+	return &jmicron{d: d, port: port}
+}
+
+func (j *jmicron) Name() string {
+	return fmt.Sprintf("JMicron port %d", j.port)
+}
+
+func (j *jmicron) Exec(cmd *Command, data []byte) (*Registers, error) {
+	if err := checkData(cmd, data); err != nil {
+		return nil, err
+	}
+	if cmd.ext() {
+		return nil, errors.New("ata: JMicron bridges cannot send 48-bit commands")
+	}
+	device, regAddr := uint8(0xa0), uint16(jmicronRegisters0)
+	if j.port == 1 {
+		device, regAddr = 0xb0, jmicronRegisters1
+	}
+	cb := make([]byte, 12)
+	cb[0] = opJMicron
+	if cmd.Protocol == PIOIn {
+		cb[1] = 0x10
+	}
+	binary.BigEndian.PutUint16(cb[3:], uint16(len(data)))
+	cb[5], cb[6] = uint8(cmd.Features), uint8(cmd.Count)
+	cb[7], cb[8], cb[9] = uint8(cmd.LBA), uint8(cmd.LBA>>8), uint8(cmd.LBA>>16)
+	cb[10], cb[11] = cmd.device()|device, cmd.Command
+	if _, err := j.d.Command(cb, direction(cmd.Protocol), data); err != nil {
+		return nil, err
+	}
+	if cmd.Protocol != NonData {
+		return nil, nil
+	}
+	// The registers are read from the memory of the bridge.
+	regs := make([]byte, 16)
+	cb = make([]byte, 12)
+	cb[0], cb[1] = opJMicron, 0x10
+	binary.BigEndian.PutUint16(cb[3:], uint16(len(regs)))
+	binary.BigEndian.PutUint16(cb[6:], regAddr)
+	cb[11] = jmicronReadMemory
+	if _, err := j.d.Command(cb, usb.DirectionIn, regs); err != nil {
+		return nil, err
+	}
+	r := &Registers{
+		Count:  uint16(regs[0]),
+		LBA:    uint64(regs[6]) | uint64(regs[4])<<8 | uint64(regs[10])<<16,
+		Device: regs[9],
+		Error:  regs[13],
+		Status: regs[14],
+	}
+	if r.Status&StatusErr != 0 {
+		return r, &Error{Command: cmd.Command, Registers: *r}
+	}
+	return r, nil
+}
+
+// Cypress ATACB vendor command.
+const (
+	opCypress         = 0x24
+	cypressATACB      = 0x24 // the subcommand
+	cypressIdentify   = 0x80 // the command is an IDENTIFY
+	cypressRegSelects = 0xbe // features, count, LBA and command registers
+)
+
+// cypress sends the ATACB vendor command of Cypress CY7C68300 bridges.
+type cypress struct {
+	d *scsi.Device
+}
+
+// NewCypress returns the passthrough of Cypress ATACB bridges. They do not
+// return the registers, and cannot send 48-bit commands.
+func NewCypress(d *scsi.Device) Passthrough {
+	return &cypress{d: d}
+}
+
+func (c *cypress) Name() string {
+	return "Cypress ATACB"
+}
+
+func (c *cypress) Exec(cmd *Command, data []byte) (*Registers, error) {
+	if err := checkData(cmd, data); err != nil {
+		return nil, err
+	}
+	if cmd.ext() {
+		return nil, errors.New("ata: Cypress bridges cannot send 48-bit commands")
+	}
+	cb := make([]byte, 16)
+	cb[0], cb[1], cb[3] = opCypress, cypressATACB, cypressRegSelects
+	if cmd.Command == CmdIdentifyDevice {
+		cb[2] |= cypressIdentify
+	}
+	cb[4] = uint8(len(data) / 512)
+	cb[6], cb[7] = uint8(cmd.Features), uint8(cmd.Count)
+	cb[8], cb[9], cb[10] = uint8(cmd.LBA), uint8(cmd.LBA>>8), uint8(cmd.LBA>>16)
+	cb[11], cb[12] = cmd.device(), cmd.Command
+	_, err := c.d.Command(cb, direction(cmd.Protocol), data)
+	return nil, err
+}
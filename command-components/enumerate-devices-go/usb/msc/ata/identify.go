@@ -0,0 +1,109 @@
+package ata
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Identify is the IDENTIFY DEVICE data of a drive.
+type Identify struct {
+	Model    string
+	Serial   string
+	Firmware string
+	// Sectors is the number of user addressable logical sectors.
+	Sectors            uint64
+	LogicalSectorSize  int
+	PhysicalSectorSize int
+	LBA48              bool
+	SMARTSupported     bool
+	SMARTEnabled       bool
+	// MajorVersion is the highest ATA major version supported, such as 8
+	// for ATA8-ACS, or 0 if not reported.
+	MajorVersion int
+	// RotationRate is in rpm; 1 means a non-rotating medium, such as flash,
+	// and 0 that it is not reported.
+	RotationRate int
+	WWN          uint64 // the World Wide Name, or 0 if not reported
+	Words        [256]uint16
+}
+
+// ParseIdentify decodes the 512 bytes of IDENTIFY DEVICE data. The checksum
+// is verified if the data has one.
+func ParseIdentify(b []byte) (*Identify, error) {
+	if len(b) < 512 {
+		return nil, fmt.Errorf("ata: IDENTIFY DEVICE data of %d bytes", len(b))
+	}
+	id := &Identify{}
+	w := id.Words[:]
+	for i := range w {
+		w[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	if w[255]&0xff == 0xa5 && sum(b[:512]) != 0 {
+		return nil, fmt.Errorf("ata: IDENTIFY DEVICE data checksum %#02x", sum(b[:512]))
+	}
+	id.Serial = ataString(w[10:20])
+	id.Firmware = ataString(w[23:27])
+	id.Model = ataString(w[27:47])
+
+	// Words whose value is 0 or 0xffff are not reported.
+	valid := func(x uint16) bool { return x != 0 && x != 0xffff }
+	if valid(w[80]) {
+		for v := 14; v > 0; v-- {
+			if w[80]&(1<<v) != 0 {
+				id.MajorVersion = v
+				break
+			}
+		}
+	}
+	if valid(w[82]) {
+		id.SMARTSupported = w[82]&0x0001 != 0
+		id.SMARTEnabled = w[85]&0x0001 != 0
+	}
+	id.LBA48 = valid(w[83]) && w[83]&(1<<10) != 0
+	id.Sectors = uint64(w[60]) | uint64(w[61])<<16
+	if id.LBA48 {
+		id.Sectors = uint64(w[100]) | uint64(w[101])<<16 | uint64(w[102])<<32 | uint64(w[103])<<48
+	}
+
+	id.LogicalSectorSize, id.PhysicalSectorSize = 512, 512
+	if w[106]&0xc000 == 0x4000 {
+		if w[106]&(1<<12) != 0 {
+			id.LogicalSectorSize = 2 * int(uint32(w[117])|uint32(w[118])<<16)
+		}
+		id.PhysicalSectorSize = id.LogicalSectorSize
+		if w[106]&(1<<13) != 0 {
+			id.PhysicalSectorSize <<= w[106] & 0x0f
+		}
+	}
+	if valid(w[84]) && w[84]&(1<<8) != 0 {
+		id.WWN = uint64(w[108])<<48 | uint64(w[109])<<32 | uint64(w[110])<<16 | uint64(w[111])
+	}
+	if w[217] != 0xffff {
+		id.RotationRate = int(w[217])
+	}
+	return id, nil
+}
+
+// ataString decodes an ATA string, which holds two characters per word,
+// the first in the high byte.
+func ataString(words []uint16) string {
+	b := make([]byte, 0, 2*len(words))
+	for _, x := range words {
+		b = append(b, byte(x>>8), byte(x))
+	}
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+}
+
+// Bytes returns the capacity of the drive.
+func (id *Identify) Bytes() uint64 {
+	return id.Sectors * uint64(id.LogicalSectorSize)
+}
+
+func sum(b []byte) uint8 {
+	var s uint8
+	for _, x := range b {
+		s += x
+	}
+	return s
+}
@@ -0,0 +1,247 @@
+package ata
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Verdict judges the health of a drive or an attribute.
+type Verdict uint8
+
+const (
+	Healthy Verdict = iota
+	// Warning means an attribute shows wear or errors, such as
+	// reallocated sectors, without having reached its threshold.
+	Warning
+	// Failing means the drive reports an exceeded threshold, or a
+	// pre-failure attribute has reached its threshold.
+	Failing
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Healthy:
+		return "healthy"
+	case Warning:
+		return "warning"
+	case Failing:
+		return "failing"
+	}
+	return fmt.Sprintf("Verdict(%d)", uint8(v))
+}
+
+// Attribute IDs that count sectors the drive could not read or had to
+// reallocate. Any count is a warning.
+const (
+	AttrReallocatedSectors = 5
+	AttrPowerOnHours       = 9
+	AttrAirflowTemperature = 190
+	AttrTemperature        = 194
+	AttrReallocatedEvents  = 196
+	AttrPendingSectors     = 197
+	AttrUncorrectable      = 198
+)
+
+// attributeNames are the names of common attributes, as smartctl prints them.
+var attributeNames = map[uint8]string{
+	1:   "Raw_Read_Error_Rate",
+	3:   "Spin_Up_Time",
+	4:   "Start_Stop_Count",
+	5:   "Reallocated_Sector_Ct",
+	7:   "Seek_Error_Rate",
+	9:   "Power_On_Hours",
+	10:  "Spin_Retry_Count",
+	12:  "Power_Cycle_Count",
+	177: "Wear_Leveling_Count",
+	183: "Runtime_Bad_Block",
+	184: "End-to-End_Error",
+	187: "Reported_Uncorrect",
+	188: "Command_Timeout",
+	190: "Airflow_Temperature_Cel",
+	192: "Power-Off_Retract_Count",
+	193: "Load_Cycle_Count",
+	194: "Temperature_Celsius",
+	196: "Reallocated_Event_Count",
+	197: "Current_Pending_Sector",
+	198: "Offline_Uncorrectable",
+	199: "UDMA_CRC_Error_Count",
+	231: "SSD_Life_Left",
+	241: "Total_LBAs_Written",
+	242: "Total_LBAs_Read",
+}
+
+// Attribute is a SMART attribute. Value, Worst and Threshold are normalized,
+// with lower values being worse; Raw is the vendor-specific raw value.
+type Attribute struct {
+	ID        uint8
+	Name      string
+	Flags     uint16
+	Value     uint8
+	Worst     uint8
+	Threshold uint8
+	Raw       uint64 // 48 bits
+}
+
+// Prefailure reports whether reaching the threshold predicts a failure,
+// rather than the end of the drive's life.
+func (a *Attribute) Prefailure() bool {
+	return a.Flags&0x0001 != 0
+}
+
+// Verdict judges the attribute.
+func (a *Attribute) Verdict() Verdict {
+	reached := a.Threshold != 0 && a.Value <= a.Threshold
+	if reached && a.Prefailure() {
+		return Failing
+	}
+	if reached || (a.Threshold != 0 && a.Worst <= a.Threshold) {
+		return Warning
+	}
+	switch a.ID {
+	case AttrReallocatedSectors, AttrReallocatedEvents, AttrPendingSectors, AttrUncorrectable:
+		if a.Raw&0xffffffff != 0 {
+			return Warning
+		}
+	}
+	return Healthy
+}
+
+func (a *Attribute) String() string {
+	return fmt.Sprintf("%3d %-24s %3d %3d %3d %d", a.ID, a.Name, a.Value, a.Worst, a.Threshold, a.Raw)
+}
+
+// SMART is the SMART data of a drive.
+type SMART struct {
+	Revision   uint16
+	Attributes []Attribute
+	// StatusKnown reports whether the drive returned its SMART status,
+	// which bridges that do not return the ATA registers cannot.
+	StatusKnown bool
+	// StatusFailing is the SMART status: whether the drive found a
+	// threshold exceeded.
+	StatusFailing bool
+}
+
+// Attribute returns the attribute with an ID, or nil.
+func (s *SMART) Attribute(id uint8) *Attribute {
+	for i := range s.Attributes {
+		if s.Attributes[i].ID == id {
+			return &s.Attributes[i]
+		}
+	}
+	return nil
+}
+
+// Verdict judges the health of the drive by its status and the worst of its
+// attributes.
+func (s *SMART) Verdict() Verdict {
+	v := Healthy
+	if s.StatusKnown && s.StatusFailing {
+		v = Failing
+	}
+	for i := range s.Attributes {
+		v = max(v, s.Attributes[i].Verdict())
+	}
+	return v
+}
+
+// Temperature returns the temperature of the drive in degrees Celsius.
+func (s *SMART) Temperature() (int, bool) {
+	a := s.Attribute(AttrTemperature)
+	if a == nil {
+		a = s.Attribute(AttrAirflowTemperature)
+	}
+	if a == nil {
+		return 0, false
+	}
+	// The low byte is the current temperature; the other bytes hold the
+	// minimum and maximum on some drives.
+	return int(uint8(a.Raw)), true
+}
+
+// PowerOnHours returns the number of hours the drive has been powered on.
+func (s *SMART) PowerOnHours() (int, bool) {
+	a := s.Attribute(AttrPowerOnHours)
+	if a == nil {
+		return 0, false
+	}
+	return int(uint32(a.Raw)), true
+}
+
+const numAttributes = 30
+
+// ParseSMART decodes the data structures of SMART READ DATA and, if not
+// nil, SMART READ ATTRIBUTE THRESHOLDS. The status is unknown.
+func ParseSMART(data, thresholds []byte) (*SMART, error) {
+	if len(data) < 512 {
+		return nil, fmt.Errorf("ata: SMART data of %d bytes", len(data))
+	}
+	if s := sum(data[:512]); s != 0 {
+		return nil, fmt.Errorf("ata: SMART data checksum %#02x", s)
+	}
+	limits := map[uint8]uint8{}
+	if thresholds != nil {
+		if len(thresholds) < 512 {
+			return nil, fmt.Errorf("ata: SMART thresholds of %d bytes", len(thresholds))
+		}
+		if s := sum(thresholds[:512]); s != 0 {
+			return nil, fmt.Errorf("ata: SMART thresholds checksum %#02x", s)
+		}
+		for i := range numAttributes {
+			e := thresholds[2+12*i:]
+			if e[0] != 0 {
+				limits[e[0]] = e[1]
+			}
+		}
+	}
+	s := &SMART{Revision: binary.LittleEndian.Uint16(data)}
+	for i := range numAttributes {
+		e := data[2+12*i:]
+		if e[0] == 0 {
+			continue
+		}
+		a := Attribute{
+			ID:        e[0],
+			Name:      attributeNames[e[0]],
+			Flags:     binary.LittleEndian.Uint16(e[1:]),
+			Value:     e[3],
+			Worst:     e[4],
+			Threshold: limits[e[0]],
+		}
+		for j := 5; j >= 0; j-- {
+			a.Raw = a.Raw<<8 | uint64(e[5+j])
+		}
+		if a.Name == "" {
+			a.Name = "Unknown_Attribute"
+		}
+		s.Attributes = append(s.Attributes, a)
+	}
+	return s, nil
+}
+
+// ReadSMART reads the attributes, their thresholds and the status of the
+// drive. Bridges that do not return the ATA registers leave the status
+// unknown, and the verdict relies on the thresholds.
+func ReadSMART(p Passthrough) (*SMART, error) {
+	data, err := ReadSMARTData(p)
+	if err != nil {
+		return nil, err
+	}
+	thresholds, err := ReadSMARTThresholds(p)
+	if err != nil {
+		return nil, err
+	}
+	s, err := ParseSMART(data, thresholds)
+	if err != nil {
+		return nil, err
+	}
+	failing, err := ReturnStatus(p)
+	switch {
+	case err == nil:
+		s.StatusKnown, s.StatusFailing = true, failing
+	case !errors.Is(err, ErrNoRegisters):
+		return nil, err
+	}
+	return s, nil
+}
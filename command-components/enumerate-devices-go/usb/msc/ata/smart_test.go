@@ -0,0 +1,111 @@
+package ata
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+var testAttributes = []Attribute{
+	{ID: 1, Flags: 0x002f, Value: 200, Worst: 200, Threshold: 51},
+	{ID: 5, Flags: 0x0033, Value: 200, Worst: 200, Threshold: 140},
+	{ID: 9, Flags: 0x0032, Value: 62, Worst: 62, Raw: 28115},
+	{ID: 194, Flags: 0x0022, Value: 116, Worst: 98, Raw: 36 | 18<<16 | 52<<32},
+	{ID: 200, Flags: 0x0008, Value: 100, Worst: 253},
+}
+
+func smartData(attrs ...Attribute) []byte {
+	b := make([]byte, 512)
+	binary.LittleEndian.PutUint16(b, 0x0010)
+	for i, a := range attrs {
+		e := b[2+12*i:]
+		e[0] = a.ID
+		binary.LittleEndian.PutUint16(e[1:], a.Flags)
+		e[3], e[4] = a.Value, a.Worst
+		for j := range 6 {
+			e[5+j] = uint8(a.Raw >> (8 * j))
+		}
+	}
+	b[511] = -sum(b)
+	return b
+}
+
+func smartThresholds(attrs ...Attribute) []byte {
+	b := make([]byte, 512)
+	binary.LittleEndian.PutUint16(b, 0x0010)
+	for i, a := range attrs {
+		b[2+12*i], b[3+12*i] = a.ID, a.Threshold
+	}
+	b[511] = -sum(b)
+	return b
+}
+
+func TestParseSMART(t *testing.T) {
+	s, err := ParseSMART(smartData(testAttributes...), smartThresholds(testAttributes...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Revision != 0x10 || len(s.Attributes) != len(testAttributes) {
+		t.Fatalf("%+v", s)
+	}
+	for i, a := range s.Attributes {
+		want := testAttributes[i]
+		want.Name = a.Name
+		if a != want {
+			t.Errorf("attribute %d: %+v", i, a)
+		}
+	}
+	if a := s.Attribute(5); a == nil || a.Name != "Reallocated_Sector_Ct" || !a.Prefailure() {
+		t.Errorf("attribute 5: %+v", a)
+	}
+	if a := s.Attribute(200); a == nil || a.Name != "Unknown_Attribute" {
+		t.Errorf("attribute 200: %+v", a)
+	}
+	if temp, ok := s.Temperature(); !ok || temp != 36 {
+		t.Errorf("temperature %d", temp)
+	}
+	if hours, ok := s.PowerOnHours(); !ok || hours != 28115 {
+		t.Errorf("power on hours %d", hours)
+	}
+	if s.Verdict() != Healthy {
+		t.Errorf("verdict %v", s.Verdict())
+	}
+
+	// Without thresholds.
+	if s, err := ParseSMART(smartData(testAttributes...), nil); err != nil || s.Attribute(5).Threshold != 0 {
+		t.Errorf("without thresholds: %v", err)
+	}
+
+	data := smartData(testAttributes...)
+	data[5]++
+	if _, err := ParseSMART(data, nil); err == nil {
+		t.Error("bad checksum accepted")
+	}
+}
+
+func TestVerdict(t *testing.T) {
+	for _, test := range []struct {
+		a    Attribute
+		want Verdict
+	}{
+		{Attribute{ID: 5, Flags: 0x0033, Value: 100, Worst: 100, Threshold: 10}, Healthy},
+		{Attribute{ID: 5, Flags: 0x0033, Value: 100, Worst: 100, Threshold: 10, Raw: 8}, Warning},
+		{Attribute{ID: 197, Flags: 0x0032, Value: 200, Worst: 200, Raw: 1}, Warning},
+		{Attribute{ID: 1, Flags: 0x000b, Value: 100, Worst: 9, Threshold: 16}, Warning},
+		{Attribute{ID: 9, Flags: 0x0032, Value: 1, Worst: 1, Threshold: 1}, Warning},
+		{Attribute{ID: 1, Flags: 0x000b, Value: 16, Worst: 16, Threshold: 16}, Failing},
+		{Attribute{ID: 190, Flags: 0x0022, Value: 0, Worst: 0}, Healthy},
+	} {
+		if v := test.a.Verdict(); v != test.want {
+			t.Errorf("%+v: %v, want %v", test.a, v, test.want)
+		}
+	}
+
+	s := &SMART{Attributes: []Attribute{{ID: 197, Value: 100, Worst: 100, Raw: 4}}}
+	if s.Verdict() != Warning {
+		t.Errorf("pending sectors: %v", s.Verdict())
+	}
+	s.StatusKnown, s.StatusFailing = true, true
+	if s.Verdict() != Failing {
+		t.Errorf("failing status: %v", s.Verdict())
+	}
+}
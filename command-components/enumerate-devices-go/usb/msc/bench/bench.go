@@ -0,0 +1,359 @@
+// Package bench measures the raw speed of mass storage media.
+//
+// Run moves data straight over an msc.Medium, without a block cache or file
+// system, in sequential and random tests of a given transfer size and queue
+// depth. The commands of a test of depth 1 run one at a time. A deeper test
+// needs a medium that queues commands, such as a *scsi.Device over UAS
+// with bulk streams, and submits its commands in batches of its depth that
+// the device runs at once. Every command is timed, with the latency of its
+// batch; a Result reports the throughput in MB/s, the IOPS and the latency
+// percentiles of a test. A Report marshals to JSON, so that runs on the host
+// and in the guest can be compared.
+package bench
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"time"
+
+	"example.com/usb/msc"
+	"example.com/usb/msc/scsi"
+)
+
+// ErrWrite is returned for a write test without Options.Write.
+var ErrWrite = errors.New("bench: write tests destroy data and need Options.Write")
+
+// Test is one measurement.
+type Test struct {
+	Random     bool // random offsets aligned to Size, instead of sequential
+	Write      bool
+	Size       int // bytes per command, a multiple of the block size
+	QueueDepth int // commands in flight at once, defaults to 1
+}
+
+// Name returns a short name such as "rand-read-4K", followed by the queue
+// depth if it is above 1, as in "rand-read-4K-qd8".
+func (t Test) Name() string {
+	pattern, op := "seq", "read"
+	if t.Random {
+		pattern = "rand"
+	}
+	if t.Write {
+		op = "write"
+	}
+	name := fmt.Sprintf("%s-%s-%s", pattern, op, size(t.Size))
+	if t.QueueDepth > 1 {
+		name += fmt.Sprintf("-qd%d", t.QueueDepth)
+	}
+	return name
+}
+
+// Queuer is a medium that runs several reads and writes at once, such as
+// *scsi.Device.
+type Queuer interface {
+	QueueDepth() int
+	Queue(xs []scsi.Transfer) error
+}
+
+func size(n int) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dM", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dK", n>>10)
+	}
+	return fmt.Sprint(n)
+}
+
+// DefaultTests returns sequential tests of 1 MiB and 64 KiB and a random
+// test of 4 KiB, for reads, and for writes too if write is set.
+func DefaultTests(write bool) []Test {
+	var tests []Test
+	for _, w := range []bool{false, true} {
+		if w && !write {
+			break
+		}
+		tests = append(tests,
+			Test{Write: w, Size: 1 << 20},
+			Test{Write: w, Size: 64 << 10},
+			Test{Random: true, Write: w, Size: 4 << 10},
+		)
+	}
+	return tests
+}
+
+// Options tune a benchmark. The zero value reads from 1 MiB on.
+type Options struct {
+	// Start is the byte offset of the span tested, rounded up to a block.
+	// Defaults to 1 MiB, past the partition table.
+	Start int64
+	// Span is the number of bytes from Start the tests address. Defaults
+	// to 256 MiB, or less on smaller media.
+	Span int64
+	// Amount is the number of bytes each test moves. Defaults to 64 MiB,
+	// but no more than the span for sequential tests.
+	Amount int64
+	// Duration, if set, ends a test early once it has run that long.
+	Duration time.Duration
+	// Tests defaults to DefaultTests(Write).
+	Tests []Test
+	// Write allows write tests. They overwrite the span with random data.
+	Write bool
+	// BypassCache makes commands go to the medium, not the device's cache,
+	// with the force unit access bit. The medium must implement
+	// SetForceUnitAccess, as *scsi.Device does.
+	BypassCache bool
+	// Seed seeds the random offsets and data.
+	Seed uint64
+	// Progress, if set, is called with the result of every test.
+	Progress func(*Result)
+}
+
+// Latency summarizes the command latencies of a test, in microseconds.
+type Latency struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p99_9"`
+	Max  float64 `json:"max"`
+}
+
+// Result is the outcome of a test.
+type Result struct {
+	Name       string  `json:"name"`
+	Random     bool    `json:"random"`
+	Write      bool    `json:"write"`
+	Size       int     `json:"size"`
+	QueueDepth int     `json:"queue_depth"`
+	Ops        int     `json:"ops"`
+	Bytes      int64   `json:"bytes"`
+	Seconds    float64 `json:"seconds"`
+	MBps       float64 `json:"mb_per_s"` // 10^6 bytes per second
+	IOPS       float64 `json:"iops"`
+	Latency    Latency `json:"latency_us"`
+}
+
+// Report is the outcome of a benchmark.
+type Report struct {
+	Build       string    `json:"build"` // GOOS/GOARCH and compiler
+	Device      string    `json:"device,omitempty"`
+	Time        time.Time `json:"time"`
+	Blocks      uint64    `json:"blocks"`
+	BlockSize   int       `json:"block_size"`
+	Start       int64     `json:"start"`
+	Span        int64     `json:"span"`
+	BypassCache bool      `json:"bypass_cache"`
+	Results     []*Result `json:"results"`
+}
+
+// WriteJSON writes r as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Run runs the tests on m.
+func Run(m msc.Medium, opts *Options) (*Report, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	capacity, err := m.ReadCapacity()
+	if err != nil {
+		return nil, err
+	}
+	bs := int64(capacity.BlockSize)
+	total := int64(capacity.Blocks) * bs
+	if o.Start == 0 {
+		o.Start = 1 << 20
+	}
+	o.Start = (o.Start + bs - 1) / bs * bs
+	if o.Span == 0 {
+		o.Span = 256 << 20
+	}
+	o.Span = min(o.Span, total-o.Start) / bs * bs
+	if o.Amount == 0 {
+		o.Amount = 64 << 20
+	}
+	if o.Tests == nil {
+		o.Tests = DefaultTests(o.Write)
+	}
+	for _, t := range o.Tests {
+		switch {
+		case t.Size <= 0 || int64(t.Size)%bs != 0:
+			return nil, fmt.Errorf("bench: %s: size is not a multiple of the block size %d", t.Name(), bs)
+		case int64(t.Size) > o.Span:
+			return nil, fmt.Errorf("bench: %s: span of %d bytes from %d is too small", t.Name(), o.Span, o.Start)
+		case t.Write && !o.Write:
+			return nil, fmt.Errorf("%w: %s", ErrWrite, t.Name())
+		case t.QueueDepth > 1 && queueDepth(m) < t.QueueDepth:
+			return nil, fmt.Errorf("bench: %s: %T queues at most %d commands", t.Name(), m, queueDepth(m))
+		}
+	}
+	if o.BypassCache {
+		fua, ok := m.(interface{ SetForceUnitAccess(bool) })
+		if !ok {
+			return nil, fmt.Errorf("bench: %T cannot bypass the cache", m)
+		}
+		fua.SetForceUnitAccess(true)
+		defer fua.SetForceUnitAccess(false)
+	}
+
+	r := &Report{
+		Build:       runtime.GOOS + "/" + runtime.GOARCH + " " + runtime.Compiler,
+		Time:        time.Now(),
+		Blocks:      capacity.Blocks,
+		BlockSize:   int(bs),
+		Start:       o.Start,
+		Span:        o.Span,
+		BypassCache: o.BypassCache,
+	}
+	rng := rand.New(rand.NewPCG(o.Seed, o.Seed))
+	for _, t := range o.Tests {
+		res, err := run(m, t, &o, bs, rng)
+		if err != nil {
+			return nil, fmt.Errorf("bench: %s: %w", t.Name(), err)
+		}
+		if o.Progress != nil {
+			o.Progress(res)
+		}
+		r.Results = append(r.Results, res)
+	}
+	return r, nil
+}
+
+// queueDepth returns the most commands m runs at once.
+func queueDepth(m msc.Medium) int {
+	if q, ok := m.(Queuer); ok {
+		return q.QueueDepth()
+	}
+	return 1
+}
+
+// run runs test t, one command or one batch of QueueDepth commands after
+// the other.
+func run(m msc.Medium, t Test, o *Options, blockSize int64, rng *rand.Rand) (*Result, error) {
+	depth := max(t.QueueDepth, 1)
+	slots := o.Span / int64(t.Size)
+	ops := int((o.Amount + int64(t.Size) - 1) / int64(t.Size))
+	if !t.Random {
+		ops = min(ops, int(slots))
+	}
+	offsets := make([]int64, ops)
+	for i := range offsets {
+		slot := int64(i)
+		if t.Random {
+			slot = rng.Int64N(slots)
+		}
+		offsets[i] = o.Start + slot*int64(t.Size)
+	}
+	batch := make([]scsi.Transfer, depth)
+	for i := range batch {
+		batch[i] = scsi.Transfer{Write: t.Write, Buf: make([]byte, t.Size)}
+		if t.Write {
+			fill(batch[i].Buf, rng)
+		}
+	}
+	latencies := make([]time.Duration, 0, ops)
+	start := time.Now()
+	for len(offsets) > 0 {
+		if o.Duration != 0 && time.Since(start) >= o.Duration {
+			break
+		}
+		xs := batch[:min(depth, len(offsets))]
+		for i := range xs {
+			xs[i].LBA = uint64(offsets[i] / blockSize)
+		}
+		offsets = offsets[len(xs):]
+		t0 := time.Now()
+		var err error
+		switch x := xs[0]; {
+		case depth > 1:
+			err = m.(Queuer).Queue(xs)
+		case t.Write:
+			err = m.Write(x.LBA, x.Buf)
+		default:
+			err = m.Read(x.LBA, x.Buf)
+		}
+		latency := time.Since(t0)
+		for range xs {
+			latencies = append(latencies, latency)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	elapsed := time.Since(start)
+	if t.Write {
+		if _, err := msc.SynchronizeCache(m); err != nil {
+			return nil, err
+		}
+	}
+
+	n := len(latencies)
+	res := &Result{
+		Name:       t.Name(),
+		Random:     t.Random,
+		Write:      t.Write,
+		Size:       t.Size,
+		QueueDepth: depth,
+		Ops:        n,
+		Bytes:      int64(n) * int64(t.Size),
+		Seconds:    elapsed.Seconds(),
+		Latency:    summarize(latencies),
+	}
+	if elapsed > 0 {
+		res.MBps = float64(res.Bytes) / 1e6 / res.Seconds
+		res.IOPS = float64(res.Ops) / res.Seconds
+	}
+	return res, nil
+}
+
+// summarize returns the latency percentiles of d, which it sorts, using the
+// nearest rank.
+func summarize(d []time.Duration) Latency {
+	if len(d) == 0 {
+		return Latency{}
+	}
+	slices.Sort(d)
+	var sum time.Duration
+	for _, x := range d {
+		sum += x
+	}
+	us := func(x time.Duration) float64 { return float64(x) / float64(time.Microsecond) }
+	rank := func(p float64) float64 {
+		i := int(p*float64(len(d))+0.999999) - 1
+		return us(d[min(max(i, 0), len(d)-1)])
+	}
+	return Latency{
+		Min:  us(d[0]),
+		Mean: us(sum) / float64(len(d)),
+		P50:  rank(0.50),
+		P90:  rank(0.90),
+		P99:  rank(0.99),
+		P999: rank(0.999),
+		Max:  us(d[len(d)-1]),
+	}
+}
+
+// fill fills b with random bytes, so that devices that compress or
+// deduplicate do not look faster than they are.
+func fill(b []byte, rng *rand.Rand) {
+	for i := 0; i+8 <= len(b); i += 8 {
+		x := rng.Uint64()
+		for j := range 8 {
+			b[i+j] = byte(x >> (8 * j))
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"example.com/usb"
+	"example.com/usb/msc/msctest"
+	"example.com/usb/msc/scsi"
+	"example.com/usb/msc/uas"
+)
+
+// transfer is a READ(10) or WRITE(10) seen by the emulated disk.
+type transfer struct {
+	op     uint8
+	fua    bool
+	lba    uint32
+	blocks uint16
+}
+
+func record(lun *msctest.LUN) *[]transfer {
+	var seen []transfer
+	lun.Fault = func(cb []byte) msctest.Sense {
+		if cb[0] == scsi.OpRead10 || cb[0] == scsi.OpWrite10 {
+			seen = append(seen, transfer{cb[0], cb[1]&scsi.FUA != 0, binary.BigEndian.Uint32(cb[2:]), binary.BigEndian.Uint16(cb[7:])})
+		}
+		return msctest.Sense{}
+	}
+	return &seen
+}
+
+func TestRun(t *testing.T) {
+	lun := msctest.NewLUN(8192, 512) // 4 MiB
+	seen := record(lun)
+	var progress []string
+	r, err := Run(scsi.New(scsi.BulkOnly(msctest.NewTransport(t, lun)), 0), &Options{
+		Amount:   256 << 10,
+		Write:    true,
+		Progress: func(r *Result) { progress = append(progress, r.Name) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Start != 1<<20 || r.Span != 3<<20 || r.Blocks != 8192 || r.BlockSize != 512 || len(r.Results) != 6 {
+		t.Fatalf("report %+v", r)
+	}
+	want := []string{
+		"seq-read-1M", "seq-read-64K", "rand-read-4K",
+		"seq-write-1M", "seq-write-64K", "rand-write-4K",
+	}
+	for i, res := range r.Results {
+		if res.Name != want[i] || progress[i] != want[i] {
+			t.Errorf("result %d is %s", i, res.Name)
+		}
+		if res.Bytes != int64(res.Ops*res.Size) || res.MBps <= 0 || res.IOPS <= 0 {
+			t.Errorf("%s: %+v", res.Name, res)
+		}
+		l := res.Latency
+		if l.Min > l.P50 || l.P50 > l.P90 || l.P90 > l.P99 || l.P99 > l.P999 || l.P999 > l.Max || l.Mean < l.Min || l.Mean > l.Max {
+			t.Errorf("%s: latency %+v", res.Name, l)
+		}
+	}
+	// The 1 MiB tests stop at the end of the span.
+	if r.Results[0].Ops != 1 || r.Results[2].Ops != 64 {
+		t.Errorf("seq-read-1M did %d ops, rand-read-4K %d", r.Results[0].Ops, r.Results[2].Ops)
+	}
+
+	// Every transfer is within the span and aligned to its size.
+	for _, x := range *seen {
+		size := uint32(x.blocks) * 512
+		if x.lba < 2048 || x.lba+uint32(x.blocks) > 8192 || x.lba*512%size != 0 || x.fua {
+			t.Errorf("transfer %+v", x)
+		}
+	}
+	// The data written is random, and nothing before the span was touched.
+	if written := lun.Data[1<<20 : 5<<18]; bytes.Count(written, []byte{0}) > len(written)/100 {
+		t.Error("written data is not random")
+	}
+	if bytes.ContainsFunc(lun.Data[:1<<20], func(r rune) bool { return r != 0 }) {
+		t.Error("data before the span was written")
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	res := decoded["results"].([]any)[2].(map[string]any)
+	if res["name"] != "rand-read-4K" || res["latency_us"].(map[string]any)["p99_9"] == nil {
+		t.Errorf("JSON %s", buf.String())
+	}
+}
+
+func TestBypassCache(t *testing.T) {
+	lun := msctest.NewLUN(4096, 512)
+	seen := record(lun)
+	m := scsi.New(scsi.BulkOnly(msctest.NewTransport(t, lun)), 0)
+	_, err := Run(m, &Options{
+		Span:        64 << 10,
+		Amount:      64 << 10,
+		Tests:       []Test{{Size: 4096}, {Random: true, Write: true, Size: 4096}},
+		Write:       true,
+		BypassCache: true,
+		Seed:        7,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*seen) != 32 {
+		t.Errorf("%d transfers", len(*seen))
+	}
+	for _, x := range *seen {
+		if !x.fua {
+			t.Errorf("transfer %+v without FUA", x)
+		}
+	}
+	// The medium is left as it was.
+	*seen = nil
+	m.Read(0, make([]byte, 512))
+	if len(*seen) != 1 || (*seen)[0].fua {
+		t.Errorf("after the run: %+v", *seen)
+	}
+}
+
+func TestWriteWithoutSynchronizeCache(t *testing.T) {
+	lun := msctest.NewLUN(4096, 512)
+	lun.SynchronizeCacheUnsupported = true
+	r, err := Run(scsi.New(scsi.BulkOnly(msctest.NewTransport(t, lun)), 0), &Options{
+		Amount: 64 << 10,
+		Tests:  []Test{{Write: true, Size: 4096}},
+		Write:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := r.Results[0]; res.Ops != 16 {
+		t.Errorf("%d ops", res.Ops)
+	}
+}
+
+// A UAS device with bulk streams runs the commands of a test deeper than 1
+// at once.
+func TestQueueDepth(t *testing.T) {
+	disk := msctest.NewUAS(msctest.NewLUN(4096, 512))
+	seen := record(disk.LUNs[0])
+	d := usb.NewDevice(disk)
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	tr, _, err := uas.Open(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := Run(scsi.New(tr, 0), &Options{
+		Span:   1 << 20,
+		Amount: 64 << 10,
+		Tests:  []Test{{Random: true, Size: 4096, QueueDepth: 4}, {Random: true, Write: true, Size: 4096, QueueDepth: 6}},
+		Write:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"rand-read-4K-qd4", "rand-write-4K-qd6"} {
+		if res := r.Results[i]; res.Name != want || res.QueueDepth != 4+2*i || res.Ops != 16 {
+			t.Errorf("result %+v", res)
+		}
+	}
+	if len(*seen) != 32 {
+		t.Errorf("%d transfers", len(*seen))
+	}
+
+	// A Bulk-Only device runs one command at a time.
+	m := scsi.New(scsi.BulkOnly(msctest.NewTransport(t, msctest.NewLUN(4096, 512))), 0)
+	if _, err := Run(m, &Options{Tests: []Test{{Random: true, Size: 4096, QueueDepth: 2}}}); err == nil {
+		t.Error("queue depth 2 over Bulk-Only")
+	}
+}
+
+func TestDuration(t *testing.T) {
+	lun := msctest.NewLUN(4096, 512)
+	lun.Fault = func(cb []byte) msctest.Sense {
+		time.Sleep(time.Millisecond)
+		return msctest.Sense{}
+	}
+	r, err := Run(scsi.New(scsi.BulkOnly(msctest.NewTransport(t, lun)), 0), &Options{
+		Tests:    []Test{{Random: true, Size: 512}},
+		Duration: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := r.Results[0]; res.Ops == 0 || res.Ops > 100 {
+		t.Errorf("%d ops in %.3fs", res.Ops, res.Seconds)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	lun := msctest.NewLUN(4096, 512)
+	seen := record(lun)
+	m := scsi.New(scsi.BulkOnly(msctest.NewTransport(t, lun)), 0)
+	for _, opts := range []*Options{
+		{Tests: []Test{{Size: 1000}}},
+		{Tests: []Test{{Size: 2 << 20}}},
+		{Tests: []Test{{Write: true, Size: 4096}}},
+		{Start: 4 << 20},
+	} {
+		if _, err := Run(m, opts); err == nil {
+			t.Errorf("%+v: no error", opts)
+		}
+	}
+	if _, err := Run(m, &Options{Tests: []Test{{Write: true, Size: 4096}}}); !errors.Is(err, ErrWrite) {
+		t.Errorf("err = %v", err)
+	}
+	if len(*seen) != 0 {
+		t.Errorf("%d transfers", len(*seen))
+	}
+
+	lun.Fault = func(cb []byte) msctest.Sense {
+		if cb[0] == scsi.OpRead10 {
+			return msctest.SenseMediumError
+		}
+		return msctest.Sense{}
+	}
+	if _, err := Run(m, &Options{Tests: []Test{{Size: 4096}}}); err == nil {
+		t.Error("medium error not returned")
+	}
+}
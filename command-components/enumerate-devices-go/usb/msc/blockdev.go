@@ -0,0 +1,412 @@
+// Package msc provides byte-addressed access to USB mass storage media.
+//
+// The transports and command sets live in the subpackages: bot for the
+// Bulk-Only Transport and scsi for the commands. BlockDevice builds on a
+// scsi.Device, or anything else that reads and writes whole blocks.
+// Probe finds the logical units of a device, such as the slots of a card
+// reader, each with a BlockDevice of its own, and Watch reports the media
+// inserted into and removed from them.
+package msc
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync/atomic"
+
+	"example.com/usb/msc/scsi"
+)
+
+// Medium reads and writes whole logical blocks. *scsi.Device implements it.
+type Medium interface {
+	ReadCapacity() (scsi.Capacity, error)
+	Read(lba uint64, buf []byte) error
+	Write(lba uint64, buf []byte) error
+	SynchronizeCache() error
+}
+
+var _ Medium = (*scsi.Device)(nil)
+
+// SynchronizeCache asks m to write its cache to the medium, and reports
+// whether m supports that. Many USB sticks reject SYNCHRONIZE CACHE with
+// ILLEGAL REQUEST; they have no volatile cache, so that is not an error.
+func SynchronizeCache(m Medium) (supported bool, err error) {
+	err = m.SynchronizeCache()
+	if errors.Is(err, scsi.ErrInvalidOpcode) || errors.Is(err, scsi.ErrIllegalRequest) {
+		return false, nil
+	}
+	return true, err
+}
+
+var (
+	// ErrOutOfRange is returned for writes beyond the end of the medium.
+	ErrOutOfRange = errors.New("msc: write beyond the end of the medium")
+	// ErrMediumChanged is returned by a BlockDevice whose medium was
+	// removed or replaced.
+	ErrMediumChanged = errors.New("msc: medium removed or changed")
+)
+
+// Options configures a BlockDevice.
+type Options struct {
+	// CacheBlocks is the number of blocks cached. Defaults to 256.
+	CacheBlocks int
+	// ReadAhead is the number of blocks read beyond a read that continues
+	// the previous one. Negative disables read-ahead. Defaults to 32.
+	ReadAhead int
+	// MaxTransfer is the largest number of blocks read or written with one
+	// command. Defaults to 128.
+	MaxTransfer int
+}
+
+// BlockDevice is a byte-addressed view of a Medium with a write-back LRU
+// cache of blocks. Reads of uncached blocks are merged into one command per
+// run of blocks, and sequential reads fetch the following blocks ahead of
+// time. Writes only reach the medium when dirty blocks are evicted, in runs
+// of adjacent dirty blocks, or on Sync.
+//
+// When the medium is removed or replaced, the cache is dropped, dirty blocks
+// included, and every later call fails with ErrMediumChanged: open a new
+// BlockDevice for the new medium.
+//
+// A BlockDevice is not safe for concurrent use, except for Invalidate.
+type BlockDevice struct {
+	m         Medium
+	opts      Options
+	blockSize int
+	blocks    uint64
+	offset    int64 // for Read, Write and Seek
+
+	lru    *list.List // of *block, most recently used first
+	cached map[uint64]*list.Element
+	next   uint64 // the block after the last read, to detect sequential reads
+
+	written bool // the medium was written since the last cache flush
+	noSync  bool // the medium does not support SYNCHRONIZE CACHE
+
+	stale atomic.Bool // set by Invalidate
+}
+
+type block struct {
+	lba   uint64
+	data  []byte
+	dirty bool
+}
+
+var _ interface {
+	io.ReaderAt
+	io.WriterAt
+	io.ReadWriteSeeker
+	io.Closer
+} = (*BlockDevice)(nil)
+
+// NewBlockDevice reads the capacity of m and returns a BlockDevice for it.
+func NewBlockDevice(m Medium, opts Options) (*BlockDevice, error) {
+	c, err := m.ReadCapacity()
+	if err != nil {
+		return nil, err
+	}
+	if c.BlockSize == 0 {
+		return nil, errors.New("msc: medium reports a block size of 0")
+	}
+	if opts.CacheBlocks <= 0 {
+		opts.CacheBlocks = 256
+	}
+	if opts.ReadAhead == 0 {
+		opts.ReadAhead = 32
+	}
+	if opts.MaxTransfer <= 0 {
+		opts.MaxTransfer = 128
+	}
+	// A transfer must fit in the cache.
+	opts.MaxTransfer = min(opts.MaxTransfer, opts.CacheBlocks)
+	opts.ReadAhead = min(opts.ReadAhead, opts.MaxTransfer)
+	return &BlockDevice{
+		m:         m,
+		opts:      opts,
+		blockSize: int(c.BlockSize),
+		blocks:    c.Blocks,
+		lru:       list.New(),
+		cached:    make(map[uint64]*list.Element),
+		next:      ^uint64(0),
+	}, nil
+}
+
+// BlockSize returns the logical block size of the medium.
+func (b *BlockDevice) BlockSize() int {
+	return b.blockSize
+}
+
+// Size returns the size of the medium in bytes.
+func (b *BlockDevice) Size() int64 {
+	return int64(b.blocks) * int64(b.blockSize)
+}
+
+// ReadAt reads len(p) bytes at offset off. Like a file, it returns io.EOF if
+// fewer bytes are left on the medium.
+func (b *BlockDevice) ReadAt(p []byte, off int64) (int, error) {
+	if err := b.check(); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("msc: negative offset %d", off)
+	}
+	if off >= b.Size() {
+		return 0, io.EOF
+	}
+	var eof error
+	if int64(len(p)) > b.Size()-off {
+		p, eof = p[:b.Size()-off], io.EOF
+	}
+
+	bs := int64(b.blockSize)
+	first, last := uint64(off/bs), uint64((off+int64(len(p))-1)/bs)
+	sequential := first == b.next
+	b.next = last + 1
+	n := 0
+	for lba := first; lba <= last; {
+		// Copy the cached blocks, then read the run of uncached blocks
+		// after them with one command.
+		if e, ok := b.cached[lba]; ok {
+			b.lru.MoveToFront(e)
+			n += copy(p[n:], e.Value.(*block).data[(off+int64(n))%bs:])
+			lba++
+			continue
+		}
+		end := lba + 1
+		for end <= last && end-lba < uint64(b.opts.MaxTransfer) && b.cached[end] == nil {
+			end++
+		}
+		data, err := b.fetch(lba, end, sequential)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[(off+int64(n))%bs:])
+		lba = end
+	}
+	return n, eof
+}
+
+// fetch reads the uncached blocks [lba, end) into the cache and returns
+// their data. With ahead set, the blocks after end are read too.
+func (b *BlockDevice) fetch(lba, end uint64, ahead bool) ([]byte, error) {
+	read := end
+	if ahead && b.opts.ReadAhead > 0 {
+		limit := min(b.blocks, end+uint64(b.opts.ReadAhead), lba+uint64(b.opts.MaxTransfer))
+		for read < limit && b.cached[read] == nil {
+			read++
+		}
+	}
+	data := make([]byte, int(read-lba)*b.blockSize)
+	if err := b.m.Read(lba, data); err != nil {
+		return nil, b.failed(err)
+	}
+	// Read-ahead blocks are least likely to be used, so they go in first.
+	for i := read; i > lba; i-- {
+		start := int(i-1-lba) * b.blockSize
+		if err := b.insert(&block{lba: i - 1, data: data[start : start+b.blockSize : start+b.blockSize]}); err != nil {
+			return nil, err
+		}
+	}
+	return data[:int(end-lba)*b.blockSize], nil
+}
+
+// WriteAt writes p at offset off. Blocks that are only partly overwritten
+// are read first. The data reaches the medium when the blocks are evicted
+// from the cache or on Sync.
+func (b *BlockDevice) WriteAt(p []byte, off int64) (int, error) {
+	if err := b.check(); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("msc: negative offset %d", off)
+	}
+	var short error
+	if off > b.Size() || int64(len(p)) > b.Size()-off {
+		p, short = p[:max(0, b.Size()-off)], ErrOutOfRange
+	}
+
+	bs := int64(b.blockSize)
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		lba, start := uint64(pos/bs), int(pos%bs)
+		length := min(len(p)-n, b.blockSize-start)
+
+		var blk *block
+		if e, ok := b.cached[lba]; ok {
+			b.lru.MoveToFront(e)
+			blk = e.Value.(*block)
+		} else if length == b.blockSize {
+			blk = &block{lba: lba, data: make([]byte, b.blockSize)}
+			if err := b.insert(blk); err != nil {
+				return n, err
+			}
+		} else {
+			if _, err := b.fetch(lba, lba+1, false); err != nil {
+				return n, err
+			}
+			blk = b.cached[lba].Value.(*block)
+		}
+		copy(blk.data[start:], p[n:n+length])
+		blk.dirty = true
+		n += length
+	}
+	return n, short
+}
+
+// insert adds blk to the cache as the most recently used block, evicting
+// the least recently used one first if the cache is full. Dirty blocks are
+// written back, together with the dirty blocks next to them. If that fails,
+// blk is not cached, so that the cache never holds a block whose data was
+// not filled in.
+func (b *BlockDevice) insert(blk *block) error {
+	for b.lru.Len() >= b.opts.CacheBlocks {
+		e := b.lru.Back()
+		victim := e.Value.(*block)
+		if victim.dirty {
+			if err := b.flush(victim.lba); err != nil {
+				return err
+			}
+		}
+		b.lru.Remove(e)
+		delete(b.cached, victim.lba)
+	}
+	b.cached[blk.lba] = b.lru.PushFront(blk)
+	return nil
+}
+
+// flush writes the run of adjacent dirty blocks containing lba, up to
+// MaxTransfer blocks, with one command.
+func (b *BlockDevice) flush(lba uint64) error {
+	first, end := lba, lba+1
+	for first > 0 && end-first < uint64(b.opts.MaxTransfer) && b.dirty(first-1) {
+		first--
+	}
+	for end-first < uint64(b.opts.MaxTransfer) && b.dirty(end) {
+		end++
+	}
+	data := make([]byte, 0, int(end-first)*b.blockSize)
+	for i := first; i < end; i++ {
+		data = append(data, b.cached[i].Value.(*block).data...)
+	}
+	b.written = true
+	if err := b.m.Write(first, data); err != nil {
+		return b.failed(err)
+	}
+	for i := first; i < end; i++ {
+		b.cached[i].Value.(*block).dirty = false
+	}
+	return nil
+}
+
+func (b *BlockDevice) dirty(lba uint64) bool {
+	e, ok := b.cached[lba]
+	return ok && e.Value.(*block).dirty
+}
+
+// Sync writes all dirty blocks to the medium, in ascending order and
+// coalesced into runs, and then asks the device to flush its own cache if
+// anything was written since the last Sync, evictions included. A device
+// that does not support that is not asked again.
+func (b *BlockDevice) Sync() error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	var dirty []uint64
+	for lba, e := range b.cached {
+		if e.Value.(*block).dirty {
+			dirty = append(dirty, lba)
+		}
+	}
+	slices.Sort(dirty)
+	for _, lba := range dirty {
+		if b.dirty(lba) {
+			if err := b.flush(lba); err != nil {
+				return err
+			}
+		}
+	}
+	if !b.written || b.noSync {
+		return nil
+	}
+	supported, err := SynchronizeCache(b.m)
+	if err != nil {
+		return b.failed(err)
+	}
+	b.written, b.noSync = false, !supported
+	return nil
+}
+
+// Invalidate drops the cache, dirty blocks included, and makes every later
+// call fail with ErrMediumChanged. It is called when the medium is found
+// to be removed or replaced, and may be called from any goroutine.
+func (b *BlockDevice) Invalidate() {
+	b.stale.Store(true)
+}
+
+// check returns ErrMediumChanged, and drops the cache, once the device is
+// invalidated.
+func (b *BlockDevice) check() error {
+	if !b.stale.Load() {
+		return nil
+	}
+	if b.lru.Len() > 0 {
+		b.lru.Init()
+		clear(b.cached)
+	}
+	return ErrMediumChanged
+}
+
+// failed invalidates the device if err reports that the medium was removed
+// or replaced, and returns err.
+func (b *BlockDevice) failed(err error) error {
+	if errors.Is(err, scsi.ErrMediumChanged) || errors.Is(err, scsi.ErrMediumNotPresent) {
+		b.Invalidate()
+		b.check()
+		return fmt.Errorf("%w: %w", ErrMediumChanged, err)
+	}
+	return err
+}
+
+// Close writes the dirty blocks to the medium. The cache stays usable.
+func (b *BlockDevice) Close() error {
+	return b.Sync()
+}
+
+// Read reads from the current offset and advances it.
+func (b *BlockDevice) Read(p []byte) (int, error) {
+	n, err := b.ReadAt(p, b.offset)
+	b.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Write writes at the current offset and advances it.
+func (b *BlockDevice) Write(p []byte) (int, error) {
+	n, err := b.WriteAt(p, b.offset)
+	b.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read or Write. Offsets beyond the end
+// of the medium are allowed, but reading there returns io.EOF.
+func (b *BlockDevice) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.offset
+	case io.SeekEnd:
+		offset += b.Size()
+	default:
+		return 0, fmt.Errorf("msc: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("msc: negative offset %d", offset)
+	}
+	b.offset = offset
+	return offset, nil
+}
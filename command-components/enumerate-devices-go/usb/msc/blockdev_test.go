@@ -0,0 +1,361 @@
+package msc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"example.com/usb"
+	"example.com/usb/msc/bot"
+	"example.com/usb/msc/msctest"
+	"example.com/usb/msc/scsi"
+)
+
+// memMedium is a Medium in memory that records the commands it receives.
+type memMedium struct {
+	blockSize int
+	data      []byte
+	ops       []string
+	fail      error
+}
+
+func newMemMedium(blocks, blockSize int) *memMedium {
+	m := &memMedium{blockSize: blockSize, data: make([]byte, blocks*blockSize)}
+	for i := range m.data {
+		m.data[i] = byte(i / blockSize)
+	}
+	return m
+}
+
+func (m *memMedium) ReadCapacity() (scsi.Capacity, error) {
+	return scsi.Capacity{Blocks: uint64(len(m.data) / m.blockSize), BlockSize: uint32(m.blockSize)}, nil
+}
+
+func (m *memMedium) Read(lba uint64, buf []byte) error {
+	m.ops = append(m.ops, fmt.Sprintf("read(%d,%d)", lba, len(buf)/m.blockSize))
+	copy(buf, m.data[int(lba)*m.blockSize:])
+	return m.fail
+}
+
+func (m *memMedium) Write(lba uint64, buf []byte) error {
+	m.ops = append(m.ops, fmt.Sprintf("write(%d,%d)", lba, len(buf)/m.blockSize))
+	if m.fail != nil {
+		return m.fail
+	}
+	copy(m.data[int(lba)*m.blockSize:], buf)
+	return nil
+}
+
+func (m *memMedium) SynchronizeCache() error {
+	m.ops = append(m.ops, "sync")
+	return nil
+}
+
+func (m *memMedium) Ops() string {
+	ops := strings.Join(m.ops, " ")
+	m.ops = nil
+	return ops
+}
+
+func TestReadAtUnaligned(t *testing.T) {
+	m := newMemMedium(16, 512)
+	b, err := NewBlockDevice(m, Options{ReadAhead: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1100)
+	if n, err := b.ReadAt(buf, 500); n != 1100 || err != nil {
+		t.Fatalf("ReadAt = %d, %v", n, err)
+	}
+	if !bytes.Equal(buf, m.data[500:1600]) {
+		t.Error("wrong data")
+	}
+	if got := m.Ops(); got != "read(0,4)" {
+		t.Errorf("ops %q", got)
+	}
+
+	// Blocks 2 and 3 are cached, so only 4 and 5 are read.
+	buf = make([]byte, 2048)
+	if _, err := b.ReadAt(buf, 1024); err != nil || !bytes.Equal(buf, m.data[1024:3072]) {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if got := m.Ops(); got != "read(4,2)" {
+		t.Errorf("ops %q", got)
+	}
+
+	n, err := b.ReadAt(buf, b.Size()-100)
+	if n != 100 || err != io.EOF || !bytes.Equal(buf[:100], m.data[len(m.data)-100:]) {
+		t.Errorf("read at the end = %d, %v", n, err)
+	}
+	if _, err := b.ReadAt(buf, b.Size()); err != io.EOF {
+		t.Errorf("read past the end: %v", err)
+	}
+	if _, err := b.ReadAt(buf, -1); err == nil {
+		t.Error("negative offset accepted")
+	}
+}
+
+func TestReadAhead(t *testing.T) {
+	m := newMemMedium(64, 512)
+	b, _ := NewBlockDevice(m, Options{ReadAhead: 8})
+	buf := make([]byte, 1024)
+	for off := int64(0); off < 8*1024; off += 1024 {
+		if _, err := b.ReadAt(buf, off); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := m.Ops(); got != "read(0,2) read(2,10) read(12,10)" {
+		t.Errorf("ops %q", got)
+	}
+	// A random read is not extended.
+	b.ReadAt(buf, 40*512)
+	if got := m.Ops(); got != "read(40,2)" {
+		t.Errorf("ops %q", got)
+	}
+}
+
+func TestWriteCoalescing(t *testing.T) {
+	m := newMemMedium(64, 512)
+	b, _ := NewBlockDevice(m, Options{CacheBlocks: 8, MaxTransfer: 4})
+	want := bytes.Clone(m.data)
+
+	// Partial blocks are read first, whole blocks are not.
+	data := bytes.Repeat([]byte{0xee}, 3*512)
+	if n, err := b.WriteAt(data, 256); n != len(data) || err != nil {
+		t.Fatalf("WriteAt = %d, %v", n, err)
+	}
+	copy(want[256:], data)
+	if got := m.Ops(); got != "read(0,1) read(3,1)" {
+		t.Errorf("ops %q", got)
+	}
+	if b.WriteAt([]byte{1}, 10*512); m.Ops() != "read(10,1)" {
+		t.Error("partial block not read")
+	}
+	want[10*512] = 1
+	if err := b.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Ops(); got != "write(0,4) write(10,1) sync" {
+		t.Errorf("ops %q", got)
+	}
+	if !bytes.Equal(m.data, want) {
+		t.Error("medium does not hold the data written")
+	}
+	if b.Sync(); m.Ops() != "" {
+		t.Error("clean cache written again")
+	}
+
+	// Evicting a dirty block writes its run of dirty neighbours.
+	for lba := range 12 {
+		b.WriteAt(bytes.Repeat([]byte{byte(lba)}, 512), int64(20+lba)*512)
+	}
+	if got := m.Ops(); got != "write(20,4)" {
+		t.Errorf("ops %q", got)
+	}
+	b.Close()
+	if got := m.Ops(); got != "write(24,4) write(28,4) sync" {
+		t.Errorf("ops %q", got)
+	}
+}
+
+// Blocks written back by eviction are in the device's cache too.
+func TestSyncAfterEviction(t *testing.T) {
+	m := newMemMedium(8, 512)
+	b, _ := NewBlockDevice(m, Options{CacheBlocks: 2, ReadAhead: -1})
+	b.WriteAt(bytes.Repeat([]byte{0xaa}, 512), 0)
+	b.ReadAt(make([]byte, 1024), 4*512)
+	if got := m.Ops(); got != "read(4,2) write(0,1)" {
+		t.Errorf("ops %q", got)
+	}
+	if err := b.Sync(); err != nil || m.Ops() != "sync" {
+		t.Error("evicted blocks not synchronized")
+	}
+	if b.Sync(); m.Ops() != "" {
+		t.Error("synchronized twice")
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	m := newMemMedium(4, 512)
+	b, _ := NewBlockDevice(m, Options{})
+	n, err := b.WriteAt(make([]byte, 1024), b.Size()-512)
+	if n != 512 || !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("write past the end = %d, %v", n, err)
+	}
+
+	m.fail = errors.New("medium error")
+	if err := b.Sync(); err != m.fail {
+		t.Errorf("Sync: %v", err)
+	}
+	m.fail = nil
+	m.Ops()
+	if err := b.Sync(); err != nil || m.Ops() != "write(3,1) sync" {
+		t.Error("failed block not kept dirty")
+	}
+}
+
+func TestEvictionError(t *testing.T) {
+	m := newMemMedium(8, 512)
+	b, _ := NewBlockDevice(m, Options{CacheBlocks: 2, ReadAhead: -1})
+	if _, err := b.WriteAt(bytes.Repeat([]byte{0xaa}, 1024), 0); err != nil {
+		t.Fatal(err)
+	}
+	// A full block written over an uncached one evicts block 0, whose
+	// write-back fails. Block 5 must not stay cached with zeros.
+	m.fail = errors.New("medium error")
+	if _, err := b.WriteAt(bytes.Repeat([]byte{0xbb}, 512), 5*512); err != m.fail {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	m.fail = nil
+	got := make([]byte, 512)
+	if _, err := b.ReadAt(got, 5*512); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, bytes.Repeat([]byte{5}, 512)) {
+		t.Errorf("block 5 reads %x...", got[:4])
+	}
+	if err := b.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.data[:1024], bytes.Repeat([]byte{0xaa}, 1024)) {
+		t.Error("dirty blocks lost")
+	}
+}
+
+func TestSeek(t *testing.T) {
+	m := newMemMedium(4, 512)
+	b, _ := NewBlockDevice(m, Options{})
+	if off, _ := b.Seek(-10, io.SeekEnd); off != 2038 {
+		t.Errorf("offset %d", off)
+	}
+	buf := make([]byte, 20)
+	if n, err := b.Read(buf); n != 10 || err != nil {
+		t.Errorf("Read = %d, %v", n, err)
+	}
+	if n, err := b.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read at the end = %d, %v", n, err)
+	}
+	b.Seek(100, io.SeekStart)
+	b.Write([]byte("hello"))
+	if off, _ := b.Seek(0, io.SeekCurrent); off != 105 {
+		t.Errorf("offset %d after write", off)
+	}
+	if _, err := b.Seek(-1, io.SeekStart); err == nil {
+		t.Error("negative offset accepted")
+	}
+	b.Sync()
+	if string(m.data[100:105]) != "hello" {
+		t.Error("write lost")
+	}
+}
+
+func TestBlockDeviceOverSCSI(t *testing.T) {
+	lun := msctest.NewLUN(64, 4096)
+	d := usb.NewDevice(msctest.NewMulti(lun))
+	d.Open()
+	intf, _ := bot.Find(d)
+	tr, err := bot.New(d, intf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBlockDevice(scsi.New(scsi.BulkOnly(tr), 0), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.BlockSize() != 4096 || b.Size() != 64*4096 {
+		t.Fatalf("block size %d, size %d", b.BlockSize(), b.Size())
+	}
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	if _, err := b.WriteAt(data, 4000); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(lun.Data[4000:14000], data) {
+		t.Error("medium does not hold the data written")
+	}
+	buf := make([]byte, len(data))
+	if _, err := b.ReadAt(buf, 4000); err != nil || !bytes.Equal(buf, data) {
+		t.Errorf("read back: %v", err)
+	}
+}
+
+func TestSynchronizeCacheUnsupported(t *testing.T) {
+	lun := msctest.NewLUN(64, 512)
+	lun.SynchronizeCacheUnsupported = true
+	syncs := 0
+	lun.Fault = func(cb []byte) msctest.Sense {
+		if cb[0] == scsi.OpSynchronizeCache10 {
+			syncs++
+		}
+		return msctest.Sense{}
+	}
+	b, err := NewBlockDevice(scsi.New(scsi.BulkOnly(msctest.NewTransport(t, lun)), 0), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		if _, err := b.WriteAt([]byte{byte(i + 1)}, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Sync(); err != nil {
+			t.Fatalf("Sync %d: %v", i+1, err)
+		}
+	}
+	if syncs != 1 || lun.Data[0] != 2 {
+		t.Errorf("%d SYNCHRONIZE CACHE, data %d", syncs, lun.Data[0])
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	m := newMemMedium(4, 512)
+	b, _ := NewBlockDevice(m, Options{})
+	b.WriteAt([]byte("lost"), 0)
+	b.Invalidate()
+	if _, err := b.ReadAt(make([]byte, 10), 0); !errors.Is(err, ErrMediumChanged) {
+		t.Errorf("ReadAt: %v", err)
+	}
+	if _, err := b.WriteAt([]byte("x"), 0); !errors.Is(err, ErrMediumChanged) {
+		t.Errorf("WriteAt: %v", err)
+	}
+	// The dirty block was dropped without being written.
+	if err := b.Sync(); !errors.Is(err, ErrMediumChanged) || b.lru.Len() != 0 {
+		t.Errorf("Sync: %v", err)
+	}
+	if ops := m.Ops(); ops != "read(0,1)" {
+		t.Errorf("ops %q", ops)
+	}
+}
+
+func TestMediumChanged(t *testing.T) {
+	lun := msctest.NewLUN(64, 512)
+	d := usb.NewDevice(msctest.NewMulti(lun))
+	d.Open()
+	intf, _ := bot.Find(d)
+	tr, err := bot.New(d, intf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBlockDevice(scsi.New(scsi.BulkOnly(tr), 0), Options{ReadAhead: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.WriteAt([]byte("for the old card"), 0)
+
+	// The card is swapped: the next command reports it, and the block
+	// device gives up on the old medium.
+	lun.Insert(64)
+	if _, err := b.ReadAt(make([]byte, 512), 4096); !errors.Is(err, ErrMediumChanged) || !errors.Is(err, scsi.ErrMediumChanged) {
+		t.Errorf("read after the swap: %v", err)
+	}
+	if err := b.Sync(); !errors.Is(err, ErrMediumChanged) {
+		t.Errorf("Sync: %v", err)
+	}
+	if !bytes.Equal(lun.Data[:16], make([]byte, 16)) {
+		t.Error("old data written to the new medium")
+	}
+}
@@ -0,0 +1,274 @@
+// Package bot implements the USB Mass Storage Class Bulk-Only Transport
+// (BOT) on top of a usb.Device.
+//
+// A command is sent in a Command Block Wrapper on the bulk OUT pipe, is
+// followed by an optional data stage in either direction, and completes
+// with a Command Status Wrapper on the bulk IN pipe. Transport handles the
+// wrappers, tags and error recovery of section 5.3 of the specification;
+// the command blocks themselves, typically SCSI, are opaque to it.
+package bot
+
+import (
+	"errors"
+	"fmt"
+
+	"example.com/usb"
+)
+
+// Interface class and protocol of a Bulk-Only mass storage interface.
+const (
+	InterfaceClass   = 0x08
+	ProtocolBulkOnly = 0x50
+)
+
+// Class-specific requests.
+const (
+	RequestGetMaxLUN = 0xfe
+	RequestReset     = 0xff // Bulk-Only Mass Storage Reset
+)
+
+// Find returns the first Bulk-Only mass storage interface of the active
+// configuration.
+func Find(d *usb.Device) (*usb.Interface, bool) {
+	config, ok := d.ActiveConfiguration()
+	if !ok {
+		return nil, false
+	}
+	for i := range config.Interfaces {
+		desc := config.Interfaces[i].Descriptor
+		if desc.InterfaceClass == InterfaceClass && desc.InterfaceProtocol == ProtocolBulkOnly {
+			return &config.Interfaces[i], true
+		}
+	}
+	return nil, false
+}
+
+// Transport runs commands on a Bulk-Only mass storage interface.
+type Transport struct {
+	dev     *usb.Device
+	number  uint8
+	in, out uint8
+	tag     uint32
+	maxLUN  uint8
+
+	ignoreResidue bool
+}
+
+// New claims intf if needed, finds its bulk pipes and asks the device for
+// its number of LUNs. The device must be open and configured. New registers
+// ResetRecovery as the device's class reset, so retry policies can use
+// usb.RecoverClassReset, and sets the policy of the bulk pipes to fail
+// right away: a stall of the data stage ends the stage, after which the CSW
+// must be read, and other errors call for the recovery of section 5.3,
+// which Command runs itself.
+//
+// The quirks usb.QuirkSingleLUN and usb.QuirkIgnoreResidue are honoured.
+func New(d *usb.Device, intf *usb.Interface) (*Transport, error) {
+	desc := intf.Descriptor
+	t := &Transport{
+		dev:           d,
+		number:        desc.InterfaceNumber,
+		ignoreResidue: d.Quirks().Has(usb.QuirkIgnoreResidue),
+	}
+	for _, ep := range intf.Endpoints {
+		if ep.TransferType != usb.TransferBulk {
+			continue
+		}
+		if ep.Direction == usb.DirectionIn && t.in == 0 {
+			t.in = ep.Address()
+		} else if ep.Direction == usb.DirectionOut && t.out == 0 {
+			t.out = ep.Address()
+		}
+	}
+	if t.in == 0 || t.out == 0 {
+		return nil, fmt.Errorf("bot: interface %d has no bulk IN and OUT endpoints", t.number)
+	}
+	if alt, ok := d.Claimed(t.number); !ok || alt != desc.AlternateSetting {
+		if err := d.ClaimInterface(t.number, desc.AlternateSetting); err != nil {
+			return nil, err
+		}
+	}
+	if !d.Quirks().Has(usb.QuirkSingleLUN) {
+		maxLUN, err := t.getMaxLUN()
+		if err != nil {
+			return nil, err
+		}
+		t.maxLUN = maxLUN
+	}
+	d.SetClassReset(t.ResetRecovery)
+	d.SetRetryPolicy(t.in, failPolicy)
+	d.SetRetryPolicy(t.out, failPolicy)
+	return t, nil
+}
+
+// failPolicy is the retry policy of the bulk pipes.
+var failPolicy = usb.RetryPolicyFunc(func(int, error) usb.Recovery {
+	return usb.Recovery{Action: usb.RecoverFail}
+})
+
+// getMaxLUN sends GET MAX LUN. Devices with a single LUN may stall it.
+func (t *Transport) getMaxLUN() (uint8, error) {
+	data, err := t.dev.ReadControl(t.classRequest(RequestGetMaxLUN), 1)
+	if errors.Is(err, usb.ErrStall) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("bot: GET MAX LUN: %w", err)
+	}
+	if len(data) != 1 || data[0] > 15 {
+		return 0, fmt.Errorf("bot: GET MAX LUN returned %x", data)
+	}
+	return data[0], nil
+}
+
+func (t *Transport) classRequest(request uint8) usb.ControlSetup {
+	return usb.ControlSetup{
+		Type:      usb.ControlClass,
+		Recipient: usb.RecipientInterface,
+		Request:   request,
+		Index:     uint16(t.number),
+	}
+}
+
+// Device returns the device the transport runs on.
+func (t *Transport) Device() *usb.Device {
+	return t.dev
+}
+
+// MaxLUN returns the highest LUN of the device.
+func (t *Transport) MaxLUN() uint8 {
+	return t.maxLUN
+}
+
+// Endpoints returns the addresses of the bulk IN and OUT pipes.
+func (t *Transport) Endpoints() (in, out uint8) {
+	return t.in, t.out
+}
+
+// Result is the outcome of a command that completed with a valid CSW.
+type Result struct {
+	Status Status
+	// Residue is the difference between the data expected and the data
+	// processed, as reported by the device or, for devices with
+	// usb.QuirkIgnoreResidue, as counted by the host.
+	Residue uint32
+	// Transferred is the number of bytes moved in the data stage.
+	Transferred int
+}
+
+// Command runs command block cb on lun. For usb.DirectionIn the data stage
+// reads into data, for usb.DirectionOut it writes data; an empty data has no
+// data stage.
+//
+// A failed command is not an error: it is reported with StatusFailed, and
+// the cause is left for the command set to find out. Errors are reserved
+// for transfers that fail and CSWs that are invalid or report a phase
+// error; the device is reset with ResetRecovery before they are returned.
+func (t *Transport) Command(lun uint8, cb []byte, dir usb.Direction, data []byte) (Result, error) {
+	if lun > t.maxLUN {
+		return Result{}, fmt.Errorf("%w: %d, max %d", ErrInvalidLUN, lun, t.maxLUN)
+	}
+	t.tag++
+	cbw := CBW{Tag: t.tag, DataTransferLength: uint32(len(data)), Direction: dir, LUN: lun, CB: cb}
+	b, err := cbw.MarshalBinary()
+	if err != nil {
+		return Result{}, err
+	}
+	if _, err := t.dev.WriteBulk(t.out, b); err != nil {
+		return Result{}, t.fail(fmt.Errorf("bot: sending CBW: %w", err))
+	}
+
+	var r Result
+	if len(data) > 0 {
+		if r.Transferred, err = t.transfer(dir, data); err != nil {
+			// The device stalls the data pipe when it has processed less
+			// data than expected; the CSW tells how much.
+			if !errors.Is(err, usb.ErrStall) {
+				return r, t.fail(fmt.Errorf("bot: data stage: %w", err))
+			}
+			ep := t.out
+			if dir == usb.DirectionIn {
+				ep = t.in
+			}
+			if err := t.dev.ClearHalt(ep); err != nil {
+				return r, t.fail(err)
+			}
+		}
+	}
+
+	csw, err := t.readCSW()
+	if err != nil {
+		return r, t.fail(err)
+	}
+	switch {
+	case csw.Tag != cbw.Tag:
+		return r, t.fail(fmt.Errorf("%w: tag %#x, want %#x", ErrInvalidCSW, csw.Tag, cbw.Tag))
+	case csw.Status == StatusPhaseError:
+		return r, t.fail(ErrPhase)
+	case csw.Status > StatusPhaseError:
+		return r, t.fail(fmt.Errorf("%w: status %d", ErrInvalidCSW, csw.Status))
+	}
+	r.Status = csw.Status
+	r.Residue = uint32(len(data) - r.Transferred)
+	if !t.ignoreResidue {
+		if csw.DataResidue > cbw.DataTransferLength {
+			return r, t.fail(fmt.Errorf("%w: residue %d of %d bytes", ErrInvalidCSW, csw.DataResidue, cbw.DataTransferLength))
+		}
+		r.Residue = csw.DataResidue
+	}
+	return r, nil
+}
+
+func (t *Transport) transfer(dir usb.Direction, data []byte) (int, error) {
+	if dir == usb.DirectionOut {
+		return t.dev.WriteBulk(t.out, data)
+	}
+	read, err := t.dev.ReadBulk(t.in, len(data))
+	return copy(data, read), err
+}
+
+// readCSW reads the CSW. If the IN pipe stalls, it is cleared and the read
+// retried once, as in figure 2 of the specification.
+func (t *Transport) readCSW() (CSW, error) {
+	var csw CSW
+	b, err := t.dev.ReadBulk(t.in, CSWLength)
+	if errors.Is(err, usb.ErrStall) {
+		if err := t.dev.ClearHalt(t.in); err != nil {
+			return csw, err
+		}
+		b, err = t.dev.ReadBulk(t.in, CSWLength)
+	}
+	if err != nil {
+		return csw, fmt.Errorf("bot: reading CSW: %w", err)
+	}
+	return csw, csw.UnmarshalBinary(b)
+}
+
+// fail performs a reset recovery after err and returns err, together with
+// the error of the recovery if it failed too.
+func (t *Transport) fail(err error) error {
+	if rerr := t.ResetRecovery(); rerr != nil {
+		return errors.Join(err, fmt.Errorf("bot: reset recovery: %w", rerr))
+	}
+	return err
+}
+
+// Reset sends a Bulk-Only Mass Storage Reset. The device is ready for the
+// next CBW afterwards, but its pipes stay halted until they are cleared;
+// ResetRecovery does both.
+func (t *Transport) Reset() error {
+	_, err := t.dev.WriteControl(t.classRequest(RequestReset), nil)
+	return err
+}
+
+// ResetRecovery performs the reset recovery of section 5.3.4: a Bulk-Only
+// Mass Storage Reset followed by clearing the halt on both bulk pipes.
+func (t *Transport) ResetRecovery() error {
+	if err := t.Reset(); err != nil {
+		return err
+	}
+	if err := t.dev.ClearHalt(t.in); err != nil {
+		return err
+	}
+	return t.dev.ClearHalt(t.out)
+}
@@ -0,0 +1,271 @@
+package bot_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"example.com/usb"
+	"example.com/usb/msc/bot"
+	"example.com/usb/msc/msctest"
+)
+
+func newTransport(t *testing.T, disk *msctest.Disk, quirks usb.Quirk) (*bot.Transport, *usb.Device) {
+	t.Helper()
+	d := usb.NewDevice(disk)
+	d.SetQuirks(usb.QuirkEntry{Quirks: quirks})
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	intf, ok := bot.Find(d)
+	if !ok {
+		t.Fatal("no Bulk-Only interface")
+	}
+	tr, err := bot.New(d, intf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr, d
+}
+
+func rw10(op uint8, lba uint32, blocks uint16) []byte {
+	cb := make([]byte, 10)
+	cb[0] = op
+	binary.BigEndian.PutUint32(cb[2:], lba)
+	binary.BigEndian.PutUint16(cb[7:], blocks)
+	return cb
+}
+
+func TestReadWrite(t *testing.T) {
+	disk := msctest.New(16, 512)
+	tr, _ := newTransport(t, disk, 0)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64)
+	r, err := tr.Command(0, rw10(0x2a, 3, 2), usb.DirectionOut, data)
+	if err != nil || r != (bot.Result{Status: bot.StatusPassed, Transferred: 1024}) {
+		t.Fatalf("write: %+v, %v", r, err)
+	}
+	if !bytes.Equal(disk.LUNs[0].Data[3*512:5*512], data) {
+		t.Fatal("medium not written")
+	}
+	buf := make([]byte, 1024)
+	r, err = tr.Command(0, rw10(0x28, 3, 2), usb.DirectionIn, buf)
+	if err != nil || r.Status != bot.StatusPassed || r.Transferred != 1024 {
+		t.Fatalf("read: %+v, %v", r, err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Fatal("read back different data")
+	}
+	if _, err := tr.Command(0, []byte{0x00, 0, 0, 0, 0, 0}, usb.DirectionOut, nil); err != nil {
+		t.Fatalf("command without data stage: %v", err)
+	}
+}
+
+func TestTags(t *testing.T) {
+	var tags []uint32
+	disk := msctest.New(16, 512)
+	d := usb.NewDevice(disk, usb.Around(func(c *usb.Call, invoke func() error) error {
+		var cbw bot.CBW
+		if c.Op == usb.OpWriteBulk && cbw.UnmarshalBinary(c.Data) == nil {
+			tags = append(tags, cbw.Tag)
+		}
+		return invoke()
+	}))
+	d.Open()
+	intf, _ := bot.Find(d)
+	tr, err := bot.New(d, intf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		tr.Command(0, []byte{0x00, 0, 0, 0, 0, 0}, usb.DirectionOut, nil)
+	}
+	if len(tags) != 3 || tags[0] == tags[1] || tags[1] == tags[2] {
+		t.Errorf("tags %v", tags)
+	}
+}
+
+func TestMaxLUN(t *testing.T) {
+	disk := msctest.NewMulti(msctest.NewLUN(8, 512), msctest.NewLUN(8, 512), msctest.NewLUN(8, 512))
+	tr, _ := newTransport(t, disk, 0)
+	if tr.MaxLUN() != 2 {
+		t.Errorf("max LUN %d", tr.MaxLUN())
+	}
+	if _, err := tr.Command(3, []byte{0x00, 0, 0, 0, 0, 0}, usb.DirectionOut, nil); !errors.Is(err, bot.ErrInvalidLUN) {
+		t.Errorf("LUN 3: %v", err)
+	}
+
+	single, _ := newTransport(t, msctest.NewMulti(msctest.NewLUN(8, 512), msctest.NewLUN(8, 512)), usb.QuirkSingleLUN)
+	if single.MaxLUN() != 0 {
+		t.Errorf("max LUN with single-lun quirk %d", single.MaxLUN())
+	}
+
+	stalling := msctest.New(8, 512)
+	stalling.StallGetMaxLUN = true
+	if tr, _ := newTransport(t, stalling, 0); tr.MaxLUN() != 0 {
+		t.Errorf("max LUN after stall %d", tr.MaxLUN())
+	}
+}
+
+func TestFailedCommand(t *testing.T) {
+	disk := msctest.New(8, 512)
+	tr, _ := newTransport(t, disk, 0)
+
+	// The device stalls the data stage of an unsupported command, which
+	// must be cleared before the CSW can be read.
+	r, err := tr.Command(0, []byte{0xff, 0, 0, 0, 0, 0}, usb.DirectionIn, make([]byte, 8))
+	if err != nil || r.Status != bot.StatusFailed || r.Residue != 8 || r.Transferred != 0 {
+		t.Fatalf("result %+v, %v", r, err)
+	}
+	sense := make([]byte, 18)
+	if r, err := tr.Command(0, []byte{0x03, 0, 0, 0, 18, 0}, usb.DirectionIn, sense); err != nil || r.Status != bot.StatusPassed {
+		t.Fatalf("REQUEST SENSE: %+v, %v", r, err)
+	}
+	if sense[2] != 0x05 || sense[12] != 0x20 {
+		t.Errorf("sense % x", sense)
+	}
+	if disk.Resets != 0 {
+		t.Errorf("%d resets for a failed command", disk.Resets)
+	}
+}
+
+func TestDefaultRetryPolicy(t *testing.T) {
+	disk := msctest.New(8, 512)
+	d := usb.NewDevice(disk)
+	d.SetDefaultRetryPolicy(usb.DefaultRetryPolicy)
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	intf, _ := bot.Find(d)
+	tr, err := bot.New(d, intf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The stalled data stage is not retried, which would read the CSW as
+	// data, but ends with the CSW of the failed command.
+	r, err := tr.Command(0, []byte{0xff, 0, 0, 0, 0, 0}, usb.DirectionIn, make([]byte, 8))
+	if err != nil || r.Status != bot.StatusFailed || r.Residue != 8 {
+		t.Fatalf("result %+v, %v", r, err)
+	}
+	if r, err := tr.Command(0, []byte{0x00, 0, 0, 0, 0, 0}, usb.DirectionOut, nil); err != nil || r.Status != bot.StatusPassed {
+		t.Fatalf("next command: %+v, %v", r, err)
+	}
+	if disk.Resets != 0 {
+		t.Errorf("%d resets", disk.Resets)
+	}
+}
+
+func TestShortData(t *testing.T) {
+	tr, _ := newTransport(t, msctest.New(8, 512), 0)
+	buf := make([]byte, 96)
+	r, err := tr.Command(0, []byte{0x12, 0, 0, 0, 96, 0}, usb.DirectionIn, buf)
+	if err != nil || r.Transferred != 36 || r.Residue != 60 {
+		t.Fatalf("INQUIRY: %+v, %v", r, err)
+	}
+}
+
+func TestInvalidCSW(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*bot.CSW)
+		want   error
+	}{
+		{"wrong tag", func(c *bot.CSW) { c.Tag++ }, bot.ErrInvalidCSW},
+		{"reserved status", func(c *bot.CSW) { c.Status = 3 }, bot.ErrInvalidCSW},
+		{"residue too large", func(c *bot.CSW) { c.DataResidue = 4096 }, bot.ErrInvalidCSW},
+		{"phase error", func(c *bot.CSW) { c.Status = bot.StatusPhaseError }, bot.ErrPhase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disk := msctest.New(8, 512)
+			tr, _ := newTransport(t, disk, 0)
+			disk.CSW = tt.mutate
+			if _, err := tr.Command(0, rw10(0x28, 0, 1), usb.DirectionIn, make([]byte, 512)); !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v", err)
+			}
+			if disk.Resets != 1 {
+				t.Errorf("%d resets", disk.Resets)
+			}
+			if _, err := tr.Command(0, rw10(0x28, 0, 1), usb.DirectionIn, make([]byte, 512)); err != nil {
+				t.Errorf("command after reset recovery: %v", err)
+			}
+		})
+	}
+}
+
+func TestPhaseError(t *testing.T) {
+	disk := msctest.New(8, 512)
+	tr, _ := newTransport(t, disk, 0)
+	// Two blocks do not fit in the 512 bytes the host expects.
+	if _, err := tr.Command(0, rw10(0x28, 0, 2), usb.DirectionIn, make([]byte, 512)); !errors.Is(err, bot.ErrPhase) {
+		t.Fatalf("err = %v", err)
+	}
+	if disk.Resets != 1 {
+		t.Errorf("%d resets", disk.Resets)
+	}
+}
+
+func TestStalledCSW(t *testing.T) {
+	disk := msctest.New(8, 512)
+	tr, _ := newTransport(t, disk, 0)
+	disk.StallCSW = 1
+	if _, err := tr.Command(0, []byte{0x00, 0, 0, 0, 0, 0}, usb.DirectionOut, nil); err != nil {
+		t.Fatalf("CSW after one stall: %v", err)
+	}
+
+	disk.StallCSW = 2
+	if _, err := tr.Command(0, []byte{0x00, 0, 0, 0, 0, 0}, usb.DirectionOut, nil); !errors.Is(err, usb.ErrStall) {
+		t.Fatalf("CSW after two stalls: %v", err)
+	}
+	if disk.Resets != 1 {
+		t.Errorf("%d resets", disk.Resets)
+	}
+}
+
+func TestIgnoreResidue(t *testing.T) {
+	disk := msctest.New(8, 512)
+	tr, _ := newTransport(t, disk, usb.QuirkIgnoreResidue)
+	disk.CSW = func(c *bot.CSW) { c.DataResidue = 4096 }
+	r, err := tr.Command(0, rw10(0x28, 0, 1), usb.DirectionIn, make([]byte, 512))
+	if err != nil || r.Residue != 0 {
+		t.Fatalf("result %+v, %v", r, err)
+	}
+}
+
+func TestResetRecoveryAfterInvalidCBW(t *testing.T) {
+	disk := msctest.New(8, 512)
+	tr, d := newTransport(t, disk, 0)
+	if _, err := d.WriteBulk(msctest.EndpointOut, []byte("not a CBW")); !errors.Is(err, usb.ErrStall) {
+		t.Fatalf("invalid CBW: %v", err)
+	}
+	// Clearing the halts alone does not help after an invalid CBW.
+	d.ClearHalt(msctest.EndpointOut)
+	if _, err := d.WriteBulk(msctest.EndpointOut, []byte("still no CBW")); !errors.Is(err, usb.ErrStall) {
+		t.Fatalf("after clear-halt: %v", err)
+	}
+	if err := tr.ResetRecovery(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.Command(0, []byte{0x00, 0, 0, 0, 0, 0}, usb.DirectionOut, nil); err != nil {
+		t.Fatalf("after reset recovery: %v", err)
+	}
+}
+
+func TestClassResetPolicy(t *testing.T) {
+	disk := msctest.New(8, 512)
+	tr, d := newTransport(t, disk, 0)
+	in, _ := tr.Endpoints()
+	d.SetRetryPolicy(in, usb.RetryPolicyFunc(func(attempt int, err error) usb.Recovery {
+		if attempt > 1 {
+			return usb.Recovery{Action: usb.RecoverFail}
+		}
+		return usb.Recovery{Action: usb.RecoverClassReset}
+	}))
+	// Reading where no data is expected stalls the pipe; the policy
+	// recovers with a reset recovery registered by New.
+	d.ReadBulk(in, 13)
+	if disk.Resets != 1 {
+		t.Errorf("%d resets", disk.Resets)
+	}
+}
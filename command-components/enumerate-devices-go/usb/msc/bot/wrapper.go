@@ -0,0 +1,135 @@
+package bot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"example.com/usb"
+)
+
+// Sizes and signatures of the Bulk-Only Transport wrappers.
+const (
+	CBWLength    = 31
+	CSWLength    = 13
+	CBWSignature = 0x43425355 // "USBC"
+	CSWSignature = 0x53425355 // "USBS"
+)
+
+// CBW is a Command Block Wrapper, sent on the bulk OUT pipe to start a
+// command.
+type CBW struct {
+	Tag                uint32
+	DataTransferLength uint32
+	Direction          usb.Direction // of the data stage; ignored without one
+	LUN                uint8
+	CB                 []byte // the command block, 1 to 16 bytes
+}
+
+// MarshalBinary encodes the CBW in its 31-byte wire format.
+func (c CBW) MarshalBinary() ([]byte, error) {
+	if c.LUN > 15 {
+		return nil, fmt.Errorf("bot: LUN %d out of range", c.LUN)
+	}
+	if len(c.CB) == 0 || len(c.CB) > 16 {
+		return nil, fmt.Errorf("bot: command block of %d bytes", len(c.CB))
+	}
+	b := make([]byte, CBWLength)
+	binary.LittleEndian.PutUint32(b[0:], CBWSignature)
+	binary.LittleEndian.PutUint32(b[4:], c.Tag)
+	binary.LittleEndian.PutUint32(b[8:], c.DataTransferLength)
+	if c.Direction == usb.DirectionIn {
+		b[12] = 0x80
+	}
+	b[13] = c.LUN
+	b[14] = uint8(len(c.CB))
+	copy(b[15:], c.CB)
+	return b, nil
+}
+
+// UnmarshalBinary decodes a CBW and checks that it is valid and meaningful
+// as defined in section 6.2 of the specification.
+func (c *CBW) UnmarshalBinary(b []byte) error {
+	if len(b) != CBWLength {
+		return fmt.Errorf("%w: %d bytes", ErrInvalidCBW, len(b))
+	}
+	if sig := binary.LittleEndian.Uint32(b); sig != CBWSignature {
+		return fmt.Errorf("%w: signature %#08x", ErrInvalidCBW, sig)
+	}
+	if b[12]&0x7f != 0 || b[13] > 15 || b[14] == 0 || b[14] > 16 {
+		return fmt.Errorf("%w: reserved bits set or bad length", ErrInvalidCBW)
+	}
+	c.Tag = binary.LittleEndian.Uint32(b[4:])
+	c.DataTransferLength = binary.LittleEndian.Uint32(b[8:])
+	c.Direction = usb.DirectionOut
+	if b[12]&0x80 != 0 {
+		c.Direction = usb.DirectionIn
+	}
+	c.LUN = b[13]
+	c.CB = append([]byte(nil), b[15:15+b[14]]...)
+	return nil
+}
+
+// Status is the bCSWStatus of a CSW.
+type Status uint8
+
+const (
+	StatusPassed     Status = 0
+	StatusFailed     Status = 1
+	StatusPhaseError Status = 2
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusPassed:
+		return "passed"
+	case StatusFailed:
+		return "failed"
+	case StatusPhaseError:
+		return "phase error"
+	}
+	return fmt.Sprintf("Status(%d)", uint8(s))
+}
+
+// CSW is a Command Status Wrapper, received on the bulk IN pipe at the end
+// of a command.
+type CSW struct {
+	Tag         uint32
+	DataResidue uint32
+	Status      Status
+}
+
+// MarshalBinary encodes the CSW in its 13-byte wire format.
+func (c CSW) MarshalBinary() ([]byte, error) {
+	b := make([]byte, CSWLength)
+	binary.LittleEndian.PutUint32(b[0:], CSWSignature)
+	binary.LittleEndian.PutUint32(b[4:], c.Tag)
+	binary.LittleEndian.PutUint32(b[8:], c.DataResidue)
+	b[12] = uint8(c.Status)
+	return b, nil
+}
+
+// UnmarshalBinary decodes a CSW and checks its length and signature. The
+// tag, status and residue are checked by Transport, which knows the CBW.
+func (c *CSW) UnmarshalBinary(b []byte) error {
+	if len(b) != CSWLength {
+		return fmt.Errorf("%w: %d bytes", ErrInvalidCSW, len(b))
+	}
+	if sig := binary.LittleEndian.Uint32(b); sig != CSWSignature {
+		return fmt.Errorf("%w: signature %#08x", ErrInvalidCSW, sig)
+	}
+	c.Tag = binary.LittleEndian.Uint32(b[4:])
+	c.DataResidue = binary.LittleEndian.Uint32(b[8:])
+	c.Status = Status(b[12])
+	return nil
+}
+
+// Errors reported by the wrappers and by Transport. Transport performs a
+// reset recovery after ErrInvalidCSW and ErrPhase, so the device is ready
+// for the next command.
+var (
+	ErrInvalidCBW = errors.New("bot: invalid CBW")
+	ErrInvalidCSW = errors.New("bot: invalid CSW")
+	ErrPhase      = errors.New("bot: phase error")
+	ErrInvalidLUN = errors.New("bot: no such LUN")
+)
@@ -0,0 +1,63 @@
+package bot
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"example.com/usb"
+)
+
+func TestCBW(t *testing.T) {
+	cbw := CBW{Tag: 0x01020304, DataTransferLength: 512, Direction: usb.DirectionIn, LUN: 1, CB: []byte{0x28, 0, 0, 0, 0, 7, 0, 0, 1, 0}}
+	b, err := cbw.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		'U', 'S', 'B', 'C', 0x04, 0x03, 0x02, 0x01, 0x00, 0x02, 0x00, 0x00, 0x80, 0x01, 10,
+		0x28, 0, 0, 0, 0, 7, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0,
+	}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("got  % x\nwant % x", b, want)
+	}
+	var got CBW
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if got.Tag != cbw.Tag || got.DataTransferLength != 512 || got.Direction != usb.DirectionIn || got.LUN != 1 || !bytes.Equal(got.CB, cbw.CB) {
+		t.Errorf("round trip: %+v", got)
+	}
+
+	for _, bad := range []CBW{{LUN: 16, CB: []byte{0}}, {CB: nil}, {CB: make([]byte, 17)}} {
+		if _, err := bad.MarshalBinary(); err == nil {
+			t.Errorf("%+v accepted", bad)
+		}
+	}
+	b[14] = 17
+	if err := got.UnmarshalBinary(b); !errors.Is(err, ErrInvalidCBW) {
+		t.Errorf("bad length: %v", err)
+	}
+	if err := got.UnmarshalBinary(b[:30]); !errors.Is(err, ErrInvalidCBW) {
+		t.Errorf("short CBW: %v", err)
+	}
+}
+
+func TestCSW(t *testing.T) {
+	b, _ := CSW{Tag: 7, DataResidue: 0x100, Status: StatusFailed}.MarshalBinary()
+	want := []byte{'U', 'S', 'B', 'S', 7, 0, 0, 0, 0x00, 0x01, 0, 0, 1}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("got  % x\nwant % x", b, want)
+	}
+	var csw CSW
+	if err := csw.UnmarshalBinary(b); err != nil || csw != (CSW{Tag: 7, DataResidue: 0x100, Status: StatusFailed}) {
+		t.Errorf("round trip: %+v, %v", csw, err)
+	}
+	b[0] = 'X'
+	if err := csw.UnmarshalBinary(b); !errors.Is(err, ErrInvalidCSW) {
+		t.Errorf("bad signature: %v", err)
+	}
+	if err := csw.UnmarshalBinary(want[:12]); !errors.Is(err, ErrInvalidCSW) {
+		t.Errorf("short CSW: %v", err)
+	}
+}
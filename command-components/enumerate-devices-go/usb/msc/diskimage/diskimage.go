@@ -0,0 +1,325 @@
+// Package diskimage copies whole mass storage media to image files and
+// back.
+//
+// Read and Write move the data in large transfers aligned to the transfer
+// size, straight over the msc.Medium, without a block cache. Both compute
+// the SHA-256 of the data copied and can verify the copy by reading it back.
+// An interrupted copy can be resumed from the last Checkpoint: it records
+// the first block not yet copied together with the state of the hash, so
+// the digest still covers the whole medium.
+package diskimage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"example.com/usb/msc"
+)
+
+var (
+	// ErrMismatch is returned when a checkpoint is not for the copy it is
+	// resumed with.
+	ErrMismatch = errors.New("diskimage: checkpoint does not match the copy")
+	// ErrVerify is returned when the copy read back differs.
+	ErrVerify = errors.New("diskimage: verification failed")
+)
+
+// File is an image file, such as an *os.File.
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+	Truncate(size int64) error
+	Sync() error
+}
+
+// Phase is the stage of a copy.
+type Phase int
+
+const (
+	Reading Phase = iota
+	Writing
+	Verifying
+)
+
+func (p Phase) String() string {
+	switch p {
+	case Reading:
+		return "reading"
+	case Writing:
+		return "writing"
+	case Verifying:
+		return "verifying"
+	}
+	return fmt.Sprintf("Phase(%d)", int(p))
+}
+
+// Progress is reported after every transfer.
+type Progress struct {
+	Phase   Phase
+	Done    int64 // bytes, including those copied before a resume
+	Total   int64
+	Start   int64 // Done when this run of the phase started
+	Elapsed time.Duration
+}
+
+// Rate returns the bytes per second of this run of the phase.
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Done-p.Start) / p.Elapsed.Seconds()
+}
+
+// Checkpoint is the state of an interrupted copy. All blocks before Next
+// have been copied and synced.
+type Checkpoint struct {
+	Phase     Phase // Reading or Writing
+	Blocks    uint64
+	BlockSize int
+	Next      uint64
+	Hash      []byte // the marshaled SHA-256 state of the blocks before Next
+}
+
+// Options tune a copy. The zero value is a plain copy.
+type Options struct {
+	// TransferSize is the number of bytes moved by one command, rounded
+	// down to whole blocks. Defaults to 1 MiB.
+	TransferSize int
+	// Sparse makes Read leave holes in the image for zero transfers
+	// instead of writing them.
+	Sparse bool
+	// Verify reads the copy back and compares its SHA-256.
+	Verify bool
+	// Progress, if set, is called after every transfer.
+	Progress func(Progress)
+	// Checkpoint, if set, is called with the state to resume from every
+	// CheckpointInterval bytes, after the copy has been synced. An error
+	// stops the copy.
+	Checkpoint func(*Checkpoint) error
+	// CheckpointInterval defaults to 64 MiB.
+	CheckpointInterval int64
+	// Resume continues the copy a checkpoint was taken of.
+	Resume *Checkpoint
+}
+
+// Result describes a finished copy.
+type Result struct {
+	Blocks    uint64
+	BlockSize int
+	SHA256    [sha256.Size]byte
+	Verified  bool
+}
+
+// copier moves blocks from a source to a destination in aligned transfers.
+type copier struct {
+	opts      Options
+	blockSize int
+	blocks    uint64
+	buf       []byte
+}
+
+func newCopier(blockSize int, blocks uint64, opts *Options) *copier {
+	c := &copier{blockSize: blockSize, blocks: blocks}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.TransferSize <= 0 {
+		c.opts.TransferSize = 1 << 20
+	}
+	if c.opts.CheckpointInterval <= 0 {
+		c.opts.CheckpointInterval = 64 << 20
+	}
+	c.buf = make([]byte, max(c.opts.TransferSize/blockSize, 1)*blockSize)
+	return c
+}
+
+// resume returns the block to start at and the hash to continue.
+func (c *copier) resume(phase Phase) (uint64, hash.Hash, error) {
+	h := sha256.New()
+	cp := c.opts.Resume
+	if cp == nil {
+		return 0, h, nil
+	}
+	if cp.Phase != phase || cp.BlockSize != c.blockSize || cp.Blocks != c.blocks || cp.Next > c.blocks {
+		return 0, nil, fmt.Errorf("%w: %s %d blocks of %d bytes up to block %d, not %s %d blocks of %d bytes",
+			ErrMismatch, cp.Phase, cp.Blocks, cp.BlockSize, cp.Next, phase, c.blocks, c.blockSize)
+	}
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(cp.Hash); err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrMismatch, err)
+	}
+	return cp.Next, h, nil
+}
+
+// run copies the blocks from lba on with step, which must fill buf from the
+// source at lba and write it to the destination. sync makes the copy
+// durable before a checkpoint.
+func (c *copier) run(phase Phase, lba uint64, h hash.Hash, step func(lba uint64, buf []byte) error, sync func() error) error {
+	bs := int64(c.blockSize)
+	chunk := uint64(len(c.buf) / c.blockSize)
+	p := Progress{Phase: phase, Done: int64(lba) * bs, Total: int64(c.blocks) * bs, Start: int64(lba) * bs}
+	start, synced := time.Now(), p.Done
+	for lba < c.blocks {
+		// The first transfer after a resume ends on a transfer boundary.
+		n := min(chunk-lba%chunk, c.blocks-lba)
+		buf := c.buf[:n*uint64(bs)]
+		if err := step(lba, buf); err != nil {
+			return err
+		}
+		h.Write(buf)
+		lba += n
+		p.Done, p.Elapsed = int64(lba)*bs, time.Since(start)
+		if c.opts.Progress != nil {
+			c.opts.Progress(p)
+		}
+		if c.opts.Checkpoint != nil && p.Done-synced >= c.opts.CheckpointInterval && lba < c.blocks {
+			if err := sync(); err != nil {
+				return err
+			}
+			state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+			if err != nil {
+				return err
+			}
+			cp := &Checkpoint{Phase: phase, Blocks: c.blocks, BlockSize: c.blockSize, Next: lba, Hash: state}
+			if err := c.opts.Checkpoint(cp); err != nil {
+				return err
+			}
+			synced = p.Done
+		}
+	}
+	return sync()
+}
+
+// verify hashes the copy with read and compares it with sum.
+func (c *copier) verify(sum []byte, read func(lba uint64, buf []byte) error) error {
+	h := sha256.New()
+	noSync := func() error { return nil }
+	if err := c.run(Verifying, 0, h, read, noSync); err != nil {
+		return err
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, sum) {
+		return fmt.Errorf("%w: SHA-256 of the copy is %x, not %x", ErrVerify, got, sum)
+	}
+	return nil
+}
+
+// Read copies the medium to f. Unless resuming, f is truncated first; it
+// ends up the size of the medium.
+func Read(m msc.Medium, f File, opts *Options) (*Result, error) {
+	capacity, err := m.ReadCapacity()
+	if err != nil {
+		return nil, err
+	}
+	c := newCopier(int(capacity.BlockSize), capacity.Blocks, opts)
+	lba, h, err := c.resume(Reading)
+	if err != nil {
+		return nil, err
+	}
+	bs := int64(c.blockSize)
+	// Anything past the checkpoint may be stale, and would survive in the
+	// holes of a sparse image.
+	if err := f.Truncate(int64(lba) * bs); err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(int64(c.blocks) * bs); err != nil {
+		return nil, err
+	}
+	step := func(lba uint64, buf []byte) error {
+		if err := m.Read(lba, buf); err != nil {
+			return err
+		}
+		if c.opts.Sparse && zero(buf) {
+			return nil
+		}
+		_, err := f.WriteAt(buf, int64(lba)*bs)
+		return err
+	}
+	if err := c.run(Reading, lba, h, step, f.Sync); err != nil {
+		return nil, err
+	}
+	r := c.result(h)
+	if c.opts.Verify {
+		err := c.verify(r.SHA256[:], func(lba uint64, buf []byte) error {
+			if n, err := f.ReadAt(buf, int64(lba)*bs); n < len(buf) {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		r.Verified = true
+	}
+	return r, nil
+}
+
+// Write copies the image of size bytes to the medium, from block 0 on.
+// The size must be a multiple of the block size and fit the medium.
+func Write(m msc.Medium, image io.ReaderAt, size int64, opts *Options) (*Result, error) {
+	capacity, err := m.ReadCapacity()
+	if err != nil {
+		return nil, err
+	}
+	bs := int64(capacity.BlockSize)
+	if size%bs != 0 {
+		return nil, fmt.Errorf("diskimage: image of %d bytes is not a multiple of the block size %d", size, bs)
+	}
+	if uint64(size/bs) > capacity.Blocks {
+		return nil, fmt.Errorf("diskimage: image of %d blocks does not fit the medium of %d", size/bs, capacity.Blocks)
+	}
+	c := newCopier(int(bs), uint64(size/bs), opts)
+	lba, h, err := c.resume(Writing)
+	if err != nil {
+		return nil, err
+	}
+	step := func(lba uint64, buf []byte) error {
+		if n, err := image.ReadAt(buf, int64(lba)*bs); n < len(buf) {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		return m.Write(lba, buf)
+	}
+	// Devices that reject SYNCHRONIZE CACHE are not asked again.
+	supported := true
+	sync := func() error {
+		if !supported {
+			return nil
+		}
+		var err error
+		supported, err = msc.SynchronizeCache(m)
+		return err
+	}
+	if err := c.run(Writing, lba, h, step, sync); err != nil {
+		return nil, err
+	}
+	r := c.result(h)
+	if c.opts.Verify {
+		if err := c.verify(r.SHA256[:], m.Read); err != nil {
+			return nil, err
+		}
+		r.Verified = true
+	}
+	return r, nil
+}
+
+func (c *copier) result(h hash.Hash) *Result {
+	r := &Result{Blocks: c.blocks, BlockSize: c.blockSize}
+	h.Sum(r.SHA256[:0])
+	return r
+}
+
+func zero(b []byte) bool {
+	for _, x := range b {
+		if x != 0 {
+			return false
+		}
+	}
+	return true
+}
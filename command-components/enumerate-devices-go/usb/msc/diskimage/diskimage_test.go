@@ -0,0 +1,258 @@
+package diskimage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"example.com/usb/msc"
+	"example.com/usb/msc/msctest"
+	"example.com/usb/msc/scsi"
+)
+
+// newLUN returns a LUN of 4096 blocks with data in the first and last
+// quarter and zeros in between.
+func newLUN() *msctest.LUN {
+	lun := msctest.NewLUN(4096, 512)
+	for i := range lun.Data {
+		if i < len(lun.Data)/4 || i >= len(lun.Data)*3/4 {
+			lun.Data[i] = byte(i*7 + i/512)
+		}
+	}
+	return lun
+}
+
+// transfer is a READ(10) or WRITE(10) seen by the emulated disk.
+type transfer struct {
+	op     uint8
+	lba    uint32
+	blocks uint16
+}
+
+// record makes lun record its transfers, and fail those at or past failAt
+// if it is not 0.
+func record(lun *msctest.LUN, failAt uint32) *[]transfer {
+	var seen []transfer
+	lun.Fault = func(cb []byte) msctest.Sense {
+		if cb[0] != scsi.OpRead10 && cb[0] != scsi.OpWrite10 {
+			return msctest.Sense{}
+		}
+		x := transfer{cb[0], binary.BigEndian.Uint32(cb[2:]), binary.BigEndian.Uint16(cb[7:])}
+		if failAt != 0 && x.lba+uint32(x.blocks) > failAt {
+			return msctest.SenseMediumError
+		}
+		seen = append(seen, x)
+		return msctest.Sense{}
+	}
+	return &seen
+}
+
+// imageFile counts the writes to an image file.
+type imageFile struct {
+	*os.File
+	writes int
+}
+
+func (f *imageFile) WriteAt(p []byte, off int64) (int, error) {
+	f.writes++
+	return f.File.WriteAt(p, off)
+}
+
+func newFile(t *testing.T) *imageFile {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "disk.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return &imageFile{File: f}
+}
+
+func TestReadWrite(t *testing.T) {
+	lun := newLUN()
+	seen := record(lun, 0)
+	f := newFile(t)
+	f.WriteAt(bytes.Repeat([]byte{0xff}, 3<<20), 0) // stale data
+	f.writes = 0
+
+	var last Progress
+	opts := &Options{TransferSize: 64 << 10, Sparse: true, Verify: true, Progress: func(p Progress) {
+		if p.Phase == Reading && p.Done <= last.Done || p.Total != 2<<20 {
+			t.Errorf("progress %+v after %+v", p, last)
+		}
+		last = p
+	}}
+	r, err := Read(scsi.New(scsi.BulkOnly(msctest.NewTransport(t, lun)), 0), f, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sha256.Sum256(lun.Data)
+	if r.SHA256 != want || !r.Verified || r.Blocks != 4096 || r.BlockSize != 512 {
+		t.Errorf("result %+v", r)
+	}
+	if last.Phase != Verifying || last.Done != last.Total {
+		t.Errorf("last progress %+v", last)
+	}
+	image, _ := os.ReadFile(f.Name())
+	if !bytes.Equal(image, lun.Data) {
+		t.Fatal("image differs from the medium")
+	}
+	// Half the medium is zeros, which were not written.
+	if f.writes != 16 {
+		t.Errorf("%d writes to the image", f.writes)
+	}
+	for _, x := range *seen {
+		if x.lba%128 != 0 || x.blocks != 128 {
+			t.Errorf("unaligned transfer %+v", x)
+		}
+	}
+
+	// Write the image to a larger disk and read it back.
+	target := msctest.NewLUN(5000, 512)
+	r, err = Write(scsi.New(scsi.BulkOnly(msctest.NewTransport(t, target)), 0), f, int64(len(image)), &Options{Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.SHA256 != want || !r.Verified || !bytes.Equal(target.Data[:len(image)], image) {
+		t.Errorf("write result %+v", r)
+	}
+}
+
+// A disk that rejects SYNCHRONIZE CACHE, as many sticks do, is written
+// all the same, and only asked once.
+func TestWriteWithoutSynchronizeCache(t *testing.T) {
+	target := msctest.NewLUN(64, 512)
+	target.SynchronizeCacheUnsupported = true
+	syncs := 0
+	target.Fault = func(cb []byte) msctest.Sense {
+		if cb[0] == scsi.OpSynchronizeCache10 {
+			syncs++
+		}
+		return msctest.Sense{}
+	}
+	image := bytes.Repeat([]byte{0x5a}, 64*512)
+	var checkpoints int
+	r, err := Write(scsi.New(scsi.BulkOnly(msctest.NewTransport(t, target)), 0), bytes.NewReader(image), int64(len(image)), &Options{
+		TransferSize:       4096,
+		CheckpointInterval: 8192,
+		Checkpoint:         func(*Checkpoint) error { checkpoints++; return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.SHA256 != sha256.Sum256(image) || !bytes.Equal(target.Data, image) {
+		t.Errorf("result %+v", r)
+	}
+	if checkpoints == 0 || syncs != 1 {
+		t.Errorf("%d checkpoints, %d SYNCHRONIZE CACHE", checkpoints, syncs)
+	}
+}
+
+func TestResume(t *testing.T) {
+	for _, phase := range []Phase{Reading, Writing} {
+		t.Run(phase.String(), func(t *testing.T) {
+			lun := newLUN()
+			f := newFile(t)
+			var cps []*Checkpoint
+			opts := &Options{TransferSize: 32 << 10, CheckpointInterval: 256 << 10, Checkpoint: func(cp *Checkpoint) error {
+				cps = append(cps, cp)
+				return nil
+			}}
+			run := func() (*Result, error) {
+				if phase == Reading {
+					return Read(scsi.New(scsi.BulkOnly(msctest.NewTransport(t, lun)), 0), f, opts)
+				}
+				return Write(scsi.New(scsi.BulkOnly(msctest.NewTransport(t, lun)), 0), bytes.NewReader(newLUN().Data), 4096*512, opts)
+			}
+			if phase == Writing {
+				clear(lun.Data)
+			}
+
+			// The copy fails in block 3000, after checkpoints at every
+			// 512 blocks.
+			record(lun, 3000)
+			if _, err := run(); err == nil {
+				t.Fatal("copy did not fail")
+			}
+			if len(cps) != 5 || cps[4].Next != 2560 || cps[4].Phase != phase {
+				t.Fatalf("checkpoints %+v", cps)
+			}
+
+			seen := record(lun, 0)
+			opts.Resume = cps[4]
+			r, err := run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (*seen)[0].lba != 2560 || len(*seen) != (4096-2560)/64 {
+				t.Errorf("resumed with %+v", (*seen)[0])
+			}
+			want := newLUN().Data
+			if r.SHA256 != sha256.Sum256(want) {
+				t.Error("wrong digest")
+			}
+			got := lun.Data
+			if phase == Reading {
+				got, _ = os.ReadFile(f.Name())
+			}
+			if !bytes.Equal(got, want) {
+				t.Error("copy differs")
+			}
+		})
+	}
+}
+
+func TestResumeMismatch(t *testing.T) {
+	lun := newLUN()
+	var cp *Checkpoint
+	opts := &Options{CheckpointInterval: 1, Checkpoint: func(c *Checkpoint) error { cp = c; return nil }}
+	if _, err := Read(scsi.New(scsi.BulkOnly(msctest.NewTransport(t, lun)), 0), newFile(t), opts); err != nil {
+		t.Fatal(err)
+	}
+	for name, resume := range map[string]*Checkpoint{
+		"phase":      {Phase: Writing, Blocks: cp.Blocks, BlockSize: cp.BlockSize, Next: cp.Next, Hash: cp.Hash},
+		"blocks":     {Phase: Reading, Blocks: 8192, BlockSize: cp.BlockSize, Next: cp.Next, Hash: cp.Hash},
+		"block size": {Phase: Reading, Blocks: cp.Blocks, BlockSize: 4096, Next: cp.Next, Hash: cp.Hash},
+		"hash":       {Phase: Reading, Blocks: cp.Blocks, BlockSize: cp.BlockSize, Next: cp.Next, Hash: []byte("x")},
+	} {
+		if _, err := Read(scsi.New(scsi.BulkOnly(msctest.NewTransport(t, lun)), 0), newFile(t), &Options{Resume: resume}); !errors.Is(err, ErrMismatch) {
+			t.Errorf("%s: err = %v", name, err)
+		}
+	}
+}
+
+// corrupting flips a bit in every read.
+type corrupting struct {
+	msc.Medium
+}
+
+func (m corrupting) Read(lba uint64, buf []byte) error {
+	err := m.Medium.Read(lba, buf)
+	buf[0] ^= 1
+	return err
+}
+
+func TestVerifyFails(t *testing.T) {
+	lun := newLUN()
+	image := bytes.Clone(lun.Data)
+	if _, err := Write(corrupting{scsi.New(scsi.BulkOnly(msctest.NewTransport(t, lun)), 0)}, bytes.NewReader(image), int64(len(image)), &Options{Verify: true}); !errors.Is(err, ErrVerify) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	m := scsi.New(scsi.BulkOnly(msctest.NewTransport(t, msctest.NewLUN(16, 512))), 0)
+	for _, size := range []int64{1000, 17 * 512} {
+		if _, err := Write(m, bytes.NewReader(make([]byte, size)), size, nil); err == nil {
+			t.Errorf("image of %d bytes written", size)
+		}
+	}
+	// An image that is shorter than it claims.
+	if _, err := Write(m, bytes.NewReader(make([]byte, 512)), 1024, nil); err == nil {
+		t.Error("short image written")
+	}
+}
@@ -0,0 +1,219 @@
+package exfat
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const fatEnd = 0xffffffff
+
+// stream is where the data of a file or directory is: a FAT chain, or with
+// noFATChain a contiguous run of clusters that the FAT does not describe.
+type stream struct {
+	first      uint32
+	size       uint64 // DataLength
+	valid      uint64 // ValidDataLength; beyond it the data reads as zeros
+	noFATChain bool
+}
+
+// chain returns the clusters of s. A FAT chain is followed to its end,
+// which is how the size of the root directory is known.
+func (f *FS) chain(s stream) ([]uint32, error) {
+	if s.first == 0 {
+		return nil, nil
+	}
+	cs := uint64(f.clusterSize)
+	if s.noFATChain {
+		n := (s.size + cs - 1) / cs
+		if !f.validCluster(s.first) || n > uint64(f.clusters) || uint64(s.first-2)+n > uint64(f.clusters) {
+			return nil, fmt.Errorf("%w: contiguous stream at cluster %d of %d bytes", ErrCorrupt, s.first, s.size)
+		}
+		chain := make([]uint32, n)
+		for i := range chain {
+			chain[i] = s.first + uint32(i)
+		}
+		return chain, nil
+	}
+	var chain []uint32
+	for c := s.first; c != fatEnd; {
+		if !f.validCluster(c) || uint32(len(chain)) >= f.clusters {
+			return nil, fmt.Errorf("%w: invalid cluster chain at %d", ErrCorrupt, s.first)
+		}
+		chain = append(chain, c)
+		next, err := f.fatEntry(c)
+		if err != nil {
+			return nil, err
+		}
+		c = next
+	}
+	return chain, nil
+}
+
+// loadBitmap reads the allocation bitmap, which is described by its entry
+// in the root directory.
+func (f *FS) loadBitmap(s *stream) error {
+	if s.size < uint64(f.clusters+7)/8 {
+		return fmt.Errorf("%w: allocation bitmap of %d bytes for %d clusters", ErrCorrupt, s.size, f.clusters)
+	}
+	chain, err := f.chain(*s)
+	if err != nil {
+		return err
+	}
+	for i := 1; i < len(chain); i++ {
+		if chain[i] != chain[i-1]+1 {
+			return fmt.Errorf("%w: fragmented allocation bitmap", ErrCorrupt)
+		}
+	}
+	f.bitmap = make([]byte, (f.clusters+7)/8)
+	f.bitmapOffset = f.clusterOffset(s.first)
+	if _, err := f.dev.ReadAt(f.bitmap, f.bitmapOffset); err != nil {
+		return err
+	}
+	for c := uint32(2); c < f.clusters+2; c++ {
+		if !f.allocated(c) {
+			f.free++
+		}
+	}
+	f.nextFree = 2
+	return nil
+}
+
+func (f *FS) allocated(c uint32) bool {
+	i := c - 2
+	return f.bitmap[i/8]&(1<<(i%8)) != 0
+}
+
+// mark sets the bitmap bits of clusters and writes the changed bytes.
+func (f *FS) mark(clusters []uint32, used bool) error {
+	if len(clusters) == 0 {
+		return nil
+	}
+	lo, hi := uint32(len(f.bitmap)), uint32(0)
+	for _, c := range clusters {
+		i := c - 2
+		if used {
+			f.bitmap[i/8] |= 1 << (i % 8)
+			f.free--
+		} else {
+			f.bitmap[i/8] &^= 1 << (i % 8)
+			f.free++
+		}
+		lo, hi = min(lo, i/8), max(hi, i/8)
+	}
+	_, err := f.w.WriteAt(f.bitmap[lo:hi+1], f.bitmapOffset+int64(lo))
+	return err
+}
+
+func (f *FS) setFAT(c, v uint32) error {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	_, err := f.w.WriteAt(b, f.fatOffset+4*int64(c))
+	return err
+}
+
+// chainFAT writes clusters into the FAT as a chain.
+func (f *FS) chainFAT(clusters []uint32) error {
+	for i, c := range clusters {
+		next := uint32(fatEnd)
+		if i+1 < len(clusters) {
+			next = clusters[i+1]
+		}
+		if err := f.setFAT(c, next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findFree returns n free clusters, contiguous if there is such a run, and
+// whether they are.
+func (f *FS) findFree(n int) ([]uint32, bool, error) {
+	if uint32(n) > f.free {
+		return nil, false, ErrNoSpace
+	}
+	run := 0
+	for i := range f.clusters {
+		c := 2 + (f.nextFree-2+i)%f.clusters
+		if c == 2 {
+			run = 0 // runs do not wrap around
+		}
+		if f.allocated(c) {
+			run = 0
+			continue
+		}
+		if run++; run == n {
+			clusters := make([]uint32, n)
+			for j := range clusters {
+				clusters[j] = c - uint32(n-1-j)
+			}
+			return clusters, true, nil
+		}
+	}
+	var clusters []uint32
+	for c := uint32(2); len(clusters) < n; c++ {
+		if !f.allocated(c) {
+			clusters = append(clusters, c)
+		}
+	}
+	return clusters, false, nil
+}
+
+// grow adds n clusters to s. An empty stream gets a contiguous run if there
+// is one. A contiguous stream stays contiguous if the clusters after it are
+// free, and becomes a FAT chain otherwise.
+func (f *FS) grow(s *stream, n int) ([]uint32, error) {
+	old, err := f.chain(*s)
+	if err != nil {
+		return nil, err
+	}
+	if s.noFATChain && len(old) > 0 {
+		last := old[len(old)-1]
+		next := make([]uint32, n)
+		ok := true
+		for i := range next {
+			next[i] = last + 1 + uint32(i)
+			ok = ok && f.validCluster(next[i]) && !f.allocated(next[i])
+		}
+		if ok {
+			f.nextFree = last + 1 + uint32(n)
+			return next, f.mark(next, true)
+		}
+	}
+	added, contiguous, err := f.findFree(n)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.mark(added, true); err != nil {
+		return nil, err
+	}
+	f.nextFree = added[len(added)-1] + 1
+	if len(old) == 0 && contiguous {
+		s.first, s.noFATChain = added[0], true
+		return added, nil
+	}
+	chain := append(old, added...)
+	if len(old) == 0 {
+		s.first = added[0]
+	}
+	s.noFATChain = false
+	return added, f.chainFAT(chain)
+}
+
+// shrink frees the clusters of s after the first keep.
+func (f *FS) shrink(s *stream, keep int) error {
+	chain, err := f.chain(*s)
+	if err != nil || keep >= len(chain) {
+		return err
+	}
+	if err := f.mark(chain[keep:], false); err != nil {
+		return err
+	}
+	if keep == 0 {
+		s.first, s.noFATChain = 0, false
+		return nil
+	}
+	if !s.noFATChain {
+		return f.setFAT(chain[keep-1], fatEnd)
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package exfat
+
+import (
+	"encoding/binary"
+	"io/fs"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const entrySize = 32
+
+// Entry types. The top bit is the in-use bit; a deleted entry has it clear.
+const (
+	typeEnd    = 0x00
+	typeInUse  = 0x80
+	typeBitmap = 0x81
+	typeUpcase = 0x82
+	typeLabel  = 0x83
+	typeFile   = 0x85
+	typeStream = 0xc0
+	typeName   = 0xc1
+)
+
+// File attributes.
+const (
+	attrReadOnly  = 0x01
+	attrHidden    = 0x02
+	attrSystem    = 0x04
+	attrDirectory = 0x10
+	attrArchive   = 0x20
+)
+
+// Stream extension flags.
+const (
+	flagAllocationPossible = 0x01
+	flagNoFATChain         = 0x02
+)
+
+const (
+	nameChars   = 15 // UTF-16 code units per file name entry
+	maxNameLen  = 255
+	maxLabelLen = 11
+	invalidChar = `"*/:<>?\|`
+)
+
+// dirent is a file entry set: a file entry, its stream extension and its
+// file name entries, plus any secondary entries this package does not
+// interpret, which are kept as they are.
+type dirent struct {
+	name    string
+	attr    uint16
+	created time.Time
+	mtime   time.Time
+	atime   time.Time
+	stream  stream
+
+	set  []byte  // the entries as on the device
+	offs []int64 // device offset of each entry of set
+}
+
+func (e *dirent) isDir() bool {
+	return e.attr&attrDirectory != 0
+}
+
+// dirBuf is a directory read into memory.
+type dirBuf struct {
+	f       *FS
+	dir     *dirent // the directory itself
+	data    []byte
+	offsets []int64 // device offset of each cluster
+
+	entries []*dirent
+	slots   []int // first slot of each element of entries
+
+	// Only found in the root directory.
+	bitmap    *stream
+	upcase    *stream
+	upcaseSum uint32
+	label     string
+}
+
+// readDir reads and parses the directory dir.
+func (f *FS) readDir(dir *dirent) (*dirBuf, error) {
+	chain, err := f.chain(dir.stream)
+	if err != nil {
+		return nil, err
+	}
+	d := &dirBuf{f: f, dir: dir, data: make([]byte, len(chain)*f.clusterSize)}
+	for i, c := range chain {
+		d.offsets = append(d.offsets, f.clusterOffset(c))
+		if _, err := f.dev.ReadAt(d.data[i*f.clusterSize:(i+1)*f.clusterSize], d.offsets[i]); err != nil {
+			return nil, err
+		}
+	}
+	d.parse()
+	return d, nil
+}
+
+func (d *dirBuf) count() int {
+	return len(d.data) / entrySize
+}
+
+func (d *dirBuf) slot(i int) []byte {
+	return d.data[i*entrySize : (i+1)*entrySize]
+}
+
+func (d *dirBuf) slotOffset(i int) int64 {
+	pos := i * entrySize
+	return d.offsets[pos/d.f.clusterSize] + int64(pos%d.f.clusterSize)
+}
+
+// parse collects the file entry sets and the critical primary entries of
+// the root directory. A set with a wrong checksum or missing entries is
+// ignored, as the specification demands.
+func (d *dirBuf) parse() {
+	for i := 0; i < d.count(); i++ {
+		s := d.slot(i)
+		switch s[0] {
+		case typeEnd:
+			return
+		case typeBitmap:
+			// With two FATs there is a second bitmap; flag bit 0 tells them
+			// apart, and the first is the one in use.
+			if s[1]&1 == 0 {
+				d.bitmap = parseStream(s)
+			}
+		case typeUpcase:
+			d.upcase = parseStream(s)
+			d.upcaseSum = binary.LittleEndian.Uint32(s[4:])
+		case typeLabel:
+			n := min(int(s[1]), maxLabelLen)
+			d.label = decodeName(nameUnits(s[2 : 2+2*n]))
+		case typeFile:
+			if e := d.parseSet(i); e != nil {
+				d.entries = append(d.entries, e)
+				d.slots = append(d.slots, i)
+				i += len(e.offs) - 1
+			}
+		}
+	}
+}
+
+// parseStream reads the first cluster and data length of an allocation
+// bitmap or up-case table entry, which are where a stream extension has
+// them.
+func parseStream(s []byte) *stream {
+	size := binary.LittleEndian.Uint64(s[24:])
+	return &stream{first: binary.LittleEndian.Uint32(s[20:]), size: size, valid: size}
+}
+
+func (d *dirBuf) parseSet(i int) *dirent {
+	secondary := int(d.slot(i)[1])
+	if secondary < 2 || i+secondary >= d.count() {
+		return nil
+	}
+	set := d.data[i*entrySize : (i+1+secondary)*entrySize]
+	if binary.LittleEndian.Uint16(set[2:]) != setChecksum(set) {
+		return nil
+	}
+	s := set[entrySize:]
+	if s[0] != typeStream {
+		return nil
+	}
+	nameLen := int(s[3])
+	var units []uint16
+	for j := 2; j <= secondary && len(units) < nameLen; j++ {
+		n := set[j*entrySize:]
+		if n[0] != typeName {
+			return nil
+		}
+		units = append(units, nameUnits(n[2:entrySize])...)
+	}
+	if nameLen == 0 || len(units) < nameLen {
+		return nil
+	}
+	e := &dirent{
+		name:    decodeName(units[:nameLen]),
+		attr:    binary.LittleEndian.Uint16(set[4:]),
+		created: decodeTime(binary.LittleEndian.Uint32(set[8:]), set[20], set[22]),
+		mtime:   decodeTime(binary.LittleEndian.Uint32(set[12:]), set[21], set[23]),
+		atime:   decodeTime(binary.LittleEndian.Uint32(set[16:]), 0, set[24]),
+		stream: stream{
+			first:      binary.LittleEndian.Uint32(s[20:]),
+			size:       binary.LittleEndian.Uint64(s[24:]),
+			valid:      binary.LittleEndian.Uint64(s[8:]),
+			noFATChain: s[1]&flagNoFATChain != 0,
+		},
+		set: append([]byte(nil), set...),
+	}
+	if s[1]&flagAllocationPossible == 0 {
+		e.stream.first = 0
+	}
+	for j := range 1 + secondary {
+		e.offs = append(e.offs, d.slotOffset(i+j))
+	}
+	return e
+}
+
+// find returns the entry called name, compared through the up-case table,
+// or nil.
+func (d *dirBuf) find(name string) *dirent {
+	if i := d.index(name); i >= 0 {
+		return d.entries[i]
+	}
+	return nil
+}
+
+func (d *dirBuf) index(name string) int {
+	want := d.f.upper(utf16.Encode([]rune(name)))
+	for i, e := range d.entries {
+		if equalUnits(d.f.upper(utf16.Encode([]rune(e.name))), want) {
+			return i
+		}
+	}
+	return -1
+}
+
+func equalUnits(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func nameUnits(b []byte) []uint16 {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return units
+}
+
+func decodeName(units []uint16) string {
+	for i, u := range units {
+		if u == 0 {
+			units = units[:i]
+			break
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// setChecksum is the checksum of an entry set, stored in its file entry.
+func setChecksum(set []byte) uint16 {
+	var sum uint16
+	for i, c := range set {
+		if i == 2 || i == 3 {
+			continue
+		}
+		sum = (sum&1)<<15 + sum>>1 + uint16(c)
+	}
+	return sum
+}
+
+// decodeTime decodes a time stamp, its 10 ms increments and its offset from
+// UTC. Without a valid offset the time is local.
+func decodeTime(ts uint32, ms10, utc uint8) time.Time {
+	if ts == 0 {
+		return time.Time{}
+	}
+	loc := time.Local
+	if utc&0x80 != 0 {
+		loc = time.FixedZone("", int(int8(utc<<1)>>1)*15*60)
+	}
+	return time.Date(
+		1980+int(ts>>25), time.Month(ts>>21&0x0f), int(ts>>16&0x1f),
+		int(ts>>11&0x1f), int(ts>>5&0x3f), int(ts&0x1f)*2+int(ms10/100),
+		int(ms10%100)*10*int(time.Millisecond), loc)
+}
+
+func encodeTime(t time.Time) (ts uint32, ms10, utc uint8) {
+	if t.Year() < 1980 {
+		return 0x21 << 16, 0, 0 // 1980-01-01, local
+	}
+	_, offset := t.Zone()
+	ts = uint32(t.Year()-1980)<<25 | uint32(t.Month())<<21 | uint32(t.Day())<<16 |
+		uint32(t.Hour())<<11 | uint32(t.Minute())<<5 | uint32(t.Second()/2)
+	ms10 = uint8(t.Second()%2*100 + t.Nanosecond()/int(10*time.Millisecond))
+	return ts, ms10, 0x80 | uint8(offset/(15*60))&0x7f
+}
+
+// newSet returns an entry set for a file called name, with room for the
+// fields that encode fills in.
+func (f *FS) newSet(name string) []byte {
+	units := utf16.Encode([]rune(name))
+	names := (len(units) + nameChars - 1) / nameChars
+	set := make([]byte, (2+names)*entrySize)
+	set[0], set[1] = typeFile, uint8(1+names)
+	s := set[entrySize:]
+	s[0], s[3] = typeStream, uint8(len(units))
+	binary.LittleEndian.PutUint16(s[4:], f.nameHash(units))
+	for i, u := range units {
+		n := set[(2+i/nameChars)*entrySize:]
+		n[0] = typeName
+		binary.LittleEndian.PutUint16(n[2+2*(i%nameChars):], u)
+	}
+	return set
+}
+
+// encode stores the fields of e in its entry set and updates the checksum.
+func (e *dirent) encode() {
+	set := e.set
+	binary.LittleEndian.PutUint16(set[4:], e.attr)
+	ts, ms10, utc := encodeTime(e.created)
+	binary.LittleEndian.PutUint32(set[8:], ts)
+	set[20], set[22] = ms10, utc
+	ts, ms10, utc = encodeTime(e.mtime)
+	binary.LittleEndian.PutUint32(set[12:], ts)
+	set[21], set[23] = ms10, utc
+	ts, _, utc = encodeTime(e.atime)
+	binary.LittleEndian.PutUint32(set[16:], ts)
+	set[24] = utc
+
+	s := set[entrySize:]
+	s[1] = 0
+	if e.stream.first != 0 {
+		s[1] |= flagAllocationPossible
+		if e.stream.noFATChain {
+			s[1] |= flagNoFATChain
+		}
+	}
+	binary.LittleEndian.PutUint64(s[8:], e.stream.valid)
+	binary.LittleEndian.PutUint32(s[20:], e.stream.first)
+	binary.LittleEndian.PutUint64(s[24:], e.stream.size)
+	binary.LittleEndian.PutUint16(set[2:], setChecksum(set))
+}
+
+// validName checks a name for a new entry.
+func validName(name string) bool {
+	if name == "" || name == "." || name == ".." || len(utf16.Encode([]rune(name))) > maxNameLen {
+		return false
+	}
+	return strings.IndexFunc(name, func(r rune) bool {
+		return r < 0x20 || strings.ContainsRune(invalidChar, r)
+	}) < 0
+}
+
+// fileInfo implements fs.FileInfo and fs.DirEntry.
+type fileInfo struct {
+	name string
+	e    dirent
+}
+
+func (e *dirent) info(name string) *fileInfo {
+	return &fileInfo{name: name, e: *e}
+}
+
+func (i *fileInfo) Name() string               { return i.name }
+func (i *fileInfo) Size() int64                { return int64(i.e.stream.size) }
+func (i *fileInfo) ModTime() time.Time         { return i.e.mtime }
+func (i *fileInfo) IsDir() bool                { return i.e.isDir() }
+func (i *fileInfo) Sys() any                   { return nil }
+func (i *fileInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i *fileInfo) Info() (fs.FileInfo, error) { return i, nil }
+func (i *fileInfo) String() string             { return fs.FormatFileInfo(i) }
+
+func (i *fileInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(0o666)
+	if i.e.isDir() {
+		mode = fs.ModeDir | 0o777
+	}
+	if i.e.attr&attrReadOnly != 0 {
+		mode &^= 0o222
+	}
+	return mode
+}
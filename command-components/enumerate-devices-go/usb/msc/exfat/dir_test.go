@@ -0,0 +1,82 @@
+package exfat
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+func TestSetRoundTrip(t *testing.T) {
+	f, _ := openImage(t, "exfat512.img.gz")
+	when := time.Date(2023, 12, 31, 23, 59, 59, 990*int(time.Millisecond), time.FixedZone("", -5*3600))
+	for _, name := range []string{"a", "exactly 15 char", "exactly 16 chars", "日本語のファイル名.txt", strings.Repeat("x", 255)} {
+		e := &dirent{
+			name: name, attr: attrArchive, created: when, mtime: when, atime: when,
+			stream: stream{first: 7, size: 5000, valid: 4000, noFATChain: true},
+		}
+		e.set = f.newSet(name)
+		e.encode()
+		data := append(e.set, make([]byte, entrySize)...)
+		d := &dirBuf{f: &FS{clusterSize: len(data), upcase: f.upcase}, data: data, offsets: []int64{0}}
+		d.parse()
+		if len(d.entries) != 1 {
+			t.Fatalf("%q: %d entries", name, len(d.entries))
+		}
+		got := d.entries[0]
+		if got.name != name || got.stream != e.stream || !got.mtime.Equal(when) || !got.created.Equal(when) {
+			t.Errorf("%q: got %q, %+v, %v", name, got.name, got.stream, got.mtime)
+		}
+	}
+
+	// A set whose checksum does not match is ignored.
+	e := &dirent{name: "x"}
+	e.set = f.newSet("x")
+	e.encode()
+	e.set[40]++
+	d := &dirBuf{f: &FS{clusterSize: 128, upcase: f.upcase}, data: append(e.set, make([]byte, 32)...), offsets: []int64{0}}
+	if d.parse(); len(d.entries) != 0 {
+		t.Errorf("entries %v", d.entries)
+	}
+}
+
+func TestNameHash(t *testing.T) {
+	f, _ := openImage(t, "exfat4k.img.gz")
+	d, err := f.readDir(f.root())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range d.entries {
+		units := utf16.Encode([]rune(e.name))
+		if got := f.nameHash(units); got != uint16(e.set[36])|uint16(e.set[37])<<8 {
+			t.Errorf("%q: hash %#x", e.name, got)
+		}
+		// The hash is over the name in upper case.
+		if f.nameHash(units) != f.nameHash(utf16.Encode([]rune(strings.ToLower(e.name)))) {
+			t.Errorf("%q: hash depends on case", e.name)
+		}
+	}
+}
+
+func TestUpcaseTable(t *testing.T) {
+	f, _ := openImage(t, "exfat4k.img.gz")
+	for in, want := range map[rune]rune{'a': 'A', 'Z': 'Z', 'é': 'É', '÷': '÷', 'ÿ': 'Ÿ', '0': '0', 'ω': 'ω'} {
+		if got := rune(f.upcase[in]); got != want {
+			t.Errorf("%q maps to %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTimeEncoding(t *testing.T) {
+	want := time.Date(2023, 12, 31, 23, 59, 59, 990*int(time.Millisecond), time.FixedZone("", 5*3600+45*60))
+	ts, ms10, utc := encodeTime(want)
+	if got := decodeTime(ts, ms10, utc); !got.Equal(want) {
+		t.Errorf("got %v", got)
+	}
+	if _, off := decodeTime(ts, ms10, utc).Zone(); off != 5*3600+45*60 {
+		t.Errorf("offset %d", off)
+	}
+	if ts, _, _ := encodeTime(time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)); ts != 0x21<<16 {
+		t.Errorf("time before 1980 encoded as %#x", ts)
+	}
+}
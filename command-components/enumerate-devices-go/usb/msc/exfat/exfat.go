@@ -0,0 +1,291 @@
+// Package exfat implements the exFAT file system on a block device, such as
+// an msc.BlockDevice or an image file.
+//
+// FS implements fs.FS, fs.ReadDirFS and fs.StatFS. Open gives read-only
+// access. OpenWritable also allows files and directories to be created,
+// written, truncated, renamed and removed; new files are allocated
+// contiguously without a FAT chain where possible. Every change is written
+// through to the device before the call returns.
+package exfat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotExFAT    = errors.New("exfat: not an exFAT file system")
+	ErrCorrupt     = errors.New("exfat: file system is corrupt")
+	ErrReadOnly    = errors.New("exfat: file system is opened read-only")
+	ErrNoSpace     = errors.New("exfat: no space left on device")
+	ErrNotEmpty    = errors.New("exfat: directory not empty")
+	ErrInvalidName = errors.New("exfat: invalid file name")
+)
+
+// Device is a block device that can be written.
+type Device interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// FS is an exFAT file system. It is not safe for concurrent use.
+type FS struct {
+	dev io.ReaderAt
+	w   io.WriterAt // nil if opened read-only
+
+	sectorSize  int
+	clusterSize int
+	fatOffset   int64
+	heapOffset  int64
+	clusters    uint32 // valid cluster numbers are 2 to clusters+1
+	rootCluster uint32
+	serial      uint32
+	label       string
+
+	bitmap       []byte // one bit per cluster, cluster 2 first
+	bitmapOffset int64  // on the device; the bitmap is contiguous
+	free         uint32
+	nextFree     uint32
+	upcase       []uint16 // maps every UTF-16 code unit to upper case
+
+	// Now returns the time stamps for changes. Defaults to time.Now.
+	Now func() time.Time
+}
+
+var _ interface {
+	fs.ReadDirFS
+	fs.StatFS
+} = (*FS)(nil)
+
+// Open reads the boot region, the allocation bitmap and the up-case table
+// of the file system on dev for reading.
+func Open(dev io.ReaderAt) (*FS, error) {
+	f := &FS{dev: dev, Now: time.Now}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// OpenWritable is like Open, but also allows changes.
+func OpenWritable(dev Device) (*FS, error) {
+	f := &FS{dev: dev, w: dev, Now: time.Now}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FS) load() error {
+	b := make([]byte, 512)
+	if _, err := f.dev.ReadAt(b, 0); err != nil {
+		return err
+	}
+	if string(b[3:11]) != "EXFAT   " || b[510] != 0x55 || b[511] != 0xaa {
+		return ErrNotExFAT
+	}
+	sectorShift, clusterShift := b[108], b[109]
+	if sectorShift < 9 || sectorShift > 12 || sectorShift+clusterShift > 25 {
+		return ErrNotExFAT
+	}
+	f.sectorSize = 1 << sectorShift
+	f.clusterSize = f.sectorSize << clusterShift
+	ss := int64(f.sectorSize)
+	f.fatOffset = int64(binary.LittleEndian.Uint32(b[80:])) * ss
+	f.heapOffset = int64(binary.LittleEndian.Uint32(b[88:])) * ss
+	f.clusters = binary.LittleEndian.Uint32(b[92:])
+	f.rootCluster = binary.LittleEndian.Uint32(b[96:])
+	f.serial = binary.LittleEndian.Uint32(b[100:])
+	switch b[110] {
+	case 1:
+	case 2:
+		// TexFAT keeps a second FAT; the volume flags say which is active.
+		if b[106]&1 != 0 {
+			f.fatOffset += int64(binary.LittleEndian.Uint32(b[84:])) * ss
+		}
+		if f.w != nil {
+			return fmt.Errorf("exfat: writing to a volume with %d FATs is not supported", b[110])
+		}
+	default:
+		return ErrNotExFAT
+	}
+	if err := f.verifyBootChecksum(); err != nil {
+		return err
+	}
+	if !f.validCluster(f.rootCluster) {
+		return fmt.Errorf("%w: root cluster %d", ErrCorrupt, f.rootCluster)
+	}
+
+	root, err := f.readDir(f.root())
+	if err != nil {
+		return err
+	}
+	if root.bitmap == nil || root.upcase == nil {
+		return fmt.Errorf("%w: root directory lacks the allocation bitmap or up-case table", ErrCorrupt)
+	}
+	if err := f.loadBitmap(root.bitmap); err != nil {
+		return err
+	}
+	if err := f.loadUpcase(root.upcase, root.upcaseSum); err != nil {
+		return err
+	}
+	f.label = root.label
+	return nil
+}
+
+// verifyBootChecksum checks the checksum sector of the main boot region,
+// which covers the boot sector without its volume flags and percent in use,
+// the extended boot sectors, the OEM parameters and the reserved sector.
+func (f *FS) verifyBootChecksum() error {
+	region := make([]byte, 12*f.sectorSize)
+	if _, err := f.dev.ReadAt(region, 0); err != nil {
+		return err
+	}
+	sum := bootChecksum(region[:11*f.sectorSize])
+	for i := 11 * f.sectorSize; i < len(region); i += 4 {
+		if binary.LittleEndian.Uint32(region[i:]) != sum {
+			return fmt.Errorf("%w: boot region checksum mismatch", ErrCorrupt)
+		}
+	}
+	return nil
+}
+
+func bootChecksum(b []byte) uint32 {
+	var sum uint32
+	for i, c := range b {
+		if i == 106 || i == 107 || i == 112 {
+			continue
+		}
+		sum = (sum&1)<<31 + sum>>1 + uint32(c)
+	}
+	return sum
+}
+
+// Label returns the volume label.
+func (f *FS) Label() string {
+	return f.label
+}
+
+// Serial returns the volume serial number.
+func (f *FS) Serial() uint32 {
+	return f.serial
+}
+
+// ClusterSize returns the allocation unit in bytes.
+func (f *FS) ClusterSize() int {
+	return f.clusterSize
+}
+
+// Size returns the size of the cluster heap in bytes.
+func (f *FS) Size() int64 {
+	return int64(f.clusters) * int64(f.clusterSize)
+}
+
+// Free returns the number of bytes in free clusters.
+func (f *FS) Free() int64 {
+	return int64(f.free) * int64(f.clusterSize)
+}
+
+func (f *FS) validCluster(c uint32) bool {
+	return c >= 2 && c-2 < f.clusters
+}
+
+func (f *FS) clusterOffset(c uint32) int64 {
+	return f.heapOffset + int64(c-2)*int64(f.clusterSize)
+}
+
+// fatEntry reads the FAT entry of cluster c.
+func (f *FS) fatEntry(c uint32) (uint32, error) {
+	b := make([]byte, 4)
+	if _, err := f.dev.ReadAt(b, f.fatOffset+4*int64(c)); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+// root returns the entry standing for the root directory, whose clusters
+// are always chained in the FAT.
+func (f *FS) root() *dirent {
+	return &dirent{name: ".", attr: attrDirectory, stream: stream{first: f.rootCluster}}
+}
+
+// lookup returns the entry for name and the directory it is in.
+func (f *FS) lookup(op, name string) (*dirent, *dirBuf, error) {
+	if !fs.ValidPath(name) {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	e := f.root()
+	if name == "." {
+		return e, nil, nil
+	}
+	var parent *dirBuf
+	for _, elem := range strings.Split(name, "/") {
+		if !e.isDir() {
+			return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		d, err := f.readDir(e)
+		if err != nil {
+			return nil, nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		if e = d.find(elem); e == nil {
+			return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		parent = d
+	}
+	return e, parent, nil
+}
+
+// Open opens the named file or directory for reading.
+func (f *FS) Open(name string) (fs.File, error) {
+	file, err := f.OpenFile(name, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Stat returns a FileInfo describing the named file.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	e, _, err := f.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return e.info(path.Base(name)), nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, _, err := f.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.isDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries, err := f.dirEntries(e)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+func (f *FS) dirEntries(dir *dirent) ([]fs.DirEntry, error) {
+	d, err := f.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []fs.DirEntry
+	for _, e := range d.entries {
+		entries = append(entries, e.info(e.name))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
@@ -0,0 +1,185 @@
+package exfat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// The images in testdata were made following the specification: a 4 MiB
+// volume with 4 KiB clusters and a 1 MiB one with 512-byte clusters. Each
+// holds the same files: contiguous ones without a FAT chain, a fragmented
+// one, one with less valid data than its length, and directories both
+// contiguous and chained.
+var images = []struct {
+	file        string
+	clusterSize int
+}{
+	{"exfat4k.img.gz", 4096},
+	{"exfat512.img.gz", 512},
+}
+
+const longName = "A file name longer than fifteen characters.txt"
+
+// openImage copies a test image to a temporary file and opens it for
+// writing.
+func openImage(t *testing.T, name string) (*FS, *os.File) {
+	t.Helper()
+	src, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	zr, err := gzip.NewReader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := os.Create(filepath.Join(t.TempDir(), strings.TrimSuffix(name, ".gz")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { img.Close() })
+	if _, err := io.Copy(img, zr); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenWritable(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, img
+}
+
+// checkAllocation fails the test unless the allocation bitmap on the device
+// marks exactly the clusters of the files, directories and metadata, and
+// no cluster belongs to two of them.
+func checkAllocation(t *testing.T, f *FS) {
+	t.Helper()
+	f, err := Open(f.dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := map[uint32]string{}
+	claim := func(name string, s stream) {
+		chain, err := f.chain(s)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if uint64(len(chain)) != (s.size+uint64(f.clusterSize)-1)/uint64(f.clusterSize) && name != "/" {
+			t.Errorf("%s: %d bytes in %d clusters", name, s.size, len(chain))
+		}
+		for _, c := range chain {
+			if other, ok := owner[c]; ok {
+				t.Errorf("%s: cluster %d also belongs to %s", name, c, other)
+			}
+			owner[c] = name
+		}
+	}
+	var walk func(name string, dir *dirent)
+	walk = func(name string, dir *dirent) {
+		claim(name, dir.stream)
+		d, err := f.readDir(dir)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if d.bitmap != nil {
+			claim("bitmap", *d.bitmap)
+			claim("up-case table", *d.upcase)
+		}
+		for _, e := range d.entries {
+			if e.isDir() {
+				walk(path.Join(name, e.name), e)
+			} else {
+				claim(path.Join(name, e.name), e.stream)
+			}
+		}
+	}
+	walk("/", f.root())
+	for c := uint32(2); c < f.clusters+2; c++ {
+		if _, ok := owner[c]; ok != f.allocated(c) {
+			t.Errorf("cluster %d: allocated %v, in use %v", c, f.allocated(c), ok)
+		}
+	}
+}
+
+func TestReadImages(t *testing.T) {
+	for _, img := range images {
+		t.Run(img.file, func(t *testing.T) {
+			f, dev := openImage(t, img.file)
+			f, err := Open(dev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Label() != "exFAT Test" || f.Serial() != 0x1234abcd || f.ClusterSize() != img.clusterSize {
+				t.Errorf("label %q, serial %#x, cluster size %d", f.Label(), f.Serial(), f.ClusterSize())
+			}
+			if err := fstest.TestFS(f, "README.TXT", longName, "fragmented.bin", "sparse.dat",
+				"readonly.txt", "Docs/Résumé.txt", "Docs/sub/empty", "Many/file39.dat"); err != nil {
+				t.Fatal(err)
+			}
+			checkAllocation(t, f)
+
+			b, err := fs.ReadFile(f, longName)
+			if err != nil || len(b) != 5000 || b[4999] != byte(4999*7%251) {
+				t.Errorf("long file: %d bytes, %v", len(b), err)
+			}
+			b, err = fs.ReadFile(f, "fragmented.bin")
+			if err != nil || len(b) != 3*img.clusterSize+100 {
+				t.Fatalf("fragmented file: %d bytes, %v", len(b), err)
+			}
+			for i := range b {
+				if b[i] != byte(i*13%253) {
+					t.Fatalf("fragmented file: byte %d is %d", i, b[i])
+				}
+			}
+			b, _ = fs.ReadFile(f, "sparse.dat")
+			if want := append(bytes.Repeat([]byte("v"), 1000), make([]byte, 2000)...); !bytes.Equal(b, want) {
+				t.Errorf("data beyond the valid length: %q", b[995:1005])
+			}
+			// Names match through the up-case table.
+			for _, name := range []string{"readme.txt", "DOCS/RÉSUMÉ.TXT", "many/FILE07.DAT"} {
+				if _, err := f.Stat(name); err != nil {
+					t.Errorf("%s: %v", name, err)
+				}
+			}
+			if b, _ := fs.ReadFile(f, "docs/résumé.txt"); string(b) != "curriculum vitae\n" {
+				t.Errorf("Résumé.txt holds %q", b)
+			}
+
+			entries, err := f.ReadDir("Docs")
+			if err != nil || len(entries) != 2 || entries[0].Name() != "Résumé.txt" || !entries[1].IsDir() {
+				t.Errorf("Docs: %v, %v", entries, err)
+			}
+			info, _ := f.Stat("README.TXT")
+			want := time.Date(2024, 3, 14, 15, 9, 26, 500*int(time.Millisecond), time.FixedZone("", 3600))
+			if !info.ModTime().Equal(want) {
+				t.Errorf("modification time %v", info.ModTime())
+			}
+			if info, _ := f.Stat("readonly.txt"); info.Mode() != 0o444 {
+				t.Errorf("read-only file has mode %v", info.Mode())
+			}
+			if _, err := f.Open("README.TXT/x"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("file as directory: %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	if _, err := Open(strings.NewReader(strings.Repeat("\x00", 8192))); !errors.Is(err, ErrNotExFAT) {
+		t.Errorf("zeros: %v", err)
+	}
+	_, dev := openImage(t, "exfat4k.img.gz")
+	dev.WriteAt([]byte{0x42}, 200) // in the boot code
+	if _, err := Open(dev); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("boot region changed: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package exfat
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// maxUpcaseSize bounds the up-case table, which maps at most 65536 code
+// units of two bytes each.
+const maxUpcaseSize = 2 << 16
+
+// loadUpcase reads the up-case table and checks it against sum, the
+// checksum in its directory entry. The table may be compressed: 0xffff
+// followed by a count stands for that many code units that map to
+// themselves. Code units beyond the end of the table also do.
+func (f *FS) loadUpcase(s *stream, sum uint32) error {
+	if s.size%2 != 0 || s.size > maxUpcaseSize {
+		return fmt.Errorf("%w: up-case table of %d bytes", ErrCorrupt, s.size)
+	}
+	data, err := f.readStream(*s)
+	if err != nil {
+		return err
+	}
+	if tableChecksum(data) != sum {
+		return fmt.Errorf("%w: up-case table checksum mismatch", ErrCorrupt)
+	}
+	f.upcase = make([]uint16, 1<<16)
+	for i := range f.upcase {
+		f.upcase[i] = uint16(i)
+	}
+	j := 0
+	for i := 0; i+1 < len(data) && j < len(f.upcase); i += 2 {
+		u := binary.LittleEndian.Uint16(data[i:])
+		if u == 0xffff && i+3 < len(data) {
+			i += 2
+			j += int(binary.LittleEndian.Uint16(data[i:]))
+			continue
+		}
+		f.upcase[j] = u
+		j++
+	}
+	return nil
+}
+
+func tableChecksum(b []byte) uint32 {
+	var sum uint32
+	for _, c := range b {
+		sum = (sum&1)<<31 + sum>>1 + uint32(c)
+	}
+	return sum
+}
+
+// readStream reads all the data of s.
+func (f *FS) readStream(s stream) ([]byte, error) {
+	chain, err := f.chain(s)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(chain))*uint64(f.clusterSize) < s.size {
+		return nil, fmt.Errorf("%w: stream of %d bytes in %d clusters", ErrCorrupt, s.size, len(chain))
+	}
+	data := make([]byte, s.size)
+	for i, c := range chain {
+		pos := i * f.clusterSize
+		if pos >= len(data) {
+			break
+		}
+		end := min(pos+f.clusterSize, len(data))
+		if _, err := f.dev.ReadAt(data[pos:end], f.clusterOffset(c)); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// upper maps name to upper case through the up-case table.
+func (f *FS) upper(name []uint16) []uint16 {
+	up := make([]uint16, len(name))
+	for i, u := range name {
+		up[i] = f.upcase[u]
+	}
+	return up
+}
+
+// nameHash is the hash of a file name stored in its stream extension, over
+// the name in upper case.
+func (f *FS) nameHash(name []uint16) uint16 {
+	var hash uint16
+	for _, u := range f.upper(name) {
+		hash = (hash&1)<<15 + hash>>1 + u&0xff
+		hash = (hash&1)<<15 + hash>>1 + u>>8
+	}
+	return hash
+}
@@ -0,0 +1,299 @@
+package exfat
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// writeEntry writes the entry set of e back to its directory.
+func (f *FS) writeEntry(e *dirent) error {
+	e.encode()
+	for i, off := range e.offs {
+		if _, err := f.w.WriteAt(e.set[i*entrySize:(i+1)*entrySize], off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parentDir reads the directory that is to contain name and checks the
+// last element of name for a new entry.
+func (f *FS) parentDir(op, name string) (*dirBuf, string, error) {
+	if f.w == nil {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: ErrReadOnly}
+	}
+	if !fs.ValidPath(name) || name == "." {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	dir, base := path.Split(name)
+	parent, _, err := f.lookup(op, path.Clean(dir+"."))
+	if err != nil {
+		return nil, "", err
+	}
+	if !parent.isDir() {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	d, err := f.readDir(parent)
+	if err != nil {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if !validName(base) {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: ErrInvalidName}
+	}
+	return d, base, nil
+}
+
+// create adds an entry for a new, empty file or directory called name. The
+// caller has checked that it does not exist.
+func (f *FS) create(name string, attr uint16) (*dirent, error) {
+	d, base, err := f.parentDir("create", name)
+	if err != nil {
+		return nil, err
+	}
+	now := f.Now()
+	return d.add(&dirent{name: base, attr: attr, created: now, mtime: now, atime: now})
+}
+
+// add writes a new entry set for e to the directory, which grows by a
+// cluster if there is no room.
+func (d *dirBuf) add(e *dirent) (*dirent, error) {
+	e.set = d.f.newSet(e.name)
+	e.encode()
+	n := len(e.set) / entrySize
+	first := d.freeSlots(n)
+	if first < 0 {
+		if err := d.grow(n); err != nil {
+			return nil, err
+		}
+		first = d.freeSlots(n)
+	}
+	e.offs = nil
+	for i := range n {
+		copy(d.slot(first+i), e.set[i*entrySize:])
+		e.offs = append(e.offs, d.slotOffset(first+i))
+	}
+	if err := d.f.writeEntry(e); err != nil {
+		return nil, err
+	}
+	d.entries = append(d.entries, e)
+	d.slots = append(d.slots, first)
+	return e, nil
+}
+
+// freeSlots returns the first of n consecutive unused entries, or -1.
+func (d *dirBuf) freeSlots(n int) int {
+	run := 0
+	for i := range d.count() {
+		switch t := d.slot(i)[0]; {
+		case t == typeEnd:
+			// Everything after the end marker is unused.
+			if d.count()-i >= n-run {
+				return i - run
+			}
+			return -1
+		case t&typeInUse == 0:
+			run++
+		default:
+			run = 0
+		}
+		if run == n {
+			return i - n + 1
+		}
+	}
+	return -1
+}
+
+// grow adds zeroed clusters to the directory for at least n more entries
+// and records its new size in its own entry. The size of the root
+// directory is only recorded by its FAT chain.
+func (d *dirBuf) grow(n int) error {
+	f := d.f
+	count := (n*entrySize + f.clusterSize - 1) / f.clusterSize
+	added, err := f.grow(&d.dir.stream, count)
+	if err != nil {
+		return err
+	}
+	for _, c := range added {
+		if _, err := f.w.WriteAt(make([]byte, f.clusterSize), f.clusterOffset(c)); err != nil {
+			return err
+		}
+		d.offsets = append(d.offsets, f.clusterOffset(c))
+		d.data = append(d.data, make([]byte, f.clusterSize)...)
+	}
+	if d.dir.offs == nil {
+		return nil
+	}
+	d.dir.stream.size += uint64(count * f.clusterSize)
+	d.dir.stream.valid = d.dir.stream.size
+	return f.writeEntry(d.dir)
+}
+
+// remove clears the in-use bit of the entries of e.
+func (d *dirBuf) remove(e *dirent) error {
+	for i, x := range d.entries {
+		if x != e {
+			continue
+		}
+		for j := range len(e.offs) {
+			s := d.slot(d.slots[i] + j)
+			s[0] &^= typeInUse
+			if _, err := d.f.w.WriteAt(s[:1], e.offs[j]); err != nil {
+				return err
+			}
+		}
+		d.entries = append(d.entries[:i], d.entries[i+1:]...)
+		d.slots = append(d.slots[:i], d.slots[i+1:]...)
+		break
+	}
+	return nil
+}
+
+// Mkdir creates a directory.
+func (f *FS) Mkdir(name string) error {
+	if _, _, err := f.lookup("mkdir", name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	d, base, err := f.parentDir("mkdir", name)
+	if err != nil {
+		return err
+	}
+	now := f.Now()
+	e := &dirent{name: base, attr: attrDirectory, created: now, mtime: now, atime: now}
+	added, err := f.grow(&e.stream, 1)
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	e.stream.size = uint64(f.clusterSize)
+	e.stream.valid = e.stream.size
+	if _, err := f.w.WriteAt(make([]byte, f.clusterSize), f.clusterOffset(added[0])); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if _, err := d.add(e); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: errors.Join(err, f.shrink(&e.stream, 0))}
+	}
+	return nil
+}
+
+// MkdirAll creates a directory and any parents that do not exist yet.
+func (f *FS) MkdirAll(name string) error {
+	if e, _, err := f.lookup("mkdir", name); err == nil {
+		if !e.isDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: errors.New("not a directory")}
+		}
+		return nil
+	}
+	if dir := path.Dir(name); dir != "." {
+		if err := f.MkdirAll(dir); err != nil {
+			return err
+		}
+	}
+	return f.Mkdir(name)
+}
+
+// Remove removes a file or an empty directory.
+func (f *FS) Remove(name string) error {
+	if f.w == nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: ErrReadOnly}
+	}
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	e, d, err := f.lookup("remove", name)
+	if err != nil {
+		return err
+	}
+	if e.isDir() {
+		sub, err := f.readDir(e)
+		if err != nil {
+			return &fs.PathError{Op: "remove", Path: name, Err: err}
+		}
+		if len(sub.entries) > 0 {
+			return &fs.PathError{Op: "remove", Path: name, Err: ErrNotEmpty}
+		}
+	}
+	if err := d.remove(e); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if err := f.shrink(&e.stream, 0); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Rename renames or moves a file or directory. An existing file at newname
+// is replaced; an existing directory is not. exFAT directories do not point
+// at their parent, so a moved directory needs no change.
+func (f *FS) Rename(oldname, newname string) error {
+	if f.w == nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrReadOnly}
+	}
+	if oldname == "." || newname == "." {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrInvalid}
+	}
+	e, _, err := f.lookup("rename", oldname)
+	if err != nil {
+		return err
+	}
+	if e.isDir() && strings.HasPrefix(newname+"/", oldname+"/") {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errors.New("cannot move a directory into itself")}
+	}
+	if target, _, err := f.lookup("rename", newname); err == nil {
+		if target.offs[0] == e.offs[0] {
+			// Only the case of the name changes.
+		} else if target.isDir() {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrExist}
+		} else if err := f.Remove(newname); err != nil {
+			return err
+		}
+	}
+
+	d, base, err := f.parentDir("rename", newname)
+	if err != nil {
+		return err
+	}
+	moved := *e
+	moved.name = base
+	if _, err := d.add(&moved); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	// The old entry may be in the directory just changed, so read that
+	// again. If only the case changes, both entries match the old name; the
+	// old one is where it always was.
+	od := d
+	if path.Dir(oldname) != path.Dir(newname) {
+		parent, _, err := f.lookup("rename", path.Dir(oldname))
+		if err == nil {
+			od, err = f.readDir(parent)
+		}
+		if err != nil {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+		}
+	}
+	if err := od.remove(od.at(e.offs[0])); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+// at returns the entry whose set starts at device offset off, or nil.
+func (d *dirBuf) at(off int64) *dirent {
+	for _, e := range d.entries {
+		if e.offs[0] == off {
+			return e
+		}
+	}
+	return nil
+}
+
+// Truncate changes the size of the named file.
+func (f *FS) Truncate(name string, size int64) error {
+	file, err := f.OpenFile(name, os.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Truncate(size)
+}
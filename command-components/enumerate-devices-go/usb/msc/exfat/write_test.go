@@ -0,0 +1,249 @@
+package exfat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestWriteImages(t *testing.T) {
+	for _, img := range images {
+		t.Run(img.file, func(t *testing.T) {
+			f, dev := openImage(t, img.file)
+			now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+			f.Now = func() time.Time { return now }
+
+			// Create a file spanning several clusters, then append to it.
+			file, err := f.Create("Docs/New File.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := bytes.Repeat([]byte("0123456789abcdef"), 600)
+			if n, err := file.Write(data); n != len(data) || err != nil {
+				t.Fatalf("Write = %d, %v", n, err)
+			}
+			file.Close()
+			file, err = f.OpenFile("docs/new file.log", os.O_WRONLY|os.O_APPEND)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Write([]byte("tail"))
+			file.Close()
+			want := append(bytes.Clone(data), "tail"...)
+
+			// Truncate one file, extend others, overwrite across clusters.
+			if err := f.Truncate(longName, 600); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Truncate("sparse.dat", 5000); err != nil {
+				t.Fatal(err)
+			}
+			file, _ = f.OpenFile("README.TXT", os.O_RDWR)
+			if _, err := file.WriteAt([]byte("!"), 1000); err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+			file, _ = f.OpenFile("fragmented.bin", os.O_WRONLY)
+			if _, err := file.WriteAt([]byte("xyzw"), int64(img.clusterSize-2)); err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+
+			if err := f.MkdirAll("new/deeper"); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Rename("readonly.txt", "new/deeper/moved.txt"); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Rename("Docs/sub", "new/sub"); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Rename("README.TXT", "ReadMe.txt"); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Remove("Many/file00.dat"); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Remove("Docs"); !errors.Is(err, ErrNotEmpty) {
+				t.Errorf("removing a full directory: %v", err)
+			}
+			checkAllocation(t, f)
+
+			// Everything must be on the image, so open it again.
+			f, err = Open(dev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := fstest.TestFS(f, "Docs/New File.log", "ReadMe.txt", "new/deeper/moved.txt", "new/sub/empty"); err != nil {
+				t.Fatal(err)
+			}
+			if b, _ := fs.ReadFile(f, "Docs/New File.log"); !bytes.Equal(b, want) {
+				t.Errorf("appended file holds %d bytes", len(b))
+			}
+			if info, _ := f.Stat("Docs/New File.log"); !info.ModTime().Equal(now) {
+				t.Errorf("modification time %v", info.ModTime())
+			}
+			if b, _ := fs.ReadFile(f, longName); len(b) != 600 || b[599] != byte(599*7%251) {
+				t.Errorf("truncated file holds %d bytes", len(b))
+			}
+			if b, _ := fs.ReadFile(f, "sparse.dat"); len(b) != 5000 || !bytes.Equal(b[1000:], make([]byte, 4000)) {
+				t.Errorf("extended sparse file holds %d bytes", len(b))
+			}
+			b, _ := fs.ReadFile(f, "ReadMe.txt")
+			if len(b) != 1001 || string(b[:13]) != "hello, exfat\n" || !bytes.Equal(b[13:1000], make([]byte, 987)) || b[1000] != '!' {
+				t.Errorf("extended file holds %q", b)
+			}
+			b, _ = fs.ReadFile(f, "fragmented.bin")
+			if i := img.clusterSize - 2; string(b[i:i+4]) != "xyzw" || b[i-1] != byte((i-1)*13%253) || b[i+4] != byte((i+4)*13%253) {
+				t.Errorf("overwritten file holds %q", b[i-1:i+5])
+			}
+			for _, gone := range []string{"readonly.txt", "Docs/sub", "Many/file00.dat"} {
+				if _, err := f.Stat(gone); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("%s: %v", gone, err)
+				}
+			}
+
+			// Removing everything that was added gives the space back.
+			f, _ = OpenWritable(dev)
+			free := f.Free()
+			for _, name := range []string{"Docs/New File.log", "new/deeper/moved.txt", "new/sub/empty", "new/sub", "new/deeper", "new"} {
+				if err := f.Remove(name); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if f.Free() <= free {
+				t.Errorf("free space went from %d to %d", free, f.Free())
+			}
+			checkAllocation(t, f)
+		})
+	}
+}
+
+func TestContiguousAllocation(t *testing.T) {
+	f, _ := openImage(t, "exfat512.img.gz")
+	stat := func(name string) stream {
+		t.Helper()
+		e, _, err := f.lookup("", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e.stream
+	}
+	cluster := make([]byte, f.ClusterSize())
+
+	// A file that can grow in place stays without a FAT chain.
+	a, _ := f.Create("a")
+	a.Write(cluster)
+	a.Write(cluster)
+	if s := stat("a"); !s.noFATChain || s.size != 1024 {
+		t.Errorf("a: %+v", s)
+	}
+	// Once the next cluster is taken, it needs one.
+	b, _ := f.Create("b")
+	b.Write(cluster)
+	a.Write([]byte("more"))
+	if s := stat("a"); s.noFATChain {
+		t.Errorf("a after b: %+v", s)
+	}
+	if s := stat("b"); !s.noFATChain {
+		t.Errorf("b: %+v", s)
+	}
+	a.Seek(0, 0)
+	buf := make([]byte, 2000)
+	if n, _ := a.Read(buf); n != 1028 || string(buf[1024:n]) != "more" {
+		t.Errorf("a holds %d bytes", n)
+	}
+	// Truncating to nothing frees all clusters.
+	free := f.Free()
+	if err := a.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	if s := stat("a"); s.first != 0 || f.Free() != free+3*512 {
+		t.Errorf("a: %+v, free %d", s, f.Free())
+	}
+	a.Close()
+	b.Close()
+	checkAllocation(t, f)
+}
+
+func TestDirectoryGrows(t *testing.T) {
+	f, _ := openImage(t, "exfat512.img.gz")
+	if err := f.Mkdir("big"); err != nil {
+		t.Fatal(err)
+	}
+	// 512-byte clusters hold 16 entries; each file takes four.
+	for _, dir := range []string{"big/", ""} {
+		for i := range 40 {
+			file, err := f.Create(fmt.Sprintf("%sa file with a long name %03d", dir, i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+		}
+	}
+	entries, err := f.ReadDir("big")
+	if err != nil || len(entries) != 40 {
+		t.Fatalf("%d entries, %v", len(entries), err)
+	}
+	if info, _ := f.Stat("big"); info.Size() != 10*512 {
+		t.Errorf("directory size %d", info.Size())
+	}
+	checkAllocation(t, f)
+}
+
+func TestNoSpace(t *testing.T) {
+	f, _ := openImage(t, "exfat512.img.gz")
+	file, _ := f.Create("huge")
+	_, err := file.Write(make([]byte, 2<<20))
+	if !errors.Is(err, ErrNoSpace) {
+		t.Errorf("err = %v", err)
+	}
+	checkAllocation(t, f)
+}
+
+func TestWriteErrors(t *testing.T) {
+	f, dev := openImage(t, "exfat4k.img.gz")
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{f.Mkdir("Docs"), fs.ErrExist},
+		{f.Mkdir("nowhere/dir"), fs.ErrNotExist},
+		{f.Mkdir("bad:name"), ErrInvalidName},
+		{f.Remove("."), fs.ErrInvalid},
+		{f.Rename("Docs", "Docs/sub/Docs"), nil},
+		{f.Rename("README.TXT", "Many"), fs.ErrExist},
+	}
+	for i, tt := range tests {
+		if tt.err == nil || tt.want != nil && !errors.Is(tt.err, tt.want) {
+			t.Errorf("%d: err = %v, want %v", i, tt.err, tt.want)
+		}
+	}
+	if _, err := f.OpenFile("README.TXT", os.O_CREATE|os.O_EXCL|os.O_WRONLY); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("exclusive create: %v", err)
+	}
+	if _, err := f.OpenFile("Docs", os.O_WRONLY); err == nil {
+		t.Error("directory opened for writing")
+	}
+	file, _ := f.OpenFile("README.TXT", os.O_RDONLY)
+	if _, err := file.Write([]byte("x")); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("write to read-only file: %v", err)
+	}
+
+	ro, err := Open(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ro.Create("x"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("create on read-only file system: %v", err)
+	}
+	if err := ro.Rename("README.TXT", "x"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("rename on read-only file system: %v", err)
+	}
+	checkAllocation(t, f)
+}
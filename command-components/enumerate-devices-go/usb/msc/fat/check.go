@@ -0,0 +1,200 @@
+package fat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Problem is an inconsistency found by Check.
+type Problem struct {
+	Path   string // of the file or directory concerned, empty for the volume
+	Detail string
+}
+
+func (p Problem) String() string {
+	if p.Path == "" {
+		return p.Detail
+	}
+	return p.Path + ": " + p.Detail
+}
+
+// checker collects the problems and which file owns each cluster.
+type checker struct {
+	f        *FS
+	problems []Problem
+	owner    map[uint32]string
+}
+
+func (c *checker) report(path, format string, args ...any) {
+	c.problems = append(c.problems, Problem{Path: path, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Check verifies the consistency of the file system, like fsck.fat -n: that
+// the copies of the FAT agree, that every cluster chain is valid, belongs to
+// one file only and matches the file size, that directories are well
+// formed, that no allocated cluster is lost, that the FSInfo free count is
+// right and that the backup boot sector of FAT32 matches the boot sector.
+// It changes nothing. The error is only for failing reads.
+func (f *FS) Check() ([]Problem, error) {
+	c := &checker{f: f, owner: make(map[uint32]string)}
+
+	copyBuf := make([]byte, f.fatSize)
+	for i := 1; i < f.numFATs; i++ {
+		if _, err := f.dev.ReadAt(copyBuf, f.fatOffset+int64(i)*f.fatSize); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(copyBuf, f.fat) {
+			c.report("", "FAT %d differs from FAT 0", i)
+		}
+	}
+
+	if f.backupBoot != 0 {
+		boot, backup := make([]byte, 512), make([]byte, 512)
+		if _, err := f.dev.ReadAt(boot, 0); err != nil {
+			return nil, err
+		}
+		if _, err := f.dev.ReadAt(backup, f.backupBoot); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(boot, backup) {
+			c.report("", "backup boot sector differs from the boot sector")
+		}
+	}
+
+	if f.typ == FAT32 && c.claim("/", f.rootCluster) < 0 {
+		return c.problems, nil
+	}
+	if err := c.dir("/", f.rootDirCluster(), 0); err != nil {
+		return nil, err
+	}
+
+	lost := 0
+	for cl := uint32(2); cl < f.clusters+2; cl++ {
+		if v := f.entry(cl); v != 0 && v != f.eoc()-1 && c.owner[cl] == "" {
+			lost++
+		}
+	}
+	if lost > 0 {
+		c.report("", "%d lost clusters", lost)
+	}
+	if f.fsInfo != 0 {
+		if info, err := f.readFSInfo(); err != nil {
+			c.report("", "%v", err)
+		} else if info.free != 0xffffffff && info.free != f.free {
+			c.report("", "FSInfo free count %d, but %d clusters are free", info.free, f.free)
+		}
+	}
+	return c.problems, nil
+}
+
+// claim follows the chain starting at first for the file at name and
+// returns its length, or -1 if the chain is broken.
+func (c *checker) claim(name string, first uint32) int {
+	f := c.f
+	n := 0
+	for cl := first; ; n++ {
+		if !f.validCluster(cl) {
+			c.report(name, "invalid cluster %d", cl)
+			return -1
+		}
+		if other := c.owner[cl]; other != "" {
+			if other == name {
+				c.report(name, "cluster chain loops at cluster %d", cl)
+			} else {
+				c.report(name, "cross-linked with %s at cluster %d", other, cl)
+			}
+			return -1
+		}
+		c.owner[cl] = name
+		next := f.entry(cl)
+		switch {
+		case next >= f.eoc():
+			return n + 1
+		case next == 0:
+			c.report(name, "cluster chain runs into free cluster after cluster %d", cl)
+			return -1
+		case next == f.eoc()-1:
+			c.report(name, "cluster chain runs into bad cluster after cluster %d", cl)
+			return -1
+		}
+		cl = next
+	}
+}
+
+// dir checks the directory at cluster and everything below it. parent is
+// the cluster its .. entry must point to.
+func (c *checker) dir(name string, cluster, parent uint32) error {
+	f := c.f
+	d, err := f.readDir(cluster)
+	if errors.Is(err, ErrCorrupt) {
+		return nil // reported by claim
+	} else if err != nil {
+		return err
+	}
+	if len(d.orphans) > 0 {
+		c.report(name, "%d orphaned long file name entries", len(d.orphans))
+	}
+	if cluster != 0 && cluster != f.rootCluster {
+		c.dots(name, d, cluster, parent)
+	}
+
+	seen := make(map[string]bool)
+	for _, e := range d.entries {
+		p := path.Join(name, e.name)
+		if seen[strings.ToUpper(e.name)] {
+			c.report(p, "duplicate name")
+		}
+		seen[strings.ToUpper(e.name)] = true
+
+		if e.cluster == 0 {
+			switch {
+			case e.isDir():
+				c.report(p, "directory without clusters")
+			case e.size > 0:
+				c.report(p, "size %d without clusters", e.size)
+			}
+			continue
+		}
+		n := c.claim(p, e.cluster)
+		if n < 0 {
+			continue
+		}
+		if e.isDir() {
+			if err := c.dir(p, e.cluster, cluster); err != nil {
+				return err
+			}
+			continue
+		}
+		cs := uint32(f.clusterSize)
+		if want := int((uint64(e.size) + uint64(cs) - 1) / uint64(cs)); n != want {
+			c.report(p, "size %d needs %d clusters, but the chain has %d", e.size, want, n)
+		}
+	}
+	return nil
+}
+
+// dots checks the . and .. entries of a subdirectory.
+func (c *checker) dots(name string, d *dirBuf, cluster, parent uint32) {
+	if parent == c.f.rootCluster {
+		parent = 0
+	}
+	for i, want := range []struct {
+		name    string
+		cluster uint32
+	}{{".", cluster}, {"..", parent}} {
+		if i >= d.slots() {
+			break
+		}
+		e := parseShort(d.slot(i))
+		if e.short != dotName(want.name) || !e.isDir() {
+			c.report(name, "missing %s entry", want.name)
+			continue
+		}
+		if e.cluster != want.cluster {
+			c.report(name, "%s points to cluster %d instead of %d", want.name, e.cluster, want.cluster)
+		}
+	}
+}
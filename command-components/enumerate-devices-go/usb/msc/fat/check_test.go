@@ -0,0 +1,106 @@
+package fat
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(f *FS)
+		want    []string
+	}{
+		{
+			"cross-link",
+			func(f *FS) {
+				readme, _, _ := f.lookup("", "README.TXT")
+				lower, _, _ := f.lookup("", "lower.txt")
+				f.setEntry(readme.cluster, lower.cluster)
+			},
+			[]string{"/README.TXT: size 11 needs 1 clusters, but the chain has 2", "/lower.txt: cross-linked with /README.TXT"},
+		},
+		{
+			"lost and wrong size",
+			func(f *FS) {
+				e, _, _ := f.lookup("", "A long file name.txt")
+				chain, _ := f.chain(e.cluster)
+				f.setEntry(chain[1], 0x0fffffff)
+			},
+			[]string{"size 5000 needs 3 clusters, but the chain has 2", "1 lost clusters"},
+		},
+		{
+			"free cluster in chain",
+			func(f *FS) {
+				e, _, _ := f.lookup("", "A long file name.txt")
+				chain, _ := f.chain(e.cluster)
+				f.setEntry(chain[len(chain)-1], 0)
+			},
+			[]string{"runs into free cluster"},
+		},
+		{
+			"bad dot dot",
+			func(f *FS) {
+				d, _, _ := f.lookup("", "Docs/sub")
+				sub, _ := f.readDir(d.cluster)
+				sub.setDotDot(d.cluster)
+			},
+			[]string{"/Docs/sub: .. points to cluster"},
+		},
+		{
+			"orphaned long name",
+			func(f *FS) {
+				e, _, _ := f.lookup("", "A long file name.txt")
+				f.w.WriteAt([]byte{slotFree}, e.off)
+			},
+			[]string{"/: 2 orphaned long file name entries", "3 lost clusters"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, dev := openImage(t, "fat16.img.gz")
+			tt.corrupt(f)
+			if err := f.commit(); err != nil {
+				t.Fatal(err)
+			}
+			f, _ = Open(dev)
+			problems, err := f.Check()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, p := range problems {
+				got = append(got, p.String())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("problems %q", got)
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(got[i], want) {
+					t.Errorf("problem %q, want %q", got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckFATCopiesAndFSInfo(t *testing.T) {
+	f, dev := openImage(t, "fat32.img.gz")
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, 12345)
+	dev.WriteAt(b, f.fsInfo+488)
+	dev.WriteAt([]byte{0xff, 0xff, 0xff, 0x0f}, f.fatOffset+f.fatSize+4*1000)
+	problems, _ := f.Check()
+	if len(problems) != 2 || problems[0].Detail != "FAT 1 differs from FAT 0" || !strings.HasPrefix(problems[1].Detail, "FSInfo free count 12345") {
+		t.Errorf("problems %v", problems)
+	}
+
+	// Any allocation corrects the FSInfo free count.
+	file, _ := f.Create("x")
+	file.Write([]byte("x"))
+	file.Close()
+	if problems, _ := f.Check(); len(problems) != 1 {
+		t.Errorf("problems %v", problems)
+	}
+}
@@ -0,0 +1,472 @@
+package fat
+
+import (
+	"encoding/binary"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const dirEntrySize = 32
+
+// Attributes of a directory entry.
+const (
+	attrReadOnly  = 0x01
+	attrHidden    = 0x02
+	attrSystem    = 0x04
+	attrVolumeID  = 0x08
+	attrDirectory = 0x10
+	attrArchive   = 0x20
+	attrLongName  = attrReadOnly | attrHidden | attrSystem | attrVolumeID
+)
+
+// Bits of the reserved byte Windows NT uses for lower case short names.
+const (
+	ntLowerBase = 0x08
+	ntLowerExt  = 0x10
+)
+
+const (
+	slotFree    = 0xe5
+	slotEnd     = 0x00
+	lfnLast     = 0x40
+	lfnChars    = 13
+	maxNameLen  = 255
+	invalidChar = `"*/:<>?\|`
+)
+
+// dirent is a parsed directory entry: a short entry and the long file name
+// entries before it.
+type dirent struct {
+	name    string
+	short   [11]byte
+	attr    uint8
+	ntres   uint8
+	cluster uint32
+	size    uint32
+	created time.Time
+	mtime   time.Time
+
+	first int // slot of the first long name entry, or slot if there are none
+	slot  int // slot of the short entry; -1 for the root directory
+	off   int64
+}
+
+func (e *dirent) isDir() bool {
+	return e.attr&attrDirectory != 0
+}
+
+// dirBuf is a directory read into memory.
+type dirBuf struct {
+	f       *FS
+	cluster uint32 // first cluster, 0 for the fixed root directory
+	data    []byte
+	offsets []int64 // device offset of each cluster, or of the fixed root
+	chunk   int     // bytes per element of offsets
+
+	entries []*dirent
+	orphans []int // slots of long name entries without their short entry
+	volume  int   // slot of the volume label, -1 if there is none
+}
+
+// readDir reads and parses the directory starting at cluster.
+func (f *FS) readDir(cluster uint32) (*dirBuf, error) {
+	d := &dirBuf{f: f, cluster: cluster, volume: -1}
+	if cluster == 0 {
+		d.chunk = f.rootEntries * dirEntrySize
+		d.offsets = []int64{f.rootOffset}
+	} else {
+		chain, err := f.chain(cluster)
+		if err != nil {
+			return nil, err
+		}
+		d.chunk = f.clusterSize
+		for _, c := range chain {
+			d.offsets = append(d.offsets, f.clusterOffset(c))
+		}
+	}
+	d.data = make([]byte, d.chunk*len(d.offsets))
+	for i, off := range d.offsets {
+		if _, err := f.dev.ReadAt(d.data[i*d.chunk:(i+1)*d.chunk], off); err != nil {
+			return nil, err
+		}
+	}
+	d.parse()
+	return d, nil
+}
+
+func (d *dirBuf) slots() int {
+	return len(d.data) / dirEntrySize
+}
+
+func (d *dirBuf) slot(i int) []byte {
+	return d.data[i*dirEntrySize : (i+1)*dirEntrySize]
+}
+
+func (d *dirBuf) slotOffset(i int) int64 {
+	pos := i * dirEntrySize
+	return d.offsets[pos/d.chunk] + int64(pos%d.chunk)
+}
+
+// parse collects the entries, joining the long name entries to the short
+// entry that follows them. A long name whose sequence or checksum does not
+// match is ignored, as the specification demands.
+func (d *dirBuf) parse() {
+	var (
+		lfn      []uint16
+		lfnFirst = -1
+		lfnNext  int // ordinal of the next long name entry expected
+		lfnSum   uint8
+	)
+	orphan := func() {
+		for i := lfnFirst; i < lfnFirst+len(lfn)/lfnChars-lfnNext; i++ {
+			d.orphans = append(d.orphans, i)
+		}
+		lfn, lfnFirst, lfnNext = nil, -1, 0
+	}
+	for i := range d.slots() {
+		s := d.slot(i)
+		if s[0] == slotEnd {
+			break
+		}
+		if s[0] == slotFree {
+			if lfnFirst >= 0 {
+				orphan()
+			}
+			continue
+		}
+		if s[11]&0x3f == attrLongName {
+			ord := int(s[0] &^ lfnLast)
+			switch {
+			case s[0]&lfnLast != 0 && ord > 0 && ord <= 20:
+				if lfnFirst >= 0 {
+					orphan()
+				}
+				lfn, lfnFirst, lfnSum = make([]uint16, ord*lfnChars), i, s[13]
+			case lfnFirst < 0 || ord == 0 || ord != lfnNext || s[13] != lfnSum:
+				if lfnFirst >= 0 {
+					orphan()
+				}
+				d.orphans = append(d.orphans, i)
+				continue
+			}
+			lfnNext = ord - 1
+			copy(lfn[(ord-1)*lfnChars:], lfnUnits(s))
+			continue
+		}
+
+		e := parseShort(s)
+		e.first, e.slot, e.off = i, i, d.slotOffset(i)
+		if lfnFirst >= 0 {
+			if lfnNext == 0 && lfnSum == checksum(e.short) {
+				e.first = lfnFirst
+				e.name = decodeLFN(lfn)
+				lfn, lfnFirst = nil, -1
+			} else {
+				orphan()
+			}
+		}
+		switch {
+		case s[11]&attrVolumeID != 0:
+			if d.volume < 0 {
+				d.volume = i
+			}
+		case e.name == "." || e.name == "..":
+		default:
+			d.entries = append(d.entries, e)
+		}
+	}
+	if lfnFirst >= 0 {
+		orphan()
+	}
+}
+
+// label returns the volume label of a root directory.
+func (d *dirBuf) label() (string, bool) {
+	if d.volume < 0 {
+		return "", false
+	}
+	return strings.TrimRight(string(d.slot(d.volume)[:11]), " "), true
+}
+
+// find returns the entry called name, compared without regard to case like
+// FAT does, or nil.
+func (d *dirBuf) find(name string) *dirent {
+	for _, e := range d.entries {
+		if strings.EqualFold(e.name, name) || strings.EqualFold(e.shortName(), name) {
+			return e
+		}
+	}
+	return nil
+}
+
+func lfnUnits(s []byte) []uint16 {
+	units := make([]uint16, 0, lfnChars)
+	for _, r := range [][2]int{{1, 11}, {14, 26}, {28, 32}} {
+		for j := r[0]; j < r[1]; j += 2 {
+			units = append(units, binary.LittleEndian.Uint16(s[j:]))
+		}
+	}
+	return units
+}
+
+func decodeLFN(units []uint16) string {
+	for i, u := range units {
+		if u == 0 {
+			units = units[:i]
+			break
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+func parseShort(s []byte) *dirent {
+	e := &dirent{
+		attr:    s[11],
+		ntres:   s[12],
+		cluster: uint32(binary.LittleEndian.Uint16(s[20:]))<<16 | uint32(binary.LittleEndian.Uint16(s[26:])),
+		size:    binary.LittleEndian.Uint32(s[28:]),
+		created: decodeTime(binary.LittleEndian.Uint16(s[16:]), binary.LittleEndian.Uint16(s[14:]), s[13]),
+		mtime:   decodeTime(binary.LittleEndian.Uint16(s[24:]), binary.LittleEndian.Uint16(s[22:]), 0),
+	}
+	copy(e.short[:], s)
+	e.name = e.shortName()
+	return e
+}
+
+// shortName returns the 8.3 name for display, in lower case where the NT
+// case bits ask for it.
+func (e *dirent) shortName() string {
+	short := e.short
+	if short[0] == 0x05 {
+		short[0] = slotFree
+	}
+	base := latin1(strings.TrimRight(string(short[:8]), " "))
+	ext := latin1(strings.TrimRight(string(short[8:]), " "))
+	if e.ntres&ntLowerBase != 0 {
+		base = strings.ToLower(base)
+	}
+	if e.ntres&ntLowerExt != 0 {
+		ext = strings.ToLower(ext)
+	}
+	if ext == "" {
+		return base
+	}
+	return base + "." + ext
+}
+
+// latin1 decodes the OEM code page of short names as Latin-1.
+func latin1(s string) string {
+	if strings.IndexFunc(s, func(r rune) bool { return r >= 0x80 }) < 0 {
+		return s
+	}
+	r := make([]rune, len(s))
+	for i := range len(s) {
+		r[i] = rune(s[i])
+	}
+	return string(r)
+}
+
+// checksum is the checksum of a short name stored in its long name entries.
+func checksum(short [11]byte) uint8 {
+	var sum uint8
+	for _, b := range short {
+		sum = (sum&1)<<7 + sum>>1 + b
+	}
+	return sum
+}
+
+func decodeTime(date, clock uint16, tenths uint8) time.Time {
+	if date == 0 {
+		return time.Time{}
+	}
+	return time.Date(
+		1980+int(date>>9), time.Month(date>>5&0x0f), int(date&0x1f),
+		int(clock>>11), int(clock>>5&0x3f), int(clock&0x1f)*2+int(tenths/100),
+		int(tenths%100)*10*int(time.Millisecond), time.Local)
+}
+
+func encodeTime(t time.Time) (date, clock uint16, tenths uint8) {
+	if t.Year() < 1980 {
+		return 0x21, 0, 0 // 1980-01-01
+	}
+	date = uint16(t.Year()-1980)<<9 | uint16(t.Month())<<5 | uint16(t.Day())
+	clock = uint16(t.Hour())<<11 | uint16(t.Minute())<<5 | uint16(t.Second()/2)
+	tenths = uint8(t.Second()%2*100 + t.Nanosecond()/int(10*time.Millisecond))
+	return date, clock, tenths
+}
+
+// marshal encodes the short entry.
+func (e *dirent) marshal() []byte {
+	s := make([]byte, dirEntrySize)
+	copy(s, e.short[:])
+	s[11], s[12] = e.attr, e.ntres
+	date, clock, tenths := encodeTime(e.created)
+	s[13] = tenths
+	binary.LittleEndian.PutUint16(s[14:], clock)
+	binary.LittleEndian.PutUint16(s[16:], date)
+	binary.LittleEndian.PutUint16(s[18:], date) // last access
+	binary.LittleEndian.PutUint16(s[20:], uint16(e.cluster>>16))
+	date, clock, _ = encodeTime(e.mtime)
+	binary.LittleEndian.PutUint16(s[22:], clock)
+	binary.LittleEndian.PutUint16(s[24:], date)
+	binary.LittleEndian.PutUint16(s[26:], uint16(e.cluster))
+	binary.LittleEndian.PutUint32(s[28:], e.size)
+	return s
+}
+
+// lfnSlots encodes name as long name entries, in the order they are
+// stored.
+func lfnSlots(name string, sum uint8) [][]byte {
+	units := utf16.Encode([]rune(name))
+	n := (len(units) + lfnChars - 1) / lfnChars
+	if len(units)%lfnChars != 0 {
+		units = append(units, 0)
+	}
+	for len(units) < n*lfnChars {
+		units = append(units, 0xffff)
+	}
+	slots := make([][]byte, n)
+	for i := range n {
+		s := make([]byte, dirEntrySize)
+		s[0] = uint8(n - i)
+		if i == 0 {
+			s[0] |= lfnLast
+		}
+		s[11], s[13] = attrLongName, sum
+		part := units[(n-1-i)*lfnChars:]
+		k := 0
+		for _, r := range [][2]int{{1, 11}, {14, 26}, {28, 32}} {
+			for j := r[0]; j < r[1]; j += 2 {
+				binary.LittleEndian.PutUint16(s[j:], part[k])
+				k++
+			}
+		}
+		slots[i] = s
+	}
+	return slots
+}
+
+// validName checks a name for a new entry.
+func validName(name string) bool {
+	if name == "" || name == "." || name == ".." || len(utf16.Encode([]rune(name))) > maxNameLen {
+		return false
+	}
+	if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return false
+	}
+	return strings.IndexFunc(name, func(r rune) bool {
+		return r < 0x20 || strings.ContainsRune(invalidChar, r)
+	}) < 0
+}
+
+// shortChar reports whether r may appear in a short name as it is.
+func shortChar(r rune) bool {
+	return r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("$%'-_@~`!(){}^#&", r)
+}
+
+// exactShort returns the short entry name for name and the NT case bits if
+// name fits 8.3 so that no long name is needed.
+func exactShort(name string) (short [11]byte, ntres uint8, ok bool) {
+	base, ext, _ := strings.Cut(name, ".")
+	if len(base) < 1 || len(base) > 8 || len(ext) > 3 || strings.Contains(ext, ".") {
+		return short, 0, false
+	}
+	part := func(s string, lowerBit uint8) bool {
+		upper := strings.ToUpper(s)
+		switch s {
+		case upper:
+		case strings.ToLower(s):
+			ntres |= lowerBit
+		default:
+			return false // mixed case needs a long name
+		}
+		for _, r := range upper {
+			if !shortChar(r) {
+				return false
+			}
+		}
+		return true
+	}
+	if !part(base, ntLowerBase) || !part(ext, ntLowerExt) {
+		return short, 0, false
+	}
+	copy(short[:], strings.ToUpper(base)+strings.Repeat(" ", 8-len(base))+strings.ToUpper(ext)+strings.Repeat(" ", 3-len(ext)))
+	return short, ntres, true
+}
+
+// aliasShort generates a short name with a numeric tail for name that is
+// not used in d, as Windows does: the basis is the upper case name without
+// invalid characters, followed by ~1, ~2, ...
+func (d *dirBuf) aliasShort(name string) [11]byte {
+	clean := func(s string, n int) string {
+		var b strings.Builder
+		for _, r := range strings.ToUpper(s) {
+			if b.Len() == n {
+				break
+			}
+			switch {
+			case r == ' ' || r == '.':
+			case shortChar(r):
+				b.WriteRune(r)
+			default:
+				b.WriteByte('_')
+			}
+		}
+		return b.String()
+	}
+	base, ext := strings.TrimLeft(name, "."), ""
+	if i := strings.LastIndex(base, "."); i >= 0 {
+		base, ext = base[:i], base[i+1:]
+	}
+	base, ext = clean(base, 8), clean(ext, 3)
+	used := make(map[[11]byte]bool)
+	for _, e := range d.entries {
+		used[e.short] = true
+	}
+	for n := 1; ; n++ {
+		tail := "~" + strconv.Itoa(n)
+		b := base
+		if len(b)+len(tail) > 8 {
+			b = b[:8-len(tail)]
+		}
+		var short [11]byte
+		copy(short[:], b+tail+strings.Repeat(" ", 8-len(b)-len(tail))+ext+strings.Repeat(" ", 3-len(ext)))
+		if !used[short] {
+			return short
+		}
+	}
+}
+
+// info returns the entry as a FileInfo called name.
+func (e *dirent) info(name string) *fileInfo {
+	return &fileInfo{name: name, e: *e}
+}
+
+// fileInfo implements fs.FileInfo and fs.DirEntry.
+type fileInfo struct {
+	name string
+	e    dirent
+}
+
+func (i *fileInfo) Name() string               { return i.name }
+func (i *fileInfo) Size() int64                { return int64(i.e.size) }
+func (i *fileInfo) ModTime() time.Time         { return i.e.mtime }
+func (i *fileInfo) IsDir() bool                { return i.e.isDir() }
+func (i *fileInfo) Sys() any                   { return nil }
+func (i *fileInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i *fileInfo) Info() (fs.FileInfo, error) { return i, nil }
+func (i *fileInfo) String() string             { return fs.FormatFileInfo(i) }
+
+func (i *fileInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(0o666)
+	if i.e.isDir() {
+		mode = fs.ModeDir | 0o777
+	}
+	if i.e.attr&attrReadOnly != 0 {
+		mode &^= 0o222
+	}
+	return mode
+}
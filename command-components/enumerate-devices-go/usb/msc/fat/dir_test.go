@@ -0,0 +1,105 @@
+package fat
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExactShort(t *testing.T) {
+	tests := []struct {
+		name  string
+		short string
+		ntres uint8
+		ok    bool
+	}{
+		{"README.TXT", "README  TXT", 0, true},
+		{"readme.txt", "README  TXT", ntLowerBase | ntLowerExt, true},
+		{"README.txt", "README  TXT", ntLowerExt, true},
+		{"MAKEFILE", "MAKEFILE   ", 0, true},
+		{"Readme.txt", "", 0, false},
+		{"longername.txt", "", 0, false},
+		{"a.b.c", "", 0, false},
+		{"file.html", "", 0, false},
+		{"with space", "", 0, false},
+		{".profile", "", 0, false},
+	}
+	for _, tt := range tests {
+		short, ntres, ok := exactShort(tt.name)
+		if ok != tt.ok || ok && (string(short[:]) != tt.short || ntres != tt.ntres) {
+			t.Errorf("%q: %q, %#x, %v", tt.name, short, ntres, ok)
+		}
+	}
+}
+
+func TestAliasShort(t *testing.T) {
+	d := &dirBuf{}
+	tests := []struct{ name, short string }{
+		{"A long file name.txt", "ALONGF~1TXT"},
+		{".profile", "PROFIL~1   "},
+		{"Résumé.html", "R_SUM_~1HTM"},
+		{"a+b.tar.gz", "A_BTAR~1GZ "},
+	}
+	for _, tt := range tests {
+		if short := d.aliasShort(tt.name); string(short[:]) != tt.short {
+			t.Errorf("%q: %q, want %q", tt.name, short, tt.short)
+		}
+	}
+
+	for n := 1; n <= 10; n++ {
+		short := d.aliasShort("A long file name.txt")
+		d.entries = append(d.entries, &dirent{short: short})
+		if n == 10 && string(short[:]) != "ALONG~10TXT" {
+			t.Errorf("tenth alias %q", short)
+		}
+	}
+}
+
+func TestLongNameRoundTrip(t *testing.T) {
+	for _, name := range []string{"a", "exactly13char", "exactly 14 chr", "日本語のファイル名.txt", strings.Repeat("x", 255)} {
+		d := &dirBuf{volume: -1, chunk: 512 * 32, offsets: []int64{0}}
+		short := d.aliasShort(name)
+		var data []byte
+		for _, s := range lfnSlots(name, checksum(short)) {
+			data = append(data, s...)
+		}
+		data = append(data, (&dirent{short: short, attr: attrArchive}).marshal()...)
+		d.data = append(data, make([]byte, 32)...)
+		d.parse()
+		if len(d.entries) != 1 || d.entries[0].name != name || len(d.orphans) != 0 {
+			t.Errorf("%q: entries %v, orphans %v", name, d.entries, d.orphans)
+		}
+	}
+}
+
+func TestParseOrphans(t *testing.T) {
+	short := [11]byte([]byte("LONGNA~1TXT"))
+	slots := lfnSlots("long name.txt and more", checksum(short))
+	var data []byte
+	// A long name without its first part, then one with a wrong checksum.
+	data = append(data, slots[1]...)
+	data = append(data, (&dirent{short: short}).marshal()...)
+	for _, s := range lfnSlots("other name", 0) {
+		data = append(data, s...)
+	}
+	data = append(data, (&dirent{short: [11]byte([]byte("OTHERN~1   "))}).marshal()...)
+	d := &dirBuf{volume: -1, data: append(data, make([]byte, 32)...), chunk: 512, offsets: []int64{0}}
+	d.parse()
+	if len(d.entries) != 2 || d.entries[0].name != "LONGNA~1.TXT" || d.entries[1].name != "OTHERN~1" {
+		t.Errorf("entries %v", d.entries)
+	}
+	if len(d.orphans) != 2 {
+		t.Errorf("orphans %v", d.orphans)
+	}
+}
+
+func TestTimeEncoding(t *testing.T) {
+	want := time.Date(2023, 12, 31, 23, 59, 59, 990*int(time.Millisecond), time.Local)
+	date, clock, tenths := encodeTime(want)
+	if got := decodeTime(date, clock, tenths); !got.Equal(want) {
+		t.Errorf("got %v", got)
+	}
+	if date, _, _ := encodeTime(time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)); date != 0x21 {
+		t.Errorf("date before 1980 encoded as %#x", date)
+	}
+}
@@ -0,0 +1,334 @@
+// Package fat implements the FAT12, FAT16 and FAT32 file systems on a block
+// device, such as an msc.BlockDevice or an image file.
+//
+// FS implements fs.FS, fs.ReadDirFS and fs.StatFS with long file names. If
+// the device is also an io.WriterAt, files and directories can be created,
+// extended, truncated, renamed and removed. Every change is written through
+// to the device before the call returns; a cached device still has to be
+// synced. Check verifies the consistency of the file system.
+package fat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Type is the FAT variant, named after the width of its table entries.
+type Type int
+
+const (
+	FAT12 Type = 12
+	FAT16 Type = 16
+	FAT32 Type = 32
+)
+
+func (t Type) String() string {
+	return fmt.Sprintf("FAT%d", int(t))
+}
+
+var (
+	ErrNotFAT      = errors.New("fat: not a FAT file system")
+	ErrCorrupt     = errors.New("fat: file system is corrupt")
+	ErrReadOnly    = errors.New("fat: device is read-only")
+	ErrNoSpace     = errors.New("fat: no space left on device")
+	ErrRootFull    = errors.New("fat: root directory is full")
+	ErrNotEmpty    = errors.New("fat: directory not empty")
+	ErrInvalidName = errors.New("fat: invalid file name")
+)
+
+// FS is a FAT file system. It is not safe for concurrent use.
+type FS struct {
+	dev io.ReaderAt
+	w   io.WriterAt // nil if the device is read-only
+
+	typ         Type
+	sectorSize  int
+	clusterSize int
+	numFATs     int
+	fatOffset   int64 // of the first FAT
+	fatSize     int64 // of each FAT, in bytes
+	rootOffset  int64 // of the fixed root directory of FAT12 and FAT16
+	rootEntries int
+	rootCluster uint32 // of FAT32
+	dataOffset  int64
+	clusters    uint32 // valid cluster numbers are 2 to clusters+1
+	fsInfo      int64  // offset of the FSInfo sector, 0 if there is none
+	backupBoot  int64  // offset of the backup boot sector, 0 if there is none
+	label       string
+	serial      uint32
+
+	fat      []byte // the first FAT
+	dirty    map[int64]bool
+	free     uint32
+	nextFree uint32
+
+	// Now returns the time stamps for changes. Defaults to time.Now.
+	Now func() time.Time
+}
+
+var _ interface {
+	fs.ReadDirFS
+	fs.StatFS
+} = (*FS)(nil)
+
+// Open reads the boot sector and the FAT of the file system on dev. The
+// file system can be changed if dev is also an io.WriterAt.
+func Open(dev io.ReaderAt) (*FS, error) {
+	b := make([]byte, 512)
+	if _, err := dev.ReadAt(b, 0); err != nil {
+		return nil, err
+	}
+	if b[510] != 0x55 || b[511] != 0xaa {
+		return nil, ErrNotFAT
+	}
+	f := &FS{dev: dev, dirty: make(map[int64]bool), Now: time.Now}
+	f.w, _ = dev.(io.WriterAt)
+
+	f.sectorSize = int(binary.LittleEndian.Uint16(b[11:]))
+	sectorsPerCluster := int(b[13])
+	reserved := int64(binary.LittleEndian.Uint16(b[14:]))
+	f.numFATs = int(b[16])
+	f.rootEntries = int(binary.LittleEndian.Uint16(b[17:]))
+	total := int64(binary.LittleEndian.Uint16(b[19:]))
+	if total == 0 {
+		total = int64(binary.LittleEndian.Uint32(b[32:]))
+	}
+	fatSectors := int64(binary.LittleEndian.Uint16(b[22:]))
+	if fatSectors == 0 {
+		fatSectors = int64(binary.LittleEndian.Uint32(b[36:]))
+	}
+	switch f.sectorSize {
+	case 512, 1024, 2048, 4096:
+	default:
+		return nil, ErrNotFAT
+	}
+	if sectorsPerCluster == 0 || sectorsPerCluster&(sectorsPerCluster-1) != 0 ||
+		reserved == 0 || f.numFATs == 0 || fatSectors == 0 {
+		return nil, ErrNotFAT
+	}
+
+	ss := int64(f.sectorSize)
+	f.clusterSize = f.sectorSize * sectorsPerCluster
+	f.fatOffset = reserved * ss
+	f.fatSize = fatSectors * ss
+	rootSectors := (int64(f.rootEntries)*dirEntrySize + ss - 1) / ss
+	f.rootOffset = f.fatOffset + int64(f.numFATs)*f.fatSize
+	f.dataOffset = f.rootOffset + rootSectors*ss
+	dataSectors := total - f.dataOffset/ss
+	if dataSectors <= 0 {
+		return nil, ErrNotFAT
+	}
+	f.clusters = uint32(dataSectors / int64(sectorsPerCluster))
+
+	ext := b[36:] // extended boot record of FAT12 and FAT16
+	switch {
+	case f.clusters < 4085:
+		f.typ = FAT12
+	case f.clusters < 65525:
+		f.typ = FAT16
+	default:
+		f.typ = FAT32
+		ext = b[64:]
+		if f.rootEntries != 0 {
+			return nil, ErrNotFAT
+		}
+		f.rootCluster = binary.LittleEndian.Uint32(b[44:])
+		if info := int64(binary.LittleEndian.Uint16(b[48:])); info != 0 && info != 0xffff && info < reserved {
+			f.fsInfo = info * ss
+		}
+		if backup := int64(binary.LittleEndian.Uint16(b[50:])); backup != 0 && backup != 0xffff && backup < reserved {
+			f.backupBoot = backup * ss
+		}
+	}
+	if f.typ != FAT32 && f.rootEntries == 0 {
+		return nil, ErrNotFAT
+	}
+	// The entry of the last cluster must lie wholly inside the FAT. A
+	// FAT12 entry is read as the two bytes at c+c/2.
+	last := int64(f.clusters + 1)
+	end := last*int64(f.typ)/8 + int64(f.typ)/8
+	if f.typ == FAT12 {
+		end = last + last/2 + 2
+	}
+	if end > f.fatSize {
+		return nil, fmt.Errorf("%w: FAT of %d bytes is too small for %d clusters", ErrCorrupt, f.fatSize, f.clusters)
+	}
+	if ext[2] == 0x29 {
+		f.serial = binary.LittleEndian.Uint32(ext[3:])
+		f.label = strings.TrimRight(string(ext[7:18]), " ")
+	}
+
+	f.fat = make([]byte, f.fatSize)
+	if _, err := dev.ReadAt(f.fat, f.fatOffset); err != nil {
+		return nil, err
+	}
+	for c := uint32(2); c < f.clusters+2; c++ {
+		if f.entry(c) == 0 {
+			f.free++
+		}
+	}
+	f.nextFree = 2
+	if f.fsInfo != 0 {
+		if info, err := f.readFSInfo(); err == nil && info.nextFree >= 2 && info.nextFree < f.clusters+2 {
+			f.nextFree = info.nextFree
+		}
+	}
+
+	if f.typ == FAT32 && !f.validCluster(f.rootCluster) {
+		return nil, fmt.Errorf("%w: root cluster %d", ErrCorrupt, f.rootCluster)
+	}
+	// The volume label in the root directory takes precedence, like on
+	// Windows.
+	root, err := f.readDir(f.rootDirCluster())
+	if err != nil {
+		return nil, err
+	}
+	if label, ok := root.label(); ok {
+		f.label = label
+	}
+	if f.label == "NO NAME" {
+		f.label = ""
+	}
+	return f, nil
+}
+
+// Type returns the FAT variant.
+func (f *FS) Type() Type {
+	return f.typ
+}
+
+// Label returns the volume label.
+func (f *FS) Label() string {
+	return f.label
+}
+
+// Serial returns the volume serial number.
+func (f *FS) Serial() uint32 {
+	return f.serial
+}
+
+// ClusterSize returns the allocation unit in bytes.
+func (f *FS) ClusterSize() int {
+	return f.clusterSize
+}
+
+// Size returns the size of the data area in bytes.
+func (f *FS) Size() int64 {
+	return int64(f.clusters) * int64(f.clusterSize)
+}
+
+// Free returns the number of bytes in free clusters.
+func (f *FS) Free() int64 {
+	return int64(f.free) * int64(f.clusterSize)
+}
+
+type fsInfo struct {
+	free, nextFree uint32
+}
+
+func (f *FS) readFSInfo() (fsInfo, error) {
+	b := make([]byte, 512)
+	if _, err := f.dev.ReadAt(b, f.fsInfo); err != nil {
+		return fsInfo{}, err
+	}
+	if binary.LittleEndian.Uint32(b) != 0x41615252 || binary.LittleEndian.Uint32(b[484:]) != 0x61417272 {
+		return fsInfo{}, fmt.Errorf("%w: invalid FSInfo signature", ErrCorrupt)
+	}
+	return fsInfo{
+		free:     binary.LittleEndian.Uint32(b[488:]),
+		nextFree: binary.LittleEndian.Uint32(b[492:]),
+	}, nil
+}
+
+// rootDirCluster returns the first cluster of the root directory, 0 for
+// the fixed root directory of FAT12 and FAT16.
+func (f *FS) rootDirCluster() uint32 {
+	return f.rootCluster
+}
+
+// root returns the directory entry standing for the root directory.
+func (f *FS) root() *dirent {
+	return &dirent{name: ".", attr: attrDirectory, cluster: f.rootDirCluster(), slot: -1}
+}
+
+// lookup returns the entry for name and the directory it is in. The root
+// directory has no parent.
+func (f *FS) lookup(op, name string) (*dirent, *dirBuf, error) {
+	if !fs.ValidPath(name) {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	e := f.root()
+	if name == "." {
+		return e, nil, nil
+	}
+	var parent *dirBuf
+	for _, elem := range strings.Split(name, "/") {
+		if !e.isDir() {
+			return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		d, err := f.readDir(e.cluster)
+		if err != nil {
+			return nil, nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		if e = d.find(elem); e == nil {
+			return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		parent = d
+	}
+	return e, parent, nil
+}
+
+// Open opens the named file or directory for reading.
+func (f *FS) Open(name string) (fs.File, error) {
+	file, err := f.OpenFile(name, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Stat returns a FileInfo describing the named file.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	e, _, err := f.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return e.info(path.Base(name)), nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, _, err := f.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.isDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries, err := f.dirEntries(e.cluster)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+func (f *FS) dirEntries(cluster uint32) ([]fs.DirEntry, error) {
+	d, err := f.readDir(cluster)
+	if err != nil {
+		return nil, err
+	}
+	var entries []fs.DirEntry
+	for _, e := range d.entries {
+		entries = append(entries, e.info(e.name))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
@@ -0,0 +1,141 @@
+package fat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// The images in testdata were made like mkfs.fat makes them: a 1.44 MB
+// FAT12 floppy, a 16 MiB FAT16 and a 33 MiB FAT32 volume with 512-byte
+// clusters. Each holds the same files, with long and short names.
+var images = []struct {
+	file string
+	typ  Type
+}{
+	{"fat12.img.gz", FAT12},
+	{"fat16.img.gz", FAT16},
+	{"fat32.img.gz", FAT32},
+}
+
+// openImage copies a test image to a temporary file and opens it.
+func openImage(t *testing.T, name string) (*FS, *os.File) {
+	t.Helper()
+	src, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	zr, err := gzip.NewReader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := os.Create(filepath.Join(t.TempDir(), strings.TrimSuffix(name, ".gz")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { img.Close() })
+	if _, err := io.Copy(img, zr); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, img
+}
+
+// checkClean fails the test if Check finds problems.
+func checkClean(t *testing.T, f *FS) {
+	t.Helper()
+	problems, err := f.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Errorf("check: %s", p)
+	}
+}
+
+func TestReadImages(t *testing.T) {
+	for _, img := range images {
+		t.Run(img.typ.String(), func(t *testing.T) {
+			f, _ := openImage(t, img.file)
+			if f.Type() != img.typ || f.Label() != "TESTVOL" || f.Serial() != 0x1234abcd {
+				t.Errorf("type %v, label %q, serial %#x", f.Type(), f.Label(), f.Serial())
+			}
+			if err := fstest.TestFS(f, "README.TXT", "lower.txt", "A long file name.txt",
+				"Docs/Résumé.txt", "Docs/sub/DEEP.BIN", "MANY/file39.dat"); err != nil {
+				t.Fatal(err)
+			}
+			checkClean(t, f)
+
+			b, err := fs.ReadFile(f, "A long file name.txt")
+			if err != nil || len(b) != 5000 || b[4999] != byte(4999*7%251) {
+				t.Errorf("long file: %d bytes, %v", len(b), err)
+			}
+			// Names match without regard to case, and by their alias.
+			for _, name := range []string{"readme.txt", "ALONGF~1.TXT", "docs/RSUM~1.TXT", "many/FILE07.DAT"} {
+				if _, err := f.Stat(name); err != nil {
+					t.Errorf("%s: %v", name, err)
+				}
+			}
+			if b, _ := fs.ReadFile(f, "docs/résumé.txt"); string(b) != "Résumé\n" {
+				t.Errorf("Résumé.txt holds %q", b)
+			}
+
+			entries, err := f.ReadDir("Docs")
+			if err != nil || len(entries) != 2 || entries[0].Name() != "Résumé.txt" || !entries[1].IsDir() {
+				t.Errorf("Docs: %v, %v", entries, err)
+			}
+			info, _ := f.Stat("README.TXT")
+			if want := time.Date(2024, 3, 14, 15, 9, 26, 0, time.Local); !info.ModTime().Equal(want) {
+				t.Errorf("modification time %v", info.ModTime())
+			}
+			if _, err := f.Open("README.TXT/x"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("file as directory: %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenNotFAT(t *testing.T) {
+	dev := &readOnly{strings.NewReader(strings.Repeat("\x00", 1024))}
+	if _, err := Open(dev); !errors.Is(err, ErrNotFAT) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// A FAT12 volume whose FAT ends in the middle of the entry of its last
+// cluster is corrupt, not a reason to panic.
+func TestOpenShortFAT12(t *testing.T) {
+	src, err := os.Open(filepath.Join("testdata", "fat12.img.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	zr, err := gzip.NewReader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 FAT sectors of 1024 bytes and 700-19 = 681 clusters: the entry of
+	// cluster 682 is the two bytes at 1023.
+	binary.LittleEndian.PutUint16(img[19:], 700)
+	binary.LittleEndian.PutUint16(img[22:], 2)
+	if _, err := Open(bytes.NewReader(img)); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,350 @@
+package fat
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+)
+
+// File is an open file or directory.
+type File struct {
+	f      *FS
+	name   string
+	e      dirent
+	flag   int
+	offset int64
+	chain  []uint32 // nil until needed
+	closed bool
+
+	dir []fs.DirEntry // remaining entries for ReadDir, nil until read
+}
+
+var _ interface {
+	fs.ReadDirFile
+	io.ReaderAt
+	io.WriterAt
+	io.ReadWriteSeeker
+} = (*File)(nil)
+
+// OpenFile opens the named file with flag, a combination of the os.O_*
+// flags: os.O_RDONLY, os.O_WRONLY or os.O_RDWR, optionally with
+// os.O_CREATE, os.O_EXCL, os.O_TRUNC and os.O_APPEND. Directories can only
+// be opened for reading.
+func (f *FS) OpenFile(name string, flag int) (*File, error) {
+	write := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if (write || flag&(os.O_CREATE|os.O_TRUNC) != 0) && f.w == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrReadOnly}
+	}
+	e, _, err := f.lookup("open", name)
+	switch {
+	case err == nil && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case errors.Is(err, fs.ErrNotExist) && flag&os.O_CREATE != 0:
+		if e, err = f.create(name, 0, 0); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	case err != nil:
+		return nil, err
+	}
+	if e.isDir() && (write || flag&os.O_TRUNC != 0) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+	file := &File{f: f, name: name, e: *e, flag: flag}
+	if flag&os.O_TRUNC != 0 && file.e.size > 0 {
+		if err := file.truncate(0); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+	return file, nil
+}
+
+// Create creates or truncates the named file and opens it for reading and
+// writing.
+func (f *FS) Create(name string) (*File, error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+}
+
+// Name returns the name the file was opened with.
+func (file *File) Name() string {
+	return file.name
+}
+
+// Stat returns a FileInfo describing the file.
+func (file *File) Stat() (fs.FileInfo, error) {
+	if file.closed {
+		return nil, file.err("stat", fs.ErrClosed)
+	}
+	return file.e.info(path.Base(file.name)), nil
+}
+
+func (file *File) err(op string, err error) error {
+	return &fs.PathError{Op: op, Path: file.name, Err: err}
+}
+
+func (file *File) clusters() ([]uint32, error) {
+	if file.chain == nil {
+		chain, err := file.f.chain(file.e.cluster)
+		if err != nil {
+			return nil, err
+		}
+		file.chain = chain
+	}
+	return file.chain, nil
+}
+
+// Read reads from the current offset and advances it.
+func (file *File) Read(p []byte) (int, error) {
+	n, err := file.ReadAt(p, file.offset)
+	file.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt reads len(p) bytes at offset off.
+func (file *File) ReadAt(p []byte, off int64) (int, error) {
+	switch {
+	case file.closed:
+		return 0, file.err("read", fs.ErrClosed)
+	case file.e.isDir():
+		return 0, file.err("read", errors.New("is a directory"))
+	case file.flag&os.O_WRONLY != 0:
+		return 0, file.err("read", fs.ErrPermission)
+	case off < 0:
+		return 0, file.err("read", fs.ErrInvalid)
+	case off >= int64(file.e.size):
+		return 0, io.EOF
+	}
+	var eof error
+	if rest := int64(file.e.size) - off; int64(len(p)) > rest {
+		p, eof = p[:rest], io.EOF
+	}
+	chain, err := file.clusters()
+	if err != nil {
+		return 0, file.err("read", err)
+	}
+	cs := int64(file.f.clusterSize)
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		i, start := pos/cs, pos%cs
+		if i >= int64(len(chain)) {
+			return n, file.err("read", fmt.Errorf("%w: file is longer than its cluster chain", ErrCorrupt))
+		}
+		// Read the run of contiguous clusters at once.
+		run := int64(1)
+		for i+run < int64(len(chain)) && chain[i+run] == chain[i]+uint32(run) && run*cs-start < int64(len(p)-n) {
+			run++
+		}
+		length := min(int64(len(p)-n), run*cs-start)
+		m, err := file.f.dev.ReadAt(p[n:n+int(length)], file.f.clusterOffset(chain[i])+start)
+		n += m
+		if err != nil {
+			return n, file.err("read", err)
+		}
+	}
+	return n, eof
+}
+
+// Seek sets the offset for the next Read or Write.
+func (file *File) Seek(offset int64, whence int) (int64, error) {
+	if file.closed {
+		return 0, file.err("seek", fs.ErrClosed)
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += file.offset
+	case io.SeekEnd:
+		offset += int64(file.e.size)
+	default:
+		return 0, file.err("seek", fs.ErrInvalid)
+	}
+	if offset < 0 {
+		return 0, file.err("seek", fs.ErrInvalid)
+	}
+	file.offset = offset
+	return offset, nil
+}
+
+// ReadDir reads the entries of a directory, sorted by name. If n > 0, it
+// returns at most n entries and io.EOF at the end; otherwise it returns all
+// remaining entries.
+func (file *File) ReadDir(n int) ([]fs.DirEntry, error) {
+	if file.closed {
+		return nil, file.err("readdir", fs.ErrClosed)
+	}
+	if !file.e.isDir() {
+		return nil, file.err("readdir", errors.New("not a directory"))
+	}
+	if file.dir == nil {
+		entries, err := file.f.dirEntries(file.e.cluster)
+		if err != nil {
+			return nil, file.err("readdir", err)
+		}
+		file.dir = append(entries, nil)[:len(entries)] // non-nil once read
+	}
+	if n <= 0 || n >= len(file.dir) {
+		entries := file.dir
+		file.dir = file.dir[len(file.dir):]
+		if n > 0 && len(entries) == 0 {
+			return nil, io.EOF
+		}
+		return entries, nil
+	}
+	entries := file.dir[:n]
+	file.dir = file.dir[n:]
+	return entries, nil
+}
+
+// Write writes at the current offset, or at the end of the file if it was
+// opened with os.O_APPEND, and advances the offset.
+func (file *File) Write(p []byte) (int, error) {
+	if file.flag&os.O_APPEND != 0 {
+		file.offset = int64(file.e.size)
+	}
+	n, err := file.WriteAt(p, file.offset)
+	file.offset += int64(n)
+	return n, err
+}
+
+// WriteAt writes p at offset off, extending the file if needed. A gap
+// between the end of the file and off reads as zeros.
+func (file *File) WriteAt(p []byte, off int64) (int, error) {
+	switch {
+	case file.closed:
+		return 0, file.err("write", fs.ErrClosed)
+	case file.flag&(os.O_WRONLY|os.O_RDWR) == 0:
+		return 0, file.err("write", fs.ErrPermission)
+	case off < 0:
+		return 0, file.err("write", fs.ErrInvalid)
+	case off+int64(len(p)) > 0xffffffff:
+		return 0, file.err("write", errors.New("file too large for FAT"))
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	end := off + int64(len(p))
+	if end > int64(file.e.size) {
+		if err := file.grow(end, off); err != nil {
+			return 0, file.err("write", err)
+		}
+	}
+	chain, err := file.clusters()
+	if err != nil {
+		return 0, file.err("write", err)
+	}
+	cs := int64(file.f.clusterSize)
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		i, start := pos/cs, pos%cs
+		length := min(int64(len(p)-n), cs-start)
+		if _, err := file.f.w.WriteAt(p[n:n+int(length)], file.f.clusterOffset(chain[i])+start); err != nil {
+			return n, file.err("write", err)
+		}
+		n += int(length)
+	}
+	file.e.mtime = file.f.Now()
+	if err := file.f.writeEntry(&file.e); err != nil {
+		return n, file.err("write", err)
+	}
+	return n, nil
+}
+
+// grow extends the file to size bytes, allocating clusters and zeroing the
+// bytes between the current end and zeroTo.
+func (file *File) grow(size, zeroTo int64) error {
+	chain, err := file.clusters()
+	if err != nil {
+		return err
+	}
+	cs := int64(file.f.clusterSize)
+	if need := int((size+cs-1)/cs) - len(chain); need > 0 {
+		var last uint32
+		if len(chain) > 0 {
+			last = chain[len(chain)-1]
+		}
+		added, err := file.f.alloc(last, need)
+		if err != nil {
+			return err
+		}
+		if err := file.f.commit(); err != nil {
+			return err
+		}
+		if file.e.cluster == 0 {
+			file.e.cluster = added[0]
+		}
+		file.chain = append(chain, added...)
+	}
+	for pos := int64(file.e.size); pos < zeroTo; {
+		i, start := pos/cs, pos%cs
+		length := min(zeroTo-pos, cs-start)
+		if _, err := file.f.w.WriteAt(make([]byte, length), file.f.clusterOffset(file.chain[i])+start); err != nil {
+			return err
+		}
+		pos += length
+	}
+	file.e.size = uint32(size)
+	return nil
+}
+
+// Truncate changes the size of the file. Clusters no longer needed are
+// freed; new bytes read as zeros.
+func (file *File) Truncate(size int64) error {
+	switch {
+	case file.closed:
+		return file.err("truncate", fs.ErrClosed)
+	case file.flag&(os.O_WRONLY|os.O_RDWR) == 0:
+		return file.err("truncate", fs.ErrPermission)
+	case size < 0 || size > 0xffffffff:
+		return file.err("truncate", fs.ErrInvalid)
+	}
+	if err := file.truncate(size); err != nil {
+		return file.err("truncate", err)
+	}
+	return nil
+}
+
+func (file *File) truncate(size int64) error {
+	if size > int64(file.e.size) {
+		if err := file.grow(size, size); err != nil {
+			return err
+		}
+	} else {
+		chain, err := file.clusters()
+		if err != nil {
+			return err
+		}
+		cs := int64(file.f.clusterSize)
+		keep := int((size + cs - 1) / cs)
+		if keep < len(chain) {
+			file.f.freeChain(chain[keep:])
+			if keep > 0 {
+				file.f.setEntry(chain[keep-1], 0x0fffffff)
+			} else {
+				file.e.cluster = 0
+			}
+			file.chain = chain[:keep]
+			if err := file.f.commit(); err != nil {
+				return err
+			}
+		}
+		file.e.size = uint32(size)
+	}
+	file.e.mtime = file.f.Now()
+	return file.f.writeEntry(&file.e)
+}
+
+// Close closes the file. Changes have already been written.
+func (file *File) Close() error {
+	if file.closed {
+		return file.err("close", fs.ErrClosed)
+	}
+	file.closed = true
+	return nil
+}
@@ -0,0 +1,395 @@
+package fat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"example.com/usb/msc/partition"
+)
+
+// Device is a block device that can be formatted, such as an
+// msc.BlockDevice or a partition.Device. If it has a BlockSize method, the
+// block size is the sector size; otherwise sectors are 512 bytes.
+type Device interface {
+	io.ReaderAt
+	io.WriterAt
+	Size() int64
+}
+
+// FormatOptions are the choices Format makes when they are left zero.
+type FormatOptions struct {
+	// Type is FAT32 from 512 MiB up and FAT16 below, or FAT12 for
+	// volumes too small for FAT16.
+	Type Type
+
+	// ClusterSize in bytes is chosen by the size of the volume, as Windows
+	// chooses it.
+	ClusterSize int
+
+	Label         string // upper case; lower case letters are converted
+	Serial        uint32 // derived from the time
+	HiddenSectors uint32 // the start of the volume on its disk, set by MBR
+
+	// MBR makes Format write a partition table with one partition that
+	// starts at 1 MiB and fills the disk, and format that partition.
+	MBR bool
+
+	// Now returns the time stamps. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// mbrStart is where Format puts the partition, in bytes.
+const mbrStart = 1 << 20
+
+// layout is the geometry of a new file system, in sectors.
+type layout struct {
+	typ               Type
+	sectorSize        int
+	sectorsPerCluster int
+	total             int64
+	reserved          int64
+	fatSectors        int64
+	rootEntries       int
+	clusters          int64
+}
+
+func (l *layout) rootSectors() int64 {
+	return (int64(l.rootEntries)*dirEntrySize + int64(l.sectorSize) - 1) / int64(l.sectorSize)
+}
+
+func (l *layout) dataStart() int64 {
+	return l.reserved + 2*l.fatSectors + l.rootSectors()
+}
+
+// Format creates an empty FAT file system on dev and opens it. Everything
+// on dev is lost. The boot sector is written last, so the device does not
+// look formatted until it is. With opts.MBR the partition table is written
+// with partition.Write first. opts may be nil.
+func Format(dev Device, opts *FormatOptions) (*FS, error) {
+	if opts == nil {
+		opts = &FormatOptions{}
+	}
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+	ss := 512
+	if b, ok := dev.(interface{ BlockSize() int }); ok {
+		ss = b.BlockSize()
+	}
+	switch ss {
+	case 512, 1024, 2048, 4096:
+	default:
+		return nil, fmt.Errorf("fat: cannot format %d-byte blocks", ss)
+	}
+	label, err := volumeLabel(opts.Label)
+	if err != nil {
+		return nil, err
+	}
+
+	sectors := dev.Size() / int64(ss)
+	if opts.MBR {
+		sectors -= mbrStart / int64(ss)
+	}
+	l, err := plan(sectors, ss, opts)
+	if err != nil {
+		return nil, err
+	}
+	serial := opts.Serial
+	if serial == 0 {
+		t := now()
+		serial = uint32(t.Unix()<<20 | int64(t.Nanosecond()/1000))
+	}
+	var vol Device = dev
+	hidden := opts.HiddenSectors
+	if opts.MBR {
+		// The partition table is written first, since partition.Write checks
+		// it before writing and reads it back. The boot sector of the volume
+		// still comes last.
+		if vol, err = writeMBR(dev, ss, l.typ, serial); err != nil {
+			return nil, err
+		}
+		hidden = uint32(mbrStart / ss)
+	}
+
+	if err := l.write(vol, label, serial, hidden, now()); err != nil {
+		return nil, err
+	}
+	if s, ok := dev.(interface{ Sync() error }); ok {
+		if err := s.Sync(); err != nil {
+			return nil, err
+		}
+	}
+	f, err := Open(vol)
+	if err != nil {
+		return nil, err
+	}
+	f.Now = now
+	return f, nil
+}
+
+// volumeLabel checks a label and returns it as it is stored.
+func volumeLabel(s string) ([11]byte, error) {
+	var label [11]byte
+	s = strings.ToUpper(s)
+	if len(s) > len(label) || strings.HasPrefix(s, " ") {
+		return label, fmt.Errorf("%w: volume label %q", ErrInvalidName, s)
+	}
+	for _, r := range s {
+		if r != ' ' && !shortChar(r) {
+			return label, fmt.Errorf("%w: volume label %q", ErrInvalidName, s)
+		}
+	}
+	copy(label[:], s+strings.Repeat(" ", len(label)-len(s)))
+	return label, nil
+}
+
+// defaultClusterSize returns the cluster size Windows chooses for a volume
+// of typ with size bytes.
+func defaultClusterSize(typ Type, size int64) int {
+	const K, M, G = 1 << 10, 1 << 20, 1 << 30
+	switch typ {
+	case FAT12:
+		c := 512
+		for size/int64(c) >= 4000 {
+			c *= 2
+		}
+		return c
+	case FAT16:
+		switch {
+		case size <= 16*M:
+			return 1 * K
+		case size <= 128*M:
+			return 2 * K
+		case size <= 256*M:
+			return 4 * K
+		case size <= 512*M:
+			return 8 * K
+		case size <= 1*G:
+			return 16 * K
+		}
+		return 32 * K
+	}
+	switch {
+	case size <= 260*M:
+		return 512
+	case size <= 8*G:
+		return 4 * K
+	case size <= 16*G:
+		return 8 * K
+	case size <= 32*G:
+		return 16 * K
+	}
+	return 32 * K
+}
+
+// plan lays out a file system of total sectors of ss bytes.
+func plan(total int64, ss int, opts *FormatOptions) (*layout, error) {
+	typ := opts.Type
+	if typ == 0 {
+		if total*int64(ss) >= 512<<20 {
+			typ = FAT32
+		} else {
+			l, err := plan(total, ss, &FormatOptions{Type: FAT16, ClusterSize: opts.ClusterSize})
+			if err != nil {
+				if l, err12 := plan(total, ss, &FormatOptions{Type: FAT12, ClusterSize: opts.ClusterSize}); err12 == nil {
+					return l, nil
+				}
+			}
+			return l, err
+		}
+	}
+	cs := opts.ClusterSize
+	if cs == 0 {
+		cs = max(defaultClusterSize(typ, total*int64(ss)), ss)
+	}
+	if cs < ss || cs > 128*ss || cs > 64<<10 || cs&(cs-1) != 0 {
+		return nil, fmt.Errorf("fat: invalid cluster size %d for %d-byte sectors", cs, ss)
+	}
+
+	l := &layout{typ: typ, sectorSize: ss, sectorsPerCluster: cs / ss, total: total}
+	var lo, hi int64 // the cluster counts that make typ
+	switch typ {
+	case FAT12:
+		l.reserved, l.rootEntries, lo, hi = 1, 512, 1, 4084
+	case FAT16:
+		l.reserved, l.rootEntries, lo, hi = 1, 512, 4085, 65524
+	case FAT32:
+		l.reserved, lo, hi = 32, 65525, 0x0ffffff5
+	default:
+		return nil, fmt.Errorf("fat: invalid type %v", typ)
+	}
+	// Grow the FAT until it covers the clusters that are left, with the
+	// data area aligned to a cluster.
+	spc := int64(l.sectorsPerCluster)
+	base := l.reserved
+	for l.fatSectors = 1; ; {
+		l.reserved = base
+		l.reserved += (spc - l.dataStart()%spc) % spc
+		l.clusters = (total - l.dataStart()) / spc
+		if l.clusters < 1 {
+			return nil, fmt.Errorf("fat: %d sectors are too few for %v", total, typ)
+		}
+		need := ((l.clusters+2)*int64(typ)/4 + 1) / 2
+		need = (need + int64(ss) - 1) / int64(ss)
+		if need <= l.fatSectors {
+			break
+		}
+		l.fatSectors = need
+	}
+	if l.clusters < lo || l.clusters > hi {
+		return nil, fmt.Errorf("fat: %d clusters of %d bytes do not make %v, which needs %d to %d", l.clusters, cs, typ, lo, hi)
+	}
+	return l, nil
+}
+
+// write writes the file system: the reserved sectors, the FATs and the
+// root directory are cleared, then filled in, and the boot sector comes
+// last.
+func (l *layout) write(dev io.WriterAt, label [11]byte, serial, hidden uint32, now time.Time) error {
+	ss := int64(l.sectorSize)
+	end := l.dataStart() * ss
+	if l.typ == FAT32 {
+		end += int64(l.sectorsPerCluster) * ss // the root directory in cluster 2
+	}
+	zero := make([]byte, min(end, 1<<16))
+	for off := int64(0); off < end; off += int64(len(zero)) {
+		if _, err := dev.WriteAt(zero[:min(int64(len(zero)), end-off)], off); err != nil {
+			return err
+		}
+	}
+
+	// The first FAT entry holds the media byte, the second an end of chain
+	// marker; on FAT32 the third ends the root directory.
+	fat := make([]byte, ss)
+	switch l.typ {
+	case FAT12:
+		copy(fat, []byte{0xf8, 0xff, 0xff})
+	case FAT16:
+		copy(fat, []byte{0xf8, 0xff, 0xff, 0xff})
+	case FAT32:
+		binary.LittleEndian.PutUint32(fat[0:], 0x0ffffff8)
+		binary.LittleEndian.PutUint32(fat[4:], 0x0fffffff)
+		binary.LittleEndian.PutUint32(fat[8:], 0x0fffffff)
+	}
+	for i := range int64(2) {
+		if _, err := dev.WriteAt(fat, (l.reserved+i*l.fatSectors)*ss); err != nil {
+			return err
+		}
+	}
+
+	if label[0] != ' ' {
+		root := (l.reserved + 2*l.fatSectors) * ss
+		e := &dirent{short: label, attr: attrVolumeID, created: now, mtime: now}
+		if _, err := dev.WriteAt(e.marshal(), root); err != nil {
+			return err
+		}
+	}
+
+	boot := l.bootSector(label, serial, hidden)
+	if l.typ == FAT32 {
+		info := make([]byte, ss)
+		binary.LittleEndian.PutUint32(info[0:], 0x41615252)
+		binary.LittleEndian.PutUint32(info[484:], 0x61417272)
+		binary.LittleEndian.PutUint32(info[488:], uint32(l.clusters-1))
+		binary.LittleEndian.PutUint32(info[492:], 3)
+		binary.LittleEndian.PutUint32(info[508:], 0xaa550000)
+		// The backup boot sector at 6 is followed by a backup of the FSInfo
+		// sector.
+		for _, sector := range []struct {
+			n    int64
+			data []byte
+		}{{1, info}, {7, info}, {6, boot}} {
+			if _, err := dev.WriteAt(sector.data, sector.n*ss); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := dev.WriteAt(boot, 0)
+	return err
+}
+
+// bootSector returns the boot sector with the BIOS parameter block.
+func (l *layout) bootSector(label [11]byte, serial, hidden uint32) []byte {
+	b := make([]byte, l.sectorSize)
+	copy(b[3:], "MSWIN4.1")
+	binary.LittleEndian.PutUint16(b[11:], uint16(l.sectorSize))
+	b[13] = uint8(l.sectorsPerCluster)
+	binary.LittleEndian.PutUint16(b[14:], uint16(l.reserved))
+	b[16] = 2
+	binary.LittleEndian.PutUint16(b[17:], uint16(l.rootEntries))
+	if l.total < 1<<16 && l.typ != FAT32 {
+		binary.LittleEndian.PutUint16(b[19:], uint16(l.total))
+	} else {
+		binary.LittleEndian.PutUint32(b[32:], uint32(l.total))
+	}
+	b[21] = 0xf8 // fixed disk
+	binary.LittleEndian.PutUint16(b[24:], 63)
+	binary.LittleEndian.PutUint16(b[26:], 255)
+	binary.LittleEndian.PutUint32(b[28:], hidden)
+
+	ext, fsType := b[36:], "FAT12   "
+	switch l.typ {
+	case FAT16:
+		fsType = "FAT16   "
+	case FAT32:
+		binary.LittleEndian.PutUint32(b[36:], uint32(l.fatSectors))
+		binary.LittleEndian.PutUint32(b[44:], 2) // root directory cluster
+		binary.LittleEndian.PutUint16(b[48:], 1) // FSInfo sector
+		binary.LittleEndian.PutUint16(b[50:], 6) // backup boot sector
+		ext, fsType = b[64:], "FAT32   "
+	}
+	if l.typ != FAT32 {
+		binary.LittleEndian.PutUint16(b[22:], uint16(l.fatSectors))
+	}
+	ext[0] = 0x80 // drive number
+	ext[2] = 0x29
+	binary.LittleEndian.PutUint32(ext[3:], serial)
+	copy(ext[7:18], label[:])
+	if label[0] == ' ' {
+		copy(ext[7:18], "NO NAME    ")
+	}
+	copy(ext[18:26], fsType)
+
+	// The jump goes past the file system type to code that asks the BIOS
+	// to try the next boot device.
+	code := len(b) - len(ext) + 26
+	b[0], b[1], b[2] = 0xeb, byte(code-2), 0x90
+	copy(b[code:], []byte{0xcd, 0x18, 0xeb, 0xfe}) // int 18h; jmp $
+	b[510], b[511] = 0x55, 0xaa
+	return b
+}
+
+// writeMBR writes a partition table with one partition from mbrStart to
+// the end of the disk, of the type Windows gives typ, and returns the
+// partition. The disk signature is the volume serial number.
+func writeMBR(dev Device, ss int, typ Type, signature uint32) (*partition.Device, error) {
+	disk, ok := dev.(partition.WritableDisk)
+	if !ok {
+		disk = sectorDisk{dev, ss}
+	}
+	p := partition.Partition{Start: uint64(mbrStart / ss), Type: 0x0c} // FAT32 with LBA
+	p.Length = uint64(dev.Size()/int64(ss)) - p.Start
+	switch typ {
+	case FAT12:
+		p.Type = 0x01
+	case FAT16:
+		p.Type = 0x0e // with LBA
+	}
+	table := &partition.Table{Scheme: partition.MBR, DiskSignature: signature, Partitions: []partition.Partition{p}}
+	if err := partition.Write(disk, table); err != nil {
+		return nil, fmt.Errorf("fat: %w", err)
+	}
+	return partition.NewDevice(disk, table.Partitions[0]), nil
+}
+
+// sectorDisk gives a Device without a BlockSize method its sector size.
+type sectorDisk struct {
+	Device
+	sectorSize int
+}
+
+func (d sectorDisk) BlockSize() int { return d.sectorSize }
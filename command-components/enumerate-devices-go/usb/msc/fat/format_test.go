@@ -0,0 +1,224 @@
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"example.com/usb/msc/partition"
+)
+
+// fileDevice is a sparse image file with a block size.
+type fileDevice struct {
+	*os.File
+	size      int64
+	blockSize int
+}
+
+func (d *fileDevice) Size() int64    { return d.size }
+func (d *fileDevice) BlockSize() int { return d.blockSize }
+
+func newDevice(t *testing.T, size int64, blockSize int) *fileDevice {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "disk.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	// Leave garbage where the file system goes.
+	if _, err := f.WriteAt(bytes.Repeat([]byte{0xa5}, 1<<20), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	return &fileDevice{f, size, blockSize}
+}
+
+func TestFormat(t *testing.T) {
+	const M = 1 << 20
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name        string
+		size        int64
+		blockSize   int
+		opts        FormatOptions
+		typ         Type
+		clusterSize int
+	}{
+		{"floppy", 1474560, 512, FormatOptions{}, FAT12, 512},
+		{"small", 8 * M, 512, FormatOptions{}, FAT16, 1024},
+		{"FAT16", 200 * M, 512, FormatOptions{}, FAT16, 4096},
+		{"FAT32", 600 * M, 512, FormatOptions{}, FAT32, 4096},
+		{"forced FAT32", 40 * M, 512, FormatOptions{Type: FAT32}, FAT32, 512},
+		{"cluster size", 64 * M, 512, FormatOptions{ClusterSize: 16384}, FAT16, 16384},
+		{"4K sectors", 600 * M, 4096, FormatOptions{}, FAT32, 4096},
+		{"4K sectors FAT16", 100 * M, 4096, FormatOptions{}, FAT16, 4096},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev := newDevice(t, tt.size, tt.blockSize)
+			tt.opts.Label, tt.opts.Serial = "Field 7", 0x2025abcd
+			tt.opts.Now = func() time.Time { return now }
+			f, err := Format(dev, &tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Type() != tt.typ || f.ClusterSize() != tt.clusterSize || f.Label() != "FIELD 7" || f.Serial() != 0x2025abcd {
+				t.Errorf("%v with %d-byte clusters, label %q, serial %#x", f.Type(), f.ClusterSize(), f.Label(), f.Serial())
+			}
+			if f.dataOffset%int64(f.clusterSize) != 0 {
+				t.Errorf("data area at %d is not aligned", f.dataOffset)
+			}
+			checkClean(t, f)
+			// Only the root directory of FAT32 takes a cluster.
+			if f.free != f.clusters-uint32(min(f.rootCluster, 1)) {
+				t.Errorf("%d of %d clusters free", f.free, f.clusters)
+			}
+
+			// The boot sector as other systems expect it.
+			b := make([]byte, tt.blockSize)
+			dev.ReadAt(b, 0)
+			if b[0] != 0xeb || b[510] != 0x55 || b[511] != 0xaa || b[21] != 0xf8 {
+				t.Errorf("boot sector % x", b[:64])
+			}
+			if f.typ == FAT32 {
+				backup := make([]byte, tt.blockSize)
+				dev.ReadAt(backup, 6*int64(tt.blockSize))
+				if !bytes.Equal(b, backup) || f.fsInfo == 0 {
+					t.Error("no backup boot sector or FSInfo")
+				}
+				if info, err := f.readFSInfo(); err != nil || info.free != f.free || info.nextFree != 3 {
+					t.Errorf("FSInfo %+v, %v", info, err)
+				}
+			}
+			if fat := f.fat[:4]; fat[0] != 0xf8 || fat[1] != 0xff {
+				t.Errorf("FAT starts % x", fat)
+			}
+
+			// The new file system can be used, and checked again after
+			// reopening.
+			if err := f.MkdirAll("logs/2025"); err != nil {
+				t.Fatal(err)
+			}
+			file, err := f.Create("logs/2025/boot.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Write(bytes.Repeat([]byte("ok\n"), 5000))
+			file.Close()
+			if f, err = Open(dev); err != nil {
+				t.Fatal(err)
+			}
+			if err := fstest.TestFS(f, "logs/2025/boot.log"); err != nil {
+				t.Fatal(err)
+			}
+			checkClean(t, f)
+			entries, _ := f.ReadDir(".")
+			if len(entries) != 1 || entries[0].Name() != "logs" {
+				t.Errorf("root holds %v", entries)
+			}
+		})
+	}
+}
+
+// TestFsck runs fsck.fat, where it is installed, on new file systems.
+func TestFsck(t *testing.T) {
+	fsck, err := exec.LookPath("fsck.fat")
+	if err != nil {
+		t.Skip("no fsck.fat")
+	}
+	for _, size := range []int64{1474560, 64 << 20, 600 << 20} {
+		dev := newDevice(t, size, 512)
+		f, err := Format(dev, &FormatOptions{Label: "FSCK"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.MkdirAll("a/b"); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := exec.Command(fsck, "-n", "-v", dev.Name()).CombinedOutput(); err != nil {
+			t.Errorf("%v, %d bytes: %v\n%s", f.Type(), size, err, out)
+		}
+	}
+}
+
+func TestFormatMBR(t *testing.T) {
+	for _, bs := range []int{512, 4096} {
+		dev := newDevice(t, 64<<20, bs)
+		f, err := Format(dev, &FormatOptions{MBR: true, Label: "STICK"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		table, err := partition.Read(dev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := table.Partitions
+		if table.Scheme != partition.MBR || len(p) != 1 || p[0].Start != uint64(1<<20/bs) ||
+			p[0].End() != uint64(64<<20/bs) || p[0].Type != 0x0e || len(table.Warnings) != 0 {
+			t.Fatalf("%d-byte blocks: table %+v", bs, table)
+		}
+		f2, err := Open(partition.NewDevice(dev, p[0]))
+		if err != nil || f2.Label() != "STICK" || f2.Serial() != f.Serial() {
+			t.Fatalf("%d-byte blocks: open partition: %v", bs, err)
+		}
+		b := make([]byte, 4)
+		partition.NewDevice(dev, p[0]).ReadAt(b, 28)
+		if binary.LittleEndian.Uint32(b) != uint32(p[0].Start) {
+			t.Errorf("%d-byte blocks: hidden sectors %d", bs, binary.LittleEndian.Uint32(b))
+		}
+		checkClean(t, f2)
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	const M = 1 << 20
+	tests := []struct {
+		name string
+		size int64
+		opts FormatOptions
+		err  error
+	}{
+		{"FAT32 too small", 16 * M, FormatOptions{Type: FAT32}, nil},
+		{"FAT16 too large", 4096 * M, FormatOptions{Type: FAT16}, nil},
+		{"FAT12 too large", 64 * M, FormatOptions{Type: FAT12, ClusterSize: 512}, nil},
+		{"odd cluster size", 64 * M, FormatOptions{ClusterSize: 3000}, nil},
+		{"huge cluster size", 64 * M, FormatOptions{ClusterSize: 128 << 10}, nil},
+		{"too small", 4096, FormatOptions{}, nil},
+		{"long label", 8 * M, FormatOptions{Label: "TWELVE CHARS"}, ErrInvalidName},
+		{"bad label", 8 * M, FormatOptions{Label: "A*B"}, ErrInvalidName},
+	}
+	for _, tt := range tests {
+		dev := newDevice(t, tt.size, 512)
+		_, err := Format(dev, &tt.opts)
+		if err == nil || tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+		// Nothing was written.
+		b := make([]byte, 512)
+		dev.ReadAt(b, 0)
+		if b[0] != 0xa5 {
+			t.Errorf("%s: device was written", tt.name)
+		}
+	}
+}
+
+func TestCheckBackupBoot(t *testing.T) {
+	dev := newDevice(t, 40<<20, 512)
+	f, err := Format(dev, &FormatOptions{Type: FAT32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.WriteAt([]byte("X"), 6*512+3)
+	problems, err := f.Check()
+	if err != nil || len(problems) != 1 || problems[0].Detail != "backup boot sector differs from the boot sector" {
+		t.Errorf("problems %v, %v", problems, err)
+	}
+}
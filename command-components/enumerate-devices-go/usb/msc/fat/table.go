@@ -0,0 +1,168 @@
+package fat
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// entry returns the FAT entry of cluster c.
+func (f *FS) entry(c uint32) uint32 {
+	switch f.typ {
+	case FAT12:
+		off := c + c/2
+		v := uint32(binary.LittleEndian.Uint16(f.fat[off:]))
+		if c&1 != 0 {
+			return v >> 4
+		}
+		return v & 0xfff
+	case FAT16:
+		return uint32(binary.LittleEndian.Uint16(f.fat[2*c:]))
+	}
+	return binary.LittleEndian.Uint32(f.fat[4*c:]) & 0x0fffffff
+}
+
+// setEntry sets the FAT entry of cluster c. The change is written to the
+// device by commit.
+func (f *FS) setEntry(c, v uint32) {
+	var off, n int64
+	switch f.typ {
+	case FAT12:
+		off, n = int64(c+c/2), 2
+		old := binary.LittleEndian.Uint16(f.fat[off:])
+		if c&1 != 0 {
+			v = uint32(old&0x000f) | (v&0xfff)<<4
+		} else {
+			v = uint32(old&0xf000) | v&0xfff
+		}
+		binary.LittleEndian.PutUint16(f.fat[off:], uint16(v))
+	case FAT16:
+		off, n = int64(2*c), 2
+		binary.LittleEndian.PutUint16(f.fat[off:], uint16(v))
+	default:
+		// The top four bits are reserved and kept.
+		off, n = int64(4*c), 4
+		old := binary.LittleEndian.Uint32(f.fat[off:])
+		binary.LittleEndian.PutUint32(f.fat[off:], old&0xf0000000|v&0x0fffffff)
+	}
+	ss := int64(f.sectorSize)
+	f.dirty[off/ss] = true
+	f.dirty[(off+n-1)/ss] = true
+}
+
+// eoc returns the smallest end of chain marker; the value below it marks a
+// bad cluster.
+func (f *FS) eoc() uint32 {
+	switch f.typ {
+	case FAT12:
+		return 0xff8
+	case FAT16:
+		return 0xfff8
+	}
+	return 0x0ffffff8
+}
+
+func (f *FS) validCluster(c uint32) bool {
+	return c >= 2 && c < f.clusters+2
+}
+
+// chain returns the clusters of the chain starting at first, which is empty
+// for cluster 0.
+func (f *FS) chain(first uint32) ([]uint32, error) {
+	var chain []uint32
+	for c := first; c != 0; {
+		if !f.validCluster(c) {
+			return nil, fmt.Errorf("%w: invalid cluster %d in chain at %d", ErrCorrupt, c, first)
+		}
+		if uint32(len(chain)) > f.clusters {
+			return nil, fmt.Errorf("%w: cluster chain at %d loops", ErrCorrupt, first)
+		}
+		chain = append(chain, c)
+		next := f.entry(c)
+		if next >= f.eoc() {
+			break
+		}
+		if next == 0 {
+			return nil, fmt.Errorf("%w: cluster chain at %d runs into free cluster %d", ErrCorrupt, first, c)
+		}
+		c = next
+	}
+	return chain, nil
+}
+
+// alloc allocates n clusters, links them into a chain and appends it to the
+// chain ending in last, unless last is 0.
+func (f *FS) alloc(last uint32, n int) ([]uint32, error) {
+	if uint32(n) > f.free {
+		return nil, ErrNoSpace
+	}
+	clusters := make([]uint32, 0, n)
+	c := f.nextFree
+	for len(clusters) < n {
+		if !f.validCluster(c) {
+			c = 2
+		}
+		if f.entry(c) == 0 {
+			clusters = append(clusters, c)
+		}
+		c++
+	}
+	for i, c := range clusters {
+		if i+1 < len(clusters) {
+			f.setEntry(c, clusters[i+1])
+		} else {
+			f.setEntry(c, 0x0fffffff)
+		}
+	}
+	if last != 0 && n > 0 {
+		f.setEntry(last, clusters[0])
+	}
+	f.free -= uint32(n)
+	f.nextFree = c
+	return clusters, nil
+}
+
+// freeChain frees the clusters of chain.
+func (f *FS) freeChain(chain []uint32) {
+	for _, c := range chain {
+		f.setEntry(c, 0)
+	}
+	f.free += uint32(len(chain))
+}
+
+// commit writes the changed sectors of the FAT to every copy, and the free
+// cluster count and hint to the FSInfo sector.
+func (f *FS) commit() error {
+	ss := int64(f.sectorSize)
+	for sector := range f.dirty {
+		data := f.fat[sector*ss : (sector+1)*ss]
+		for i := range int64(f.numFATs) {
+			if _, err := f.w.WriteAt(data, f.fatOffset+i*f.fatSize+sector*ss); err != nil {
+				return err
+			}
+		}
+		delete(f.dirty, sector)
+	}
+	if f.fsInfo == 0 {
+		return nil
+	}
+	if _, err := f.readFSInfo(); err != nil {
+		// Leave a damaged FSInfo sector to the checker.
+		return nil
+	}
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b, f.free)
+	binary.LittleEndian.PutUint32(b[4:], f.nextFree)
+	_, err := f.w.WriteAt(b, f.fsInfo+488)
+	return err
+}
+
+// clusterOffset returns the device offset of cluster c.
+func (f *FS) clusterOffset(c uint32) int64 {
+	return f.dataOffset + int64(c-2)*int64(f.clusterSize)
+}
+
+// zeroCluster clears cluster c on the device.
+func (f *FS) zeroCluster(c uint32) error {
+	_, err := f.w.WriteAt(make([]byte, f.clusterSize), f.clusterOffset(c))
+	return err
+}
@@ -0,0 +1,355 @@
+package fat
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// writeEntry writes the short entry of e back to its directory.
+func (f *FS) writeEntry(e *dirent) error {
+	_, err := f.w.WriteAt(e.marshal(), e.off)
+	return err
+}
+
+// parentDir reads the directory that is to contain name and checks the
+// last element of name for a new entry.
+func (f *FS) parentDir(op, name string) (*dirBuf, *dirent, string, error) {
+	if f.w == nil {
+		return nil, nil, "", &fs.PathError{Op: op, Path: name, Err: ErrReadOnly}
+	}
+	if !fs.ValidPath(name) || name == "." {
+		return nil, nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	dir, base := path.Split(name)
+	parent, _, err := f.lookup(op, path.Clean(dir+"."))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if !parent.isDir() {
+		return nil, nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	d, err := f.readDir(parent.cluster)
+	if err != nil {
+		return nil, nil, "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if !validName(base) {
+		return nil, nil, "", &fs.PathError{Op: op, Path: name, Err: ErrInvalidName}
+	}
+	return d, parent, base, nil
+}
+
+// create adds an entry for a new file or directory called name. The caller
+// has checked that it does not exist.
+func (f *FS) create(name string, attr uint8, cluster uint32) (*dirent, error) {
+	d, _, base, err := f.parentDir("create", name)
+	if err != nil {
+		return nil, err
+	}
+	now := f.Now()
+	return d.add(&dirent{name: base, attr: attr, cluster: cluster, created: now, mtime: now})
+}
+
+// add writes an entry called e.name to the directory, with long name
+// entries if the name does not fit 8.3. The directory grows by a cluster
+// if there is no room; the fixed root directory cannot.
+func (d *dirBuf) add(e *dirent) (*dirent, error) {
+	var slots [][]byte
+	if short, ntres, ok := exactShort(e.name); ok {
+		e.short, e.ntres = short, ntres
+	} else {
+		e.short, e.ntres = d.aliasShort(e.name), 0
+		slots = lfnSlots(e.name, checksum(e.short))
+	}
+	slots = append(slots, e.marshal())
+
+	first := d.freeSlots(len(slots))
+	if first < 0 {
+		if d.cluster == 0 {
+			return nil, ErrRootFull
+		}
+		if err := d.grow(len(slots)); err != nil {
+			return nil, err
+		}
+		first = d.freeSlots(len(slots))
+	}
+	for i, s := range slots {
+		copy(d.slot(first+i), s)
+		if _, err := d.f.w.WriteAt(s, d.slotOffset(first+i)); err != nil {
+			return nil, err
+		}
+	}
+	e.first, e.slot = first, first+len(slots)-1
+	e.off = d.slotOffset(e.slot)
+	d.entries = append(d.entries, e)
+	return e, nil
+}
+
+// freeSlots returns the first of n consecutive free slots, or -1.
+func (d *dirBuf) freeSlots(n int) int {
+	run := 0
+	for i := range d.slots() {
+		switch d.slot(i)[0] {
+		case slotEnd:
+			// Everything after the end marker is free.
+			if d.slots()-i >= n-run {
+				return i - run
+			}
+			return -1
+		case slotFree:
+			run++
+		default:
+			run = 0
+		}
+		if run == n {
+			return i - n + 1
+		}
+	}
+	return -1
+}
+
+// grow adds zeroed clusters to the directory for at least n more slots.
+func (d *dirBuf) grow(n int) error {
+	f := d.f
+	chain, err := f.chain(d.cluster)
+	if err != nil {
+		return err
+	}
+	count := (n*dirEntrySize + f.clusterSize - 1) / f.clusterSize
+	added, err := f.alloc(chain[len(chain)-1], count)
+	if err != nil {
+		return err
+	}
+	for _, c := range added {
+		if err := f.zeroCluster(c); err != nil {
+			return err
+		}
+		d.offsets = append(d.offsets, f.clusterOffset(c))
+		d.data = append(d.data, make([]byte, f.clusterSize)...)
+	}
+	return f.commit()
+}
+
+// remove marks the slots of e free.
+func (d *dirBuf) remove(e *dirent) error {
+	for i := e.first; i <= e.slot; i++ {
+		d.slot(i)[0] = slotFree
+		if _, err := d.f.w.WriteAt([]byte{slotFree}, d.slotOffset(i)); err != nil {
+			return err
+		}
+	}
+	for i, x := range d.entries {
+		if x == e {
+			d.entries = append(d.entries[:i], d.entries[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Mkdir creates a directory.
+func (f *FS) Mkdir(name string) error {
+	if _, _, err := f.lookup("mkdir", name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	d, parent, base, err := f.parentDir("mkdir", name)
+	if err != nil {
+		return err
+	}
+	clusters, err := f.alloc(0, 1)
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	c := clusters[0]
+	now := f.Now()
+	dot := dirent{short: dotName("."), attr: attrDirectory, cluster: c, created: now, mtime: now}
+	dotdot := dirent{short: dotName(".."), attr: attrDirectory, cluster: parent.cluster, created: now, mtime: now}
+	if parent.cluster == f.rootCluster {
+		dotdot.cluster = 0 // .. refers to the root directory as cluster 0
+	}
+	data := make([]byte, f.clusterSize)
+	copy(data, dot.marshal())
+	copy(data[dirEntrySize:], dotdot.marshal())
+	if _, err := f.w.WriteAt(data, f.clusterOffset(c)); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if err := f.commit(); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if _, err := d.add(&dirent{name: base, attr: attrDirectory, cluster: c, created: now, mtime: now}); err != nil {
+		f.freeChain(clusters)
+		return &fs.PathError{Op: "mkdir", Path: name, Err: errors.Join(err, f.commit())}
+	}
+	return nil
+}
+
+func dotName(name string) [11]byte {
+	var short [11]byte
+	copy(short[:], name+strings.Repeat(" ", 11-len(name)))
+	return short
+}
+
+// MkdirAll creates a directory and any parents that do not exist yet.
+func (f *FS) MkdirAll(name string) error {
+	if e, _, err := f.lookup("mkdir", name); err == nil {
+		if !e.isDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: errors.New("not a directory")}
+		}
+		return nil
+	}
+	if dir := path.Dir(name); dir != "." {
+		if err := f.MkdirAll(dir); err != nil {
+			return err
+		}
+	}
+	return f.Mkdir(name)
+}
+
+// Remove removes a file or an empty directory.
+func (f *FS) Remove(name string) error {
+	if f.w == nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: ErrReadOnly}
+	}
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	e, d, err := f.lookup("remove", name)
+	if err != nil {
+		return err
+	}
+	if e.isDir() {
+		sub, err := f.readDir(e.cluster)
+		if err != nil {
+			return &fs.PathError{Op: "remove", Path: name, Err: err}
+		}
+		if len(sub.entries) > 0 {
+			return &fs.PathError{Op: "remove", Path: name, Err: ErrNotEmpty}
+		}
+	}
+	chain, err := f.chain(e.cluster)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if err := d.remove(e); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	f.freeChain(chain)
+	if err := f.commit(); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Rename renames or moves a file or directory. An existing file at newname
+// is replaced; an existing directory is not.
+func (f *FS) Rename(oldname, newname string) error {
+	if f.w == nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrReadOnly}
+	}
+	if oldname == "." || newname == "." {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrInvalid}
+	}
+	e, _, err := f.lookup("rename", oldname)
+	if err != nil {
+		return err
+	}
+	if e.isDir() && f.inside(path.Dir(newname), e.cluster) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errors.New("cannot move a directory into itself")}
+	}
+	if target, _, err := f.lookup("rename", newname); err == nil {
+		if target.off == e.off {
+			// Only the case of the name changes.
+		} else if target.isDir() {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrExist}
+		} else if err := f.Remove(newname); err != nil {
+			return err
+		}
+	}
+
+	d, parent, base, err := f.parentDir("rename", newname)
+	if err != nil {
+		return err
+	}
+	moved := *e
+	moved.name = base
+	if _, err := d.add(&moved); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	// The old entry may be in the directory just changed, so read that
+	// again. If only the case changes, both entries match the old name; the
+	// old one is where it always was.
+	od := d
+	if path.Dir(oldname) != path.Dir(newname) {
+		_, od, err = f.lookup("rename", oldname)
+		if err != nil {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+		}
+	}
+	if err := od.remove(od.at(e.off)); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	if e.isDir() && path.Dir(oldname) != path.Dir(newname) {
+		// Point .. at the new parent.
+		sub, err := f.readDir(e.cluster)
+		if err != nil {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+		}
+		cluster := parent.cluster
+		if cluster == f.rootCluster {
+			cluster = 0
+		}
+		if err := sub.setDotDot(cluster); err != nil {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+		}
+	}
+	return nil
+}
+
+// inside reports whether the directory dir is, or is below, the directory
+// at cluster. Clusters are compared rather than names, which match in any
+// case and by their short names too.
+func (f *FS) inside(dir string, cluster uint32) bool {
+	for ; dir != "."; dir = path.Dir(dir) {
+		if e, _, err := f.lookup("rename", dir); err == nil && e.cluster == cluster {
+			return true
+		}
+	}
+	return false
+}
+
+// at returns the entry whose short entry is at device offset off, or nil.
+func (d *dirBuf) at(off int64) *dirent {
+	for _, e := range d.entries {
+		if e.off == off {
+			return e
+		}
+	}
+	return nil
+}
+
+// setDotDot points the .. entry of the directory at cluster.
+func (d *dirBuf) setDotDot(cluster uint32) error {
+	dotdot := dotName("..")
+	for i := range min(2, d.slots()) {
+		s := d.slot(i)
+		if string(s[:11]) == string(dotdot[:]) {
+			e := parseShort(s)
+			e.cluster = cluster
+			_, err := d.f.w.WriteAt(e.marshal(), d.slotOffset(i))
+			return err
+		}
+	}
+	return nil
+}
+
+// Truncate changes the size of the named file.
+func (f *FS) Truncate(name string, size int64) error {
+	file, err := f.OpenFile(name, os.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Truncate(size)
+}
@@ -0,0 +1,235 @@
+package fat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// readOnly hides the io.WriterAt of a device.
+type readOnly struct {
+	io.ReaderAt
+}
+
+func TestWriteImages(t *testing.T) {
+	for _, img := range images {
+		t.Run(img.typ.String(), func(t *testing.T) {
+			f, dev := openImage(t, img.file)
+			now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.Local)
+			f.Now = func() time.Time { return now }
+
+			// Create a file spanning several clusters, then append to it.
+			file, err := f.Create("Docs/New File.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := bytes.Repeat([]byte("0123456789abcdef"), 100)
+			if n, err := file.Write(data); n != len(data) || err != nil {
+				t.Fatalf("Write = %d, %v", n, err)
+			}
+			file.Close()
+			file, err = f.OpenFile("docs/new file.log", os.O_WRONLY|os.O_APPEND)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Write([]byte("tail"))
+			file.Close()
+			want := append(bytes.Clone(data), "tail"...)
+
+			// Truncate one file, extend another with a gap.
+			if err := f.Truncate("A long file name.txt", 600); err != nil {
+				t.Fatal(err)
+			}
+			file, _ = f.OpenFile("README.TXT", os.O_RDWR)
+			if _, err := file.WriteAt([]byte("!"), 1000); err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+			file, _ = f.OpenFile("A long file name.txt", os.O_WRONLY)
+			if _, err := file.WriteAt([]byte{0}, 0); err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+
+			if err := f.MkdirAll("new/deeper"); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Rename("lower.txt", "new/deeper/moved.txt"); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Rename("Docs/sub", "new/sub"); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Rename("README.TXT", "ReadMe.txt"); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Remove("MANY/file00.dat"); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Remove("Docs"); !errors.Is(err, ErrNotEmpty) {
+				t.Errorf("removing a full directory: %v", err)
+			}
+			checkClean(t, f)
+
+			// Everything must be on the image, so open it again.
+			f, err = Open(dev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkClean(t, f)
+			if err := fstest.TestFS(f, "Docs/New File.log", "ReadMe.txt", "new/deeper/moved.txt", "new/sub/DEEP.BIN"); err != nil {
+				t.Fatal(err)
+			}
+			if b, _ := fs.ReadFile(f, "Docs/New File.log"); !bytes.Equal(b, want) {
+				t.Errorf("appended file holds %d bytes", len(b))
+			}
+			if info, _ := f.Stat("Docs/New File.log"); !info.ModTime().Equal(now) {
+				t.Errorf("modification time %v", info.ModTime())
+			}
+			if b, _ := fs.ReadFile(f, "A long file name.txt"); len(b) != 600 || b[599] != byte(599*7%251) {
+				t.Errorf("truncated file holds %d bytes", len(b))
+			}
+			b, _ := fs.ReadFile(f, "ReadMe.txt")
+			if len(b) != 1001 || string(b[:11]) != "hello, fat\n" || !bytes.Equal(b[11:1000], make([]byte, 989)) || b[1000] != '!' {
+				t.Errorf("extended file holds %q", b)
+			}
+			if entries, _ := f.ReadDir("."); entries[len(entries)-2].Name() != "ReadMe.txt" {
+				t.Errorf("root %v", entries)
+			}
+			for _, gone := range []string{"lower.txt", "Docs/sub", "MANY/file00.dat"} {
+				if _, err := f.Stat(gone); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("%s: %v", gone, err)
+				}
+			}
+
+			// Removing everything that was added gives the space back.
+			free := f.Free()
+			for _, name := range []string{"Docs/New File.log", "new/deeper/moved.txt", "new/sub/DEEP.BIN", "new/sub", "new/deeper", "new"} {
+				if err := f.Remove(name); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if f.Free() <= free {
+				t.Errorf("free space went from %d to %d", free, f.Free())
+			}
+			checkClean(t, f)
+		})
+	}
+}
+
+func TestDirectoryGrows(t *testing.T) {
+	f, _ := openImage(t, "fat16.img.gz")
+	if err := f.Mkdir("big"); err != nil {
+		t.Fatal(err)
+	}
+	// 2 KiB clusters hold 64 entries; long names take two or three.
+	for i := range 100 {
+		file, err := f.Create(fmt.Sprintf("big/a file with a long name %03d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+	}
+	entries, err := f.ReadDir("big")
+	if err != nil || len(entries) != 100 {
+		t.Fatalf("%d entries, %v", len(entries), err)
+	}
+	checkClean(t, f)
+}
+
+func TestRenameCase(t *testing.T) {
+	f, _ := openImage(t, "fat16.img.gz")
+	// Free slots before the file, where the renamed entry goes.
+	for _, name := range []string{"Docs/a name that takes several slots", "Docs/some file"} {
+		file, err := f.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+	}
+	if err := f.Remove("Docs/a name that takes several slots"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Rename("Docs/some file", "Docs/Some File"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := f.ReadDir("Docs"); len(entries) != 3 || entries[1].Name() != "Some File" {
+		t.Errorf("entries %v", entries)
+	}
+	checkClean(t, f)
+}
+
+func TestRootDirectoryFull(t *testing.T) {
+	f, _ := openImage(t, "fat12.img.gz")
+	var err error
+	for i := 0; err == nil; i++ {
+		var file *File
+		if file, err = f.Create(fmt.Sprintf("F%d", i)); err == nil {
+			file.Close()
+		}
+	}
+	if !errors.Is(err, ErrRootFull) {
+		t.Errorf("err = %v", err)
+	}
+	checkClean(t, f)
+}
+
+func TestNoSpace(t *testing.T) {
+	f, _ := openImage(t, "fat12.img.gz")
+	file, _ := f.Create("huge")
+	_, err := file.Write(make([]byte, 2<<20))
+	if !errors.Is(err, ErrNoSpace) {
+		t.Errorf("err = %v", err)
+	}
+	checkClean(t, f)
+}
+
+func TestWriteErrors(t *testing.T) {
+	f, dev := openImage(t, "fat16.img.gz")
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{f.Mkdir("Docs"), fs.ErrExist},
+		{f.Mkdir("nowhere/dir"), fs.ErrNotExist},
+		{f.Mkdir("bad:name"), ErrInvalidName},
+		{f.Remove("."), fs.ErrInvalid},
+		{f.Rename("Docs", "Docs/sub/Docs"), nil},
+		{f.Rename("Docs", "docs/sub/Docs"), nil},
+		{f.Rename("docs", "DOCS/x"), nil},
+		{f.Rename("README.TXT", "MANY"), fs.ErrExist},
+	}
+	for i, tt := range tests {
+		if tt.err == nil || tt.want != nil && !errors.Is(tt.err, tt.want) {
+			t.Errorf("%d: err = %v, want %v", i, tt.err, tt.want)
+		}
+	}
+	if _, err := f.OpenFile("README.TXT", os.O_CREATE|os.O_EXCL|os.O_WRONLY); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("exclusive create: %v", err)
+	}
+	if _, err := f.OpenFile("Docs", os.O_WRONLY); err == nil {
+		t.Error("directory opened for writing")
+	}
+	file, _ := f.OpenFile("README.TXT", os.O_RDONLY)
+	if _, err := file.Write([]byte("x")); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("write to read-only file: %v", err)
+	}
+
+	ro, err := Open(readOnly{dev})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ro.Create("x"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("create on read-only device: %v", err)
+	}
+	if err := ro.Rename("README.TXT", "x"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("rename on read-only device: %v", err)
+	}
+	checkClean(t, f)
+}
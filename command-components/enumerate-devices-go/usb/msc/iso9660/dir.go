@@ -0,0 +1,234 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// Directory record flags.
+const (
+	flagDirectory   = 0x02
+	flagAssociated  = 0x04
+	flagMultiExtent = 0x80 // the file continues in the next record
+)
+
+// extent is a run of sectors holding data of a file.
+type extent struct {
+	lba  uint32
+	size uint32
+}
+
+// dirent is a file or directory, from its directory records.
+type dirent struct {
+	name    string
+	ident   string // the file identifier of the record, as recorded
+	flags   uint8
+	extents []extent
+	size    int64
+	mode    fs.FileMode
+	mtime   time.Time
+	link    string // the target of a Rock Ridge symbolic link
+}
+
+func (e *dirent) isDir() bool {
+	return e.flags&flagDirectory != 0
+}
+
+// start returns the first sector of the data of e.
+func (e *dirent) start() uint32 {
+	if len(e.extents) == 0 {
+		return 0
+	}
+	return e.extents[0].lba
+}
+
+// parseRecord decodes the fixed part of a directory record. The name is
+// left to the caller.
+func parseRecord(b []byte) (*dirent, error) {
+	if len(b) < 34 || int(b[0]) > len(b) || int(b[0]) < 33+int(b[32]) {
+		return nil, fmt.Errorf("record of %d bytes", len(b))
+	}
+	e := &dirent{
+		ident:   string(b[33 : 33+b[32]]),
+		flags:   b[25],
+		extents: []extent{{lba: binary.LittleEndian.Uint32(b[2:]), size: binary.LittleEndian.Uint32(b[10:])}},
+		mtime:   recordDate(b[18:25]),
+	}
+	e.size = int64(e.extents[0].size)
+	e.mode = 0o444
+	if e.isDir() {
+		e.mode = fs.ModeDir | 0o555
+	}
+	return e, nil
+}
+
+// systemUse returns the system use area of a directory record.
+func systemUse(rec []byte) []byte {
+	off := 33 + int(rec[32])
+	if rec[32]%2 == 0 {
+		off++ // padding
+	}
+	if off > len(rec) {
+		return nil
+	}
+	return rec[off:]
+}
+
+// recordDate decodes the 7-byte date and time of a directory record.
+func recordDate(b []byte) time.Time {
+	if b[1] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, zone)
+}
+
+// readDir returns the entries of a directory, without "." and "..".
+func (f *FS) readDir(dir *dirent) ([]*dirent, error) {
+	data, err := f.readExtent(dir)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		entries []*dirent
+		last    *dirent // the last entry, if its file continues
+	)
+	for sector := 0; sector < len(data); sector += SectorSize {
+		b := data[sector:min(sector+SectorSize, len(data))]
+		for pos := 0; pos < len(b) && b[pos] != 0; pos += int(b[pos]) {
+			rec := b[pos:min(pos+int(b[pos]), len(b))]
+			e, err := parseRecord(rec)
+			if err != nil {
+				return nil, fmt.Errorf("%w: directory at sector %d: %v", ErrCorrupt, dir.start(), err)
+			}
+			if e.ident == "\x00" || e.ident == "\x01" {
+				continue
+			}
+			if last != nil && e.ident == last.ident {
+				last.extents = append(last.extents, e.extents[0])
+				last.size += e.size
+				if e.flags&flagMultiExtent == 0 {
+					last = nil
+				}
+				continue
+			}
+			last = nil
+			if e.flags&flagAssociated != 0 {
+				continue
+			}
+			e.name = f.name(e.ident, e.isDir())
+			if f.ext == RockRidge {
+				var rr rockRidge
+				su := systemUse(rec)
+				if err := f.susp(&rr, su[min(f.suspSkip, len(su)):]); err != nil {
+					return nil, fmt.Errorf("%w: Rock Ridge of %q: %v", ErrCorrupt, e.name, err)
+				}
+				if rr.relocated {
+					continue
+				}
+				if rr.child != 0 {
+					// A directory moved elsewhere to keep within the depth
+					// of ISO 9660.
+					moved, err := f.self(&dirent{extents: []extent{{lba: rr.child, size: SectorSize}}})
+					if err != nil {
+						return nil, err
+					}
+					moved.mtime = e.mtime
+					rr.apply(moved)
+					moved.name = e.name
+					if rr.name != "" {
+						moved.name = rr.name
+					}
+					e = moved
+				} else {
+					rr.apply(e)
+				}
+			}
+			if e.flags&flagMultiExtent != 0 {
+				last = e
+			}
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// name returns the name of an entry without an extension that gives it
+// one, from its file identifier: without its version, and without the dot
+// of names that have no extension.
+func (f *FS) name(ident string, dir bool) string {
+	if f.ext == Joliet {
+		ident = ucs2([]byte(ident))
+	}
+	if dir {
+		return ident
+	}
+	if i := strings.LastIndexByte(ident, ';'); i >= 0 {
+		ident = ident[:i]
+	}
+	return strings.TrimSuffix(ident, ".")
+}
+
+// self returns the directory entry described by the "." record of a
+// directory.
+func (f *FS) self(dir *dirent) (*dirent, error) {
+	b := make([]byte, SectorSize)
+	if _, err := f.dev.ReadAt(b, int64(dir.start())*SectorSize); err != nil {
+		return nil, err
+	}
+	e, err := parseRecord(b[:b[0]])
+	if err != nil || e.ident != "\x00" || !e.isDir() {
+		return nil, fmt.Errorf("%w: no \".\" record at sector %d", ErrCorrupt, dir.start())
+	}
+	if f.ext == RockRidge {
+		var rr rockRidge
+		su := systemUse(b[:b[0]])
+		if dir.start() != f.root.start() {
+			su = su[min(f.suspSkip, len(su)):]
+		}
+		if err := f.susp(&rr, su); err != nil {
+			return nil, fmt.Errorf("%w: Rock Ridge of directory at sector %d: %v", ErrCorrupt, dir.start(), err)
+		}
+		rr.apply(e)
+	}
+	return e, nil
+}
+
+// readExtent reads the data of a directory.
+func (f *FS) readExtent(dir *dirent) ([]byte, error) {
+	if len(dir.extents) != 1 {
+		return nil, fmt.Errorf("%w: directory of %d extents", ErrCorrupt, len(dir.extents))
+	}
+	// Directories are read in full: keep them within the size of a CD.
+	if dir.size > 1<<20 {
+		return nil, fmt.Errorf("%w: directory of %d bytes", ErrCorrupt, dir.size)
+	}
+	data := make([]byte, dir.size)
+	if _, err := f.dev.ReadAt(data, int64(dir.start())*SectorSize); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (e *dirent) info(name string) *fileInfo {
+	return &fileInfo{name: name, e: *e}
+}
+
+// fileInfo implements fs.FileInfo and fs.DirEntry.
+type fileInfo struct {
+	name string
+	e    dirent
+}
+
+func (i *fileInfo) Name() string               { return i.name }
+func (i *fileInfo) Size() int64                { return i.e.size }
+func (i *fileInfo) Mode() fs.FileMode          { return i.e.mode }
+func (i *fileInfo) ModTime() time.Time         { return i.e.mtime }
+func (i *fileInfo) IsDir() bool                { return i.e.isDir() }
+func (i *fileInfo) Sys() any                   { return nil }
+func (i *fileInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i *fileInfo) Info() (fs.FileInfo, error) { return i, nil }
+func (i *fileInfo) String() string             { return fs.FormatFileInfo(i) }
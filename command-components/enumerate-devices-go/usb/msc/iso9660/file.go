@@ -0,0 +1,146 @@
+package iso9660
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+)
+
+// File is an open file or directory.
+type File struct {
+	f      *FS
+	name   string
+	e      *dirent
+	offset int64
+	closed bool
+
+	dir []fs.DirEntry // remaining entries for ReadDir, nil until read
+}
+
+var _ interface {
+	fs.ReadDirFile
+	io.ReaderAt
+	io.ReadSeeker
+} = (*File)(nil)
+
+// Name returns the name the file was opened with.
+func (file *File) Name() string {
+	return file.name
+}
+
+// Stat returns a FileInfo describing the file.
+func (file *File) Stat() (fs.FileInfo, error) {
+	if file.closed {
+		return nil, file.err("stat", fs.ErrClosed)
+	}
+	return file.e.info(path.Base(file.name)), nil
+}
+
+func (file *File) err(op string, err error) error {
+	return &fs.PathError{Op: op, Path: file.name, Err: err}
+}
+
+// Read reads from the current offset and advances it.
+func (file *File) Read(p []byte) (int, error) {
+	n, err := file.ReadAt(p, file.offset)
+	file.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt reads len(p) bytes at offset off.
+func (file *File) ReadAt(p []byte, off int64) (int, error) {
+	switch {
+	case file.closed:
+		return 0, file.err("read", fs.ErrClosed)
+	case file.e.isDir():
+		return 0, file.err("read", errors.New("is a directory"))
+	case off < 0:
+		return 0, file.err("read", fs.ErrInvalid)
+	case off >= file.e.size:
+		return 0, io.EOF
+	}
+	var eof error
+	if rest := file.e.size - off; int64(len(p)) > rest {
+		p, eof = p[:rest], io.EOF
+	}
+	n := 0
+	// Files larger than 4 GiB are split in extents, each up to 4 GiB.
+	start := int64(0)
+	for _, x := range file.e.extents {
+		end := start + int64(x.size)
+		if pos := off + int64(n); n < len(p) && pos < end {
+			length := min(int64(len(p)-n), end-pos)
+			m, err := file.f.dev.ReadAt(p[n:n+int(length)], int64(x.lba)*SectorSize+pos-start)
+			n += m
+			if err != nil {
+				return n, file.err("read", err)
+			}
+		}
+		start = end
+	}
+	return n, eof
+}
+
+// Seek sets the offset for the next Read.
+func (file *File) Seek(offset int64, whence int) (int64, error) {
+	if file.closed {
+		return 0, file.err("seek", fs.ErrClosed)
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += file.offset
+	case io.SeekEnd:
+		offset += file.e.size
+	default:
+		return 0, file.err("seek", fs.ErrInvalid)
+	}
+	if offset < 0 {
+		return 0, file.err("seek", fs.ErrInvalid)
+	}
+	file.offset = offset
+	return offset, nil
+}
+
+// ReadDir reads the entries of a directory, sorted by name. If n > 0, it
+// returns at most n entries and io.EOF at the end; otherwise it returns all
+// remaining entries.
+func (file *File) ReadDir(n int) ([]fs.DirEntry, error) {
+	if file.closed {
+		return nil, file.err("readdir", fs.ErrClosed)
+	}
+	if !file.e.isDir() {
+		return nil, file.err("readdir", errors.New("not a directory"))
+	}
+	if file.dir == nil {
+		entries, err := file.f.dirEntries(file.e)
+		if err != nil {
+			return nil, file.err("readdir", err)
+		}
+		file.dir = append(entries, nil)[:len(entries)] // non-nil once read
+	}
+	if n <= 0 || n >= len(file.dir) {
+		entries := file.dir
+		file.dir = file.dir[len(file.dir):]
+		if n > 0 && len(entries) == 0 {
+			return nil, io.EOF
+		}
+		return entries, nil
+	}
+	entries := file.dir[:n]
+	file.dir = file.dir[n:]
+	return entries, nil
+}
+
+// Close closes the file.
+func (file *File) Close() error {
+	if file.closed {
+		return file.err("close", fs.ErrClosed)
+	}
+	file.closed = true
+	return nil
+}
@@ -0,0 +1,417 @@
+// Package iso9660 reads the ISO 9660 file system of CDs and DVDs, with the
+// Joliet and Rock Ridge extensions, from a block device such as an
+// msc.BlockDevice over an mmc.Drive, or from an image file.
+//
+// FS implements fs.FS, fs.ReadDirFS and fs.StatFS. Rock Ridge gives files
+// their POSIX names, modes, time stamps and symbolic links, and undoes the
+// relocation of directories nested deeper than ISO 9660 allows; Joliet
+// gives them Unicode names. Without either, names are those of ISO 9660,
+// without their version, and match without regard to case.
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// SectorSize is the size of the logical sectors of the file system.
+const SectorSize = 2048
+
+var (
+	ErrNotISO9660 = errors.New("iso9660: not an ISO 9660 file system")
+	ErrCorrupt    = errors.New("iso9660: file system is corrupt")
+)
+
+// Extension is the extension an FS reads names and attributes from.
+type Extension int
+
+const (
+	NoExtension Extension = iota
+	Joliet
+	RockRidge
+)
+
+func (e Extension) String() string {
+	switch e {
+	case NoExtension:
+		return "ISO 9660"
+	case Joliet:
+		return "Joliet"
+	case RockRidge:
+		return "Rock Ridge"
+	}
+	return fmt.Sprintf("Extension(%d)", int(e))
+}
+
+// Options configures Open.
+type Options struct {
+	// Session is the first sector of the last session of a multisession
+	// disc, which holds the current volume descriptors. The sectors the
+	// file system refers to are counted from the start of the disc, so dev
+	// must cover the whole disc. mmc.Drive.ReadSessionInfo reports it.
+	Session uint32
+	// NoRockRidge and NoJoliet make Open ignore the extensions. Rock Ridge
+	// is preferred to Joliet when a disc has both.
+	NoRockRidge bool
+	NoJoliet    bool
+}
+
+// Volume descriptor types.
+const (
+	vdBootRecord    = 0
+	vdPrimary       = 1
+	vdSupplementary = 2
+	vdTerminator    = 255
+)
+
+// FS is an ISO 9660 file system. It is read-only, and safe for concurrent
+// use if dev is.
+type FS struct {
+	dev  io.ReaderAt
+	ext  Extension
+	root *dirent
+
+	label    string
+	created  time.Time
+	modified time.Time
+	size     int64
+
+	// suspSkip is the number of bytes to skip at the start of the system use
+	// area of every record, from the SP entry of Rock Ridge.
+	suspSkip int
+}
+
+var _ interface {
+	fs.ReadDirFS
+	fs.StatFS
+} = (*FS)(nil)
+
+// Open reads the volume descriptors of the file system on dev and picks
+// the extension to use.
+func Open(dev io.ReaderAt) (*FS, error) {
+	return OpenWith(dev, Options{})
+}
+
+// OpenWith is Open with options.
+func OpenWith(dev io.ReaderAt, opts Options) (*FS, error) {
+	var primary, joliet []byte
+	b := make([]byte, SectorSize)
+	for lba := int64(opts.Session) + 16; ; lba++ {
+		if _, err := dev.ReadAt(b, lba*SectorSize); err != nil {
+			if primary == nil {
+				return nil, fmt.Errorf("%w: %v", ErrNotISO9660, err)
+			}
+			break
+		}
+		if string(b[1:6]) != "CD001" || b[6] != 1 {
+			break
+		}
+		if b[0] == vdTerminator {
+			break
+		}
+		switch {
+		case b[0] == vdPrimary && primary == nil:
+			primary = bytes.Clone(b)
+		case b[0] == vdSupplementary && joliet == nil && jolietLevel(b) > 0:
+			joliet = bytes.Clone(b)
+		}
+	}
+	if primary == nil {
+		return nil, ErrNotISO9660
+	}
+	if size := binary.LittleEndian.Uint16(primary[128:]); size != SectorSize {
+		return nil, fmt.Errorf("%w: logical blocks of %d bytes", ErrNotISO9660, size)
+	}
+
+	f := &FS{dev: dev, ext: NoExtension}
+	vd := primary
+	root, err := parseRecord(vd[156:190])
+	if err != nil {
+		return nil, fmt.Errorf("%w: root directory record: %v", ErrCorrupt, err)
+	}
+	if !opts.NoRockRidge {
+		if ok, err := f.detectRockRidge(root); err != nil {
+			return nil, err
+		} else if ok {
+			f.ext = RockRidge
+		}
+	}
+	if f.ext == NoExtension && joliet != nil && !opts.NoJoliet {
+		f.ext = Joliet
+		vd = joliet
+		if root, err = parseRecord(vd[156:190]); err != nil {
+			return nil, fmt.Errorf("%w: Joliet root directory record: %v", ErrCorrupt, err)
+		}
+	}
+	root.mode = fs.ModeDir | 0o555
+	f.root = root
+	if f.ext == RockRidge {
+		// The attributes of the root come with its "." record.
+		if self, err := f.self(root); err == nil {
+			self.name = "."
+			f.root = self
+		}
+	}
+	f.label = f.text(vd[40:72])
+	f.created = decDate(vd[813:830])
+	f.modified = decDate(vd[830:847])
+	f.size = int64(binary.LittleEndian.Uint32(vd[80:])) * SectorSize
+	return f, nil
+}
+
+// jolietLevel returns the level of Joliet a supplementary volume
+// descriptor declares with its escape sequences, or 0 if it is not Joliet.
+func jolietLevel(b []byte) int {
+	switch string(b[88:91]) {
+	case "%/@":
+		return 1
+	case "%/C":
+		return 2
+	case "%/E":
+		return 3
+	}
+	return 0
+}
+
+// detectRockRidge reports whether the "." record of the root directory
+// starts with the SP entry of the System Use Sharing Protocol, and records
+// the number of bytes it says to skip.
+func (f *FS) detectRockRidge(root *dirent) (bool, error) {
+	b := make([]byte, SectorSize)
+	if _, err := f.dev.ReadAt(b, int64(root.start())*SectorSize); err != nil {
+		return false, err
+	}
+	if b[0] < 34 || int(b[0]) > len(b) {
+		return false, fmt.Errorf("%w: root directory record of %d bytes", ErrCorrupt, b[0])
+	}
+	su := systemUse(b[:b[0]])
+	if len(su) < 7 || string(su[:2]) != "SP" || su[2] < 7 || su[4] != 0xbe || su[5] != 0xef {
+		return false, nil
+	}
+	f.suspSkip = int(su[6])
+	return true, nil
+}
+
+// Extension returns the extension the names and attributes come from.
+func (f *FS) Extension() Extension {
+	return f.ext
+}
+
+// Label returns the volume identifier, from the Joliet volume descriptor
+// when Joliet is used.
+func (f *FS) Label() string {
+	return f.label
+}
+
+// Created and Modified return the time stamps of the volume. They are zero
+// if the volume descriptor leaves them unset.
+func (f *FS) Created() time.Time {
+	return f.created
+}
+
+func (f *FS) Modified() time.Time {
+	return f.modified
+}
+
+// Size returns the size of the volume in bytes.
+func (f *FS) Size() int64 {
+	return f.size
+}
+
+// text decodes a string of a volume descriptor.
+func (f *FS) text(b []byte) string {
+	if f.ext == Joliet {
+		return strings.TrimRight(ucs2(b), " \x00")
+	}
+	return strings.TrimRight(string(b), " \x00")
+}
+
+// ucs2 decodes the big-endian UCS-2 of Joliet.
+func ucs2(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// decDate decodes the 17-byte date and time of a volume descriptor and of
+// long-form Rock Ridge time stamps: digits for the year to the hundredths
+// of a second, then the offset from UTC in units of 15 minutes.
+func decDate(b []byte) time.Time {
+	var v [7]int // year, month, day, hour, minute, second, hundredths
+	digits := b[:16]
+	for i := range v {
+		n := 2
+		if i == 0 {
+			n = 4
+		}
+		for _, c := range digits[:n] {
+			if c < '0' || c > '9' {
+				return time.Time{}
+			}
+			v[i] = v[i]*10 + int(c-'0')
+		}
+		digits = digits[n:]
+	}
+	if v[0] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[16]))*15*60)
+	return time.Date(v[0], time.Month(v[1]), v[2], v[3], v[4], v[5], v[6]*10*int(time.Millisecond), zone)
+}
+
+// maxLinks is the number of symbolic links followed in one lookup.
+const maxLinks = 40
+
+// lookup returns the entry for name. Symbolic links are followed on the
+// way, and at the end if follow is set.
+func (f *FS) lookup(op, name string, follow bool) (*dirent, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	e, err := f.walk(name, follow, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return e, nil
+}
+
+func (f *FS) walk(name string, follow bool, links int) (*dirent, error) {
+	e := f.root
+	if name == "." {
+		return e, nil
+	}
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		if !e.isDir() {
+			return nil, fs.ErrNotExist
+		}
+		entries, err := f.readDir(e)
+		if err != nil {
+			return nil, err
+		}
+		if e = f.find(entries, elem); e == nil {
+			return nil, fs.ErrNotExist
+		}
+		if e.mode&fs.ModeSymlink == 0 || i == len(elems)-1 && !follow {
+			continue
+		}
+		if links++; links > maxLinks {
+			return nil, errors.New("too many levels of symbolic links")
+		}
+		// Absolute targets start at the root of the disc.
+		target := path.Join(path.Join(elems[:i]...), e.link)
+		if strings.HasPrefix(e.link, "/") {
+			target = path.Clean(e.link[1:])
+		}
+		if target == "" {
+			target = "."
+		}
+		if !fs.ValidPath(target) {
+			return nil, fs.ErrNotExist
+		}
+		rest := path.Join(elems[i+1:]...)
+		if rest != "" {
+			target = path.Join(target, rest)
+		}
+		return f.walk(target, follow, links)
+	}
+	return e, nil
+}
+
+// find returns the entry named name. Without an extension that gives exact
+// names, the names match without regard to case.
+func (f *FS) find(entries []*dirent, name string) *dirent {
+	for _, e := range entries {
+		if e.name == name {
+			return e
+		}
+	}
+	if f.ext == NoExtension {
+		for _, e := range entries {
+			if strings.EqualFold(e.name, name) {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// Open opens the named file or directory for reading.
+func (f *FS) Open(name string) (fs.File, error) {
+	e, err := f.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return &File{f: f, name: name, e: e}, nil
+}
+
+// Stat returns a FileInfo describing the named file, following symbolic
+// links.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	e, err := f.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return e.info(path.Base(name)), nil
+}
+
+// Lstat is Stat, but describes a symbolic link rather than its target.
+func (f *FS) Lstat(name string) (fs.FileInfo, error) {
+	e, err := f.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return e.info(path.Base(name)), nil
+}
+
+// ReadLink returns the target of the named symbolic link.
+func (f *FS) ReadLink(name string) (string, error) {
+	e, err := f.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if e.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return e.link, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := f.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !e.isDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries, err := f.dirEntries(e)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+func (f *FS) dirEntries(dir *dirent) ([]fs.DirEntry, error) {
+	d, err := f.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.DirEntry, len(d))
+	for i, e := range d {
+		entries[i] = e.info(e.name)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
@@ -0,0 +1,190 @@
+package iso9660
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// The image in testdata was made like genisoimage -J -R makes them. Rock
+// Ridge names the files; the Joliet tree has the same files but for the
+// symbolic link, with the long name cut to 64 characters. The directories
+// nest nine levels deep, so d1/.../d8 is relocated to rr_moved, and
+// split.bin is recorded in two extents.
+const image = "rockridge.iso.gz"
+
+var longName = strings.Repeat("x", 200) + ".txt"
+
+func openImage(t *testing.T, opts Options) *FS {
+	t.Helper()
+	f, err := OpenWith(bytes.NewReader(readImage(t)), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// readImage returns the uncompressed test image.
+func readImage(t *testing.T) []byte {
+	t.Helper()
+	src, err := os.Open(filepath.Join("testdata", image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	zr, err := gzip.NewReader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func pattern(n, mul int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * mul % 251)
+	}
+	return b
+}
+
+func TestRockRidge(t *testing.T) {
+	f := openImage(t, Options{})
+	if f.Extension() != RockRidge || f.Label() != "OPTICAL_TEST" {
+		t.Errorf("extension %s, label %q", f.Extension(), f.Label())
+	}
+	if want := time.Date(2024, 3, 14, 15, 9, 26, 0, time.FixedZone("", 2*3600)); !f.Created().Equal(want) {
+		t.Errorf("created %v", f.Created())
+	}
+	deep := "d1/d2/d3/d4/d5/d6/d7/d8/d9/deep.txt"
+	if err := fstest.TestFS(f, "readme.txt", "A long file name.txt", "docs/Résumé.txt", "docs/sub/deep.bin",
+		"split.bin", "script.sh", longName, deep); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string][]byte{
+		"readme.txt":           []byte("hello, optical world\n"),
+		"A long file name.txt": pattern(5000, 7),
+		"split.bin":            pattern(5096, 11),
+		longName:               []byte("long name\n"),
+		deep:                   []byte("nine levels down\n"),
+		"link":                 []byte("Résumé\n"),
+	} {
+		if b, err := fs.ReadFile(f, name); err != nil || !bytes.Equal(b, want) {
+			t.Errorf("%s: %d bytes, %v", name, len(b), err)
+		}
+	}
+
+	info, err := f.Stat("script.sh")
+	if err != nil || info.Mode() != 0o755 {
+		t.Fatalf("script.sh: %v, %v", info, err)
+	}
+	if want := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC); !info.ModTime().Equal(want) {
+		t.Errorf("modification time %v", info.ModTime())
+	}
+	if info, _ := f.Stat("readme.txt"); info.Mode() != 0o444 {
+		t.Errorf("readme.txt mode %v", info.Mode())
+	}
+
+	if target, err := f.ReadLink("link"); err != nil || target != "docs/Résumé.txt" {
+		t.Errorf("link to %q, %v", target, err)
+	}
+	if info, err := f.Lstat("link"); err != nil || info.Mode().Type() != fs.ModeSymlink {
+		t.Errorf("link: %v, %v", info, err)
+	}
+	if info, err := f.Stat("link"); err != nil || !info.Mode().IsRegular() || info.Size() != int64(len("Résumé\n")) {
+		t.Errorf("link target: %v, %v", info, err)
+	}
+	if _, err := f.ReadLink("readme.txt"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("ReadLink of a file: %v", err)
+	}
+
+	// The relocated directory is only listed where it belongs.
+	if entries, err := f.ReadDir("rr_moved"); err != nil || len(entries) != 0 {
+		t.Errorf("rr_moved: %v, %v", entries, err)
+	}
+	if entries, err := f.ReadDir("d1/d2/d3/d4/d5/d6/d7"); err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		t.Errorf("d7: %v, %v", entries, err)
+	}
+	// Rock Ridge names are exact.
+	if _, err := f.Stat("README.TXT"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("README.TXT: %v", err)
+	}
+}
+
+func TestJoliet(t *testing.T) {
+	f := openImage(t, Options{NoRockRidge: true})
+	if f.Extension() != Joliet || f.Label() != "Optical Test" {
+		t.Errorf("extension %s, label %q", f.Extension(), f.Label())
+	}
+	if err := fstest.TestFS(f, "readme.txt", "A long file name.txt", "docs/Résumé.txt", "split.bin",
+		longName[:64], "d1/d2/d3/d4/d5/d6/d7/d8/d9/deep.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Stat("link"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("link: %v", err)
+	}
+	info, err := f.Stat("readme.txt")
+	if err != nil || info.Mode() != 0o444 {
+		t.Fatalf("readme.txt: %v, %v", info, err)
+	}
+	if want := time.Date(2024, 3, 14, 13, 9, 26, 0, time.UTC); !info.ModTime().Equal(want) {
+		t.Errorf("modification time %v", info.ModTime())
+	}
+}
+
+func TestISO9660(t *testing.T) {
+	f := openImage(t, Options{NoRockRidge: true, NoJoliet: true})
+	if f.Extension() != NoExtension || f.Label() != "OPTICAL_TEST" {
+		t.Errorf("extension %s, label %q", f.Extension(), f.Label())
+	}
+	if err := fstest.TestFS(f, "README.TXT", "A_LONG_F.TXT", "DOCS/RSUM.TXT", "SPLIT.BIN", "RR_MOVED/D8/D9/DEEP.TXT"); err != nil {
+		t.Fatal(err)
+	}
+	// Names match without regard to case; the link is an empty file.
+	if b, err := fs.ReadFile(f, "docs/rsum.txt"); err != nil || string(b) != "Résumé\n" {
+		t.Errorf("docs/rsum.txt: %q, %v", b, err)
+	}
+	if info, err := f.Stat("LINK"); err != nil || info.Size() != 0 || !info.Mode().IsRegular() {
+		t.Errorf("LINK: %v, %v", info, err)
+	}
+}
+
+func TestOpenNotISO9660(t *testing.T) {
+	if _, err := Open(bytes.NewReader(make([]byte, 40*SectorSize))); !errors.Is(err, ErrNotISO9660) {
+		t.Errorf("zeros: %v", err)
+	}
+	if _, err := Open(bytes.NewReader(make([]byte, 1024))); !errors.Is(err, ErrNotISO9660) {
+		t.Errorf("short device: %v", err)
+	}
+}
+
+// A record whose name runs past its end is corrupt, however long the name.
+func TestCorruptRecord(t *testing.T) {
+	img := readImage(t)
+	pvd := img[16*SectorSize:]
+	root := img[int(binary.LittleEndian.Uint32(pvd[158:]))*SectorSize:]
+	// Skip "." and ".." and give the first file a name of 255 bytes.
+	pos := int(root[0])
+	pos += int(root[pos])
+	root[pos+32] = 0xff
+	f, err := Open(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadDir(f, "."); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// maxContinuations bounds the continuation areas of one record, against
+// loops in corrupt file systems.
+const maxContinuations = 16
+
+// rockRidge collects the Rock Ridge entries of a directory record.
+type rockRidge struct {
+	name    string
+	hasMode bool
+	mode    uint32 // POSIX mode
+	mtime   time.Time
+
+	link     strings.Builder
+	hasLink  bool
+	linkNext bool // the last component continues in the next SL entry
+
+	relocated bool   // RE: the directory is listed where it belongs, by CL
+	child     uint32 // CL: the sector of the directory moved elsewhere
+}
+
+// susp walks the entries of the System Use Sharing Protocol in the system
+// use area of a record and in its continuation areas.
+func (f *FS) susp(rr *rockRidge, su []byte) error {
+	for hops := 0; ; hops++ {
+		var next []byte // the continuation area, if any
+		for len(su) >= 4 {
+			sig, n := string(su[:2]), int(su[2])
+			if n < 4 || n > len(su) {
+				return errors.New("invalid system use entry")
+			}
+			data := su[4:n]
+			su = su[n:]
+			switch sig {
+			case "ST":
+				su = nil
+			case "CE":
+				if len(data) < 24 {
+					return errors.New("short CE entry")
+				}
+				lba, off, length := binary.LittleEndian.Uint32(data), binary.LittleEndian.Uint32(data[8:]), binary.LittleEndian.Uint32(data[16:])
+				if length > SectorSize || off+length > SectorSize {
+					return errors.New("continuation area beyond its sector")
+				}
+				next = make([]byte, length)
+				if _, err := f.dev.ReadAt(next, int64(lba)*SectorSize+int64(off)); err != nil {
+					return err
+				}
+			case "NM":
+				if len(data) >= 1 && data[0]&0x06 == 0 { // not "." or ".."
+					rr.name += string(data[1:])
+				}
+			case "PX":
+				if len(data) >= 4 {
+					rr.mode, rr.hasMode = binary.LittleEndian.Uint32(data), true
+				}
+			case "TF":
+				rr.timestamps(data)
+			case "SL":
+				if len(data) >= 1 {
+					rr.symlink(data[1:])
+				}
+			case "RE":
+				rr.relocated = true
+			case "CL":
+				if len(data) >= 4 {
+					rr.child = binary.LittleEndian.Uint32(data)
+				}
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		if hops == maxContinuations {
+			return errors.New("too many continuation areas")
+		}
+		su = next
+	}
+}
+
+// timestamps decodes a TF entry, keeping the modification time.
+func (rr *rockRidge) timestamps(data []byte) {
+	if len(data) < 1 {
+		return
+	}
+	flags, stamps := data[0], data[1:]
+	size := 7
+	if flags&0x80 != 0 {
+		size = 17
+	}
+	// The time stamps present, in order: creation, modification, ...
+	if flags&0x02 == 0 {
+		return
+	}
+	if flags&0x01 != 0 {
+		stamps = stamps[min(size, len(stamps)):]
+	}
+	if len(stamps) < size {
+		return
+	}
+	if size == 17 {
+		rr.mtime = decDate(stamps[:size])
+	} else {
+		rr.mtime = recordDate(stamps[:size])
+	}
+}
+
+// symlink adds the components of an SL entry to the link target.
+func (rr *rockRidge) symlink(comps []byte) {
+	rr.hasLink = true
+	for len(comps) >= 2 && len(comps) >= 2+int(comps[1]) {
+		flags, text := comps[0], string(comps[2:2+int(comps[1])])
+		comps = comps[2+int(comps[1]):]
+		if rr.link.Len() > 0 && !rr.linkNext && !strings.HasSuffix(rr.link.String(), "/") {
+			rr.link.WriteByte('/')
+		}
+		switch {
+		case flags&0x02 != 0:
+			text = "."
+		case flags&0x04 != 0:
+			text = ".."
+		case flags&0x08 != 0:
+			text = "/"
+		}
+		rr.link.WriteString(text)
+		rr.linkNext = flags&0x01 != 0
+	}
+}
+
+// apply sets the name and attributes of e from its Rock Ridge entries.
+func (rr *rockRidge) apply(e *dirent) {
+	if rr.name != "" {
+		e.name = rr.name
+	}
+	if rr.hasMode {
+		e.mode = posixMode(rr.mode)
+		if e.isDir() {
+			e.mode = fs.ModeDir | e.mode.Perm()
+		}
+	}
+	if !rr.mtime.IsZero() {
+		e.mtime = rr.mtime
+	}
+	if rr.hasLink && !e.isDir() {
+		e.mode = fs.ModeSymlink | e.mode.Perm()
+		e.link = rr.link.String()
+	}
+}
+
+// posixMode converts the file mode of PX.
+func posixMode(m uint32) fs.FileMode {
+	mode := fs.FileMode(m & 0o777)
+	switch m & 0o170000 {
+	case 0o040000:
+		mode |= fs.ModeDir
+	case 0o120000:
+		mode |= fs.ModeSymlink
+	case 0o020000:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case 0o060000:
+		mode |= fs.ModeDevice
+	case 0o010000:
+		mode |= fs.ModeNamedPipe
+	case 0o140000:
+		mode |= fs.ModeSocket
+	}
+	if m&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if m&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if m&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
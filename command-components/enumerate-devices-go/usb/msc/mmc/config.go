@@ -0,0 +1,259 @@
+package mmc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"example.com/usb/msc/scsi"
+)
+
+// Profile is a kind of medium, as the drive sees it. The current profile
+// names the medium loaded, ProfileNone if there is none.
+type Profile uint16
+
+const (
+	ProfileNone            Profile = 0x0000
+	ProfileRemovableDisk   Profile = 0x0002
+	ProfileCDROM           Profile = 0x0008
+	ProfileCDR             Profile = 0x0009
+	ProfileCDRW            Profile = 0x000a
+	ProfileDVDROM          Profile = 0x0010
+	ProfileDVDR            Profile = 0x0011 // sequential recording
+	ProfileDVDRAM          Profile = 0x0012
+	ProfileDVDRWRestricted Profile = 0x0013 // restricted overwrite
+	ProfileDVDRWSeq        Profile = 0x0014 // sequential recording
+	ProfileDVDRDLSeq       Profile = 0x0015
+	ProfileDVDRDLJump      Profile = 0x0016
+	ProfileDVDPlusRW       Profile = 0x001a
+	ProfileDVDPlusR        Profile = 0x001b
+	ProfileDVDPlusRWDL     Profile = 0x002a
+	ProfileDVDPlusRDL      Profile = 0x002b
+	ProfileBDROM           Profile = 0x0040
+	ProfileBDRSeq          Profile = 0x0041 // sequential recording mode
+	ProfileBDRRandom       Profile = 0x0042 // random recording mode
+	ProfileBDRE            Profile = 0x0043
+	ProfileNonConforming   Profile = 0xffff
+)
+
+var profileNames = map[Profile]string{
+	ProfileNone:            "no medium",
+	ProfileRemovableDisk:   "removable disk",
+	ProfileCDROM:           "CD-ROM",
+	ProfileCDR:             "CD-R",
+	ProfileCDRW:            "CD-RW",
+	ProfileDVDROM:          "DVD-ROM",
+	ProfileDVDR:            "DVD-R",
+	ProfileDVDRAM:          "DVD-RAM",
+	ProfileDVDRWRestricted: "DVD-RW (restricted overwrite)",
+	ProfileDVDRWSeq:        "DVD-RW",
+	ProfileDVDRDLSeq:       "DVD-R DL",
+	ProfileDVDRDLJump:      "DVD-R DL (layer jump)",
+	ProfileDVDPlusRW:       "DVD+RW",
+	ProfileDVDPlusR:        "DVD+R",
+	ProfileDVDPlusRWDL:     "DVD+RW DL",
+	ProfileDVDPlusRDL:      "DVD+R DL",
+	ProfileBDROM:           "BD-ROM",
+	ProfileBDRSeq:          "BD-R",
+	ProfileBDRRandom:       "BD-R (random recording)",
+	ProfileBDRE:            "BD-RE",
+	ProfileNonConforming:   "non-conforming",
+}
+
+func (p Profile) String() string {
+	if name, ok := profileNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("Profile(%#04x)", uint16(p))
+}
+
+// CD reports whether p is a CD profile.
+func (p Profile) CD() bool {
+	return p >= ProfileCDROM && p <= ProfileCDRW
+}
+
+// DVD reports whether p is a DVD profile.
+func (p Profile) DVD() bool {
+	return p >= ProfileDVDROM && p <= ProfileDVDPlusRDL
+}
+
+// BD reports whether p is a Blu-ray profile.
+func (p Profile) BD() bool {
+	return p >= ProfileBDROM && p <= ProfileBDRE
+}
+
+// Feature codes.
+const (
+	FeatureProfileList         = 0x0000
+	FeatureCore                = 0x0001
+	FeatureMorphing            = 0x0002
+	FeatureRemovableMedium     = 0x0003
+	FeatureWriteProtect        = 0x0004
+	FeatureRandomReadable      = 0x0010
+	FeatureMultiRead           = 0x001d
+	FeatureCDRead              = 0x001e
+	FeatureDVDRead             = 0x001f
+	FeatureRandomWritable      = 0x0020
+	FeatureIncrementalWrite    = 0x0021
+	FeatureFormattable         = 0x0023
+	FeatureDefectManagement    = 0x0024
+	FeatureWriteOnce           = 0x0025
+	FeatureRestrictedOverwrite = 0x0026
+	FeatureDVDPlusRW           = 0x002a
+	FeatureDVDPlusR            = 0x002b
+	FeatureCDTrackAtOnce       = 0x002d
+	FeatureCDMastering         = 0x002e
+	FeatureDVDRWrite           = 0x002f
+	FeatureBDRead              = 0x0040
+	FeatureBDWrite             = 0x0041
+	FeaturePowerManagement     = 0x0100
+	FeatureRealTimeStreaming   = 0x0107
+	FeatureSerialNumber        = 0x0108
+)
+
+// Feature is a feature descriptor of GET CONFIGURATION.
+type Feature struct {
+	Code       uint16
+	Version    uint8
+	Persistent bool // the feature is current whatever the medium
+	Current    bool // the feature is usable with the medium loaded
+	Data       []byte
+}
+
+// Types of GET CONFIGURATION requests.
+const (
+	RequestAll     = 0 // every feature from the starting one on
+	RequestCurrent = 1 // the current features from the starting one on
+	RequestOne     = 2 // the starting feature alone
+)
+
+// Configuration is the result of GET CONFIGURATION.
+type Configuration struct {
+	CurrentProfile Profile
+	Features       []Feature
+}
+
+// GetConfiguration sends GET CONFIGURATION for the features the request
+// type rt selects, starting with feature code start.
+func (dr *Drive) GetConfiguration(rt uint8, start uint16) (*Configuration, error) {
+	cb := make([]byte, 10)
+	cb[0] = scsi.OpGetConfiguration
+	cb[1] = rt & 0x03
+	binary.BigEndian.PutUint16(cb[2:], start)
+	b, err := dr.command(cb, 4, 8)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfiguration(b)
+}
+
+// ParseConfiguration decodes the response of GET CONFIGURATION, header
+// included.
+func ParseConfiguration(b []byte) (*Configuration, error) {
+	if len(b) < 8 {
+		return nil, fmt.Errorf("mmc: configuration of %d bytes", len(b))
+	}
+	c := &Configuration{CurrentProfile: Profile(binary.BigEndian.Uint16(b[6:]))}
+	for b = b[8:]; len(b) > 0; {
+		if len(b) < 4 || len(b) < 4+int(b[3]) {
+			return c, fmt.Errorf("mmc: truncated feature descriptor")
+		}
+		c.Features = append(c.Features, Feature{
+			Code:       binary.BigEndian.Uint16(b),
+			Version:    b[2] >> 2 & 0x0f,
+			Persistent: b[2]&0x02 != 0,
+			Current:    b[2]&0x01 != 0,
+			Data:       b[4 : 4+int(b[3])],
+		})
+		b = b[4+int(b[3]):]
+	}
+	return c, nil
+}
+
+// Feature returns the descriptor of a feature, if the drive reported it.
+func (c *Configuration) Feature(code uint16) (Feature, bool) {
+	for _, f := range c.Features {
+		if f.Code == code {
+			return f, true
+		}
+	}
+	return Feature{}, false
+}
+
+// Profiles returns the profiles of the Profile List feature: the media the
+// drive supports, and in current, those matching the medium loaded.
+func (c *Configuration) Profiles() (all, current []Profile) {
+	f, ok := c.Feature(FeatureProfileList)
+	if !ok {
+		return nil, nil
+	}
+	for b := f.Data; len(b) >= 4; b = b[4:] {
+		p := Profile(binary.BigEndian.Uint16(b))
+		all = append(all, p)
+		if b[2]&0x01 != 0 {
+			current = append(current, p)
+		}
+	}
+	return all, current
+}
+
+// Loading mechanisms of the Removable Medium feature.
+type Loading uint8
+
+const (
+	LoadingCaddy      Loading = 0
+	LoadingTray       Loading = 1
+	LoadingPopUp      Loading = 2
+	LoadingChanger    Loading = 4 // individually changeable discs
+	LoadingMagazine   Loading = 5 // changer with a magazine
+	LoadingNotPresent Loading = 0xff
+)
+
+func (l Loading) String() string {
+	switch l {
+	case LoadingCaddy:
+		return "caddy"
+	case LoadingTray:
+		return "tray"
+	case LoadingPopUp:
+		return "pop-up"
+	case LoadingChanger:
+		return "changer"
+	case LoadingMagazine:
+		return "magazine changer"
+	case LoadingNotPresent:
+		return "not removable"
+	}
+	return fmt.Sprintf("Loading(%d)", uint8(l))
+}
+
+// RemovableMedium returns how the drive loads media, and whether it can
+// eject them and lock them in, from the Removable Medium feature. Drives
+// without the feature report LoadingNotPresent.
+func (c *Configuration) RemovableMedium() (mechanism Loading, eject, lock bool) {
+	f, ok := c.Feature(FeatureRemovableMedium)
+	if !ok || len(f.Data) < 1 {
+		return LoadingNotPresent, false, false
+	}
+	return Loading(f.Data[0] >> 5), f.Data[0]&0x08 != 0, f.Data[0]&0x01 != 0
+}
+
+// SerialNumber returns the serial number of the drive from the Drive Serial
+// Number feature, or "" without it.
+func (c *Configuration) SerialNumber() string {
+	f, ok := c.Feature(FeatureSerialNumber)
+	if !ok {
+		return ""
+	}
+	return strings.Trim(string(f.Data), " \x00")
+}
+
+// CurrentProfile returns the profile of the medium loaded, with a GET
+// CONFIGURATION that asks for no more than the header and the profile list.
+func (dr *Drive) CurrentProfile() (Profile, error) {
+	c, err := dr.GetConfiguration(RequestOne, FeatureProfileList)
+	if err != nil {
+		return ProfileNone, err
+	}
+	return c.CurrentProfile, nil
+}
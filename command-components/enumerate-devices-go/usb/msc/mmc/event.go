@@ -0,0 +1,131 @@
+package mmc
+
+import (
+	"fmt"
+
+	"example.com/usb/msc/scsi"
+)
+
+// EventClass is a notification class of GET EVENT STATUS NOTIFICATION.
+type EventClass uint8
+
+const (
+	ClassOperationalChange EventClass = 1
+	ClassPowerManagement   EventClass = 2
+	ClassExternalRequest   EventClass = 3
+	ClassMedia             EventClass = 4
+	ClassMultipleHosts     EventClass = 5
+	ClassDeviceBusy        EventClass = 6
+)
+
+func (c EventClass) String() string {
+	switch c {
+	case ClassOperationalChange:
+		return "operational change"
+	case ClassPowerManagement:
+		return "power management"
+	case ClassExternalRequest:
+		return "external request"
+	case ClassMedia:
+		return "media"
+	case ClassMultipleHosts:
+		return "multiple hosts"
+	case ClassDeviceBusy:
+		return "device busy"
+	}
+	return fmt.Sprintf("EventClass(%d)", uint8(c))
+}
+
+// Event is the response to GET EVENT STATUS NOTIFICATION: the oldest event
+// of the classes asked for, which the drive then forgets.
+type Event struct {
+	// None is set if no event of the classes asked for is pending, and
+	// Class and Data are then meaningless.
+	None  bool
+	Class EventClass
+	// Supported is the set of classes the drive reports, bit n for class n.
+	Supported uint8
+	Data      []byte // the event descriptor
+}
+
+// GetEventStatus sends GET EVENT STATUS NOTIFICATION in polled mode for the
+// given classes. Drives that do not support polling fail with ILLEGAL
+// REQUEST.
+func (dr *Drive) GetEventStatus(classes ...EventClass) (*Event, error) {
+	cb := make([]byte, 10)
+	cb[0] = scsi.OpGetEventStatus
+	cb[1] = 0x01 // polled
+	for _, c := range classes {
+		cb[4] |= 1 << c
+	}
+	b, err := dr.command(cb, 2, 4)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{
+		None:      b[2]&0x80 != 0,
+		Class:     EventClass(b[2] & 0x07),
+		Supported: b[3],
+		Data:      b[4:],
+	}, nil
+}
+
+// MediaEventCode is the event of a media event.
+type MediaEventCode uint8
+
+const (
+	MediaNoChange       MediaEventCode = 0
+	MediaEjectRequest   MediaEventCode = 1 // the eject button was pressed
+	MediaNew            MediaEventCode = 2
+	MediaRemoval        MediaEventCode = 3
+	MediaChanged        MediaEventCode = 4
+	MediaFormatComplete MediaEventCode = 5 // background format
+	MediaFormatRestart  MediaEventCode = 6
+)
+
+func (e MediaEventCode) String() string {
+	switch e {
+	case MediaNoChange:
+		return "no change"
+	case MediaEjectRequest:
+		return "eject request"
+	case MediaNew:
+		return "new media"
+	case MediaRemoval:
+		return "media removal"
+	case MediaChanged:
+		return "media changed"
+	case MediaFormatComplete:
+		return "background format completed"
+	case MediaFormatRestart:
+		return "background format restarted"
+	}
+	return fmt.Sprintf("MediaEventCode(%d)", uint8(e))
+}
+
+// MediaStatus is the state of the tray and the medium, from a media event.
+type MediaStatus struct {
+	Event        MediaEventCode
+	TrayOpen     bool
+	MediaPresent bool
+}
+
+// MediaStatus polls the media event class. The status is current even if
+// no event is pending; the event is reported once.
+func (dr *Drive) MediaStatus() (MediaStatus, error) {
+	e, err := dr.GetEventStatus(ClassMedia)
+	if err != nil {
+		return MediaStatus{}, err
+	}
+	if e.Supported&(1<<ClassMedia) == 0 {
+		return MediaStatus{}, fmt.Errorf("mmc: drive does not report media events")
+	}
+	if e.Class != ClassMedia || len(e.Data) < 2 {
+		return MediaStatus{}, fmt.Errorf("mmc: media event of class %s and %d bytes", e.Class, len(e.Data))
+	}
+	return MediaStatus{
+		Event:        MediaEventCode(e.Data[0] & 0x0f),
+		TrayOpen:     e.Data[1]&0x01 != 0,
+		MediaPresent: e.Data[1]&0x02 != 0,
+	}, nil
+}
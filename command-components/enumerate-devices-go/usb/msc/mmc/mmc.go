@@ -0,0 +1,112 @@
+// Package mmc sends the MMC commands of CD, DVD and Blu-ray drives, which
+// report themselves as mass storage devices of type scsi.DeviceTypeCDDVD.
+//
+// A Drive reads the configuration of the drive and the profile of its
+// medium, the table of contents of CDs, and the tray and media events. It
+// is also an msc.Medium of 2048-byte sectors, read with READ(12), so that
+// msc.NewBlockDevice gives the byte-addressed view the iso9660 and udf
+// packages read.
+package mmc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"example.com/usb"
+	"example.com/usb/msc/scsi"
+)
+
+// SectorSize is the size of the user data of a sector of every optical
+// medium, the Mode 1 sectors of data CDs included.
+const SectorSize = 2048
+
+// ErrReadOnly is returned by Write: a Drive does not write media.
+var ErrReadOnly = errors.New("mmc: writing media is not supported")
+
+// Drive sends MMC commands to an optical drive.
+type Drive struct {
+	d *scsi.Device
+}
+
+// New returns a Drive for the logical unit of d.
+func New(d *scsi.Device) *Drive {
+	return &Drive{d: d}
+}
+
+// Device returns the logical unit the drive sends its commands to.
+func (dr *Drive) Device() *scsi.Device {
+	return dr.d
+}
+
+// ReadCapacity returns the number of readable sectors of the medium. The
+// block size is always SectorSize: some drives report the 2352 bytes of raw
+// CD sectors, or nothing at all.
+func (dr *Drive) ReadCapacity() (scsi.Capacity, error) {
+	c, err := dr.d.ReadCapacity10()
+	if err != nil {
+		return c, err
+	}
+	c.BlockSize = SectorSize
+	return c, nil
+}
+
+// Read reads len(buf)/SectorSize sectors starting at lba with READ(12).
+func (dr *Drive) Read(lba uint64, buf []byte) error {
+	if len(buf)%SectorSize != 0 {
+		return fmt.Errorf("mmc: buffer of %d bytes is not a multiple of the sector size", len(buf))
+	}
+	if lba+uint64(len(buf)/SectorSize) > 1<<32 {
+		return fmt.Errorf("mmc: read of sector %d beyond the 32-bit addresses of READ(12)", lba)
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	cb := scsi.Read12(uint32(lba), uint32(len(buf)/SectorSize))
+	n, err := dr.d.Command(cb, usb.DirectionIn, buf)
+	if err == nil && n != len(buf) {
+		err = fmt.Errorf("mmc: READ(12) transferred %d of %d bytes", n, len(buf))
+	}
+	return err
+}
+
+// Write returns ErrReadOnly.
+func (dr *Drive) Write(lba uint64, buf []byte) error {
+	return ErrReadOnly
+}
+
+// SynchronizeCache does nothing: nothing is written.
+func (dr *Drive) SynchronizeCache() error {
+	return nil
+}
+
+// command sends a command that returns a parameter list of at least header
+// bytes, whose first field bytes hold the big-endian length of the rest. It
+// asks again with a larger allocation length, the uint16 at cb[7:9], if the
+// list did not fit.
+func (dr *Drive) command(cb []byte, field, header int) ([]byte, error) {
+	alloc := 252
+	for {
+		buf := make([]byte, alloc)
+		binary.BigEndian.PutUint16(cb[7:], uint16(alloc))
+		n, err := dr.d.Command(cb, usb.DirectionIn, buf)
+		if err != nil {
+			return nil, err
+		}
+		total := field
+		switch field {
+		case 2:
+			total += int(binary.BigEndian.Uint16(buf))
+		case 4:
+			total += int(binary.BigEndian.Uint32(buf))
+		}
+		if n < header || total < header {
+			return nil, fmt.Errorf("mmc: %s returned %d bytes, of %d", scsi.CommandName(cb), n, total)
+		}
+		if total <= n || n < alloc || alloc == 0xfffe {
+			return buf[:min(total, n)], nil
+		}
+		// Allocation lengths stay even: some drives reject odd ones.
+		alloc = min(total+total%2, 0xfffe)
+	}
+}
@@ -0,0 +1,308 @@
+package mmc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"example.com/usb"
+	"example.com/usb/msc"
+	"example.com/usb/msc/scsi"
+)
+
+// drive emulates a DVD writer with a two-session CD-RW loaded: an audio
+// track, then a data track of 300 sectors in the second session. Unknown
+// commands fail with ILLEGAL REQUEST.
+type drive struct {
+	data     []byte // the sectors of the data track
+	trayOpen bool
+	events   []MediaEventCode
+
+	commands [][]byte
+}
+
+const dataTrack = 1000 // the first sector of the data track
+
+func newDrive() *drive {
+	d := &drive{data: make([]byte, 300*SectorSize)}
+	for i := range d.data {
+		d.data[i] = byte(i / SectorSize)
+	}
+	return d
+}
+
+func (d *drive) Command(lun uint8, cb []byte, dir usb.Direction, data []byte) (scsi.Completion, error) {
+	d.commands = append(d.commands, append([]byte(nil), cb...))
+	var resp []byte
+	switch cb[0] {
+	case scsi.OpReadCapacity10:
+		// Like some drives, report the size of raw sectors.
+		resp = binary.BigEndian.AppendUint32(nil, dataTrack+300-1)
+		resp = binary.BigEndian.AppendUint32(resp, 2352)
+	case scsi.OpRead12:
+		lba, count := binary.BigEndian.Uint32(cb[2:]), binary.BigEndian.Uint32(cb[6:])
+		if lba < dataTrack || lba+count > dataTrack+300 {
+			return checkCondition(0x05, 0x21), nil
+		}
+		resp = d.data[(lba-dataTrack)*SectorSize : (lba-dataTrack+count)*SectorSize]
+	case scsi.OpGetConfiguration:
+		resp = d.configuration(cb[1]&0x03, binary.BigEndian.Uint16(cb[2:]))
+	case scsi.OpReadTOC:
+		resp = d.toc(cb[2] & 0x0f)
+	case scsi.OpGetEventStatus:
+		if cb[1]&0x01 == 0 {
+			return checkCondition(0x05, 0x24), nil
+		}
+		header := []byte{uint8(ClassMedia), 1<<ClassOperationalChange | 1<<ClassMedia}
+		if cb[4]&(1<<ClassMedia) == 0 {
+			header[0] |= 0x80
+			resp = withLength(2, header, nil)
+			break
+		}
+		event := MediaNoChange
+		if len(d.events) > 0 {
+			event, d.events = d.events[0], d.events[1:]
+		}
+		status := uint8(0x02)
+		if d.trayOpen {
+			status = 0x01
+		}
+		resp = withLength(2, header, []byte{uint8(event), status, 0, 0})
+	default:
+		return checkCondition(0x05, 0x20), nil
+	}
+	// The responses are cut to the allocation length, and the residue
+	// reports what was not transferred.
+	n := copy(data, resp)
+	return scsi.Completion{Residue: uint32(len(data) - n)}, nil
+}
+
+func checkCondition(key, asc uint8) scsi.Completion {
+	sense := make([]byte, 18)
+	sense[0], sense[2], sense[7], sense[12] = 0x70, key, 10, asc
+	return scsi.Completion{Status: scsi.StatusCheckCondition, Sense: sense}
+}
+
+// withLength prefixes b with its length in a field of size bytes, and the
+// header bytes that follow the field.
+func withLength(size int, header []byte, b []byte) []byte {
+	b = append(append([]byte(nil), header...), b...)
+	if size == 2 {
+		return append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...)
+	}
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(b))), b...)
+}
+
+func (d *drive) configuration(rt uint8, start uint16) []byte {
+	profile := ProfileCDRW
+	if d.trayOpen {
+		profile = ProfileNone
+	}
+	features := [][]byte{
+		// Profile List: DVD+RW, DVD-ROM, CD-RW current, CD-ROM.
+		{0x00, 0x00, 0x03, 16, 0x00, 0x1a, 0, 0, 0x00, 0x10, 0, 0, 0x00, 0x0a, 1, 0, 0x00, 0x08, 0, 0},
+		{0x00, 0x01, 0x0b, 8, 0, 0, 0, 8, 1, 0, 0, 0}, // Core: USB
+		{0x00, 0x03, 0x03, 4, 0x29, 0, 0, 0},          // Removable Medium: tray, eject, lock
+		{0x00, 0x1e, 0x09, 4, 0, 0, 0, 0},             // CD Read, current
+		{0x00, 0x1f, 0x04, 4, 0, 0, 0, 0},             // DVD Read, not current
+		// Drive Serial Number, padded.
+		append([]byte{0x01, 0x08, 0x03, 12}, "KZ4A1234\x00\x00\x00\x00"...),
+	}
+	var b []byte
+	for _, f := range features {
+		code := binary.BigEndian.Uint16(f)
+		switch {
+		case code < start,
+			rt == RequestCurrent && f[2]&0x01 == 0,
+			rt == RequestOne && code != start:
+			continue
+		}
+		b = append(b, f...)
+	}
+	return withLength(4, []byte{0, 0, 0, uint8(profile)}, b)
+}
+
+func (d *drive) toc(format uint8) []byte {
+	switch format {
+	case FormatTOC:
+		return withLength(2, []byte{1, 2}, []byte{
+			0, 0x10, 1, 0, 0, 0, 0, 0, // audio track 1 at 0
+			0, 0x14, 2, 0, 0, 0, dataTrack >> 8, dataTrack & 0xff,
+			0, 0x14, LeadOut, 0, 0, 0, (dataTrack + 300) >> 8, (dataTrack + 300) & 0xff,
+		})
+	case FormatSessionInfo:
+		return withLength(2, []byte{1, 2}, []byte{0, 0x14, 2, 0, 0, 0, dataTrack >> 8, dataTrack & 0xff})
+	case FormatFullTOC:
+		return withLength(2, []byte{1, 2}, []byte{
+			1, 0x10, 0, 0xa0, 0, 0, 0, 0, 1, 0x00, 0, // first track 1, CD-DA
+			1, 0x10, 0, 1, 0, 0, 0, 0, 0, 2, 0, // track 1 at 00:02:00
+			2, 0x14, 0, 2, 0, 0, 0, 0, 0, 15, 25, // track 2 at LBA 1000
+		})
+	case FormatPMA:
+		return withLength(2, []byte{0, 0}, nil)
+	case FormatATIP:
+		return withLength(2, []byte{0, 0}, []byte{0xd0, 0x00, 0xc0, 0, 97, 34, 24, 0, 79, 59, 74, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	}
+	return nil
+}
+
+func TestConfiguration(t *testing.T) {
+	dr := New(scsi.New(newDrive(), 0))
+	c, err := dr.GetConfiguration(RequestAll, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.CurrentProfile != ProfileCDRW || !c.CurrentProfile.CD() || c.CurrentProfile.DVD() || len(c.Features) != 6 {
+		t.Fatalf("configuration %+v", c)
+	}
+	all, current := c.Profiles()
+	if len(all) != 4 || all[0] != ProfileDVDPlusRW || len(current) != 1 || current[0] != ProfileCDRW {
+		t.Errorf("profiles %v, current %v", all, current)
+	}
+	if mech, eject, lock := c.RemovableMedium(); mech != LoadingTray || !eject || !lock {
+		t.Errorf("removable medium: %s, eject %v, lock %v", mech, eject, lock)
+	}
+	if f, ok := c.Feature(FeatureDVDRead); !ok || f.Current || f.Persistent || f.Version != 1 {
+		t.Errorf("DVD Read %+v", f)
+	}
+	if s := c.SerialNumber(); s != "KZ4A1234" {
+		t.Errorf("serial number %q", s)
+	}
+
+	c, err = dr.GetConfiguration(RequestCurrent, FeatureCDRead)
+	if err != nil || len(c.Features) != 2 || c.Features[0].Code != FeatureCDRead || c.Features[1].Code != FeatureSerialNumber {
+		t.Fatalf("current features from CD Read: %+v, %v", c, err)
+	}
+	if p, err := dr.CurrentProfile(); err != nil || p != ProfileCDRW {
+		t.Errorf("current profile %s, %v", p, err)
+	}
+	if s := Profile(0x1234).String(); s != "Profile(0x1234)" {
+		t.Errorf("unknown profile %s", s)
+	}
+}
+
+func TestTOC(t *testing.T) {
+	dr := New(scsi.New(newDrive(), 0))
+	toc, err := dr.ReadTOC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if toc.FirstTrack != 1 || toc.LastTrack != 2 || len(toc.Tracks) != 3 {
+		t.Fatalf("TOC %+v", toc)
+	}
+	if tr := toc.Tracks[0]; tr.Data() || tr.ADR != 1 || tr.Start != 0 {
+		t.Errorf("track 1 %+v", tr)
+	}
+	if tr := toc.Tracks[1]; !tr.Data() || tr.Start != dataTrack {
+		t.Errorf("track 2 %+v", tr)
+	}
+	if end, ok := toc.LeadOut(); !ok || end != dataTrack+300 {
+		t.Errorf("lead-out %d, %v", end, ok)
+	}
+
+	s, err := dr.ReadSessionInfo()
+	if err != nil || s.FirstSession != 1 || s.LastSession != 2 || s.LastSessionTrack.Number != 2 || s.LastSessionTrack.Start != dataTrack {
+		t.Errorf("session info %+v, %v", s, err)
+	}
+
+	entries, err := dr.ReadFullTOC(1)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("full TOC %+v, %v", entries, err)
+	}
+	if e := entries[2]; e.Session != 2 || e.Point != 2 || e.PTime.LBA() != dataTrack {
+		t.Errorf("track 2 entry %+v at %d", e, e.PTime.LBA())
+	}
+	if entries, err := dr.ReadPMA(); err != nil || len(entries) != 0 {
+		t.Errorf("PMA %+v, %v", entries, err)
+	}
+
+	atip, err := dr.ReadATIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !atip.Rewritable || atip.WritingPower != 5 || atip.LeadInStart != (MSF{97, 34, 24}) || atip.LastLeadOut.String() != "79:59:74" {
+		t.Errorf("ATIP %+v", atip)
+	}
+}
+
+func TestMSF(t *testing.T) {
+	for _, tt := range []struct {
+		msf MSF
+		lba int32
+	}{
+		{MSF{0, 2, 0}, 0},
+		{MSF{0, 0, 0}, -150},
+		{MSF{79, 59, 74}, 359849},
+		{MSF{97, 34, 24}, -11076},
+		{MSF{99, 59, 74}, -151},
+	} {
+		if lba := tt.msf.LBA(); lba != tt.lba {
+			t.Errorf("%s: LBA %d, want %d", tt.msf, lba, tt.lba)
+		}
+		if msf := ToMSF(tt.lba); msf != tt.msf {
+			t.Errorf("LBA %d: %s, want %s", tt.lba, msf, tt.msf)
+		}
+	}
+}
+
+func TestMediaStatus(t *testing.T) {
+	d := newDrive()
+	dr := New(scsi.New(d, 0))
+	d.events = []MediaEventCode{MediaEjectRequest}
+	s, err := dr.MediaStatus()
+	if err != nil || s.Event != MediaEjectRequest || !s.MediaPresent || s.TrayOpen {
+		t.Fatalf("media status %+v, %v", s, err)
+	}
+	// The event is reported once, the status every time.
+	d.trayOpen = true
+	if s, err = dr.MediaStatus(); err != nil || s.Event != MediaNoChange || s.MediaPresent || !s.TrayOpen {
+		t.Fatalf("media status %+v, %v", s, err)
+	}
+	if p, _ := dr.CurrentProfile(); p != ProfileNone {
+		t.Errorf("profile %s with the tray open", p)
+	}
+
+	e, err := dr.GetEventStatus(ClassOperationalChange)
+	if err != nil || !e.None || e.Supported != 1<<ClassOperationalChange|1<<ClassMedia {
+		t.Fatalf("operational change: %+v, %v", e, err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	d := newDrive()
+	dr := New(scsi.New(d, 0))
+	c, err := dr.ReadCapacity()
+	if err != nil || c.Blocks != dataTrack+300 || c.BlockSize != SectorSize {
+		t.Fatalf("capacity %+v, %v", c, err)
+	}
+	buf := make([]byte, 3*SectorSize)
+	if err := dr.Read(dataTrack+10, buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf[0] != 10 || buf[len(buf)-1] != 12 {
+		t.Errorf("read sectors %d to %d", buf[0], buf[len(buf)-1])
+	}
+	if cb := d.commands[len(d.commands)-1]; cb[0] != scsi.OpRead12 || binary.BigEndian.Uint32(cb[6:]) != 3 {
+		t.Errorf("command block %x", cb)
+	}
+	if err := dr.Read(0, buf); !errors.Is(err, scsi.ErrLBAOutOfRange) {
+		t.Errorf("audio sectors: %v", err)
+	}
+	if err := dr.Write(0, buf); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("write: %v", err)
+	}
+
+	// A block device reads unaligned spans.
+	b, err := msc.NewBlockDevice(dr, msc.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 100)
+	if _, err := b.ReadAt(p, (dataTrack+5)*SectorSize-50); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, append(bytes.Repeat([]byte{4}, 50), bytes.Repeat([]byte{5}, 50)...)) {
+		t.Errorf("block device read %v", p)
+	}
+}
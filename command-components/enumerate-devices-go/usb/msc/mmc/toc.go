@@ -0,0 +1,236 @@
+package mmc
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"example.com/usb/msc/scsi"
+)
+
+// Formats of READ TOC/PMA/ATIP.
+const (
+	FormatTOC         = 0x0
+	FormatSessionInfo = 0x1
+	FormatFullTOC     = 0x2
+	FormatPMA         = 0x3
+	FormatATIP        = 0x4
+	FormatCDText      = 0x5
+)
+
+// LeadOut is the track number of the lead-out area in a TOC.
+const LeadOut = 0xaa
+
+// MSF is a CD address in minutes, seconds and frames of 1/75 s.
+type MSF struct {
+	M, S, F uint8
+}
+
+// LBA returns the logical block address of the MSF address. Addresses of
+// the lead-in, from 90 minutes on, are negative.
+func (m MSF) LBA() int32 {
+	n := (int32(m.M)*60+int32(m.S))*75 + int32(m.F)
+	if m.M >= 90 {
+		return n - 450150
+	}
+	return n - 150
+}
+
+func (m MSF) String() string {
+	return fmt.Sprintf("%02d:%02d:%02d", m.M, m.S, m.F)
+}
+
+// ToMSF returns the MSF address of a logical block address.
+func ToMSF(lba int32) MSF {
+	n := lba + 150
+	if lba < -150 {
+		n = lba + 450150
+	}
+	return MSF{M: uint8(n / (60 * 75)), S: uint8(n / 75 % 60), F: uint8(n % 75)}
+}
+
+// Track is a track descriptor of the TOC.
+type Track struct {
+	Number  uint8 // LeadOut for the lead-out area
+	ADR     uint8 // the kind of Q sub-channel information, 1 for positions
+	Control uint8
+	Start   uint32 // the LBA of the first sector
+}
+
+// Data reports whether the track holds data rather than audio.
+func (t Track) Data() bool {
+	return t.Control&0x04 != 0
+}
+
+// TOC is the table of contents of the medium, as READ TOC/PMA/ATIP reports
+// it in format 0. DVDs and BDs report one track per session.
+type TOC struct {
+	FirstTrack, LastTrack uint8
+	Tracks                []Track // the lead-out last
+}
+
+// LeadOut returns the start of the lead-out area, the end of the last track.
+func (t *TOC) LeadOut() (uint32, bool) {
+	for _, tr := range t.Tracks {
+		if tr.Number == LeadOut {
+			return tr.Start, true
+		}
+	}
+	return 0, false
+}
+
+// ReadTOCFormat sends READ TOC/PMA/ATIP in the given format and returns the
+// response, header included. With msf set, addresses are MSF rather than
+// LBA. track is the first track, or the session, the format starts with.
+func (dr *Drive) ReadTOCFormat(format uint8, msf bool, track uint8) ([]byte, error) {
+	cb := make([]byte, 10)
+	cb[0] = scsi.OpReadTOC
+	if msf {
+		cb[1] = 0x02
+	}
+	cb[2] = format & 0x0f
+	cb[6] = track
+	return dr.command(cb, 2, 4)
+}
+
+// ReadTOC returns the table of contents, starting with the first track.
+func (dr *Drive) ReadTOC() (*TOC, error) {
+	b, err := dr.ReadTOCFormat(FormatTOC, false, 1)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTOC(b)
+}
+
+// ParseTOC decodes the response to READ TOC/PMA/ATIP in format 0 with LBA
+// addresses.
+func ParseTOC(b []byte) (*TOC, error) {
+	if len(b) < 4 || (len(b)-4)%8 != 0 {
+		return nil, fmt.Errorf("mmc: TOC of %d bytes", len(b))
+	}
+	toc := &TOC{FirstTrack: b[2], LastTrack: b[3]}
+	for d := b[4:]; len(d) >= 8; d = d[8:] {
+		toc.Tracks = append(toc.Tracks, Track{
+			Number:  d[2],
+			ADR:     d[1] >> 4,
+			Control: d[1] & 0x0f,
+			Start:   binary.BigEndian.Uint32(d[4:]),
+		})
+	}
+	return toc, nil
+}
+
+// SessionInfo is format 1 of READ TOC/PMA/ATIP: the sessions of the medium
+// and the first track of the last one.
+type SessionInfo struct {
+	FirstSession, LastSession uint8
+	// LastSessionTrack is the first track of the last complete session.
+	// File systems of multisession discs start in that track.
+	LastSessionTrack Track
+}
+
+// ReadSessionInfo returns the session information.
+func (dr *Drive) ReadSessionInfo() (*SessionInfo, error) {
+	b, err := dr.ReadTOCFormat(FormatSessionInfo, false, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 12 {
+		return nil, fmt.Errorf("mmc: session information of %d bytes", len(b))
+	}
+	return &SessionInfo{
+		FirstSession: b[2],
+		LastSession:  b[3],
+		LastSessionTrack: Track{
+			Number:  b[6],
+			ADR:     b[5] >> 4,
+			Control: b[5] & 0x0f,
+			Start:   binary.BigEndian.Uint32(b[8:]),
+		},
+	}, nil
+}
+
+// QEntry is a descriptor of the full TOC or of the PMA, one Q sub-channel
+// entry of the lead-in or of the program memory area.
+type QEntry struct {
+	Session uint8 // 0 in the PMA
+	ADR     uint8
+	Control uint8
+	TNO     uint8
+	Point   uint8
+	ATime   MSF // the running time, or the additional information of the point
+	Zero    uint8
+	PTime   MSF // the position the point stands for
+}
+
+// ReadFullTOC returns the Q sub-channel entries of the lead-ins of the
+// sessions, starting with session.
+func (dr *Drive) ReadFullTOC(session uint8) ([]QEntry, error) {
+	b, err := dr.ReadTOCFormat(FormatFullTOC, true, session)
+	if err != nil {
+		return nil, err
+	}
+	return parseQEntries(b), nil
+}
+
+// ReadPMA returns the Q sub-channel entries of the program memory area of a
+// recordable CD, the tracks recorded in sessions not yet closed.
+func (dr *Drive) ReadPMA() ([]QEntry, error) {
+	b, err := dr.ReadTOCFormat(FormatPMA, true, 0)
+	if err != nil {
+		return nil, err
+	}
+	return parseQEntries(b), nil
+}
+
+func parseQEntries(b []byte) []QEntry {
+	var entries []QEntry
+	for d := b[4:]; len(d) >= 11; d = d[11:] {
+		entries = append(entries, QEntry{
+			Session: d[0],
+			ADR:     d[1] >> 4,
+			Control: d[1] & 0x0f,
+			TNO:     d[2],
+			Point:   d[3],
+			ATime:   MSF{d[4], d[5], d[6]},
+			Zero:    d[7],
+			PTime:   MSF{d[8], d[9], d[10]},
+		})
+	}
+	return entries
+}
+
+// ATIP is the Absolute Time In Pregroove of a recordable CD, recorded in
+// the groove of blank discs by their manufacturer.
+type ATIP struct {
+	WritingPower uint8 // the indicative target writing power, 0 to 7
+	Rewritable   bool  // CD-RW rather than CD-R
+	SubType      uint8
+	// LeadInStart is the start of the lead-in, which identifies the
+	// manufacturer of the disc, and LastLeadOut the last possible start of
+	// the lead-out, the capacity of the disc.
+	LeadInStart, LastLeadOut MSF
+}
+
+// ReadATIP returns the ATIP of the recordable CD loaded. Drives report
+// ILLEGAL REQUEST for other media.
+func (dr *Drive) ReadATIP() (*ATIP, error) {
+	b, err := dr.ReadTOCFormat(FormatATIP, true, 0)
+	if err != nil {
+		return nil, err
+	}
+	return ParseATIP(b)
+}
+
+// ParseATIP decodes the response to READ TOC/PMA/ATIP in format 4.
+func ParseATIP(b []byte) (*ATIP, error) {
+	if len(b) < 15 {
+		return nil, fmt.Errorf("mmc: ATIP of %d bytes", len(b))
+	}
+	return &ATIP{
+		WritingPower: b[4] >> 4 & 0x07,
+		Rewritable:   b[6]&0x40 != 0,
+		SubType:      b[6] >> 3 & 0x07,
+		LeadInStart:  MSF{b[8], b[9], b[10]},
+		LastLeadOut:  MSF{b[12], b[13], b[14]},
+	}, nil
+}
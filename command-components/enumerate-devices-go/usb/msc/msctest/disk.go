@@ -0,0 +1,335 @@
+// Package msctest provides an emulated USB mass storage device for testing
+// the mass storage drivers without hardware.
+package msctest
+
+import (
+	"testing"
+
+	"example.com/usb"
+	"example.com/usb/msc/bot"
+)
+
+// Endpoint addresses of the emulated bulk pipes.
+const (
+	EndpointIn  = 0x81
+	EndpointOut = 0x02
+)
+
+type state int
+
+const (
+	stateCommand state = iota
+	stateDataIn
+	stateDataOut
+	stateStatus
+)
+
+// Disk is an emulated Bulk-Only mass storage device with a SCSI command set.
+// It implements usb.Host. Commands that fail report CHECK CONDITION, with
+// the sense data available through REQUEST SENSE.
+//
+// The exported fields that are not LUNs inject faults and record what the
+// host did; they may be changed between calls.
+type Disk struct {
+	LUNs []*LUN
+
+	// StallGetMaxLUN makes the device stall GET MAX LUN, like many single
+	// LUN devices do.
+	StallGetMaxLUN bool
+	// StallCSW is the number of times reading the CSW stalls before the
+	// CSW is sent.
+	StallCSW int
+	// CSW, if set, rewrites the next CSW before it is sent.
+	CSW func(csw *bot.CSW)
+
+	// Commands holds the command blocks received, in order.
+	Commands [][]byte
+	// Resets counts the Bulk-Only Mass Storage Resets.
+	Resets int
+
+	descriptor usb.DeviceDescriptor
+	active     uint8
+	opened     bool
+
+	state      state
+	cbw        bot.CBW
+	response   []byte // the data IN stage still to send
+	received   []byte // the data OUT stage received so far
+	csw        bot.CSW
+	halted     map[uint8]bool
+	needsReset bool // after an invalid CBW, only a reset clears the halts
+}
+
+var _ usb.Host = (*Disk)(nil)
+
+// New returns an emulated device with one LUN of blocks blocks of blockSize
+// bytes.
+func New(blocks, blockSize int) *Disk {
+	return NewMulti(NewLUN(blocks, blockSize))
+}
+
+// NewMulti returns an emulated device with the given LUNs.
+func NewMulti(luns ...*LUN) *Disk {
+	return &Disk{
+		LUNs: luns,
+		descriptor: usb.DeviceDescriptor{
+			ProductName:      "Mass Storage Gadget",
+			ManufacturerName: "msctest",
+			SerialNumber:     "0123456789",
+			USBVersion:       usb.Version{Major: 2},
+			VendorID:         0x0525,
+			ProductID:        0xa4a5,
+			MaxPacketSize:    64,
+		},
+		active: 1,
+		halted: make(map[uint8]bool),
+	}
+}
+
+func (d *Disk) Descriptor() usb.DeviceDescriptor { return d.descriptor }
+func (d *Disk) Speed() usb.Speed                 { return usb.SpeedHigh }
+func (d *Disk) ActiveConfiguration() uint8       { return d.active }
+func (d *Disk) Opened() bool                     { return d.opened }
+
+func (d *Disk) Configurations() []usb.Configuration {
+	return []usb.Configuration{{
+		Descriptor: usb.ConfigurationDescriptor{Number: 1, MaxPower: 100},
+		Interfaces: []usb.Interface{{
+			Descriptor: usb.InterfaceDescriptor{
+				InterfaceClass:    bot.InterfaceClass,
+				InterfaceSubclass: 0x06, // SCSI transparent command set
+				InterfaceProtocol: bot.ProtocolBulkOnly,
+			},
+			Endpoints: []usb.EndpointDescriptor{
+				{EndpointNumber: 1, Direction: usb.DirectionIn, TransferType: usb.TransferBulk, MaxPacketSize: 512},
+				{EndpointNumber: 2, Direction: usb.DirectionOut, TransferType: usb.TransferBulk, MaxPacketSize: 512},
+			},
+		}},
+	}}
+}
+
+func (d *Disk) Open() error {
+	d.opened = true
+	return nil
+}
+
+func (d *Disk) Close() error {
+	d.opened = false
+	return nil
+}
+
+// Reset is a USB port reset, which also resets the transport.
+func (d *Disk) Reset() error {
+	d.resetTransport()
+	clear(d.halted)
+	return nil
+}
+
+func (d *Disk) resetTransport() {
+	d.state = stateCommand
+	d.response, d.received = nil, nil
+	d.needsReset = false
+}
+
+func (d *Disk) SelectConfiguration(number uint8) error {
+	if number != 1 {
+		return usb.ErrStall
+	}
+	d.active = number
+	return nil
+}
+
+func (d *Disk) ClaimInterface(number, alt uint8) error   { return nil }
+func (d *Disk) ReleaseInterface(number, alt uint8) error { return nil }
+
+func (d *Disk) ClearHalt(endpoint uint8) error {
+	if !d.needsReset {
+		d.halted[endpoint] = false
+	}
+	return nil
+}
+
+func (d *Disk) ReadControl(setup usb.ControlSetup, length uint16) ([]byte, error) {
+	if setup.Type == usb.ControlClass && setup.Request == bot.RequestGetMaxLUN && !d.StallGetMaxLUN {
+		return []byte{uint8(len(d.LUNs) - 1)}, nil
+	}
+	return nil, usb.ErrStall
+}
+
+func (d *Disk) WriteControl(setup usb.ControlSetup, data []byte) (int, error) {
+	if setup.Type == usb.ControlClass && setup.Request == bot.RequestReset {
+		d.Resets++
+		d.resetTransport()
+		return 0, nil
+	}
+	return 0, usb.ErrStall
+}
+
+func (d *Disk) ReadInterrupt(endpoint uint8, length int) ([]byte, error) {
+	return nil, usb.ErrStall
+}
+
+func (d *Disk) WriteInterrupt(endpoint uint8, data []byte) (int, error) {
+	return 0, usb.ErrStall
+}
+
+func (d *Disk) ReadIsochronous(endpoint uint8) ([]byte, error) {
+	return nil, usb.ErrStall
+}
+
+func (d *Disk) WriteIsochronous(endpoint uint8, data []byte) (int, error) {
+	return 0, usb.ErrStall
+}
+
+func (d *Disk) WriteBulk(endpoint uint8, data []byte) (int, error) {
+	if endpoint != EndpointOut || d.halted[endpoint] {
+		return 0, usb.ErrStall
+	}
+	switch d.state {
+	case stateCommand:
+		return d.command(data)
+	case stateDataOut:
+		n := min(len(data), int(d.cbw.DataTransferLength)-len(d.received))
+		d.received = append(d.received, data[:n]...)
+		if len(d.received) == int(d.cbw.DataTransferLength) {
+			d.finishDataOut()
+		}
+		return n, nil
+	}
+	// A CBW where none is expected is invalid.
+	d.invalidCBW()
+	return 0, usb.ErrStall
+}
+
+func (d *Disk) ReadBulk(endpoint uint8, length int) ([]byte, error) {
+	if endpoint != EndpointIn || d.halted[endpoint] {
+		return nil, usb.ErrStall
+	}
+	switch d.state {
+	case stateDataIn:
+		n := min(length, len(d.response))
+		data := d.response[:n]
+		d.response = d.response[n:]
+		if len(d.response) == 0 || n < length {
+			d.state = stateStatus
+		}
+		return data, nil
+	case stateStatus:
+		if d.StallCSW > 0 {
+			d.StallCSW--
+			d.halted[endpoint] = true
+			return nil, usb.ErrStall
+		}
+		csw := d.csw
+		if d.CSW != nil {
+			d.CSW(&csw)
+			d.CSW = nil
+		}
+		d.state = stateCommand
+		return csw.MarshalBinary()
+	}
+	d.halted[endpoint] = true
+	return nil, usb.ErrStall
+}
+
+func (d *Disk) invalidCBW() {
+	d.halted[EndpointIn] = true
+	d.halted[EndpointOut] = true
+	d.needsReset = true
+}
+
+// command handles a CBW and sets up the data and status stages.
+func (d *Disk) command(b []byte) (int, error) {
+	var cbw bot.CBW
+	if err := cbw.UnmarshalBinary(b); err != nil || int(cbw.LUN) >= len(d.LUNs) {
+		d.invalidCBW()
+		return 0, usb.ErrStall
+	}
+	d.cbw = cbw
+	d.Commands = append(d.Commands, cbw.CB)
+	d.csw = bot.CSW{Tag: cbw.Tag, DataResidue: cbw.DataTransferLength}
+	lun := d.LUNs[cbw.LUN]
+
+	expected := int(cbw.DataTransferLength)
+	dir, length := dataStage(lun, cbw.CB)
+	switch {
+	case expected > 0 && length > 0 && dir != cbw.Direction,
+		length > expected:
+		// The host and the device disagree on the data stage.
+		d.csw.Status = bot.StatusPhaseError
+		d.stallDataStage(cbw)
+		return len(b), nil
+	}
+
+	if dir == usb.DirectionOut && length > 0 {
+		d.state = stateDataOut
+		return len(b), nil
+	}
+	response, s := lun.execute(cbw.CB, nil)
+	d.complete(s)
+	if expected == 0 {
+		d.state = stateStatus
+		return len(b), nil
+	}
+	if s.Key != 0 {
+		// A failed command has no data; the pipe stalls instead.
+		d.stallDataStage(cbw)
+		return len(b), nil
+	}
+	d.response = response[:min(len(response), expected)]
+	d.csw.DataResidue = uint32(expected - len(d.response))
+	d.state = stateDataIn
+	if len(d.response) == 0 {
+		d.stallDataStage(cbw)
+	}
+	return len(b), nil
+}
+
+func (d *Disk) stallDataStage(cbw bot.CBW) {
+	d.state = stateStatus
+	if cbw.DataTransferLength == 0 {
+		return
+	}
+	if cbw.Direction == usb.DirectionIn {
+		d.halted[EndpointIn] = true
+	} else {
+		d.halted[EndpointOut] = true
+	}
+}
+
+func (d *Disk) finishDataOut() {
+	lun := d.LUNs[d.cbw.LUN]
+	_, length := dataStage(lun, d.cbw.CB)
+	_, s := lun.execute(d.cbw.CB, d.received[:length])
+	d.complete(s)
+	d.csw.DataResidue = uint32(len(d.received) - length)
+	d.received = nil
+	d.state = stateStatus
+}
+
+// complete records the outcome of a command in the CSW and the sense data.
+func (d *Disk) complete(s Sense) {
+	lun := d.LUNs[d.cbw.LUN]
+	if d.cbw.CB[0] != opRequestSense {
+		lun.sense = s
+	}
+	if s.Key != 0 {
+		d.csw.Status = bot.StatusFailed
+	}
+}
+
+// NewTransport returns a Bulk-Only transport to an emulated device with the
+// given LUNs, opened and configured.
+func NewTransport(t testing.TB, luns ...*LUN) *bot.Transport {
+	t.Helper()
+	d := usb.NewDevice(NewMulti(luns...))
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	intf, _ := bot.Find(d)
+	tr, err := bot.New(d, intf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
@@ -0,0 +1,351 @@
+package msctest
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"example.com/usb"
+)
+
+// LUN is a logical unit of the emulated device and its medium.
+type LUN struct {
+	Vendor   string // INQUIRY vendor identification, up to 8 characters
+	Product  string // INQUIRY product identification, up to 16 characters
+	Serial   string // Unit Serial Number VPD page
+	NAA      uint64 // reported as an NAA designator if not zero
+	ReadOnly bool
+	// NoMedium makes the commands that need a medium fail with MEDIUM NOT
+	// PRESENT, like an empty card reader slot.
+	NoMedium bool
+	// Locked is set by PREVENT ALLOW MEDIUM REMOVAL. START STOP UNIT does
+	// not eject a locked medium.
+	Locked bool
+
+	BlockSize int
+	// Data is the medium, a multiple of BlockSize. It is nil for sparse
+	// media created with NewSparseLUN.
+	Data []byte
+
+	// ModeSense6Unsupported makes MODE SENSE(6) fail with ILLEGAL REQUEST,
+	// as on devices that only implement MODE SENSE(10).
+	ModeSense6Unsupported bool
+	// SynchronizeCacheUnsupported makes SYNCHRONIZE CACHE(10) fail with
+	// ILLEGAL REQUEST, as on many USB sticks.
+	SynchronizeCacheUnsupported bool
+	// Fault, if set, is called before a command is executed. A Sense with
+	// a non-zero Key fails the command with it.
+	Fault func(cb []byte) Sense
+
+	blocks    uint64
+	sparse    map[uint64][]byte
+	sense     Sense
+	attention bool // a medium change not yet reported
+}
+
+// NewLUN returns a LUN with a zeroed medium of blocks blocks.
+func NewLUN(blocks, blockSize int) *LUN {
+	return &LUN{
+		Vendor:    "msctest",
+		Product:   "Emulated disk",
+		Serial:    "MSCTEST0001",
+		BlockSize: blockSize,
+		Data:      make([]byte, blocks*blockSize),
+		blocks:    uint64(blocks),
+	}
+}
+
+// NewSparseLUN returns a LUN whose medium only stores the blocks written,
+// so that it can be larger than memory.
+func NewSparseLUN(blocks uint64, blockSize int) *LUN {
+	l := NewLUN(0, blockSize)
+	l.Data = nil
+	l.blocks = blocks
+	l.sparse = make(map[uint64][]byte)
+	return l
+}
+
+// Insert replaces the medium by a zeroed one of blocks blocks, as if a card
+// were swapped. The next command fails with a unit attention for the
+// medium change.
+func (l *LUN) Insert(blocks int) {
+	l.NoMedium, l.attention = false, true
+	l.blocks = uint64(blocks)
+	if l.sparse != nil {
+		l.sparse = make(map[uint64][]byte)
+	} else {
+		l.Data = make([]byte, blocks*l.BlockSize)
+	}
+}
+
+// Blocks returns the number of blocks of the medium.
+func (l *LUN) Blocks() uint64 {
+	return l.blocks
+}
+
+func (l *LUN) read(lba uint64, count int) []byte {
+	if l.sparse == nil {
+		start := int(lba) * l.BlockSize
+		return append([]byte(nil), l.Data[start:start+count*l.BlockSize]...)
+	}
+	data := make([]byte, 0, count*l.BlockSize)
+	for i := range uint64(count) {
+		block, ok := l.sparse[lba+i]
+		if !ok {
+			block = make([]byte, l.BlockSize)
+		}
+		data = append(data, block...)
+	}
+	return data
+}
+
+func (l *LUN) write(lba uint64, data []byte) {
+	if l.sparse == nil {
+		copy(l.Data[int(lba)*l.BlockSize:], data)
+		return
+	}
+	for i := 0; i < len(data); i += l.BlockSize {
+		l.sparse[lba+uint64(i/l.BlockSize)] = append([]byte(nil), data[i:i+l.BlockSize]...)
+	}
+}
+
+// Sense is the sense data of a failed command.
+type Sense struct {
+	Key, ASC, ASCQ uint8
+	// Information, usually the LBA of the error, is reported if
+	// InformationValid is set.
+	Information      uint64
+	InformationValid bool
+}
+
+// Sense data the emulated device reports.
+var (
+	SenseInvalidOpcode = Sense{Key: 0x05, ASC: 0x20}
+	SenseInvalidField  = Sense{Key: 0x05, ASC: 0x24}
+	SenseLBAOutOfRange = Sense{Key: 0x05, ASC: 0x21}
+	SenseWriteProtect  = Sense{Key: 0x07, ASC: 0x27}
+	SenseMediumError   = Sense{Key: 0x03, ASC: 0x11}
+	SenseNoMedium      = Sense{Key: 0x02, ASC: 0x3a}
+	SenseMediumChanged = Sense{Key: 0x06, ASC: 0x28}
+	SenseLocked        = Sense{Key: 0x05, ASC: 0x53, ASCQ: 0x02}
+)
+
+// marshal encodes the sense data in fixed or descriptor format.
+func (s Sense) marshal(descriptor bool) []byte {
+	if descriptor {
+		r := make([]byte, 8, 20)
+		r[0] = 0x72
+		r[1], r[2], r[3] = s.Key, s.ASC, s.ASCQ
+		if s.InformationValid {
+			info := make([]byte, 12)
+			info[0], info[1], info[2] = 0x00, 0x0a, 0x80
+			binary.BigEndian.PutUint64(info[4:], s.Information)
+			r = append(r, info...)
+		}
+		r[7] = uint8(len(r) - 8)
+		return r
+	}
+	r := make([]byte, 18)
+	r[0] = 0x70
+	if s.InformationValid && s.Information <= 0xffffffff {
+		// An LBA that does not fit is not reported as valid.
+		r[0] |= 0x80
+		binary.BigEndian.PutUint32(r[3:], uint32(s.Information))
+	}
+	r[2] = s.Key
+	r[7] = 10
+	r[12], r[13] = s.ASC, s.ASCQ
+	return r
+}
+
+// SCSI operation codes the emulated device implements.
+const (
+	opTestUnitReady      = 0x00
+	opRequestSense       = 0x03
+	opInquiry            = 0x12
+	opModeSense6         = 0x1a
+	opStartStopUnit      = 0x1b
+	opPreventAllow       = 0x1e
+	opReadCapacity10     = 0x25
+	opRead10             = 0x28
+	opWrite10            = 0x2a
+	opVerify10           = 0x2f
+	opSynchronizeCache10 = 0x35
+	opModeSense10        = 0x5a
+	opRead16             = 0x88
+	opWrite16            = 0x8a
+	opVerify16           = 0x8f
+	opServiceActionIn16  = 0x9e
+	opReportLUNs         = 0xa0
+	opRead12             = 0xa8
+	opWrite12            = 0xaa
+	opVerify12           = 0xaf
+)
+
+// rw decodes the LBA and block count of a READ, WRITE or VERIFY command.
+func rw(cb []byte) (lba uint64, count int, ok bool) {
+	switch cb[0] {
+	case opRead10, opWrite10, opVerify10:
+		return uint64(binary.BigEndian.Uint32(cb[2:])), int(binary.BigEndian.Uint16(cb[7:])), len(cb) >= 10
+	case opRead12, opWrite12, opVerify12:
+		return uint64(binary.BigEndian.Uint32(cb[2:])), int(binary.BigEndian.Uint32(cb[6:])), len(cb) >= 12
+	case opRead16, opWrite16, opVerify16:
+		return binary.BigEndian.Uint64(cb[2:]), int(binary.BigEndian.Uint32(cb[10:])), len(cb) >= 16
+	}
+	return 0, 0, false
+}
+
+// dataStage returns the direction and length of the data stage of a
+// command, as the device sees it.
+func dataStage(lun *LUN, cb []byte) (usb.Direction, int) {
+	switch cb[0] {
+	case opRequestSense, opModeSense6:
+		return usb.DirectionIn, int(cb[4])
+	case opInquiry:
+		return usb.DirectionIn, int(binary.BigEndian.Uint16(cb[3:]))
+	case opModeSense10:
+		return usb.DirectionIn, int(binary.BigEndian.Uint16(cb[7:]))
+	case opReadCapacity10:
+		return usb.DirectionIn, 8
+	case opServiceActionIn16:
+		return usb.DirectionIn, int(binary.BigEndian.Uint32(cb[10:]))
+	case opReportLUNs:
+		return usb.DirectionIn, int(binary.BigEndian.Uint32(cb[6:]))
+	case opRead10, opRead12, opRead16:
+		_, count, _ := rw(cb)
+		return usb.DirectionIn, count * lun.BlockSize
+	case opWrite10, opWrite12, opWrite16:
+		_, count, _ := rw(cb)
+		return usb.DirectionOut, count * lun.BlockSize
+	}
+	return usb.DirectionOut, 0
+}
+
+// execute runs a SCSI command. data is the data OUT stage.
+func (l *LUN) execute(cb []byte, data []byte) ([]byte, Sense) {
+	if cb[0] != opRequestSense && cb[0] != opInquiry && l.Fault != nil {
+		if s := l.Fault(cb); s.Key != 0 {
+			return nil, s
+		}
+	}
+	if l.attention && cb[0] != opRequestSense && cb[0] != opInquiry {
+		l.attention = false
+		return nil, SenseMediumChanged
+	}
+	if l.NoMedium {
+		switch cb[0] {
+		case opTestUnitReady, opReadCapacity10, opServiceActionIn16, opSynchronizeCache10:
+			return nil, SenseNoMedium
+		}
+		if _, _, ok := rw(cb); ok {
+			return nil, SenseNoMedium
+		}
+	}
+	switch cb[0] {
+	case opSynchronizeCache10:
+		if l.SynchronizeCacheUnsupported {
+			return nil, SenseInvalidOpcode
+		}
+		return nil, Sense{}
+	case opTestUnitReady:
+		return nil, Sense{}
+	case opPreventAllow:
+		l.Locked = cb[4]&0x01 != 0
+		return nil, Sense{}
+	case opStartStopUnit:
+		if cb[4]&0x03 == 0x02 { // eject
+			if l.Locked {
+				return nil, SenseLocked
+			}
+			l.NoMedium = true
+		}
+		return nil, Sense{}
+	case opRequestSense:
+		r := l.sense.marshal(cb[1]&0x01 != 0)
+		l.sense = Sense{}
+		return r, Sense{}
+	case opInquiry:
+		if cb[1]&0x01 != 0 {
+			return l.vpd(cb[2])
+		}
+		r := make([]byte, 36)
+		r[1] = 0x80 // removable
+		r[2] = 0x06 // SPC-4
+		r[3] = 0x02
+		r[4] = 31
+		copy(r[8:16], fmt.Sprintf("%-8s", l.Vendor))
+		copy(r[16:32], fmt.Sprintf("%-16s", l.Product))
+		copy(r[32:36], "1.00")
+		return r, Sense{}
+	case opModeSense6:
+		if l.ModeSense6Unsupported {
+			return nil, SenseInvalidOpcode
+		}
+		r := []byte{3, 0, 0, 0}
+		if l.ReadOnly {
+			r[2] = 0x80
+		}
+		return r, Sense{}
+	case opModeSense10:
+		r := []byte{0, 6, 0, 0, 0, 0, 0, 0}
+		if l.ReadOnly {
+			r[3] = 0x80
+		}
+		return r, Sense{}
+	case opReadCapacity10:
+		r := make([]byte, 8)
+		binary.BigEndian.PutUint32(r, uint32(min(l.blocks-1, 0xffffffff)))
+		binary.BigEndian.PutUint32(r[4:], uint32(l.BlockSize))
+		return r, Sense{}
+	case opServiceActionIn16:
+		if cb[1]&0x1f != 0x10 {
+			return nil, SenseInvalidField
+		}
+		r := make([]byte, 32)
+		binary.BigEndian.PutUint64(r, l.blocks-1)
+		binary.BigEndian.PutUint32(r[8:], uint32(l.BlockSize))
+		return r, Sense{}
+	}
+	lba, count, ok := rw(cb)
+	if !ok {
+		return nil, SenseInvalidOpcode
+	}
+	if lba+uint64(count) > l.blocks {
+		s := SenseLBAOutOfRange
+		s.Information, s.InformationValid = lba, true
+		return nil, s
+	}
+	switch cb[0] {
+	case opRead10, opRead12, opRead16:
+		return l.read(lba, count), Sense{}
+	case opWrite10, opWrite12, opWrite16:
+		if l.ReadOnly {
+			return nil, SenseWriteProtect
+		}
+		l.write(lba, data)
+	}
+	return nil, Sense{}
+}
+
+// vpd returns a vital product data page.
+func (l *LUN) vpd(page uint8) ([]byte, Sense) {
+	var payload []byte
+	switch page {
+	case 0x00:
+		payload = []byte{0x00, 0x80, 0x83}
+	case 0x80:
+		payload = []byte(l.Serial)
+	case 0x83:
+		// A T10 vendor ID designator, and an NAA one if the LUN has one.
+		t10 := fmt.Sprintf("%-8s%s %s", l.Vendor, l.Product, l.Serial)
+		payload = append([]byte{0x02, 0x01, 0x00, uint8(len(t10))}, t10...)
+		if l.NAA != 0 {
+			payload = append(payload, 0x01, 0x03, 0x00, 0x08)
+			payload = binary.BigEndian.AppendUint64(payload, l.NAA)
+		}
+	default:
+		return nil, SenseInvalidField
+	}
+	r := []byte{0, page, 0, 0}
+	binary.BigEndian.PutUint16(r[2:], uint16(len(payload)))
+	return append(r, payload...), Sense{}
+}
@@ -0,0 +1,484 @@
+package msctest
+
+import (
+	"encoding/binary"
+
+	"example.com/usb"
+	"example.com/usb/msc/bot"
+)
+
+// Endpoint addresses of the command and status pipes of the emulated UAS
+// interface. Its data-in and data-out pipes are EndpointIn and EndpointOut,
+// shared with the Bulk-Only interface.
+const (
+	EndpointCommand = 0x04
+	EndpointStatus  = 0x83
+)
+
+// UAS protocol values of the emulated device.
+const (
+	protocolUAS       = 0x62
+	descriptorPipe    = 0x24 // Pipe Usage
+	descriptorSSEPC   = 0x30 // SuperSpeed Endpoint Companion
+	iuCommand         = 0x01
+	iuSense           = 0x03
+	iuResponse        = 0x04
+	iuTaskManagement  = 0x05
+	iuReadReady       = 0x06
+	iuWriteReady      = 0x07
+	responseComplete  = 0x00
+	responseInvalidIU = 0x02
+	responseNoSupport = 0x04
+	responseBadLUN    = 0x09
+)
+
+// Task is a Task Management IU the emulated device received.
+type Task struct {
+	Tag      uint16
+	Function uint8
+	TaskTag  uint16 // the tag of the command to manage
+	LUN      uint8
+}
+
+// UASDisk is an emulated mass storage device whose interface has a
+// Bulk-Only alternate setting 0, handled by the embedded Disk, and a UAS
+// alternate setting 1. It implements usb.Host and usb.StreamHost.
+//
+// At SuperSpeed the UAS status and data pipes have streams. At high speed
+// they have none, and the device sends Read Ready and Write Ready IUs. A
+// failed command reports its sense data in the Sense IU.
+type UASDisk struct {
+	*Disk
+
+	// HighSpeed makes the device run at high speed.
+	HighSpeed bool
+	// MaxStreams is the MaxStreams field of the SuperSpeed Endpoint
+	// Companion descriptors of the status and data pipes: they have
+	// 2^MaxStreams streams, or none if it is 0.
+	MaxStreams uint8
+
+	// Reject, if set, is called for every Command IU. A non-zero response
+	// code answers it with a Response IU instead of running the command.
+	Reject func(lun uint8, cb []byte) uint8
+	// TaskResponse, if set, returns the response code of the task
+	// management functions. Otherwise the aborts and resets complete, and
+	// the other functions are not supported.
+	TaskResponse func(t Task) uint8
+	// StallStatus is the number of times the status pipe stalls instead of
+	// sending the IU that completes a command.
+	StallStatus int
+	// OutOfOrder makes the device complete one command per batch of stream
+	// transfers, the one whose status is read last, and none of those
+	// queued before it.
+	OutOfOrder bool
+
+	// Tasks holds the Task Management IUs received, in order.
+	Tasks []Task
+
+	alt     uint8
+	streams uint32
+	// pending holds the IUs received on the command pipe with streams, by
+	// tag, until the command completes.
+	pending map[uint32][]byte
+
+	// Without streams, the IUs waiting on the status pipe, and the data
+	// stage of the current command.
+	status  [][]byte
+	dataIn  []byte
+	dataOut *command
+	after   []byte // the Sense IU that follows the data-in stage
+}
+
+var (
+	_ usb.Host       = (*UASDisk)(nil)
+	_ usb.StreamHost = (*UASDisk)(nil)
+)
+
+// NewUAS returns an emulated SuperSpeed UAS device with the given LUNs and
+// 32 streams.
+func NewUAS(luns ...*LUN) *UASDisk {
+	u := &UASDisk{Disk: NewMulti(luns...), MaxStreams: 5}
+	u.descriptor.USBVersion = usb.Version{Major: 3, Minor: 2}
+	return u
+}
+
+func (u *UASDisk) Speed() usb.Speed {
+	if u.HighSpeed {
+		return usb.SpeedHigh
+	}
+	return usb.SpeedSuper
+}
+
+func (u *UASDisk) maxPacketSize() uint16 {
+	if u.HighSpeed {
+		return 512
+	}
+	return 1024
+}
+
+func (u *UASDisk) Configurations() []usb.Configuration {
+	ep := func(address uint8) usb.EndpointDescriptor {
+		dir := usb.DirectionOut
+		if address&0x80 != 0 {
+			dir = usb.DirectionIn
+		}
+		return usb.EndpointDescriptor{EndpointNumber: address & 0x0f, Direction: dir, TransferType: usb.TransferBulk, MaxPacketSize: u.maxPacketSize()}
+	}
+	intf := func(alt, protocol uint8, eps ...usb.EndpointDescriptor) usb.Interface {
+		return usb.Interface{
+			Descriptor: usb.InterfaceDescriptor{
+				AlternateSetting:  alt,
+				InterfaceClass:    bot.InterfaceClass,
+				InterfaceSubclass: 0x06, // SCSI transparent command set
+				InterfaceProtocol: protocol,
+			},
+			Endpoints: eps,
+		}
+	}
+	return []usb.Configuration{{
+		Descriptor: usb.ConfigurationDescriptor{Number: 1, MaxPower: 100},
+		Interfaces: []usb.Interface{
+			intf(0, bot.ProtocolBulkOnly, ep(EndpointIn), ep(EndpointOut)),
+			intf(1, protocolUAS, ep(EndpointCommand), ep(EndpointStatus), ep(EndpointIn), ep(EndpointOut)),
+		},
+	}}
+}
+
+// configurationDescriptor encodes the configuration as the device sends
+// it, with SuperSpeed Endpoint Companion descriptors at SuperSpeed and the
+// Pipe Usage descriptors of the UAS interface.
+func (u *UASDisk) configurationDescriptor() []byte {
+	b := []byte{9, usb.DescriptorTypeConfiguration, 0, 0, 1, 1, 0, 0x80, 50}
+	for _, intf := range u.Configurations()[0].Interfaces {
+		desc := intf.Descriptor
+		b = append(b, 9, 0x04, 0, desc.AlternateSetting, uint8(len(intf.Endpoints)), desc.InterfaceClass, desc.InterfaceSubclass, desc.InterfaceProtocol, 0)
+		for i, ep := range intf.Endpoints {
+			b = append(b, 7, 0x05, ep.Address(), 0x02)
+			b = binary.LittleEndian.AppendUint16(b, ep.MaxPacketSize)
+			b = append(b, 0)
+			if !u.HighSpeed {
+				var streams uint8
+				if desc.AlternateSetting == 1 && ep.Address() != EndpointCommand {
+					streams = u.MaxStreams
+				}
+				b = append(b, 6, descriptorSSEPC, 0, streams, 0, 0)
+			}
+			if desc.AlternateSetting == 1 {
+				b = append(b, 4, descriptorPipe, uint8(i+1), 0)
+			}
+		}
+	}
+	binary.LittleEndian.PutUint16(b[2:], uint16(len(b)))
+	return b
+}
+
+func (u *UASDisk) ReadControl(setup usb.ControlSetup, length uint16) ([]byte, error) {
+	if setup.Type == usb.ControlStandard && setup.Request == usb.RequestGetDescriptor && setup.Value == uint16(usb.DescriptorTypeConfiguration)<<8 {
+		b := u.configurationDescriptor()
+		return b[:min(int(length), len(b))], nil
+	}
+	return u.Disk.ReadControl(setup, length)
+}
+
+// Reset is a USB port reset, which also resets both transports.
+func (u *UASDisk) Reset() error {
+	u.resetUAS()
+	return u.Disk.Reset()
+}
+
+func (u *UASDisk) resetUAS() {
+	u.status, u.dataIn, u.dataOut, u.after = nil, nil, nil, nil
+	u.pending = nil
+}
+
+func (u *UASDisk) ClaimInterface(number, alt uint8) error {
+	if number != 0 || alt > 1 {
+		return usb.ErrStall
+	}
+	u.alt = alt
+	u.resetUAS()
+	return nil
+}
+
+func (u *UASDisk) ReleaseInterface(number, alt uint8) error {
+	u.alt, u.streams = 0, 0
+	return nil
+}
+
+func (u *UASDisk) AllocStreams(count uint32, endpoints []uint8) (uint32, error) {
+	if u.alt != 1 || u.MaxStreams == 0 || u.HighSpeed {
+		return 0, usb.ErrIO
+	}
+	u.streams = min(count, 1<<u.MaxStreams)
+	return u.streams, nil
+}
+
+func (u *UASDisk) FreeStreams(endpoints []uint8) error {
+	u.streams = 0
+	return nil
+}
+
+// TransferStreams handles the IUs on the command pipe, and completes the
+// commands whose status is read in the batch: it moves their data stage on
+// the stream of their tag, and sends the IU that completes them there. The
+// other transfers are cancelled, and the commands without a status read
+// wait for a later batch. A batch whose last transfer does not complete
+// times out, as a host waiting for it would.
+func (u *UASDisk) TransferStreams(transfers []usb.StreamTransfer) ([]usb.StreamResult, error) {
+	results := make([]usb.StreamResult, len(transfers))
+	for i := range results {
+		results[i].Status = usb.StreamCancelled
+	}
+	if u.alt != 1 || u.streams == 0 {
+		return nil, usb.ErrTimeout
+	}
+	if u.pending == nil {
+		u.pending = make(map[uint32][]byte)
+	}
+	for i, t := range transfers {
+		if t.Endpoint != EndpointCommand {
+			continue
+		}
+		if len(t.Data) < 4 {
+			return nil, usb.ErrTimeout
+		}
+		u.pending[uint32(binary.BigEndian.Uint16(t.Data[2:]))] = t.Data
+		results[i] = usb.StreamResult{Status: usb.StreamCompleted, Actual: len(t.Data)}
+	}
+
+	for i, t := range transfers {
+		iu, ok := u.pending[t.StreamID]
+		if t.Endpoint != EndpointStatus || !ok || u.OutOfOrder && i != len(transfers)-1 {
+			continue
+		}
+		tag := t.StreamID
+		var out []byte
+		for _, t := range transfers {
+			if t.Endpoint == EndpointOut && t.StreamID == tag {
+				out = t.Data
+			}
+		}
+		delete(u.pending, tag)
+		in, consumed, status := u.receive(iu, out)
+		for j, t := range transfers {
+			r := &results[j]
+			switch {
+			case t.StreamID != tag:
+			case t.Endpoint == EndpointOut && consumed > 0:
+				r.Status, r.Actual = usb.StreamCompleted, consumed
+			case t.Endpoint == EndpointIn && in != nil:
+				r.Data = in[:min(len(in), t.Length)]
+				r.Status, r.Actual = usb.StreamCompleted, len(r.Data)
+			case t.Endpoint == EndpointStatus && u.StallStatus > 0:
+				u.StallStatus--
+				u.halted[EndpointStatus] = true
+				r.Status = usb.StreamStalled
+			case t.Endpoint == EndpointStatus && !u.halted[EndpointStatus]:
+				r.Data = status[:min(len(status), t.Length)]
+				r.Status, r.Actual = usb.StreamCompleted, len(r.Data)
+			}
+		}
+	}
+	if len(transfers) == 0 || results[len(results)-1].Status == usb.StreamCancelled {
+		return nil, usb.ErrTimeout
+	}
+	return results, nil
+}
+
+func (u *UASDisk) WriteBulk(endpoint uint8, data []byte) (int, error) {
+	if u.alt != 1 {
+		return u.Disk.WriteBulk(endpoint, data)
+	}
+	if u.halted[endpoint] {
+		return 0, usb.ErrStall
+	}
+	switch {
+	case endpoint == EndpointCommand && u.streams == 0:
+		u.command(data)
+		return len(data), nil
+	case endpoint == EndpointOut && u.dataOut != nil:
+		_, consumed, status := u.execute(*u.dataOut, data)
+		u.dataOut = nil
+		u.status = append(u.status, status)
+		return consumed, nil
+	}
+	return 0, usb.ErrTimeout
+}
+
+func (u *UASDisk) ReadBulk(endpoint uint8, length int) ([]byte, error) {
+	if u.alt != 1 {
+		return u.Disk.ReadBulk(endpoint, length)
+	}
+	if u.halted[endpoint] {
+		return nil, usb.ErrStall
+	}
+	switch {
+	case endpoint == EndpointStatus && len(u.status) > 0:
+		if u.StallStatus > 0 {
+			u.StallStatus--
+			u.halted[endpoint] = true
+			return nil, usb.ErrStall
+		}
+		b := u.status[0]
+		u.status = u.status[1:]
+		return b[:min(len(b), length)], nil
+	case endpoint == EndpointIn && u.dataIn != nil:
+		data := u.dataIn[:min(len(u.dataIn), length)]
+		u.dataIn = nil
+		u.status = append(u.status, u.after)
+		u.after = nil
+		return data, nil
+	}
+	return nil, usb.ErrTimeout
+}
+
+// command is a decoded Command IU.
+type command struct {
+	tag uint16
+	lun uint8
+	cb  []byte
+}
+
+// parseCommand decodes a Command IU, whose LUN uses peripheral addressing.
+func parseCommand(iu []byte) (command, bool) {
+	if len(iu) < 32 || iu[0] != iuCommand || len(iu) != 32+int(iu[6]&^0x03) || iu[8] != 0 {
+		return command{}, false
+	}
+	return command{tag: binary.BigEndian.Uint16(iu[2:]), lun: iu[9], cb: iu[16:]}, true
+}
+
+// command handles an IU on the command pipe without streams: the data
+// stage waits for the host after a Read Ready or Write Ready IU.
+func (u *UASDisk) command(iu []byte) {
+	c, ok := parseCommand(iu)
+	if !ok || int(c.lun) >= len(u.LUNs) {
+		_, _, status := u.receive(iu, nil)
+		u.status = append(u.status, status)
+		return
+	}
+	dir, length := dataStage(u.LUNs[c.lun], c.cb)
+	if dir == usb.DirectionOut && length > 0 {
+		u.dataOut = &c
+		u.status = append(u.status, ready(iuWriteReady, c.tag))
+		return
+	}
+	in, _, status := u.receive(iu, nil)
+	if len(in) == 0 {
+		u.status = append(u.status, status)
+		return
+	}
+	u.status = append(u.status, ready(iuReadReady, c.tag))
+	u.dataIn, u.after = in, status
+}
+
+func ready(id uint8, tag uint16) []byte {
+	return []byte{id, 0, uint8(tag >> 8), uint8(tag)}
+}
+
+// receive handles an IU on the command pipe with the data-out stage out.
+// It returns the data-in stage, the number of bytes of out consumed and
+// the IU that completes it.
+func (u *UASDisk) receive(iu []byte, out []byte) ([]byte, int, []byte) {
+	if c, ok := parseCommand(iu); ok {
+		return u.execute(c, out)
+	}
+	var tag uint16
+	if len(iu) >= 4 {
+		tag = binary.BigEndian.Uint16(iu[2:])
+	}
+	if len(iu) == 16 && iu[0] == iuTaskManagement && iu[8] == 0 {
+		return nil, 0, u.task(Task{Tag: tag, Function: iu[4], TaskTag: binary.BigEndian.Uint16(iu[6:]), LUN: iu[9]})
+	}
+	return nil, 0, response(tag, responseInvalidIU)
+}
+
+func response(tag uint16, code uint8) []byte {
+	return []byte{iuResponse, 0, uint8(tag >> 8), uint8(tag), 0, 0, 0, code}
+}
+
+// execute runs a command with the data-out stage out.
+func (u *UASDisk) execute(c command, out []byte) ([]byte, int, []byte) {
+	if u.Reject != nil {
+		if code := u.Reject(c.lun, c.cb); code != responseComplete {
+			return nil, 0, response(c.tag, code)
+		}
+	}
+	if int(c.lun) >= len(u.LUNs) {
+		return nil, 0, response(c.tag, responseBadLUN)
+	}
+	u.Commands = append(u.Commands, c.cb)
+	lun := u.LUNs[c.lun]
+
+	var in []byte
+	var s Sense
+	consumed := 0
+	dir, length := dataStage(lun, c.cb)
+	switch {
+	case c.cb[0] == opReportLUNs:
+		in = u.reportLUNs()
+	case dir == usb.DirectionOut && length > 0 && len(out) < length:
+		s = SenseInvalidField
+	case dir == usb.DirectionOut && length > 0:
+		_, s = lun.execute(c.cb, out[:length])
+		consumed = length
+	default:
+		in, s = lun.execute(c.cb, nil)
+	}
+	// The Sense IU carries the status, and the sense data of a failed
+	// command, which has no data.
+	b := make([]byte, 16)
+	b[0] = iuSense
+	binary.BigEndian.PutUint16(b[2:], c.tag)
+	if s.Key != 0 {
+		in = nil
+		sense := s.marshal(false)
+		b[6] = 0x02 // CHECK CONDITION
+		binary.BigEndian.PutUint16(b[14:], uint16(len(sense)))
+		b = append(b, sense...)
+	}
+	if length < len(in) {
+		in = in[:length]
+	}
+	return in, consumed, b
+}
+
+// reportLUNs returns the parameter data of REPORT LUNS.
+func (u *UASDisk) reportLUNs() []byte {
+	b := make([]byte, 8+8*len(u.LUNs))
+	binary.BigEndian.PutUint32(b, uint32(8*len(u.LUNs)))
+	for i := range u.LUNs {
+		b[9+8*i] = uint8(i)
+	}
+	return b
+}
+
+// Task management functions that complete on the emulated device.
+const (
+	taskAbortTask    = 0x01
+	taskAbortTaskSet = 0x02
+	taskClearTaskSet = 0x04
+	taskLUNReset     = 0x08
+	taskNexusReset   = 0x10
+)
+
+// task handles a task management function. Aborting a command drops it, and
+// its data stage, before it runs.
+func (u *UASDisk) task(t Task) []byte {
+	u.Tasks = append(u.Tasks, t)
+	if u.TaskResponse != nil {
+		return response(t.Tag, u.TaskResponse(t))
+	}
+	if int(t.LUN) >= len(u.LUNs) {
+		return response(t.Tag, responseBadLUN)
+	}
+	switch t.Function {
+	case taskAbortTask:
+		u.status, u.dataIn, u.dataOut, u.after = nil, nil, nil, nil
+		delete(u.pending, uint32(t.TaskTag))
+		return response(t.Tag, responseComplete)
+	case taskAbortTaskSet, taskClearTaskSet, taskLUNReset, taskNexusReset:
+		u.resetUAS()
+		return response(t.Tag, responseComplete)
+	}
+	return response(t.Tag, responseNoSupport)
+}
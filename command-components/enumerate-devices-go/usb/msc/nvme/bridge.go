@@ -0,0 +1,162 @@
+package nvme
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"example.com/usb"
+	"example.com/usb/msc/scsi"
+)
+
+// checkCommand checks a command for the ASMedia and Realtek bridges, which
+// only send Identify and Get Log Page, to namespace 1, with the command
+// dword 10 bits in mask.
+func checkCommand(name string, cmd *Command, mask uint32, data []byte) error {
+	ok := cmd.Opcode == OpIdentify || cmd.Opcode == OpGetLogPage
+	ok = ok && (cmd.NSID == 0 || cmd.NSID == 1 || cmd.NSID == NamespaceAll)
+	ok = ok && cmd.CDW10&^mask == 0 && cmd.CDW11|cmd.CDW12|cmd.CDW13|cmd.CDW14|cmd.CDW15 == 0
+	if !ok {
+		return fmt.Errorf("%w: %s: opcode %#02x, namespace %d, CDW10 %#x", ErrCommand, name, cmd.Opcode, cmd.NSID, cmd.CDW10)
+	}
+	if len(data) == 0 || len(data) > 0xffff {
+		return fmt.Errorf("nvme: %s: %d bytes of data", name, len(data))
+	}
+	return nil
+}
+
+// opASMedia is the vendor command of ASMedia ASM2362 and ASM2364 bridges.
+const opASMedia = 0xe6
+
+type asmedia struct {
+	d *scsi.Device
+}
+
+// NewASMedia returns the passthrough of ASMedia bridges. They send
+// Identify and Get Log Page of up to 1 KiB, and do not return Dword 0.
+func NewASMedia(d *scsi.Device) Passthrough {
+	return &asmedia{d: d}
+}
+
+func (a *asmedia) Name() string {
+	return "ASMedia"
+}
+
+func (a *asmedia) Exec(cmd *Command, data []byte) (uint32, error) {
+	// The command block holds bytes 0 and 2 of CDW10: the CNS, or the log
+	// page identifier and the low byte of the number of dwords.
+	if err := checkCommand(a.Name(), cmd, 0x00ff00ff, data); err != nil {
+		return 0, err
+	}
+	cb := make([]byte, 16)
+	cb[0], cb[1] = opASMedia, cmd.Opcode
+	cb[3], cb[7] = uint8(cmd.CDW10), uint8(cmd.CDW10>>16)
+	_, err := a.d.Command(cb, usb.DirectionIn, data)
+	return 0, err
+}
+
+// opRealtek is the vendor command of Realtek RTL9210 and RTL9211 bridges.
+const opRealtek = 0xe4
+
+type realtek struct {
+	d *scsi.Device
+}
+
+// NewRealtek returns the passthrough of Realtek bridges. They send Identify
+// and Get Log Page, and do not return Dword 0.
+func NewRealtek(d *scsi.Device) Passthrough {
+	return &realtek{d: d}
+}
+
+func (r *realtek) Name() string {
+	return "Realtek"
+}
+
+func (r *realtek) Exec(cmd *Command, data []byte) (uint32, error) {
+	// The command block holds the low byte of CDW10 and the length of the
+	// data, from which the bridge computes the number of dwords of a log
+	// page.
+	mask := uint32(0xff)
+	if cmd.Opcode == OpGetLogPage && cmd.CDW10>>16 == uint32(len(data)/4-1) {
+		mask |= 0xffff0000
+	}
+	if err := checkCommand(r.Name(), cmd, mask, data); err != nil {
+		return 0, err
+	}
+	cb := make([]byte, 16)
+	cb[0] = opRealtek
+	binary.LittleEndian.PutUint16(cb[1:], uint16(len(data)))
+	cb[3], cb[4] = cmd.Opcode, uint8(cmd.CDW10)
+	_, err := r.d.Command(cb, usb.DirectionIn, data)
+	return 0, err
+}
+
+// JMicron bridges tunnel NVMe commands through the opcode of ATA
+// PASS-THROUGH(12), in three steps: the command is written in a 512-byte
+// block, the data read, and the completion read in another block. Byte 1
+// of the command blocks selects the step, bytes 3 to 5 hold the length of
+// its data, big-endian. This follows sntjmicron_device of smartmontools.
+const (
+	jmicronNVMe       = 0x80
+	jmicronCommand    = 0x00 // write the command block
+	jmicronDataIn     = 0x02
+	jmicronCompletion = 0x0f // read the completion block
+
+	jmicronSignature = 0x454d564e // "NVME"
+)
+
+type jmicron struct {
+	d *scsi.Device
+}
+
+// NewJMicron returns the passthrough of JMicron JMS583 and JMS581 bridges.
+// They send any admin command that reads data.
+func NewJMicron(d *scsi.Device) Passthrough {
+	return &jmicron{d: d}
+}
+
+func (j *jmicron) Name() string {
+	return "JMicron"
+}
+
+func (j *jmicron) command(step uint8, dir usb.Direction, data []byte) error {
+	cb := make([]byte, 12)
+	cb[0], cb[1] = scsi.OpATAPassThrough12, jmicronNVMe|step
+	cb[3], cb[4], cb[5] = uint8(len(data)>>16), uint8(len(data)>>8), uint8(len(data))
+	_, err := j.d.Command(cb, dir, data)
+	return err
+}
+
+func (j *jmicron) Exec(cmd *Command, data []byte) (uint32, error) {
+	if len(data) == 0 || len(data) > 0xffffff {
+		return 0, fmt.Errorf("nvme: JMicron: %d bytes of data", len(data))
+	}
+	// The command block holds the signature and a submission queue entry.
+	block := make([]byte, 512)
+	binary.LittleEndian.PutUint32(block, jmicronSignature)
+	sqe := block[8:72]
+	sqe[0] = cmd.Opcode
+	binary.LittleEndian.PutUint32(sqe[4:], cmd.NSID)
+	for i, dw := range []uint32{cmd.CDW10, cmd.CDW11, cmd.CDW12, cmd.CDW13, cmd.CDW14, cmd.CDW15} {
+		binary.LittleEndian.PutUint32(sqe[40+4*i:], dw)
+	}
+	if err := j.command(jmicronCommand, usb.DirectionOut, block); err != nil {
+		return 0, err
+	}
+	if err := j.command(jmicronDataIn, usb.DirectionIn, data); err != nil {
+		return 0, err
+	}
+	// The completion block holds the signature and, like the command
+	// block, the completion queue entry at byte 8.
+	clear(block)
+	if err := j.command(jmicronCompletion, usb.DirectionIn, block); err != nil {
+		return 0, err
+	}
+	if binary.LittleEndian.Uint32(block) != jmicronSignature {
+		return 0, fmt.Errorf("nvme: JMicron: completion block without signature: % x", block[:4])
+	}
+	cqe := block[8:24]
+	if status := binary.LittleEndian.Uint16(cqe[14:]) >> 1; status&0x7ff != 0 {
+		return 0, &Error{Opcode: cmd.Opcode, Status: status & 0x7ff}
+	}
+	return binary.LittleEndian.Uint32(cqe), nil
+}
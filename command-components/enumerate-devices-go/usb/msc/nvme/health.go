@@ -0,0 +1,129 @@
+package nvme
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// CriticalWarning holds the critical warning bits of the SMART / Health
+// Information log.
+type CriticalWarning uint8
+
+const (
+	WarningSpare       CriticalWarning = 1 << iota // available spare below its threshold
+	WarningTemperature                             // temperature outside its thresholds
+	WarningReliability                             // degraded by media or internal errors
+	WarningReadOnly                                // media placed in read-only mode
+	WarningBackup                                  // volatile memory backup device failed
+	WarningPMR                                     // persistent memory region read-only
+)
+
+var warningNames = []string{
+	"available spare low",
+	"temperature",
+	"reliability degraded",
+	"read-only",
+	"volatile memory backup failed",
+	"persistent memory region read-only",
+}
+
+func (w CriticalWarning) String() string {
+	if w == 0 {
+		return "none"
+	}
+	var s []string
+	for i, name := range warningNames {
+		if w&(1<<i) != 0 {
+			s = append(s, name)
+		}
+	}
+	if rest := w &^ (1<<len(warningNames) - 1); rest != 0 {
+		s = append(s, fmt.Sprintf("%#02x", uint8(rest)))
+	}
+	return strings.Join(s, ", ")
+}
+
+// Health is the SMART / Health Information log. Counters are saturated
+// above 64 bits.
+type Health struct {
+	CriticalWarning CriticalWarning
+	// Temperature is the composite temperature in kelvins.
+	Temperature uint16
+	// AvailableSpare and its threshold are percentages of the spare
+	// capacity.
+	AvailableSpare          uint8
+	AvailableSpareThreshold uint8
+	// PercentageUsed estimates the life used, and may exceed 100.
+	PercentageUsed uint8
+	// DataUnitsRead and DataUnitsWritten count units of 1000 blocks of
+	// 512 bytes.
+	DataUnitsRead       uint64
+	DataUnitsWritten    uint64
+	HostReads           uint64
+	HostWrites          uint64
+	BusyMinutes         uint64
+	PowerCycles         uint64
+	PowerOnHours        uint64
+	UnsafeShutdowns     uint64
+	MediaErrors         uint64
+	ErrorLogEntries     uint64
+	WarningTempMinutes  uint32
+	CriticalTempMinutes uint32
+	// Sensors holds the temperatures of the sensors that report one, in
+	// kelvins.
+	Sensors []uint16
+}
+
+// ParseHealth decodes the 512 bytes of the SMART / Health Information log.
+func ParseHealth(b []byte) (*Health, error) {
+	if len(b) < 512 {
+		return nil, fmt.Errorf("nvme: SMART / Health Information log of %d bytes", len(b))
+	}
+	h := &Health{
+		CriticalWarning:         CriticalWarning(b[0]),
+		Temperature:             binary.LittleEndian.Uint16(b[1:]),
+		AvailableSpare:          b[3],
+		AvailableSpareThreshold: b[4],
+		PercentageUsed:          b[5],
+		DataUnitsRead:           uint128(b[32:]),
+		DataUnitsWritten:        uint128(b[48:]),
+		HostReads:               uint128(b[64:]),
+		HostWrites:              uint128(b[80:]),
+		BusyMinutes:             uint128(b[96:]),
+		PowerCycles:             uint128(b[112:]),
+		PowerOnHours:            uint128(b[128:]),
+		UnsafeShutdowns:         uint128(b[144:]),
+		MediaErrors:             uint128(b[160:]),
+		ErrorLogEntries:         uint128(b[176:]),
+		WarningTempMinutes:      binary.LittleEndian.Uint32(b[192:]),
+		CriticalTempMinutes:     binary.LittleEndian.Uint32(b[196:]),
+	}
+	for i := range 8 {
+		if t := binary.LittleEndian.Uint16(b[200+2*i:]); t != 0 {
+			h.Sensors = append(h.Sensors, t)
+		}
+	}
+	return h, nil
+}
+
+// Celsius returns the composite temperature in degrees Celsius.
+func (h *Health) Celsius() int {
+	return int(h.Temperature) - 273
+}
+
+// BytesRead returns the data read by the host, in bytes.
+func (h *Health) BytesRead() uint64 {
+	return h.DataUnitsRead * 512000
+}
+
+// BytesWritten returns the data written by the host, in bytes.
+func (h *Health) BytesWritten() uint64 {
+	return h.DataUnitsWritten * 512000
+}
+
+// Failing reports whether the drive warns of a condition that threatens
+// its data: low spare capacity, degraded reliability or read-only media.
+func (h *Health) Failing() bool {
+	return h.CriticalWarning&(WarningSpare|WarningReliability|WarningReadOnly|WarningBackup) != 0
+}
@@ -0,0 +1,137 @@
+package nvme
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Controller is the Identify Controller data structure.
+type Controller struct {
+	VendorID          uint16 // PCI vendor ID
+	SubsystemVendorID uint16
+	Serial            string
+	Model             string
+	Firmware          string
+	OUI               uint32 // IEEE OUI of the vendor
+	// MaxTransferShift is the maximum data transfer size as a power of two
+	// of the minimum memory page size, or 0 for no limit.
+	MaxTransferShift uint8
+	ControllerID     uint16
+	// Version is the NVMe version, such as 0x010400 for 1.4.
+	Version uint32
+	// WarningTemp and CriticalTemp are the composite temperature
+	// thresholds, in kelvins, or 0 if not reported.
+	WarningTemp   uint16
+	CriticalTemp  uint16
+	TotalCapacity uint64 // bytes of NVM; saturated above 64 bits
+	Namespaces    uint32 // the highest namespace ID
+}
+
+// VersionString formats Version, such as "1.4".
+func (c *Controller) VersionString() string {
+	if c.Version == 0 {
+		return "unknown"
+	}
+	v := fmt.Sprintf("%d.%d", c.Version>>16, c.Version>>8&0xff)
+	if tertiary := c.Version & 0xff; tertiary != 0 {
+		v += fmt.Sprintf(".%d", tertiary)
+	}
+	return v
+}
+
+// ParseController decodes the 4096 bytes of Identify Controller data.
+func ParseController(b []byte) (*Controller, error) {
+	if len(b) < 4096 {
+		return nil, fmt.Errorf("nvme: Identify Controller data of %d bytes", len(b))
+	}
+	return &Controller{
+		VendorID:          binary.LittleEndian.Uint16(b[0:]),
+		SubsystemVendorID: binary.LittleEndian.Uint16(b[2:]),
+		Serial:            trimASCII(b[4:24]),
+		Model:             trimASCII(b[24:64]),
+		Firmware:          trimASCII(b[64:72]),
+		OUI:               uint32(b[73]) | uint32(b[74])<<8 | uint32(b[75])<<16,
+		MaxTransferShift:  b[77],
+		ControllerID:      binary.LittleEndian.Uint16(b[78:]),
+		Version:           binary.LittleEndian.Uint32(b[80:]),
+		WarningTemp:       binary.LittleEndian.Uint16(b[266:]),
+		CriticalTemp:      binary.LittleEndian.Uint16(b[268:]),
+		TotalCapacity:     uint128(b[280:]),
+		Namespaces:        binary.LittleEndian.Uint32(b[516:]),
+	}, nil
+}
+
+// Format is an LBA format of a namespace.
+type Format struct {
+	BlockSize    int
+	MetadataSize int
+	// Performance is the relative performance the controller reports, from
+	// 0 for best to 3 for degraded.
+	Performance uint8
+}
+
+// Namespace is the Identify Namespace data structure.
+type Namespace struct {
+	// Size, Capacity and Utilization are in blocks of the current format.
+	Size        uint64
+	Capacity    uint64
+	Utilization uint64
+	Formats     []Format
+	Current     int // the index of the current format in Formats
+	NGUID       [16]byte
+	EUI64       [8]byte
+}
+
+// BlockSize returns the block size of the current format.
+func (ns *Namespace) BlockSize() int {
+	return ns.Formats[ns.Current].BlockSize
+}
+
+// Bytes returns the size of the namespace.
+func (ns *Namespace) Bytes() uint64 {
+	return ns.Size * uint64(ns.BlockSize())
+}
+
+// ParseNamespace decodes the 4096 bytes of Identify Namespace data.
+func ParseNamespace(b []byte) (*Namespace, error) {
+	if len(b) < 4096 {
+		return nil, fmt.Errorf("nvme: Identify Namespace data of %d bytes", len(b))
+	}
+	ns := &Namespace{
+		Size:        binary.LittleEndian.Uint64(b[0:]),
+		Capacity:    binary.LittleEndian.Uint64(b[8:]),
+		Utilization: binary.LittleEndian.Uint64(b[16:]),
+		Current:     int(b[26]&0x0f | b[26]>>1&0x30),
+	}
+	copy(ns.NGUID[:], b[104:120])
+	copy(ns.EUI64[:], b[120:128])
+	for i := range int(b[25]) + 1 {
+		f := b[128+4*i:]
+		if f[2] < 9 || f[2] > 30 {
+			return nil, fmt.Errorf("nvme: LBA format %d has a block size of 2^%d", i, f[2])
+		}
+		ns.Formats = append(ns.Formats, Format{
+			MetadataSize: int(binary.LittleEndian.Uint16(f)),
+			BlockSize:    1 << f[2],
+			Performance:  f[3] & 0x03,
+		})
+	}
+	if ns.Current >= len(ns.Formats) {
+		return nil, fmt.Errorf("nvme: current LBA format %d of %d", ns.Current, len(ns.Formats))
+	}
+	return ns, nil
+}
+
+// uint128 decodes a little-endian 128-bit counter, saturating it at the
+// maximum uint64.
+func uint128(b []byte) uint64 {
+	if binary.LittleEndian.Uint64(b[8:]) != 0 {
+		return 1<<64 - 1
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func trimASCII(b []byte) string {
+	return strings.TrimRight(string(b), " \x00")
+}
@@ -0,0 +1,189 @@
+// Package nvme sends NVMe admin commands to drives in USB enclosures, and
+// decodes the Identify data and the SMART / Health Information log.
+//
+// An NVMe bridge presents the drive as a SCSI device, and tunnels a few
+// NVMe commands through vendor-specific SCSI commands. Detect picks the
+// Passthrough of the bridge from its USB vendor and product IDs and its
+// INQUIRY data, and checks that it answers Identify Controller.
+//
+// None of the passthroughs has been tested against a bridge: the ASMedia,
+// Realtek and JMicron protocols are implemented from their documentation
+// and smartmontools, and the tests only replay exchanges written from the
+// same sources. A bridge whose firmware differs may reject the commands or
+// answer them with data Detect takes for garbage.
+package nvme
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"example.com/usb/msc/scsi"
+)
+
+// Admin command opcodes.
+const (
+	OpGetLogPage = 0x02
+	OpIdentify   = 0x06
+)
+
+// Controller or Namespace Structure values of Identify.
+const (
+	CNSNamespace  = 0x00
+	CNSController = 0x01
+)
+
+// Log page identifiers.
+const (
+	LogError       = 0x01
+	LogSMARTHealth = 0x02
+	LogFirmware    = 0x03
+)
+
+// NamespaceAll is the namespace ID of commands on all namespaces, such as
+// reading the SMART / Health Information log of the controller.
+const NamespaceAll = 0xffffffff
+
+var (
+	// ErrUnsupported is returned by Detect for devices that are not known
+	// NVMe bridges, or whose passthrough does not work.
+	ErrUnsupported = errors.New("nvme: no NVMe passthrough supported")
+	// ErrCommand is returned for commands a bridge cannot send.
+	ErrCommand = errors.New("nvme: command not supported by the bridge")
+)
+
+// Command is an admin command: its opcode, namespace and command dwords.
+// The data pointer and command identifier are filled in by the bridge.
+type Command struct {
+	Opcode uint8
+	NSID   uint32
+	CDW10  uint32
+	CDW11  uint32
+	CDW12  uint32
+	CDW13  uint32
+	CDW14  uint32
+	CDW15  uint32
+}
+
+// Error is a command the controller completed with an error status.
+type Error struct {
+	Opcode uint8
+	// Status is the Status Field of the completion queue entry: the status
+	// code in bits 0 to 7 and the status code type in bits 8 to 10.
+	Status uint16
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("nvme: command %#02x failed: status code type %d, status code %#02x", e.Opcode, e.Status>>8&0x7, e.Status&0xff)
+}
+
+// Passthrough sends admin commands through a bridge.
+type Passthrough interface {
+	// Exec runs cmd, reading its data into data. Bridges only support
+	// commands that read data. It returns Dword 0 of the completion, or 0
+	// if the bridge does not return it.
+	Exec(cmd *Command, data []byte) (uint32, error)
+	// Name names the bridge, such as "ASMedia".
+	Name() string
+}
+
+// IdentifyController sends Identify with CNS 01h.
+func IdentifyController(p Passthrough) (*Controller, error) {
+	buf := make([]byte, 4096)
+	if _, err := p.Exec(&Command{Opcode: OpIdentify, CDW10: CNSController}, buf); err != nil {
+		return nil, err
+	}
+	return ParseController(buf)
+}
+
+// IdentifyNamespace sends Identify with CNS 00h for namespace nsid.
+func IdentifyNamespace(p Passthrough, nsid uint32) (*Namespace, error) {
+	buf := make([]byte, 4096)
+	if _, err := p.Exec(&Command{Opcode: OpIdentify, NSID: nsid, CDW10: CNSNamespace}, buf); err != nil {
+		return nil, err
+	}
+	return ParseNamespace(buf)
+}
+
+// GetLogPage reads len(data) bytes, a multiple of 4, of log page lid.
+func GetLogPage(p Passthrough, nsid uint32, lid uint8, data []byte) error {
+	if len(data) == 0 || len(data)%4 != 0 || len(data) > 4<<16 {
+		return fmt.Errorf("nvme: log page read of %d bytes", len(data))
+	}
+	numd := uint32(len(data)/4 - 1)
+	_, err := p.Exec(&Command{Opcode: OpGetLogPage, NSID: nsid, CDW10: numd<<16 | uint32(lid)}, data)
+	return err
+}
+
+// ReadHealth reads the SMART / Health Information log of the controller.
+func ReadHealth(p Passthrough) (*Health, error) {
+	buf := make([]byte, 512)
+	if err := GetLogPage(p, NamespaceAll, LogSMARTHealth, buf); err != nil {
+		return nil, err
+	}
+	return ParseHealth(buf)
+}
+
+// Bridge vendor IDs.
+const (
+	VendorASMedia = 0x174c
+	VendorJMicron = 0x152d
+	VendorRealtek = 0x0bda
+)
+
+// bridges are the NVMe bridges known by their product IDs. Their vendors
+// also make SATA bridges, so other products are only taken for NVMe
+// bridges if their INQUIRY data says so.
+var bridges = []struct {
+	vendor, product uint16
+	new             func(*scsi.Device) Passthrough
+}{
+	{VendorASMedia, 0x2362, NewASMedia},
+	{VendorASMedia, 0x2364, NewASMedia},
+	{VendorJMicron, 0x0562, NewJMicron},
+	{VendorJMicron, 0x0583, NewJMicron},
+	{VendorRealtek, 0x9210, NewRealtek},
+	{VendorRealtek, 0x9211, NewRealtek},
+}
+
+// inquiryBridge returns the passthrough of a bridge whose INQUIRY data
+// names an NVMe bridge, as enclosures with their own product IDs often do.
+func inquiryBridge(vendorID uint16, inq *scsi.InquiryData) func(*scsi.Device) Passthrough {
+	if inq == nil {
+		return nil
+	}
+	product := strings.ToUpper(inq.Product)
+	switch {
+	case vendorID == VendorASMedia && (strings.Contains(product, "NVME") || strings.HasPrefix(product, "236")):
+		return NewASMedia
+	case vendorID == VendorJMicron && strings.Contains(product, "NVME"):
+		return NewJMicron
+	case vendorID == VendorRealtek && (strings.Contains(product, "NVME") || strings.HasPrefix(product, "RTL921")):
+		return NewRealtek
+	}
+	return nil
+}
+
+// Detect returns the passthrough of the NVMe bridge with the given USB IDs
+// that d is behind, and the Identify Controller data with which it was
+// checked. inq is the INQUIRY data of d, or nil.
+func Detect(d *scsi.Device, vendorID, productID uint16, inq *scsi.InquiryData) (Passthrough, *Controller, error) {
+	var newBridge func(*scsi.Device) Passthrough
+	for _, b := range bridges {
+		if b.vendor == vendorID && b.product == productID {
+			newBridge = b.new
+		}
+	}
+	if newBridge == nil {
+		newBridge = inquiryBridge(vendorID, inq)
+	}
+	if newBridge == nil {
+		return nil, nil, fmt.Errorf("%w: %04x:%04x is not a known NVMe bridge", ErrUnsupported, vendorID, productID)
+	}
+	p := newBridge(d)
+	c, err := IdentifyController(p)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s: %w", ErrUnsupported, p.Name(), err)
+	}
+	return p, c, nil
+}
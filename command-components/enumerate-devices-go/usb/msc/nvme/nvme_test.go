@@ -0,0 +1,294 @@
+package nvme
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"example.com/usb"
+	"example.com/usb/msc/scsi"
+)
+
+// The fixtures in testdata hold the exchanges of each bridge's protocol,
+// answered with data modelled on a Samsung SSD 970 EVO Plus 1TB: the
+// command blocks the bridge is sent, the data written and read, and the
+// status returned. They were written by hand, not captured from hardware,
+// so they only check the encoding against the protocol as documented, not
+// that any bridge accepts it.
+
+type exchange struct {
+	line  int
+	cb    []byte
+	dir   usb.Direction
+	data  []byte // the data written, or the data read
+	sense []byte // nil for GOOD
+}
+
+// replay is a Transport that checks the commands it is sent against a
+// fixture, and answers them as the fixture says.
+type replay struct {
+	t         *testing.T
+	exchanges []exchange
+}
+
+func load(t *testing.T, name string) *replay {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := &replay{t: t}
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if err := r.parse(n, line); err != nil {
+			t.Fatalf("%s:%d: %v", name, n, err)
+		}
+	}
+	return r
+}
+
+func (r *replay) parse(n int, line string) error {
+	op, rest, _ := strings.Cut(line, " ")
+	if op == ">" {
+		cb, err := hex.DecodeString(strings.ReplaceAll(rest, " ", ""))
+		r.exchanges = append(r.exchanges, exchange{line: n, cb: cb})
+		return err
+	}
+	if len(r.exchanges) == 0 {
+		return errors.New("no command block")
+	}
+	e := &r.exchanges[len(r.exchanges)-1]
+	switch {
+	case op == "in" || op == "out":
+		size, err := strconv.Atoi(rest)
+		e.dir, e.data = usb.DirectionIn, make([]byte, size)
+		if op == "out" {
+			e.dir = usb.DirectionOut
+		}
+		return err
+	case op == "<" && rest == "good":
+		return nil
+	case op == "<" && strings.HasPrefix(rest, "check "):
+		var err error
+		e.sense, err = hex.DecodeString(strings.ReplaceAll(rest[6:], " ", ""))
+		return err
+	case op[0] == '@':
+		off, err := strconv.ParseUint(op[1:], 16, 32)
+		if err != nil {
+			return err
+		}
+		var b []byte
+		for rest != "" {
+			if rest[0] == '"' {
+				end := strings.IndexByte(rest[1:], '"')
+				if end < 0 {
+					return errors.New("unterminated string")
+				}
+				b, rest = append(b, rest[1:end+1]...), rest[end+2:]
+			} else {
+				field, tail, _ := strings.Cut(rest, " ")
+				x, err := strconv.ParseUint(field, 16, 8)
+				if err != nil {
+					return err
+				}
+				b, rest = append(b, uint8(x)), tail
+			}
+			rest = strings.TrimLeft(rest, " ")
+		}
+		if int(off)+len(b) > len(e.data) {
+			return fmt.Errorf("%d bytes at %#x of %d", len(b), off, len(e.data))
+		}
+		copy(e.data[off:], b)
+		return nil
+	}
+	return fmt.Errorf("unknown line %q", line)
+}
+
+func (r *replay) Command(lun uint8, cb []byte, dir usb.Direction, data []byte) (scsi.Completion, error) {
+	r.t.Helper()
+	if len(r.exchanges) == 0 {
+		r.t.Fatalf("unexpected command %x", cb)
+	}
+	e := r.exchanges[0]
+	r.exchanges = r.exchanges[1:]
+	if !bytes.Equal(cb, e.cb) || dir != e.dir || len(data) != len(e.data) {
+		r.t.Fatalf("line %d: command %x, %d bytes %v; want %x, %d bytes %v", e.line, cb, len(data), dir, e.cb, len(e.data), e.dir)
+	}
+	if dir == usb.DirectionOut && !bytes.Equal(data, e.data) {
+		r.t.Fatalf("line %d: wrote %x", e.line, data)
+	}
+	if dir == usb.DirectionIn {
+		copy(data, e.data)
+	}
+	if e.sense != nil {
+		return scsi.Completion{Status: scsi.StatusCheckCondition, Residue: uint32(len(data)), Sense: e.sense}, nil
+	}
+	return scsi.Completion{}, nil
+}
+
+func (r *replay) done() {
+	r.t.Helper()
+	if len(r.exchanges) > 0 {
+		r.t.Errorf("line %d: command %x not sent", r.exchanges[0].line, r.exchanges[0].cb)
+	}
+}
+
+func checkDrive(t *testing.T, p Passthrough, c *Controller) {
+	t.Helper()
+	if c.Model != "Samsung SSD 970 EVO Plus 1TB" || c.Serial != "S4EWNX0R123456A" || c.Firmware != "2B2QEXM7" {
+		t.Errorf("controller %q %q %q", c.Model, c.Serial, c.Firmware)
+	}
+	if c.VendorID != 0x144d || c.OUI != 0x002538 || c.MaxTransferShift != 9 || c.ControllerID != 4 || c.VersionString() != "1.3" {
+		t.Errorf("controller %+v", c)
+	}
+	if c.WarningTemp != 358 || c.CriticalTemp != 361 || c.TotalCapacity != 1000204886016 || c.Namespaces != 1 {
+		t.Errorf("controller %+v", c)
+	}
+
+	ns, err := IdentifyNamespace(p, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.Size != 1953525168 || ns.Capacity != ns.Size || ns.Utilization != 412327502 || ns.BlockSize() != 512 || ns.Bytes() != 1000204886016 {
+		t.Errorf("namespace %+v", ns)
+	}
+	if len(ns.Formats) != 1 || ns.Formats[0].Performance != 2 || ns.EUI64 != [8]byte{0x00, 0x25, 0x38, 0x5b, 0x91, 0xb0, 0x12, 0x34} {
+		t.Errorf("namespace %+v", ns)
+	}
+
+	h, err := ReadHealth(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Health{
+		Temperature:             321,
+		AvailableSpare:          100,
+		AvailableSpareThreshold: 10,
+		PercentageUsed:          2,
+		DataUnitsRead:           0x00a1b2c3,
+		DataUnitsWritten:        0x0155aa10,
+		HostReads:               0x023b4d9e,
+		HostWrites:              0x01c76158,
+		BusyMinutes:             500,
+		PowerCycles:             611,
+		PowerOnHours:            3333,
+		UnsafeShutdowns:         28,
+		ErrorLogEntries:         15,
+		Sensors:                 []uint16{321, 331},
+	}
+	if fmt.Sprint(*h) != fmt.Sprint(want) {
+		t.Errorf("health %+v", *h)
+	}
+	if h.Celsius() != 48 || h.Failing() || h.CriticalWarning.String() != "none" {
+		t.Errorf("%d °C, warning %v", h.Celsius(), h.CriticalWarning)
+	}
+}
+
+func TestASMedia(t *testing.T) {
+	r := load(t, "asmedia.txt")
+	p, c, err := Detect(scsi.New(r, 0), VendorASMedia, 0x2362, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name() != "ASMedia" {
+		t.Errorf("bridge %s", p.Name())
+	}
+	checkDrive(t, p, c)
+	r.done()
+
+	// Commands the bridge cannot send are refused before they reach it.
+	for _, cmd := range []*Command{
+		{Opcode: 0x0a, CDW10: 0x07},                       // Get Features
+		{Opcode: OpIdentify, NSID: 2},                     // another namespace
+		{Opcode: OpGetLogPage, CDW10: 0x03ff0002},         // 4 KiB
+		{Opcode: OpGetLogPage, CDW10: 0x7f0002, CDW12: 8}, // an offset
+	} {
+		if _, err := p.Exec(cmd, make([]byte, 512)); !errors.Is(err, ErrCommand) {
+			t.Errorf("%+v: %v", cmd, err)
+		}
+	}
+}
+
+func TestRealtek(t *testing.T) {
+	r := load(t, "realtek.txt")
+	// An enclosure with its own product ID, known by its INQUIRY data.
+	inq := &scsi.InquiryData{Vendor: "Realtek", Product: "RTL9210B NVME"}
+	p, c, err := Detect(scsi.New(r, 0), VendorRealtek, 0x9220, inq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name() != "Realtek" {
+		t.Errorf("bridge %s", p.Name())
+	}
+	checkDrive(t, p, c)
+
+	if err := GetLogPage(p, NamespaceAll, 0xc0, make([]byte, 512)); !errors.Is(err, scsi.ErrInvalidField) {
+		t.Errorf("vendor log page: %v", err)
+	}
+	r.done()
+
+	// The bridge computes the number of dwords from the length.
+	if _, err := p.Exec(&Command{Opcode: OpGetLogPage, CDW10: 0x3f0002}, make([]byte, 512)); !errors.Is(err, ErrCommand) {
+		t.Errorf("mismatched length: %v", err)
+	}
+}
+
+func TestJMicron(t *testing.T) {
+	r := load(t, "jmicron.txt")
+	p, c, err := Detect(scsi.New(r, 0), VendorJMicron, 0x0583, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name() != "JMicron" {
+		t.Errorf("bridge %s", p.Name())
+	}
+	checkDrive(t, p, c)
+
+	_, err = IdentifyNamespace(p, 2)
+	var e *Error
+	if !errors.As(err, &e) || e.Opcode != OpIdentify || e.Status != 0x0b {
+		t.Errorf("invalid namespace: %v", err)
+	}
+	r.done()
+}
+
+func TestDetectUnknown(t *testing.T) {
+	r := &replay{t: t}
+	for _, test := range []struct {
+		vendor, product uint16
+		inq             *scsi.InquiryData
+	}{
+		{VendorASMedia, 0x55aa, &scsi.InquiryData{Vendor: "ASMT", Product: "2115"}}, // a SATA bridge
+		{VendorJMicron, 0x0578, nil},
+		{0x0781, 0x5581, &scsi.InquiryData{Vendor: "SanDisk", Product: "Ultra"}},
+	} {
+		if _, _, err := Detect(scsi.New(r, 0), test.vendor, test.product, test.inq); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%04x:%04x: %v", test.vendor, test.product, err)
+		}
+	}
+}
+
+func TestCriticalWarning(t *testing.T) {
+	h, err := ParseHealth(append([]byte{0x05}, make([]byte, 511)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.Failing() || h.CriticalWarning.String() != "available spare low, reliability degraded" {
+		t.Errorf("warning %v", h.CriticalWarning)
+	}
+	if s := CriticalWarning(0x82).String(); s != "temperature, 0x80" {
+		t.Errorf("warning %s", s)
+	}
+}
@@ -0,0 +1,4 @@
+package partition
+
+// OpenImage gives the tests of package partition_test the disk images.
+var OpenImage = openImage
@@ -0,0 +1,49 @@
+package partition_test
+
+import (
+	"testing"
+
+	"example.com/usb/msc/fat"
+	"example.com/usb/msc/partition"
+)
+
+// The FAT checks are in package partition_test because the fat package
+// imports this one.
+
+// checkFAT opens the file system in p and reads a file from it.
+func checkFAT(t *testing.T, disk partition.Disk, p partition.Partition) {
+	t.Helper()
+	f, err := fat.Open(partition.NewDevice(disk, p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Label() != "TESTVOL" {
+		t.Errorf("label %q", f.Label())
+	}
+	if _, err := f.Stat("README.TXT"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadFAT(t *testing.T) {
+	for _, name := range []string{"mbr.img.gz", "gpt.img.gz"} {
+		disk := partition.OpenImage(t, name)
+		table, err := partition.Read(disk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkFAT(t, disk, table.Partitions[0])
+	}
+}
+
+func TestWriteFAT(t *testing.T) {
+	// An MBR written over the GPT leaves the file system where it was.
+	disk := partition.OpenImage(t, "gpt.img.gz")
+	table := &partition.Table{Scheme: partition.MBR, Partitions: []partition.Partition{
+		{Type: 0xef, Start: 2048, Length: 2880},
+	}}
+	if err := partition.Write(disk, table); err != nil {
+		t.Fatal(err)
+	}
+	checkFAT(t, disk, table.Partitions[0])
+}
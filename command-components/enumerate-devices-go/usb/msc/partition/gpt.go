@@ -0,0 +1,241 @@
+package partition
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	gptSignature  = "EFI PART"
+	gptRevision   = 0x00010000
+	gptHeaderSize = 92
+	gptEntrySize  = 128
+	gptEntries    = 128     // entries in the arrays Write creates
+	maxEntryBytes = 1 << 20 // bounds the entry array Read accepts
+	gptNameLen    = 36      // UTF-16 code units
+)
+
+// GUID is a globally unique identifier in the byte order GPT stores it:
+// the first three fields are little-endian.
+type GUID [16]byte
+
+// ParseGUID parses the text form of a GUID, such as
+// "C12A7328-F81F-11D2-BA4B-00A0C93EC93B".
+func ParseGUID(s string) (GUID, error) {
+	var g GUID
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 || len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return g, fmt.Errorf("partition: invalid GUID %q", s)
+	}
+	binary.LittleEndian.PutUint32(g[0:], binary.BigEndian.Uint32(b[0:]))
+	binary.LittleEndian.PutUint16(g[4:], binary.BigEndian.Uint16(b[4:]))
+	binary.LittleEndian.PutUint16(g[6:], binary.BigEndian.Uint16(b[6:]))
+	copy(g[8:], b[8:])
+	return g, nil
+}
+
+func mustParseGUID(s string) GUID {
+	g, err := ParseGUID(s)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// NewGUID returns a random (version 4) GUID.
+func NewGUID() (GUID, error) {
+	var g GUID
+	if _, err := rand.Read(g[:]); err != nil {
+		return g, err
+	}
+	g[7] = g[7]&0x0f | 0x40 // the version is in the high bits of the third field
+	g[8] = g[8]&0x3f | 0x80
+	return g, nil
+}
+
+func (g GUID) String() string {
+	return fmt.Sprintf("%08X-%04X-%04X-%X-%X",
+		binary.LittleEndian.Uint32(g[0:]), binary.LittleEndian.Uint16(g[4:]), binary.LittleEndian.Uint16(g[6:]), g[8:10], g[10:])
+}
+
+// Well-known partition type GUIDs.
+var (
+	TypeEFISystem          = mustParseGUID("C12A7328-F81F-11D2-BA4B-00A0C93EC93B")
+	TypeBIOSBoot           = mustParseGUID("21686148-6449-6E6F-744E-656564454649")
+	TypeMicrosoftReserved  = mustParseGUID("E3C9E316-0B5C-4DB8-817D-F92DF00215AE")
+	TypeMicrosoftBasicData = mustParseGUID("EBD0A0A2-B9E5-4433-87C0-68B6B72699C7")
+	TypeWindowsRecovery    = mustParseGUID("DE94BBA4-06D1-4D40-A16A-BFD50179D6AC")
+	TypeLinuxFilesystem    = mustParseGUID("0FC63DAF-8483-4772-8E79-3D69D8477DE4")
+	TypeLinuxSwap          = mustParseGUID("0657FD6D-A4AB-43C4-84E5-0933C84B4F4F")
+	TypeLinuxLVM           = mustParseGUID("E6D6D379-F507-44C2-A23C-238F2A3DF928")
+	TypeLinuxRAID          = mustParseGUID("A19D880F-05FC-4D3B-A006-743F0F84911E")
+	TypeLinuxRootAMD64     = mustParseGUID("4F68BCE3-E8CD-4DB1-96E7-FBCAF984B709")
+	TypeLinuxRootARM64     = mustParseGUID("B921B045-1DF0-41C3-AF44-4C6F280D3FAE")
+	TypeLinuxHome          = mustParseGUID("933AC7E1-2EB4-4F13-B844-0E14E2AEF915")
+	TypeAppleHFSPlus       = mustParseGUID("48465300-0000-11AA-AA11-00306543ECAC")
+	TypeAppleAPFS          = mustParseGUID("7C3457EF-0000-11AA-AA11-00306543ECAC")
+	TypeFreeBSDUFS         = mustParseGUID("516E7CB6-6ECF-11D6-8FF8-00022D09712B")
+	TypeChromeOSKernel     = mustParseGUID("FE3A2A5D-4F32-41A7-B725-ACCC3285A309")
+	TypeStorageSpaces      = mustParseGUID("E75CAF8F-F680-4CEE-AFA3-B001E56EFC2D")
+)
+
+var gptTypes = map[GUID]string{
+	TypeEFISystem:          "EFI System",
+	TypeBIOSBoot:           "BIOS boot",
+	TypeMicrosoftReserved:  "Microsoft reserved",
+	TypeMicrosoftBasicData: "Microsoft basic data",
+	TypeWindowsRecovery:    "Windows recovery environment",
+	TypeLinuxFilesystem:    "Linux filesystem",
+	TypeLinuxSwap:          "Linux swap",
+	TypeLinuxLVM:           "Linux LVM",
+	TypeLinuxRAID:          "Linux RAID",
+	TypeLinuxRootAMD64:     "Linux root (x86-64)",
+	TypeLinuxRootARM64:     "Linux root (ARM-64)",
+	TypeLinuxHome:          "Linux home",
+	TypeAppleHFSPlus:       "Apple HFS/HFS+",
+	TypeAppleAPFS:          "Apple APFS",
+	TypeFreeBSDUFS:         "FreeBSD UFS",
+	TypeChromeOSKernel:     "ChromeOS kernel",
+	TypeStorageSpaces:      "Microsoft Storage Spaces",
+}
+
+// gptHeader is a GPT header with the entry array it describes.
+type gptHeader struct {
+	lba         uint64
+	alternate   uint64
+	firstUsable uint64
+	lastUsable  uint64
+	diskGUID    GUID
+	entriesLBA  uint64
+	count       uint32
+	entrySize   uint32
+	entriesCRC  uint32
+	entries     []byte
+}
+
+// readHeader reads the header at lba and its entry array and checks both
+// CRCs.
+func readHeader(disk Disk, lba uint64) (*gptHeader, error) {
+	bs := disk.BlockSize()
+	blocks := uint64(disk.Size() / int64(bs))
+	if lba >= blocks {
+		return nil, fmt.Errorf("header at block %d is beyond the disk", lba)
+	}
+	b := make([]byte, bs)
+	if _, err := disk.ReadAt(b, int64(lba)*int64(bs)); err != nil {
+		return nil, err
+	}
+	if string(b[:8]) != gptSignature {
+		return nil, fmt.Errorf("no header at block %d", lba)
+	}
+	size := binary.LittleEndian.Uint32(b[12:])
+	if size < gptHeaderSize || size > uint32(bs) {
+		return nil, fmt.Errorf("header at block %d has size %d", lba, size)
+	}
+	hdr := bytes.Clone(b[:size])
+	clear(hdr[16:20])
+	if crc32.ChecksumIEEE(hdr) != binary.LittleEndian.Uint32(b[16:]) {
+		return nil, fmt.Errorf("header at block %d fails its CRC", lba)
+	}
+	h := &gptHeader{
+		lba:         binary.LittleEndian.Uint64(b[24:]),
+		alternate:   binary.LittleEndian.Uint64(b[32:]),
+		firstUsable: binary.LittleEndian.Uint64(b[40:]),
+		lastUsable:  binary.LittleEndian.Uint64(b[48:]),
+		entriesLBA:  binary.LittleEndian.Uint64(b[72:]),
+		count:       binary.LittleEndian.Uint32(b[80:]),
+		entrySize:   binary.LittleEndian.Uint32(b[84:]),
+		entriesCRC:  binary.LittleEndian.Uint32(b[88:]),
+	}
+	copy(h.diskGUID[:], b[56:])
+	if h.lba != lba {
+		return nil, fmt.Errorf("header at block %d says it is at block %d", lba, h.lba)
+	}
+	n := uint64(h.count) * uint64(h.entrySize)
+	if h.entrySize < gptEntrySize || h.entrySize&(h.entrySize-1) != 0 || n > maxEntryBytes {
+		return nil, fmt.Errorf("header at block %d has %d entries of %d bytes", lba, h.count, h.entrySize)
+	}
+	if h.entriesLBA+(n+uint64(bs)-1)/uint64(bs) > blocks {
+		return nil, fmt.Errorf("entries of header at block %d are beyond the disk", lba)
+	}
+	h.entries = make([]byte, n)
+	if _, err := disk.ReadAt(h.entries, int64(h.entriesLBA)*int64(bs)); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(h.entries) != h.entriesCRC {
+		return nil, fmt.Errorf("entries of header at block %d fail their CRC", lba)
+	}
+	return h, nil
+}
+
+// readGPT reads the primary GPT, or the backup if the primary is damaged.
+func readGPT(disk Disk) (*Table, error) {
+	last := uint64(disk.Size()/int64(disk.BlockSize())) - 1
+	primary, perr := readHeader(disk, 1)
+	backupLBA := last
+	if perr == nil {
+		backupLBA = primary.alternate
+	}
+	backup, berr := readHeader(disk, backupLBA)
+	h := primary
+	var warnings []string
+	switch {
+	case perr != nil && berr != nil:
+		return nil, fmt.Errorf("%w: primary GPT: %v; backup GPT: %v", ErrCorrupt, perr, berr)
+	case perr != nil:
+		h = backup
+		warnings = append(warnings, fmt.Sprintf("primary GPT is damaged (%v); using the backup", perr))
+	case berr != nil:
+		warnings = append(warnings, fmt.Sprintf("backup GPT is damaged: %v", berr))
+	case primary.entriesCRC != backup.entriesCRC || primary.diskGUID != backup.diskGUID:
+		warnings = append(warnings, "primary and backup GPT differ")
+	}
+	if h.alternate != last && h.lba != last {
+		warnings = append(warnings, fmt.Sprintf("backup GPT is at block %d, not at the end of the disk", max(h.alternate, h.lba)))
+	}
+
+	t := &Table{
+		Scheme: GPT, DiskGUID: h.diskGUID,
+		FirstUsable: h.firstUsable, LastUsable: h.lastUsable,
+		Warnings: warnings,
+	}
+	for i := range int(h.count) {
+		e := h.entries[i*int(h.entrySize):]
+		p := Partition{Number: i + 1}
+		copy(p.TypeGUID[:], e[0:16])
+		if p.TypeGUID == (GUID{}) {
+			continue
+		}
+		copy(p.GUID[:], e[16:32])
+		first, last := binary.LittleEndian.Uint64(e[32:]), binary.LittleEndian.Uint64(e[40:])
+		if last < first || first < h.firstUsable || last > h.lastUsable {
+			t.Warnings = append(t.Warnings, fmt.Sprintf("partition %d at blocks %d to %d is outside the usable blocks", p.Number, first, last))
+		}
+		p.Start = first
+		if last >= first {
+			p.Length = last - first + 1
+		}
+		p.Attributes = binary.LittleEndian.Uint64(e[48:])
+		p.Name = decodeName(e[56:128])
+		t.Partitions = append(t.Partitions, p)
+	}
+	return t, nil
+}
+
+func decodeName(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		u := binary.LittleEndian.Uint16(b[i:])
+		if u == 0 {
+			break
+		}
+		units = append(units, u)
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,124 @@
+package partition
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const (
+	mbrEntries     = 446 // offset of the four partition entries
+	typeProtective = 0xee
+	maxLogical     = 128 // bounds the chain of extended boot records
+)
+
+// Names of common MBR partition types.
+var mbrTypes = map[byte]string{
+	0x01: "FAT12",
+	0x04: "FAT16 <32M",
+	0x05: "Extended",
+	0x06: "FAT16",
+	0x07: "HPFS/NTFS/exFAT",
+	0x0b: "W95 FAT32",
+	0x0c: "W95 FAT32 (LBA)",
+	0x0e: "W95 FAT16 (LBA)",
+	0x0f: "W95 Extended (LBA)",
+	0x11: "Hidden FAT12",
+	0x82: "Linux swap",
+	0x83: "Linux",
+	0x85: "Linux extended",
+	0x8e: "Linux LVM",
+	0xa5: "FreeBSD",
+	0xa6: "OpenBSD",
+	0xaf: "HFS / HFS+",
+	0xee: "GPT protective",
+	0xef: "EFI (FAT-12/16/32)",
+	0xfd: "Linux raid autodetect",
+}
+
+func extended(typ byte) bool {
+	return typ == 0x05 || typ == 0x0f || typ == 0x85
+}
+
+// mbrEntry is one of the four entries of an MBR or an extended boot record.
+type mbrEntry struct {
+	flag   byte
+	typ    byte
+	start  uint32
+	length uint32
+}
+
+func parseEntry(b []byte) mbrEntry {
+	return mbrEntry{
+		flag:   b[0],
+		typ:    b[4],
+		start:  binary.LittleEndian.Uint32(b[8:]),
+		length: binary.LittleEndian.Uint32(b[12:]),
+	}
+}
+
+func readMBR(disk Disk, b []byte) (*Table, error) {
+	blocks := uint64(disk.Size() / int64(disk.BlockSize()))
+	t := &Table{Scheme: MBR, DiskSignature: binary.LittleEndian.Uint32(b[440:])}
+	hasExtended := false
+	for i := range 4 {
+		e := parseEntry(b[mbrEntries+16*i:])
+		if e.typ == 0 || e.length == 0 {
+			continue
+		}
+		if uint64(e.start)+uint64(e.length) > blocks {
+			t.Warnings = append(t.Warnings, fmt.Sprintf("partition %d ends beyond the disk", i+1))
+		}
+		if extended(e.typ) {
+			if hasExtended {
+				return nil, fmt.Errorf("%w: more than one extended partition", ErrCorrupt)
+			}
+			hasExtended = true
+			if err := t.readLogical(disk, uint64(e.start), uint64(e.length)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		t.Partitions = append(t.Partitions, Partition{
+			Number: i + 1, Type: e.typ, Bootable: e.flag == 0x80,
+			Start: uint64(e.start), Length: uint64(e.length),
+		})
+	}
+	sort.Slice(t.Partitions, func(i, j int) bool { return t.Partitions[i].Number < t.Partitions[j].Number })
+	return t, nil
+}
+
+// readLogical follows the chain of extended boot records in the extended
+// partition at base. Each holds a logical partition, relative to itself,
+// and a link to the next, relative to base.
+func (t *Table) readLogical(disk Disk, base, length uint64) error {
+	bs := disk.BlockSize()
+	b := make([]byte, bs)
+	seen := map[uint64]bool{}
+	number := 5
+	for ebr := base; len(seen) < maxLogical; {
+		if seen[ebr] || ebr < base || ebr >= base+length {
+			return fmt.Errorf("%w: extended boot record chain is broken at block %d", ErrCorrupt, ebr)
+		}
+		seen[ebr] = true
+		if _, err := disk.ReadAt(b, int64(ebr)*int64(bs)); err != nil {
+			return err
+		}
+		if b[510] != 0x55 || b[511] != 0xaa {
+			return fmt.Errorf("%w: extended boot record at block %d has no signature", ErrCorrupt, ebr)
+		}
+		e, next := parseEntry(b[mbrEntries:]), parseEntry(b[mbrEntries+16:])
+		if e.typ != 0 && e.length != 0 {
+			t.Partitions = append(t.Partitions, Partition{
+				Number: number, Type: e.typ, Bootable: e.flag == 0x80, Logical: true,
+				Start: ebr + uint64(e.start), Length: uint64(e.length),
+			})
+			number++
+		}
+		if !extended(next.typ) || next.length == 0 {
+			return nil
+		}
+		ebr = base + uint64(next.start)
+	}
+	return fmt.Errorf("%w: more than %d logical partitions", ErrCorrupt, maxLogical)
+}
@@ -0,0 +1,224 @@
+// Package partition reads and writes MBR and GPT partition tables and gives
+// access to each partition as a block device of its own.
+//
+// Read finds the table on a disk, such as an msc.BlockDevice. A GPT is
+// checked against its CRCs and the backup copy at the end of the disk is
+// used if the primary one is damaged. NewDevice returns the part of the disk
+// a partition covers, which the fat and exfat packages can open.
+package partition
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrNoTable  = errors.New("partition: no partition table")
+	ErrCorrupt  = errors.New("partition: partition table is corrupt")
+	ErrInvalid  = errors.New("partition: invalid partition table")
+	ErrReadOnly = errors.New("partition: disk is not writable")
+)
+
+// Disk is a block device, such as an msc.BlockDevice.
+type Disk interface {
+	io.ReaderAt
+	BlockSize() int
+	Size() int64
+}
+
+// WritableDisk is a Disk that can be written.
+type WritableDisk interface {
+	Disk
+	io.WriterAt
+}
+
+// Scheme is the kind of partition table.
+type Scheme int
+
+const (
+	MBR Scheme = iota + 1
+	GPT
+)
+
+func (s Scheme) String() string {
+	switch s {
+	case MBR:
+		return "MBR"
+	case GPT:
+		return "GPT"
+	}
+	return fmt.Sprintf("Scheme(%d)", int(s))
+}
+
+// Table is a partition table.
+type Table struct {
+	Scheme Scheme
+
+	// DiskSignature identifies an MBR disk.
+	DiskSignature uint32
+
+	// DiskGUID identifies a GPT disk. FirstUsable and LastUsable bound the
+	// blocks partitions may use; zero values are filled in when the table
+	// is written.
+	DiskGUID    GUID
+	FirstUsable uint64
+	LastUsable  uint64
+
+	Partitions []Partition
+
+	// Warnings describes damage that Read recovered from, such as a
+	// primary GPT replaced by its backup.
+	Warnings []string
+}
+
+// Partition is an entry of a partition table. Start and Length are in
+// blocks of the disk.
+type Partition struct {
+	// Number is the partition number: the entry index plus one for GPT
+	// and the primary entries of an MBR, and 5 onwards for logical
+	// partitions, as Linux numbers them.
+	Number int
+	Start  uint64
+	Length uint64
+
+	// MBR only.
+	Type     byte
+	Bootable bool
+	Logical  bool // in the extended partition
+
+	// GPT only.
+	TypeGUID   GUID
+	GUID       GUID
+	Name       string
+	Attributes uint64
+}
+
+// End returns the block after the partition.
+func (p *Partition) End() uint64 {
+	return p.Start + p.Length
+}
+
+// TypeName returns a description of the partition type, or its number or
+// GUID if it is not a well-known one.
+func (p *Partition) TypeName() string {
+	if p.TypeGUID != (GUID{}) {
+		if name, ok := gptTypes[p.TypeGUID]; ok {
+			return name
+		}
+		return p.TypeGUID.String()
+	}
+	if name, ok := mbrTypes[p.Type]; ok {
+		return name
+	}
+	return fmt.Sprintf("type %#02x", p.Type)
+}
+
+// Read reads the partition table of disk. It returns ErrNoTable if the
+// disk has none, which includes a disk whose file system starts at block 0.
+func Read(disk Disk) (*Table, error) {
+	bs := disk.BlockSize()
+	if bs < 512 || bs&(bs-1) != 0 {
+		return nil, fmt.Errorf("partition: unsupported block size %d", bs)
+	}
+	b := make([]byte, bs)
+	if _, err := disk.ReadAt(b, 0); err != nil {
+		return nil, err
+	}
+	if b[510] != 0x55 || b[511] != 0xaa || bootSector(b) {
+		return nil, ErrNoTable
+	}
+	for i := range 4 {
+		if b[mbrEntries+16*i+4] == typeProtective {
+			return readGPT(disk)
+		}
+	}
+	return readMBR(disk, b)
+}
+
+// bootSector reports whether b is the boot sector of a file system rather
+// than a master boot record; both end in the same signature.
+func bootSector(b []byte) bool {
+	switch {
+	case string(b[3:11]) == "EXFAT   ", string(b[3:11]) == "NTFS    ":
+		return true
+	case string(b[54:59]) == "FAT12", string(b[54:59]) == "FAT16", string(b[82:87]) == "FAT32":
+		return true
+	}
+	// A valid MBR has only 0x00 and 0x80 as boot indicators.
+	for i := range 4 {
+		if flag := b[mbrEntries+16*i]; flag != 0 && flag != 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+// Device is the part of a disk a partition covers. Offsets are relative to
+// the start of the partition and accesses beyond its end fail.
+type Device struct {
+	disk   Disk
+	offset int64
+	size   int64
+}
+
+var _ interface {
+	Disk
+	io.WriterAt
+} = (*Device)(nil)
+
+// NewDevice returns the part of disk that p covers.
+func NewDevice(disk Disk, p Partition) *Device {
+	bs := int64(disk.BlockSize())
+	return &Device{disk: disk, offset: int64(p.Start) * bs, size: int64(p.Length) * bs}
+}
+
+// BlockSize returns the block size of the disk.
+func (d *Device) BlockSize() int {
+	return d.disk.BlockSize()
+}
+
+// Size returns the size of the partition in bytes.
+func (d *Device) Size() int64 {
+	return d.size
+}
+
+// ReadAt reads len(p) bytes at offset off in the partition.
+func (d *Device) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("partition: negative offset %d", off)
+	}
+	if off >= d.size {
+		return 0, io.EOF
+	}
+	var eof error
+	if rest := d.size - off; int64(len(p)) > rest {
+		p, eof = p[:rest], io.EOF
+	}
+	n, err := d.disk.ReadAt(p, d.offset+off)
+	if err == nil {
+		err = eof
+	}
+	return n, err
+}
+
+// WriteAt writes p at offset off in the partition. It fails with
+// ErrReadOnly if the disk is not an io.WriterAt.
+func (d *Device) WriteAt(p []byte, off int64) (int, error) {
+	w, ok := d.disk.(io.WriterAt)
+	if !ok {
+		return 0, ErrReadOnly
+	}
+	if off < 0 || off+int64(len(p)) > d.size {
+		return 0, fmt.Errorf("partition: write of %d bytes at %d beyond the end of the partition", len(p), off)
+	}
+	return w.WriteAt(p, d.offset+off)
+}
+
+// Sync syncs the disk if it can be.
+func (d *Device) Sync() error {
+	if s, ok := d.disk.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
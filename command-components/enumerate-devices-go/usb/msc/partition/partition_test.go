@@ -0,0 +1,248 @@
+package partition
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example.com/usb/msc"
+)
+
+var _ Disk = (*msc.BlockDevice)(nil)
+
+// memDisk is a disk in memory. Writes fail once failAfter reaches zero, if
+// it was positive.
+type memDisk struct {
+	data      []byte
+	blockSize int
+	failAfter int
+}
+
+func newDisk(blocks, blockSize int) *memDisk {
+	return &memDisk{data: make([]byte, blocks*blockSize), blockSize: blockSize}
+}
+
+func (d *memDisk) BlockSize() int { return d.blockSize }
+func (d *memDisk) Size() int64    { return int64(len(d.data)) }
+
+func (d *memDisk) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, d.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (d *memDisk) WriteAt(p []byte, off int64) (int, error) {
+	if d.failAfter > 0 {
+		if d.failAfter--; d.failAfter == 0 {
+			return 0, errors.New("device gone")
+		}
+	}
+	if off+int64(len(p)) > int64(len(d.data)) {
+		return 0, io.ErrShortWrite
+	}
+	return copy(d.data[off:], p), nil
+}
+
+// The images in testdata are 16 MiB disks with 512-byte blocks, made
+// following the specifications. Both have the FAT12 image of the fat
+// package in partition 1.
+//
+// mbr.img: a bootable FAT12 partition 1, an extended partition 2 with
+// logical partitions 5 (Linux) and 6 (NTFS), and swap in partition 3.
+//
+// gpt.img: an EFI system partition 1, basic data partition 2 and a Linux
+// partition in entry 4.
+func openImage(t *testing.T, name string) *memDisk {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &memDisk{data: data, blockSize: 512}
+}
+
+func TestReadMBR(t *testing.T) {
+	disk := openImage(t, "mbr.img.gz")
+	table, err := Read(disk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Scheme != MBR || table.DiskSignature != 0xdeadbeef || len(table.Warnings) != 0 {
+		t.Errorf("table %+v", table)
+	}
+	want := []Partition{
+		{Number: 1, Start: 2048, Length: 2880, Type: 0x01, Bootable: true},
+		{Number: 3, Start: 24576, Length: 8192, Type: 0x82},
+		{Number: 5, Start: 8192, Length: 4096, Type: 0x83, Logical: true},
+		{Number: 6, Start: 14336, Length: 6144, Type: 0x07, Logical: true},
+	}
+	if len(table.Partitions) != len(want) {
+		t.Fatalf("partitions %+v", table.Partitions)
+	}
+	for i, p := range table.Partitions {
+		if p != want[i] {
+			t.Errorf("partition %d: %+v, want %+v", i, p, want[i])
+		}
+	}
+	if name := table.Partitions[3].TypeName(); name != "HPFS/NTFS/exFAT" {
+		t.Errorf("type name %q", name)
+	}
+}
+
+func TestReadGPT(t *testing.T) {
+	disk := openImage(t, "gpt.img.gz")
+	table, err := Read(disk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Scheme != GPT || table.DiskGUID.String() != "2A5F3C6B-1D2E-4F30-8A9B-0C1D2E3F4A5B" ||
+		table.FirstUsable != 34 || table.LastUsable != 32734 || len(table.Warnings) != 0 {
+		t.Errorf("table %+v", table)
+	}
+	want := []struct {
+		number        int
+		start, length uint64
+		typ, name     string
+		attributes    uint64
+	}{
+		{1, 2048, 2880, "EFI System", "EFI system partition", 1},
+		{2, 6144, 8192, "Microsoft basic data", "Data", 1 << 60},
+		{4, 16384, 16351, "Linux filesystem", "root fs ü", 0},
+	}
+	if len(table.Partitions) != len(want) {
+		t.Fatalf("partitions %+v", table.Partitions)
+	}
+	for i, p := range table.Partitions {
+		w := want[i]
+		if p.Number != w.number || p.Start != w.start || p.Length != w.length || p.TypeName() != w.typ || p.Name != w.name || p.Attributes != w.attributes {
+			t.Errorf("partition %d: %+v", i, p)
+		}
+	}
+	if g := table.Partitions[2].GUID.String(); g != "11111111-2222-4333-8444-777777777777" {
+		t.Errorf("GUID %s", g)
+	}
+}
+
+func TestGPTRecovery(t *testing.T) {
+	const last = 32767
+	tests := []struct {
+		name    string
+		corrupt []int64 // byte offsets to change
+		warning string
+		err     error
+	}{
+		{"primary header", []int64{512 + 40}, "primary GPT is damaged (header at block 1 fails its CRC); using the backup", nil},
+		{"primary entries", []int64{2*512 + 200}, "primary GPT is damaged (entries of header at block 1 fail their CRC); using the backup", nil},
+		{"primary signature", []int64{512}, "primary GPT is damaged (no header at block 1); using the backup", nil},
+		{"backup header", []int64{last*512 + 60}, "backup GPT is damaged: header at block 32767 fails its CRC", nil},
+		{"both", []int64{512 + 40, last*512 + 60}, "", ErrCorrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disk := openImage(t, "gpt.img.gz")
+			for _, off := range tt.corrupt {
+				disk.data[off] ^= 0xff
+			}
+			table, err := Read(disk)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("err = %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(table.Warnings) != 1 || table.Warnings[0] != tt.warning {
+				t.Errorf("warnings %q", table.Warnings)
+			}
+			if len(table.Partitions) != 3 || table.Partitions[1].Name != "Data" {
+				t.Errorf("partitions %+v", table.Partitions)
+			}
+		})
+	}
+}
+
+func TestBackupNotAtEnd(t *testing.T) {
+	// A GPT image written to a larger disk.
+	disk := openImage(t, "gpt.img.gz")
+	disk.data = append(disk.data, make([]byte, 1<<20)...)
+	table, err := Read(disk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table.Warnings) != 1 || !strings.Contains(table.Warnings[0], "not at the end of the disk") {
+		t.Errorf("warnings %q", table.Warnings)
+	}
+}
+
+func TestNoTable(t *testing.T) {
+	f, _ := os.Open(filepath.Join("..", "fat", "testdata", "fat12.img.gz"))
+	defer f.Close()
+	zr, _ := gzip.NewReader(f)
+	floppy, _ := io.ReadAll(zr)
+	for name, data := range map[string][]byte{"zeros": make([]byte, 1<<20), "file system": floppy} {
+		if _, err := Read(&memDisk{data: data, blockSize: 512}); !errors.Is(err, ErrNoTable) {
+			t.Errorf("%s: err = %v", name, err)
+		}
+	}
+}
+
+func TestDevice(t *testing.T) {
+	disk := newDisk(100, 512)
+	dev := NewDevice(disk, Partition{Start: 10, Length: 4})
+	if dev.Size() != 2048 || dev.BlockSize() != 512 {
+		t.Errorf("size %d, block size %d", dev.Size(), dev.BlockSize())
+	}
+	if _, err := dev.WriteAt([]byte("hi"), 2046); err != nil {
+		t.Fatal(err)
+	}
+	if string(disk.data[10*512+2046:][:2]) != "hi" {
+		t.Error("write landed elsewhere")
+	}
+	if _, err := dev.WriteAt([]byte("hi"), 2047); err == nil {
+		t.Error("write beyond the partition succeeded")
+	}
+	buf := make([]byte, 10)
+	if n, err := dev.ReadAt(buf, 2040); n != 8 || err != io.EOF || string(buf[6:8]) != "hi" {
+		t.Errorf("ReadAt = %d, %v", n, err)
+	}
+	if _, err := NewDevice(struct{ Disk }{disk}, Partition{Length: 1}).WriteAt([]byte("x"), 0); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("write to read-only disk: %v", err)
+	}
+}
+
+func TestGUID(t *testing.T) {
+	g, err := ParseGUID("C12A7328-F81F-11D2-BA4B-00A0C93EC93B")
+	if err != nil || g != TypeEFISystem || g[0] != 0x28 || g[8] != 0xba {
+		t.Errorf("ParseGUID = %x, %v", g, err)
+	}
+	for _, bad := range []string{"", "C12A7328F81F11D2BA4B00A0C93EC93B", "C12A7328-F81F-11D2-BA4B-00A0C93EC93G"} {
+		if _, err := ParseGUID(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+	a, _ := NewGUID()
+	b, _ := NewGUID()
+	if a == b || a.String()[14] != '4' {
+		t.Errorf("random GUIDs %s and %s", a, b)
+	}
+}
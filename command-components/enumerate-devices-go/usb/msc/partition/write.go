@@ -0,0 +1,364 @@
+package partition
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"slices"
+	"unicode/utf16"
+)
+
+// Write writes t to disk, replacing whatever partition table it has.
+//
+// The table is checked before anything is written: partitions must lie on
+// the disk, outside the table itself, and must not overlap. The parts are
+// then written so that an interruption leaves a table Read accepts: the
+// extended boot records before the MBR, and the backup GPT before the
+// primary one and the protective MBR. The boot code of an existing MBR is
+// kept. Finally the table is read back and compared.
+//
+// For a GPT, zero GUIDs, usable bounds and partition numbers are filled in.
+// For an MBR, logical partitions go in an extended partition, and each
+// needs a free block before it for its extended boot record.
+func Write(disk WritableDisk, t *Table) error {
+	var err error
+	switch t.Scheme {
+	case MBR:
+		err = writeMBR(disk, t)
+	case GPT:
+		err = writeGPT(disk, t)
+	default:
+		err = fmt.Errorf("%w: scheme %v", ErrInvalid, t.Scheme)
+	}
+	if err != nil {
+		return err
+	}
+	if s, ok := disk.(interface{ Sync() error }); ok {
+		if err := s.Sync(); err != nil {
+			return err
+		}
+	}
+	return verify(disk, t)
+}
+
+// verify reads the table back and compares it with t.
+func verify(disk Disk, t *Table) error {
+	got, err := Read(disk)
+	if err != nil {
+		return fmt.Errorf("partition: reading back the table: %w", err)
+	}
+	if len(got.Warnings) > 0 || len(got.Partitions) != len(t.Partitions) {
+		return fmt.Errorf("partition: table read back differs: %d partitions, warnings %q", len(got.Partitions), got.Warnings)
+	}
+	for i, p := range got.Partitions {
+		want := t.Partitions[i]
+		if p.Start != want.Start || p.Length != want.Length || p.Type != want.Type || p.TypeGUID != want.TypeGUID || p.Name != want.Name {
+			return fmt.Errorf("partition: partition %d read back as %+v", want.Number, p)
+		}
+	}
+	return nil
+}
+
+// checkOverlap fails if any two of the extents, given as start and end
+// blocks, overlap.
+func checkOverlap(extents [][2]uint64) error {
+	slices.SortFunc(extents, func(a, b [2]uint64) int { return cmp.Compare(a[0], b[0]) })
+	for i := 1; i < len(extents); i++ {
+		if extents[i][0] < extents[i-1][1] {
+			return fmt.Errorf("%w: partitions overlap at block %d", ErrInvalid, extents[i][0])
+		}
+	}
+	return nil
+}
+
+// oldMBR returns the boot code and disk signature to keep from the current
+// first block, if it is an MBR.
+func oldMBR(disk Disk) ([]byte, uint32, error) {
+	b := make([]byte, disk.BlockSize())
+	if _, err := disk.ReadAt(b, 0); err != nil {
+		return nil, 0, err
+	}
+	if b[510] != 0x55 || b[511] != 0xaa || bootSector(b) {
+		return make([]byte, 440), 0, nil
+	}
+	return b[:440], binary.LittleEndian.Uint32(b[440:]), nil
+}
+
+// chs returns the cylinder, head and sector form of lba in the geometry
+// all tools assume, or the maximum if it is beyond what that can address.
+func chs(lba uint64) [3]byte {
+	const heads, sectors = 255, 63
+	c := lba / (heads * sectors)
+	if c > 1023 {
+		return [3]byte{0xfe, 0xff, 0xff}
+	}
+	h, s := lba/sectors%heads, lba%sectors+1
+	return [3]byte{byte(h), byte(s) | byte(c>>8)<<6, byte(c)}
+}
+
+func putEntry(b []byte, flag, typ byte, start, length uint64) {
+	b[0], b[4] = flag, typ
+	first, last := chs(start), chs(start+length-1)
+	copy(b[1:4], first[:])
+	copy(b[5:8], last[:])
+	binary.LittleEndian.PutUint32(b[8:], uint32(start))
+	binary.LittleEndian.PutUint32(b[12:], uint32(length))
+}
+
+func writeMBR(disk WritableDisk, t *Table) error {
+	bs := disk.BlockSize()
+	blocks := uint64(disk.Size() / int64(bs))
+	var primary, logical []*Partition
+	var extents [][2]uint64
+	used := map[int]bool{}
+	for i := range t.Partitions {
+		p := &t.Partitions[i]
+		switch {
+		case p.Length == 0 || p.Type == 0 || extended(p.Type) || p.Type == typeProtective:
+			return fmt.Errorf("%w: partition %d has type %#02x and %d blocks", ErrInvalid, p.Number, p.Type, p.Length)
+		case p.End() > blocks:
+			return fmt.Errorf("%w: partition %d ends beyond the disk", ErrInvalid, p.Number)
+		case p.End() > 1<<32:
+			return fmt.Errorf("%w: partition %d ends beyond what an MBR can address; use GPT", ErrInvalid, p.Number)
+		}
+		if p.Logical {
+			if p.Start < 2 {
+				return fmt.Errorf("%w: logical partition %d has no room for its extended boot record", ErrInvalid, p.Number)
+			}
+			logical = append(logical, p)
+			extents = append(extents, [2]uint64{p.Start - 1, p.End()})
+			continue
+		}
+		if p.Start < 1 {
+			return fmt.Errorf("%w: partition %d overlaps the MBR", ErrInvalid, p.Number)
+		}
+		if p.Number < 0 || p.Number > 4 || p.Number > 0 && used[p.Number] {
+			return fmt.Errorf("%w: primary partition number %d", ErrInvalid, p.Number)
+		}
+		used[p.Number] = true
+		primary = append(primary, p)
+		extents = append(extents, [2]uint64{p.Start, p.End()})
+	}
+	if err := checkOverlap(extents); err != nil {
+		return err
+	}
+	slices.SortFunc(logical, func(a, b *Partition) int { return cmp.Compare(a.Start, b.Start) })
+
+	// Give the primary partitions and the extended one their slots.
+	slots := [4]*Partition{}
+	for _, p := range primary {
+		if p.Number > 0 {
+			slots[p.Number-1] = p
+		}
+	}
+	var ext *Partition
+	if len(logical) > 0 {
+		last := logical[len(logical)-1]
+		ext = &Partition{Type: 0x0f, Start: logical[0].Start - 1}
+		ext.Length = last.End() - ext.Start
+		for _, p := range primary {
+			if p.Start < last.End() && p.End() > ext.Start {
+				return fmt.Errorf("%w: primary partition %d lies between logical partitions", ErrInvalid, p.Number)
+			}
+		}
+		primary = append(primary, ext)
+	}
+	for _, p := range primary {
+		if p.Number > 0 && p != ext {
+			continue
+		}
+		i := slices.Index(slots[:], nil)
+		if i < 0 {
+			return fmt.Errorf("%w: more than four primary partitions", ErrInvalid)
+		}
+		slots[i] = p
+		if p != ext {
+			p.Number = i + 1
+		}
+	}
+
+	// The extended boot records go first, from the last one back.
+	for i := len(logical) - 1; i >= 0; i-- {
+		p := logical[i]
+		b := make([]byte, bs)
+		putEntry(b[mbrEntries:], flag(p), p.Type, 1, p.Length)
+		if i+1 < len(logical) {
+			next := logical[i+1]
+			putEntry(b[mbrEntries+16:], 0, 0x05, next.Start-1-ext.Start, next.End()-(next.Start-1))
+		}
+		b[510], b[511] = 0x55, 0xaa
+		if _, err := disk.WriteAt(b, int64(p.Start-1)*int64(bs)); err != nil {
+			return err
+		}
+		p.Number = 5 + i
+	}
+
+	code, signature, err := oldMBR(disk)
+	if err != nil {
+		return err
+	}
+	if t.DiskSignature == 0 {
+		t.DiskSignature = signature
+	}
+	b := make([]byte, bs)
+	copy(b, code)
+	binary.LittleEndian.PutUint32(b[440:], t.DiskSignature)
+	for i, p := range slots {
+		if p != nil {
+			putEntry(b[mbrEntries+16*i:], flag(p), p.Type, p.Start, p.Length)
+		}
+	}
+	b[510], b[511] = 0x55, 0xaa
+	if _, err := disk.WriteAt(b, 0); err != nil {
+		return err
+	}
+	slices.SortFunc(t.Partitions, func(a, b Partition) int { return a.Number - b.Number })
+	return wipeGPT(disk)
+}
+
+func flag(p *Partition) byte {
+	if p.Bootable {
+		return 0x80
+	}
+	return 0
+}
+
+// wipeGPT clears the GPT headers left on a disk that now has an MBR, so
+// that no tool mistakes them for the table.
+func wipeGPT(disk WritableDisk) error {
+	bs := disk.BlockSize()
+	last := disk.Size()/int64(bs) - 1
+	b := make([]byte, bs)
+	for _, lba := range []int64{1, last} {
+		if _, err := disk.ReadAt(b, lba*int64(bs)); err != nil {
+			return err
+		}
+		if string(b[:8]) == gptSignature {
+			if _, err := disk.WriteAt(make([]byte, bs), lba*int64(bs)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeGPT(disk WritableDisk, t *Table) error {
+	bs := uint64(disk.BlockSize())
+	blocks := uint64(disk.Size()) / bs
+	arrayBlocks := (gptEntries*gptEntrySize + bs - 1) / bs
+	if blocks < 3+2*arrayBlocks+1 {
+		return fmt.Errorf("%w: disk of %d blocks is too small for a GPT", ErrInvalid, blocks)
+	}
+	last := blocks - 1
+	if t.FirstUsable == 0 {
+		t.FirstUsable = 2 + arrayBlocks
+	}
+	if t.LastUsable == 0 {
+		t.LastUsable = last - 1 - arrayBlocks
+	}
+	if t.FirstUsable < 2+arrayBlocks || t.LastUsable > last-1-arrayBlocks || t.FirstUsable > t.LastUsable {
+		return fmt.Errorf("%w: usable blocks %d to %d overlap the table", ErrInvalid, t.FirstUsable, t.LastUsable)
+	}
+	if t.DiskGUID == (GUID{}) {
+		g, err := NewGUID()
+		if err != nil {
+			return err
+		}
+		t.DiskGUID = g
+	}
+
+	entries := make([]byte, gptEntries*gptEntrySize)
+	var extents [][2]uint64
+	used := map[int]bool{}
+	for i := range t.Partitions {
+		p := &t.Partitions[i]
+		switch {
+		case p.TypeGUID == (GUID{}) || p.Length == 0:
+			return fmt.Errorf("%w: partition %d has no type or no blocks", ErrInvalid, p.Number)
+		case p.Start < t.FirstUsable || p.End()-1 > t.LastUsable:
+			return fmt.Errorf("%w: partition %d is outside the usable blocks %d to %d", ErrInvalid, p.Number, t.FirstUsable, t.LastUsable)
+		case p.Number < 0 || p.Number > gptEntries || p.Number > 0 && used[p.Number]:
+			return fmt.Errorf("%w: partition number %d", ErrInvalid, p.Number)
+		case len(utf16.Encode([]rune(p.Name))) > gptNameLen:
+			return fmt.Errorf("%w: name of partition %d is longer than %d characters", ErrInvalid, p.Number, gptNameLen)
+		}
+		used[p.Number] = true
+		extents = append(extents, [2]uint64{p.Start, p.End()})
+	}
+	if err := checkOverlap(extents); err != nil {
+		return err
+	}
+	for i := range t.Partitions {
+		p := &t.Partitions[i]
+		if p.Number == 0 {
+			for p.Number = 1; used[p.Number]; p.Number++ {
+			}
+			used[p.Number] = true
+		}
+		if p.GUID == (GUID{}) {
+			g, err := NewGUID()
+			if err != nil {
+				return err
+			}
+			p.GUID = g
+		}
+		e := entries[(p.Number-1)*gptEntrySize:]
+		copy(e[0:], p.TypeGUID[:])
+		copy(e[16:], p.GUID[:])
+		binary.LittleEndian.PutUint64(e[32:], p.Start)
+		binary.LittleEndian.PutUint64(e[40:], p.End()-1)
+		binary.LittleEndian.PutUint64(e[48:], p.Attributes)
+		for j, u := range utf16.Encode([]rune(p.Name)) {
+			binary.LittleEndian.PutUint16(e[56+2*j:], u)
+		}
+	}
+	slices.SortFunc(t.Partitions, func(a, b Partition) int { return a.Number - b.Number })
+
+	array := make([]byte, arrayBlocks*bs)
+	copy(array, entries)
+	backupArray := last - arrayBlocks
+	header := func(lba, alternate, entriesLBA uint64) []byte {
+		b := make([]byte, bs)
+		copy(b, gptSignature)
+		binary.LittleEndian.PutUint32(b[8:], gptRevision)
+		binary.LittleEndian.PutUint32(b[12:], gptHeaderSize)
+		binary.LittleEndian.PutUint64(b[24:], lba)
+		binary.LittleEndian.PutUint64(b[32:], alternate)
+		binary.LittleEndian.PutUint64(b[40:], t.FirstUsable)
+		binary.LittleEndian.PutUint64(b[48:], t.LastUsable)
+		copy(b[56:], t.DiskGUID[:])
+		binary.LittleEndian.PutUint64(b[72:], entriesLBA)
+		binary.LittleEndian.PutUint32(b[80:], gptEntries)
+		binary.LittleEndian.PutUint32(b[84:], gptEntrySize)
+		binary.LittleEndian.PutUint32(b[88:], crc32.ChecksumIEEE(entries))
+		binary.LittleEndian.PutUint32(b[16:], crc32.ChecksumIEEE(b[:gptHeaderSize]))
+		return b
+	}
+
+	code, _, err := oldMBR(disk)
+	if err != nil {
+		return err
+	}
+	mbr := make([]byte, bs)
+	copy(mbr, code)
+	putEntry(mbr[mbrEntries:], 0, typeProtective, 1, min(last, 0xffffffff))
+	mbr[510], mbr[511] = 0x55, 0xaa
+
+	writes := []struct {
+		lba  uint64
+		data []byte
+	}{
+		{backupArray, array},
+		{last, header(last, 1, backupArray)},
+		{2, array},
+		{1, header(1, last, 2)},
+		{0, mbr},
+	}
+	for _, w := range writes {
+		if _, err := disk.WriteAt(w.data, int64(w.lba*bs)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package partition
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// bigDisk claims to be larger than it is.
+type bigDisk struct {
+	*memDisk
+	size int64
+}
+
+func (d bigDisk) Size() int64 { return d.size }
+
+func TestWriteGPT(t *testing.T) {
+	for _, bs := range []int{512, 4096} {
+		disk := newDisk(8<<20/bs, bs)
+		code := bytes.Repeat([]byte{0xfa}, 440)
+		copy(disk.data, code)
+		disk.data[510], disk.data[511] = 0x55, 0xaa
+
+		start := uint64(1 << 20 / bs)
+		table := &Table{Scheme: GPT, Partitions: []Partition{
+			{TypeGUID: TypeEFISystem, Start: start, Length: start, Name: "EFI"},
+			{Number: 3, TypeGUID: TypeLinuxFilesystem, Start: 2 * start, Length: 4 * start, Name: "Linux ü", Attributes: 1 << 63},
+		}}
+		if err := Write(disk, table); err != nil {
+			t.Fatalf("%d-byte blocks: %v", bs, err)
+		}
+		if table.DiskGUID == (GUID{}) || table.Partitions[0].GUID == (GUID{}) || table.Partitions[0].Number != 1 {
+			t.Errorf("%d-byte blocks: defaults not filled in: %+v", bs, table)
+		}
+		got, err := Read(disk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.DiskGUID != table.DiskGUID || got.FirstUsable != table.FirstUsable || got.LastUsable != uint64(8<<20/bs)-2-uint64(16384/bs) {
+			t.Errorf("%d-byte blocks: read back %+v", bs, got)
+		}
+		for i, p := range got.Partitions {
+			if p != table.Partitions[i] {
+				t.Errorf("%d-byte blocks: partition %+v, want %+v", bs, p, table.Partitions[i])
+			}
+		}
+		if !bytes.Equal(disk.data[:440], code) || disk.data[446+4] != typeProtective {
+			t.Errorf("%d-byte blocks: protective MBR % x", bs, disk.data[440:512])
+		}
+	}
+}
+
+func TestWriteMBR(t *testing.T) {
+	disk := openImage(t, "gpt.img.gz")
+	table := &Table{Scheme: MBR, DiskSignature: 0x12345678, Partitions: []Partition{
+		{Type: 0xef, Start: 2048, Length: 2880, Bootable: true},
+		{Type: 0x83, Start: 8192, Length: 2048, Logical: true},
+		{Type: 0x82, Start: 12288, Length: 2048, Logical: true},
+		{Number: 4, Type: 0x07, Start: 20000, Length: 1000},
+	}}
+	if err := Write(disk, table); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(disk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Scheme != MBR || got.DiskSignature != 0x12345678 {
+		t.Errorf("table %+v", got)
+	}
+	numbers := []int{1, 4, 5, 6}
+	for i, p := range got.Partitions {
+		if p != table.Partitions[i] || p.Number != numbers[i] {
+			t.Errorf("partition %+v, want %+v", p, table.Partitions[i])
+		}
+	}
+	// The old GPT is gone.
+	if string(disk.data[512:520]) == gptSignature || string(disk.data[len(disk.data)-512:][:8]) == gptSignature {
+		t.Error("GPT headers left behind")
+	}
+}
+
+func TestWriteInvalid(t *testing.T) {
+	gpt := func(parts ...Partition) *Table { return &Table{Scheme: GPT, Partitions: parts} }
+	mbr := func(parts ...Partition) *Table { return &Table{Scheme: MBR, Partitions: parts} }
+	linux := TypeLinuxFilesystem
+	tests := []struct {
+		name  string
+		table *Table
+	}{
+		{"overlap", gpt(Partition{TypeGUID: linux, Start: 100, Length: 100}, Partition{TypeGUID: linux, Start: 199, Length: 10})},
+		{"over the table", gpt(Partition{TypeGUID: linux, Start: 33, Length: 10})},
+		{"over the backup", gpt(Partition{TypeGUID: linux, Start: 2000, Length: 48})},
+		{"no type", gpt(Partition{Start: 100, Length: 10})},
+		{"same number", gpt(Partition{Number: 2, TypeGUID: linux, Start: 100, Length: 1}, Partition{Number: 2, TypeGUID: linux, Start: 200, Length: 1})},
+		{"long name", gpt(Partition{TypeGUID: linux, Start: 100, Length: 1, Name: "a name of more than thirty-six characters"})},
+		{"beyond the disk", mbr(Partition{Type: 0x83, Start: 2000, Length: 100})},
+		{"five primaries", mbr(
+			Partition{Type: 0x83, Start: 1, Length: 1}, Partition{Type: 0x83, Start: 2, Length: 1},
+			Partition{Type: 0x83, Start: 3, Length: 1}, Partition{Type: 0x83, Start: 4, Length: 1},
+			Partition{Type: 0x83, Start: 5, Length: 1})},
+		{"no room for the EBR", mbr(Partition{Type: 0x83, Start: 100, Length: 10}, Partition{Type: 0x83, Start: 110, Length: 10, Logical: true})},
+		{"primary between logicals", mbr(
+			Partition{Type: 0x83, Start: 100, Length: 10, Logical: true}, Partition{Type: 0x83, Start: 120, Length: 10},
+			Partition{Type: 0x83, Start: 140, Length: 10, Logical: true})},
+		{"extended type", mbr(Partition{Type: 0x05, Start: 100, Length: 10})},
+		{"no scheme", &Table{}},
+	}
+	for _, tt := range tests {
+		disk := newDisk(2048, 512)
+		if err := Write(disk, tt.table); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+		if !bytes.Equal(disk.data, make([]byte, len(disk.data))) {
+			t.Errorf("%s: disk was written", tt.name)
+		}
+	}
+
+	disk := bigDisk{newDisk(2048, 512), 3 << 40}
+	if err := Write(disk, mbr(Partition{Type: 0x83, Start: 1 << 32, Length: 10})); !errors.Is(err, ErrInvalid) {
+		t.Errorf("beyond 2 TiB: err = %v", err)
+	}
+}
+
+func TestWriteInterrupted(t *testing.T) {
+	table := func() *Table {
+		return &Table{Scheme: GPT, Partitions: []Partition{{TypeGUID: TypeLinuxSwap, Start: 4096, Length: 4096, Name: "new"}}}
+	}
+	// Whichever write fails, the disk has a table: the old or the new one.
+	for image, old := range map[string]int{"mbr.img.gz": 4, "gpt.img.gz": 3} {
+		for n := 1; n <= 5; n++ {
+			disk := openImage(t, image)
+			disk.failAfter = n
+			if err := Write(disk, table()); err == nil {
+				t.Fatalf("%s: write %d did not fail", image, n)
+			}
+			got, err := Read(disk)
+			if err != nil {
+				t.Errorf("%s, write %d failed: %v", image, n, err)
+				continue
+			}
+			if len(got.Partitions) != 1 && len(got.Partitions) != old {
+				t.Errorf("%s, write %d failed: partitions %+v", image, n, got.Partitions)
+			}
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package scsi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"example.com/usb"
+)
+
+// Capacity describes the medium of a direct access device.
+type Capacity struct {
+	Blocks    uint64 // number of logical blocks
+	BlockSize uint32 // in bytes
+
+	// The fields below are only reported by READ CAPACITY(16).
+
+	// PhysicalBlockExponent is the base 2 logarithm of the number of
+	// logical blocks per physical block.
+	PhysicalBlockExponent uint8
+	LowestAlignedLBA      uint16
+	ProtectionEnabled     bool
+}
+
+// Bytes returns the size of the medium.
+func (c Capacity) Bytes() uint64 {
+	return c.Blocks * uint64(c.BlockSize)
+}
+
+// ReadCapacity10 sends READ CAPACITY(10). Media of 2^32 blocks or more
+// report 2^32 blocks; use ReadCapacity16 for those.
+func (d *Device) ReadCapacity10() (Capacity, error) {
+	buf := make([]byte, 8)
+	n, err := d.Command([]byte{OpReadCapacity10, 0, 0, 0, 0, 0, 0, 0, 0, 0}, usb.DirectionIn, buf)
+	if err != nil {
+		return Capacity{}, err
+	}
+	if n < 8 {
+		return Capacity{}, fmt.Errorf("scsi: READ CAPACITY(10) returned %d bytes", n)
+	}
+	return Capacity{
+		Blocks:    uint64(binary.BigEndian.Uint32(buf)) + 1,
+		BlockSize: binary.BigEndian.Uint32(buf[4:]),
+	}, nil
+}
+
+// ReadCapacity16 sends READ CAPACITY(16).
+func (d *Device) ReadCapacity16() (Capacity, error) {
+	buf := make([]byte, 32)
+	cb := make([]byte, 16)
+	cb[0] = OpServiceActionIn16
+	cb[1] = ServiceActionReadCapacity16
+	binary.BigEndian.PutUint32(cb[10:], uint32(len(buf)))
+	n, err := d.Command(cb, usb.DirectionIn, buf)
+	if err != nil {
+		return Capacity{}, err
+	}
+	if n < 12 {
+		return Capacity{}, fmt.Errorf("scsi: READ CAPACITY(16) returned %d bytes", n)
+	}
+	return Capacity{
+		Blocks:                binary.BigEndian.Uint64(buf) + 1,
+		BlockSize:             binary.BigEndian.Uint32(buf[8:]),
+		ProtectionEnabled:     buf[12]&0x01 != 0,
+		PhysicalBlockExponent: buf[13] & 0x0f,
+		LowestAlignedLBA:      binary.BigEndian.Uint16(buf[14:]) & 0x3fff,
+	}, nil
+}
+
+// ReadCapacity returns the capacity of the medium, using READ CAPACITY(16)
+// if the medium is too large for READ CAPACITY(10). The result is kept for
+// Read, Write and Verify.
+func (d *Device) ReadCapacity() (Capacity, error) {
+	c, err := d.ReadCapacity10()
+	if err != nil {
+		return c, err
+	}
+	if c.Blocks > 0xffffffff {
+		if c, err = d.ReadCapacity16(); err != nil {
+			return c, err
+		}
+	}
+	if c.BlockSize == 0 {
+		return c, errors.New("scsi: medium reports a block size of 0")
+	}
+	d.capacity = &c
+	return c, nil
+}
+
+func (d *Device) blockSize() (uint32, error) {
+	if d.capacity == nil {
+		if _, err := d.ReadCapacity(); err != nil {
+			return 0, err
+		}
+	}
+	return d.capacity.BlockSize, nil
+}
+
+// fits10 reports whether a transfer can be expressed with a 10-byte CDB.
+func fits10(lba uint64, blocks uint32) bool {
+	return lba+uint64(blocks) <= 1<<32 && blocks <= 0xffff
+}
+
+// blocks returns the number of blocks in buf.
+func (d *Device) blocks(buf []byte) (uint32, error) {
+	size, err := d.blockSize()
+	if err != nil {
+		return 0, err
+	}
+	if len(buf)%int(size) != 0 {
+		return 0, fmt.Errorf("scsi: buffer of %d bytes is not a multiple of the block size %d", len(buf), size)
+	}
+	return uint32(len(buf) / int(size)), nil
+}
+
+// Read reads len(buf)/blocksize blocks starting at lba, using READ(10) if
+// possible and READ(16) otherwise.
+func (d *Device) Read(lba uint64, buf []byte) error {
+	cb, err := d.rw(false, lba, buf)
+	if err != nil || cb == nil {
+		return err
+	}
+	return d.transfer(cb, usb.DirectionIn, buf)
+}
+
+// SetForceUnitAccess makes Read and Write bypass the cache of the device,
+// for measurements and for writes that must reach the medium at once.
+func (d *Device) SetForceUnitAccess(on bool) {
+	d.fua = on
+}
+
+// Write writes buf to the blocks starting at lba, using WRITE(10) if
+// possible and WRITE(16) otherwise.
+func (d *Device) Write(lba uint64, buf []byte) error {
+	cb, err := d.rw(true, lba, buf)
+	if err != nil || cb == nil {
+		return err
+	}
+	return d.transfer(cb, usb.DirectionOut, buf)
+}
+
+// rw returns the command block that reads or writes buf at lba, or nil if
+// buf is empty.
+func (d *Device) rw(write bool, lba uint64, buf []byte) ([]byte, error) {
+	blocks, err := d.blocks(buf)
+	if err != nil || blocks == 0 {
+		return nil, err
+	}
+	cb := Read16(lba, blocks)
+	switch {
+	case write && fits10(lba, blocks):
+		cb = Write10(uint32(lba), uint16(blocks))
+	case write:
+		cb = Write16(lba, blocks)
+	case fits10(lba, blocks):
+		cb = Read10(uint32(lba), uint16(blocks))
+	}
+	if d.fua {
+		cb[1] |= FUA
+	}
+	return cb, nil
+}
+
+func (d *Device) transfer(cb []byte, dir usb.Direction, buf []byte) error {
+	n, err := d.Command(cb, dir, buf)
+	return transferred(cb, n, len(buf), err)
+}
+
+func transferred(cb []byte, n, length int, err error) error {
+	if err == nil && n != length {
+		err = fmt.Errorf("scsi: %s transferred %d of %d bytes", CommandName(cb), n, length)
+	}
+	return err
+}
+
+// Transfer is a read or a write of Queue.
+type Transfer struct {
+	Write bool
+	LBA   uint64
+	Buf   []byte
+}
+
+// QueueDepth returns the most transfers Queue runs at once: the queue
+// depth of the transport if it is a QueueTransport, and 1 otherwise.
+func (d *Device) QueueDepth() int {
+	if q, ok := d.t.(QueueTransport); ok {
+		return max(q.QueueDepth(), 1)
+	}
+	return 1
+}
+
+// Queue reads and writes the transfers, in groups of QueueDepth that the
+// device runs at once, in any order: transfers of a group that overlap
+// leave the blocks as either one left them. It returns the errors of the
+// transfers that failed, joined.
+func (d *Device) Queue(xs []Transfer) error {
+	q, ok := d.t.(QueueTransport)
+	if !ok {
+		for _, x := range xs {
+			var err error
+			if x.Write {
+				err = d.Write(x.LBA, x.Buf)
+			} else {
+				err = d.Read(x.LBA, x.Buf)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for len(xs) > 0 {
+		group := xs[:min(len(xs), d.QueueDepth())]
+		xs = xs[len(group):]
+		reqs := make([]Request, 0, len(group))
+		for _, x := range group {
+			cb, err := d.rw(x.Write, x.LBA, x.Buf)
+			if err != nil {
+				return err
+			}
+			dir := usb.DirectionIn
+			if x.Write {
+				dir = usb.DirectionOut
+			}
+			if cb != nil {
+				reqs = append(reqs, Request{CB: cb, Dir: dir, Data: x.Buf})
+			}
+		}
+		cs, err := q.Queue(d.lun, reqs)
+		if err != nil {
+			return fmt.Errorf("scsi: queue of %d commands: %w", len(reqs), err)
+		}
+		var errs []error
+		for i, r := range reqs {
+			n, _, err := d.complete(CommandName(r.CB), cs[i], len(r.Data))
+			errs = append(errs, transferred(r.CB, n, len(r.Data), err))
+		}
+		if err := errors.Join(errs...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify asks the device to check that blocks blocks starting at lba can be
+// read, without transferring them.
+func (d *Device) Verify(lba uint64, blocks uint32) error {
+	cb := Verify16(lba, blocks)
+	if fits10(lba, blocks) {
+		cb = Verify10(uint32(lba), uint16(blocks))
+	}
+	_, err := d.Command(cb, usb.DirectionOut, nil)
+	return err
+}
+
+// SynchronizeCache asks the device to write its cache to the medium.
+func (d *Device) SynchronizeCache() error {
+	_, err := d.Command([]byte{OpSynchronizeCache10, 0, 0, 0, 0, 0, 0, 0, 0, 0}, usb.DirectionOut, nil)
+	return err
+}
+
+// Mode pages.
+const (
+	ModePageCaching = 0x08
+	ModePageAll     = 0x3f
+)
+
+// ModeParameters is the header of MODE SENSE data and its mode pages.
+type ModeParameters struct {
+	MediumType   uint8
+	WriteProtect bool
+	// Pages holds the mode pages, each including its page code and length.
+	Pages []byte
+}
+
+// ModeSense6 sends MODE SENSE(6) for a page and returns the current values.
+func (d *Device) ModeSense6(page uint8) (*ModeParameters, error) {
+	buf := make([]byte, 255)
+	// DBD is set: block descriptors are of no interest.
+	n, err := d.Command([]byte{OpModeSense6, 0x08, page & 0x3f, 0, uint8(len(buf)), 0}, usb.DirectionIn, buf)
+	if err != nil {
+		return nil, err
+	}
+	if n < 4 {
+		return nil, fmt.Errorf("scsi: MODE SENSE(6) returned %d bytes", n)
+	}
+	end := min(n, 1+int(buf[0]))
+	return &ModeParameters{
+		MediumType:   buf[1],
+		WriteProtect: buf[2]&0x80 != 0,
+		Pages:        buf[min(end, 4+int(buf[3])):end],
+	}, nil
+}
+
+// ModeSense10 sends MODE SENSE(10) for a page and returns the current values.
+func (d *Device) ModeSense10(page uint8) (*ModeParameters, error) {
+	buf := make([]byte, 512)
+	cb := []byte{OpModeSense10, 0x08, page & 0x3f, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(cb[7:], uint16(len(buf)))
+	n, err := d.Command(cb, usb.DirectionIn, buf)
+	if err != nil {
+		return nil, err
+	}
+	if n < 8 {
+		return nil, fmt.Errorf("scsi: MODE SENSE(10) returned %d bytes", n)
+	}
+	end := min(n, 2+int(binary.BigEndian.Uint16(buf)))
+	return &ModeParameters{
+		MediumType:   buf[2],
+		WriteProtect: buf[3]&0x80 != 0,
+		Pages:        buf[min(end, 8+int(binary.BigEndian.Uint16(buf[6:]))):end],
+	}, nil
+}
+
+// WriteProtected reports whether the medium is write protected, using MODE
+// SENSE(6) and falling back to MODE SENSE(10) for devices that reject it.
+func (d *Device) WriteProtected() (bool, error) {
+	p, err := d.ModeSense6(ModePageAll)
+	if errors.Is(err, ErrIllegalRequest) {
+		p, err = d.ModeSense10(ModePageAll)
+	}
+	if err != nil {
+		return false, err
+	}
+	return p.WriteProtect, nil
+}
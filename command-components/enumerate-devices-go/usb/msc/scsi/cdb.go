@@ -0,0 +1,154 @@
+package scsi
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Operation codes.
+const (
+	OpTestUnitReady      = 0x00
+	OpRequestSense       = 0x03
+	OpInquiry            = 0x12
+	OpModeSense6         = 0x1a
+	OpStartStopUnit      = 0x1b
+	OpPreventAllow       = 0x1e
+	OpReadCapacity10     = 0x25
+	OpRead10             = 0x28
+	OpWrite10            = 0x2a
+	OpVerify10           = 0x2f
+	OpSynchronizeCache10 = 0x35
+	OpReadTOC            = 0x43
+	OpGetConfiguration   = 0x46
+	OpGetEventStatus     = 0x4a
+	OpModeSense10        = 0x5a
+	OpATAPassThrough16   = 0x85
+	OpRead16             = 0x88
+	OpWrite16            = 0x8a
+	OpVerify16           = 0x8f
+	OpServiceActionIn16  = 0x9e
+	OpReportLUNs         = 0xa0
+	OpATAPassThrough12   = 0xa1
+	OpRead12             = 0xa8
+	OpWrite12            = 0xaa
+	OpVerify12           = 0xaf
+
+	// ServiceActionReadCapacity16 is the service action of
+	// OpServiceActionIn16 for READ CAPACITY(16).
+	ServiceActionReadCapacity16 = 0x10
+)
+
+// FUA is the force unit access bit in byte 1 of the READ and WRITE command
+// blocks: the device reads from or writes to the medium, not its cache.
+const FUA = 0x08
+
+var commandNames = map[uint8]string{
+	OpTestUnitReady:      "TEST UNIT READY",
+	OpRequestSense:       "REQUEST SENSE",
+	OpInquiry:            "INQUIRY",
+	OpModeSense6:         "MODE SENSE(6)",
+	OpStartStopUnit:      "START STOP UNIT",
+	OpPreventAllow:       "PREVENT ALLOW MEDIUM REMOVAL",
+	OpReadCapacity10:     "READ CAPACITY(10)",
+	OpRead10:             "READ(10)",
+	OpWrite10:            "WRITE(10)",
+	OpVerify10:           "VERIFY(10)",
+	OpSynchronizeCache10: "SYNCHRONIZE CACHE(10)",
+	OpReadTOC:            "READ TOC/PMA/ATIP",
+	OpGetConfiguration:   "GET CONFIGURATION",
+	OpGetEventStatus:     "GET EVENT STATUS NOTIFICATION",
+	OpModeSense10:        "MODE SENSE(10)",
+	OpATAPassThrough16:   "ATA PASS-THROUGH(16)",
+	OpReportLUNs:         "REPORT LUNS",
+	OpATAPassThrough12:   "ATA PASS-THROUGH(12)",
+	OpRead16:             "READ(16)",
+	OpWrite16:            "WRITE(16)",
+	OpVerify16:           "VERIFY(16)",
+	OpRead12:             "READ(12)",
+	OpWrite12:            "WRITE(12)",
+	OpVerify12:           "VERIFY(12)",
+}
+
+// CommandName returns the name of the command in cb, e.g. "READ(10)".
+func CommandName(cb []byte) string {
+	if len(cb) == 0 {
+		return "empty command"
+	}
+	if cb[0] == OpServiceActionIn16 && len(cb) > 1 && cb[1]&0x1f == ServiceActionReadCapacity16 {
+		return "READ CAPACITY(16)"
+	}
+	if name, ok := commandNames[cb[0]]; ok {
+		return name
+	}
+	return fmt.Sprintf("operation %#02x", cb[0])
+}
+
+// Read10 returns a READ(10) command block.
+func Read10(lba uint32, blocks uint16) []byte {
+	return cdb10(OpRead10, lba, blocks)
+}
+
+// Read12 returns a READ(12) command block.
+func Read12(lba, blocks uint32) []byte {
+	return cdb12(OpRead12, lba, blocks)
+}
+
+// Read16 returns a READ(16) command block.
+func Read16(lba uint64, blocks uint32) []byte {
+	return cdb16(OpRead16, lba, blocks)
+}
+
+// Write10 returns a WRITE(10) command block.
+func Write10(lba uint32, blocks uint16) []byte {
+	return cdb10(OpWrite10, lba, blocks)
+}
+
+// Write12 returns a WRITE(12) command block.
+func Write12(lba, blocks uint32) []byte {
+	return cdb12(OpWrite12, lba, blocks)
+}
+
+// Write16 returns a WRITE(16) command block.
+func Write16(lba uint64, blocks uint32) []byte {
+	return cdb16(OpWrite16, lba, blocks)
+}
+
+// Verify10 returns a VERIFY(10) command block that verifies the medium
+// without comparing data (BYTCHK 0).
+func Verify10(lba uint32, blocks uint16) []byte {
+	return cdb10(OpVerify10, lba, blocks)
+}
+
+// Verify12 is the 12-byte form of Verify10.
+func Verify12(lba, blocks uint32) []byte {
+	return cdb12(OpVerify12, lba, blocks)
+}
+
+// Verify16 is the 16-byte form of Verify10.
+func Verify16(lba uint64, blocks uint32) []byte {
+	return cdb16(OpVerify16, lba, blocks)
+}
+
+func cdb10(op uint8, lba uint32, blocks uint16) []byte {
+	cb := make([]byte, 10)
+	cb[0] = op
+	binary.BigEndian.PutUint32(cb[2:], lba)
+	binary.BigEndian.PutUint16(cb[7:], blocks)
+	return cb
+}
+
+func cdb12(op uint8, lba, blocks uint32) []byte {
+	cb := make([]byte, 12)
+	cb[0] = op
+	binary.BigEndian.PutUint32(cb[2:], lba)
+	binary.BigEndian.PutUint32(cb[6:], blocks)
+	return cb
+}
+
+func cdb16(op uint8, lba uint64, blocks uint32) []byte {
+	cb := make([]byte, 16)
+	cb[0] = op
+	binary.BigEndian.PutUint64(cb[2:], lba)
+	binary.BigEndian.PutUint32(cb[10:], blocks)
+	return cb
+}
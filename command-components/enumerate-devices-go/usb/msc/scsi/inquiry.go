@@ -0,0 +1,223 @@
+package scsi
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"example.com/usb"
+)
+
+// Peripheral device types.
+const (
+	DeviceTypeDirectAccess  = 0x00 // disks
+	DeviceTypeSequential    = 0x01 // tapes
+	DeviceTypeCDDVD         = 0x05 // MMC devices
+	DeviceTypeOpticalMemory = 0x07
+	DeviceTypeSimplified    = 0x0e // RBC devices
+	DeviceTypeUnknown       = 0x1f
+)
+
+// InquiryData is the standard INQUIRY data.
+type InquiryData struct {
+	PeripheralQualifier uint8
+	DeviceType          uint8
+	Removable           bool
+	Version             uint8 // the SPC version the device claims
+	ResponseDataFormat  uint8
+	Vendor              string
+	Product             string
+	Revision            string
+}
+
+// ParseInquiry decodes standard INQUIRY data. Trailing spaces and NUL
+// padding are removed from the identification strings.
+func ParseInquiry(b []byte) (*InquiryData, error) {
+	if len(b) < 36 {
+		return nil, fmt.Errorf("scsi: INQUIRY data of %d bytes", len(b))
+	}
+	return &InquiryData{
+		PeripheralQualifier: b[0] >> 5,
+		DeviceType:          b[0] & 0x1f,
+		Removable:           b[1]&0x80 != 0,
+		Version:             b[2],
+		ResponseDataFormat:  b[3] & 0x0f,
+		Vendor:              trimASCII(b[8:16]),
+		Product:             trimASCII(b[16:32]),
+		Revision:            trimASCII(b[32:36]),
+	}, nil
+}
+
+func trimASCII(b []byte) string {
+	return strings.TrimRight(string(b), " \x00")
+}
+
+// Inquiry returns the standard INQUIRY data. It asks for the 36 bytes that
+// ParseInquiry decodes: some devices fail INQUIRY with another allocation
+// length.
+func (d *Device) Inquiry() (*InquiryData, error) {
+	buf := make([]byte, 36)
+	n, err := d.Command([]byte{OpInquiry, 0, 0, 0, uint8(len(buf)), 0}, usb.DirectionIn, buf)
+	if err != nil {
+		return nil, err
+	}
+	return ParseInquiry(buf[:n])
+}
+
+// Vital product data pages.
+const (
+	VPDSupportedPages       = 0x00
+	VPDUnitSerialNumber     = 0x80
+	VPDDeviceIdentification = 0x83
+)
+
+// VPD returns the payload of a vital product data page, without its 4-byte
+// header.
+func (d *Device) VPD(page uint8) ([]byte, error) {
+	length := 255
+	for {
+		buf := make([]byte, length)
+		cb := []byte{OpInquiry, 0x01, page, 0, 0, 0}
+		binary.BigEndian.PutUint16(cb[3:], uint16(length))
+		n, err := d.Command(cb, usb.DirectionIn, buf)
+		if err != nil {
+			return nil, err
+		}
+		if n < 4 || buf[1] != page {
+			return nil, fmt.Errorf("scsi: malformed VPD page %#02x", page)
+		}
+		total := 4 + int(binary.BigEndian.Uint16(buf[2:]))
+		if total <= n || n < length || length == 0xffff {
+			return buf[4:min(total, n)], nil
+		}
+		// The page is longer than the allocation length; ask again.
+		length = min(total, 0xffff)
+	}
+}
+
+// ReportLUNs returns the logical units of the device that REPORT LUNS lists
+// with peripheral or flat space addressing below 256, the LUNs a Transport
+// addresses.
+func (d *Device) ReportLUNs() ([]uint8, error) {
+	length := 256
+	for {
+		buf := make([]byte, length)
+		cb := make([]byte, 12)
+		cb[0] = OpReportLUNs
+		binary.BigEndian.PutUint32(cb[6:], uint32(length))
+		n, err := d.Command(cb, usb.DirectionIn, buf)
+		if err != nil {
+			return nil, err
+		}
+		if n < 8 {
+			return nil, fmt.Errorf("scsi: REPORT LUNS returned %d bytes", n)
+		}
+		total := 8 + int(binary.BigEndian.Uint32(buf))
+		if total <= n || n < length {
+			return ParseLUNs(buf[8:min(total, n)]), nil
+		}
+		length = total
+	}
+}
+
+// ParseLUNs decodes the LUN list of REPORT LUNS, keeping the LUNs below 256
+// with peripheral or flat space addressing.
+func ParseLUNs(list []byte) []uint8 {
+	var luns []uint8
+	for i := 0; i+8 <= len(list); i += 8 {
+		method, bus := list[i]>>6, list[i]&0x3f
+		if (method == 0 || method == 1) && bus == 0 {
+			luns = append(luns, list[i+1])
+		}
+	}
+	return luns
+}
+
+// SupportedVPDPages returns the VPD pages the device supports.
+func (d *Device) SupportedVPDPages() ([]uint8, error) {
+	return d.VPD(VPDSupportedPages)
+}
+
+// SerialNumber returns the product serial number from the Unit Serial
+// Number VPD page.
+func (d *Device) SerialNumber() (string, error) {
+	page, err := d.VPD(VPDUnitSerialNumber)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(string(page), " \x00"), nil
+}
+
+// Identifiers returns the designators of the Device Identification VPD page.
+func (d *Device) Identifiers() ([]Designator, error) {
+	page, err := d.VPD(VPDDeviceIdentification)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDesignators(page)
+}
+
+// Designator types.
+const (
+	DesignatorVendorSpecific = 0x0
+	DesignatorT10VendorID    = 0x1
+	DesignatorEUI64          = 0x2
+	DesignatorNAA            = 0x3
+	DesignatorSCSIName       = 0x8
+)
+
+// Code sets of designators.
+const (
+	CodeSetBinary = 0x1
+	CodeSetASCII  = 0x2
+	CodeSetUTF8   = 0x3
+)
+
+// Designator is a descriptor of the Device Identification VPD page.
+type Designator struct {
+	CodeSet     uint8
+	Association uint8 // 0 for the logical unit, 1 for the port, 2 for the target
+	Type        uint8
+	Value       []byte
+}
+
+// String formats the designator like the SCSI name strings of SPC, for
+// example "naa.5000C50012345678", or as "type-N:value" for other types.
+func (x Designator) String() string {
+	value := hex.EncodeToString(x.Value)
+	if x.CodeSet == CodeSetASCII || x.CodeSet == CodeSetUTF8 {
+		value = trimASCII(x.Value)
+	}
+	switch x.Type {
+	case DesignatorNAA:
+		return "naa." + strings.ToUpper(value)
+	case DesignatorEUI64:
+		return "eui." + strings.ToUpper(value)
+	case DesignatorT10VendorID:
+		return "t10." + value
+	case DesignatorSCSIName:
+		return value
+	case DesignatorVendorSpecific:
+		return "vendor:" + value
+	}
+	return fmt.Sprintf("type-%d:%s", x.Type, value)
+}
+
+// ParseDesignators decodes the payload of the Device Identification VPD page.
+func ParseDesignators(page []byte) ([]Designator, error) {
+	var ds []Designator
+	for len(page) > 0 {
+		if len(page) < 4 || len(page) < 4+int(page[3]) {
+			return ds, fmt.Errorf("scsi: truncated designation descriptor")
+		}
+		ds = append(ds, Designator{
+			CodeSet:     page[0] & 0x0f,
+			Association: page[1] >> 4 & 0x03,
+			Type:        page[1] & 0x0f,
+			Value:       page[4 : 4+int(page[3])],
+		})
+		page = page[4+int(page[3]):]
+	}
+	return ds, nil
+}
@@ -0,0 +1,67 @@
+package scsi
+
+import (
+	"testing"
+)
+
+func TestParseInquiry(t *testing.T) {
+	b := make([]byte, 36)
+	b[0] = 0x05
+	b[1] = 0x80
+	b[2] = 0x05
+	b[3] = 0x02
+	copy(b[8:], "ACME    ")
+	copy(b[16:], "Optical drive\x00\x00\x00")
+	copy(b[32:], "1.2 ")
+	inq, err := ParseInquiry(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := InquiryData{DeviceType: DeviceTypeCDDVD, Removable: true, Version: 5, ResponseDataFormat: 2, Vendor: "ACME", Product: "Optical drive", Revision: "1.2"}
+	if *inq != want {
+		t.Errorf("got %+v", *inq)
+	}
+	if _, err := ParseInquiry(b[:35]); err == nil {
+		t.Error("short INQUIRY data accepted")
+	}
+}
+
+func TestParseDesignators(t *testing.T) {
+	page := []byte{
+		0x01, 0x03, 0x00, 0x08, 0x50, 0x00, 0xc5, 0x00, 0x12, 0x34, 0x56, 0x78,
+		0x02, 0x01, 0x00, 0x06, 'A', 'C', 'M', 'E', ' ', ' ',
+		0x01, 0x12, 0x00, 0x02, 0xbe, 0xef,
+	}
+	ds, err := ParseDesignators(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"naa.5000C50012345678", "t10.ACME", "eui.BEEF"}
+	if len(ds) != len(want) {
+		t.Fatalf("%d designators", len(ds))
+	}
+	for i, d := range ds {
+		if d.String() != want[i] {
+			t.Errorf("designator %d: %s, want %s", i, d, want[i])
+		}
+	}
+	if ds[2].Association != 1 {
+		t.Errorf("association %d", ds[2].Association)
+	}
+	if _, err := ParseDesignators(page[:10]); err == nil {
+		t.Error("truncated page accepted")
+	}
+}
+
+func TestParseLUNs(t *testing.T) {
+	list := []byte{
+		0x00, 0x00, 0, 0, 0, 0, 0, 0, // peripheral LUN 0
+		0x40, 0x03, 0, 0, 0, 0, 0, 0, // flat space LUN 3
+		0x41, 0x00, 0, 0, 0, 0, 0, 0, // flat space LUN 256
+		0xc1, 0x01, 0, 0, 0, 0, 0, 0, // well-known REPORT LUNS
+		0x00, 0x01,
+	}
+	if luns := ParseLUNs(list); len(luns) != 2 || luns[0] != 0 || luns[1] != 3 {
+		t.Errorf("got %v", luns)
+	}
+}
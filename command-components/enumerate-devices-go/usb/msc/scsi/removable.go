@@ -0,0 +1,46 @@
+package scsi
+
+import "example.com/usb"
+
+// PreventMediumRemoval sends PREVENT ALLOW MEDIUM REMOVAL, which locks the
+// medium in the device, or unlocks it, so that it can be ejected.
+func (d *Device) PreventMediumRemoval(prevent bool) error {
+	cb := []byte{OpPreventAllow, 0, 0, 0, 0, 0}
+	if prevent {
+		cb[4] = 0x01
+	}
+	_, err := d.Command(cb, usb.DirectionOut, nil)
+	return err
+}
+
+// StartStopUnit sends START STOP UNIT. With loadEject set, start loads the
+// medium and !start ejects it; otherwise start spins the medium up or down.
+func (d *Device) StartStopUnit(start, loadEject bool) error {
+	cb := []byte{OpStartStopUnit, 0, 0, 0, 0, 0}
+	if start {
+		cb[4] |= 0x01
+	}
+	if loadEject {
+		cb[4] |= 0x02
+	}
+	_, err := d.Command(cb, usb.DirectionOut, nil)
+	return err
+}
+
+// Eject allows medium removal and ejects the medium. Devices that lock the
+// medium with PREVENT ALLOW MEDIUM REMOVAL refuse to eject it otherwise.
+func (d *Device) Eject() error {
+	if err := d.PreventMediumRemoval(false); err != nil {
+		return err
+	}
+	if err := d.StartStopUnit(false, true); err != nil {
+		return err
+	}
+	d.capacity = nil
+	return nil
+}
+
+// Load loads the medium, closing the tray of devices that have one.
+func (d *Device) Load() error {
+	return d.StartStopUnit(true, true)
+}
@@ -0,0 +1,227 @@
+// Package scsi implements the SCSI commands USB mass storage devices use:
+// the primary commands of SPC and the block commands of SBC.
+//
+// Commands are sent over a Transport, such as the Bulk-Only Transport of
+// package bot. Commands that complete with CHECK CONDITION are returned as a
+// *SenseError, which matches the errors of this package for its sense key
+// and additional sense code with errors.Is.
+package scsi
+
+import (
+	"errors"
+	"fmt"
+
+	"example.com/usb"
+	"example.com/usb/msc/bot"
+)
+
+// Status is the SCSI status of a completed command.
+type Status uint8
+
+const (
+	StatusGood                Status = 0x00
+	StatusCheckCondition      Status = 0x02
+	StatusConditionMet        Status = 0x04
+	StatusBusy                Status = 0x08
+	StatusReservationConflict Status = 0x18
+	StatusTaskSetFull         Status = 0x28
+	StatusACAActive           Status = 0x30
+	StatusTaskAborted         Status = 0x40
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusGood:
+		return "good"
+	case StatusCheckCondition:
+		return "check condition"
+	case StatusConditionMet:
+		return "condition met"
+	case StatusBusy:
+		return "busy"
+	case StatusReservationConflict:
+		return "reservation conflict"
+	case StatusTaskSetFull:
+		return "task set full"
+	case StatusACAActive:
+		return "ACA active"
+	case StatusTaskAborted:
+		return "task aborted"
+	}
+	return fmt.Sprintf("Status(%#x)", uint8(s))
+}
+
+// StatusError is a command that completed with a status other than GOOD or
+// CHECK CONDITION.
+type StatusError struct {
+	Command string
+	Status  Status
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("scsi: %s: %s", e.Command, e.Status)
+}
+
+// Completion is the outcome of a command on a Transport.
+type Completion struct {
+	Status Status
+	// Residue is the number of bytes of the data stage that were not
+	// transferred.
+	Residue uint32
+	// Sense holds the sense data of a CHECK CONDITION if the transport
+	// delivers it with the status. Without it, Device sends REQUEST SENSE.
+	Sense []byte
+}
+
+// Transport runs command blocks on a logical unit. For usb.DirectionIn the
+// data stage reads into data, for usb.DirectionOut it writes data.
+type Transport interface {
+	Command(lun uint8, cb []byte, dir usb.Direction, data []byte) (Completion, error)
+}
+
+// Request is a command block of a queue, with its data stage.
+type Request struct {
+	CB   []byte
+	Dir  usb.Direction
+	Data []byte
+}
+
+// QueueTransport is a Transport that can have several commands in flight
+// on a logical unit, such as UAS with bulk streams.
+type QueueTransport interface {
+	Transport
+	// QueueDepth returns the most requests Queue takes.
+	QueueDepth() int
+	// Queue runs the requests together and returns their completions in
+	// order. An error fails all of them.
+	Queue(lun uint8, reqs []Request) ([]Completion, error)
+}
+
+// BulkOnly adapts a Bulk-Only Transport to a Transport. BOT has no
+// autosense: a failed command reports CHECK CONDITION without sense data.
+func BulkOnly(t *bot.Transport) Transport {
+	return bulkOnly{t}
+}
+
+type bulkOnly struct {
+	t *bot.Transport
+}
+
+func (b bulkOnly) Command(lun uint8, cb []byte, dir usb.Direction, data []byte) (Completion, error) {
+	r, err := b.t.Command(lun, cb, dir, data)
+	if err != nil {
+		return Completion{}, err
+	}
+	c := Completion{Status: StatusGood, Residue: r.Residue}
+	if r.Status == bot.StatusFailed {
+		c.Status = StatusCheckCondition
+	}
+	return c, nil
+}
+
+// Device sends SCSI commands to one logical unit.
+type Device struct {
+	t   Transport
+	lun uint8
+
+	// capacity is cached by ReadCapacity for the block commands.
+	capacity *Capacity
+	fua      bool
+	// descriptorSense makes REQUEST SENSE ask for descriptor format.
+	descriptorSense bool
+}
+
+// New returns a Device for logical unit lun.
+func New(t Transport, lun uint8) *Device {
+	return &Device{t: t, lun: lun}
+}
+
+// LUN returns the logical unit number.
+func (d *Device) LUN() uint8 {
+	return d.lun
+}
+
+// Command sends a command block and returns the number of bytes transferred
+// in the data stage. CHECK CONDITION is returned as a *SenseError, other
+// statuses than GOOD as a *StatusError. A RECOVERED ERROR is not an error.
+func (d *Device) Command(cb []byte, dir usb.Direction, data []byte) (int, error) {
+	n, _, err := d.CommandSense(cb, dir, data)
+	return n, err
+}
+
+// CommandSense is Command, but also returns the sense data of a RECOVERED
+// ERROR, which some commands use to return information, such as the ATA
+// registers of ATA PASS-THROUGH. The sense data is nil otherwise.
+func (d *Device) CommandSense(cb []byte, dir usb.Direction, data []byte) (int, *Sense, error) {
+	name := CommandName(cb)
+	c, err := d.t.Command(d.lun, cb, dir, data)
+	if err != nil {
+		return 0, nil, fmt.Errorf("scsi: %s: %w", name, err)
+	}
+	return d.complete(name, c, len(data))
+}
+
+// complete checks the completion of command name, whose data stage has
+// length bytes.
+func (d *Device) complete(name string, c Completion, length int) (int, *Sense, error) {
+	n := length - int(min(c.Residue, uint32(length)))
+	switch c.Status {
+	case StatusGood, StatusConditionMet:
+		return n, nil, nil
+	case StatusCheckCondition:
+		sense, err := d.sense(c.Sense)
+		if err != nil {
+			return n, nil, fmt.Errorf("scsi: %s: %s, and no sense data: %w", name, c.Status, err)
+		}
+		if sense.Key == SenseRecoveredError {
+			return n, sense, nil
+		}
+		err = &SenseError{Command: name, Sense: *sense}
+		if errors.Is(err, ErrMediumChanged) || errors.Is(err, ErrMediumNotPresent) {
+			// The next block command reads the capacity of the new medium.
+			d.capacity = nil
+		}
+		return n, nil, err
+	}
+	return n, nil, &StatusError{Command: name, Status: c.Status}
+}
+
+func (d *Device) sense(data []byte) (*Sense, error) {
+	if len(data) > 0 {
+		return ParseSense(data)
+	}
+	return d.RequestSense()
+}
+
+// SetDescriptorSense makes REQUEST SENSE ask for descriptor format sense
+// data, which holds 64-bit LBAs, instead of fixed format.
+func (d *Device) SetDescriptorSense(on bool) {
+	d.descriptorSense = on
+}
+
+// RequestSense fetches the sense data of the last command. It asks for the
+// 18 bytes of fixed format, which devices that get a larger allocation
+// length may pad or refuse, or for up to 252 bytes of descriptor format
+// after SetDescriptorSense.
+func (d *Device) RequestSense() (*Sense, error) {
+	cb := []byte{OpRequestSense, 0, 0, 0, 18, 0}
+	if d.descriptorSense {
+		cb[1], cb[4] = 0x01, 252
+	}
+	buf := make([]byte, cb[4])
+	c, err := d.t.Command(d.lun, cb, usb.DirectionIn, buf)
+	if err != nil {
+		return nil, fmt.Errorf("scsi: REQUEST SENSE: %w", err)
+	}
+	if c.Status != StatusGood {
+		return nil, &StatusError{Command: "REQUEST SENSE", Status: c.Status}
+	}
+	return ParseSense(buf[:len(buf)-int(min(c.Residue, uint32(len(buf))))])
+}
+
+// TestUnitReady reports nil if the logical unit is ready, and a
+// *SenseError, typically ErrNotReady or ErrUnitAttention, if not.
+func (d *Device) TestUnitReady() error {
+	_, err := d.Command([]byte{OpTestUnitReady, 0, 0, 0, 0, 0}, usb.DirectionOut, nil)
+	return err
+}
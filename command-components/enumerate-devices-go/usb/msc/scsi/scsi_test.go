@@ -0,0 +1,291 @@
+package scsi
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"example.com/usb"
+	"example.com/usb/msc/bot"
+	"example.com/usb/msc/msctest"
+)
+
+// newDevice returns a Device for LUN 0 of an emulated disk.
+func newDevice(t *testing.T, lun *msctest.LUN) (*Device, *msctest.Disk) {
+	t.Helper()
+	disk := msctest.NewMulti(lun)
+	d := usb.NewDevice(disk)
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	intf, _ := bot.Find(d)
+	tr, err := bot.New(d, intf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(BulkOnly(tr), 0), disk
+}
+
+func TestInquiry(t *testing.T) {
+	lun := msctest.NewLUN(8, 512)
+	lun.NAA = 0x5000c50012345678
+	dev, disk := newDevice(t, lun)
+	inq, err := dev.Inquiry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := allocationLength(disk, OpInquiry); n != 36 {
+		t.Errorf("INQUIRY for %d bytes", n)
+	}
+	if inq.Vendor != "msctest" || inq.Product != "Emulated disk" || inq.Revision != "1.00" || !inq.Removable || inq.DeviceType != DeviceTypeDirectAccess {
+		t.Errorf("inquiry %+v", inq)
+	}
+	pages, err := dev.SupportedVPDPages()
+	if err != nil || !bytes.Equal(pages, []byte{0x00, 0x80, 0x83}) {
+		t.Errorf("VPD pages % x, %v", pages, err)
+	}
+	if sn, err := dev.SerialNumber(); err != nil || sn != "MSCTEST0001" {
+		t.Errorf("serial %q, %v", sn, err)
+	}
+	ids, err := dev.Identifiers()
+	if err != nil || len(ids) != 2 || ids[0].String() != "t10.msctest Emulated disk MSCTEST0001" || ids[1].String() != "naa.5000C50012345678" {
+		t.Errorf("identifiers %v, %v", ids, err)
+	}
+	if _, err := dev.VPD(0xb0); !errors.Is(err, ErrInvalidField) {
+		t.Errorf("unsupported VPD page: %v", err)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	lun := msctest.NewLUN(64, 512)
+	dev, disk := newDevice(t, lun)
+	if err := dev.TestUnitReady(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := dev.ReadCapacity()
+	if err != nil || c.Blocks != 64 || c.BlockSize != 512 || c.Bytes() != 64*512 {
+		t.Fatalf("capacity %+v, %v", c, err)
+	}
+	data := bytes.Repeat([]byte{0xa5, 0x5a}, 512)
+	if err := dev.Write(10, data); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	if err := dev.Read(10, buf); err != nil || !bytes.Equal(buf, data) {
+		t.Fatalf("read back: %v", err)
+	}
+	if err := dev.Verify(10, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.SynchronizeCache(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Read(0, make([]byte, 100)); err == nil {
+		t.Error("partial block accepted")
+	}
+	for _, cb := range disk.Commands {
+		if len(cb) != 10 && len(cb) != 6 {
+			t.Errorf("small transfer used %s", CommandName(cb))
+		}
+	}
+
+	// The 12-byte CDBs are not chosen automatically but work through Command.
+	if _, err := dev.Command(Read12(10, 2), usb.DirectionIn, buf); err != nil || !bytes.Equal(buf, data) {
+		t.Fatalf("READ(12): %v", err)
+	}
+	if _, err := dev.Command(Write12(20, 2), usb.DirectionOut, data); err != nil {
+		t.Fatalf("WRITE(12): %v", err)
+	}
+	if !bytes.Equal(lun.Data[20*512:22*512], data) {
+		t.Error("WRITE(12) did not write")
+	}
+
+	dev.SetForceUnitAccess(true)
+	disk.Commands = nil
+	if err := dev.Write(30, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Read(30, buf); err != nil || !bytes.Equal(buf, data) {
+		t.Fatalf("read back with FUA: %v", err)
+	}
+	for _, cb := range disk.Commands {
+		if (cb[0] == OpRead10 || cb[0] == OpWrite10) && cb[1]&FUA == 0 {
+			t.Errorf("%s without FUA", CommandName(cb))
+		}
+	}
+}
+
+func TestLargeDisk(t *testing.T) {
+	// 4 TiB of 512-byte blocks: more than READ CAPACITY(10) can report.
+	const blocks = 1 << 33
+	dev, disk := newDevice(t, msctest.NewSparseLUN(blocks, 512))
+	c, err := dev.ReadCapacity()
+	if err != nil || c.Blocks != blocks || c.BlockSize != 512 {
+		t.Fatalf("capacity %+v, %v", c, err)
+	}
+	if CommandName(disk.Commands[len(disk.Commands)-1]) != "READ CAPACITY(16)" {
+		t.Error("READ CAPACITY(16) not used")
+	}
+
+	data := bytes.Repeat([]byte{0x42}, 512)
+	lba := uint64(blocks - 1)
+	if err := dev.Write(lba, data); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 512)
+	if err := dev.Read(lba, buf); err != nil || !bytes.Equal(buf, data) {
+		t.Fatalf("read back: %v", err)
+	}
+	if err := dev.Verify(lba, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, cb := range disk.Commands[len(disk.Commands)-3:] {
+		if len(cb) != 16 {
+			t.Errorf("%s used beyond 2 TiB", CommandName(cb))
+		}
+	}
+
+	// Fixed format sense cannot hold the LBA, descriptor format can.
+	err = dev.Read(blocks, buf)
+	var serr *SenseError
+	if !errors.As(err, &serr) || !errors.Is(err, ErrLBAOutOfRange) || serr.Sense.InformationValid {
+		t.Errorf("read past the end: %v", err)
+	}
+	dev.SetDescriptorSense(true)
+	err = dev.Read(blocks, buf)
+	if !errors.As(err, &serr) || serr.Sense.Information != blocks {
+		t.Errorf("read past the end: %v", err)
+	}
+}
+
+func TestSenseDecoding(t *testing.T) {
+	lun := msctest.NewLUN(8, 512)
+	lun.Fault = func(cb []byte) msctest.Sense {
+		if cb[0] == OpRead10 {
+			return msctest.Sense{Key: 0x03, ASC: 0x11, Information: 5, InformationValid: true}
+		}
+		return msctest.Sense{}
+	}
+	dev, _ := newDevice(t, lun)
+	err := dev.Read(5, make([]byte, 512))
+	var serr *SenseError
+	if !errors.As(err, &serr) || !errors.Is(err, ErrMediumError) {
+		t.Fatalf("err = %v", err)
+	}
+	if serr.Command != "READ(10)" || serr.Sense.Information != 5 {
+		t.Errorf("sense error %+v", serr)
+	}
+	if _, err := dev.Command([]byte{0xc0, 0, 0, 0, 0, 0}, usb.DirectionOut, nil); !errors.Is(err, ErrInvalidOpcode) {
+		t.Errorf("unknown command: %v", err)
+	}
+}
+
+// allocationLength returns the allocation length of the last command with
+// opcode op.
+func allocationLength(disk *msctest.Disk, op uint8) int {
+	for i := len(disk.Commands) - 1; i >= 0; i-- {
+		if cb := disk.Commands[i]; cb[0] == op {
+			return int(cb[3])<<8 | int(cb[4])
+		}
+	}
+	return -1
+}
+
+func TestDescriptorSense(t *testing.T) {
+	dev, disk := newDevice(t, msctest.NewLUN(8, 512))
+	err := dev.Read(100, make([]byte, 512))
+	var serr *SenseError
+	if !errors.As(err, &serr) || serr.Sense.Descriptor || !errors.Is(err, ErrLBAOutOfRange) {
+		t.Fatalf("err = %v (%+v)", err, serr)
+	}
+	if n := allocationLength(disk, OpRequestSense); n != 18 {
+		t.Errorf("REQUEST SENSE for %d bytes of fixed format", n)
+	}
+
+	dev.SetDescriptorSense(true)
+	err = dev.Read(100, make([]byte, 512))
+	if !errors.As(err, &serr) || !serr.Sense.Descriptor || serr.Sense.Information != 100 || !errors.Is(err, ErrLBAOutOfRange) {
+		t.Fatalf("err = %v (%+v)", err, serr)
+	}
+	if n := allocationLength(disk, OpRequestSense); n != 252 {
+		t.Errorf("REQUEST SENSE for %d bytes of descriptor format", n)
+	}
+}
+
+// autosense delivers the sense data with the status, like UAS does.
+type autosense struct {
+	sense []byte
+}
+
+func (a autosense) Command(lun uint8, cb []byte, dir usb.Direction, data []byte) (Completion, error) {
+	if cb[0] == OpRequestSense {
+		return Completion{}, errors.New("REQUEST SENSE sent")
+	}
+	return Completion{Status: StatusCheckCondition, Residue: uint32(len(data)), Sense: a.sense}, nil
+}
+
+func TestAutosense(t *testing.T) {
+	dev := New(autosense{[]byte{0x70, 0, 0x06, 0, 0, 0, 0, 10, 0, 0, 0, 0, 0x28, 0, 0, 0, 0, 0}}, 0)
+	if err := dev.TestUnitReady(); !errors.Is(err, ErrMediumChanged) {
+		t.Errorf("err = %v", err)
+	}
+	recovered := New(autosense{[]byte{0x70, 0, 0x01, 0, 0, 0, 0, 10, 0, 0, 0, 0, 0x17, 0, 0, 0, 0, 0}}, 0)
+	if err := recovered.TestUnitReady(); err != nil {
+		t.Errorf("recovered error: %v", err)
+	}
+}
+
+func TestWriteProtected(t *testing.T) {
+	lun := msctest.NewLUN(8, 512)
+	lun.ReadOnly = true
+	dev, disk := newDevice(t, lun)
+	if wp, err := dev.WriteProtected(); err != nil || !wp {
+		t.Fatalf("write protected = %v, %v", wp, err)
+	}
+	if err := dev.Write(0, make([]byte, 512)); !errors.Is(err, ErrWriteProtected) || !errors.Is(err, ErrDataProtect) {
+		t.Errorf("write: %v", err)
+	}
+
+	lun.ReadOnly = false
+	lun.ModeSense6Unsupported = true
+	if wp, err := dev.WriteProtected(); err != nil || wp {
+		t.Fatalf("write protected = %v, %v", wp, err)
+	}
+	if name := CommandName(disk.Commands[len(disk.Commands)-1]); name != "MODE SENSE(10)" {
+		t.Errorf("last command %s", name)
+	}
+}
+
+func TestRemovable(t *testing.T) {
+	lun := msctest.NewLUN(64, 512)
+	dev, _ := newDevice(t, lun)
+	if err := dev.PreventMediumRemoval(true); err != nil || !lun.Locked {
+		t.Fatalf("prevent: %v", err)
+	}
+	if err := dev.StartStopUnit(false, true); !errors.Is(err, ErrRemovalPrevented) || lun.NoMedium {
+		t.Errorf("eject while locked: %v", err)
+	}
+	if _, err := dev.ReadCapacity(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Eject(); err != nil || lun.Locked || !lun.NoMedium {
+		t.Fatalf("eject: %v", err)
+	}
+	if err := dev.TestUnitReady(); !errors.Is(err, ErrMediumNotPresent) || !errors.Is(err, ErrNotReady) {
+		t.Errorf("after eject: %v", err)
+	}
+
+	// A medium of another size is inserted: the unit attention drops the
+	// capacity read for the old one.
+	if _, err := dev.ReadCapacity(); !errors.Is(err, ErrMediumNotPresent) {
+		t.Errorf("capacity without medium: %v", err)
+	}
+	lun.Insert(128)
+	dev.capacity = &Capacity{Blocks: 64, BlockSize: 512}
+	if err := dev.TestUnitReady(); !errors.Is(err, ErrMediumChanged) || !errors.Is(err, ErrUnitAttention) || dev.capacity != nil {
+		t.Errorf("after insertion: %v", err)
+	}
+	if c, err := dev.ReadCapacity(); err != nil || c.Blocks != 128 {
+		t.Errorf("new capacity %+v, %v", c, err)
+	}
+}
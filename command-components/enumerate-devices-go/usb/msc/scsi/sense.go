@@ -0,0 +1,270 @@
+package scsi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// SenseKey is the sense key of a CHECK CONDITION.
+type SenseKey uint8
+
+const (
+	SenseNoSense        SenseKey = 0x00
+	SenseRecoveredError SenseKey = 0x01
+	SenseNotReady       SenseKey = 0x02
+	SenseMediumError    SenseKey = 0x03
+	SenseHardwareError  SenseKey = 0x04
+	SenseIllegalRequest SenseKey = 0x05
+	SenseUnitAttention  SenseKey = 0x06
+	SenseDataProtect    SenseKey = 0x07
+	SenseBlankCheck     SenseKey = 0x08
+	SenseVendorSpecific SenseKey = 0x09
+	SenseCopyAborted    SenseKey = 0x0a
+	SenseAbortedCommand SenseKey = 0x0b
+	SenseVolumeOverflow SenseKey = 0x0d
+	SenseMiscompare     SenseKey = 0x0e
+	SenseCompleted      SenseKey = 0x0f
+)
+
+var senseKeyNames = map[SenseKey]string{
+	SenseNoSense:        "no sense",
+	SenseRecoveredError: "recovered error",
+	SenseNotReady:       "not ready",
+	SenseMediumError:    "medium error",
+	SenseHardwareError:  "hardware error",
+	SenseIllegalRequest: "illegal request",
+	SenseUnitAttention:  "unit attention",
+	SenseDataProtect:    "data protect",
+	SenseBlankCheck:     "blank check",
+	SenseVendorSpecific: "vendor specific",
+	SenseCopyAborted:    "copy aborted",
+	SenseAbortedCommand: "aborted command",
+	SenseVolumeOverflow: "volume overflow",
+	SenseMiscompare:     "miscompare",
+	SenseCompleted:      "completed",
+}
+
+func (k SenseKey) String() string {
+	if name, ok := senseKeyNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("SenseKey(%#x)", uint8(k))
+}
+
+// Errors for the sense keys. A *SenseError matches the one for its key with
+// errors.Is.
+var (
+	ErrNotReady       = errors.New("scsi: not ready")
+	ErrMediumError    = errors.New("scsi: medium error")
+	ErrHardwareError  = errors.New("scsi: hardware error")
+	ErrIllegalRequest = errors.New("scsi: illegal request")
+	ErrUnitAttention  = errors.New("scsi: unit attention")
+	ErrDataProtect    = errors.New("scsi: data protect")
+	ErrBlankCheck     = errors.New("scsi: blank check")
+	ErrAbortedCommand = errors.New("scsi: aborted command")
+	ErrMiscompare     = errors.New("scsi: miscompare")
+)
+
+var keyErrors = map[SenseKey]error{
+	SenseNotReady:       ErrNotReady,
+	SenseMediumError:    ErrMediumError,
+	SenseHardwareError:  ErrHardwareError,
+	SenseIllegalRequest: ErrIllegalRequest,
+	SenseUnitAttention:  ErrUnitAttention,
+	SenseDataProtect:    ErrDataProtect,
+	SenseBlankCheck:     ErrBlankCheck,
+	SenseAbortedCommand: ErrAbortedCommand,
+	SenseMiscompare:     ErrMiscompare,
+}
+
+// Errors for common additional sense codes. A *SenseError matches them
+// with errors.Is regardless of its sense key.
+var (
+	ErrMediumNotPresent = errors.New("scsi: medium not present")
+	ErrWriteProtected   = errors.New("scsi: write protected")
+	ErrLBAOutOfRange    = errors.New("scsi: logical block address out of range")
+	ErrInvalidOpcode    = errors.New("scsi: invalid command operation code")
+	ErrInvalidField     = errors.New("scsi: invalid field in CDB")
+	ErrMediumChanged    = errors.New("scsi: medium may have changed")
+	ErrReset            = errors.New("scsi: power on or reset occurred")
+	ErrRemovalPrevented = errors.New("scsi: medium removal prevented")
+)
+
+// ascErrors maps an ASC and ASCQ to its error, and ascAnyErrors an ASC
+// whatever its ASCQ.
+var (
+	ascErrors = map[uint16]error{
+		0x2100: ErrLBAOutOfRange,
+		0x2000: ErrInvalidOpcode,
+		0x2400: ErrInvalidField,
+		0x2800: ErrMediumChanged,
+		0x5302: ErrRemovalPrevented,
+	}
+	ascAnyErrors = map[uint8]error{
+		0x3a: ErrMediumNotPresent,
+		0x27: ErrWriteProtected,
+		0x29: ErrReset,
+	}
+)
+
+// ascDescriptions describes common additional sense codes, by ASC and ASCQ.
+// ascAnyDescriptions describes every ASCQ of an ASC not listed separately.
+var (
+	ascDescriptions = map[uint16]string{
+		0x0000: "no additional sense information",
+		0x001d: "ATA pass through information available",
+		0x0401: "logical unit is in process of becoming ready",
+		0x0402: "logical unit not ready, initializing command required",
+		0x0c00: "write error",
+		0x1100: "unrecovered read error",
+		0x1a00: "parameter list length error",
+		0x1d00: "miscompare during verify operation",
+		0x2000: "invalid command operation code",
+		0x2100: "logical block address out of range",
+		0x2400: "invalid field in CDB",
+		0x2500: "logical unit not supported",
+		0x2600: "invalid field in parameter list",
+		0x2800: "not ready to ready change, medium may have changed",
+		0x2a01: "mode parameters changed",
+		0x3000: "incompatible medium installed",
+		0x3a01: "medium not present, tray closed",
+		0x3a02: "medium not present, tray open",
+		0x4400: "internal target failure",
+		0x5302: "medium removal prevented",
+	}
+	ascAnyDescriptions = map[uint8]string{
+		0x04: "logical unit not ready",
+		0x27: "write protected",
+		0x29: "power on, reset, or bus device reset occurred",
+		0x3a: "medium not present",
+	}
+)
+
+func lookupASC[V any](exact map[uint16]V, wild map[uint8]V, asc, ascq uint8) (V, bool) {
+	if v, ok := exact[uint16(asc)<<8|uint16(ascq)]; ok {
+		return v, true
+	}
+	v, ok := wild[asc]
+	return v, ok
+}
+
+// SenseDescriptor is a descriptor of descriptor-format sense data.
+type SenseDescriptor struct {
+	Type uint8
+	Data []byte // the descriptor after its type and length
+}
+
+// Sense is decoded sense data, in either fixed or descriptor format.
+type Sense struct {
+	Key        SenseKey
+	ASC        uint8
+	ASCQ       uint8
+	Deferred   bool // the error is from an earlier command
+	Descriptor bool // the data was in descriptor format
+
+	// Information is usually the LBA the error occurred at. It is only
+	// meaningful if InformationValid is set.
+	Information      uint64
+	InformationValid bool
+	CommandSpecific  uint64
+
+	// Descriptors holds all descriptors of descriptor-format sense data,
+	// including the information and command-specific ones decoded above.
+	Descriptors []SenseDescriptor
+}
+
+// Sense data response codes.
+const (
+	senseFixedCurrent       = 0x70
+	senseFixedDeferred      = 0x71
+	senseDescriptorCurrent  = 0x72
+	senseDescriptorDeferred = 0x73
+)
+
+// ParseSense decodes fixed or descriptor format sense data.
+func ParseSense(b []byte) (*Sense, error) {
+	if len(b) < 1 {
+		return nil, errors.New("scsi: empty sense data")
+	}
+	s := &Sense{}
+	switch code := b[0] & 0x7f; code {
+	case senseFixedCurrent, senseFixedDeferred:
+		if len(b) < 14 {
+			return nil, fmt.Errorf("scsi: fixed sense data of %d bytes", len(b))
+		}
+		s.Deferred = code == senseFixedDeferred
+		s.Key = SenseKey(b[2] & 0x0f)
+		s.InformationValid = b[0]&0x80 != 0
+		s.Information = uint64(binary.BigEndian.Uint32(b[3:]))
+		s.CommandSpecific = uint64(binary.BigEndian.Uint32(b[8:]))
+		s.ASC, s.ASCQ = b[12], b[13]
+	case senseDescriptorCurrent, senseDescriptorDeferred:
+		if len(b) < 8 {
+			return nil, fmt.Errorf("scsi: descriptor sense data of %d bytes", len(b))
+		}
+		s.Descriptor = true
+		s.Deferred = code == senseDescriptorDeferred
+		s.Key = SenseKey(b[1] & 0x0f)
+		s.ASC, s.ASCQ = b[2], b[3]
+		rest := b[8:min(len(b), 8+int(b[7]))]
+		for len(rest) >= 2 {
+			n := min(len(rest), 2+int(rest[1]))
+			d := SenseDescriptor{Type: rest[0], Data: rest[2:n]}
+			s.Descriptors = append(s.Descriptors, d)
+			rest = rest[n:]
+			if len(d.Data) < 10 {
+				continue
+			}
+			switch d.Type {
+			case 0x00: // information
+				s.InformationValid = d.Data[0]&0x80 != 0
+				s.Information = binary.BigEndian.Uint64(d.Data[2:])
+			case 0x01: // command specific information
+				s.CommandSpecific = binary.BigEndian.Uint64(d.Data[2:])
+			}
+		}
+	default:
+		return nil, fmt.Errorf("scsi: unknown sense data response code %#x", code)
+	}
+	return s, nil
+}
+
+// Description describes the additional sense code.
+func (s *Sense) Description() string {
+	if d, ok := lookupASC(ascDescriptions, ascAnyDescriptions, s.ASC, s.ASCQ); ok {
+		return d
+	}
+	return fmt.Sprintf("asc 0x%02x, ascq 0x%02x", s.ASC, s.ASCQ)
+}
+
+func (s *Sense) String() string {
+	str := fmt.Sprintf("%s: %s", s.Key, s.Description())
+	if s.InformationValid {
+		str += fmt.Sprintf(" (information %d)", s.Information)
+	}
+	if s.Deferred {
+		str += " (deferred)"
+	}
+	return str
+}
+
+// SenseError is a command that completed with CHECK CONDITION.
+type SenseError struct {
+	Command string // e.g. "READ(10)"
+	Sense   Sense
+}
+
+func (e *SenseError) Error() string {
+	return fmt.Sprintf("scsi: %s: %v", e.Command, &e.Sense)
+}
+
+// Is matches the error of the sense key and the error of the additional
+// sense code.
+func (e *SenseError) Is(target error) bool {
+	if err, ok := keyErrors[e.Sense.Key]; ok && err == target {
+		return true
+	}
+	err, ok := lookupASC(ascErrors, ascAnyErrors, e.Sense.ASC, e.Sense.ASCQ)
+	return ok && err == target
+}
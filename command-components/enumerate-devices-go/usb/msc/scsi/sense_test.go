@@ -0,0 +1,103 @@
+package scsi
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestParseFixedSense(t *testing.T) {
+	b := []byte{0xf0, 0, 0x03, 0, 0, 0x12, 0x34, 10, 0, 0, 0, 0, 0x11, 0x00, 0, 0, 0, 0}
+	s, err := ParseSense(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Key != SenseMediumError || s.ASC != 0x11 || !s.InformationValid || s.Information != 0x1234 || s.Descriptor || s.Deferred {
+		t.Errorf("sense %+v", s)
+	}
+	if got := s.String(); got != "medium error: unrecovered read error (information 4660)" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestParseDescriptorSense(t *testing.T) {
+	b := []byte{
+		0x73, 0x05, 0x21, 0x00, 0, 0, 0, 24,
+		0x00, 0x0a, 0x80, 0, 0, 0, 0, 1, 0, 0, 0, 0, // information: LBA 2^32
+		0x01, 0x0a, 0, 0, 0, 0, 0, 0, 0, 0, 0, 7, // command specific
+	}
+	s, err := ParseSense(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Descriptor || !s.Deferred || s.Key != SenseIllegalRequest || s.ASC != 0x21 || s.Information != 1<<32 || !s.InformationValid || s.CommandSpecific != 7 || len(s.Descriptors) != 2 {
+		t.Errorf("sense %+v", s)
+	}
+}
+
+func TestParseSenseErrors(t *testing.T) {
+	for _, b := range [][]byte{nil, {0x70, 0, 5}, {0x72, 5}, {0x7f, 0, 0, 0, 0, 0, 0, 0}} {
+		if _, err := ParseSense(b); err == nil {
+			t.Errorf("% x accepted", b)
+		}
+	}
+}
+
+func TestSenseError(t *testing.T) {
+	tests := []struct {
+		sense Sense
+		is    []error
+		isNot []error
+	}{
+		{Sense{Key: SenseNotReady, ASC: 0x3a, ASCQ: 0x02}, []error{ErrNotReady, ErrMediumNotPresent}, []error{ErrUnitAttention}},
+		{Sense{Key: SenseUnitAttention, ASC: 0x28}, []error{ErrUnitAttention, ErrMediumChanged}, []error{ErrReset}},
+		{Sense{Key: SenseUnitAttention, ASC: 0x29, ASCQ: 0x03}, []error{ErrReset}, []error{ErrMediumChanged}},
+		{Sense{Key: SenseDataProtect, ASC: 0x27, ASCQ: 0x07}, []error{ErrDataProtect, ErrWriteProtected}, nil},
+		{Sense{Key: SenseIllegalRequest, ASC: 0x24}, []error{ErrIllegalRequest, ErrInvalidField}, []error{ErrInvalidOpcode}},
+		{Sense{Key: SenseMiscompare, ASC: 0x1d}, []error{ErrMiscompare}, []error{ErrMediumError}},
+	}
+	for _, tt := range tests {
+		err := error(&SenseError{Command: "READ(10)", Sense: tt.sense})
+		for _, target := range tt.is {
+			if !errors.Is(err, target) {
+				t.Errorf("%v is not %v", err, target)
+			}
+		}
+		for _, target := range tt.isNot {
+			if errors.Is(err, target) {
+				t.Errorf("%v is %v", err, target)
+			}
+		}
+	}
+	err := &SenseError{Command: "TEST UNIT READY", Sense: Sense{Key: SenseNotReady, ASC: 0x3a, ASCQ: 0x02}}
+	if got := err.Error(); got != "scsi: TEST UNIT READY: not ready: medium not present, tray open" {
+		t.Errorf("Error() = %q", got)
+	}
+	err.Sense.ASCQ = 0x7f
+	if got := err.Sense.Description(); got != "medium not present" {
+		t.Errorf("unknown ASCQ described as %q", got)
+	}
+	err.Sense.ASC = 0x99
+	if got := err.Sense.Description(); got != "asc 0x99, ascq 0x7f" {
+		t.Errorf("unknown ASC described as %q", got)
+	}
+}
+
+// The codes of ASC 00h are looked up exactly, never as another ASC.
+func TestSenseASC00(t *testing.T) {
+	for _, ascq := range []uint8{0x04, 0x27, 0x29, 0x3a} {
+		err := error(&SenseError{Command: "READ(10)", Sense: Sense{Key: SenseNoSense, ASCQ: ascq}})
+		for _, target := range []error{ErrWriteProtected, ErrReset, ErrMediumNotPresent} {
+			if errors.Is(err, target) {
+				t.Errorf("ASCQ %#02x is %v", ascq, target)
+			}
+		}
+		s := Sense{ASCQ: ascq}
+		if got, want := s.Description(), fmt.Sprintf("asc 0x00, ascq 0x%02x", ascq); got != want {
+			t.Errorf("ASCQ %#02x described as %q", ascq, got)
+		}
+	}
+	if got := (&Sense{ASCQ: 0x1d}).Description(); got != "ATA pass through information available" {
+		t.Errorf("ASCQ 0x1d described as %q", got)
+	}
+}
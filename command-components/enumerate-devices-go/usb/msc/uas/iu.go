@@ -0,0 +1,292 @@
+package uas
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Information unit IDs, the first byte of every IU.
+const (
+	IDCommand        = 0x01
+	IDSense          = 0x03
+	IDResponse       = 0x04
+	IDTaskManagement = 0x05
+	IDReadReady      = 0x06
+	IDWriteReady     = 0x07
+)
+
+// Sizes of the information units.
+const (
+	CommandIULength        = 32 // with a command block of up to 16 bytes
+	SenseIUHeaderLength    = 16 // followed by the sense data
+	ResponseIULength       = 8
+	TaskManagementIULength = 16
+	ReadyIULength          = 4
+	// MaxSenseLength is the most sense data a Sense IU carries.
+	MaxSenseLength = 252
+)
+
+// ErrInvalidIU is returned for information units that cannot be decoded.
+var ErrInvalidIU = errors.New("uas: invalid information unit")
+
+// Task attributes of a Command IU.
+const (
+	TaskSimple      = 0
+	TaskHeadOfQueue = 1
+	TaskOrdered     = 2
+	TaskACA         = 4
+)
+
+// CommandIU is a Command IU, sent on the command pipe to start a command.
+type CommandIU struct {
+	Tag           uint16
+	TaskAttribute uint8
+	LUN           uint8
+	CB            []byte // the command block; beyond 16 bytes, a multiple of 4
+}
+
+// MarshalBinary encodes the IU. Command blocks longer than 16 bytes are
+// sent in the additional CDB bytes.
+func (c CommandIU) MarshalBinary() ([]byte, error) {
+	extra := max(len(c.CB)-16, 0)
+	if len(c.CB) == 0 || extra%4 != 0 || extra > 0xfc {
+		return nil, fmt.Errorf("uas: command block of %d bytes", len(c.CB))
+	}
+	if c.TaskAttribute > 0x07 {
+		return nil, fmt.Errorf("uas: task attribute %d", c.TaskAttribute)
+	}
+	b := make([]byte, CommandIULength+extra)
+	b[0] = IDCommand
+	binary.BigEndian.PutUint16(b[2:], c.Tag)
+	b[4] = c.TaskAttribute
+	b[6] = uint8(extra)
+	b[9] = c.LUN
+	copy(b[16:], c.CB)
+	return b, nil
+}
+
+// UnmarshalBinary decodes a Command IU. The command block keeps the 16
+// bytes of the IU, padding included.
+func (c *CommandIU) UnmarshalBinary(b []byte) error {
+	if len(b) < CommandIULength || b[0] != IDCommand {
+		return fmt.Errorf("%w: %d bytes, ID %#02x", ErrInvalidIU, len(b), firstByte(b))
+	}
+	extra := int(b[6] &^ 0x03)
+	if len(b) != CommandIULength+extra {
+		return fmt.Errorf("%w: Command IU of %d bytes with %d additional CDB bytes", ErrInvalidIU, len(b), extra)
+	}
+	if b[8] != 0 || binary.BigEndian.Uint64(b[8:])&0x0000ffffffffffff != 0 {
+		return fmt.Errorf("%w: LUN %x", ErrInvalidIU, b[8:16])
+	}
+	c.Tag = binary.BigEndian.Uint16(b[2:])
+	c.TaskAttribute = b[4] & 0x07
+	c.LUN = b[9]
+	c.CB = append([]byte(nil), b[16:]...)
+	return nil
+}
+
+// SenseIU is a Sense IU, sent on the status pipe when a command completes.
+// Despite its name it carries every status, GOOD included.
+type SenseIU struct {
+	Tag             uint16
+	StatusQualifier uint16
+	Status          uint8
+	Sense           []byte // the sense data of a CHECK CONDITION
+}
+
+// MarshalBinary encodes the IU.
+func (s SenseIU) MarshalBinary() ([]byte, error) {
+	if len(s.Sense) > MaxSenseLength {
+		return nil, fmt.Errorf("uas: %d bytes of sense data", len(s.Sense))
+	}
+	b := make([]byte, SenseIUHeaderLength, SenseIUHeaderLength+len(s.Sense))
+	b[0] = IDSense
+	binary.BigEndian.PutUint16(b[2:], s.Tag)
+	binary.BigEndian.PutUint16(b[4:], s.StatusQualifier)
+	b[6] = s.Status
+	binary.BigEndian.PutUint16(b[14:], uint16(len(s.Sense)))
+	return append(b, s.Sense...), nil
+}
+
+// UnmarshalBinary decodes a Sense IU. Sense data cut short by the transfer
+// is kept as far as it was received.
+func (s *SenseIU) UnmarshalBinary(b []byte) error {
+	if len(b) < SenseIUHeaderLength || b[0] != IDSense {
+		return fmt.Errorf("%w: %d bytes, ID %#02x", ErrInvalidIU, len(b), firstByte(b))
+	}
+	s.Tag = binary.BigEndian.Uint16(b[2:])
+	s.StatusQualifier = binary.BigEndian.Uint16(b[4:])
+	s.Status = b[6]
+	s.Sense = nil
+	if n := int(binary.BigEndian.Uint16(b[14:])); n > 0 {
+		s.Sense = append([]byte(nil), b[SenseIUHeaderLength:min(SenseIUHeaderLength+n, len(b))]...)
+	}
+	return nil
+}
+
+// ResponseCode is the outcome a Response IU reports.
+type ResponseCode uint8
+
+const (
+	ResponseComplete      ResponseCode = 0x00 // task management function complete
+	ResponseInvalidIU     ResponseCode = 0x02
+	ResponseNotSupported  ResponseCode = 0x04 // task management function not supported
+	ResponseFailed        ResponseCode = 0x05 // task management function failed
+	ResponseSucceeded     ResponseCode = 0x08 // task management function succeeded
+	ResponseIncorrectLUN  ResponseCode = 0x09
+	ResponseOverlappedTag ResponseCode = 0x0a
+)
+
+func (c ResponseCode) String() string {
+	switch c {
+	case ResponseComplete:
+		return "task management function complete"
+	case ResponseInvalidIU:
+		return "invalid information unit"
+	case ResponseNotSupported:
+		return "task management function not supported"
+	case ResponseFailed:
+		return "task management function failed"
+	case ResponseSucceeded:
+		return "task management function succeeded"
+	case ResponseIncorrectLUN:
+		return "incorrect logical unit number"
+	case ResponseOverlappedTag:
+		return "overlapped tag attempted"
+	}
+	return fmt.Sprintf("ResponseCode(%#02x)", uint8(c))
+}
+
+// ResponseIU is a Response IU, sent on the status pipe to complete a task
+// management function, or instead of a Sense IU for a Command IU the device
+// rejects.
+type ResponseIU struct {
+	Tag  uint16
+	Info [3]byte // additional response information
+	Code ResponseCode
+}
+
+// MarshalBinary encodes the IU.
+func (r ResponseIU) MarshalBinary() ([]byte, error) {
+	b := make([]byte, ResponseIULength)
+	b[0] = IDResponse
+	binary.BigEndian.PutUint16(b[2:], r.Tag)
+	copy(b[4:7], r.Info[:])
+	b[7] = uint8(r.Code)
+	return b, nil
+}
+
+// UnmarshalBinary decodes a Response IU.
+func (r *ResponseIU) UnmarshalBinary(b []byte) error {
+	if len(b) < ResponseIULength || b[0] != IDResponse {
+		return fmt.Errorf("%w: %d bytes, ID %#02x", ErrInvalidIU, len(b), firstByte(b))
+	}
+	r.Tag = binary.BigEndian.Uint16(b[2:])
+	copy(r.Info[:], b[4:7])
+	r.Code = ResponseCode(b[7])
+	return nil
+}
+
+// TaskFunction is a task management function.
+type TaskFunction uint8
+
+const (
+	AbortTask        TaskFunction = 0x01
+	AbortTaskSet     TaskFunction = 0x02
+	ClearTaskSet     TaskFunction = 0x04
+	LogicalUnitReset TaskFunction = 0x08
+	NexusReset       TaskFunction = 0x10 // I_T NEXUS RESET
+	ClearACA         TaskFunction = 0x40
+	QueryTask        TaskFunction = 0x80
+	QueryTaskSet     TaskFunction = 0x81
+	QueryAsyncEvent  TaskFunction = 0x82
+)
+
+var taskFunctionNames = map[TaskFunction]string{
+	AbortTask:        "ABORT TASK",
+	AbortTaskSet:     "ABORT TASK SET",
+	ClearTaskSet:     "CLEAR TASK SET",
+	LogicalUnitReset: "LOGICAL UNIT RESET",
+	NexusReset:       "I_T NEXUS RESET",
+	ClearACA:         "CLEAR ACA",
+	QueryTask:        "QUERY TASK",
+	QueryTaskSet:     "QUERY TASK SET",
+	QueryAsyncEvent:  "QUERY ASYNCHRONOUS EVENT",
+}
+
+func (f TaskFunction) String() string {
+	if name, ok := taskFunctionNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("TaskFunction(%#02x)", uint8(f))
+}
+
+// TaskManagementIU is a Task Management IU, sent on the command pipe.
+type TaskManagementIU struct {
+	Tag      uint16
+	Function TaskFunction
+	// TaskTag is the tag of the command that ABORT TASK and QUERY TASK
+	// manage.
+	TaskTag uint16
+	LUN     uint8
+}
+
+// MarshalBinary encodes the IU.
+func (t TaskManagementIU) MarshalBinary() ([]byte, error) {
+	b := make([]byte, TaskManagementIULength)
+	b[0] = IDTaskManagement
+	binary.BigEndian.PutUint16(b[2:], t.Tag)
+	b[4] = uint8(t.Function)
+	binary.BigEndian.PutUint16(b[6:], t.TaskTag)
+	b[9] = t.LUN
+	return b, nil
+}
+
+// UnmarshalBinary decodes a Task Management IU.
+func (t *TaskManagementIU) UnmarshalBinary(b []byte) error {
+	if len(b) != TaskManagementIULength || b[0] != IDTaskManagement {
+		return fmt.Errorf("%w: %d bytes, ID %#02x", ErrInvalidIU, len(b), firstByte(b))
+	}
+	t.Tag = binary.BigEndian.Uint16(b[2:])
+	t.Function = TaskFunction(b[4])
+	t.TaskTag = binary.BigEndian.Uint16(b[6:])
+	t.LUN = b[9]
+	return nil
+}
+
+// ReadyIU is a Read Ready or Write Ready IU. Without streams, the device
+// sends one on the status pipe when it is ready for the data stage of a
+// command.
+type ReadyIU struct {
+	Tag   uint16
+	Write bool // Write Ready: the device waits for data on the data-out pipe
+}
+
+// MarshalBinary encodes the IU.
+func (r ReadyIU) MarshalBinary() ([]byte, error) {
+	b := make([]byte, ReadyIULength)
+	b[0] = IDReadReady
+	if r.Write {
+		b[0] = IDWriteReady
+	}
+	binary.BigEndian.PutUint16(b[2:], r.Tag)
+	return b, nil
+}
+
+// UnmarshalBinary decodes a Read Ready or Write Ready IU.
+func (r *ReadyIU) UnmarshalBinary(b []byte) error {
+	if len(b) < ReadyIULength || b[0] != IDReadReady && b[0] != IDWriteReady {
+		return fmt.Errorf("%w: %d bytes, ID %#02x", ErrInvalidIU, len(b), firstByte(b))
+	}
+	r.Tag = binary.BigEndian.Uint16(b[2:])
+	r.Write = b[0] == IDWriteReady
+	return nil
+}
+
+func firstByte(b []byte) uint8 {
+	if len(b) == 0 {
+		return 0
+	}
+	return b[0]
+}
@@ -0,0 +1,105 @@
+package uas
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestCommandIU(t *testing.T) {
+	c := CommandIU{Tag: 0x1234, TaskAttribute: TaskOrdered, LUN: 2, CB: []byte{0x28, 0, 0, 0, 0, 3, 0, 0, 2, 0}}
+	b, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "01001234020000000002000000000000" + "28000000000300000200000000000000"
+	if hex.EncodeToString(b) != want {
+		t.Fatalf("encoded %x", b)
+	}
+	var d CommandIU
+	if err := d.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if d.Tag != c.Tag || d.TaskAttribute != c.TaskAttribute || d.LUN != c.LUN || !bytes.HasPrefix(d.CB, c.CB) || len(d.CB) != 16 {
+		t.Fatalf("decoded %+v", d)
+	}
+
+	// A 32-byte command block has 16 additional CDB bytes.
+	c.CB = make([]byte, 32)
+	if b, err = c.MarshalBinary(); err != nil || len(b) != 48 || b[6] != 16 {
+		t.Fatalf("long command block: %x, %v", b, err)
+	}
+	for _, n := range []int{0, 18} {
+		if _, err := (CommandIU{CB: make([]byte, n)}).MarshalBinary(); err == nil {
+			t.Errorf("command block of %d bytes accepted", n)
+		}
+	}
+}
+
+func TestSenseIU(t *testing.T) {
+	sense := []byte{0x70, 0, 0x05, 0, 0, 0, 0, 10, 0, 0, 0, 0, 0x21, 0, 0, 0, 0, 0}
+	b, err := SenseIU{Tag: 7, Status: 0x02, Sense: sense}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != SenseIUHeaderLength+len(sense) || b[0] != IDSense || b[6] != 0x02 || b[15] != uint8(len(sense)) {
+		t.Fatalf("encoded %x", b)
+	}
+	var s SenseIU
+	if err := s.UnmarshalBinary(b); err != nil || s.Tag != 7 || s.Status != 0x02 || !bytes.Equal(s.Sense, sense) {
+		t.Fatalf("decoded %+v, %v", s, err)
+	}
+	// Sense data cut short by the transfer.
+	if err := s.UnmarshalBinary(b[:20]); err != nil || len(s.Sense) != 4 {
+		t.Fatalf("short Sense IU: %+v, %v", s, err)
+	}
+	if err := s.UnmarshalBinary(b[:8]); !errors.Is(err, ErrInvalidIU) {
+		t.Fatalf("8-byte Sense IU: %v", err)
+	}
+}
+
+func TestResponseIU(t *testing.T) {
+	b, _ := ResponseIU{Tag: 3, Info: [3]byte{1, 2, 3}, Code: ResponseSucceeded}.MarshalBinary()
+	if hex.EncodeToString(b) != "0400000301020308" {
+		t.Fatalf("encoded %x", b)
+	}
+	var r ResponseIU
+	if err := r.UnmarshalBinary(b); err != nil || r.Tag != 3 || r.Info != [3]byte{1, 2, 3} || r.Code != ResponseSucceeded {
+		t.Fatalf("decoded %+v, %v", r, err)
+	}
+	if err := r.UnmarshalBinary([]byte{IDSense, 0, 0, 3, 0, 0, 0, 0}); !errors.Is(err, ErrInvalidIU) {
+		t.Fatalf("Sense IU decoded as a Response IU: %v", err)
+	}
+	if s := ResponseCode(0x42).String(); s != "ResponseCode(0x42)" {
+		t.Errorf("unknown code %s", s)
+	}
+}
+
+func TestTaskManagementIU(t *testing.T) {
+	b, _ := TaskManagementIU{Tag: 9, Function: AbortTask, TaskTag: 4, LUN: 1}.MarshalBinary()
+	if hex.EncodeToString(b) != "05000009010000040001000000000000" {
+		t.Fatalf("encoded %x", b)
+	}
+	var tm TaskManagementIU
+	if err := tm.UnmarshalBinary(b); err != nil || tm.Tag != 9 || tm.Function != AbortTask || tm.TaskTag != 4 || tm.LUN != 1 {
+		t.Fatalf("decoded %+v, %v", tm, err)
+	}
+	if s := NexusReset.String(); s != "I_T NEXUS RESET" {
+		t.Errorf("function %s", s)
+	}
+}
+
+func TestReadyIU(t *testing.T) {
+	b, _ := ReadyIU{Tag: 0x0102, Write: true}.MarshalBinary()
+	if hex.EncodeToString(b) != "07000102" {
+		t.Fatalf("encoded %x", b)
+	}
+	var r ReadyIU
+	if err := r.UnmarshalBinary(b); err != nil || r.Tag != 0x0102 || !r.Write {
+		t.Fatalf("decoded %+v, %v", r, err)
+	}
+	if err := r.UnmarshalBinary([]byte{IDCommand, 0, 1, 2}); !errors.Is(err, ErrInvalidIU) {
+		t.Fatalf("Command IU decoded as a ready IU: %v", err)
+	}
+}
@@ -0,0 +1,712 @@
+// Package uas implements USB Attached SCSI (UAS) on top of a usb.Device.
+//
+// A UAS interface has four bulk pipes. Commands and task management
+// functions are sent in information units (IUs) on the command pipe, data
+// moves on the data-in and data-out pipes, and the device completes each
+// command with an IU on the status pipe; a tag ties the IUs of a command
+// together. At SuperSpeed the status and data pipes have bulk streams, and
+// the stream of a transfer is the tag of its command. At high speed there
+// are no streams: the device announces each data stage with a Read Ready or
+// Write Ready IU on the status pipe instead.
+//
+// Command runs one command at a time. With streams, Queue runs several at
+// once, which the device may complete in any order.
+//
+// Devices with a UAS interface also have a Bulk-Only alternate setting.
+// Open uses UAS when the device and the host support it and falls back to
+// package bot otherwise; both plug into package scsi.
+package uas
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"example.com/usb"
+	"example.com/usb/msc/bot"
+	"example.com/usb/msc/scsi"
+)
+
+// ProtocolUAS is the interface protocol of a UAS mass storage interface,
+// whose class is bot.InterfaceClass.
+const ProtocolUAS = 0x62
+
+// Pipe IDs of the Pipe Usage descriptors.
+const (
+	PipeCommand = 1
+	PipeStatus  = 2
+	PipeDataIn  = 3
+	PipeDataOut = 4
+)
+
+// Descriptor types in the configuration descriptor of a UAS device that
+// package usb does not decode.
+const (
+	DescriptorPipeUsage           = 0x24
+	DescriptorSSEndpointCompanion = 0x30
+)
+
+// MaxStreams is the most streams Transport allocates. Queue runs up to one
+// command fewer at once, keeping a tag to abort them; the streams also give
+// fresh tags to task management functions and to the commands after them.
+const MaxStreams = 16
+
+// statusLength is the longest IU on the status pipe, a Sense IU with the
+// most sense data.
+const statusLength = SenseIUHeaderLength + MaxSenseLength
+
+var (
+	ErrNoInterface = errors.New("uas: no UAS or Bulk-Only mass storage interface")
+	ErrInvalidLUN  = errors.New("uas: no such LUN")
+)
+
+// ResponseError is a command or task management function that the device
+// answered with a Response IU reporting a failure.
+type ResponseError struct {
+	Function TaskFunction // 0 for a command
+	Code     ResponseCode
+	Info     [3]byte
+}
+
+func (e *ResponseError) Error() string {
+	if e.Function == 0 {
+		return fmt.Sprintf("uas: command: %s", e.Code)
+	}
+	return fmt.Sprintf("uas: %s: %s", e.Function, e.Code)
+}
+
+// Find returns the first UAS interface of the active configuration. It is
+// usually alternate setting 1 of an interface whose setting 0 is
+// Bulk-Only.
+func Find(d *usb.Device) (*usb.Interface, bool) {
+	config, ok := d.ActiveConfiguration()
+	if !ok {
+		return nil, false
+	}
+	for i := range config.Interfaces {
+		desc := config.Interfaces[i].Descriptor
+		if desc.InterfaceClass == bot.InterfaceClass && desc.InterfaceProtocol == ProtocolUAS {
+			return &config.Interfaces[i], true
+		}
+	}
+	return nil, false
+}
+
+// Open returns a SCSI transport for the mass storage interface of d, and
+// its highest LUN. It uses UAS if the device has a UAS interface, unless it
+// has usb.QuirkIgnoreUAS, and New succeeds; otherwise it uses the
+// Bulk-Only interface. The device must be open and configured.
+func Open(d *usb.Device) (scsi.Transport, uint8, error) {
+	var uasErr error
+	if intf, ok := Find(d); ok && !d.Quirks().Has(usb.QuirkIgnoreUAS) {
+		t, err := New(d, intf)
+		if err == nil {
+			return t, t.MaxLUN(), nil
+		}
+		uasErr = err
+	}
+	intf, ok := bot.Find(d)
+	if !ok {
+		if uasErr != nil {
+			return nil, 0, uasErr
+		}
+		return nil, 0, ErrNoInterface
+	}
+	t, err := bot.New(d, intf)
+	if err != nil {
+		return nil, 0, errors.Join(uasErr, err)
+	}
+	return scsi.BulkOnly(t), t.MaxLUN(), nil
+}
+
+// Transport runs SCSI commands on a UAS interface. It implements
+// scsi.QueueTransport.
+type Transport struct {
+	dev         *usb.Device
+	number, alt uint8
+
+	command, status, dataIn, dataOut uint8
+	// streams is the number of streams allocated on the status and data
+	// pipes, or 0 without streams.
+	streams uint32
+	tag     uint16
+	maxLUN  uint8
+}
+
+var _ scsi.QueueTransport = (*Transport)(nil)
+
+// New claims the alternate setting of intf, releasing another one of the
+// interface if needed, and finds its pipes in the configuration descriptor.
+// At SuperSpeed it allocates the streams of the status and data pipes, and
+// fails with usb.ErrNotSupported if the device or the host has none. It
+// asks the device for its LUNs with REPORT LUNS, and registers Reset as the
+// device's class reset.
+//
+// On failure the interface is released, so that its Bulk-Only alternate
+// setting can be used instead. usb.QuirkSingleLUN is honoured.
+func New(d *usb.Device, intf *usb.Interface) (*Transport, error) {
+	desc := intf.Descriptor
+	t := &Transport{dev: d, number: desc.InterfaceNumber, alt: desc.AlternateSetting}
+	available, err := t.readPipes()
+	if err != nil {
+		return nil, err
+	}
+	if alt, ok := d.Claimed(t.number); ok && alt != t.alt {
+		if err := d.ReleaseInterface(t.number, alt); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := d.Claimed(t.number); !ok {
+		if err := d.ClaimInterface(t.number, t.alt); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.setup(available); err != nil {
+		if cerr := t.Close(); cerr != nil {
+			return nil, errors.Join(err, cerr)
+		}
+		return nil, err
+	}
+	d.SetClassReset(t.Reset)
+	return t, nil
+}
+
+func (t *Transport) setup(available uint32) error {
+	if t.dev.Speed() >= usb.SpeedSuper {
+		if available < 2 {
+			return fmt.Errorf("uas: interface %d: %w: %d streams at SuperSpeed", t.number, usb.ErrNotSupported, available)
+		}
+		n, err := t.dev.AllocStreams(min(available, MaxStreams), t.status, t.dataIn, t.dataOut)
+		if err != nil {
+			return fmt.Errorf("uas: allocating streams: %w", err)
+		}
+		t.streams = n
+		if n < 2 {
+			return fmt.Errorf("uas: interface %d: %w: the host allocated %d streams", t.number, usb.ErrNotSupported, n)
+		}
+	}
+	if t.dev.Quirks().Has(usb.QuirkSingleLUN) {
+		return nil
+	}
+	luns, err := scsi.New(t, 0).ReportLUNs()
+	var serr *scsi.SenseError
+	switch {
+	case errors.As(err, &serr):
+		// A device with a single LUN need not implement REPORT LUNS.
+		return nil
+	case err != nil:
+		return err
+	}
+	for _, lun := range luns {
+		t.maxLUN = max(t.maxLUN, lun)
+	}
+	return nil
+}
+
+// readPipes reads the configuration descriptor of the active configuration
+// to find the endpoint of each pipe of the interface in its Pipe Usage
+// descriptors, and the streams its SuperSpeed Endpoint Companion
+// descriptors allow on the status and data pipes.
+func (t *Transport) readPipes() (uint32, error) {
+	index := -1
+	if config, ok := t.dev.ActiveConfiguration(); ok {
+		for i, c := range t.dev.Configurations() {
+			if c.Descriptor.Number == config.Descriptor.Number {
+				index = i
+			}
+		}
+	}
+	if index < 0 {
+		return 0, fmt.Errorf("uas: %w", usb.ErrNotConfigured)
+	}
+	setup := usb.ControlSetup{
+		Type:      usb.ControlStandard,
+		Recipient: usb.RecipientDevice,
+		Request:   usb.RequestGetDescriptor,
+		Value:     uint16(usb.DescriptorTypeConfiguration)<<8 | uint16(index),
+	}
+	b, err := t.dev.ReadControl(setup, 9)
+	if err != nil {
+		return 0, fmt.Errorf("uas: reading the configuration descriptor: %w", err)
+	}
+	if len(b) < 4 {
+		return 0, fmt.Errorf("uas: configuration descriptor of %d bytes", len(b))
+	}
+	if b, err = t.dev.ReadControl(setup, binary.LittleEndian.Uint16(b[2:])); err != nil {
+		return 0, fmt.Errorf("uas: reading the configuration descriptor: %w", err)
+	}
+
+	var pipes [PipeDataOut + 1]uint8
+	streams := make(map[uint8]uint32)
+	inInterface := false
+	var endpoint uint8
+	for len(b) >= 2 {
+		n := int(b[0])
+		if n < 2 || n > len(b) {
+			return 0, fmt.Errorf("uas: malformed configuration descriptor")
+		}
+		switch {
+		case b[1] == 0x04 && n >= 9: // interface
+			inInterface = b[2] == t.number && b[3] == t.alt
+			endpoint = 0
+		case !inInterface:
+		case b[1] == 0x05 && n >= 7: // endpoint
+			endpoint = b[2]
+		case b[1] == DescriptorSSEndpointCompanion && n >= 6 && endpoint != 0:
+			if maxStreams := b[3] & 0x1f; maxStreams > 0 {
+				streams[endpoint] = 1 << maxStreams
+			}
+		case b[1] == DescriptorPipeUsage && n >= 4 && endpoint != 0:
+			if id := b[2]; id >= PipeCommand && id <= PipeDataOut {
+				pipes[id] = endpoint
+			}
+		}
+		b = b[n:]
+	}
+	t.command, t.status, t.dataIn, t.dataOut = pipes[PipeCommand], pipes[PipeStatus], pipes[PipeDataIn], pipes[PipeDataOut]
+	if t.command&0x80 != 0 || t.status&0x80 == 0 || t.dataIn&0x80 == 0 || t.dataOut&0x80 != 0 || t.command == 0 || t.dataOut == 0 {
+		return 0, fmt.Errorf("uas: interface %d alternate %d has no command, status, data-in and data-out pipes", t.number, t.alt)
+	}
+	return min(streams[t.status], streams[t.dataIn], streams[t.dataOut]), nil
+}
+
+// Device returns the device the transport runs on.
+func (t *Transport) Device() *usb.Device {
+	return t.dev
+}
+
+// MaxLUN returns the highest LUN of the device.
+func (t *Transport) MaxLUN() uint8 {
+	return t.maxLUN
+}
+
+// Streams returns the number of streams allocated on the status and data
+// pipes, or 0 if the device runs without streams.
+func (t *Transport) Streams() uint32 {
+	return t.streams
+}
+
+// Pipes returns the endpoint addresses of the command, status, data-in and
+// data-out pipes.
+func (t *Transport) Pipes() (command, status, dataIn, dataOut uint8) {
+	return t.command, t.status, t.dataIn, t.dataOut
+}
+
+// nextTag returns the tag of the next IU: with streams, it is the stream of
+// the data and status pipes, from 1 to the number allocated.
+func (t *Transport) nextTag() uint16 {
+	if t.streams > 0 {
+		t.tag = uint16(uint32(t.tag)%t.streams + 1)
+	} else if t.tag++; t.tag == 0 {
+		t.tag = 1
+	}
+	return t.tag
+}
+
+// Command runs command block cb on lun. The sense data of a CHECK CONDITION
+// comes with the status in the Sense IU. A command the device rejects with
+// a Response IU returns a *ResponseError.
+//
+// Errors are reserved for transfers that fail and IUs that are invalid; the
+// command is aborted with ABORT TASK before they are returned.
+func (t *Transport) Command(lun uint8, cb []byte, dir usb.Direction, data []byte) (scsi.Completion, error) {
+	if lun > t.maxLUN {
+		return scsi.Completion{}, fmt.Errorf("%w: %d, max %d", ErrInvalidLUN, lun, t.maxLUN)
+	}
+	tag := t.nextTag()
+	iu, err := commandIU(tag, lun, cb)
+	if err != nil {
+		return scsi.Completion{}, err
+	}
+	n, status, err := t.exchange(iu, tag, dir, data)
+	if err != nil {
+		return scsi.Completion{}, t.fail(lun, tag, err)
+	}
+	c, err := completion(tag, status)
+	var rerr *ResponseError
+	if err != nil && !errors.As(err, &rerr) {
+		return scsi.Completion{}, t.fail(lun, tag, err)
+	}
+	c.Residue = uint32(len(data) - n)
+	return c, err
+}
+
+// commandIU encodes the Command IU of cb, padding its additional bytes to
+// a multiple of 4.
+func commandIU(tag uint16, lun uint8, cb []byte) ([]byte, error) {
+	if extra := len(cb) - 16; extra > 0 && extra%4 != 0 {
+		cb = append(cb[:len(cb):len(cb)], make([]byte, 4-extra%4)...)
+	}
+	return CommandIU{Tag: tag, LUN: lun, CB: cb}.MarshalBinary()
+}
+
+// QueueDepth returns the most commands Queue runs at once: one less than
+// the streams, or 1 without streams.
+func (t *Transport) QueueDepth() int {
+	if t.streams < 2 {
+		return 1
+	}
+	return int(t.streams) - 1
+}
+
+// queued is a command of Queue.
+type queued struct {
+	scsi.Request
+	tag  uint16
+	n    int  // bytes transferred
+	data bool // the data stage is still to be moved
+	done bool
+	c    scsi.Completion
+	err  error // a *ResponseError
+}
+
+// Queue runs the commands of reqs on lun at once, each on the streams of
+// its own tag, and returns their completions in the order of reqs; a
+// command the device rejects has a *ResponseError among the joined errors
+// returned with them. Without streams the commands run one after the
+// other.
+//
+// The device completes the commands in any order, but a batch of stream
+// transfers ends when its last transfer completes: the data and status
+// transfers of the commands still running are cancelled then, and
+// submitted again. A data stage cancelled midway cannot be resumed. It
+// fails the queue, like a transfer that fails or an invalid IU, and the
+// commands still running are aborted with ABORT TASK.
+func (t *Transport) Queue(lun uint8, reqs []scsi.Request) ([]scsi.Completion, error) {
+	cs := make([]scsi.Completion, len(reqs))
+	var errs []error
+	if t.streams == 0 {
+		for i, r := range reqs {
+			c, err := t.Command(lun, r.CB, r.Dir, r.Data)
+			var rerr *ResponseError
+			if err != nil && !errors.As(err, &rerr) {
+				return nil, err
+			}
+			cs[i], errs = c, append(errs, err)
+		}
+		return cs, errors.Join(errs...)
+	}
+	if lun > t.maxLUN {
+		return nil, fmt.Errorf("%w: %d, max %d", ErrInvalidLUN, lun, t.maxLUN)
+	}
+	if len(reqs) > t.QueueDepth() {
+		return nil, fmt.Errorf("uas: %d commands queued, at most %d", len(reqs), t.QueueDepth())
+	}
+	q := make([]queued, len(reqs))
+	var transfers []usb.StreamTransfer
+	for i, r := range reqs {
+		q[i] = queued{Request: r, tag: t.nextTag(), data: len(r.Data) > 0}
+		iu, err := commandIU(q[i].tag, lun, r.CB)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, usb.StreamTransfer{Endpoint: t.command, Data: iu})
+	}
+	for pending := len(q); pending > 0; {
+		if err := t.round(q, transfers); err != nil {
+			return nil, t.abort(lun, q, err)
+		}
+		transfers, pending = nil, 0
+		for i := range q {
+			if !q[i].done {
+				pending++
+			}
+		}
+	}
+	for i := range q {
+		cs[i], errs = q[i].c, append(errs, q[i].err)
+	}
+	return cs, errors.Join(errs...)
+}
+
+// round submits the IUs, if any, and the data and status transfers of the
+// commands of q that are not done, and takes the results.
+func (t *Transport) round(q []queued, ius []usb.StreamTransfer) error {
+	// The commands of the data and status transfers, which follow the IUs.
+	var data, status []int
+	transfers := ius
+	for i := range q {
+		if x := &q[i]; !x.done && x.data {
+			transfer := usb.StreamTransfer{Endpoint: t.dataPipe(x.Dir), StreamID: uint32(x.tag), Length: len(x.Data)}
+			if x.Dir == usb.DirectionOut {
+				transfer.Data, transfer.Length = x.Data, 0
+			}
+			data = append(data, i)
+			transfers = append(transfers, transfer)
+		}
+	}
+	for i := range q {
+		if !q[i].done {
+			status = append(status, i)
+			transfers = append(transfers, usb.StreamTransfer{Endpoint: t.status, StreamID: uint32(q[i].tag), Length: statusLength})
+		}
+	}
+	results, err := t.dev.TransferStreams(transfers...)
+	if err != nil {
+		return err
+	}
+	for i := range ius {
+		if err := streamError("sending IU", results[i].Status); err != nil {
+			return err
+		}
+	}
+	results = results[len(ius):]
+	for j, i := range data {
+		x, r := &q[i], results[j]
+		switch r.Status {
+		case usb.StreamCancelled:
+			if r.Actual > 0 {
+				return fmt.Errorf("uas: data stage of tag %d cancelled after %d bytes", x.tag, r.Actual)
+			}
+			continue
+		case usb.StreamStalled:
+			if err := t.dev.ClearHalt(t.dataPipe(x.Dir)); err != nil {
+				return err
+			}
+		}
+		x.n, x.data = min(r.Actual, len(x.Data)), false
+		if x.Dir == usb.DirectionIn {
+			x.n = copy(x.Data, r.Data)
+		}
+	}
+	results = results[len(data):]
+	for j, i := range status {
+		x, r := &q[i], results[j]
+		if r.Status == usb.StreamCancelled {
+			continue
+		}
+		if r.Status == usb.StreamStalled {
+			if err := t.dev.ClearHalt(t.status); err != nil {
+				return err
+			}
+		}
+		if err := streamError("reading status", r.Status); err != nil {
+			return err
+		}
+		c, err := completion(x.tag, r.Data)
+		var rerr *ResponseError
+		if err != nil && !errors.As(err, &rerr) {
+			return err
+		}
+		// The data stage of a command completed without it is the residue.
+		c.Residue = uint32(len(x.Data) - x.n)
+		x.c, x.err, x.data, x.done = c, err, false, true
+	}
+	return nil
+}
+
+// abort aborts the commands of q that are not done after err, and returns
+// err with the errors of the aborts.
+func (t *Transport) abort(lun uint8, q []queued, err error) error {
+	for i := range q {
+		if !q[i].done {
+			err = t.fail(lun, q[i].tag, err)
+		}
+	}
+	return err
+}
+
+// completion decodes the IU that completes a command.
+func completion(tag uint16, b []byte) (scsi.Completion, error) {
+	switch firstByte(b) {
+	case IDSense:
+		var s SenseIU
+		if err := s.UnmarshalBinary(b); err != nil {
+			return scsi.Completion{}, err
+		}
+		if s.Tag != tag {
+			return scsi.Completion{}, fmt.Errorf("%w: Sense IU for tag %d, want %d", ErrInvalidIU, s.Tag, tag)
+		}
+		return scsi.Completion{Status: scsi.Status(s.Status), Sense: s.Sense}, nil
+	case IDResponse:
+		var r ResponseIU
+		if err := r.UnmarshalBinary(b); err != nil {
+			return scsi.Completion{}, err
+		}
+		if r.Tag != tag {
+			return scsi.Completion{}, fmt.Errorf("%w: Response IU for tag %d, want %d", ErrInvalidIU, r.Tag, tag)
+		}
+		return scsi.Completion{}, &ResponseError{Code: r.Code, Info: r.Info}
+	}
+	return scsi.Completion{}, fmt.Errorf("%w: %d bytes with ID %#02x on the status pipe", ErrInvalidIU, len(b), firstByte(b))
+}
+
+// exchange sends an IU on the command pipe, moves the data stage if there
+// is one, and returns the number of bytes transferred and the IU that
+// completes the exchange.
+func (t *Transport) exchange(iu []byte, tag uint16, dir usb.Direction, data []byte) (int, []byte, error) {
+	if t.streams > 0 {
+		return t.exchangeStreams(iu, tag, dir, data)
+	}
+	if _, err := t.dev.WriteBulk(t.command, iu); err != nil {
+		return 0, nil, fmt.Errorf("uas: sending IU: %w", err)
+	}
+	status, err := t.readStatus()
+	if err != nil || len(data) == 0 {
+		return 0, status, err
+	}
+	var ready ReadyIU
+	if ready.UnmarshalBinary(status) != nil {
+		// The command completed without its data stage.
+		return 0, status, nil
+	}
+	if ready.Tag != tag || ready.Write != (dir == usb.DirectionOut) {
+		return 0, nil, fmt.Errorf("%w: ready IU %+v for tag %d", ErrInvalidIU, ready, tag)
+	}
+	var n int
+	if dir == usb.DirectionOut {
+		n, err = t.dev.WriteBulk(t.dataOut, data)
+	} else {
+		var read []byte
+		read, err = t.dev.ReadBulk(t.dataIn, len(data))
+		n = copy(data, read)
+	}
+	if err != nil {
+		// The device stalls the data pipe when it processed less data
+		// than expected; the status tells why.
+		if !errors.Is(err, usb.ErrStall) {
+			return n, nil, fmt.Errorf("uas: data stage: %w", err)
+		}
+		if err := t.dev.ClearHalt(t.dataPipe(dir)); err != nil {
+			return n, nil, err
+		}
+	}
+	status, err = t.readStatus()
+	return n, status, err
+}
+
+// exchangeStreams submits the IU, the data stage and the status read
+// together: the data transfer is cancelled if the device completes the
+// command without it.
+func (t *Transport) exchangeStreams(iu []byte, tag uint16, dir usb.Direction, data []byte) (int, []byte, error) {
+	transfers := []usb.StreamTransfer{{Endpoint: t.command, Data: iu}}
+	if len(data) > 0 {
+		transfer := usb.StreamTransfer{Endpoint: t.dataPipe(dir), StreamID: uint32(tag), Length: len(data)}
+		if dir == usb.DirectionOut {
+			transfer.Data, transfer.Length = data, 0
+		}
+		transfers = append(transfers, transfer)
+	}
+	transfers = append(transfers, usb.StreamTransfer{Endpoint: t.status, StreamID: uint32(tag), Length: statusLength})
+	results, err := t.dev.TransferStreams(transfers...)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := streamError("sending IU", results[0].Status); err != nil {
+		return 0, nil, err
+	}
+	var n int
+	if len(data) > 0 {
+		r := results[1]
+		n = min(r.Actual, len(data))
+		if dir == usb.DirectionIn {
+			n = copy(data, r.Data)
+		}
+		if r.Status == usb.StreamStalled {
+			if err := t.dev.ClearHalt(t.dataPipe(dir)); err != nil {
+				return n, nil, err
+			}
+		}
+	}
+	status := results[len(results)-1]
+	if status.Status == usb.StreamStalled {
+		if err := t.dev.ClearHalt(t.status); err != nil {
+			return n, nil, err
+		}
+	}
+	if err := streamError("reading status", status.Status); err != nil {
+		return n, nil, err
+	}
+	return n, status.Data, nil
+}
+
+func streamError(what string, status usb.StreamStatus) error {
+	switch status {
+	case usb.StreamCompleted:
+		return nil
+	case usb.StreamStalled:
+		return fmt.Errorf("uas: %s: %w", what, usb.ErrStall)
+	}
+	return fmt.Errorf("uas: %s: transfer %s", what, status)
+}
+
+func (t *Transport) dataPipe(dir usb.Direction) uint8 {
+	if dir == usb.DirectionOut {
+		return t.dataOut
+	}
+	return t.dataIn
+}
+
+// readStatus reads an IU on the status pipe. A stalled pipe is cleared, so
+// that the command can be aborted.
+func (t *Transport) readStatus() ([]byte, error) {
+	b, err := t.dev.ReadBulk(t.status, statusLength)
+	if errors.Is(err, usb.ErrStall) {
+		if cerr := t.dev.ClearHalt(t.status); cerr != nil {
+			return nil, cerr
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("uas: reading status: %w", err)
+	}
+	return b, nil
+}
+
+// fail aborts the command with tag after err and returns err, together with
+// the error of the abort if it failed too.
+func (t *Transport) fail(lun uint8, tag uint16, err error) error {
+	if _, aerr := t.TaskManagement(lun, AbortTask, tag); aerr != nil {
+		return errors.Join(err, fmt.Errorf("uas: aborting tag %d: %w", tag, aerr))
+	}
+	return err
+}
+
+// TaskManagement sends a task management function to lun. task is the tag
+// of the command that ABORT TASK and QUERY TASK manage, and is ignored by
+// the other functions. It returns the additional response information, and
+// a *ResponseError unless the function completed or succeeded.
+func (t *Transport) TaskManagement(lun uint8, function TaskFunction, task uint16) ([3]byte, error) {
+	tag := t.nextTag()
+	iu, _ := TaskManagementIU{Tag: tag, Function: function, TaskTag: task, LUN: lun}.MarshalBinary()
+	_, b, err := t.exchange(iu, tag, usb.DirectionOut, nil)
+	if err != nil {
+		return [3]byte{}, err
+	}
+	var r ResponseIU
+	if err := r.UnmarshalBinary(b); err != nil {
+		return [3]byte{}, err
+	}
+	if r.Tag != tag {
+		return r.Info, fmt.Errorf("%w: Response IU for tag %d, want %d", ErrInvalidIU, r.Tag, tag)
+	}
+	if r.Code != ResponseComplete && r.Code != ResponseSucceeded {
+		return r.Info, &ResponseError{Function: function, Code: r.Code, Info: r.Info}
+	}
+	return r.Info, nil
+}
+
+// ResetLUN sends LOGICAL UNIT RESET to lun.
+func (t *Transport) ResetLUN(lun uint8) error {
+	_, err := t.TaskManagement(lun, LogicalUnitReset, 0)
+	return err
+}
+
+// Reset sends I_T NEXUS RESET, which aborts the commands of every LUN. It is
+// the class reset of the device; a device that does not answer it needs a
+// port reset.
+func (t *Transport) Reset() error {
+	_, err := t.TaskManagement(0, NexusReset, 0)
+	return err
+}
+
+// Close frees the streams and releases the interface, so that its
+// Bulk-Only alternate setting can be claimed.
+func (t *Transport) Close() error {
+	if t.streams > 0 {
+		if err := t.dev.FreeStreams(t.status, t.dataIn, t.dataOut); err != nil {
+			return err
+		}
+		t.streams = 0
+	}
+	return t.dev.ReleaseInterface(t.number, t.alt)
+}
@@ -0,0 +1,363 @@
+package uas_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"example.com/usb"
+	"example.com/usb/msc/msctest"
+	"example.com/usb/msc/scsi"
+	"example.com/usb/msc/uas"
+)
+
+func open(t *testing.T, host usb.Host, quirks usb.Quirk) (scsi.Transport, uint8, *usb.Device) {
+	t.Helper()
+	d := usb.NewDevice(host)
+	d.SetQuirks(usb.QuirkEntry{Quirks: quirks})
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	tr, maxLUN, err := uas.Open(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr, maxLUN, d
+}
+
+// readWrite writes two blocks to LUN 0 and reads them back, and reads past
+// the end of the medium.
+func readWrite(t *testing.T, tr scsi.Transport, disk *msctest.Disk) {
+	t.Helper()
+	dev := scsi.New(tr, 0)
+	c, err := dev.ReadCapacity()
+	if err != nil || c.Blocks != 64 || c.BlockSize != 512 {
+		t.Fatalf("capacity %+v, %v", c, err)
+	}
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64)
+	if err := dev.Write(3, data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(disk.LUNs[0].Data[3*512:5*512], data) {
+		t.Fatal("medium not written")
+	}
+	buf := make([]byte, len(data))
+	if err := dev.Read(3, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Fatal("read back different data")
+	}
+
+	if err := dev.Read(64, buf[:512]); !errors.Is(err, scsi.ErrLBAOutOfRange) {
+		t.Fatalf("read past the end: %v", err)
+	}
+}
+
+func TestStreams(t *testing.T) {
+	disk := msctest.NewUAS(msctest.NewLUN(64, 512))
+	tr, maxLUN, d := open(t, disk, 0)
+	u, ok := tr.(*uas.Transport)
+	if !ok {
+		t.Fatalf("transport %T", tr)
+	}
+	if u.Streams() != uas.MaxStreams || maxLUN != 0 {
+		t.Fatalf("%d streams, max LUN %d", u.Streams(), maxLUN)
+	}
+	if cmd, status, in, out := u.Pipes(); cmd != msctest.EndpointCommand || status != msctest.EndpointStatus || in != msctest.EndpointIn || out != msctest.EndpointOut {
+		t.Fatalf("pipes %#02x %#02x %#02x %#02x", cmd, status, in, out)
+	}
+	if alt, ok := d.Claimed(0); !ok || alt != 1 {
+		t.Fatalf("alternate setting %d claimed", alt)
+	}
+	// More commands than streams: the tags wrap around.
+	for range 3 {
+		readWrite(t, tr, disk.Disk)
+	}
+	// The sense data comes with the status: no REQUEST SENSE is sent.
+	for _, cb := range disk.Commands {
+		if cb[0] == scsi.OpRequestSense {
+			t.Fatal("REQUEST SENSE sent")
+		}
+	}
+
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.Claimed(0); ok || d.Streams(msctest.EndpointStatus) != 0 {
+		t.Fatal("interface still claimed after Close")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	disk := msctest.NewUAS(msctest.NewLUN(64, 512))
+	tr, _, d := open(t, disk, 0)
+	d.SetSafety(usb.SafetyOptions{Mode: usb.SafetyReadOnly})
+	// The IU of every command is written on the command pipe, in the batch of
+	// stream transfers.
+	dev := scsi.New(tr, 0)
+	if err := dev.Write(3, make([]byte, 512)); !errors.Is(err, usb.ErrReadOnly) {
+		t.Fatalf("write: %v", err)
+	}
+	for _, cb := range disk.Commands {
+		if cb[0] == scsi.OpWrite10 {
+			t.Fatal("WRITE(10) reached the device")
+		}
+	}
+}
+
+func TestReadyIUs(t *testing.T) {
+	disk := msctest.NewUAS(msctest.NewLUN(64, 512))
+	disk.HighSpeed = true
+	tr, _, _ := open(t, disk, 0)
+	if u, ok := tr.(*uas.Transport); !ok || u.Streams() != 0 {
+		t.Fatalf("transport %T", tr)
+	}
+	readWrite(t, tr, disk.Disk)
+}
+
+func TestReportLUNs(t *testing.T) {
+	disk := msctest.NewUAS(msctest.NewLUN(64, 512), msctest.NewLUN(8, 2048))
+	tr, maxLUN, _ := open(t, disk, 0)
+	if maxLUN != 1 {
+		t.Fatalf("max LUN %d", maxLUN)
+	}
+	c, err := scsi.New(tr, 1).ReadCapacity()
+	if err != nil || c.Blocks != 8 || c.BlockSize != 2048 {
+		t.Fatalf("LUN 1: %+v, %v", c, err)
+	}
+	if _, err := tr.Command(2, []byte{scsi.OpTestUnitReady, 0, 0, 0, 0, 0}, usb.DirectionOut, nil); !errors.Is(err, uas.ErrInvalidLUN) {
+		t.Fatalf("LUN 2: %v", err)
+	}
+
+	disk = msctest.NewUAS(msctest.NewLUN(64, 512), msctest.NewLUN(8, 2048))
+	if _, maxLUN, _ := open(t, disk, usb.QuirkSingleLUN); maxLUN != 0 {
+		t.Fatalf("max LUN %d with usb.QuirkSingleLUN", maxLUN)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	noStreams := msctest.NewUAS(msctest.NewLUN(64, 512))
+	noStreams.MaxStreams = 0
+	for name, test := range map[string]struct {
+		disk   *msctest.Disk
+		host   usb.Host
+		quirks usb.Quirk
+	}{
+		"no streams": {noStreams.Disk, noStreams, 0},
+		"ignore-uas": {nil, msctest.NewUAS(msctest.NewLUN(64, 512)), usb.QuirkIgnoreUAS},
+		"bulk-only":  {msctest.New(64, 512), nil, 0},
+	} {
+		if test.host == nil {
+			test.host = test.disk
+		} else if test.disk == nil {
+			test.disk = test.host.(*msctest.UASDisk).Disk
+		}
+		tr, _, d := open(t, test.host, test.quirks)
+		if _, ok := tr.(*uas.Transport); ok {
+			t.Errorf("%s: UAS transport", name)
+			continue
+		}
+		if alt, ok := d.Claimed(0); !ok || alt != 0 {
+			t.Errorf("%s: alternate setting %d claimed", name, alt)
+		}
+		readWrite(t, tr, test.disk)
+	}
+}
+
+func TestTaskManagement(t *testing.T) {
+	for _, highSpeed := range []bool{false, true} {
+		disk := msctest.NewUAS(msctest.NewLUN(64, 512))
+		disk.HighSpeed = highSpeed
+		tr, _, _ := open(t, disk, 0)
+		u := tr.(*uas.Transport)
+
+		if err := u.ResetLUN(0); err != nil {
+			t.Fatal(err)
+		}
+		if err := u.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		_, err := u.TaskManagement(0, uas.QueryTask, 1)
+		var rerr *uas.ResponseError
+		if !errors.As(err, &rerr) || rerr.Function != uas.QueryTask || rerr.Code != uas.ResponseNotSupported {
+			t.Fatalf("QUERY TASK: %v", err)
+		}
+		if _, err := u.TaskManagement(3, uas.AbortTaskSet, 0); !errors.As(err, &rerr) || rerr.Code != uas.ResponseIncorrectLUN {
+			t.Fatalf("ABORT TASK SET on LUN 3: %v", err)
+		}
+		want := []msctest.Task{{Function: 0x08}, {Function: 0x10}, {Function: 0x80, TaskTag: 1}, {Function: 0x02, LUN: 3}}
+		if len(disk.Tasks) != len(want) {
+			t.Fatalf("tasks %+v", disk.Tasks)
+		}
+		for i, task := range disk.Tasks {
+			task.Tag = 0
+			if task != want[i] {
+				t.Errorf("task %d: %+v, want %+v", i, task, want[i])
+			}
+		}
+	}
+}
+
+func TestAbort(t *testing.T) {
+	for _, highSpeed := range []bool{false, true} {
+		disk := msctest.NewUAS(msctest.NewLUN(64, 512))
+		disk.HighSpeed = highSpeed
+		tr, _, _ := open(t, disk, 0)
+
+		// A command whose status pipe stalls is aborted.
+		disk.StallStatus = 1
+		dev := scsi.New(tr, 0)
+		if err := dev.TestUnitReady(); !errors.Is(err, usb.ErrStall) {
+			t.Fatalf("stalled status: %v", err)
+		}
+		if len(disk.Tasks) != 1 || disk.Tasks[0].Function != 0x01 || disk.Tasks[0].TaskTag == 0 {
+			t.Fatalf("tasks %+v", disk.Tasks)
+		}
+		readWrite(t, tr, disk.Disk)
+	}
+}
+
+func TestRejected(t *testing.T) {
+	disk := msctest.NewUAS(msctest.NewLUN(64, 512))
+	tr, _, _ := open(t, disk, 0)
+	disk.Reject = func(lun uint8, cb []byte) uint8 {
+		if cb[0] == scsi.OpSynchronizeCache10 {
+			return uint8(uas.ResponseInvalidIU)
+		}
+		return 0
+	}
+	err := scsi.New(tr, 0).SynchronizeCache()
+	var rerr *uas.ResponseError
+	if !errors.As(err, &rerr) || rerr.Function != 0 || rerr.Code != uas.ResponseInvalidIU {
+		t.Fatalf("rejected command: %v", err)
+	}
+	// The device rejected the command: it is not aborted.
+	if len(disk.Tasks) != 0 {
+		t.Fatalf("tasks %+v", disk.Tasks)
+	}
+	readWrite(t, tr, disk.Disk)
+}
+
+// batches records the number of IUs and transfers of each batch of stream
+// transfers.
+type batches struct {
+	*msctest.UASDisk
+	ius, transfers []int
+}
+
+func (b *batches) TransferStreams(transfers []usb.StreamTransfer) ([]usb.StreamResult, error) {
+	ius := 0
+	for _, t := range transfers {
+		if t.Endpoint == msctest.EndpointCommand {
+			ius++
+		}
+	}
+	b.ius, b.transfers = append(b.ius, ius), append(b.transfers, len(transfers))
+	return b.UASDisk.TransferStreams(transfers)
+}
+
+func TestQueue(t *testing.T) {
+	for _, outOfOrder := range []bool{false, true} {
+		disk := msctest.NewUAS(msctest.NewLUN(64, 512))
+		disk.OutOfOrder = outOfOrder
+		host := &batches{UASDisk: disk}
+		tr, _, _ := open(t, host, 0)
+		u := tr.(*uas.Transport)
+		dev := scsi.New(tr, 0)
+		if u.QueueDepth() != uas.MaxStreams-1 || dev.QueueDepth() != u.QueueDepth() {
+			t.Fatalf("queue depth %d, %d", u.QueueDepth(), dev.QueueDepth())
+		}
+		if _, err := dev.ReadCapacity(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Twenty writes run in a queue of 15 and one of 5, whose IUs go in
+		// one batch each. A device that completes them in reverse order
+		// needs a batch per command.
+		var xs []scsi.Transfer
+		for i := range 20 {
+			xs = append(xs, scsi.Transfer{Write: true, LBA: uint64(2 * i), Buf: bytes.Repeat([]byte{byte(i + 1)}, 512)})
+		}
+		host.ius, host.transfers = nil, nil
+		if err := dev.Queue(xs); err != nil {
+			t.Fatal(err)
+		}
+		want := []int{15, 5}
+		if outOfOrder {
+			want = []int{15, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 5, 0, 0, 0, 0}
+		}
+		if fmt.Sprint(host.ius) != fmt.Sprint(want) {
+			t.Errorf("IUs of the batches %v, want %v", host.ius, want)
+		}
+		if outOfOrder && (host.transfers[0] != 45 || host.transfers[1] != 28) {
+			t.Errorf("transfers of the batches %v", host.transfers)
+		}
+		for i := range 20 {
+			if disk.LUNs[0].Data[2*i*512] != byte(i+1) {
+				t.Fatalf("block %d not written", 2*i)
+			}
+		}
+
+		// Reads, one of them past the end of the medium.
+		xs = xs[:4]
+		for i := range xs {
+			xs[i] = scsi.Transfer{LBA: uint64(2 * i), Buf: make([]byte, 512)}
+		}
+		xs[3].LBA = 64
+		if err := dev.Queue(xs); !errors.Is(err, scsi.ErrLBAOutOfRange) {
+			t.Fatalf("read past the end: %v", err)
+		}
+		for i, x := range xs[:3] {
+			if x.Buf[0] != byte(i+1) {
+				t.Errorf("read %d: %x", i, x.Buf[:4])
+			}
+		}
+		readWrite(t, tr, disk.Disk)
+	}
+}
+
+func TestQueueAbort(t *testing.T) {
+	disk := msctest.NewUAS(msctest.NewLUN(64, 512))
+	disk.OutOfOrder = true
+	tr, _, _ := open(t, disk, 0)
+	u := tr.(*uas.Transport)
+
+	// The status of the last command stalls: it is aborted with the two
+	// still running.
+	disk.StallStatus = 1
+	tur := scsi.Request{CB: []byte{scsi.OpTestUnitReady, 0, 0, 0, 0, 0}, Dir: usb.DirectionOut}
+	if _, err := u.Queue(0, []scsi.Request{tur, tur, tur}); !errors.Is(err, usb.ErrStall) {
+		t.Fatalf("stalled status: %v", err)
+	}
+	if len(disk.Tasks) != 3 {
+		t.Fatalf("tasks %+v", disk.Tasks)
+	}
+	for i, task := range disk.Tasks {
+		if task.Function != 0x01 || task.TaskTag != disk.Tasks[0].TaskTag+uint16(i) {
+			t.Errorf("task %d: %+v", i, task)
+		}
+	}
+	if _, err := u.Queue(0, make([]scsi.Request, uas.MaxStreams)); err == nil {
+		t.Error("queue deeper than the streams")
+	}
+	readWrite(t, tr, disk.Disk)
+}
+
+func TestQueueWithoutStreams(t *testing.T) {
+	disk := msctest.NewUAS(msctest.NewLUN(64, 512))
+	disk.HighSpeed = true
+	tr, _, _ := open(t, disk, 0)
+	dev := scsi.New(tr, 0)
+	if dev.QueueDepth() != 1 {
+		t.Fatalf("queue depth %d", dev.QueueDepth())
+	}
+	xs := []scsi.Transfer{{Write: true, LBA: 1, Buf: bytes.Repeat([]byte{1}, 512)}, {LBA: 1, Buf: make([]byte, 512)}}
+	if err := dev.Queue(xs); err != nil {
+		t.Fatal(err)
+	}
+	if xs[1].Buf[0] != 1 {
+		t.Error("read before the write")
+	}
+}
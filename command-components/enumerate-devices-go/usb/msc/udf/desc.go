@@ -0,0 +1,206 @@
+package udf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+// Tag identifiers of descriptors.
+const (
+	tagPrimaryVolume    = 1
+	tagAnchor           = 2
+	tagPointer          = 3
+	tagPartition        = 5
+	tagLogicalVolume    = 6
+	tagTerminating      = 8
+	tagFileSet          = 256
+	tagFileIdentifier   = 257
+	tagAllocationExtent = 258
+	tagFileEntry        = 261
+	tagExtendedEntry    = 266
+)
+
+// checkTag checks the descriptor tag at the start of b: its identifier,
+// checksum, the CRC of the descriptor and the location it was recorded at.
+func checkTag(b []byte, ident uint16, location uint32) error {
+	if err := checkTagData(b, ident); err != nil {
+		return err
+	}
+	if loc := binary.LittleEndian.Uint32(b[12:]); loc != location {
+		return fmt.Errorf("%w: descriptor of location %d at %d", ErrCorrupt, loc, location)
+	}
+	return nil
+}
+
+// checkTagData is checkTag without the location, for descriptors whose
+// location the caller does not know.
+func checkTagData(b []byte, ident uint16) error {
+	if len(b) < 16 {
+		return fmt.Errorf("%w: short descriptor", ErrCorrupt)
+	}
+	var sum byte
+	for i, c := range b[:16] {
+		if i != 4 {
+			sum += c
+		}
+	}
+	if sum != b[4] {
+		return fmt.Errorf("%w: descriptor tag checksum", ErrCorrupt)
+	}
+	if id := binary.LittleEndian.Uint16(b); id != ident {
+		return fmt.Errorf("%w: descriptor %d where %d belongs", ErrCorrupt, id, ident)
+	}
+	n := int(binary.LittleEndian.Uint16(b[10:]))
+	if 16+n > len(b) {
+		return fmt.Errorf("%w: descriptor CRC beyond its block", ErrCorrupt)
+	}
+	if crc := binary.LittleEndian.Uint16(b[8:]); crc != crc16(b[16:16+n]) {
+		return fmt.Errorf("%w: descriptor %d CRC", ErrCorrupt, ident)
+	}
+	return nil
+}
+
+// crc16 is the CRC of ITU-T V.41 that descriptors carry: x^16 + x^12 + x^5
+// + 1, from zero, most significant bit first.
+func crc16(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Kinds of extents, in the top two bits of the length of an allocation
+// descriptor.
+const (
+	extentRecorded     = 0
+	extentAllocated    = 1 // allocated but not recorded, reads as zeros
+	extentUnallocated  = 2
+	extentContinuation = 3 // the next allocation descriptors
+)
+
+// extent is a run of blocks of a file, from an allocation descriptor.
+type extent struct {
+	kind      uint8
+	length    uint32 // in bytes
+	lbn       uint32 // the first block, in the partition
+	partition uint16
+}
+
+// parseLongAD decodes an allocation descriptor of a block in any
+// partition.
+func parseLongAD(b []byte) extent {
+	length := binary.LittleEndian.Uint32(b)
+	return extent{
+		kind:      uint8(length >> 30),
+		length:    length & 0x3fffffff,
+		lbn:       binary.LittleEndian.Uint32(b[4:]),
+		partition: binary.LittleEndian.Uint16(b[8:]),
+	}
+}
+
+// parseShortAD decodes an allocation descriptor of a block in the
+// partition of the file entry.
+func parseShortAD(b []byte, partition uint16) extent {
+	length := binary.LittleEndian.Uint32(b)
+	return extent{
+		kind:      uint8(length >> 30),
+		length:    length & 0x3fffffff,
+		lbn:       binary.LittleEndian.Uint32(b[4:]),
+		partition: partition,
+	}
+}
+
+// offset returns the byte offset on the device of a block of a partition.
+func (f *FS) offset(partition uint16, lbn uint32) (int64, error) {
+	if int(partition) >= len(f.partitions) {
+		return 0, fmt.Errorf("%w: partition %d", ErrCorrupt, partition)
+	}
+	p := f.partitions[partition]
+	if lbn >= p.length {
+		return 0, fmt.Errorf("%w: block %d beyond partition %d", ErrCorrupt, lbn, partition)
+	}
+	return (int64(p.start) + int64(lbn)) * f.blockSize, nil
+}
+
+// readBlock reads the block at ad, which holds a descriptor of ident.
+func (f *FS) readBlock(ad extent, ident uint16) ([]byte, error) {
+	off, err := f.offset(ad.partition, ad.lbn)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, f.blockSize)
+	if _, err := f.dev.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	if err := checkTag(b, ident, ad.lbn); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// timestamp decodes a timestamp of ECMA-167. Local times are in the zone
+// they were recorded in; times without one are taken as UTC.
+func timestamp(b []byte) time.Time {
+	zone := binary.LittleEndian.Uint16(b)
+	year := int(int16(binary.LittleEndian.Uint16(b[2:])))
+	if year == 0 && b[4] == 0 {
+		return time.Time{}
+	}
+	offset := 0
+	if zone>>12 == 1 {
+		// Minutes from UTC, in 12 bits; -2047 leaves the zone unspecified.
+		if offset = int(zone & 0xfff); offset&0x800 != 0 {
+			offset -= 0x1000
+		}
+		if offset == -2047 {
+			offset = 0
+		}
+	}
+	ns := int(b[9])*10*int(time.Millisecond) + int(b[10])*100*int(time.Microsecond) + int(b[11])*int(time.Microsecond)
+	return time.Date(year, time.Month(b[4]), int(b[5]), int(b[6]), int(b[7]), int(b[8]), ns, time.FixedZone("", offset*60))
+}
+
+// cs0 decodes the OSTA Compressed Unicode of names: a compression
+// identifier, then Latin-1 for 8 or big-endian UTF-16 for 16.
+func cs0(b []byte) (string, error) {
+	if len(b) == 0 {
+		return "", nil
+	}
+	switch b[0] {
+	case 8:
+		r := make([]rune, len(b)-1)
+		for i, c := range b[1:] {
+			r[i] = rune(c)
+		}
+		return string(r), nil
+	case 16:
+		u := make([]uint16, (len(b)-1)/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(b[1+2*i:])
+		}
+		return string(utf16.Decode(u)), nil
+	}
+	return "", errors.New("unknown compression of a name")
+}
+
+// dstring decodes a fixed-length field of OSTA Compressed Unicode, whose
+// last byte is the length of the string.
+func dstring(b []byte) string {
+	n := int(b[len(b)-1])
+	if n >= len(b) {
+		return ""
+	}
+	s, _ := cs0(b[:n])
+	return s
+}
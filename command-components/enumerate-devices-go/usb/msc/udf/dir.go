@@ -0,0 +1,338 @@
+package udf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// File types of the ICB tag of a file entry.
+const (
+	typeDirectory = 4
+	typeFile      = 5
+	typeBlock     = 6
+	typeChar      = 7
+	typeFIFO      = 9
+	typeSocket    = 10
+	typeSymlink   = 12
+)
+
+// Kinds of allocation descriptors, in the flags of the ICB tag.
+const (
+	adShort  = 0
+	adLong   = 1
+	adInline = 3 // the data is in the file entry
+)
+
+// File characteristics of a file identifier descriptor.
+const (
+	fidDeleted = 0x04
+	fidParent  = 0x08
+)
+
+const (
+	// maxContinuations bounds the allocation extent descriptors of a file,
+	// against loops in corrupt file systems.
+	maxContinuations = 1024
+	// maxDirectory bounds the size of a directory, which is read in full.
+	maxDirectory = 16 << 20
+	// maxLink bounds the size of the target of a symbolic link.
+	maxLink = 4096
+)
+
+// dirent is a file or directory, from its file entry.
+type dirent struct {
+	name    string
+	mode    fs.FileMode
+	size    int64
+	mtime   time.Time
+	extents []extent
+	inline  []byte // the data, when the file entry holds it
+	link    string // the target of a symbolic link
+}
+
+func (e *dirent) isDir() bool {
+	return e.mode.IsDir()
+}
+
+// entry reads the file entry or extended file entry at icb.
+func (f *FS) entry(icb extent) (*dirent, error) {
+	off, err := f.offset(icb.partition, icb.lbn)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, f.blockSize)
+	if _, err := f.dev.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	ident := binary.LittleEndian.Uint16(b)
+	if ident != tagExtendedEntry {
+		ident = tagFileEntry
+	}
+	if err := checkTag(b, ident, icb.lbn); err != nil {
+		return nil, err
+	}
+	if strategy := binary.LittleEndian.Uint16(b[20:]); strategy != 4 {
+		return nil, fmt.Errorf("%w: ICB strategy %d", ErrUnsupported, strategy)
+	}
+	e := &dirent{
+		mode: unixMode(binary.LittleEndian.Uint32(b[44:])),
+		size: int64(binary.LittleEndian.Uint64(b[56:])),
+	}
+	var ads []byte
+	if ident == tagExtendedEntry {
+		e.mtime = timestamp(b[92:104])
+		ads, err = allocation(b, 216, binary.LittleEndian.Uint32(b[208:]), binary.LittleEndian.Uint32(b[212:]))
+	} else {
+		e.mtime = timestamp(b[84:96])
+		ads, err = allocation(b, 176, binary.LittleEndian.Uint32(b[168:]), binary.LittleEndian.Uint32(b[172:]))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch b[27] {
+	case typeDirectory:
+		e.mode |= fs.ModeDir
+	case typeFile:
+	case typeSymlink:
+		e.mode |= fs.ModeSymlink
+	case typeBlock:
+		e.mode |= fs.ModeDevice
+	case typeChar:
+		e.mode |= fs.ModeDevice | fs.ModeCharDevice
+	case typeFIFO:
+		e.mode |= fs.ModeNamedPipe
+	case typeSocket:
+		e.mode |= fs.ModeSocket
+	default:
+		e.mode |= fs.ModeIrregular
+	}
+
+	switch flags := binary.LittleEndian.Uint16(b[34:]); flags & 7 {
+	case adInline:
+		e.inline = ads
+	case adShort, adLong:
+		if e.extents, err = f.extents(ads, flags&7 == adLong, icb.partition); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: allocation descriptors of kind %d", ErrUnsupported, flags&7)
+	}
+
+	if e.mode&fs.ModeSymlink != 0 {
+		if e.size > maxLink {
+			return nil, fmt.Errorf("%w: symbolic link of %d bytes", ErrCorrupt, e.size)
+		}
+		b := make([]byte, e.size)
+		if _, err := f.readAt(e, b, 0); err != nil {
+			return nil, err
+		}
+		if e.link, err = pathComponents(b); err != nil {
+			return nil, fmt.Errorf("%w: symbolic link: %v", ErrCorrupt, err)
+		}
+	}
+	return e, nil
+}
+
+// allocation returns the allocation descriptors of a file entry, after its
+// extended attributes at base.
+func allocation(b []byte, base int, lea, lad uint32) ([]byte, error) {
+	if int64(lea)+int64(lad) > int64(len(b)-base) {
+		return nil, fmt.Errorf("%w: file entry beyond its block", ErrCorrupt)
+	}
+	start := base + int(lea)
+	return b[start : start+int(lad)], nil
+}
+
+// extents decodes allocation descriptors, following allocation extent
+// descriptors to the rest of them.
+func (f *FS) extents(ads []byte, long bool, partition uint16) ([]extent, error) {
+	size := 8
+	if long {
+		size = 16
+	}
+	var extents []extent
+	for hops := 0; ; {
+		var next *extent
+		for ; len(ads) >= size; ads = ads[size:] {
+			x := parseShortAD(ads, partition)
+			if long {
+				x = parseLongAD(ads)
+			}
+			if x.length == 0 {
+				break
+			}
+			if x.kind == extentContinuation {
+				next = &x
+				break
+			}
+			extents = append(extents, x)
+		}
+		if next == nil {
+			return extents, nil
+		}
+		if hops++; hops > maxContinuations {
+			return nil, fmt.Errorf("%w: too many allocation extents", ErrCorrupt)
+		}
+		b, err := f.readBlock(*next, tagAllocationExtent)
+		if err != nil {
+			return nil, err
+		}
+		lad := binary.LittleEndian.Uint32(b[20:])
+		if int64(lad) > int64(len(b)-24) {
+			return nil, fmt.Errorf("%w: allocation extent beyond its block", ErrCorrupt)
+		}
+		ads = b[24 : 24+lad]
+	}
+}
+
+// readAt reads the data of e at off, up to its size.
+func (f *FS) readAt(e *dirent, p []byte, off int64) (int, error) {
+	if rest := e.size - off; int64(len(p)) > rest {
+		p = p[:max(rest, 0)]
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if e.inline != nil {
+		if off+int64(len(p)) > int64(len(e.inline)) {
+			return 0, fmt.Errorf("%w: file beyond its data", ErrCorrupt)
+		}
+		return copy(p, e.inline[off:]), nil
+	}
+	n := 0
+	start := int64(0)
+	for _, x := range e.extents {
+		end := start + int64(x.length)
+		if pos := off + int64(n); n < len(p) && pos < end {
+			chunk := p[n : n+int(min(int64(len(p)-n), end-pos))]
+			if x.kind == extentRecorded {
+				base, err := f.offset(x.partition, x.lbn)
+				if err != nil {
+					return n, err
+				}
+				if _, err := f.dev.ReadAt(chunk, base+pos-start); err != nil {
+					return n, err
+				}
+			} else {
+				clear(chunk)
+			}
+			n += len(chunk)
+		}
+		start = end
+	}
+	if n < len(p) {
+		return n, fmt.Errorf("%w: file beyond its extents", ErrCorrupt)
+	}
+	return n, nil
+}
+
+// readDir returns the entries of a directory, without its parent and
+// deleted files.
+func (f *FS) readDir(dir *dirent) ([]*dirent, error) {
+	if dir.size > maxDirectory {
+		return nil, fmt.Errorf("%w: directory of %d bytes", ErrCorrupt, dir.size)
+	}
+	data := make([]byte, dir.size)
+	if _, err := f.readAt(dir, data, 0); err != nil {
+		return nil, err
+	}
+	var entries []*dirent
+	for len(data) > 0 {
+		if len(data) < 38 {
+			return nil, fmt.Errorf("%w: file identifier of %d bytes", ErrCorrupt, len(data))
+		}
+		lfi, liu := int(data[19]), int(binary.LittleEndian.Uint16(data[36:]))
+		n := (38 + liu + lfi + 3) &^ 3
+		if 38+liu+lfi > len(data) {
+			return nil, fmt.Errorf("%w: file identifier beyond its directory", ErrCorrupt)
+		}
+		fid := data[:38+liu+lfi]
+		if err := checkTagData(data[:min(n, len(data))], tagFileIdentifier); err != nil {
+			return nil, err
+		}
+		data = data[min(n, len(data)):]
+		if fid[18]&(fidParent|fidDeleted) != 0 {
+			continue
+		}
+		name, err := cs0(fid[38+liu:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		// Names that cannot be in a path, which no writer records, are left
+		// out.
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			continue
+		}
+		e, err := f.entry(parseLongAD(fid[20:36]))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", name, err)
+		}
+		e.name = name
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// pathComponents decodes the target of a symbolic link.
+func pathComponents(b []byte) (string, error) {
+	var elems []string
+	abs := false
+	for len(b) > 0 {
+		if len(b) < 4 || 4+int(b[1]) > len(b) {
+			return "", errors.New("short path component")
+		}
+		kind, ident := b[0], b[4:4+int(b[1])]
+		b = b[4+len(ident):]
+		switch kind {
+		case 1, 2: // the root
+			elems, abs = elems[:0], true
+		case 3:
+			elems = append(elems, "..")
+		case 4:
+			elems = append(elems, ".")
+		case 5:
+			name, err := cs0(ident)
+			if err != nil {
+				return "", err
+			}
+			elems = append(elems, name)
+		}
+	}
+	link := strings.Join(elems, "/")
+	if abs {
+		link = "/" + link
+	}
+	return link, nil
+}
+
+// unixMode converts the permissions of a file entry: execute, write, read,
+// change attributes and delete, for others, the group and the owner in
+// turn.
+func unixMode(p uint32) fs.FileMode {
+	return fs.FileMode(p>>10&7<<6 | p>>5&7<<3 | p&7)
+}
+
+func (e *dirent) info(name string) *fileInfo {
+	return &fileInfo{name: name, e: *e}
+}
+
+// fileInfo implements fs.FileInfo and fs.DirEntry.
+type fileInfo struct {
+	name string
+	e    dirent
+}
+
+func (i *fileInfo) Name() string               { return i.name }
+func (i *fileInfo) Size() int64                { return i.e.size }
+func (i *fileInfo) Mode() fs.FileMode          { return i.e.mode }
+func (i *fileInfo) ModTime() time.Time         { return i.e.mtime }
+func (i *fileInfo) IsDir() bool                { return i.e.isDir() }
+func (i *fileInfo) Sys() any                   { return nil }
+func (i *fileInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i *fileInfo) Info() (fs.FileInfo, error) { return i, nil }
+func (i *fileInfo) String() string             { return fs.FormatFileInfo(i) }
@@ -0,0 +1,339 @@
+// Package udf reads the Universal Disk Format file system of DVDs and of
+// discs and drives written with it, from a block device such as an
+// msc.BlockDevice over an mmc.Drive, or from an image file.
+//
+// FS implements fs.FS, fs.ReadDirFS and fs.StatFS. It reads the UDF of
+// revisions up to 2.01 on partitions of type 1, which is what DVD-ROM and
+// DVD video discs and most images use. Metadata partitions, which Blu-ray
+// discs use from revision 2.50, and the virtual partitions of discs written
+// incrementally are reported as ErrUnsupported.
+package udf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotUDF      = errors.New("udf: not a UDF file system")
+	ErrCorrupt     = errors.New("udf: file system is corrupt")
+	ErrUnsupported = errors.New("udf: unsupported file system")
+)
+
+// anchorSector is where the anchor volume descriptor pointer is.
+const anchorSector = 256
+
+// sectorSizes are the sizes of sectors Open looks for the anchor with:
+// that of optical discs first.
+var sectorSizes = []int64{2048, 512, 4096}
+
+// maxSequence bounds the number of volume descriptors read, against loops
+// in corrupt file systems.
+const maxSequence = 256
+
+// partition is a partition of the volume, by its place in the partition
+// maps of the logical volume.
+type partition struct {
+	start  uint32 // the first sector
+	length uint32 // in sectors
+}
+
+// FS is a UDF file system. It is read-only, and safe for concurrent use if
+// dev is.
+type FS struct {
+	dev        io.ReaderAt
+	blockSize  int64
+	partitions []partition
+	root       *dirent
+
+	label    string
+	revision uint16
+	recorded time.Time
+}
+
+var _ interface {
+	fs.ReadDirFS
+	fs.StatFS
+} = (*FS)(nil)
+
+// Open reads the volume descriptors and the file set descriptor of the file
+// system on dev.
+func Open(dev io.ReaderAt) (*FS, error) {
+	f := &FS{dev: dev}
+	var anchor []byte
+	for _, size := range sectorSizes {
+		b := make([]byte, size)
+		if _, err := dev.ReadAt(b, anchorSector*size); err != nil {
+			continue
+		}
+		if checkTag(b, tagAnchor, anchorSector) == nil {
+			f.blockSize, anchor = size, b
+			break
+		}
+	}
+	if anchor == nil {
+		return nil, ErrNotUDF
+	}
+
+	pvd, pds, lvd, err := f.volumeDescriptors(anchor)
+	if err != nil {
+		return nil, err
+	}
+	if pvd == nil || lvd == nil {
+		return nil, fmt.Errorf("%w: no primary or logical volume descriptor", ErrCorrupt)
+	}
+	if size := binary.LittleEndian.Uint32(lvd[212:]); int64(size) != f.blockSize {
+		return nil, fmt.Errorf("%w: logical blocks of %d bytes in sectors of %d", ErrUnsupported, size, f.blockSize)
+	}
+	f.label = dstring(lvd[84:212])
+	f.revision = binary.LittleEndian.Uint16(lvd[240:])
+	f.recorded = timestamp(pvd[376:388])
+
+	// The partition maps refer to the partitions by number.
+	maps := lvd[440:]
+	if n := binary.LittleEndian.Uint32(lvd[264:]); int(n) < len(maps) {
+		maps = maps[:n]
+	}
+	for i := binary.LittleEndian.Uint32(lvd[268:]); i > 0; i-- {
+		if len(maps) < 2 || maps[1] < 2 || int(maps[1]) > len(maps) {
+			return nil, fmt.Errorf("%w: partition map table", ErrCorrupt)
+		}
+		m := maps[:maps[1]]
+		maps = maps[maps[1]:]
+		if m[0] != 1 || len(m) < 6 {
+			ident := ""
+			if len(m) >= 36 {
+				ident = strings.TrimRight(string(m[5:28]), "\x00")
+			}
+			return nil, fmt.Errorf("%w: partition map of type %d %q", ErrUnsupported, m[0], ident)
+		}
+		pd, ok := pds[binary.LittleEndian.Uint16(m[4:])]
+		if !ok {
+			return nil, fmt.Errorf("%w: no descriptor for partition %d", ErrCorrupt, binary.LittleEndian.Uint16(m[4:]))
+		}
+		f.partitions = append(f.partitions, partition{
+			start:  binary.LittleEndian.Uint32(pd[188:]),
+			length: binary.LittleEndian.Uint32(pd[192:]),
+		})
+	}
+
+	fsd, err := f.readBlock(parseLongAD(lvd[248:264]), tagFileSet)
+	if err != nil {
+		return nil, fmt.Errorf("file set descriptor: %w", err)
+	}
+	if f.root, err = f.entry(parseLongAD(fsd[400:416])); err != nil {
+		return nil, fmt.Errorf("root directory: %w", err)
+	}
+	if !f.root.isDir() {
+		return nil, fmt.Errorf("%w: root is not a directory", ErrCorrupt)
+	}
+	return f, nil
+}
+
+// volumeDescriptors reads the main volume descriptor sequence the anchor
+// points to, and returns the primary volume descriptor, the partition
+// descriptors by partition number and the logical volume descriptor.
+func (f *FS) volumeDescriptors(anchor []byte) (pvd []byte, pds map[uint16][]byte, lvd []byte, err error) {
+	pds = make(map[uint16][]byte)
+	length, lba := binary.LittleEndian.Uint32(anchor[16:]), binary.LittleEndian.Uint32(anchor[20:])
+	for n := 0; n < maxSequence; n++ {
+		if int64(length) < f.blockSize {
+			return pvd, pds, lvd, nil
+		}
+		b := make([]byte, f.blockSize)
+		if _, err := f.dev.ReadAt(b, int64(lba)*f.blockSize); err != nil {
+			return nil, nil, nil, err
+		}
+		ident := binary.LittleEndian.Uint16(b)
+		if err := checkTag(b, ident, lba); err != nil {
+			return nil, nil, nil, fmt.Errorf("volume descriptor at sector %d: %w", lba, err)
+		}
+		length, lba = length-uint32(f.blockSize), lba+1
+		switch ident {
+		case tagPrimaryVolume:
+			if pvd == nil {
+				pvd = b
+			}
+		case tagPartition:
+			if num := binary.LittleEndian.Uint16(b[22:]); pds[num] == nil {
+				pds[num] = b
+			}
+		case tagLogicalVolume:
+			if lvd == nil {
+				lvd = b
+			}
+		case tagPointer:
+			length, lba = binary.LittleEndian.Uint32(b[20:]), binary.LittleEndian.Uint32(b[24:])
+		case tagTerminating:
+			return pvd, pds, lvd, nil
+		}
+	}
+	return nil, nil, nil, fmt.Errorf("%w: volume descriptor sequence too long", ErrCorrupt)
+}
+
+// Label returns the logical volume identifier.
+func (f *FS) Label() string {
+	return f.label
+}
+
+// Revision returns the revision of UDF the volume declares, in binary
+// coded decimal: 0x0201 for 2.01.
+func (f *FS) Revision() uint16 {
+	return f.revision
+}
+
+// Recorded returns the time the volume was recorded, from the primary
+// volume descriptor.
+func (f *FS) Recorded() time.Time {
+	return f.recorded
+}
+
+// BlockSize returns the size of the logical blocks of the volume.
+func (f *FS) BlockSize() int {
+	return int(f.blockSize)
+}
+
+// maxLinks is the number of symbolic links followed in one lookup.
+const maxLinks = 40
+
+// lookup returns the entry for name. Symbolic links are followed on the
+// way, and at the end if follow is set.
+func (f *FS) lookup(op, name string, follow bool) (*dirent, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	e, err := f.walk(name, follow, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return e, nil
+}
+
+func (f *FS) walk(name string, follow bool, links int) (*dirent, error) {
+	e := f.root
+	if name == "." {
+		return e, nil
+	}
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		if !e.isDir() {
+			return nil, fs.ErrNotExist
+		}
+		entries, err := f.readDir(e)
+		if err != nil {
+			return nil, err
+		}
+		if e = find(entries, elem); e == nil {
+			return nil, fs.ErrNotExist
+		}
+		if e.mode&fs.ModeSymlink == 0 || i == len(elems)-1 && !follow {
+			continue
+		}
+		if links++; links > maxLinks {
+			return nil, errors.New("too many levels of symbolic links")
+		}
+		// Absolute targets start at the root of the file set.
+		target := path.Join(path.Join(elems[:i]...), e.link)
+		if strings.HasPrefix(e.link, "/") {
+			target = path.Clean(e.link[1:])
+		}
+		if target == "" {
+			target = "."
+		}
+		if !fs.ValidPath(target) {
+			return nil, fs.ErrNotExist
+		}
+		rest := path.Join(elems[i+1:]...)
+		if rest != "" {
+			target = path.Join(target, rest)
+		}
+		return f.walk(target, follow, links)
+	}
+	return e, nil
+}
+
+// find returns the entry named name. Names in UDF are case sensitive.
+func find(entries []*dirent, name string) *dirent {
+	for _, e := range entries {
+		if e.name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// Open opens the named file or directory for reading.
+func (f *FS) Open(name string) (fs.File, error) {
+	e, err := f.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return &File{f: f, name: name, e: e}, nil
+}
+
+// Stat returns a FileInfo describing the named file, following symbolic
+// links.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	e, err := f.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return e.info(path.Base(name)), nil
+}
+
+// Lstat is Stat, but describes a symbolic link rather than its target.
+func (f *FS) Lstat(name string) (fs.FileInfo, error) {
+	e, err := f.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return e.info(path.Base(name)), nil
+}
+
+// ReadLink returns the target of the named symbolic link.
+func (f *FS) ReadLink(name string) (string, error) {
+	e, err := f.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if e.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return e.link, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := f.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !e.isDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries, err := f.dirEntries(e)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+func (f *FS) dirEntries(dir *dirent) ([]fs.DirEntry, error) {
+	d, err := f.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.DirEntry, len(d))
+	for i, e := range d {
+		entries[i] = e.info(e.name)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
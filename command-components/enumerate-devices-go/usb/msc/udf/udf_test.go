@@ -0,0 +1,157 @@
+package udf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// The images in testdata hold the same UDF 2.01 file system, in sectors of
+// 2048 bytes as on a DVD and of 512 bytes as on a hard disk. Its files
+// cover both kinds of file entry and every kind of allocation descriptor
+// but extended ones: readme.txt and the docs directory are in their file
+// entries, and big.bin has an extent that is not recorded and its last
+// extent in an allocation extent descriptor. gone.txt is deleted.
+func openImage(t *testing.T, name string) *FS {
+	t.Helper()
+	f, err := Open(bytes.NewReader(readImage(t, name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func readImage(t *testing.T, name string) []byte {
+	t.Helper()
+	src, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	zr, err := gzip.NewReader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func pattern(n, mul int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * mul % 251)
+	}
+	return b
+}
+
+func TestFS(t *testing.T) {
+	for _, tt := range []struct {
+		image     string
+		blockSize int
+	}{
+		{"udf.img.gz", 2048},
+		{"udf512.img.gz", 512},
+	} {
+		t.Run(tt.image, func(t *testing.T) {
+			f := openImage(t, tt.image)
+			if f.BlockSize() != tt.blockSize || f.Label() != "Optical Test" || f.Revision() != 0x0201 {
+				t.Errorf("block size %d, label %q, revision %#04x", f.BlockSize(), f.Label(), f.Revision())
+			}
+			if err := fstest.TestFS(f, "readme.txt", "Grüße.txt", "日本語.txt", "big.bin", "script.sh",
+				"docs/note.txt", "empty"); err != nil {
+				t.Fatal(err)
+			}
+
+			big := append(append(pattern(2*tt.blockSize, 11), make([]byte, tt.blockSize)...), pattern(1000, 13)...)
+			for name, want := range map[string][]byte{
+				"readme.txt": []byte("hello, udf\n"),
+				"Grüße.txt":  []byte("grüße\n"),
+				"日本語.txt":    pattern(3000, 3),
+				"big.bin":    big,
+				"abs":        []byte("note\n"),
+				"docs/up":    []byte("hello, udf\n"),
+			} {
+				if b, err := fs.ReadFile(f, name); err != nil || !bytes.Equal(b, want) {
+					t.Errorf("%s: %d bytes, %v", name, len(b), err)
+				}
+			}
+		})
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	f := openImage(t, "udf.img.gz")
+	zone := time.FixedZone("", 2*3600)
+	if want := time.Date(2024, 3, 14, 15, 9, 26, 0, zone); !f.Recorded().Equal(want) {
+		t.Errorf("recorded %v", f.Recorded())
+	}
+	for name, mode := range map[string]fs.FileMode{
+		"readme.txt": 0o644,
+		"script.sh":  0o755,
+		"docs":       fs.ModeDir | 0o755,
+	} {
+		info, err := f.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != mode || !info.ModTime().Equal(time.Date(2024, 3, 14, 13, 9, 26, 0, time.UTC)) {
+			t.Errorf("%s: %v", name, info)
+		}
+	}
+	if _, err := f.Stat("gone.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("deleted file: %v", err)
+	}
+	// Names are case sensitive.
+	if _, err := f.Stat("README.TXT"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("README.TXT: %v", err)
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	f := openImage(t, "udf.img.gz")
+	for name, target := range map[string]string{
+		"abs":     "/docs/note.txt",
+		"docs/up": "../readme.txt",
+	} {
+		if got, err := f.ReadLink(name); err != nil || got != target {
+			t.Errorf("%s: link to %q, %v", name, got, err)
+		}
+		if info, err := f.Lstat(name); err != nil || info.Mode() != fs.ModeSymlink|0o777 {
+			t.Errorf("%s: %v, %v", name, info, err)
+		}
+	}
+	if info, err := f.Stat("docs/up"); err != nil || !info.Mode().IsRegular() || info.Size() != 11 {
+		t.Errorf("docs/up target: %v, %v", info, err)
+	}
+	if _, err := f.ReadLink("readme.txt"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("ReadLink of a file: %v", err)
+	}
+}
+
+func TestOpenNotUDF(t *testing.T) {
+	if _, err := Open(bytes.NewReader(make([]byte, 300*2048))); !errors.Is(err, ErrNotUDF) {
+		t.Errorf("zeros: %v", err)
+	}
+	if _, err := Open(bytes.NewReader(nil)); !errors.Is(err, ErrNotUDF) {
+		t.Errorf("empty device: %v", err)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	b := readImage(t, "udf.img.gz")
+	// A flipped bit in the logical volume descriptor fails its CRC.
+	b[34*2048+100] ^= 1
+	if _, err := Open(bytes.NewReader(b)); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Open: %v", err)
+	}
+}
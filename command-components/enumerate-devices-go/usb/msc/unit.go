@@ -0,0 +1,189 @@
+package msc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"example.com/usb"
+	"example.com/usb/msc/scsi"
+)
+
+// ErrNoMedium is returned when opening a unit whose medium is not ready.
+var ErrNoMedium = errors.New("msc: medium not ready")
+
+// MediaState is the state of the medium of a unit, as TEST UNIT READY
+// reports it.
+type MediaState int
+
+const (
+	MediaReady    MediaState = iota
+	MediaAbsent              // no medium, such as an empty card slot
+	MediaNotReady            // present but not ready, such as spinning up
+)
+
+func (s MediaState) String() string {
+	switch s {
+	case MediaReady:
+		return "ready"
+	case MediaAbsent:
+		return "no medium"
+	case MediaNotReady:
+		return "not ready"
+	}
+	return fmt.Sprintf("MediaState(%d)", int(s))
+}
+
+// Unit is a logical unit of a mass storage device. Card readers have one
+// per slot. Each unit has its own medium, state and capacity.
+//
+// The units of a device share its transport, but Probe serializes their
+// commands, so that each unit can be used from its own goroutine.
+type Unit struct {
+	Device  *scsi.Device
+	Inquiry *scsi.InquiryData
+	// State and Capacity are updated by Refresh. While Watch runs, follow
+	// its events instead.
+	State    MediaState
+	Capacity scsi.Capacity // of the medium, if State is MediaReady
+
+	poll    *scsi.Device // for Refresh, so that it can run concurrently
+	mu      sync.Mutex   // guards the fields below, and State and Capacity
+	devices []*BlockDevice
+}
+
+// Probe sends INQUIRY and TEST UNIT READY to the logical units 0 to maxLUN
+// and returns those that are present, whether or not they hold a medium.
+// For a Bulk-Only device maxLUN is bot.Transport.MaxLUN.
+//
+// Units that fail INQUIRY, or report that no device is connected at their
+// LUN, are left out. Transport errors stop the probe.
+func Probe(t scsi.Transport, maxLUN uint8) ([]*Unit, error) {
+	t = &serialized{t: t}
+	var units []*Unit
+	for lun := range int(maxLUN) + 1 {
+		d := scsi.New(t, uint8(lun))
+		inq, err := d.Inquiry()
+		var se *scsi.SenseError
+		switch {
+		case errors.As(err, &se):
+			continue
+		case err != nil:
+			return nil, err
+		case inq.PeripheralQualifier != 0 || inq.DeviceType == scsi.DeviceTypeUnknown:
+			continue
+		}
+		u := &Unit{Device: d, Inquiry: inq, State: MediaAbsent, poll: scsi.New(t, uint8(lun))}
+		if err := u.Refresh(); err != nil {
+			return nil, err
+		}
+		units = append(units, u)
+	}
+	return units, nil
+}
+
+// serialized runs one command at a time on a transport.
+type serialized struct {
+	mu sync.Mutex
+	t  scsi.Transport
+}
+
+func (s *serialized) Command(lun uint8, cb []byte, dir usb.Direction, data []byte) (scsi.Completion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.Command(lun, cb, dir, data)
+}
+
+// Refresh sends TEST UNIT READY and updates State, and Capacity if the
+// medium is ready. The unit attention a unit reports after a reset or a
+// medium change is cleared on the way. If the medium was removed or
+// replaced, the block devices opened on it are invalidated.
+func (u *Unit) Refresh() error {
+	_, err := u.refresh()
+	return err
+}
+
+// refresh is Refresh, returning the events of the change.
+func (u *Unit) refresh() ([]Event, error) {
+	var err error
+	changed := false
+	for range 3 {
+		if err = u.poll.TestUnitReady(); !errors.Is(err, scsi.ErrUnitAttention) {
+			break
+		}
+		changed = changed || errors.Is(err, scsi.ErrMediumChanged)
+	}
+	var (
+		state    MediaState
+		capacity scsi.Capacity
+		se       *scsi.SenseError
+	)
+	switch {
+	case err == nil:
+		if capacity, err = u.poll.ReadCapacity(); err != nil {
+			return nil, err
+		}
+		state = MediaReady
+	case errors.Is(err, scsi.ErrMediumNotPresent):
+		state = MediaAbsent
+	case errors.As(err, &se):
+		state = MediaNotReady
+	default:
+		return nil, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var events []Event
+	ready := u.State == MediaReady
+	if ready && (state != MediaReady || changed || capacity != u.Capacity) {
+		events = append(events, Event{Unit: u, Type: MediaRemoved})
+		u.invalidate()
+		ready = false
+	}
+	if state == MediaReady && !ready {
+		events = append(events, Event{Unit: u, Type: MediaInserted, Capacity: capacity})
+	}
+	u.State, u.Capacity = state, capacity
+	return events, nil
+}
+
+// invalidate invalidates the block devices of the unit. u.mu is held.
+func (u *Unit) invalidate() {
+	for _, b := range u.devices {
+		b.Invalidate()
+	}
+	u.devices = nil
+}
+
+// Open returns a BlockDevice for the medium of the unit, which must be
+// ready. Call Refresh first to pick up a medium inserted since the probe.
+// The BlockDevice is invalidated when the medium is removed or replaced.
+func (u *Unit) Open(opts Options) (*BlockDevice, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.State != MediaReady {
+		return nil, fmt.Errorf("%w: LUN %d: %s", ErrNoMedium, u.Device.LUN(), u.State)
+	}
+	b, err := NewBlockDevice(u.Device, opts)
+	if err != nil {
+		return nil, err
+	}
+	u.devices = append(u.devices, b)
+	return b, nil
+}
+
+// Eject invalidates the block devices of the unit, without writing back
+// their dirty blocks, and ejects the medium even if it was locked with
+// PreventMediumRemoval. Sync the block devices first.
+func (u *Unit) Eject() error {
+	u.mu.Lock()
+	u.invalidate()
+	u.mu.Unlock()
+	return u.Device.Eject()
+}
+
+// String describes the unit, such as "LUN 1: Generic SD/MMC (ready)".
+func (u *Unit) String() string {
+	return fmt.Sprintf("LUN %d: %s %s (%s)", u.Device.LUN(), u.Inquiry.Vendor, u.Inquiry.Product, u.State)
+}
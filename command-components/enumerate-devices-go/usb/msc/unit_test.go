@@ -0,0 +1,151 @@
+package msc
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"example.com/usb"
+	"example.com/usb/msc/bot"
+	"example.com/usb/msc/msctest"
+	"example.com/usb/msc/scsi"
+)
+
+func probe(t *testing.T, disk *msctest.Disk) []*Unit {
+	t.Helper()
+	d := usb.NewDevice(disk)
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	intf, _ := bot.Find(d)
+	tr, err := bot.New(d, intf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	units, err := Probe(scsi.BulkOnly(tr), tr.MaxLUN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return units
+}
+
+func TestProbe(t *testing.T) {
+	sd := msctest.NewLUN(4096, 512)
+	sd.Product = "SD/MMC"
+	cf := msctest.NewLUN(1024, 512)
+	cf.Product, cf.NoMedium = "CF", true
+	ms := msctest.NewLUN(64, 2048)
+	ms.Product = "MS"
+	units := probe(t, msctest.NewMulti(sd, cf, ms))
+	if len(units) != 3 {
+		t.Fatalf("%d units", len(units))
+	}
+	want := []struct {
+		name     string
+		state    MediaState
+		capacity scsi.Capacity
+	}{
+		{"LUN 0: msctest SD/MMC (ready)", MediaReady, scsi.Capacity{Blocks: 4096, BlockSize: 512}},
+		{"LUN 1: msctest CF (no medium)", MediaAbsent, scsi.Capacity{}},
+		{"LUN 2: msctest MS (ready)", MediaReady, scsi.Capacity{Blocks: 64, BlockSize: 2048}},
+	}
+	for i, u := range units {
+		if u.String() != want[i].name || u.State != want[i].state || u.Capacity != want[i].capacity || !u.Inquiry.Removable {
+			t.Errorf("unit %d: %v, %+v", i, u, u.Capacity)
+		}
+	}
+	if _, err := units[1].Open(Options{}); !errors.Is(err, ErrNoMedium) {
+		t.Errorf("open without medium: %v", err)
+	}
+
+	// The media are independent block devices.
+	b0, err := units[0].Open(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := units[2].Open(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b0.BlockSize() != 512 || b2.BlockSize() != 2048 || b2.Size() != 64*2048 {
+		t.Errorf("block sizes %d and %d", b0.BlockSize(), b2.BlockSize())
+	}
+	b0.WriteAt([]byte("sd card"), 1000)
+	b2.WriteAt([]byte("memory stick"), 5000)
+	if err := errors.Join(b0.Sync(), b2.Sync()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sd.Data[1000:1007], []byte("sd card")) || !bytes.Equal(ms.Data[5000:5012], []byte("memory stick")) {
+		t.Error("data written to the wrong unit")
+	}
+
+	// A card inserted after the probe.
+	cf.NoMedium = false
+	if err := units[1].Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if units[1].State != MediaReady || units[1].Capacity.Blocks != 1024 {
+		t.Errorf("after insertion: %v", units[1])
+	}
+	if _, err := units[1].Open(Options{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProbeSingleLUN(t *testing.T) {
+	disk := msctest.New(16, 512)
+	disk.StallGetMaxLUN = true
+	if units := probe(t, disk); len(units) != 1 || units[0].Device.LUN() != 0 {
+		t.Errorf("units %v", units)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	lun := msctest.NewLUN(16, 512)
+	units := probe(t, msctest.NewMulti(lun))
+
+	// A unit attention, as after a reset, is cleared.
+	attention := 2
+	lun.Fault = func(cb []byte) msctest.Sense {
+		if attention > 0 {
+			attention--
+			return msctest.Sense{Key: 0x06, ASC: 0x29}
+		}
+		return msctest.Sense{}
+	}
+	if err := units[0].Refresh(); err != nil || units[0].State != MediaReady {
+		t.Errorf("after unit attention: %v, %v", units[0], err)
+	}
+
+	// A medium becoming ready.
+	lun.Fault = func(cb []byte) msctest.Sense {
+		return msctest.Sense{Key: 0x02, ASC: 0x04, ASCQ: 0x01}
+	}
+	if err := units[0].Refresh(); err != nil || units[0].State != MediaNotReady || units[0].Capacity.Blocks != 0 {
+		t.Errorf("becoming ready: %v, %v", units[0], err)
+	}
+	if _, err := units[0].Open(Options{}); !errors.Is(err, ErrNoMedium) {
+		t.Errorf("open while not ready: %v", err)
+	}
+}
+
+func TestEject(t *testing.T) {
+	lun := msctest.NewLUN(16, 512)
+	u := probe(t, msctest.NewMulti(lun))[0]
+	b, err := u.Open(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Device.PreventMediumRemoval(true); err != nil || !lun.Locked {
+		t.Fatalf("lock: %v", err)
+	}
+	if err := u.Eject(); err != nil || !lun.NoMedium {
+		t.Fatalf("eject: %v", err)
+	}
+	if _, err := b.ReadAt(make([]byte, 512), 0); !errors.Is(err, ErrMediumChanged) {
+		t.Errorf("read after eject: %v", err)
+	}
+	if err := u.Refresh(); err != nil || u.State != MediaAbsent {
+		t.Errorf("after eject: %v, %v", u, err)
+	}
+}
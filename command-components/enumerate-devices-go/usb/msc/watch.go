@@ -0,0 +1,77 @@
+package msc
+
+import (
+	"fmt"
+	"time"
+
+	"example.com/usb/msc/scsi"
+)
+
+// EventType is a change of the medium of a unit.
+type EventType int
+
+const (
+	MediaInserted EventType = iota
+	MediaRemoved
+)
+
+func (t EventType) String() string {
+	switch t {
+	case MediaInserted:
+		return "inserted"
+	case MediaRemoved:
+		return "removed"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// Event reports a medium inserted into or removed from a unit. A medium
+// replaced between two polls is reported as removed, then inserted.
+type Event struct {
+	Unit     *Unit
+	Type     EventType
+	Capacity scsi.Capacity // of the medium inserted
+	// Err is set on the last event, if polling failed. Type is not
+	// meaningful then.
+	Err error
+}
+
+// Watch polls the units with Refresh every interval, and sends an Event on
+// the returned channel for every medium inserted or removed. The block
+// devices of a medium removed are invalidated before its event is sent.
+//
+// Watch stops and closes the channel when done is closed, or after sending
+// an event with the error of a failed poll, such as when the device is
+// unplugged. The events must be received for polling to go on.
+func Watch(units []*Unit, interval time.Duration, done <-chan struct{}) <-chan Event {
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			for _, u := range units {
+				changes, err := u.refresh()
+				if err != nil {
+					changes = []Event{{Unit: u, Err: err}}
+				}
+				for _, e := range changes {
+					select {
+					case events <- e:
+					case <-done:
+						return
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return events
+}
@@ -0,0 +1,68 @@
+package msc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"example.com/usb/msc/msctest"
+)
+
+func TestWatch(t *testing.T) {
+	sd, cf := msctest.NewLUN(128, 512), msctest.NewLUN(64, 512)
+	cf.NoMedium = true
+	// The media change while a command runs, in the goroutine of Watch.
+	changes := make(chan func())
+	sd.Fault = func(cb []byte) msctest.Sense {
+		select {
+		case change := <-changes:
+			change()
+		default:
+		}
+		return msctest.Sense{}
+	}
+	cf.Fault = sd.Fault
+	units := probe(t, msctest.NewMulti(sd, cf))
+	b, err := units[0].Open(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	events := Watch(units, time.Millisecond, done)
+	next := func(change func()) Event {
+		t.Helper()
+		if change != nil {
+			changes <- change
+		}
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+		}
+		return Event{}
+	}
+
+	if e := next(func() { sd.NoMedium = true }); e.Unit != units[0] || e.Type != MediaRemoved || e.Err != nil {
+		t.Errorf("card pulled: %+v", e)
+	}
+	if _, err := b.ReadAt(make([]byte, 512), 0); !errors.Is(err, ErrMediumChanged) {
+		t.Errorf("read from the removed card: %v", err)
+	}
+	if e := next(func() { cf.Insert(256) }); e.Unit != units[1] || e.Type != MediaInserted || e.Capacity.Blocks != 256 {
+		t.Errorf("card inserted: %+v", e)
+	}
+	// A card swapped between two polls.
+	if e := next(func() { cf.Insert(512) }); e.Unit != units[1] || e.Type != MediaRemoved {
+		t.Errorf("card swapped: %+v", e)
+	}
+	if e := next(nil); e.Unit != units[1] || e.Type != MediaInserted || e.Capacity.Blocks != 512 {
+		t.Errorf("card swapped: %+v", e)
+	}
+
+	close(done)
+	for e := range events {
+		t.Errorf("event %+v after done", e)
+	}
+}
@@ -0,0 +1,238 @@
+package usb
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+)
+
+// LinkPowerState is a low power link state. The values follow the WIT
+// link-power-state enum.
+type LinkPowerState uint8
+
+const (
+	// LinkPowerUSB2LPM is the L1 sleep state of USB 2.0 Link Power Management.
+	LinkPowerUSB2LPM LinkPowerState = iota
+	// LinkPowerU1 is the U1 link state of USB 3.x.
+	LinkPowerU1
+	// LinkPowerU2 is the U2 link state of USB 3.x.
+	LinkPowerU2
+)
+
+func (s LinkPowerState) String() string {
+	switch s {
+	case LinkPowerUSB2LPM:
+		return "usb2-lpm"
+	case LinkPowerU1:
+		return "u1"
+	case LinkPowerU2:
+		return "u2"
+	}
+	return fmt.Sprintf("LinkPowerState(%d)", uint8(s))
+}
+
+// PowerHost is implemented by hosts that support power management.
+type PowerHost interface {
+	Suspend() error
+	Resume() error
+	Suspended() (bool, error)
+	LinkPowerStateSupported(state LinkPowerState) (bool, error)
+	LinkPowerStateEnabled(state LinkPowerState) (bool, error)
+	SetLinkPowerStateEnabled(state LinkPowerState, enabled bool) error
+}
+
+// powerTransport returns the chain of the device as a PowerHost. The
+// interceptors are all built with Around, which forwards power management.
+func (d *Device) powerTransport(op string) (PowerHost, error) {
+	if p, ok := d.transport.(PowerHost); ok {
+		return p, nil
+	}
+	return nil, &StateError{Op: op, Err: ErrNotSupported, Detail: "interceptors do not forward power management"}
+}
+
+// Suspend lets the host put the device in selective suspend. The host only
+// suspends devices that are not in use, and an open handle keeps the device
+// awake, so the device must be closed. Opening it wakes it up; it suspends
+// again once closed, until Resume is called.
+func (d *Device) Suspend() error {
+	p, err := d.powerTransport(OpSuspend)
+	if err != nil {
+		return err
+	}
+	return p.Suspend()
+}
+
+// Resume wakes up a suspended device and keeps it awake. Like Suspend, it
+// is only valid while the device is closed.
+func (d *Device) Resume() error {
+	p, err := d.powerTransport(OpResume)
+	if err != nil {
+		return err
+	}
+	return p.Resume()
+}
+
+// Suspended reports whether the device is suspended. Like the descriptor
+// queries, it goes to the host directly.
+func (d *Device) Suspended() (bool, error) {
+	p, ok := d.host.(PowerHost)
+	if !ok {
+		return false, &StateError{Op: "suspended", Err: ErrNotSupported, Detail: "host has no power management"}
+	}
+	return p.Suspended()
+}
+
+// SetRemoteWakeup allows or forbids the device to wake up the host from
+// suspend, using SET_FEATURE or CLEAR_FEATURE(DEVICE_REMOTE_WAKEUP). The
+// active configuration must support remote wakeup.
+func (d *Device) SetRemoteWakeup(enabled bool) error {
+	config, ok := d.ActiveConfiguration()
+	if !ok {
+		return stateError(OpWriteControl, ErrNotConfigured, "remote wakeup is set per configuration")
+	}
+	if !config.Descriptor.RemoteWakeup {
+		return stateError(OpWriteControl, ErrNotSupported, "configuration %d does not support remote wakeup", config.Descriptor.Number)
+	}
+	request := RequestClearFeature
+	if enabled {
+		request = RequestSetFeature
+	}
+	_, err := d.WriteControl(ControlSetup{
+		Type:      ControlStandard,
+		Recipient: RecipientDevice,
+		Request:   request,
+		Value:     FeatureDeviceRemoteWakeup,
+	}, nil)
+	return err
+}
+
+// RemoteWakeup reports whether remote wakeup is enabled, from the device's
+// GET_STATUS response.
+func (d *Device) RemoteWakeup() (bool, error) {
+	status, err := d.ReadControl(ControlSetup{
+		Type:      ControlStandard,
+		Recipient: RecipientDevice,
+		Request:   RequestGetStatus,
+	}, 2)
+	if err != nil {
+		return false, err
+	}
+	if len(status) < 1 {
+		return false, fmt.Errorf("usb: GET_STATUS returned %d bytes", len(status))
+	}
+	return status[0]&0x02 != 0, nil
+}
+
+// LinkPowerState reports whether a link power state is enabled. It fails
+// with ErrNotSupported if the host or the device does not support it.
+func (d *Device) LinkPowerState(state LinkPowerState) (bool, error) {
+	p, err := d.powerTransport(OpLinkPowerStateEnabled)
+	if err != nil {
+		return false, err
+	}
+	return p.LinkPowerStateEnabled(state)
+}
+
+// SetLinkPowerState enables or disables a link power state.
+func (d *Device) SetLinkPowerState(state LinkPowerState, enabled bool) error {
+	p, err := d.powerTransport(OpSetLinkPowerStateEnabled)
+	if err != nil {
+		return err
+	}
+	return p.SetLinkPowerStateEnabled(state, enabled)
+}
+
+// checkPower validates the power management calls: suspend and resume need
+// a closed device, the link power states an open one that supports them.
+func (d *Device) checkPower(c *Call) error {
+	p, ok := d.host.(PowerHost)
+	if !ok {
+		return &StateError{Op: c.Op, Err: ErrNotSupported, Detail: "host has no power management"}
+	}
+	if c.Op == OpSuspend || c.Op == OpResume {
+		if d.opened {
+			return &StateError{Op: c.Op, Err: ErrOpen, Detail: "an open handle keeps the device awake"}
+		}
+		return nil
+	}
+	if err := d.checkOpen(c.Op); err != nil {
+		return err
+	}
+	supported, err := p.LinkPowerStateSupported(c.State)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return stateError(c.Op, ErrNotSupported, "%s", c.State)
+	}
+	return nil
+}
+
+// PowerDraw is the power a device may draw from the bus in its active
+// configuration.
+type PowerDraw struct {
+	Location      Location
+	Descriptor    DeviceDescriptor
+	Configuration uint8  // 0 if the device is unconfigured
+	MaxPower      uint16 // in milliamps
+	SelfPowered   bool
+}
+
+// HubBudget is the power drawn by the devices connected to one hub.
+type HubBudget struct {
+	Hub     Location
+	Devices []PowerDraw
+	Total   int // sum of MaxPower, in milliamps
+}
+
+// PowerBudget groups devices by the hub they are connected to and sums the
+// max-power of their active configurations. Devices whose host does not
+// implement Locator are reported under the zero Location.
+func PowerBudget(devices ...*Device) []HubBudget {
+	var hubs []HubBudget
+	for _, d := range devices {
+		location, _ := d.Location()
+		draw := PowerDraw{Location: location, Descriptor: d.Descriptor()}
+		if config, ok := d.ActiveConfiguration(); ok {
+			draw.Configuration = config.Descriptor.Number
+			draw.MaxPower = config.Descriptor.MaxPower
+			draw.SelfPowered = config.Descriptor.SelfPowered
+		}
+		hub := location.Hub()
+		i := slices.IndexFunc(hubs, func(h HubBudget) bool { return h.Hub.Equal(hub) })
+		if i < 0 {
+			hubs = append(hubs, HubBudget{Hub: hub})
+			i = len(hubs) - 1
+		}
+		hubs[i].Devices = append(hubs[i].Devices, draw)
+		hubs[i].Total += int(draw.MaxPower)
+	}
+	for _, h := range hubs {
+		slices.SortFunc(h.Devices, func(a, b PowerDraw) int { return compareLocations(a.Location, b.Location) })
+	}
+	slices.SortFunc(hubs, func(a, b HubBudget) int { return compareLocations(a.Hub, b.Hub) })
+	return hubs
+}
+
+func compareLocations(a, b Location) int {
+	return cmp.Or(cmp.Compare(a.Bus, b.Bus), slices.Compare(a.Ports, b.Ports))
+}
+
+// WritePowerBudget writes a human-readable power budget report.
+func WritePowerBudget(w io.Writer, hubs []HubBudget) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, h := range hubs {
+		fmt.Fprintf(tw, "hub %s\t\t\t\t%d mA\n", h.Hub, h.Total)
+		for _, d := range h.Devices {
+			power := "bus"
+			if d.SelfPowered {
+				power = "self"
+			}
+			fmt.Fprintf(tw, "  %s\t%04x:%04x\tconfig %d\t%s-powered\t%d mA\n",
+				d.Location, d.Descriptor.VendorID, d.Descriptor.ProductID, d.Configuration, power, d.MaxPower)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,256 @@
+package usb
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// powerHost adds location and power management to a fakeHost.
+type powerHost struct {
+	*fakeHost
+	location  Location
+	suspended bool
+	supported map[LinkPowerState]bool
+	enabled   map[LinkPowerState]bool
+}
+
+func newPowerHost(bus uint8, ports ...uint8) *powerHost {
+	return &powerHost{
+		fakeHost:  newFakeHost(),
+		location:  Location{Bus: bus, Ports: ports},
+		supported: map[LinkPowerState]bool{LinkPowerU1: true, LinkPowerU2: true},
+		enabled:   map[LinkPowerState]bool{LinkPowerU1: true},
+	}
+}
+
+func (h *powerHost) Location() Location { return h.location }
+
+func (h *powerHost) Suspend() error {
+	h.suspended = true
+	return h.record("suspend")
+}
+
+func (h *powerHost) Resume() error {
+	h.suspended = false
+	return h.record("resume")
+}
+
+// Suspended follows the kernel, which never suspends a device with an open
+// handle.
+func (h *powerHost) Suspended() (bool, error) { return h.suspended && !h.opened, nil }
+
+func (h *powerHost) LinkPowerStateSupported(s LinkPowerState) (bool, error) {
+	return h.supported[s], nil
+}
+
+func (h *powerHost) LinkPowerStateEnabled(s LinkPowerState) (bool, error) {
+	return h.enabled[s], nil
+}
+
+func (h *powerHost) SetLinkPowerStateEnabled(s LinkPowerState, enabled bool) error {
+	h.enabled[s] = enabled
+	return h.record("set-link-power-state-enabled(%s,%v)", s, enabled)
+}
+
+func TestSuspendResume(t *testing.T) {
+	host := newPowerHost(1, 2)
+	d := NewDevice(host)
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Suspend(); !errors.Is(err, ErrOpen) {
+		t.Fatalf("suspend while open: %v", err)
+	}
+	d.Close()
+	if err := d.Suspend(); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := d.Suspended(); !s {
+		t.Fatal("not suspended")
+	}
+	// An open handle wakes the device up until it is closed again.
+	d.Open()
+	if s, _ := d.Suspended(); s {
+		t.Fatal("suspended while open")
+	}
+	if err := d.Resume(); !errors.Is(err, ErrOpen) {
+		t.Fatalf("resume while open: %v", err)
+	}
+	d.Close()
+	if s, _ := d.Suspended(); !s {
+		t.Fatal("not suspended after close")
+	}
+	if err := d.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := d.Suspended(); s {
+		t.Fatal("still suspended")
+	}
+
+	if got := host.Calls(); got != "open close suspend open close resume" {
+		t.Fatalf("host calls %q", got)
+	}
+
+	plain := NewDevice(newFakeHost())
+	if err := plain.Suspend(); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("suspend without power management: %v", err)
+	}
+}
+
+func TestRemoteWakeup(t *testing.T) {
+	host := newFakeHost()
+	host.configs[0].Descriptor.RemoteWakeup = true
+	host.read = func(endpoint uint8, length int) []byte { return []byte{0x02, 0x00} }
+	d := NewDevice(host)
+	d.Open()
+
+	if err := d.SetRemoteWakeup(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetRemoteWakeup(false); err != nil {
+		t.Fatal(err)
+	}
+	if on, err := d.RemoteWakeup(); err != nil || !on {
+		t.Fatalf("remote wakeup = %v, %v", on, err)
+	}
+	if got := host.Calls(); got != "open write-control(0x03) write-control(0x01) read-control(0x00)" {
+		t.Fatalf("host calls %q", got)
+	}
+
+	d.SelectConfiguration(2)
+	if err := d.SetRemoteWakeup(true); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("configuration without remote wakeup: %v", err)
+	}
+}
+
+func TestLinkPowerState(t *testing.T) {
+	host := newPowerHost(1, 2)
+	d := NewDevice(host)
+	if _, err := d.LinkPowerState(LinkPowerU1); !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("query before open: %v", err)
+	}
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if on, err := d.LinkPowerState(LinkPowerU1); err != nil || !on {
+		t.Fatalf("u1 = %v, %v", on, err)
+	}
+	if err := d.SetLinkPowerState(LinkPowerU2, true); err != nil {
+		t.Fatal(err)
+	}
+	if on, _ := d.LinkPowerState(LinkPowerU2); !on {
+		t.Fatal("u2 not enabled")
+	}
+	if _, err := d.LinkPowerState(LinkPowerUSB2LPM); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("usb2 lpm: %v", err)
+	}
+	if err := d.SetLinkPowerState(LinkPowerUSB2LPM, true); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("set usb2 lpm: %v", err)
+	}
+	if got := host.Calls(); got != "open set-link-power-state-enabled(u2,true)" {
+		t.Fatalf("host calls %q", got)
+	}
+}
+
+func TestPowerIntercepted(t *testing.T) {
+	var buf bytes.Buffer
+	var log []string
+	host := newPowerHost(1, 2)
+	d := NewDevice(host, recorder("driver", &log))
+
+	d.SetSafety(SafetyOptions{Mode: SafetyReadOnly})
+	if err := d.Suspend(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("read-only suspend: %v", err)
+	}
+	if err := d.Resume(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("read-only resume: %v", err)
+	}
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetLinkPowerState(LinkPowerU2, true); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("read-only set-link-power-state-enabled: %v", err)
+	}
+	if on, err := d.LinkPowerState(LinkPowerU1); err != nil || !on {
+		t.Fatalf("read-only query: %v, %v", on, err)
+	}
+
+	d.SetSafety(SafetyOptions{Mode: SafetyDryRun, Logger: testLogger(&buf, slog.LevelInfo)})
+	if err := d.SetLinkPowerState(LinkPowerU2, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Suspend(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`level=INFO msg="usb dry-run set-link-power-state-enabled" device.id=1234:5678 device.serial=0001 op=set-link-power-state-enabled state=u2 enabled=true`,
+		`level=INFO msg="usb dry-run suspend" device.id=1234:5678 device.serial=0001 op=suspend`,
+	}
+	if got := lines(&buf); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("dry-run log:\n%s", buf.String())
+	}
+	if host.suspended || host.enabled[LinkPowerU2] {
+		t.Fatal("dry-run changed the power state")
+	}
+
+	if got := host.Calls(); got != "open close" {
+		t.Fatalf("host calls %q", got)
+	}
+	if got := strings.Join(log, " "); got != "driver:suspend driver:resume driver:open driver:set-link-power-state-enabled driver:link-power-state-enabled driver:set-link-power-state-enabled driver:close driver:suspend" {
+		t.Fatalf("interceptor calls %q", got)
+	}
+}
+
+func TestPowerBudget(t *testing.T) {
+	a := newPowerHost(1, 2, 1)
+	b := newPowerHost(1, 2, 3)
+	b.active = 2
+	b.configs[1].Descriptor.SelfPowered = true
+	c := newPowerHost(1, 1)
+	unconfigured := newPowerHost(2, 4)
+	unconfigured.active = 0
+
+	hubs := PowerBudget(NewDevice(b), NewDevice(c), NewDevice(a), NewDevice(unconfigured))
+	if len(hubs) != 3 {
+		t.Fatalf("got %d hubs", len(hubs))
+	}
+	want := []struct {
+		hub     string
+		devices []string
+		total   int
+	}{
+		{"1", []string{"1-1"}, 100},
+		{"1-2", []string{"1-2.1", "1-2.3"}, 600},
+		{"2", []string{"2-4"}, 0},
+	}
+	for i, w := range want {
+		h := hubs[i]
+		var devices []string
+		for _, d := range h.Devices {
+			devices = append(devices, d.Location.String())
+		}
+		if h.Hub.String() != w.hub || strings.Join(devices, " ") != strings.Join(w.devices, " ") || h.Total != w.total {
+			t.Errorf("hub %d: %s %v %d mA, want %s %v %d mA", i, h.Hub, devices, h.Total, w.hub, w.devices, w.total)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WritePowerBudget(&buf, hubs); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"hub 1-2",
+		"1-2.3  1234:5678  config 2  self-powered  500 mA",
+		"2-4    1234:5678  config 0  bus-powered   0 mA",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("report is missing %q:\n%s", line, buf.String())
+		}
+	}
+}
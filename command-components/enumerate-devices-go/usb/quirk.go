@@ -0,0 +1,384 @@
+package usb
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Quirk is a set of deviations from the USB specification that the Device
+// and the class drivers work around.
+type Quirk uint32
+
+const (
+	// QuirkNoStrings makes ReadString and Languages fail without asking the
+	// device, for devices that stall on string descriptor requests.
+	QuirkNoStrings Quirk = 1 << iota
+	// QuirkResetAfterSetConfiguration resets the device after every
+	// successful SelectConfiguration.
+	QuirkResetAfterSetConfiguration
+	// QuirkNoZeroLengthPacket completes zero-length OUT transfers without
+	// sending them.
+	QuirkNoZeroLengthPacket
+	// QuirkPadToMaxPacket pads bulk and interrupt OUT transfers with zeros
+	// to a multiple of the endpoint's max packet size. The padding is not
+	// counted in the number of bytes written.
+	QuirkPadToMaxPacket
+	// QuirkSingleLUN tells mass storage drivers to only use LUN 0.
+	QuirkSingleLUN
+	// QuirkIgnoreResidue tells mass storage drivers to ignore the data
+	// residue the device reports.
+	QuirkIgnoreResidue
+	// QuirkIgnoreUAS tells mass storage drivers to use the Bulk-Only
+	// interface of a device whose UAS implementation is broken.
+	QuirkIgnoreUAS
+)
+
+type quirkName struct {
+	quirk Quirk
+	name  string
+}
+
+var quirkNames = []quirkName{
+	{QuirkNoStrings, "no-strings"},
+	{QuirkResetAfterSetConfiguration, "reset-after-set-configuration"},
+	{QuirkNoZeroLengthPacket, "no-zlp"},
+	{QuirkPadToMaxPacket, "pad-to-max-packet"},
+	{QuirkSingleLUN, "single-lun"},
+	{QuirkIgnoreResidue, "ignore-residue"},
+	{QuirkIgnoreUAS, "ignore-uas"},
+}
+
+// Has reports whether all quirks in flags are set.
+func (q Quirk) Has(flags Quirk) bool {
+	return q&flags == flags
+}
+
+// String returns the comma-separated names of the quirks, as in a quirks
+// file, or "-" if there are none.
+func (q Quirk) String() string {
+	var names []string
+	for _, n := range quirkNames {
+		if q.Has(n.quirk) {
+			names = append(names, n.name)
+			q &^= n.quirk
+		}
+	}
+	if q != 0 {
+		names = append(names, fmt.Sprintf("Quirk(%#x)", uint32(q)))
+	}
+	if len(names) == 0 {
+		return "-"
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseQuirk parses a comma-separated list of quirk names. "-" and the
+// empty string are the empty set.
+func ParseQuirk(s string) (Quirk, error) {
+	var q Quirk
+	if s == "-" || s == "" {
+		return 0, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		i := slices.IndexFunc(quirkNames, func(n quirkName) bool { return n.name == name })
+		if i < 0 {
+			return 0, fmt.Errorf("usb: unknown quirk %q", name)
+		}
+		q |= quirkNames[i].quirk
+	}
+	return q, nil
+}
+
+// QuirkEntry holds the quirks of one device, or of all products of a vendor.
+type QuirkEntry struct {
+	VendorID   uint16
+	ProductID  uint16
+	AnyProduct bool // matches every product of VendorID
+
+	Quirks Quirk
+	// MaxPacketSize overrides the wMaxPacketSize of endpoints, by endpoint
+	// address. Address 0x00 stands for the default endpoint.
+	MaxPacketSize map[uint8]uint16
+}
+
+// Has reports whether the entry has all quirks in flags.
+func (e QuirkEntry) Has(flags Quirk) bool {
+	return e.Quirks.Has(flags)
+}
+
+func (e QuirkEntry) String() string {
+	id := fmt.Sprintf("%04x:%04x", e.VendorID, e.ProductID)
+	if e.AnyProduct {
+		id = fmt.Sprintf("%04x:*", e.VendorID)
+	}
+	fields := []string{id, e.Quirks.String()}
+	addrs := make([]uint8, 0, len(e.MaxPacketSize))
+	for addr := range e.MaxPacketSize {
+		addrs = append(addrs, addr)
+	}
+	slices.Sort(addrs)
+	for _, addr := range addrs {
+		ep := fmt.Sprintf("0x%02x", addr)
+		if addr == 0 {
+			ep = "ep0"
+		}
+		fields = append(fields, fmt.Sprintf("%s=%d", ep, e.MaxPacketSize[addr]))
+	}
+	return strings.Join(fields, " ")
+}
+
+func (e QuirkEntry) sameDevice(o QuirkEntry) bool {
+	return e.VendorID == o.VendorID && e.AnyProduct == o.AnyProduct && (e.AnyProduct || e.ProductID == o.ProductID)
+}
+
+// QuirkDB maps devices to their quirks. The zero value is empty and ready
+// to use.
+type QuirkDB struct {
+	entries []QuirkEntry
+}
+
+//go:embed quirks.txt
+var builtinQuirks string
+
+// DefaultQuirks is consulted by NewDevice. It starts out with the built-in
+// quirks; replace it with the result of LoadQuirks to add local ones.
+var DefaultQuirks = mustParseQuirks(builtinQuirks)
+
+func mustParseQuirks(s string) *QuirkDB {
+	db, err := ParseQuirks(strings.NewReader(s))
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// BuiltinQuirks returns a copy of the built-in quirks.
+func BuiltinQuirks() *QuirkDB {
+	return mustParseQuirks(builtinQuirks)
+}
+
+// LoadQuirks reads a quirks file and returns the built-in quirks overridden
+// by it. The file format is documented in quirks.txt.
+func LoadQuirks(path string) (*QuirkDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	local, err := ParseQuirks(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	db := BuiltinQuirks()
+	db.Merge(local)
+	return db, nil
+}
+
+// ParseQuirks reads quirks in the quirks file format.
+func ParseQuirks(r io.Reader) (*QuirkDB, error) {
+	db := &QuirkDB{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		e, err := parseQuirkEntry(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		db.Add(e)
+	}
+	return db, scanner.Err()
+}
+
+func parseQuirkEntry(fields []string) (QuirkEntry, error) {
+	var e QuirkEntry
+	if len(fields) < 2 {
+		return e, fmt.Errorf("usb: want VID:PID QUIRKS, got %q", strings.Join(fields, " "))
+	}
+	vid, pid, ok := strings.Cut(fields[0], ":")
+	if !ok {
+		return e, fmt.Errorf("usb: malformed device ID %q", fields[0])
+	}
+	v, err := strconv.ParseUint(vid, 16, 16)
+	if err != nil {
+		return e, fmt.Errorf("usb: malformed vendor ID %q", vid)
+	}
+	e.VendorID = uint16(v)
+	if pid == "*" {
+		e.AnyProduct = true
+	} else {
+		p, err := strconv.ParseUint(pid, 16, 16)
+		if err != nil {
+			return e, fmt.Errorf("usb: malformed product ID %q", pid)
+		}
+		e.ProductID = uint16(p)
+	}
+	if e.Quirks, err = ParseQuirk(fields[1]); err != nil {
+		return e, err
+	}
+	for _, f := range fields[2:] {
+		ep, size, ok := strings.Cut(f, "=")
+		if !ok {
+			return e, fmt.Errorf("usb: want ENDPOINT=MAX-PACKET-SIZE, got %q", f)
+		}
+		var addr uint64
+		if ep != "ep0" {
+			if addr, err = strconv.ParseUint(ep, 0, 8); err != nil {
+				return e, fmt.Errorf("usb: malformed endpoint address %q", ep)
+			}
+		}
+		n, err := strconv.ParseUint(size, 0, 16)
+		if err != nil {
+			return e, fmt.Errorf("usb: malformed max packet size %q", size)
+		}
+		if e.MaxPacketSize == nil {
+			e.MaxPacketSize = make(map[uint8]uint16)
+		}
+		e.MaxPacketSize[uint8(addr)] = uint16(n)
+	}
+	return e, nil
+}
+
+// Add adds an entry, replacing the one for the same VID:PID or VID:*.
+func (db *QuirkDB) Add(e QuirkEntry) {
+	i := slices.IndexFunc(db.entries, e.sameDevice)
+	if i < 0 {
+		db.entries = append(db.entries, e)
+	} else {
+		db.entries[i] = e
+	}
+}
+
+// Merge adds all entries of other, which take precedence over the ones
+// already in db.
+func (db *QuirkDB) Merge(other *QuirkDB) {
+	for _, e := range other.entries {
+		db.Add(e)
+	}
+}
+
+// Entries returns the entries in the order they were added.
+func (db *QuirkDB) Entries() []QuirkEntry {
+	return slices.Clone(db.entries)
+}
+
+// Lookup returns the entry for a device: the VID:PID entry if there is
+// one, else the VID:* entry. If there is neither, the entry has no quirks.
+func (db *QuirkDB) Lookup(vendorID, productID uint16) QuirkEntry {
+	found := QuirkEntry{VendorID: vendorID, ProductID: productID}
+	for _, e := range db.entries {
+		if e.VendorID != vendorID {
+			continue
+		}
+		if !e.AnyProduct && e.ProductID == productID {
+			return e
+		}
+		if e.AnyProduct {
+			found = e
+		}
+	}
+	return found
+}
+
+// WriteTo writes the database in the quirks file format.
+func (db *QuirkDB) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, e := range db.entries {
+		m, err := fmt.Fprintln(w, e)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Quirks returns the quirks the device is handled with.
+func (d *Device) Quirks() QuirkEntry {
+	return d.quirks
+}
+
+// SetQuirks replaces the quirks found in DefaultQuirks by NewDevice. The
+// descriptors are read from the host again and corrected with the new
+// max packet sizes. The quirks carry over to the device returned by
+// ResetAndReacquire.
+func (d *Device) SetQuirks(q QuirkEntry) {
+	d.quirks = q
+	d.quirksSet = true
+	d.descriptor = d.host.Descriptor()
+	d.configs = d.host.Configurations()
+	d.correctDescriptors()
+	d.rebuild()
+}
+
+// correctDescriptors applies the max packet size corrections of the quirks
+// to copies of the descriptors.
+func (d *Device) correctDescriptors() {
+	if len(d.quirks.MaxPacketSize) == 0 {
+		return
+	}
+	if size, ok := d.quirks.MaxPacketSize[0]; ok {
+		d.descriptor.MaxPacketSize = uint8(size)
+	}
+	configs := slices.Clone(d.configs)
+	for i := range configs {
+		configs[i].Interfaces = slices.Clone(configs[i].Interfaces)
+		for j := range configs[i].Interfaces {
+			intf := &configs[i].Interfaces[j]
+			intf.Endpoints = slices.Clone(intf.Endpoints)
+			for k := range intf.Endpoints {
+				if size, ok := d.quirks.MaxPacketSize[intf.Endpoints[k].Address()]; ok {
+					intf.Endpoints[k].MaxPacketSize = size
+				}
+			}
+		}
+	}
+	d.configs = configs
+}
+
+// quirk is the innermost interceptor of every Device. It works around the
+// device's quirks that change what is sent to the host.
+func (d *Device) quirk(next Transport) Transport {
+	return Around(func(c *Call, invoke func() error) error {
+		q := d.quirks.Quirks
+		switch c.Op {
+		case OpSelectConfiguration:
+			if err := invoke(); err != nil || !q.Has(QuirkResetAfterSetConfiguration) {
+				return err
+			}
+			return next.Reset()
+		case OpWriteBulk, OpWriteInterrupt, OpWriteIsochronous:
+			if len(c.Data) == 0 && q.Has(QuirkNoZeroLengthPacket) {
+				c.Actual = 0
+				return nil
+			}
+			if c.Op != OpWriteIsochronous && q.Has(QuirkPadToMaxPacket) {
+				return d.padded(c, invoke)
+			}
+		}
+		return invoke()
+	})(next)
+}
+
+func (d *Device) padded(c *Call, invoke func() error) error {
+	ep, ok := d.findEndpoint(c.Endpoint)
+	size := int(ep.MaxPacketSize)
+	if !ok || size == 0 || len(c.Data)%size == 0 {
+		return invoke()
+	}
+	n := len(c.Data)
+	c.Data = append(c.Data[:n:n], make([]byte, size-n%size)...)
+	c.Length = len(c.Data)
+	err := invoke()
+	c.Actual = min(c.Actual, n)
+	return err
+}
@@ -0,0 +1,227 @@
+package usb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testQuirks = `
+# comment
+1234:5678  no-zlp,pad-to-max-packet   # trailing comment
+1234:*     no-strings
+abcd:0001  -  ep0=8 0x81=64
+`
+
+func TestParseQuirks(t *testing.T) {
+	db, err := ParseQuirks(strings.NewReader(testQuirks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	db.WriteTo(&buf)
+	want := "1234:5678 no-zlp,pad-to-max-packet\n1234:* no-strings\nabcd:0001 - ep0=8 0x81=64\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	for _, bad := range []string{"1234", "1234:5678 bogus", "zz:0001 -", "1234:5678 - 0x81", "1234:5678 - 0x181=8"} {
+		if _, err := ParseQuirks(strings.NewReader("\n" + bad)); err == nil || !strings.HasPrefix(err.Error(), "line 2: ") {
+			t.Errorf("%q: err = %v", bad, err)
+		}
+	}
+}
+
+func TestQuirkLookup(t *testing.T) {
+	db, _ := ParseQuirks(strings.NewReader(testQuirks))
+	tests := []struct {
+		vid, pid uint16
+		want     Quirk
+	}{
+		{0x1234, 0x5678, QuirkNoZeroLengthPacket | QuirkPadToMaxPacket},
+		{0x1234, 0x0001, QuirkNoStrings},
+		{0xabcd, 0x0001, 0},
+		{0xabcd, 0x0002, 0},
+	}
+	for _, tt := range tests {
+		if got := db.Lookup(tt.vid, tt.pid).Quirks; got != tt.want {
+			t.Errorf("%04x:%04x: %v, want %v", tt.vid, tt.pid, got, tt.want)
+		}
+	}
+	if got := db.Lookup(0xabcd, 0x0001).MaxPacketSize[0x81]; got != 64 {
+		t.Errorf("max packet size 0x81 = %d", got)
+	}
+}
+
+func TestBuiltinQuirks(t *testing.T) {
+	db := BuiltinQuirks()
+	for _, tt := range []struct {
+		vid, pid uint16
+		want     Quirk
+	}{
+		{0x054c, 0x002d, QuirkSingleLUN},
+		{0x0421, 0x0446, QuirkIgnoreResidue},
+		{0x2537, 0x1068, QuirkIgnoreUAS},
+		{0x04b4, 0x0526, QuirkNoStrings},
+		{0x054c, 0x0001, 0},
+	} {
+		if got := db.Lookup(tt.vid, tt.pid).Quirks; got != tt.want {
+			t.Errorf("%04x:%04x: %v, want %v", tt.vid, tt.pid, got, tt.want)
+		}
+	}
+}
+
+func TestLoadQuirksOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quirks")
+	os.WriteFile(path, []byte("1234:5678 single-lun,ignore-residue\n1234:5678 no-strings\n"), 0o644)
+	db, err := LoadQuirks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := db.Lookup(0x1234, 0x5678).Quirks; got != QuirkNoStrings {
+		t.Errorf("later line does not replace earlier one: %v", got)
+	}
+	if len(db.Entries()) != len(BuiltinQuirks().Entries())+1 {
+		t.Errorf("%d entries", len(db.Entries()))
+	}
+
+	os.WriteFile(path, []byte("1234:5678 no-such-quirk\n"), 0o644)
+	if _, err := LoadQuirks(path); err == nil || !strings.Contains(err.Error(), path+": line 1") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// withQuirks makes NewDevice use db for the duration of the test.
+func withQuirks(t *testing.T, quirks string) {
+	db, err := ParseQuirks(strings.NewReader(quirks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := DefaultQuirks
+	DefaultQuirks = db
+	t.Cleanup(func() { DefaultQuirks = old })
+}
+
+func TestQuirkNoStrings(t *testing.T) {
+	withQuirks(t, "1234:* no-strings")
+	host := newFakeHost()
+	d := NewDevice(host)
+	d.Open()
+	if _, err := d.ReadString(1); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("err = %v", err)
+	}
+	if _, err := d.Languages(); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("err = %v", err)
+	}
+	if got := host.Calls(); got != "open" {
+		t.Errorf("host calls %q", got)
+	}
+}
+
+func TestQuirkResetAfterSetConfiguration(t *testing.T) {
+	withQuirks(t, "1234:5678 reset-after-set-configuration")
+	host := newFakeHost()
+	d := NewDevice(host)
+	d.Open()
+	if err := d.SelectConfiguration(2); err != nil {
+		t.Fatal(err)
+	}
+	if got := host.Calls(); got != "open select-configuration(2) reset" {
+		t.Errorf("host calls %q", got)
+	}
+	if config, _ := d.ActiveConfiguration(); config.Descriptor.Number != 2 {
+		t.Errorf("active configuration %d", config.Descriptor.Number)
+	}
+}
+
+// writeRecorder records the data of bulk writes.
+type writeRecorder struct {
+	*fakeHost
+	written [][]byte
+}
+
+func (h *writeRecorder) WriteBulk(endpoint uint8, data []byte) (int, error) {
+	h.written = append(h.written, data)
+	return h.fakeHost.WriteBulk(endpoint, data)
+}
+
+func newQuirkDevice(t *testing.T, quirks string) (*Device, *writeRecorder) {
+	withQuirks(t, quirks)
+	host := &writeRecorder{fakeHost: newFakeHost()}
+	d := NewDevice(host)
+	d.Open()
+	d.ClaimInterface(0, 0)
+	return d, host
+}
+
+func TestQuirkNoZeroLengthPacket(t *testing.T) {
+	d, host := newQuirkDevice(t, "1234:5678 no-zlp")
+	if n, err := d.WriteBulk(0x02, nil); n != 0 || err != nil {
+		t.Fatalf("zero-length write = %d, %v", n, err)
+	}
+	if n, err := d.WriteBulk(0x02, []byte{1}); n != 1 || err != nil {
+		t.Fatalf("write = %d, %v", n, err)
+	}
+	if len(host.written) != 1 {
+		t.Errorf("%d writes reached the host", len(host.written))
+	}
+}
+
+func TestQuirkPadToMaxPacket(t *testing.T) {
+	d, host := newQuirkDevice(t, "1234:5678 pad-to-max-packet 0x02=64")
+	data := []byte{1, 2, 3}
+	if n, err := d.WriteBulk(0x02, data); n != 3 || err != nil {
+		t.Fatalf("write = %d, %v", n, err)
+	}
+	if n, _ := d.WriteBulk(0x02, make([]byte, 128)); n != 128 {
+		t.Fatalf("write of whole packets = %d", n)
+	}
+	if len(host.written[0]) != 64 || !bytes.Equal(host.written[0][:3], data) || len(host.written[1]) != 128 {
+		t.Errorf("written %d and %d bytes", len(host.written[0]), len(host.written[1]))
+	}
+	if len(data) != 3 || cap(data) != 3 {
+		t.Error("caller's buffer modified")
+	}
+}
+
+func TestQuirkMaxPacketSize(t *testing.T) {
+	withQuirks(t, "1234:5678 - ep0=8 0x81=64")
+	host := newFakeHost()
+	d := NewDevice(host)
+	if got := d.Descriptor().MaxPacketSize; got != 8 {
+		t.Errorf("ep0 max packet size %d", got)
+	}
+	intf, _ := d.Configurations()[0].Interface(0, 0)
+	if ep, _ := intf.Endpoint(0x81); ep.MaxPacketSize != 64 {
+		t.Errorf("0x81 max packet size %d", ep.MaxPacketSize)
+	}
+	if ep, _ := intf.Endpoint(0x02); ep.MaxPacketSize != 512 {
+		t.Errorf("0x02 max packet size %d", ep.MaxPacketSize)
+	}
+	if host.configs[0].Interfaces[0].Endpoints[0].MaxPacketSize != 512 {
+		t.Error("host descriptors modified")
+	}
+
+	d.SetQuirks(QuirkEntry{})
+	if ep, _ := intf.Endpoint(0x81); ep.MaxPacketSize != 64 {
+		t.Error("previously returned descriptors modified")
+	}
+	intf, _ = d.Configurations()[0].Interface(0, 0)
+	if ep, _ := intf.Endpoint(0x81); ep.MaxPacketSize != 512 {
+		t.Errorf("0x81 max packet size %d after SetQuirks", ep.MaxPacketSize)
+	}
+}
+
+func TestQuirkClassFlags(t *testing.T) {
+	withQuirks(t, "1234:5678 single-lun,ignore-residue")
+	q := NewDevice(newFakeHost()).Quirks()
+	if !q.Has(QuirkSingleLUN|QuirkIgnoreResidue) || q.Has(QuirkNoStrings) {
+		t.Errorf("quirks %v", q.Quirks)
+	}
+	if s := q.String(); s != "1234:5678 single-lun,ignore-residue" {
+		t.Errorf("String() = %q", s)
+	}
+}
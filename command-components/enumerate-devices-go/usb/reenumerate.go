@@ -0,0 +1,234 @@
+package usb
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Enumerator lists the devices currently connected, like the WIT
+// usb-device.enumerate function.
+type Enumerator func() ([]Host, error)
+
+// Matcher reports whether candidate is the device that was known as old
+// before it re-enumerated.
+type Matcher func(old *Device, candidate Host) bool
+
+// MatchSerial matches devices with the same vendor ID and serial number.
+func MatchSerial(old *Device, candidate Host) bool {
+	o, c := old.Descriptor(), candidate.Descriptor()
+	return o.SerialNumber != "" && o.SerialNumber == c.SerialNumber && o.VendorID == c.VendorID
+}
+
+// MatchLocation matches the device plugged into the same port.
+func MatchLocation(old *Device, candidate Host) bool {
+	o, ok := old.Location()
+	if !ok {
+		return false
+	}
+	c, ok := candidate.(Locator)
+	return ok && o.Equal(c.Location())
+}
+
+// MatchDefault matches by serial number if the device has one, and by
+// location otherwise.
+func MatchDefault(old *Device, candidate Host) bool {
+	if old.Descriptor().SerialNumber != "" {
+		return MatchSerial(old, candidate)
+	}
+	return MatchLocation(old, candidate)
+}
+
+// ReacquireOptions configures Device.ResetAndReacquire.
+type ReacquireOptions struct {
+	// Enumerate lists the connected devices. Required.
+	Enumerate Enumerator
+	// Match identifies the device among the enumerated ones. Defaults to
+	// MatchDefault.
+	Match Matcher
+	// Timeout bounds how long to wait for the device to come back,
+	// enumerations included. Defaults to 5 seconds.
+	Timeout time.Duration
+	// Interval is the time between enumerations. Defaults to 100 ms.
+	Interval time.Duration
+}
+
+// Reacquired describes the device found again after a reset.
+type Reacquired struct {
+	// Device guards the fresh handle. It has the interceptors, retry
+	// policies, recovery hooks, safety mode and quirks set with SetQuirks
+	// of the old Device, but no class reset and no claimed interfaces. The
+	// safety layer and the interceptors of EnableLogging and EnableMetrics
+	// are built again for the new descriptor.
+	Device *Device
+	// Reenumerated is set if the reset reported that the device is gone, or
+	// if it came back with different descriptors.
+	Reenumerated bool
+	// Changes lists the device descriptor fields that changed, e.g.
+	// "product-id: 0x5678 -> 0xdf11".
+	Changes []string
+	// ConfigurationsChanged is set if any configuration, interface or
+	// endpoint descriptor changed.
+	ConfigurationsChanged bool
+}
+
+// DescriptorChanged reports whether any descriptor changed.
+func (r *Reacquired) DescriptorChanged() bool {
+	return len(r.Changes) > 0 || r.ConfigurationsChanged
+}
+
+// ErrDeviceLost is returned by ResetAndReacquire if the device did not come
+// back within the timeout. It wraps the error of the last enumeration, if
+// that failed.
+var ErrDeviceLost = errors.New("usb: device did not come back after reset")
+
+// ResetAndReacquire resets the device and finds it again by a stable
+// identity, since a reset may make it re-enumerate with new descriptors (as
+// during firmware updates and DFU) and leave the old handle pointing at
+// nothing. The returned Device uses a fresh handle and is opened if d was;
+// d must not be used afterwards. Hosts that have a Drop method, such as the
+// old host and enumerated hosts that do not match, are dropped.
+func (d *Device) ResetAndReacquire(opts ReacquireOptions) (*Reacquired, error) {
+	if opts.Enumerate == nil {
+		return nil, errors.New("usb: ResetAndReacquire needs an Enumerator")
+	}
+	if opts.Match == nil {
+		opts.Match = MatchDefault
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.Interval == 0 {
+		opts.Interval = 100 * time.Millisecond
+	}
+
+	// A host may also report that the device is gone by closing the
+	// handle, as the component host does.
+	gone := false
+	if err := d.Reset(); err != nil {
+		if !errors.Is(err, ErrNoDevice) {
+			return nil, err
+		}
+		gone = true
+	} else if !d.host.Opened() {
+		gone = true
+	}
+	// The first enumeration after the device left may still list it as it
+	// was before the reset, and match it by serial number or location.
+	stale := gone
+
+	// Enumeration may fail while the device re-enumerates, so errors are
+	// retried like an enumeration that does not find it.
+	var (
+		host    Host
+		lastErr error
+	)
+	deadline := d.now().Add(opts.Timeout)
+	for host == nil {
+		left := deadline.Sub(d.now())
+		if left <= 0 {
+			if lastErr != nil {
+				return nil, fmt.Errorf("%w within %v: %w", ErrDeviceLost, opts.Timeout, lastErr)
+			}
+			return nil, fmt.Errorf("%w within %v", ErrDeviceLost, opts.Timeout)
+		}
+		d.sleep(min(opts.Interval, left))
+		hosts, err := opts.Enumerate()
+		lastErr = err
+		if err != nil {
+			continue
+		}
+		if stale {
+			stale = false
+			for _, h := range hosts {
+				drop(h)
+			}
+			continue
+		}
+		for _, h := range hosts {
+			if host == nil && opts.Match(d, h) {
+				host = h
+			} else {
+				drop(h)
+			}
+		}
+	}
+	drop(d.host)
+
+	fresh := NewDevice(host)
+	if d.quirksSet {
+		fresh.SetQuirks(d.quirks)
+	}
+	for i, ic := range d.interceptors {
+		if build := d.builders[i]; build != nil {
+			fresh.useBuilder(build)
+		} else {
+			fresh.Use(ic)
+		}
+	}
+	for endpoint, p := range d.policies {
+		fresh.policies[endpoint] = p
+	}
+	fresh.defaultPolicy = d.defaultPolicy
+	fresh.hooks = append(fresh.hooks, d.hooks...)
+	fresh.sleep = d.sleep
+	fresh.now = d.now
+	if d.safety != nil {
+		fresh.SetSafety(d.safetyOpts)
+	}
+	if d.opened && !fresh.opened {
+		if err := fresh.Open(); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &Reacquired{
+		Device:                fresh,
+		Changes:               descriptorChanges(d.descriptor, fresh.descriptor),
+		ConfigurationsChanged: !reflect.DeepEqual(d.configs, fresh.configs),
+	}
+	r.Reenumerated = gone || r.DescriptorChanged()
+	return r, nil
+}
+
+func drop(h Host) {
+	if d, ok := h.(interface{ Drop() }); ok {
+		d.Drop()
+	}
+}
+
+// descriptorChanges lists the fields that differ between two device
+// descriptors, named as in the WIT device-descriptor record.
+func descriptorChanges(old, new DeviceDescriptor) []string {
+	var changes []string
+	str := func(name, o, n string) {
+		if o != n {
+			changes = append(changes, fmt.Sprintf("%s: %q -> %q", name, o, n))
+		}
+	}
+	hex := func(name string, width int, o, n uint16) {
+		if o != n {
+			changes = append(changes, fmt.Sprintf("%s: 0x%0*x -> 0x%0*x", name, width, o, width, n))
+		}
+	}
+	version := func(name string, o, n Version) {
+		if o != n {
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, o, n))
+		}
+	}
+	str("product-name", old.ProductName, new.ProductName)
+	str("manufacturer-name", old.ManufacturerName, new.ManufacturerName)
+	str("serial-number", old.SerialNumber, new.SerialNumber)
+	version("usb-version", old.USBVersion, new.USBVersion)
+	hex("vendor-id", 4, old.VendorID, new.VendorID)
+	hex("product-id", 4, old.ProductID, new.ProductID)
+	version("device-version", old.DeviceVersion, new.DeviceVersion)
+	hex("device-class", 2, uint16(old.DeviceClass), uint16(new.DeviceClass))
+	hex("device-subclass", 2, uint16(old.DeviceSubclass), uint16(new.DeviceSubclass))
+	hex("device-protocol", 2, uint16(old.DeviceProtocol), uint16(new.DeviceProtocol))
+	if old.MaxPacketSize != new.MaxPacketSize {
+		changes = append(changes, fmt.Sprintf("max-packet-size: %d -> %d", old.MaxPacketSize, new.MaxPacketSize))
+	}
+	return changes
+}
@@ -0,0 +1,264 @@
+package usb
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// droppableHost records whether it was dropped.
+type droppableHost struct {
+	*powerHost
+	dropped bool
+}
+
+func (h *droppableHost) Drop() { h.dropped = true }
+
+func newDroppableHost(serial string, ports ...uint8) *droppableHost {
+	h := &droppableHost{powerHost: newPowerHost(1, ports...)}
+	h.descriptor.SerialNumber = serial
+	return h
+}
+
+// enumerateAfter returns an Enumerator that finds nothing for the first n
+// calls and a fresh set of hosts from hosts after that.
+func enumerateAfter(n int, hosts func() []Host) (Enumerator, *int) {
+	calls := 0
+	return func() ([]Host, error) {
+		calls++
+		if calls <= n {
+			return nil, nil
+		}
+		return hosts(), nil
+	}, &calls
+}
+
+func TestResetAndReacquireSameDevice(t *testing.T) {
+	old := newDroppableHost("0001", 2)
+	var ops []string
+	d := NewDevice(old, Around(func(c *Call, invoke func() error) error {
+		ops = append(ops, c.Op)
+		return invoke()
+	}))
+	d.SetRetryPolicy(0x81, DefaultRetryPolicy)
+	d.Open()
+	d.sleep = func(time.Duration) {}
+
+	var other, again *droppableHost
+	enumerate, calls := enumerateAfter(2, func() []Host {
+		other = newDroppableHost("0002", 3)
+		again = newDroppableHost("0001", 2)
+		return []Host{other, again}
+	})
+	r, err := d.ResetAndReacquire(ReacquireOptions{Enumerate: enumerate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *calls != 3 {
+		t.Errorf("enumerated %d times", *calls)
+	}
+	if r.Reenumerated || r.DescriptorChanged() {
+		t.Errorf("unchanged device reported as changed: %+v", r)
+	}
+	if !old.dropped || !other.dropped || again.dropped {
+		t.Errorf("dropped old=%v other=%v new=%v", old.dropped, other.dropped, again.dropped)
+	}
+	if got := old.Calls(); got != "open reset" {
+		t.Errorf("old host calls %q", got)
+	}
+	if got := again.Calls(); got != "open" {
+		t.Errorf("new host calls %q", got)
+	}
+	if r.Device.policies[0x81] != DefaultRetryPolicy {
+		t.Error("retry policy not carried over")
+	}
+	if len(ops) != 3 || ops[2] != OpOpen {
+		t.Errorf("interceptors not carried over: %v", ops)
+	}
+}
+
+func TestResetAndReacquireChangedDescriptors(t *testing.T) {
+	old := newDroppableHost("", 2)
+	old.fault = func(call string) error {
+		if call == "reset" {
+			return ErrNoDevice
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	d := NewDevice(old)
+	d.Open()
+	d.SetSafety(SafetyOptions{Mode: SafetyDryRun})
+	d.EnableLogging(LogOptions{Logger: testLogger(&buf, slog.LevelDebug)})
+	d.SetQuirks(QuirkEntry{Quirks: QuirkNoStrings})
+	d.sleep = func(time.Duration) {}
+
+	var dfu *droppableHost
+	enumerate, _ := enumerateAfter(0, func() []Host {
+		dfu = newDroppableHost("", 2)
+		dfu.descriptor.ProductID = 0xdf11
+		dfu.descriptor.ProductName = "DFU"
+		dfu.configs = dfu.configs[1:]
+		dfu.active = 2
+		return []Host{dfu}
+	})
+	r, err := d.ResetAndReacquire(ReacquireOptions{Enumerate: enumerate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Reenumerated || !r.ConfigurationsChanged {
+		t.Errorf("changes not detected: %+v", r)
+	}
+	want := []string{`product-name: "" -> "DFU"`, "product-id: 0x5678 -> 0xdf11"}
+	if len(r.Changes) != len(want) || r.Changes[0] != want[0] || r.Changes[1] != want[1] {
+		t.Errorf("changes %q, want %q", r.Changes, want)
+	}
+	buf.Reset()
+	if err := r.Device.SelectConfiguration(2); err != nil {
+		t.Fatal(err)
+	}
+	if got := dfu.Calls(); got != "open" {
+		t.Errorf("dry run not carried over, host calls %q", got)
+	}
+	if l := lines(&buf); len(l) == 0 || !strings.Contains(l[len(l)-1], "device.id=1234:df11") {
+		t.Errorf("logged with the old identity: %q", l)
+	}
+	if !r.Device.Quirks().Has(QuirkNoStrings) {
+		t.Error("quirks not carried over")
+	}
+}
+
+func TestResetAndReacquireTimeout(t *testing.T) {
+	d := NewDevice(newDroppableHost("0001", 2))
+	d.Open()
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	d.now = func() time.Time { return now }
+	d.sleep = func(interval time.Duration) { now = now.Add(interval) }
+
+	// Each enumeration takes as long as an interval, and counts against
+	// the timeout.
+	elsewhere := func() []Host {
+		now = now.Add(250 * time.Millisecond)
+		return []Host{newDroppableHost("0002", 2)}
+	}
+	enumerate, calls := enumerateAfter(0, elsewhere)
+	_, err := d.ResetAndReacquire(ReacquireOptions{
+		Enumerate: enumerate,
+		Timeout:   time.Second,
+		Interval:  250 * time.Millisecond,
+	})
+	if !errors.Is(err, ErrDeviceLost) {
+		t.Fatalf("err = %v", err)
+	}
+	if waited := now.Sub(start); *calls != 2 || waited != time.Second {
+		t.Errorf("enumerated %d times over %v", *calls, waited)
+	}
+}
+
+func TestResetAndReacquireCustomMatcher(t *testing.T) {
+	d := NewDevice(newDroppableHost("0001", 2))
+	d.Open()
+	d.sleep = func(time.Duration) {}
+
+	enumerate, _ := enumerateAfter(0, func() []Host {
+		return []Host{newDroppableHost("0002", 4), newDroppableHost("0003", 2)}
+	})
+	r, err := d.ResetAndReacquire(ReacquireOptions{Enumerate: enumerate, Match: MatchLocation})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial := r.Device.Descriptor().SerialNumber; serial != "0003" {
+		t.Errorf("matched serial %q", serial)
+	}
+	if len(r.Changes) != 1 || !r.Reenumerated {
+		t.Errorf("changes %q", r.Changes)
+	}
+}
+
+func TestResetAndReacquireEnumerateError(t *testing.T) {
+	d := NewDevice(newDroppableHost("0001", 2))
+	d.Open()
+	d.sleep = func(time.Duration) {}
+
+	// The first enumeration fails while the device re-enumerates.
+	errBusy := errors.New("enumeration busy")
+	calls := 0
+	var again *droppableHost
+	r, err := d.ResetAndReacquire(ReacquireOptions{Enumerate: func() ([]Host, error) {
+		calls++
+		if calls == 1 {
+			return nil, errBusy
+		}
+		again = newDroppableHost("0001", 2)
+		return []Host{again}, nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || r.Device.host != again {
+		t.Errorf("enumerated %d times, host %v", calls, r.Device.host)
+	}
+
+	// An error that lasts until the deadline is wrapped.
+	d = NewDevice(newDroppableHost("0001", 2))
+	d.Open()
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	d.sleep = func(interval time.Duration) { now = now.Add(interval) }
+	_, err = d.ResetAndReacquire(ReacquireOptions{
+		Enumerate: func() ([]Host, error) { return nil, errBusy },
+		Timeout:   time.Second,
+	})
+	if !errors.Is(err, ErrDeviceLost) || !errors.Is(err, errBusy) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// witHost resets like component.Host does over the WIT interface: a reset
+// that makes the device re-enumerate does not fail, but leaves the handle
+// closed.
+type witHost struct {
+	*droppableHost
+}
+
+func (h *witHost) Reset() error {
+	h.opened = false
+	return h.record("reset")
+}
+
+func TestResetAndReacquireClosedByHost(t *testing.T) {
+	old := &witHost{newDroppableHost("0001", 2)}
+	d := NewDevice(old)
+	d.Open()
+	d.sleep = func(time.Duration) {}
+
+	// The first enumeration still lists the device as it was.
+	var polls []*droppableHost
+	calls := 0
+	enumerate := func() ([]Host, error) {
+		calls++
+		h := newDroppableHost("0001", 2)
+		if calls > 1 {
+			h.descriptor.ProductID = 0xdf11
+		}
+		polls = append(polls, h)
+		return []Host{h}, nil
+	}
+	r, err := d.ResetAndReacquire(ReacquireOptions{Enumerate: enumerate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || !polls[0].dropped || r.Device.host != polls[1] {
+		t.Fatalf("enumerated %d times, reacquired %v", calls, r.Device.host)
+	}
+	if !r.Reenumerated || r.Device.Descriptor().ProductID != 0xdf11 {
+		t.Errorf("re-enumeration not reported: %+v", r)
+	}
+	if got := polls[1].Calls(); got != "open" {
+		t.Errorf("new host calls %q", got)
+	}
+}
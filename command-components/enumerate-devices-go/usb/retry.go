@@ -0,0 +1,279 @@
+package usb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RecoveryAction is what a Device does after a failed transfer, before
+// trying it again.
+type RecoveryAction uint8
+
+const (
+	// RecoverFail gives up and returns the error.
+	RecoverFail RecoveryAction = iota
+	// RecoverRetry retries the transfer as is.
+	RecoverRetry
+	// RecoverClearHalt clears the halt on the endpoint and retries.
+	// On the default control endpoint the stall clears itself, so this
+	// is the same as RecoverRetry.
+	RecoverClearHalt
+	// RecoverClassReset runs the class reset registered with
+	// SetClassReset and retries.
+	RecoverClassReset
+	// RecoverDeviceReset resets the device and retries.
+	RecoverDeviceReset
+)
+
+func (a RecoveryAction) String() string {
+	switch a {
+	case RecoverFail:
+		return "fail"
+	case RecoverRetry:
+		return "retry"
+	case RecoverClearHalt:
+		return "clear-halt"
+	case RecoverClassReset:
+		return "class-reset"
+	case RecoverDeviceReset:
+		return "device-reset"
+	}
+	return fmt.Sprintf("RecoveryAction(%d)", uint8(a))
+}
+
+// Recovery is the decision of a RetryPolicy.
+type Recovery struct {
+	Action RecoveryAction
+	Delay  time.Duration // wait this long before the recovery action
+}
+
+// RetryPolicy decides how a failed transfer is recovered. Next is called with
+// the number of failed attempts so far (starting at 1) and the last error.
+type RetryPolicy interface {
+	Next(attempt int, err error) Recovery
+}
+
+// RetryPolicyFunc adapts a function to a RetryPolicy.
+type RetryPolicyFunc func(attempt int, err error) Recovery
+
+func (f RetryPolicyFunc) Next(attempt int, err error) Recovery {
+	return f(attempt, err)
+}
+
+// Escalation is what a Policy does once its retries are exhausted.
+type Escalation uint8
+
+const (
+	EscalateNone Escalation = iota
+	EscalateClassReset
+	EscalateDeviceReset
+)
+
+// Backoff computes exponentially growing delays: Initial, Initial*Multiplier,
+// ... capped at Max. A zero Backoff never waits.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64 // defaults to 2
+}
+
+// Delay returns the delay before retry number attempt (starting at 1).
+func (b Backoff) Delay(attempt int) time.Duration {
+	if b.Initial <= 0 {
+		return 0
+	}
+	mult := b.Multiplier
+	if mult < 1 {
+		mult = 2
+	}
+	d := float64(b.Initial)
+	for i := 1; i < attempt; i++ {
+		d *= mult
+		if b.Max > 0 && d >= float64(b.Max) {
+			return b.Max
+		}
+	}
+	return time.Duration(d)
+}
+
+// Policy is the standard RetryPolicy. Stalls are recovered by clearing the
+// halt, timeouts and I/O errors are retried after a backoff, and everything
+// else (overflow, disconnect, state errors) fails immediately. After
+// MaxRetries recoveries the policy escalates once, then gives up.
+type Policy struct {
+	MaxRetries       int
+	ClearHaltOnStall bool
+	Backoff          Backoff
+	Escalation       Escalation
+}
+
+// DefaultRetryPolicy clears halts and retries timeouts up to three times.
+var DefaultRetryPolicy = &Policy{
+	MaxRetries:       3,
+	ClearHaltOnStall: true,
+	Backoff:          Backoff{Initial: 10 * time.Millisecond, Max: time.Second},
+}
+
+func (p *Policy) Next(attempt int, err error) Recovery {
+	var action RecoveryAction
+	switch {
+	case errors.Is(err, ErrStall):
+		if !p.ClearHaltOnStall {
+			return Recovery{}
+		}
+		action = RecoverClearHalt
+	case errors.Is(err, ErrTimeout), errors.Is(err, ErrIO):
+		action = RecoverRetry
+	default:
+		return Recovery{}
+	}
+
+	if attempt > p.MaxRetries {
+		if attempt > p.MaxRetries+1 {
+			return Recovery{}
+		}
+		switch p.Escalation {
+		case EscalateClassReset:
+			return Recovery{Action: RecoverClassReset}
+		case EscalateDeviceReset:
+			return Recovery{Action: RecoverDeviceReset}
+		}
+		return Recovery{}
+	}
+	if action == RecoverRetry {
+		return Recovery{Action: action, Delay: p.Backoff.Delay(attempt)}
+	}
+	return Recovery{Action: action}
+}
+
+// RecoveryEvent describes a recovery action taken by a Device.
+type RecoveryEvent struct {
+	Op       string // the failed operation, e.g. "read-bulk"
+	Endpoint uint8
+	Attempt  int
+	Err      error // the error that triggered the recovery
+	Recovery Recovery
+	// ActionErr is the error of the recovery action itself, if it failed.
+	// The transfer is not retried in that case.
+	ActionErr error
+}
+
+// RecoveryHook is called for every recovery action a Device takes.
+type RecoveryHook func(RecoveryEvent)
+
+// RecoveryError is returned when a recovery action fails.
+type RecoveryError struct {
+	Action RecoveryAction
+	Err    error // the error of the recovery action
+	Cause  error // the transfer error that triggered the recovery
+}
+
+func (e *RecoveryError) Error() string {
+	return fmt.Sprintf("usb: %s after %v failed: %v", e.Action, e.Cause, e.Err)
+}
+
+func (e *RecoveryError) Unwrap() []error {
+	return []error{e.Err, e.Cause}
+}
+
+// ErrNoClassReset is returned for RecoverClassReset when no class reset was
+// registered with SetClassReset.
+var ErrNoClassReset = errors.New("no class reset registered")
+
+// SetRetryPolicy sets the retry policy for an endpoint. Control transfers use
+// endpoint 0x00 in both directions. A nil policy removes the endpoint's policy.
+func (d *Device) SetRetryPolicy(endpoint uint8, p RetryPolicy) {
+	if endpoint&0x0f == 0 {
+		endpoint = 0
+	}
+	if p == nil {
+		delete(d.policies, endpoint)
+		return
+	}
+	d.policies[endpoint] = p
+}
+
+// SetDefaultRetryPolicy sets the policy for endpoints without their own.
+// It is nil by default, which means failed transfers are not retried.
+func (d *Device) SetDefaultRetryPolicy(p RetryPolicy) {
+	d.defaultPolicy = p
+}
+
+// SetClassReset registers the class-specific reset used by RecoverClassReset,
+// such as the Bulk-Only Mass Storage Reset.
+func (d *Device) SetClassReset(reset func() error) {
+	d.classReset = reset
+}
+
+// OnRecovery registers a hook that is called for every recovery action.
+func (d *Device) OnRecovery(hook RecoveryHook) {
+	d.hooks = append(d.hooks, hook)
+}
+
+func (d *Device) retryPolicy(endpoint uint8) RetryPolicy {
+	if endpoint&0x0f == 0 {
+		endpoint = 0
+	}
+	if p, ok := d.policies[endpoint]; ok {
+		return p
+	}
+	return d.defaultPolicy
+}
+
+// retry is the interceptor that recovers failed transfers according to the
+// endpoint's retry policy. Recovery actions are sent to next, so interceptors
+// closer to the host see them like any other call.
+func (d *Device) retry(next Transport) Transport {
+	return Around(func(c *Call, invoke func() error) error {
+		err := invoke()
+		if err == nil || !c.IsTransfer() || d.inClassReset {
+			return err
+		}
+		policy := d.retryPolicy(c.Endpoint)
+		if policy == nil {
+			return err
+		}
+		for attempt := 1; err != nil; attempt++ {
+			r := policy.Next(attempt, err)
+			if r.Action == RecoverFail {
+				return err
+			}
+			if r.Delay > 0 {
+				d.sleep(r.Delay)
+			}
+			actionErr := d.recover(next, c.Endpoint, r.Action)
+			ev := RecoveryEvent{Op: c.Op, Endpoint: c.Endpoint, Attempt: attempt, Err: err, Recovery: r, ActionErr: actionErr}
+			for _, hook := range d.hooks {
+				hook(ev)
+			}
+			if actionErr != nil {
+				return &RecoveryError{Action: r.Action, Err: actionErr, Cause: err}
+			}
+			err = invoke()
+		}
+		return nil
+	})(next)
+}
+
+func (d *Device) recover(next Transport, endpoint uint8, action RecoveryAction) error {
+	switch action {
+	case RecoverClearHalt:
+		if endpoint&0x0f == 0 {
+			return nil
+		}
+		return next.ClearHalt(endpoint)
+	case RecoverClassReset:
+		if d.classReset == nil {
+			return ErrNoClassReset
+		}
+		// The class reset goes through the whole Device, but its own
+		// transfers are not retried to avoid recursive recovery.
+		d.inClassReset = true
+		defer func() { d.inClassReset = false }()
+		return d.classReset()
+	case RecoverDeviceReset:
+		return next.Reset()
+	}
+	return nil
+}
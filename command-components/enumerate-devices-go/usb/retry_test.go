@@ -0,0 +1,243 @@
+package usb
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// script fails successive calls with the queued errors for that call.
+func script(faults map[string][]error) func(call string) error {
+	return func(call string) error {
+		q := faults[call]
+		if len(q) == 0 {
+			return nil
+		}
+		faults[call] = q[1:]
+		return q[0]
+	}
+}
+
+// always fails every call in calls with err.
+func always(err error, calls ...string) func(call string) error {
+	return func(call string) error {
+		for _, c := range calls {
+			if c == call {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func newRetryDevice(t *testing.T, fault func(string) error) (*Device, *fakeHost, *[]time.Duration, *[]RecoveryEvent) {
+	t.Helper()
+	host := newFakeHost()
+	d := NewDevice(host)
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ClaimInterface(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	host.calls = nil
+	host.fault = fault
+
+	var sleeps []time.Duration
+	d.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	var events []RecoveryEvent
+	d.OnRecovery(func(ev RecoveryEvent) { events = append(events, ev) })
+	return d, host, &sleeps, &events
+}
+
+func TestRetryClearsHaltOnStall(t *testing.T) {
+	d, host, _, events := newRetryDevice(t, script(map[string][]error{
+		"read-bulk(0x81)": {ErrStall},
+	}))
+	d.SetRetryPolicy(0x81, DefaultRetryPolicy)
+
+	data, err := d.ReadBulk(0x81, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 13 {
+		t.Fatalf("got %d bytes", len(data))
+	}
+	if want := "read-bulk(0x81) clear-halt(0x81) read-bulk(0x81)"; host.Calls() != want {
+		t.Fatalf("calls %q, want %q", host.Calls(), want)
+	}
+	if len(*events) != 1 {
+		t.Fatalf("got %d events", len(*events))
+	}
+	ev := (*events)[0]
+	if ev.Op != "read-bulk" || ev.Endpoint != 0x81 || ev.Attempt != 1 || ev.Recovery.Action != RecoverClearHalt || !errors.Is(ev.Err, ErrStall) {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+}
+
+func TestRetryBacksOffOnTimeout(t *testing.T) {
+	d, host, sleeps, events := newRetryDevice(t, always(ErrTimeout, "write-bulk(0x02)"))
+	d.SetDefaultRetryPolicy(&Policy{
+		MaxRetries: 3,
+		Backoff:    Backoff{Initial: 10 * time.Millisecond, Max: 25 * time.Millisecond},
+	})
+
+	_, err := d.WriteBulk(0x02, []byte{1})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+	if n := strings.Count(host.Calls(), "write-bulk"); n != 4 {
+		t.Fatalf("got %d attempts, want 4", n)
+	}
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 25 * time.Millisecond}
+	if !reflect.DeepEqual(*sleeps, want) {
+		t.Fatalf("sleeps %v, want %v", *sleeps, want)
+	}
+	if len(*events) != 3 {
+		t.Fatalf("got %d events, want 3", len(*events))
+	}
+}
+
+func TestRetryEscalation(t *testing.T) {
+	tests := []struct {
+		name       string
+		escalation Escalation
+		want       string
+		wantErr    error
+	}{
+		{
+			name:    "none",
+			want:    "read-bulk(0x81) clear-halt(0x81) read-bulk(0x81)",
+			wantErr: ErrStall,
+		},
+		{
+			name:       "class reset",
+			escalation: EscalateClassReset,
+			want:       "read-bulk(0x81) clear-halt(0x81) read-bulk(0x81) read-bulk(0x81)",
+			wantErr:    ErrStall,
+		},
+		{
+			name:       "class reset not registered",
+			escalation: EscalateClassReset,
+			want:       "read-bulk(0x81) clear-halt(0x81) read-bulk(0x81)",
+			wantErr:    ErrNoClassReset,
+		},
+		{
+			name:       "device reset",
+			escalation: EscalateDeviceReset,
+			want:       "read-bulk(0x81) clear-halt(0x81) read-bulk(0x81) reset read-bulk(0x81)",
+			wantErr:    ErrStall,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, host, _, events := newRetryDevice(t, always(ErrStall, "read-bulk(0x81)"))
+			d.SetRetryPolicy(0x81, &Policy{MaxRetries: 1, ClearHaltOnStall: true, Escalation: tt.escalation})
+			resets := 0
+			if tt.name == "class reset" {
+				d.SetClassReset(func() error { resets++; return nil })
+			}
+
+			_, err := d.ReadBulk(0x81, 13)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if host.Calls() != tt.want {
+				t.Fatalf("calls %q, want %q", host.Calls(), tt.want)
+			}
+			if tt.name == "class reset" && resets != 1 {
+				t.Fatalf("class reset ran %d times", resets)
+			}
+			last := (*events)[len(*events)-1]
+			switch tt.escalation {
+			case EscalateClassReset:
+				if last.Recovery.Action != RecoverClassReset {
+					t.Fatalf("last action %v", last.Recovery.Action)
+				}
+			case EscalateDeviceReset:
+				if last.Recovery.Action != RecoverDeviceReset {
+					t.Fatalf("last action %v", last.Recovery.Action)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryFailsFast(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		policy RetryPolicy
+	}{
+		{"no policy", ErrStall, nil},
+		{"disconnect", ErrNoDevice, DefaultRetryPolicy},
+		{"overflow", ErrOverflow, DefaultRetryPolicy},
+		{"stall without clear halt", ErrStall, &Policy{MaxRetries: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, host, _, events := newRetryDevice(t, always(tt.err, "read-interrupt(0x83)"))
+			d.SetRetryPolicy(0x83, tt.policy)
+			_, err := d.ReadInterrupt(0x83, 8)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if host.Calls() != "read-interrupt(0x83)" || len(*events) != 0 {
+				t.Fatalf("calls %q, events %v", host.Calls(), *events)
+			}
+		})
+	}
+}
+
+func TestRetryRecoveryActionFails(t *testing.T) {
+	d, _, _, events := newRetryDevice(t, func(call string) error {
+		switch call {
+		case "read-bulk(0x81)":
+			return ErrStall
+		case "clear-halt(0x81)":
+			return ErrNoDevice
+		}
+		return nil
+	})
+	d.SetDefaultRetryPolicy(DefaultRetryPolicy)
+
+	_, err := d.ReadBulk(0x81, 13)
+	var re *RecoveryError
+	if !errors.As(err, &re) || re.Action != RecoverClearHalt {
+		t.Fatalf("got %v, want a clear-halt RecoveryError", err)
+	}
+	if !errors.Is(err, ErrStall) || !errors.Is(err, ErrNoDevice) {
+		t.Fatalf("%v should match both the stall and the disconnect", err)
+	}
+	if len(*events) != 1 || !errors.Is((*events)[0].ActionErr, ErrNoDevice) {
+		t.Fatalf("events %+v", *events)
+	}
+}
+
+func TestRetryControlStall(t *testing.T) {
+	d, host, _, _ := newRetryDevice(t, script(map[string][]error{
+		"read-control(0x06)": {ErrStall},
+	}))
+	d.SetDefaultRetryPolicy(DefaultRetryPolicy)
+	if _, err := d.ReadControl(ControlSetup{Request: 0x06}, 18); err != nil {
+		t.Fatal(err)
+	}
+	if want := "read-control(0x06) read-control(0x06)"; host.Calls() != want {
+		t.Fatalf("calls %q, want %q", host.Calls(), want)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 3}
+	want := []time.Duration{time.Millisecond, 3 * time.Millisecond, 5 * time.Millisecond, 5 * time.Millisecond}
+	for i, w := range want {
+		if got := b.Delay(i + 1); got != w {
+			t.Errorf("Delay(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+	if got := (Backoff{}).Delay(3); got != 0 {
+		t.Errorf("zero Backoff delay = %v", got)
+	}
+}
@@ -0,0 +1,179 @@
+package usb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// SafetyMode restricts which calls reach the device.
+type SafetyMode uint8
+
+const (
+	// SafetyOff forwards every call.
+	SafetyOff SafetyMode = iota
+	// SafetyReadOnly only allows IN transfers on interrupt, bulk and
+	// isochronous endpoints and bulk streams, whitelisted standard control
+	// requests and link power state queries. Everything else that could
+	// change the device, suspend and resume included, fails with ErrReadOnly.
+	SafetyReadOnly
+	// SafetyDryRun logs OUT transfers, control writes such as SET_FEATURE,
+	// clear-halt, reset, select-configuration and the power management
+	// calls that change the device's power state instead of executing them,
+	// and reports them as successful. IN transfers are executed; in a batch
+	// of stream transfers that writes, they are reported as cancelled.
+	SafetyDryRun
+)
+
+func (m SafetyMode) String() string {
+	switch m {
+	case SafetyOff:
+		return "off"
+	case SafetyReadOnly:
+		return "read-only"
+	case SafetyDryRun:
+		return "dry-run"
+	}
+	return fmt.Sprintf("SafetyMode(%d)", uint8(m))
+}
+
+// DefaultAllowedRequests are the standard requests allowed in read-only mode.
+// None of them changes the state of the device.
+var DefaultAllowedRequests = []uint8{
+	RequestGetStatus,
+	RequestGetDescriptor,
+	RequestGetConfiguration,
+	RequestGetInterface,
+	RequestSynchFrame,
+}
+
+// SafetyOptions configures the Safety interceptor.
+type SafetyOptions struct {
+	Mode SafetyMode
+	// AllowedRequests are the standard control requests (bRequest) allowed in
+	// read-only mode. Class and vendor requests are never allowed. Defaults
+	// to DefaultAllowedRequests.
+	AllowedRequests []uint8
+	// Logger receives the calls skipped in dry-run mode. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Safety returns an interceptor enforcing opts.Mode. Claiming and releasing
+// interfaces is always allowed, since it does not change what the device
+// stores.
+func Safety(device DeviceDescriptor, opts SafetyOptions) Interceptor {
+	if opts.AllowedRequests == nil {
+		opts.AllowedRequests = DefaultAllowedRequests
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With(deviceAttr(device))
+
+	return Around(func(c *Call, invoke func() error) error {
+		switch opts.Mode {
+		case SafetyReadOnly:
+			if err := checkReadOnly(c, opts.AllowedRequests); err != nil {
+				return err
+			}
+		case SafetyDryRun:
+			if modifies(c) {
+				logDryRun(logger, c)
+				c.Actual = len(c.Data)
+				if c.Op == OpTransferStreams {
+					c.Results, c.Actual = dryRunStreams(c.Transfers)
+				}
+				return nil
+			}
+		}
+		return invoke()
+	})
+}
+
+// SetSafety puts the device in a safety mode. The safety layer sits below
+// all other interceptors, directly above the host.
+func (d *Device) SetSafety(opts SafetyOptions) {
+	d.safety = nil
+	d.safetyOpts = opts
+	if opts.Mode != SafetyOff {
+		d.safety = Safety(d.descriptor, opts)
+	}
+	d.rebuild()
+}
+
+func checkReadOnly(c *Call, allowed []uint8) error {
+	switch c.Op {
+	case OpOpen, OpClose, OpClaimInterface, OpReleaseInterface, OpAllocStreams, OpFreeStreams, OpLinkPowerStateEnabled:
+		return nil
+	case OpReadControl, OpWriteControl:
+		if c.Setup.Type != ControlStandard {
+			return stateError(c.Op, ErrReadOnly, "%s request 0x%02x", c.Setup.Type, c.Setup.Request)
+		}
+		if !slices.Contains(allowed, c.Setup.Request) {
+			return stateError(c.Op, ErrReadOnly, "standard request 0x%02x not allowed", c.Setup.Request)
+		}
+		return nil
+	case OpReadInterrupt, OpReadBulk, OpReadIsochronous:
+		return nil
+	case OpClearHalt, OpWriteInterrupt, OpWriteBulk, OpWriteIsochronous:
+		return stateError(c.Op, ErrReadOnly, "endpoint 0x%02x", c.Endpoint)
+	case OpTransferStreams:
+		for _, t := range c.Transfers {
+			if EndpointDirection(t.Endpoint) == DirectionOut {
+				return stateError(c.Op, ErrReadOnly, "endpoint 0x%02x", t.Endpoint)
+			}
+		}
+		return nil
+	}
+	return &StateError{Op: c.Op, Err: ErrReadOnly}
+}
+
+// modifies reports whether a call is skipped in dry-run mode.
+func modifies(c *Call) bool {
+	switch c.Op {
+	case OpReset, OpSelectConfiguration, OpClearHalt, OpSuspend, OpResume, OpSetLinkPowerStateEnabled:
+		return true
+	}
+	return c.IsTransfer() && c.Direction() == DirectionOut || c.WritesStreams()
+}
+
+// dryRunStreams returns the results reported for a batch of stream transfers
+// in dry-run mode: the writes complete, the reads are cancelled.
+func dryRunStreams(transfers []StreamTransfer) ([]StreamResult, int) {
+	results := make([]StreamResult, len(transfers))
+	actual := 0
+	for i, t := range transfers {
+		if EndpointDirection(t.Endpoint) == DirectionIn {
+			results[i].Status = StreamCancelled
+			continue
+		}
+		results[i].Actual = len(t.Data)
+		actual += len(t.Data)
+	}
+	return results, actual
+}
+
+func logDryRun(logger *slog.Logger, c *Call) {
+	attrs := []slog.Attr{slog.String("op", c.Op)}
+	switch c.Op {
+	case OpSelectConfiguration:
+		attrs = append(attrs, slog.Int("configuration", int(c.Number)))
+	case OpClearHalt:
+		attrs = append(attrs, slog.String("endpoint", fmt.Sprintf("0x%02x", c.Endpoint)))
+	case OpSetLinkPowerStateEnabled:
+		attrs = append(attrs, slog.String("state", c.State.String()), slog.Bool("enabled", c.Enabled))
+	}
+	if c.IsTransfer() {
+		attrs = append(attrs, slog.String("endpoint", fmt.Sprintf("0x%02x", c.Endpoint)))
+		if tt, _ := c.TransferType(); tt == TransferControl {
+			attrs = append(attrs, setupAttr(c.Setup, DirectionOut))
+		}
+		attrs = append(attrs, slog.Int("length", len(c.Data)))
+	}
+	if c.Op == OpTransferStreams {
+		attrs = append(attrs, streamsAttr(c.Transfers))
+	}
+	logger.LogAttrs(context.Background(), slog.LevelInfo, "usb dry-run "+c.Op, attrs...)
+}
@@ -0,0 +1,119 @@
+package usb
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSafetyReadOnly(t *testing.T) {
+	host := newFakeHost()
+	var log []string
+	d := NewDevice(host, recorder("driver", &log))
+	d.SetSafety(SafetyOptions{Mode: SafetyReadOnly})
+	d.Open()
+	d.ClaimInterface(0, 0)
+
+	getDescriptor := ControlSetup{Type: ControlStandard, Recipient: RecipientDevice, Request: RequestGetDescriptor, Value: 0x0100}
+	setFeature := ControlSetup{Type: ControlStandard, Recipient: RecipientDevice, Request: RequestSetFeature, Value: 1}
+	getMaxLUN := ControlSetup{Type: ControlClass, Recipient: RecipientInterface, Request: 0xfe}
+
+	allowed := []struct {
+		name string
+		call func() error
+	}{
+		{"read-bulk", func() error { _, err := d.ReadBulk(0x81, 8); return err }},
+		{"read-interrupt", func() error { _, err := d.ReadInterrupt(0x83, 8); return err }},
+		{"get-descriptor", func() error { _, err := d.ReadControl(getDescriptor, 18); return err }},
+	}
+	for _, a := range allowed {
+		if err := a.call(); err != nil {
+			t.Errorf("%s: %v", a.name, err)
+		}
+	}
+
+	denied := []struct {
+		name string
+		call func() error
+	}{
+		{"write-bulk", func() error { _, err := d.WriteBulk(0x02, []byte{1}); return err }},
+		{"set-feature", func() error { _, err := d.WriteControl(setFeature, nil); return err }},
+		{"class request", func() error { _, err := d.ReadControl(getMaxLUN, 1); return err }},
+		{"clear-halt", func() error { return d.ClearHalt(0x81) }},
+		{"reset", d.Reset},
+	}
+	for _, dn := range denied {
+		if err := dn.call(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s: got %v", dn.name, err)
+		}
+	}
+	d.ReleaseInterface(0, 0)
+	if err := d.SelectConfiguration(2); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("select-configuration: got %v", err)
+	}
+	if a, ok := d.ActiveConfiguration(); !ok || a.Descriptor.Number != 1 {
+		t.Errorf("rejected select-configuration changed the tracked state")
+	}
+
+	if got := host.Calls(); got != "open claim-interface(0,0) read-bulk(0x81) read-interrupt(0x83) read-control(0x06) release-interface(0,0)" {
+		t.Fatalf("host calls %q", got)
+	}
+	// The driver's interceptor saw the denied calls: the layer is below it.
+	if !strings.Contains(strings.Join(log, " "), "driver:write-bulk") {
+		t.Fatalf("safety layer is above the driver interceptors: %v", log)
+	}
+}
+
+func TestSafetyDryRun(t *testing.T) {
+	var buf bytes.Buffer
+	host := newFakeHost()
+	d := NewDevice(host)
+	d.SetSafety(SafetyOptions{Mode: SafetyDryRun, Logger: testLogger(&buf, slog.LevelInfo)})
+	d.Open()
+	d.ClaimInterface(0, 0)
+
+	if n, err := d.WriteBulk(0x02, []byte{1, 2, 3}); n != 3 || err != nil {
+		t.Fatalf("write-bulk = %d, %v", n, err)
+	}
+	setFeature := ControlSetup{Type: ControlStandard, Recipient: RecipientDevice, Request: RequestSetFeature, Value: 1}
+	if _, err := d.WriteControl(setFeature, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ReadBulk(0x81, 4); err != nil {
+		t.Fatal(err)
+	}
+	d.ReleaseInterface(0, 0)
+	if err := d.SelectConfiguration(2); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := host.Calls(); got != "open claim-interface(0,0) read-bulk(0x81) release-interface(0,0)" {
+		t.Fatalf("host calls %q", got)
+	}
+	want := []string{
+		`level=INFO msg="usb dry-run write-bulk" device.id=1234:5678 device.serial=0001 op=write-bulk endpoint=0x02 length=3`,
+		`level=INFO msg="usb dry-run write-control" device.id=1234:5678 device.serial=0001 op=write-control endpoint=0x00 setup.bmRequestType=0x00 setup.bRequest=0x03 setup.wValue=0x0001 setup.wIndex=0x0000 length=0`,
+		`level=INFO msg="usb dry-run reset" device.id=1234:5678 device.serial=0001 op=reset`,
+		`level=INFO msg="usb dry-run select-configuration" device.id=1234:5678 device.serial=0001 op=select-configuration configuration=2`,
+	}
+	got := lines(&buf)
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines:\n%s", len(got), buf.String())
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d:\ngot  %s\nwant %s", i, got[i], want[i])
+		}
+	}
+
+	d.SetSafety(SafetyOptions{})
+	d.Open()
+	if err := d.Reset(); err != nil || !strings.HasSuffix(host.Calls(), "reset") {
+		t.Fatalf("reset after turning safety off: %v, calls %q", err, host.Calls())
+	}
+}
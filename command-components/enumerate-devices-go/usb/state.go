@@ -0,0 +1,191 @@
+package usb
+
+import "fmt"
+
+// validate is the outermost interceptor of every Device. It rejects calls
+// that are illegal in the current state and records state changes once the
+// call has succeeded.
+func (d *Device) validate(next Transport) Transport {
+	return Around(func(c *Call, invoke func() error) error {
+		if c.Op == OpOpen && d.opened {
+			return nil
+		}
+		if err := d.check(c); err != nil {
+			return err
+		}
+		if err := invoke(); err != nil {
+			return err
+		}
+		d.update(c)
+		return nil
+	})(next)
+}
+
+func (d *Device) check(c *Call) error {
+	switch c.Op {
+	case OpOpen:
+		return nil
+	case OpClose, OpReset, OpReadControl, OpWriteControl:
+		return d.checkOpen(c.Op)
+	case OpSelectConfiguration:
+		return d.checkSelectConfiguration(c.Number)
+	case OpClaimInterface:
+		return d.checkClaimInterface(c.Number, c.Alternate)
+	case OpReleaseInterface:
+		return d.checkReleaseInterface(c.Number, c.Alternate)
+	case OpClearHalt:
+		return d.checkClearHalt(c.Endpoint)
+	case OpAllocStreams, OpFreeStreams, OpTransferStreams:
+		return d.checkStreams(c)
+	case OpSuspend, OpResume, OpLinkPowerStateEnabled, OpSetLinkPowerStateEnabled:
+		return d.checkPower(c)
+	}
+	tt, _ := c.TransferType()
+	return d.checkEndpoint(c.Op, c.Endpoint, tt, c.Direction())
+}
+
+func (d *Device) update(c *Call) {
+	switch c.Op {
+	case OpOpen:
+		d.opened = true
+	case OpClose:
+		d.opened = false
+		clear(d.claimed)
+		clear(d.streams)
+	case OpSelectConfiguration:
+		d.active = c.Number
+		clear(d.streams)
+	case OpClaimInterface:
+		d.claimed[c.Number] = c.Alternate
+	case OpReleaseInterface:
+		d.dropStreams(c.Number, c.Alternate)
+		delete(d.claimed, c.Number)
+	case OpAllocStreams:
+		for _, ep := range c.Endpoints {
+			d.streams[ep] = c.Streams
+		}
+	case OpFreeStreams:
+		for _, ep := range c.Endpoints {
+			delete(d.streams, ep)
+		}
+	}
+}
+
+func (d *Device) checkOpen(op string) error {
+	if !d.opened {
+		return &StateError{Op: op, Err: ErrNotOpen}
+	}
+	return nil
+}
+
+func (d *Device) checkConfigured(op string) (*Configuration, error) {
+	if err := d.checkOpen(op); err != nil {
+		return nil, err
+	}
+	cfg, ok := d.configuration(d.active)
+	if !ok {
+		return nil, &StateError{Op: op, Err: ErrNotConfigured}
+	}
+	return cfg, nil
+}
+
+func (d *Device) checkSelectConfiguration(number uint8) error {
+	const op = OpSelectConfiguration
+	if err := d.checkOpen(op); err != nil {
+		return err
+	}
+	if _, ok := d.configuration(number); !ok {
+		return stateError(op, ErrUnknownConfiguration, "configuration %d", number)
+	}
+	if len(d.claimed) > 0 {
+		return stateError(op, ErrInterfacesClaimed, "%d interface(s) claimed", len(d.claimed))
+	}
+	return nil
+}
+
+func (d *Device) checkClaimInterface(number, alt uint8) error {
+	const op = OpClaimInterface
+	cfg, err := d.checkConfigured(op)
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.Interface(number, alt); !ok {
+		return stateError(op, ErrUnknownInterface, "interface %d alternate %d in configuration %d", number, alt, d.active)
+	}
+	if claimedAlt, ok := d.claimed[number]; ok {
+		return stateError(op, ErrInterfaceClaimed, "interface %d (alternate %d)", number, claimedAlt)
+	}
+	return nil
+}
+
+func (d *Device) checkReleaseInterface(number, alt uint8) error {
+	const op = OpReleaseInterface
+	if err := d.checkOpen(op); err != nil {
+		return err
+	}
+	claimedAlt, ok := d.claimed[number]
+	if !ok {
+		return stateError(op, ErrInterfaceNotClaimed, "interface %d", number)
+	}
+	if claimedAlt != alt {
+		return stateError(op, ErrInterfaceNotClaimed, "interface %d is claimed with alternate %d, not %d", number, claimedAlt, alt)
+	}
+	return nil
+}
+
+func (d *Device) checkClearHalt(endpoint uint8) error {
+	const op = OpClearHalt
+	if _, err := d.checkConfigured(op); err != nil {
+		return err
+	}
+	if endpoint&0x0f == 0 {
+		return stateError(op, ErrDefaultEndpoint, "endpoint 0x%02x", endpoint)
+	}
+	if _, ok := d.findEndpoint(endpoint); !ok {
+		return stateError(op, ErrUnknownEndpoint, "endpoint 0x%02x", endpoint)
+	}
+	return nil
+}
+
+// findEndpoint looks up an endpoint in the current alternate setting of the
+// claimed interfaces of the active configuration.
+func (d *Device) findEndpoint(address uint8) (EndpointDescriptor, bool) {
+	cfg, ok := d.configuration(d.active)
+	if !ok {
+		return EndpointDescriptor{}, false
+	}
+	for number, alt := range d.claimed {
+		intf, ok := cfg.Interface(number, alt)
+		if !ok {
+			continue
+		}
+		if ep, ok := intf.Endpoint(address); ok {
+			return ep, true
+		}
+	}
+	return EndpointDescriptor{}, false
+}
+
+func (d *Device) checkEndpoint(op string, address uint8, tt TransferType, dir Direction) error {
+	if _, err := d.checkConfigured(op); err != nil {
+		return err
+	}
+	if EndpointDirection(address) != dir {
+		return stateError(op, ErrWrongDirection, "endpoint 0x%02x is an %s endpoint", address, EndpointDirection(address))
+	}
+	ep, ok := d.findEndpoint(address)
+	if !ok {
+		return stateError(op, ErrUnknownEndpoint, "endpoint 0x%02x", address)
+	}
+	if ep.TransferType != tt {
+		return stateError(op, ErrWrongTransferType, "endpoint 0x%02x is %s", address, article(ep.TransferType))
+	}
+	return nil
+}
+
+func article(tt TransferType) string {
+	if tt == TransferInterrupt || tt == TransferIsochronous {
+		return fmt.Sprintf("an %s endpoint", tt)
+	}
+	return fmt.Sprintf("a %s endpoint", tt)
+}
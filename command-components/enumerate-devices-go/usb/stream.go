@@ -0,0 +1,163 @@
+package usb
+
+import "fmt"
+
+// StreamStatus is the outcome of a transfer on a bulk stream. The values
+// follow the WIT stream-transfer-status enum.
+type StreamStatus uint8
+
+const (
+	StreamCompleted StreamStatus = iota
+	// StreamCancelled is a transfer that was still pending when the last
+	// transfer of its batch completed.
+	StreamCancelled
+	StreamStalled
+)
+
+func (s StreamStatus) String() string {
+	switch s {
+	case StreamCompleted:
+		return "completed"
+	case StreamCancelled:
+		return "cancelled"
+	case StreamStalled:
+		return "stalled"
+	}
+	return fmt.Sprintf("StreamStatus(%d)", uint8(s))
+}
+
+// StreamTransfer is a bulk transfer on a stream of an endpoint, or on the
+// endpoint itself if StreamID is 0.
+type StreamTransfer struct {
+	Endpoint uint8
+	StreamID uint32
+	Data     []byte // the data to write to an OUT endpoint
+	Length   int    // the number of bytes to read from an IN endpoint
+}
+
+// StreamResult is the result of a StreamTransfer.
+type StreamResult struct {
+	Status StreamStatus
+	Data   []byte // the data read from an IN endpoint
+	Actual int    // the number of bytes transferred
+}
+
+// StreamHost is implemented by hosts that support the bulk streams of
+// SuperSpeed endpoints.
+type StreamHost interface {
+	AllocStreams(count uint32, endpoints []uint8) (uint32, error)
+	FreeStreams(endpoints []uint8) error
+	TransferStreams(transfers []StreamTransfer) ([]StreamResult, error)
+}
+
+// streamTransport returns the chain of the device as a StreamHost. The
+// interceptors are all built with Around, which forwards bulk streams.
+func (d *Device) streamTransport(op string) (StreamHost, error) {
+	if s, ok := d.transport.(StreamHost); ok {
+		return s, nil
+	}
+	return nil, &StateError{Op: op, Err: ErrNotSupported, Detail: "interceptors do not forward bulk streams"}
+}
+
+// AllocStreams allocates count streams on each of the bulk endpoints, which
+// must be in claimed interfaces of a SuperSpeed device. It returns the number
+// of streams allocated, which may be lower than count; their IDs are 1 to
+// that number.
+func (d *Device) AllocStreams(count uint32, endpoints ...uint8) (uint32, error) {
+	s, err := d.streamTransport(OpAllocStreams)
+	if err != nil {
+		return 0, err
+	}
+	return s.AllocStreams(count, endpoints)
+}
+
+// FreeStreams frees the streams of the endpoints.
+func (d *Device) FreeStreams(endpoints ...uint8) error {
+	s, err := d.streamTransport(OpFreeStreams)
+	if err != nil {
+		return err
+	}
+	return s.FreeStreams(endpoints)
+}
+
+// Streams returns the number of streams allocated on an endpoint.
+func (d *Device) Streams(endpoint uint8) uint32 {
+	return d.streams[endpoint]
+}
+
+// TransferStreams submits bulk transfers together and waits for the last one
+// to complete. The transfers still pending then are cancelled, and reported
+// with StreamCancelled. A protocol that answers a command either with data
+// and a status or with the status alone, such as UAS, submits both and puts
+// the status last.
+//
+// The transfers go through the interceptors of the device as a single
+// transfer-bulk-streams call, which is not retried.
+func (d *Device) TransferStreams(transfers ...StreamTransfer) ([]StreamResult, error) {
+	if len(transfers) == 0 {
+		return nil, nil
+	}
+	s, err := d.streamTransport(OpTransferStreams)
+	if err != nil {
+		return nil, err
+	}
+	results, err := s.TransferStreams(transfers)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != len(transfers) {
+		return nil, fmt.Errorf("usb: %s: %d results for %d transfers", OpTransferStreams, len(results), len(transfers))
+	}
+	return results, nil
+}
+
+// checkStreams validates the calls on bulk streams.
+func (d *Device) checkStreams(c *Call) error {
+	if _, ok := d.host.(StreamHost); !ok {
+		return &StateError{Op: c.Op, Err: ErrNotSupported, Detail: "host has no bulk streams"}
+	}
+	switch c.Op {
+	case OpAllocStreams:
+		if speed := d.Speed(); speed < SpeedSuper {
+			return stateError(c.Op, ErrNotSupported, "bulk streams need SuperSpeed, the device runs at %s speed", speed)
+		}
+		if c.Streams == 0 || len(c.Endpoints) == 0 {
+			return fmt.Errorf("usb: %s: %d streams on %d endpoints", c.Op, c.Streams, len(c.Endpoints))
+		}
+		for _, ep := range c.Endpoints {
+			if err := d.checkEndpoint(c.Op, ep, TransferBulk, EndpointDirection(ep)); err != nil {
+				return err
+			}
+		}
+	case OpFreeStreams:
+		for _, ep := range c.Endpoints {
+			if d.streams[ep] == 0 {
+				return stateError(c.Op, ErrNoStreams, "endpoint 0x%02x", ep)
+			}
+		}
+	case OpTransferStreams:
+		for _, t := range c.Transfers {
+			if err := d.checkEndpoint(c.Op, t.Endpoint, TransferBulk, EndpointDirection(t.Endpoint)); err != nil {
+				return err
+			}
+			if t.StreamID > d.streams[t.Endpoint] {
+				return stateError(c.Op, ErrNoStreams, "stream %d of endpoint 0x%02x, %d allocated", t.StreamID, t.Endpoint, d.streams[t.Endpoint])
+			}
+		}
+	}
+	return nil
+}
+
+// dropStreams forgets the streams of the endpoints of an interface that is
+// released; the host frees them with the interface.
+func (d *Device) dropStreams(number, alt uint8) {
+	cfg, ok := d.configuration(d.active)
+	if !ok {
+		return
+	}
+	if intf, ok := cfg.Interface(number, alt); ok {
+		for _, ep := range intf.Endpoints {
+			delete(d.streams, ep.Address())
+		}
+	}
+}
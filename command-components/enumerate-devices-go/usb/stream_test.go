@@ -0,0 +1,190 @@
+package usb
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// streamHost adds bulk streams to a SuperSpeed fakeHost.
+type streamHost struct {
+	*fakeHost
+	max uint32 // the most streams the host allocates
+}
+
+func newStreamHost() *streamHost {
+	return &streamHost{fakeHost: newFakeHost(), max: 16}
+}
+
+func (h *streamHost) Speed() Speed { return SpeedSuper }
+
+func (h *streamHost) AllocStreams(count uint32, endpoints []uint8) (uint32, error) {
+	return min(count, h.max), h.record("alloc-streams(%d,%x)", count, endpoints)
+}
+
+func (h *streamHost) FreeStreams(endpoints []uint8) error {
+	return h.record("free-streams(%x)", endpoints)
+}
+
+func (h *streamHost) TransferStreams(transfers []StreamTransfer) ([]StreamResult, error) {
+	results := make([]StreamResult, len(transfers))
+	for i, t := range transfers {
+		if t.Endpoint&0x80 != 0 {
+			results[i].Data = h.data(t.Endpoint, t.Length)
+			results[i].Actual = len(results[i].Data)
+		} else {
+			results[i].Actual = len(t.Data)
+		}
+	}
+	return results, h.record("transfer-bulk-streams(%d)", len(transfers))
+}
+
+func TestStreams(t *testing.T) {
+	host := newStreamHost()
+	host.read = func(endpoint uint8, length int) []byte { return make([]byte, length) }
+	d := NewDevice(host)
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.AllocStreams(32, 0x81, 0x02); !errors.Is(err, ErrUnknownEndpoint) {
+		t.Fatalf("streams on an unclaimed interface: %v", err)
+	}
+	if err := d.ClaimInterface(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.AllocStreams(32, 0x83); !errors.Is(err, ErrWrongTransferType) {
+		t.Fatalf("streams on an interrupt endpoint: %v", err)
+	}
+	n, err := d.AllocStreams(32, 0x81, 0x02)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 16 || d.Streams(0x81) != 16 || d.Streams(0x02) != 16 {
+		t.Fatalf("%d streams allocated, %d and %d recorded", n, d.Streams(0x81), d.Streams(0x02))
+	}
+
+	results, err := d.TransferStreams(
+		StreamTransfer{Endpoint: 0x02, Data: make([]byte, 31)},
+		StreamTransfer{Endpoint: 0x81, StreamID: 16, Length: 13},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Actual != 31 || results[1].Actual != 13 || results[1].Status != StreamCompleted {
+		t.Fatalf("results %+v", results)
+	}
+	if _, err := d.TransferStreams(StreamTransfer{Endpoint: 0x81, StreamID: 17, Length: 13}); !errors.Is(err, ErrNoStreams) {
+		t.Fatalf("transfer on stream 17: %v", err)
+	}
+	if _, err := d.TransferStreams(StreamTransfer{Endpoint: 0x02, Length: 13}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.FreeStreams(0x81, 0x02); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.FreeStreams(0x81); !errors.Is(err, ErrNoStreams) {
+		t.Fatalf("freeing streams twice: %v", err)
+	}
+	want := "open claim-interface(0,0) alloc-streams(32,8102) transfer-bulk-streams(2) transfer-bulk-streams(1) free-streams(8102)"
+	if got := host.Calls(); got != want {
+		t.Fatalf("calls %q, want %q", got, want)
+	}
+}
+
+func TestStreamsReleased(t *testing.T) {
+	d := NewDevice(newStreamHost())
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ClaimInterface(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.AllocStreams(4, 0x81); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ReleaseInterface(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if d.Streams(0x81) != 0 {
+		t.Fatalf("%d streams after releasing the interface", d.Streams(0x81))
+	}
+}
+
+// highSpeedStreamHost is a stream host whose device runs at high speed.
+type highSpeedStreamHost struct{ *streamHost }
+
+func (h highSpeedStreamHost) Speed() Speed { return SpeedHigh }
+
+func TestStreamsNotSupported(t *testing.T) {
+	for name, host := range map[string]Host{
+		"no streams": newFakeHost(),
+		"high speed": highSpeedStreamHost{newStreamHost()},
+	} {
+		d := NewDevice(host)
+		if err := d.Open(); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.ClaimInterface(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.AllocStreams(4, 0x81); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestStreamsIntercepted(t *testing.T) {
+	var buf bytes.Buffer
+	var log []string
+	host := newStreamHost()
+	host.read = func(endpoint uint8, length int) []byte { return make([]byte, length) }
+	d := NewDevice(host, recorder("driver", &log))
+	metrics := d.EnableMetrics()
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ClaimInterface(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.AllocStreams(4, 0x81, 0x02); err != nil {
+		t.Fatal(err)
+	}
+	write := StreamTransfer{Endpoint: 0x02, StreamID: 1, Data: make([]byte, 31)}
+	read := StreamTransfer{Endpoint: 0x81, StreamID: 1, Length: 13}
+
+	d.SetSafety(SafetyOptions{Mode: SafetyReadOnly})
+	if _, err := d.TransferStreams(write, read); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("read-only write: %v", err)
+	}
+	if _, err := d.TransferStreams(read); err != nil {
+		t.Fatalf("read-only read: %v", err)
+	}
+
+	d.SetSafety(SafetyOptions{Mode: SafetyDryRun, Logger: testLogger(&buf, slog.LevelInfo)})
+	results, err := d.TransferStreams(write, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Actual != 31 || results[1].Status != StreamCancelled {
+		t.Fatalf("dry-run results %+v", results)
+	}
+	want := `level=INFO msg="usb dry-run transfer-bulk-streams" device.id=1234:5678 device.serial=0001 op=transfer-bulk-streams transfers.0=0x02/1:31 transfers.1=0x81/1:13`
+	if got := lines(&buf); len(got) != 1 || got[0] != want {
+		t.Fatalf("dry-run log:\n%s", buf.String())
+	}
+
+	if got := host.Calls(); got != "open claim-interface(0,0) alloc-streams(4,8102) transfer-bulk-streams(1)" {
+		t.Fatalf("host calls %q", got)
+	}
+	if got := strings.Join(log, " "); got != "driver:open driver:claim-interface driver:alloc-streams driver:transfer-bulk-streams driver:transfer-bulk-streams driver:transfer-bulk-streams" {
+		t.Fatalf("interceptor calls %q", got)
+	}
+	// The metrics count every transfer of a batch on its endpoint.
+	snap := metrics.Snapshot()
+	if snap.Total.Transfers != 5 || snap.Total.Bytes != 13+31 {
+		t.Fatalf("metrics %d transfers, %d bytes", snap.Total.Transfers, snap.Total.Bytes)
+	}
+}
@@ -0,0 +1,76 @@
+package usb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+)
+
+// LanguageEnglishUS is the language ID used by ReadString if the device
+// does not report any.
+const LanguageEnglishUS uint16 = 0x0409
+
+// ReadString fetches string descriptor index in the device's first
+// language. Index 0 is not a string but the list of languages; use
+// Languages for it. Devices with QuirkNoStrings are not asked at all.
+func (d *Device) ReadString(index uint8) (string, error) {
+	if index == 0 {
+		return "", nil
+	}
+	if d.quirks.Has(QuirkNoStrings) {
+		return "", stateError(OpReadControl, ErrNotSupported, "device has quirk %s", QuirkNoStrings)
+	}
+	langs, err := d.Languages()
+	if err != nil {
+		return "", err
+	}
+	lang := LanguageEnglishUS
+	if len(langs) > 0 {
+		lang = langs[0]
+	}
+	desc, err := d.readStringDescriptor(index, lang)
+	if err != nil {
+		return "", err
+	}
+	units := make([]uint16, len(desc)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(desc[2*i:])
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+// Languages returns the language IDs the device has strings in, from string
+// descriptor 0.
+func (d *Device) Languages() ([]uint16, error) {
+	if d.quirks.Has(QuirkNoStrings) {
+		return nil, stateError(OpReadControl, ErrNotSupported, "device has quirk %s", QuirkNoStrings)
+	}
+	desc, err := d.readStringDescriptor(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	langs := make([]uint16, len(desc)/2)
+	for i := range langs {
+		langs[i] = binary.LittleEndian.Uint16(desc[2*i:])
+	}
+	return langs, nil
+}
+
+// readStringDescriptor returns the payload of a string descriptor, without
+// the bLength and bDescriptorType header.
+func (d *Device) readStringDescriptor(index uint8, lang uint16) ([]byte, error) {
+	data, err := d.ReadControl(ControlSetup{
+		Type:      ControlStandard,
+		Recipient: RecipientDevice,
+		Request:   RequestGetDescriptor,
+		Value:     uint16(DescriptorTypeString)<<8 | uint16(index),
+		Index:     lang,
+	}, 255)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2 || data[1] != DescriptorTypeString || int(data[0]) > len(data) || data[0] < 2 {
+		return nil, fmt.Errorf("usb: malformed string descriptor %d", index)
+	}
+	return data[2:data[0]], nil
+}
@@ -0,0 +1,44 @@
+package usb
+
+import "testing"
+
+func TestReadString(t *testing.T) {
+	responses := [][]byte{
+		{4, DescriptorTypeString, 0x07, 0x04}, // de-DE
+		{10, DescriptorTypeString, 'U', 0, 'S', 0, 'B', 0, 0x3d, 0xd8, 0, 0},
+		{2, DescriptorTypeString},
+		{2, DescriptorTypeDevice},
+	}
+	host := newFakeHost()
+	host.read = func(uint8, int) []byte {
+		r := responses[0]
+		responses = responses[1:]
+		return r
+	}
+	var setups []ControlSetup
+	d := NewDevice(host, Around(func(c *Call, invoke func() error) error {
+		setups = append(setups, c.Setup)
+		return invoke()
+	}))
+	d.Open()
+
+	s, err := d.ReadString(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "USB�" {
+		t.Errorf("string %q", s)
+	}
+	if len(setups) != 3 || setups[1].Value != 0x0300 || setups[2].Value != 0x0302 || setups[2].Index != 0x0407 {
+		t.Errorf("setups %+v", setups)
+	}
+
+	// No languages: fall back to US English, then reject a descriptor of
+	// the wrong type.
+	if _, err := d.ReadString(1); err == nil {
+		t.Error("malformed descriptor accepted")
+	}
+	if setups[4].Index != LanguageEnglishUS {
+		t.Errorf("language %#04x", setups[4].Index)
+	}
+}
@@ -0,0 +1,368 @@
+package usb
+
+// Transport is the set of operations that act on a device: everything of
+// the usb-device resource except the descriptor queries. Host, Device and
+// every interceptor implement it.
+type Transport interface {
+	Open() error
+	Close() error
+	Reset() error
+
+	SelectConfiguration(number uint8) error
+	ClaimInterface(number, alt uint8) error
+	ReleaseInterface(number, alt uint8) error
+	ClearHalt(endpoint uint8) error
+
+	ReadControl(setup ControlSetup, length uint16) ([]byte, error)
+	WriteControl(setup ControlSetup, data []byte) (int, error)
+
+	ReadInterrupt(endpoint uint8, length int) ([]byte, error)
+	WriteInterrupt(endpoint uint8, data []byte) (int, error)
+
+	ReadBulk(endpoint uint8, length int) ([]byte, error)
+	WriteBulk(endpoint uint8, data []byte) (int, error)
+
+	ReadIsochronous(endpoint uint8) ([]byte, error)
+	WriteIsochronous(endpoint uint8, data []byte) (int, error)
+}
+
+// Interceptor wraps a Transport to add behaviour to some or all of its calls.
+// The simplest interceptors embed next in a struct and override the methods
+// they care about; Around covers the ones that treat every call alike.
+type Interceptor func(next Transport) Transport
+
+// Chain wraps t in interceptors. The first interceptor is the outermost one,
+// so it sees a call first and its result last.
+func Chain(t Transport, interceptors ...Interceptor) Transport {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		t = interceptors[i](t)
+	}
+	return t
+}
+
+// Operation names, as used in Call.Op and StateError.Op. They are the names
+// of the corresponding WIT functions.
+const (
+	OpOpen                = "open"
+	OpClose               = "close"
+	OpReset               = "reset"
+	OpSelectConfiguration = "select-configuration"
+	OpClaimInterface      = "claim-interface"
+	OpReleaseInterface    = "release-interface"
+	OpClearHalt           = "clear-halt"
+	OpReadControl         = "read-control"
+	OpWriteControl        = "write-control"
+	OpReadInterrupt       = "read-interrupt"
+	OpWriteInterrupt      = "write-interrupt"
+	OpReadBulk            = "read-bulk"
+	OpWriteBulk           = "write-bulk"
+	OpReadIsochronous     = "read-isochronous"
+	OpWriteIsochronous    = "write-isochronous"
+	OpAllocStreams        = "alloc-streams"
+	OpFreeStreams         = "free-streams"
+	OpTransferStreams     = "transfer-bulk-streams"
+
+	OpSuspend                  = "suspend"
+	OpResume                   = "resume"
+	OpLinkPowerStateEnabled    = "link-power-state-enabled"
+	OpSetLinkPowerStateEnabled = "set-link-power-state-enabled"
+)
+
+// Call describes a single Transport call. Interceptors built with Around
+// may change the request fields before invoking the call; the result fields
+// are filled in by the invocation, or by the interceptor if it answers the
+// call itself.
+type Call struct {
+	Op string
+
+	Endpoint  uint8        // clear-halt and transfers; 0x00 or 0x80 for control
+	Setup     ControlSetup // control transfers
+	Number    uint8        // configuration or interface number
+	Alternate uint8        // claim-interface and release-interface
+	Length    int          // requested length of reads, len(Data) for writes
+
+	// Data holds the payload: the data to write before the call, the data
+	// read after it.
+	Data []byte
+	// Actual is the number of bytes transferred.
+	Actual int
+
+	// Bulk streams: the endpoints of alloc-streams and free-streams, the
+	// number of streams requested and then allocated, and the transfers of
+	// transfer-bulk-streams with their results.
+	Endpoints []uint8
+	Streams   uint32
+	Transfers []StreamTransfer
+	Results   []StreamResult
+
+	// Link power management: the state of link-power-state-enabled and
+	// set-link-power-state-enabled, and whether it is, or is to be, enabled.
+	State   LinkPowerState
+	Enabled bool
+}
+
+// IsTransfer reports whether the call is a control, interrupt, bulk or
+// isochronous transfer. Transfers on bulk streams are not: they come in
+// batches of both directions, and the protocols using them recover on their
+// own.
+func (c *Call) IsTransfer() bool {
+	_, ok := c.TransferType()
+	return ok
+}
+
+// TransferType returns the transfer type of a transfer call.
+func (c *Call) TransferType() (TransferType, bool) {
+	switch c.Op {
+	case OpReadControl, OpWriteControl:
+		return TransferControl, true
+	case OpReadInterrupt, OpWriteInterrupt:
+		return TransferInterrupt, true
+	case OpReadBulk, OpWriteBulk:
+		return TransferBulk, true
+	case OpReadIsochronous, OpWriteIsochronous:
+		return TransferIsochronous, true
+	}
+	return 0, false
+}
+
+// Direction returns DirectionIn for reads and DirectionOut for everything else.
+func (c *Call) Direction() Direction {
+	switch c.Op {
+	case OpReadControl, OpReadInterrupt, OpReadBulk, OpReadIsochronous:
+		return DirectionIn
+	}
+	return DirectionOut
+}
+
+// WritesStreams reports whether a transfer-bulk-streams call writes to an
+// OUT endpoint.
+func (c *Call) WritesStreams() bool {
+	if c.Op != OpTransferStreams {
+		return false
+	}
+	for _, t := range c.Transfers {
+		if EndpointDirection(t.Endpoint) == DirectionOut {
+			return true
+		}
+	}
+	return false
+}
+
+// Around returns an Interceptor that runs fn for every call. fn receives the
+// call and a function that forwards it to the next Transport; it decides
+// whether, when and how often to invoke it.
+func Around(fn func(c *Call, invoke func() error) error) Interceptor {
+	return func(next Transport) Transport {
+		return &around{next: next, fn: fn}
+	}
+}
+
+type around struct {
+	next Transport
+	fn   func(c *Call, invoke func() error) error
+}
+
+func (t *around) Open() error {
+	return t.fn(&Call{Op: OpOpen}, t.next.Open)
+}
+
+func (t *around) Close() error {
+	return t.fn(&Call{Op: OpClose}, t.next.Close)
+}
+
+func (t *around) Reset() error {
+	return t.fn(&Call{Op: OpReset}, t.next.Reset)
+}
+
+func (t *around) SelectConfiguration(number uint8) error {
+	c := &Call{Op: OpSelectConfiguration, Number: number}
+	return t.fn(c, func() error { return t.next.SelectConfiguration(c.Number) })
+}
+
+func (t *around) ClaimInterface(number, alt uint8) error {
+	c := &Call{Op: OpClaimInterface, Number: number, Alternate: alt}
+	return t.fn(c, func() error { return t.next.ClaimInterface(c.Number, c.Alternate) })
+}
+
+func (t *around) ReleaseInterface(number, alt uint8) error {
+	c := &Call{Op: OpReleaseInterface, Number: number, Alternate: alt}
+	return t.fn(c, func() error { return t.next.ReleaseInterface(c.Number, c.Alternate) })
+}
+
+func (t *around) ClearHalt(endpoint uint8) error {
+	c := &Call{Op: OpClearHalt, Endpoint: endpoint}
+	return t.fn(c, func() error { return t.next.ClearHalt(c.Endpoint) })
+}
+
+func (t *around) read(c *Call, read func() ([]byte, error)) ([]byte, error) {
+	err := t.fn(c, func() (err error) {
+		c.Data, err = read()
+		c.Actual = len(c.Data)
+		return err
+	})
+	return c.Data, err
+}
+
+func (t *around) write(c *Call, write func() (int, error)) (int, error) {
+	err := t.fn(c, func() (err error) {
+		c.Actual, err = write()
+		return err
+	})
+	return c.Actual, err
+}
+
+func (t *around) ReadControl(setup ControlSetup, length uint16) ([]byte, error) {
+	c := &Call{Op: OpReadControl, Endpoint: 0x80, Setup: setup, Length: int(length)}
+	return t.read(c, func() ([]byte, error) { return t.next.ReadControl(c.Setup, uint16(c.Length)) })
+}
+
+func (t *around) WriteControl(setup ControlSetup, data []byte) (int, error) {
+	c := &Call{Op: OpWriteControl, Endpoint: 0x00, Setup: setup, Length: len(data), Data: data}
+	return t.write(c, func() (int, error) { return t.next.WriteControl(c.Setup, c.Data) })
+}
+
+func (t *around) ReadInterrupt(endpoint uint8, length int) ([]byte, error) {
+	c := &Call{Op: OpReadInterrupt, Endpoint: endpoint, Length: length}
+	return t.read(c, func() ([]byte, error) { return t.next.ReadInterrupt(c.Endpoint, c.Length) })
+}
+
+func (t *around) WriteInterrupt(endpoint uint8, data []byte) (int, error) {
+	c := &Call{Op: OpWriteInterrupt, Endpoint: endpoint, Length: len(data), Data: data}
+	return t.write(c, func() (int, error) { return t.next.WriteInterrupt(c.Endpoint, c.Data) })
+}
+
+func (t *around) ReadBulk(endpoint uint8, length int) ([]byte, error) {
+	c := &Call{Op: OpReadBulk, Endpoint: endpoint, Length: length}
+	return t.read(c, func() ([]byte, error) { return t.next.ReadBulk(c.Endpoint, c.Length) })
+}
+
+func (t *around) WriteBulk(endpoint uint8, data []byte) (int, error) {
+	c := &Call{Op: OpWriteBulk, Endpoint: endpoint, Length: len(data), Data: data}
+	return t.write(c, func() (int, error) { return t.next.WriteBulk(c.Endpoint, c.Data) })
+}
+
+func (t *around) ReadIsochronous(endpoint uint8) ([]byte, error) {
+	c := &Call{Op: OpReadIsochronous, Endpoint: endpoint}
+	return t.read(c, func() ([]byte, error) { return t.next.ReadIsochronous(c.Endpoint) })
+}
+
+func (t *around) WriteIsochronous(endpoint uint8, data []byte) (int, error) {
+	c := &Call{Op: OpWriteIsochronous, Endpoint: endpoint, Length: len(data), Data: data}
+	return t.write(c, func() (int, error) { return t.next.WriteIsochronous(c.Endpoint, c.Data) })
+}
+
+// The transports built with Around also forward the calls on bulk streams,
+// to a next Transport that implements StreamHost.
+var _ StreamHost = (*around)(nil)
+
+func (t *around) streamHost(op string) (StreamHost, error) {
+	if s, ok := t.next.(StreamHost); ok {
+		return s, nil
+	}
+	return nil, &StateError{Op: op, Err: ErrNotSupported, Detail: "transport has no bulk streams"}
+}
+
+func (t *around) AllocStreams(count uint32, endpoints []uint8) (uint32, error) {
+	s, err := t.streamHost(OpAllocStreams)
+	if err != nil {
+		return 0, err
+	}
+	c := &Call{Op: OpAllocStreams, Endpoints: endpoints, Streams: count}
+	err = t.fn(c, func() (err error) {
+		c.Streams, err = s.AllocStreams(c.Streams, c.Endpoints)
+		return err
+	})
+	return c.Streams, err
+}
+
+func (t *around) FreeStreams(endpoints []uint8) error {
+	s, err := t.streamHost(OpFreeStreams)
+	if err != nil {
+		return err
+	}
+	c := &Call{Op: OpFreeStreams, Endpoints: endpoints}
+	return t.fn(c, func() error { return s.FreeStreams(c.Endpoints) })
+}
+
+func (t *around) TransferStreams(transfers []StreamTransfer) ([]StreamResult, error) {
+	s, err := t.streamHost(OpTransferStreams)
+	if err != nil {
+		return nil, err
+	}
+	c := &Call{Op: OpTransferStreams, Transfers: transfers}
+	err = t.fn(c, func() (err error) {
+		c.Results, err = s.TransferStreams(c.Transfers)
+		c.Actual = 0
+		for _, r := range c.Results {
+			c.Actual += r.Actual
+		}
+		return err
+	})
+	return c.Results, err
+}
+
+// The transports built with Around also forward power management, to a next
+// Transport that implements PowerHost. Suspended and LinkPowerStateSupported
+// are queries of the host, which skip fn.
+var _ PowerHost = (*around)(nil)
+
+func (t *around) powerHost(op string) (PowerHost, error) {
+	if p, ok := t.next.(PowerHost); ok {
+		return p, nil
+	}
+	return nil, &StateError{Op: op, Err: ErrNotSupported, Detail: "transport has no power management"}
+}
+
+func (t *around) Suspend() error {
+	p, err := t.powerHost(OpSuspend)
+	if err != nil {
+		return err
+	}
+	return t.fn(&Call{Op: OpSuspend}, p.Suspend)
+}
+
+func (t *around) Resume() error {
+	p, err := t.powerHost(OpResume)
+	if err != nil {
+		return err
+	}
+	return t.fn(&Call{Op: OpResume}, p.Resume)
+}
+
+func (t *around) Suspended() (bool, error) {
+	p, err := t.powerHost("suspended")
+	if err != nil {
+		return false, err
+	}
+	return p.Suspended()
+}
+
+func (t *around) LinkPowerStateSupported(state LinkPowerState) (bool, error) {
+	p, err := t.powerHost("link-power-state-supported")
+	if err != nil {
+		return false, err
+	}
+	return p.LinkPowerStateSupported(state)
+}
+
+func (t *around) LinkPowerStateEnabled(state LinkPowerState) (bool, error) {
+	p, err := t.powerHost(OpLinkPowerStateEnabled)
+	if err != nil {
+		return false, err
+	}
+	c := &Call{Op: OpLinkPowerStateEnabled, State: state}
+	err = t.fn(c, func() (err error) {
+		c.Enabled, err = p.LinkPowerStateEnabled(c.State)
+		return err
+	})
+	return c.Enabled, err
+}
+
+func (t *around) SetLinkPowerStateEnabled(state LinkPowerState, enabled bool) error {
+	p, err := t.powerHost(OpSetLinkPowerStateEnabled)
+	if err != nil {
+		return err
+	}
+	c := &Call{Op: OpSetLinkPowerStateEnabled, State: state, Enabled: enabled}
+	return t.fn(c, func() error { return p.SetLinkPowerStateEnabled(c.State, c.Enabled) })
+}
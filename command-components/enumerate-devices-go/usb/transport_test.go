@@ -0,0 +1,150 @@
+package usb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// recorder returns an interceptor that appends "name:op" for every call.
+func recorder(name string, log *[]string) Interceptor {
+	return Around(func(c *Call, invoke func() error) error {
+		*log = append(*log, name+":"+c.Op)
+		return invoke()
+	})
+}
+
+func TestChainOrder(t *testing.T) {
+	var log []string
+	host := newFakeHost()
+	tr := Chain(host, recorder("outer", &log), recorder("inner", &log))
+	if err := tr.Open(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"outer:open", "inner:open"}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("got %v, want %v", log, want)
+	}
+	if host.Calls() != "open" {
+		t.Fatalf("host calls %q", host.Calls())
+	}
+}
+
+func TestAroundDescribesCalls(t *testing.T) {
+	var calls []Call
+	tr := Chain(newFakeHost(), Around(func(c *Call, invoke func() error) error {
+		err := invoke()
+		calls = append(calls, *c)
+		return err
+	}))
+
+	setup := ControlSetup{Type: ControlClass, Recipient: RecipientInterface, Request: 0xfe}
+	tr.Open()
+	tr.ClaimInterface(1, 2)
+	tr.ReadControl(setup, 1)
+	tr.WriteBulk(0x02, []byte{1, 2, 3})
+	tr.ReadBulk(0x81, 13)
+
+	want := []Call{
+		{Op: OpOpen},
+		{Op: OpClaimInterface, Number: 1, Alternate: 2},
+		{Op: OpReadControl, Endpoint: 0x80, Setup: setup, Length: 1, Data: []byte{0}, Actual: 1},
+		{Op: OpWriteBulk, Endpoint: 0x02, Length: 3, Data: []byte{1, 2, 3}, Actual: 3},
+		{Op: OpReadBulk, Endpoint: 0x81, Length: 13, Data: make([]byte, 13), Actual: 13},
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("got  %+v\nwant %+v", calls, want)
+	}
+	if dir := want[4].Direction(); dir != DirectionIn {
+		t.Fatalf("read-bulk direction %v", dir)
+	}
+	if tt, ok := want[3].TransferType(); !ok || tt != TransferBulk {
+		t.Fatalf("write-bulk transfer type %v, %v", tt, ok)
+	}
+	if want[1].IsTransfer() {
+		t.Fatal("claim-interface is not a transfer")
+	}
+}
+
+func TestAroundCanAnswerAndRewriteCalls(t *testing.T) {
+	host := newFakeHost()
+	tr := Chain(host, Around(func(c *Call, invoke func() error) error {
+		switch c.Op {
+		case OpWriteBulk:
+			// Answer writes without reaching the host.
+			c.Actual = len(c.Data)
+			return nil
+		case OpReadBulk:
+			// Round reads up to a multiple of 512.
+			c.Length = (c.Length + 511) / 512 * 512
+		}
+		return invoke()
+	}))
+
+	n, err := tr.WriteBulk(0x02, []byte{1, 2})
+	if n != 2 || err != nil {
+		t.Fatalf("write = %d, %v", n, err)
+	}
+	data, err := tr.ReadBulk(0x81, 13)
+	if len(data) != 512 || err != nil {
+		t.Fatalf("read = %d bytes, %v", len(data), err)
+	}
+	if host.Calls() != "read-bulk(0x81)" {
+		t.Fatalf("host calls %q", host.Calls())
+	}
+}
+
+func TestDeviceInterceptors(t *testing.T) {
+	var log []string
+	host := newFakeHost()
+	host.fault = script(map[string][]error{"read-bulk(0x81)": {ErrStall}})
+	d := NewDevice(host, recorder("a", &log))
+	d.Use(recorder("b", &log))
+	d.SetDefaultRetryPolicy(DefaultRetryPolicy)
+
+	// Rejected calls never reach the interceptors.
+	if _, err := d.ReadBulk(0x81, 13); !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("got %v", err)
+	}
+	if len(log) != 0 {
+		t.Fatalf("interceptors saw a rejected call: %v", log)
+	}
+
+	d.Open()
+	d.ClaimInterface(0, 0)
+	log = nil
+	if _, err := d.ReadBulk(0x81, 13); err != nil {
+		t.Fatal(err)
+	}
+	// Interceptors see every attempt and the recovery in between.
+	want := []string{
+		"a:read-bulk", "b:read-bulk",
+		"a:clear-halt", "b:clear-halt",
+		"a:read-bulk", "b:read-bulk",
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("got %v, want %v", log, want)
+	}
+}
+
+func TestInterceptorByEmbedding(t *testing.T) {
+	host := newFakeHost()
+	d := NewDevice(host, func(next Transport) Transport {
+		return &failResets{next}
+	})
+	d.Open()
+	if err := d.Reset(); err == nil || err.Error() != "resets disabled" {
+		t.Fatalf("got %v", err)
+	}
+	if host.Calls() != "open" {
+		t.Fatalf("host calls %q", host.Calls())
+	}
+}
+
+type failResets struct {
+	Transport
+}
+
+func (failResets) Reset() error {
+	return errors.New("resets disabled")
+}
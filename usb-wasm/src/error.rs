@@ -6,4 +6,10 @@ pub enum UsbWasmError {
     RusbError(#[from] rusb::Error),
     #[error("device not opened")]
     DeviceNotOpened,
+    #[error("device opened")]
+    DeviceOpened,
+    #[error("operation not supported")]
+    NotSupported,
+    #[error("io error")]
+    Io(#[from] std::io::Error),
 }
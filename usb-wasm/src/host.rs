@@ -338,6 +338,122 @@ impl<T: WasiView> HostUsbDevice for T {
         let bytes_written = device.control_transfer_out(setup, &data).unwrap();
         Ok(bytes_written as _)
     }
+
+    fn bus_number(
+        &mut self,
+        rep: wasmtime::component::Resource<UsbDevice>,
+    ) -> wasmtime::Result<u8> {
+        let device = self.table().get(&rep)?;
+        Ok(device.bus_number())
+    }
+
+    fn port_numbers(
+        &mut self,
+        rep: wasmtime::component::Resource<UsbDevice>,
+    ) -> wasmtime::Result<Vec<u8>> {
+        let device = self.table().get(&rep)?;
+        Ok(device.port_numbers()?)
+    }
+
+    fn suspend(&mut self, rep: wasmtime::component::Resource<UsbDevice>) -> wasmtime::Result<()> {
+        let device = self.table().get_mut(&rep)?;
+        device.suspend()?;
+        Ok(())
+    }
+
+    fn resume(&mut self, rep: wasmtime::component::Resource<UsbDevice>) -> wasmtime::Result<()> {
+        let device = self.table().get_mut(&rep)?;
+        device.resume()?;
+        Ok(())
+    }
+
+    fn suspended(
+        &mut self,
+        rep: wasmtime::component::Resource<UsbDevice>,
+    ) -> wasmtime::Result<bool> {
+        let device = self.table().get(&rep)?;
+        Ok(device.suspended()?)
+    }
+
+    fn link_power_state_supported(
+        &mut self,
+        rep: wasmtime::component::Resource<UsbDevice>,
+        state: LinkPowerState,
+    ) -> wasmtime::Result<bool> {
+        let device = self.table().get(&rep)?;
+        Ok(device.link_power_state_supported(state)?)
+    }
+
+    fn link_power_state_enabled(
+        &mut self,
+        rep: wasmtime::component::Resource<UsbDevice>,
+        state: LinkPowerState,
+    ) -> wasmtime::Result<bool> {
+        let device = self.table().get(&rep)?;
+        Ok(device.link_power_state_enabled(state)?)
+    }
+
+    fn set_link_power_state_enabled(
+        &mut self,
+        rep: wasmtime::component::Resource<UsbDevice>,
+        state: LinkPowerState,
+        enabled: bool,
+    ) -> wasmtime::Result<()> {
+        let device = self.table().get_mut(&rep)?;
+        device.set_link_power_state_enabled(state, enabled)?;
+        Ok(())
+    }
+
+    fn alloc_streams(
+        &mut self,
+        rep: wasmtime::component::Resource<UsbDevice>,
+        num_streams: u32,
+        endpoints: Vec<wasmtime::component::Resource<UsbEndpoint>>,
+    ) -> wasmtime::Result<u32> {
+        let table = self.table();
+        let addresses = endpoints
+            .iter()
+            .map(|endpoint| Ok(table.get(endpoint)?.get_endpoint_number()))
+            .collect::<wasmtime::Result<Vec<_>>>()?;
+        let device = table.get_mut(&rep)?;
+        Ok(device.alloc_streams(num_streams, &addresses)?)
+    }
+
+    fn free_streams(
+        &mut self,
+        rep: wasmtime::component::Resource<UsbDevice>,
+        endpoints: Vec<wasmtime::component::Resource<UsbEndpoint>>,
+    ) -> wasmtime::Result<()> {
+        let table = self.table();
+        let addresses = endpoints
+            .iter()
+            .map(|endpoint| Ok(table.get(endpoint)?.get_endpoint_number()))
+            .collect::<wasmtime::Result<Vec<_>>>()?;
+        let device = table.get_mut(&rep)?;
+        device.free_streams(&addresses)?;
+        Ok(())
+    }
+
+    fn transfer_bulk_streams(
+        &mut self,
+        rep: wasmtime::component::Resource<UsbDevice>,
+        transfers: Vec<StreamTransfer>,
+    ) -> wasmtime::Result<Vec<StreamTransferResult>> {
+        let table = self.table();
+        let transfers = transfers
+            .into_iter()
+            .map(|transfer| {
+                Ok(crate::StreamTransfer {
+                    endpoint: table.get(&transfer.endpoint)?.get_endpoint_number(),
+                    stream_id: transfer.stream_id,
+                    data: transfer.data,
+                    length: transfer.length as usize,
+                })
+            })
+            .collect::<wasmtime::Result<Vec<_>>>()?;
+        let device = table.get_mut(&rep)?;
+        Ok(device.bulk_stream_transfers(&transfers)?)
+    }
 }
 
 impl<T: WasiView> HostUsbConfiguration for T {
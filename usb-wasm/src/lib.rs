@@ -12,12 +12,24 @@ wasmtime::component::bindgen!({
 
 use error::UsbWasmError;
 use rusb::{
-    constants::LIBUSB_TRANSFER_TYPE_ISOCHRONOUS,
-    ffi::{libusb_alloc_transfer, libusb_handle_events_completed, libusb_submit_transfer},
+    constants::{
+        LIBUSB_TRANSFER_CANCELLED, LIBUSB_TRANSFER_COMPLETED, LIBUSB_TRANSFER_NO_DEVICE,
+        LIBUSB_TRANSFER_OVERFLOW, LIBUSB_TRANSFER_STALL, LIBUSB_TRANSFER_TIMED_OUT,
+        LIBUSB_TRANSFER_TYPE_BULK, LIBUSB_TRANSFER_TYPE_BULK_STREAM,
+        LIBUSB_TRANSFER_TYPE_ISOCHRONOUS,
+    },
+    ffi::{
+        libusb_alloc_streams, libusb_alloc_transfer, libusb_cancel_transfer, libusb_context,
+        libusb_free_streams, libusb_free_transfer, libusb_handle_events_completed,
+        libusb_submit_transfer, libusb_transfer, libusb_transfer_set_stream_id,
+    },
     GlobalContext, Recipient, RequestType, Speed, UsbContext,
 };
-use std::{error::Error, sync::Arc, time::Duration};
-use wadu436::usb::{self, types::Direction};
+use std::{error::Error, fs, io, path::PathBuf, sync::Arc, time::Duration};
+use wadu436::usb::{
+    self,
+    types::{Direction, LinkPowerState, StreamTransferResult, StreamTransferStatus},
+};
 
 use wasmtime_wasi::WasiView;
 
@@ -26,11 +38,17 @@ mod host;
 
 const TIMEOUT: Duration = Duration::from_secs(20);
 
+// Feature selectors for SET_FEATURE/CLEAR_FEATURE (USB 3.2 spec, table 9-7)
+const U1_ENABLE: u16 = 48;
+const U2_ENABLE: u16 = 49;
+
 pub struct UsbDevice {
     device: rusb::Device<rusb::GlobalContext>,
     handle: Option<rusb::DeviceHandle<GlobalContext>>,
     language: Option<rusb::Language>,
     descriptor: usb::device::DeviceDescriptor,
+    // The autosuspend delay that suspend replaced, restored by resume.
+    autosuspend_delay: Option<String>,
 }
 
 pub struct ControlSetup {
@@ -66,6 +84,23 @@ extern "system" fn libusb_transfer_cb(transfer: *mut rusb::ffi::libusb_transfer)
     }
 }
 
+// Runs the event loop until the transfer whose user data is completed has finished.
+unsafe fn wait_for_transfer(context: *mut libusb_context, completed: *mut i32) -> i32 {
+    let mut err = 0;
+    while *completed == 0 && err == 0 {
+        err = libusb_handle_events_completed(context, completed);
+    }
+    err
+}
+
+// A bulk transfer on a stream of an endpoint, or on the endpoint itself if stream_id is 0.
+pub struct StreamTransfer {
+    pub endpoint: u8,
+    pub stream_id: u32,
+    pub data: Vec<u8>, // written to an OUT endpoint
+    pub length: usize, // read from an IN endpoint
+}
+
 impl UsbDevice {
     pub fn enumerate() -> Result<Vec<Self>, UsbWasmError> {
         let devices = rusb::devices()?;
@@ -134,6 +169,7 @@ impl UsbDevice {
                 handle: None,
                 language,
                 descriptor,
+                autosuspend_delay: None,
             })
         }
 
@@ -212,7 +248,15 @@ impl UsbDevice {
 
     pub fn reset(&mut self) -> Result<(), UsbWasmError> {
         if let Some(handle) = &mut self.handle {
-            Ok(handle.reset()?)
+            match handle.reset() {
+                // libusb reports NOT_FOUND if the device re-enumerated during the reset. The handle points at nothing
+                // then, so it is closed, which the guest sees through opened instead of a trap.
+                Err(rusb::Error::NotFound | rusb::Error::NoDevice) => {
+                    self.handle = None;
+                    Ok(())
+                }
+                result => Ok(result?),
+            }
         } else {
             Err(rusb::Error::InvalidParam.into())
         }
@@ -471,6 +515,190 @@ impl UsbDevice {
         }
     }
 
+    pub fn alloc_streams(
+        &mut self,
+        num_streams: u32,
+        endpoints: &[u8],
+    ) -> Result<u32, UsbWasmError> {
+        if let Some(handle) = &mut self.handle {
+            let mut endpoints = endpoints.to_vec();
+            let ret = unsafe {
+                libusb_alloc_streams(
+                    handle.as_raw(),
+                    num_streams,
+                    endpoints.as_mut_ptr(),
+                    endpoints.len() as _,
+                )
+            };
+            if ret < 0 {
+                return Err(error_from_libusb(ret).into());
+            }
+            Ok(ret as u32)
+        } else {
+            Err(UsbWasmError::DeviceNotOpened)
+        }
+    }
+
+    pub fn free_streams(&mut self, endpoints: &[u8]) -> Result<(), UsbWasmError> {
+        if let Some(handle) = &mut self.handle {
+            let mut endpoints = endpoints.to_vec();
+            let ret = unsafe {
+                libusb_free_streams(
+                    handle.as_raw(),
+                    endpoints.as_mut_ptr(),
+                    endpoints.len() as _,
+                )
+            };
+            if ret < 0 {
+                return Err(error_from_libusb(ret).into());
+            }
+            Ok(())
+        } else {
+            Err(UsbWasmError::DeviceNotOpened)
+        }
+    }
+
+    // Submits the transfers together and waits for the last one to complete, which for UAS is
+    // the status of a command. The transfers still pending then are cancelled: a command that
+    // fails has no data stage, and its data transfer would otherwise never complete.
+    pub fn bulk_stream_transfers(
+        &mut self,
+        transfers: &[StreamTransfer],
+    ) -> Result<Vec<StreamTransferResult>, UsbWasmError> {
+        let Some(handle) = &mut self.handle else {
+            return Err(UsbWasmError::DeviceNotOpened);
+        };
+        if transfers.is_empty() {
+            return Ok(Vec::new());
+        }
+        let context = handle.context().as_raw();
+
+        // The buffers and completion flags must stay in place until every transfer has finished.
+        let mut buffers: Vec<Vec<u8>> = transfers
+            .iter()
+            .map(|t| {
+                if t.endpoint & 0x80 != 0 {
+                    vec![0; t.length]
+                } else {
+                    t.data.clone()
+                }
+            })
+            .collect();
+        let mut completed = vec![0_i32; transfers.len()];
+        let completed_ptr = completed.as_mut_ptr();
+
+        let mut raw: Vec<*mut libusb_transfer> = Vec::with_capacity(transfers.len());
+        let mut err = 0;
+        for (i, t) in transfers.iter().enumerate() {
+            let transfer = unsafe { libusb_alloc_transfer(0) };
+            if transfer.is_null() {
+                err = rusb::ffi::constants::LIBUSB_ERROR_NO_MEM;
+                break;
+            }
+            let transfer_ref = unsafe { &mut *transfer };
+            transfer_ref.dev_handle = handle.as_raw();
+            transfer_ref.endpoint = t.endpoint;
+            transfer_ref.transfer_type = if t.stream_id == 0 {
+                LIBUSB_TRANSFER_TYPE_BULK
+            } else {
+                LIBUSB_TRANSFER_TYPE_BULK_STREAM
+            };
+            transfer_ref.timeout = TIMEOUT.as_millis() as _;
+            transfer_ref.buffer = buffers[i].as_mut_ptr();
+            transfer_ref.length = buffers[i].len() as _;
+            transfer_ref.user_data = unsafe { completed_ptr.add(i) } as *mut _;
+            transfer_ref.callback = libusb_transfer_cb;
+            if t.stream_id != 0 {
+                unsafe { libusb_transfer_set_stream_id(transfer, t.stream_id) };
+            }
+            raw.push(transfer);
+        }
+
+        // Transfers that were not submitted count as completed, so that nothing waits for them.
+        let mut submitted = 0;
+        if err == 0 {
+            for &transfer in &raw {
+                err = unsafe { libusb_submit_transfer(transfer) };
+                if err != 0 {
+                    break;
+                }
+                submitted += 1;
+            }
+        }
+        for i in submitted..transfers.len() {
+            unsafe { *completed_ptr.add(i) = 1 };
+        }
+
+        if err == 0 {
+            err = unsafe { wait_for_transfer(context, completed_ptr.add(transfers.len() - 1)) };
+        }
+        for (i, &transfer) in raw.iter().enumerate().take(submitted) {
+            if unsafe { *completed_ptr.add(i) } == 0 {
+                unsafe { libusb_cancel_transfer(transfer) };
+            }
+        }
+        // A failed wait leaves the transfer in flight, with libusb still owning it and the
+        // buffers, so keep waiting until it has completed.
+        for i in 0..submitted {
+            while unsafe { *completed_ptr.add(i) } == 0 {
+                let ret = unsafe { wait_for_transfer(context, completed_ptr.add(i)) };
+                if err == 0 {
+                    err = ret;
+                }
+            }
+        }
+
+        let mut results = Vec::with_capacity(transfers.len());
+        if err == 0 {
+            for (i, &transfer) in raw.iter().enumerate() {
+                let transfer_ref = unsafe { &*transfer };
+                let actual = transfer_ref.actual_length as usize;
+                let status = match transfer_ref.status {
+                    LIBUSB_TRANSFER_COMPLETED => StreamTransferStatus::Completed,
+                    LIBUSB_TRANSFER_CANCELLED => StreamTransferStatus::Cancelled,
+                    LIBUSB_TRANSFER_STALL => StreamTransferStatus::Stalled,
+                    LIBUSB_TRANSFER_TIMED_OUT => {
+                        err = rusb::ffi::constants::LIBUSB_ERROR_TIMEOUT;
+                        break;
+                    }
+                    LIBUSB_TRANSFER_NO_DEVICE => {
+                        err = rusb::ffi::constants::LIBUSB_ERROR_NO_DEVICE;
+                        break;
+                    }
+                    LIBUSB_TRANSFER_OVERFLOW => {
+                        err = rusb::ffi::constants::LIBUSB_ERROR_OVERFLOW;
+                        break;
+                    }
+                    _ => {
+                        err = rusb::ffi::constants::LIBUSB_ERROR_IO;
+                        break;
+                    }
+                };
+                let data = if transfers[i].endpoint & 0x80 != 0 {
+                    buffers[i][..actual].to_vec()
+                } else {
+                    Vec::new()
+                };
+                results.push(StreamTransferResult {
+                    status,
+                    data,
+                    actual: actual as u64,
+                });
+            }
+        }
+
+        // Only completed transfers may be freed.
+        for (i, &transfer) in raw.iter().enumerate() {
+            if unsafe { *completed_ptr.add(i) } != 0 {
+                unsafe { libusb_free_transfer(transfer) };
+            }
+        }
+        if err != 0 {
+            return Err(error_from_libusb(err).into());
+        }
+        Ok(results)
+    }
+
     pub fn control_transfer_in(
         &mut self,
         setup: ControlSetup,
@@ -537,6 +765,124 @@ impl UsbDevice {
         }
     }
 
+    pub fn bus_number(&self) -> u8 {
+        self.device.bus_number()
+    }
+
+    pub fn port_numbers(&self) -> Result<Vec<u8>, UsbWasmError> {
+        Ok(self.device.port_numbers()?)
+    }
+
+    // Power management goes through the sysfs attributes of the device, as libusb has no API for it.
+    // See https://www.kernel.org/doc/html/latest/driver-api/usb/power-management.html
+    fn sysfs_power_path(&self) -> Result<PathBuf, UsbWasmError> {
+        let ports = self
+            .port_numbers()?
+            .iter()
+            .map(u8::to_string)
+            .collect::<Vec<_>>()
+            .join(".");
+        Ok(PathBuf::from(format!(
+            "/sys/bus/usb/devices/{}-{}/power",
+            self.bus_number(),
+            ports
+        )))
+    }
+
+    fn read_power_attribute(&self, name: &str) -> Result<Option<String>, UsbWasmError> {
+        match fs::read_to_string(self.sysfs_power_path()?.join(name)) {
+            Ok(value) => Ok(Some(value.trim().to_owned())),
+            Err(e) if e.kind() == io::ErrorKind::NotFound => Ok(None),
+            Err(e) => Err(e.into()),
+        }
+    }
+
+    fn write_power_attribute(&self, name: &str, value: &str) -> Result<(), UsbWasmError> {
+        Ok(fs::write(self.sysfs_power_path()?.join(name), value)?)
+    }
+
+    pub fn suspend(&mut self) -> Result<(), UsbWasmError> {
+        // The kernel only suspends devices that are not in use, and usbfs holds a runtime PM reference for every
+        // open handle, so the device has to be closed.
+        if self.handle.is_some() {
+            return Err(UsbWasmError::DeviceOpened);
+        }
+        if self.autosuspend_delay.is_none() {
+            self.autosuspend_delay = self.read_power_attribute("autosuspend_delay_ms")?;
+        }
+        self.write_power_attribute("autosuspend_delay_ms", "0")?;
+        self.write_power_attribute("control", "auto")
+    }
+
+    pub fn resume(&mut self) -> Result<(), UsbWasmError> {
+        if self.handle.is_some() {
+            return Err(UsbWasmError::DeviceOpened);
+        }
+        self.write_power_attribute("control", "on")?;
+        if let Some(delay) = self.autosuspend_delay.take() {
+            self.write_power_attribute("autosuspend_delay_ms", &delay)?;
+        }
+        Ok(())
+    }
+
+    pub fn suspended(&self) -> Result<bool, UsbWasmError> {
+        Ok(self.read_power_attribute("runtime_status")?.as_deref() == Some("suspended"))
+    }
+
+    fn link_power_attribute(state: LinkPowerState) -> &'static str {
+        match state {
+            LinkPowerState::Usb2Lpm => "usb2_hardware_lpm",
+            LinkPowerState::U1 => "usb3_hardware_lpm_u1",
+            LinkPowerState::U2 => "usb3_hardware_lpm_u2",
+        }
+    }
+
+    pub fn link_power_state_supported(&self, state: LinkPowerState) -> Result<bool, UsbWasmError> {
+        Ok(self
+            .read_power_attribute(Self::link_power_attribute(state))?
+            .is_some())
+    }
+
+    pub fn link_power_state_enabled(&self, state: LinkPowerState) -> Result<bool, UsbWasmError> {
+        match self.read_power_attribute(Self::link_power_attribute(state))? {
+            Some(value) => Ok(value == "enabled"),
+            None => Err(UsbWasmError::NotSupported),
+        }
+    }
+
+    pub fn set_link_power_state_enabled(
+        &mut self,
+        state: LinkPowerState,
+        enabled: bool,
+    ) -> Result<(), UsbWasmError> {
+        if !self.link_power_state_supported(state)? {
+            return Err(UsbWasmError::NotSupported);
+        }
+        let feature = match state {
+            // USB 2.0 LPM is negotiated by the host controller, which sysfs lets us switch on and off.
+            LinkPowerState::Usb2Lpm => {
+                return self
+                    .write_power_attribute("usb2_hardware_lpm", if enabled { "y" } else { "n" });
+            }
+            // The U1/U2 attributes are read-only, the device has to be told through SET_FEATURE/CLEAR_FEATURE.
+            LinkPowerState::U1 => U1_ENABLE,
+            LinkPowerState::U2 => U2_ENABLE,
+        };
+        let setup = ControlSetup {
+            request_type: RequestType::Standard,
+            request_recipient: Recipient::Device,
+            request: if enabled {
+                rusb::constants::LIBUSB_REQUEST_SET_FEATURE
+            } else {
+                rusb::constants::LIBUSB_REQUEST_CLEAR_FEATURE
+            },
+            value: feature,
+            index: 0,
+        };
+        self.control_transfer_out(setup, &[])?;
+        Ok(())
+    }
+
     pub fn get_configurations(&self) -> Vec<UsbConfiguration> {
         let mut configurations = Vec::new();
 